@@ -0,0 +1,106 @@
+package core
+
+import (
+	"encoding/hex"
+	"log"
+)
+
+// Balance returns the confirmed balance of a wallet address. Balances are
+// cached and kept current by ConnectBlock and DisconnectBlock; an empty or
+// invalidated cache is rebuilt with a single pass over the UTXO set
+func (ws *Wallets) Balance(address string, UTXOSet *UTXOSet) (int, error) {
+	address = CanonicalAddress(address)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.Wallets[address]; !ok {
+		return 0, ErrWalletNotFound
+	}
+	if ws.balances == nil {
+		ws.recountBalances(UTXOSet)
+	}
+
+	return ws.balances[address], nil
+}
+
+// InvalidateBalances drops the balance cache, the next Balance call recounts
+func (ws *Wallets) InvalidateBalances() {
+	ws.mu.Lock()
+	ws.balances = nil
+	ws.mu.Unlock()
+}
+
+// ConnectBlock updates the cached balances with a block added to the tip
+func (ws *Wallets) ConnectBlock(block *Block, bc *Blockchain) {
+	ws.applyBlock(block, bc, 1)
+}
+
+// DisconnectBlock reverts the cached balances for a block removed from the
+// tip. The transactions it spends from must still be in bc
+func (ws *Wallets) DisconnectBlock(block *Block, bc *Blockchain) {
+	ws.applyBlock(block, bc, -1)
+}
+
+func (ws *Wallets) applyBlock(block *Block, bc *Blockchain, sign int) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.balances == nil {
+		return
+	}
+	owners := ws.pubKeyHashOwners()
+
+	for _, tx := range block.Transactions {
+		for _, out := range tx.Vout {
+			if address, ok := owners[hex.EncodeToString(out.PubKeyHash)]; ok {
+				ws.balances[address] += sign * out.Value
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			address, ok := owners[hex.EncodeToString(HashPubKey(vin.PubKey))]
+			if !ok {
+				continue
+			}
+			prevTx, err := bc.FindTransaction(vin.Txid)
+			if err != nil || vin.Vout >= len(prevTx.Vout) {
+				log.Printf("balance cache: can't find output %x:%d, recounting", vin.Txid, vin.Vout)
+				ws.balances = nil
+				return
+			}
+			ws.balances[address] -= sign * prevTx.Vout[vin.Vout].Value
+		}
+	}
+}
+
+// recountBalances rebuilds the balance cache from the UTXO set. The caller
+// holds the write lock
+func (ws *Wallets) recountBalances(UTXOSet *UTXOSet) {
+	owners := ws.pubKeyHashOwners()
+	pubKeyHashes := make([][]byte, 0, len(owners))
+	for pubKeyHash := range owners {
+		decoded, _ := hex.DecodeString(pubKeyHash)
+		pubKeyHashes = append(pubKeyHashes, decoded)
+	}
+
+	balances, err := UTXOSet.GetBalances(pubKeyHashes)
+	if err != nil {
+		log.Panic(err)
+	}
+	ws.balances = make(map[string]int, len(owners))
+	for pubKeyHash, balance := range balances {
+		ws.balances[owners[pubKeyHash]] = int(balance)
+	}
+}
+
+// pubKeyHashOwners maps the hex public key hash of each wallet to its address
+func (ws *Wallets) pubKeyHashOwners() map[string]string {
+	owners := make(map[string]string, len(ws.Wallets))
+	for address, wallet := range ws.Wallets {
+		owners[hex.EncodeToString(HashPubKey(wallet.PublicKey))] = address
+	}
+
+	return owners
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bruteForceBalances sums the outputs of every wallet address in a freshly
+// reindexed UTXO set
+func bruteForceBalances(ws *Wallets, UTXOSet UTXOSet) map[string]int {
+	UTXOSet.Reindex()
+	balances := make(map[string]int)
+	for address, wallet := range ws.Wallets {
+		for _, out := range mustOutputs(UTXOSet.FindUTXO(HashPubKey(wallet.PublicKey))) {
+			balances[address] += out.Value
+		}
+	}
+
+	return balances
+}
+
+func cachedBalances(t *testing.T, ws *Wallets, UTXOSet UTXOSet) map[string]int {
+	balances := make(map[string]int)
+	for address := range ws.Wallets {
+		balance, err := ws.Balance(address, &UTXOSet)
+		assert.Nil(t, err)
+		if balance != 0 {
+			balances[address] = balance
+		}
+	}
+
+	return balances
+}
+
+func TestBalanceCacheConsistency(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws := &Wallets{Wallets: make(map[string]*Wallet)}
+		var addresses []string
+		for i := 0; i < 3; i++ {
+			wallet := NewWallet()
+			address := fmt.Sprintf("%s", wallet.GetAddress())
+			ws.Wallets[address] = wallet
+			addresses = append(addresses, address)
+		}
+		foreign := fmt.Sprintf("%s", NewWallet().GetAddress())
+
+		bc := newTestChain(addresses[0])
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		_, err := ws.Balance(foreign, &UTXOSet)
+		assert.Equal(t, ErrWalletNotFound, err)
+
+		r := rand.New(rand.NewSource(1))
+		var before map[string]int
+		var last *Block
+		for i := 0; i < 3; i++ {
+			// warm the cache, the following blocks update it incrementally
+			before = cachedBalances(t, ws, UTXOSet)
+
+			txs := []*Transaction{NewCoinbaseTX(append(addresses, foreign)[r.Intn(4)], "")}
+			from := addresses[r.Intn(3)]
+			if before[from] > 0 {
+				to := append(addresses, foreign)[r.Intn(4)]
+				if to != from {
+					wallet, _ := ws.GetWallet(from)
+					tx, err := NewUTXOTransaction(wallet, to, 1+r.Intn(before[from]), &UTXOSet, nil, 1)
+					assert.Nil(t, err)
+					txs = append(txs, tx)
+				}
+			}
+			last = mustMine(bc, txs)
+			ws.ConnectBlock(last, bc)
+
+			assert.Equal(t, bruteForceBalances(ws, UTXOSet), cachedBalances(t, ws, UTXOSet), "block %d", i)
+		}
+
+		ws.DisconnectBlock(last, bc)
+		assert.Equal(t, before, cachedBalances(t, ws, UTXOSet))
+
+		ws.InvalidateBalances()
+		assert.Equal(t, bruteForceBalances(ws, UTXOSet), cachedBalances(t, ws, UTXOSet))
+	})
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const bannedBucket = "banned"
+
+// BannedPeer is a peer the node refuses connections from until a time, by
+// its IP address or its node ID in hex
+type BannedPeer struct {
+	Address string    `json:"address"`
+	Until   time.Time `json:"banned_until"`
+	Reason  string    `json:"reason"`
+}
+
+// BanListFile returns the ban list of the node nodeID, kept apart from the
+// chain so the admin commands don't wait for the node to release it
+func BanListFile(nodeID string) string {
+	return DataPath(fmt.Sprintf("banlist_%s.db", nodeID))
+}
+
+// updateBanList calls f with the ban bucket of the node nodeID in a writable
+// transaction, the expired bans dropped
+func updateBanList(nodeID string, f func(b *bolt.Bucket) error) error {
+	db, err := openDB(BanListFile(nodeID), false, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bannedBucket))
+		if err != nil {
+			return err
+		}
+		var expired [][]byte
+		now := time.Now()
+		err = b.ForEach(func(k, v []byte) error {
+			ban, err := decodeBan(v)
+			if err != nil || !ban.Until.After(now) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return f(b)
+	})
+}
+
+func decodeBan(data []byte) (BannedPeer, error) {
+	var ban BannedPeer
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&ban)
+
+	return ban, err
+}
+
+// BanPeer records the ban of an address, replacing the one it may have
+func BanPeer(nodeID string, ban BannedPeer) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ban); err != nil {
+		return err
+	}
+
+	return updateBanList(nodeID, func(b *bolt.Bucket) error {
+		return b.Put([]byte(ban.Address), buf.Bytes())
+	})
+}
+
+// UnbanPeer lifts the ban of an address, it returns whether it was banned
+func UnbanPeer(nodeID, address string) (bool, error) {
+	var banned bool
+	err := updateBanList(nodeID, func(b *bolt.Bucket) error {
+		banned = b.Get([]byte(address)) != nil
+		return b.Delete([]byte(address))
+	})
+
+	return banned, err
+}
+
+// ClearBanned lifts all bans, it returns how many there were
+func ClearBanned(nodeID string) (int, error) {
+	var count int
+	err := updateBanList(nodeID, func(b *bolt.Bucket) error {
+		b.ForEach(func(k, v []byte) error {
+			count++
+			return nil
+		})
+		return b.Tx().DeleteBucket([]byte(bannedBucket))
+	})
+
+	return count, err
+}
+
+// ListBanned returns the bans of the node nodeID not expired yet, by
+// address, none before the first ban
+func ListBanned(nodeID string) ([]BannedPeer, error) {
+	path := BanListFile(nodeID)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	db, err := openDB(path, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var bans []BannedPeer
+	now := time.Now()
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bannedBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			ban, err := decodeBan(v)
+			if err != nil {
+				return err
+			}
+			if ban.Until.After(now) {
+				bans = append(bans, ban)
+			}
+			return nil
+		})
+	})
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Address < bans[j].Address })
+
+	return bans, err
+}
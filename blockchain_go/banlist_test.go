@@ -0,0 +1,44 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanList(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		bans, err := ListBanned("test")
+		assert.Nil(t, err)
+		assert.Empty(t, bans)
+
+		until := time.Now().Add(time.Hour).Round(time.Second)
+		assert.Nil(t, BanPeer("test", BannedPeer{"10.0.0.2", until, "bad proof of work"}))
+		assert.Nil(t, BanPeer("test", BannedPeer{"10.0.0.1", until, "manual"}))
+		// an expired ban is dropped
+		assert.Nil(t, BanPeer("test", BannedPeer{"10.0.0.3", time.Now().Add(-time.Second), "manual"}))
+		bans, err = ListBanned("test")
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(bans)) {
+			assert.Equal(t, "10.0.0.1", bans[0].Address)
+			assert.Equal(t, "10.0.0.2", bans[1].Address)
+			assert.True(t, until.Equal(bans[1].Until))
+			assert.Equal(t, "bad proof of work", bans[1].Reason)
+		}
+
+		banned, err := UnbanPeer("test", "10.0.0.1")
+		assert.Nil(t, err)
+		assert.True(t, banned)
+		banned, err = UnbanPeer("test", "10.0.0.3")
+		assert.Nil(t, err)
+		assert.False(t, banned)
+
+		count, err := ClearBanned("test")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+		bans, err = ListBanned("test")
+		assert.Nil(t, err)
+		assert.Empty(t, bans)
+	})
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// pubKeyHashLen is the length of a RIPEMD160 public key hash
+const pubKeyHashLen = 20
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+func bech32VerifyChecksum(hrp string, data []byte) bool {
+	return bech32Polymod(append(bech32HRPExpand(hrp), data...)) == 1
+}
+
+func bech32Checksum(hrp string, data []byte) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ 1
+
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte((mod >> uint(5*(5-i))) & 31)
+	}
+	return checksum
+}
+
+// convertBits regroups a byte slice from fromBits to toBits wide groups
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	var result []byte
+
+	for _, b := range data {
+		if uint32(b)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte((acc>>bits)&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte((acc<<(toBits-bits))&maxv))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+
+	return result, nil
+}
+
+// EncodeBech32Address encodes a public key hash as a Bech32 address of the
+// active network
+func EncodeBech32Address(pubKeyHash []byte) string {
+	data, err := convertBits(pubKeyHash, 8, 5, true)
+	if err != nil {
+		panic(err) // 8 bit input is always in range
+	}
+	hrp := ActiveNetParams.Bech32HRP
+	combined := append(data, bech32Checksum(hrp, data)...)
+
+	var address strings.Builder
+	address.WriteString(hrp)
+	address.WriteByte('1')
+	for _, c := range combined {
+		address.WriteByte(bech32Charset[c])
+	}
+	return address.String()
+}
+
+// DecodeBech32Address returns the public key hash of a Bech32 address of the
+// active network. On a checksum failure the error names the position of the
+// mistyped character when a single substitution explains it
+func DecodeBech32Address(address string) ([]byte, error) {
+	return decodeBech32Address(address, ActiveNetParams.Bech32HRP)
+}
+
+// decodeBech32Address is DecodeBech32Address on the network of hrp
+func decodeBech32Address(address, hrp string) ([]byte, error) {
+	if len(address) > 90 {
+		return nil, fmt.Errorf("bech32 address is %d characters long, the maximum is 90", len(address))
+	}
+	lower := strings.ToLower(address)
+	if lower != address && strings.ToUpper(address) != address {
+		return nil, errors.New("bech32 address mixes upper and lower case")
+	}
+
+	sep := strings.LastIndexByte(lower, '1')
+	if sep < 1 || sep+7 > len(lower) {
+		return nil, errors.New("bech32 address has no valid separator")
+	}
+	if prefix := lower[:sep]; prefix != hrp {
+		return nil, fmt.Errorf("bech32 address prefix is %q, expected %q", prefix, hrp)
+	}
+
+	data := make([]byte, 0, len(lower)-sep-1)
+	for i := sep + 1; i < len(lower); i++ {
+		idx := strings.IndexByte(bech32Charset, lower[i])
+		if idx < 0 {
+			return nil, fmt.Errorf("bech32 address has invalid character %q at position %d", lower[i], i)
+		}
+		data = append(data, byte(idx))
+	}
+
+	if !bech32VerifyChecksum(hrp, data) {
+		if pos := bech32LocateError(hrp, data); pos >= 0 {
+			return nil, fmt.Errorf("bech32 checksum mismatch, probably a typo at position %d", sep+1+pos)
+		}
+		return nil, errors.New("bech32 checksum mismatch")
+	}
+
+	pubKeyHash, err := convertBits(data[:len(data)-6], 5, 8, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(pubKeyHash) != pubKeyHashLen {
+		return nil, fmt.Errorf("bech32 payload is %d bytes, expected %d", len(pubKeyHash), pubKeyHashLen)
+	}
+
+	return pubKeyHash, nil
+}
+
+// bech32LocateError returns the index into data of a single substituted
+// character that makes the checksum valid, or -1
+func bech32LocateError(hrp string, data []byte) int {
+	candidate := make([]byte, len(data))
+	for i := range data {
+		copy(candidate, data)
+		for c := byte(0); c < 32; c++ {
+			if c == data[i] {
+				continue
+			}
+			candidate[i] = c
+			if bech32VerifyChecksum(hrp, candidate) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// isBech32Address reports whether address looks like a Bech32 address of
+// the network of hrp, without validating it
+func isBech32Address(address, hrp string) bool {
+	return strings.HasPrefix(strings.ToLower(address), hrp+"1")
+}
+
+// GetPubKeyHashFromAddress returns the public key hash of a Base58Check or
+// Bech32 address
+func GetPubKeyHashFromAddress(address string) ([]byte, error) {
+	if isBech32Address(address, ActiveNetParams.Bech32HRP) {
+		return DecodeBech32Address(address)
+	}
+
+	pubKeyHash, err := decodeBase58Address(address, ActiveNetParams.AddressVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", address, err)
+	}
+
+	return pubKeyHash, nil
+}
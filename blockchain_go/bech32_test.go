@@ -0,0 +1,106 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBech32AddressRoundTrip(t *testing.T) {
+	wallet := NewWallet()
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+
+	address := wallet.GetBech32Address()
+	assert.True(t, strings.HasPrefix(address, ActiveNetParams.Bech32HRP+"1"))
+	assert.True(t, ValidateAddress(address))
+
+	decoded, err := DecodeBech32Address(address)
+	assert.Nil(t, err)
+	assert.Equal(t, pubKeyHash, decoded)
+
+	decoded, err = DecodeBech32Address(strings.ToUpper(address))
+	assert.Nil(t, err)
+	assert.Equal(t, pubKeyHash, decoded)
+}
+
+func TestBech32AddressBadChecksum(t *testing.T) {
+	address := []byte(NewWallet().GetBech32Address())
+	pos := len(ActiveNetParams.Bech32HRP) + 5
+	if address[pos] == 'q' {
+		address[pos] = 'p'
+	} else {
+		address[pos] = 'q'
+	}
+
+	_, err := DecodeBech32Address(string(address))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("position %d", pos))
+	assert.False(t, ValidateAddress(string(address)))
+}
+
+func TestBech32AddressInvalid(t *testing.T) {
+	address := NewWallet().GetBech32Address()
+
+	_, err := DecodeBech32Address(address[:len(ActiveNetParams.Bech32HRP)+1] + "b" + address[len(ActiveNetParams.Bech32HRP)+2:])
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid character")
+
+	_, err = DecodeBech32Address("abc" + address[len(ActiveNetParams.Bech32HRP):])
+	assert.NotNil(t, err)
+
+	_, err = DecodeBech32Address(strings.ToUpper(address[:5]) + address[5:])
+	assert.NotNil(t, err)
+}
+
+func TestBech32AddressNetwork(t *testing.T) {
+	wallet := NewWallet()
+	address := wallet.GetBech32Address()
+	assert.True(t, strings.HasPrefix(address, RegTestParams.Bech32HRP+"1"))
+
+	// the address of the test networks is refused on the main network,
+	// where the same key has an address of its own
+	defer func(params *NetParams) { ActiveNetParams = params }(ActiveNetParams)
+	for _, params := range []*NetParams{&MainNetParams, &TestNetParams} {
+		ActiveNetParams = params
+		_, err := DecodeBech32Address(address)
+		assert.NotNil(t, err, "A regtest address on %s", params.Name)
+		assert.False(t, ValidateAddress(address))
+		_, err = GetPubKeyHashFromAddress(address)
+		assert.NotNil(t, err)
+		assert.Panics(t, func() { NewTXOutput(1, address) })
+
+		other := wallet.GetBech32Address()
+		assert.NotEqual(t, address, other)
+		assert.True(t, ValidateAddress(other))
+	}
+}
+
+func TestGetPubKeyHashFromAddress(t *testing.T) {
+	wallet := NewWallet()
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+
+	fromBase58, err := GetPubKeyHashFromAddress(string(wallet.GetAddress()))
+	assert.Nil(t, err)
+	fromBech32, err := GetPubKeyHashFromAddress(wallet.GetBech32Address())
+	assert.Nil(t, err)
+	assert.Equal(t, pubKeyHash, fromBase58)
+	assert.Equal(t, pubKeyHash, fromBech32)
+
+	_, err = GetPubKeyHashFromAddress("1")
+	assert.NotNil(t, err)
+
+	out1 := NewTXOutput(10, string(wallet.GetAddress()))
+	out2 := NewTXOutput(10, wallet.GetBech32Address())
+	assert.Equal(t, out1.PubKeyHash, out2.PubKeyHash)
+	assert.True(t, out2.IsLockedWithKey(pubKeyHash))
+}
+
+func TestGetWalletBech32(t *testing.T) {
+	ws, address := newTestWallets()
+
+	wallet, err := ws.GetWallet(ws.Wallets[address].GetBech32Address())
+	assert.Nil(t, err)
+	assert.Equal(t, address, fmt.Sprintf("%s", wallet.GetAddress()))
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"log"
 	"time"
@@ -9,58 +10,85 @@ import (
 	"math/big"
 )
 
-// Some weird constants to avoid constant memory allocs for them.
-var (
-	expDiffPeriod = big.NewInt(100000)
-	big1          = big.NewInt(1)
-	big2          = big.NewInt(2)
-	big4          = big.NewInt(4)
-	big9          = big.NewInt(9)
-	big10         = big.NewInt(10)
-	bigMinus99    = big.NewInt(-99)
-	big2999999    = big.NewInt(2999999)
-)
-
-var (
-	DifficultyBoundDivisor = big.NewInt(2)   // The bound divisor of the difficulty, used in the update calculations.
-	GenesisDifficulty      = big.NewInt(4) // Difficulty of the Genesis block.
-	MinimumDifficulty      = big.NewInt(4) // The minimum that the difficulty may ever be.
-	DurationLimit          = big.NewInt(13)     // The decision boundary on the blocktime duration used to determine whether difficulty should go up or not.
-)
+var big1 = big.NewInt(1)
 
 // Block represents a block in the blockchain
 type Block struct {
-	Timestamp     *big.Int
-	Transactions  []*Transaction
-	PrevBlockHash []byte
-	Hash          []byte
-	Nonce         int
-	Height        *big.Int
-	Difficulty    *big.Int
-	ReceivedAt    time.Time
+	BlockHeader
+	Transactions []*Transaction
+	Hash         []byte
+	ReceivedAt   time.Time
+}
+
+// storedBlock is how blocks were gob encoded before the format had versions,
+// the header fields inline as they were before BlockHeader
+type storedBlock struct {
+	Timestamp      *big.Int
+	Transactions   []*Transaction
+	PrevBlockHash  []byte
+	Hash           []byte
+	Nonce          int
+	Height         *big.Int
+	Difficulty     *big.Int
+	Bits           uint32
+	UTXOCommitment []byte
+	ReceivedAt     time.Time
+	MerkleRoot     []byte
 }
 
 // NewBlock creates and returns Block
 func NewBlock(transactions []*Transaction, prevBlockHash []byte, height *big.Int,genesis bool,bc *Blockchain) *Block {
-	var dif *big.Int
+	block, _ := newBlockContext(context.Background(), transactions, prevBlockHash, height, genesis, bc)
+
+	return block
+}
+
+// newBlockContext creates a Block like NewBlock does, mining it until ctx is
+// done, in which case it returns ErrMiningAborted
+func newBlockContext(ctx context.Context, transactions []*Transaction, prevBlockHash []byte, height *big.Int,genesis bool,bc *Blockchain) (*Block, error) {
+	bits := ActiveNetParams.PowLimitBits
 	timetime := time.Now()
 	time64 := timetime.Unix()
-	time := new(big.Int).SetInt64(time64)
 	if (!genesis && bc != nil) {
 		preBlock, _ := bc.GetBlock(prevBlockHash)
-		dif = CalcDifficulty(time.Uint64(), &preBlock)
-	}else{
-		dif = big4
+		var err error
+		bits, err = bc.CalcNextBits(&preBlock.BlockHeader)
+		if err != nil {
+			log.Panic(err)
+		}
+		// a clock behind the chain stamps the block just after the median
+		// time past, the earliest timestamp the block is valid with
+		median, err := bc.MedianTimePast(prevBlockHash)
+		if err != nil {
+			log.Panic(err)
+		}
+		if time64 <= median {
+			time64 = median + 1
+		}
+	}
+	time := new(big.Int).SetInt64(time64)
+	var commitment []byte
+	if (!genesis && bc != nil && commitsUTXOSet(height)) {
+		var err error
+		commitment, err = UTXOSet{bc}.Commitment()
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	header := BlockHeader{PrevBlockHash: prevBlockHash, Timestamp: time, Bits: bits, Height: height, UTXOCommitment: commitment}
+	block := &Block{BlockHeader: header, Transactions: transactions, Hash: []byte{}, ReceivedAt: timetime}
+	block.MerkleRoot = block.HashTransactions()
+	pow := NewProofOfWork(block)
+	nonce, hash, err := pow.RunContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-	block := &Block{ time, transactions, prevBlockHash, []byte{}, 0, height,dif, timetime}
-	pow := NewProofOfWork(block,dif.Int64())
-	nonce, hash := pow.Run()
 
 	block.Hash = hash[:]
 	block.Nonce = nonce
 
-	fmt.Printf("mined Block  %s \n", block)
-	return block
+	fmt.Printf("mined Block  %v \n", block)
+	return block, nil
 }
 
 // NewGenesisBlock creates and returns genesis Block
@@ -68,8 +96,21 @@ func NewGenesisBlock(coinbase *Transaction) *Block {
 	return NewBlock([]*Transaction{coinbase}, []byte{}, big.NewInt(0),true,nil)
 }
 
-// HashTransactions returns a hash of the transactions in the block
+// HashTransactions returns the merkle root of the transactions in the block,
+// of their IDs from MerkleTreeHeight and of their strings below it
 func (b *Block) HashTransactions() []byte {
+	if !usesMerkleTree(b.Height) {
+		return b.legacyHashTransactions()
+	}
+	var ids [][]byte
+	for _, tx := range b.Transactions {
+		ids = append(ids, tx.ID)
+	}
+
+	return NewMerkleTree(ids).RootNode.Data
+}
+
+func (b *Block) legacyHashTransactions() []byte {
 	var transactions [][]byte
 
 	for _, tx := range b.Transactions {
@@ -77,119 +118,81 @@ func (b *Block) HashTransactions() []byte {
 		//transactions = append(transactions, tx.Hash())
 		transactions = append(transactions, []byte(tx.String()))
 	}
-	mTree := NewMerkleTree(transactions)
 
-	return mTree.RootNode.Data
+	return legacyMerkleRoot(transactions)
 }
 
-// Serialize serializes the block
+// Serialize encodes the block in the newest version of the format, see
+// Encode
 func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
-
-	err := encoder.Encode(b)
+	data, err := b.Encode(MaxBlockVersion)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return result.Bytes()
+	return data
 }
 
-// DeserializeBlock deserializes a block
-func DeserializeBlock(d []byte) *Block {
-	var block Block
+// gobEncode encodes the block as blocks were before the format had versions
+func (b *Block) gobEncode() ([]byte, error) {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
 
-	//fmt.Printf("len(d) %d \n", len(d))
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
+	err := encoder.Encode(storedBlock{
+		Timestamp:      b.Timestamp,
+		Transactions:   b.Transactions,
+		PrevBlockHash:  b.PrevBlockHash,
+		Hash:           b.Hash,
+		Nonce:          b.Nonce,
+		Height:         b.Height,
+		Difficulty:     b.Difficulty,
+		Bits:           b.Bits,
+		UTXOCommitment: b.UTXOCommitment,
+		ReceivedAt:     b.ReceivedAt,
+		MerkleRoot:     b.MerkleRoot,
+	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return &block
+	return result.Bytes(), nil
 }
 
-// CalcDifficulty is the difficulty adjustment algorithm. It returns
-// the difficulty that a new block should have when created at time
-// given the parent block's time and difficulty.
-//func (b *Block) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
-func (b *Block) CalcDifficulty(time uint64, parent *Block) *big.Int {
-	return CalcDifficulty(time, parent)
-}
+// DeserializeBlock deserializes a block in any version of the format, see
+// DecodeBlock
+func DeserializeBlock(d []byte) *Block {
+	block, err := DecodeBlock(d)
+	if err != nil {
+		log.Panic(err)
+	}
 
-// CalcDifficulty is the difficulty adjustment algorithm. It returns
-// the difficulty that a new block should have when created at time
-// given the parent block's time and difficulty.
-//func CalcDifficulty(config *params.ChainConfig, time uint64, parent *types.Header) *big.Int {
-func CalcDifficulty(time uint64, parent *Block) *big.Int {
-	//next := new(big.Int).Add(parent.Number, big1)
-	//switch {
-	//case config.IsByzantium(next):
-		//return calcDifficultyByzantium(time, parent)
-	//case config.IsHomestead(next):
-		return calcDifficultyHomestead(time, parent)
-	//default:
-	//	return calcDifficultyFrontier(time, parent)
-	//}
+	return block
 }
 
+func gobDecodeBlock(d []byte) (*Block, error) {
+	var stored storedBlock
 
-// calcDifficultyHomestead is the difficulty adjustment algorithm. It returns
-// the difficulty that a new block should have when created at time given the
-// parent block's time and difficulty. The calculation uses the Homestead rules.
-func calcDifficultyHomestead(time uint64, parent *Block) *big.Int {
-	// https://github.com/ethereum/EIPs/blob/master/EIPS/eip-2.md
-	// algorithm:
-	// diff = (parent_diff +
-	//         (parent_diff / 2048 * max(1 - (block_timestamp - parent_timestamp) // 10, -99))
-	//        ) + 2^(periodCount - 2)
-
-	bigTime := new(big.Int).SetUint64(time)
-	bigParentTime := new(big.Int).Set(parent.Timestamp)
-
-	// holds intermediate values to make the algo easier to read & audit
-	x := new(big.Int)
-	y := new(big.Int)
-
-	// 1 - (block_timestamp - parent_timestamp) // 10
-	x.Sub(bigTime, bigParentTime)
-	fmt.Printf("(block_timestamp - parent_timestamp) == %d \n", x.Int64())
-	x.Div(x, big10)
-	x.Sub(big1, x)
-	fmt.Printf("1 - (block_timestamp - parent_timestamp) // 10 == %d \n", x.Int64())
-
-	// max(1 - (block_timestamp - parent_timestamp) // 10, -99)
-	if x.Cmp(bigMinus99) < 0 {
-		x.Set(bigMinus99)
-	}
-	fmt.Printf("max(1 - (block_timestamp - parent_timestamp) == %d \n", x.Int64())
-	// (parent_diff + parent_diff // 2048 * max(1 - (block_timestamp - parent_timestamp) // 10, -99))
-	y.Div(parent.Difficulty, DifficultyBoundDivisor)
-	x.Mul(y, x)
-	x.Add(parent.Difficulty, x)
-	fmt.Printf("parent_diff + parent_diff // %d * max == %d \n", DifficultyBoundDivisor,x.Int64())
-
-	// minimum difficulty can ever be (before exponential factor)
-	if x.Cmp(MinimumDifficulty) < 0 {
-		x.Set(MinimumDifficulty)
-	}
-	fmt.Printf("x.Set(params.MinimumDifficulty) == %d \n", x.Int64())
-	// for the exponential factor
-	periodCount := new(big.Int).Add(parent.Height, big1)
-	periodCount.Div(periodCount, expDiffPeriod)
-	fmt.Printf("(parent.Height+1)//10000 == %d \n", periodCount.Int64())
-
-	// the exponential factor, commonly referred to as "the bomb"
-	// diff = diff + 2^(periodCount - 2)
-	if periodCount.Cmp(big1) > 0 {
-		y.Sub(periodCount, big2)
-		y.Exp(big2, y, nil)
-		x.Add(x, y)
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	if err := decoder.Decode(&stored); err != nil {
+		return nil, err
 	}
-	fmt.Printf("diff = diff + 2^(periodCount - 2) == %d \n", x.Int64())
-	return x
-}
 
+	return &Block{
+		BlockHeader: BlockHeader{
+			PrevBlockHash:  stored.PrevBlockHash,
+			MerkleRoot:     stored.MerkleRoot,
+			Timestamp:      stored.Timestamp,
+			Bits:           stored.Bits,
+			Difficulty:     stored.Difficulty,
+			Nonce:          stored.Nonce,
+			Height:         stored.Height,
+			UTXOCommitment: stored.UTXOCommitment,
+		},
+		Transactions: stored.Transactions,
+		Hash:         stored.Hash,
+		ReceivedAt:   stored.ReceivedAt,
+	}, nil
+}
 
 // HashTransactions returns a hash of the transactions in the block
 func (b *Block) HasTransactions(TxId []byte) bool{
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrCoinbaseCount is returned for a block that doesn't hold exactly one
+	// coinbase
+	ErrCoinbaseCount = errors.New("the block doesn't hold exactly one coinbase")
+	// ErrCoinbaseOverpays is returned for a coinbase paying more than the
+	// subsidy and the fees of its block
+	ErrCoinbaseOverpays = errors.New("the coinbase pays more than the subsidy and the fees of the block")
+)
+
+// TxInputsError is returned by BlockFees for a transaction spending an
+// output it can't, or more than its inputs hold, rather than for a failure
+// to read the UTXO set
+type TxInputsError struct {
+	Err error
+}
+
+func (e *TxInputsError) Error() string {
+	return e.Err.Error()
+}
+
+// BlockSubsidy returns the coins the coinbase of the block at height
+// creates, on the schedule of the active genesis config
+func BlockSubsidy(height int64) int {
+	genesis := &ActiveNetParams.Genesis
+	if genesis.HalvingInterval == 0 {
+		return genesis.Subsidy
+	}
+	era := height / genesis.HalvingInterval
+	if era >= 64 {
+		return 0
+	}
+
+	return genesis.Subsidy >> uint(era)
+}
+
+// txFee returns the fee tx pays. Its inputs resolve against created, the
+// outputs by ID of the transactions before it in the block, then against
+// the set. used holds the outputs spent before, and gets those tx spends
+func txFee(b *bolt.Bucket, cache *utxoCache, gen uint64, created map[string][]TXOutput, used OutpointSet, tx *Transaction) (int, error) {
+	in := 0
+	for i, vin := range tx.Vin {
+		if used.Has(vin.Txid, vin.Vout) {
+			return 0, fmt.Errorf("input %d (%x:%d) of %x: %v", i, vin.Txid, vin.Vout, tx.ID, ErrOutpointReused)
+		}
+		used.Add(vin.Txid, vin.Vout)
+
+		outputs, ok := created[string(vin.Txid)]
+		if !ok {
+			data := b.Get(vin.Txid)
+			if data == nil {
+				return 0, fmt.Errorf("input %d (%x:%d) of %x: %v", i, vin.Txid, vin.Vout, tx.ID, ErrUnknownOutpoint)
+			}
+			outputs = cache.decode(gen, vin.Txid, data).Outputs
+		}
+		switch {
+		case vin.Vout < 0 || vin.Vout >= len(outputs) || outputs[vin.Vout].isSpent():
+			return 0, fmt.Errorf("input %d (%x:%d) of %x: %v", i, vin.Txid, vin.Vout, tx.ID, ErrSpentOutpoint)
+		case !outputs[vin.Vout].IsLockedWithKey(HashPubKey(vin.PubKey)):
+			return 0, fmt.Errorf("input %d (%x:%d) of %x: %v", i, vin.Txid, vin.Vout, tx.ID, ErrOutpointNotOwned)
+		}
+		in += outputs[vin.Vout].Value
+	}
+	out := 0
+	for _, vout := range tx.Vout {
+		out += vout.Value
+	}
+	if out > in {
+		return 0, fmt.Errorf("outputs of %x of %d exceed inputs of %d by %d", tx.ID, out, in, out-in)
+	}
+
+	return in - out, nil
+}
+
+// BlockFees returns the fees the transactions of a block pay, coinbases
+// aside. They are taken in block order, so an input may spend an output of
+// a transaction before it, which the set doesn't hold yet. A transaction
+// the set refuses is reported with a *TxInputsError
+func (u UTXOSet) BlockFees(txs []*Transaction) (int, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+
+	fees := 0
+	err := u.Blockchain.Db.View(func(dbTx *bolt.Tx) error {
+		b := dbTx.Bucket([]byte(utxoBucket))
+		created := make(map[string][]TXOutput)
+		used := make(OutpointSet)
+		for _, tx := range txs {
+			if tx.IsCoinbase() {
+				continue
+			}
+			fee, err := txFee(b, cache, gen, created, used, tx)
+			if err != nil {
+				return &TxInputsError{err}
+			}
+			fees += fee
+			created[string(tx.ID)] = tx.Vout
+		}
+
+		return nil
+	})
+
+	return fees, err
+}
+
+// CheckCoinbase checks that block holds one coinbase, paying at most the
+// subsidy for its height and the fees of the other transactions
+func (u UTXOSet) CheckCoinbase(block *Block) error {
+	var coinbases []*Transaction
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			coinbases = append(coinbases, tx)
+		}
+	}
+	if len(coinbases) != 1 {
+		return ErrCoinbaseCount
+	}
+	fees, err := u.BlockFees(block.Transactions)
+	if err != nil {
+		return err
+	}
+	paid := 0
+	for _, vout := range coinbases[0].Vout {
+		paid += vout.Value
+	}
+	if paid > BlockSubsidy(block.Height.Int64())+fees {
+		return ErrCoinbaseOverpays
+	}
+
+	return nil
+}
+
+// BlockTransactions returns the transactions of a block mined on the tip
+// paying to to: those of candidates SelectTransactions picks, and a coinbase
+// paying the subsidy and their fees
+func (u UTXOSet) BlockTransactions(candidates []*Transaction, to string) ([]*Transaction, error) {
+	height, _, err := u.Blockchain.GetBestHeightLastHash()
+	if err != nil {
+		return nil, err
+	}
+	txs, err := u.SelectTransactions(candidates, NewCoinbaseTX(to, ""))
+	if err != nil {
+		return nil, err
+	}
+	fees, err := u.BlockFees(txs)
+	if err != nil {
+		return nil, err
+	}
+	txs[0] = NewCoinbaseTXValue(to, "", BlockSubsidy(height.Int64()+1)+fees)
+
+	return txs, nil
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockSubsidy(t *testing.T) {
+	assert.Equal(t, subsidy, BlockSubsidy(0))
+	assert.Equal(t, subsidy, BlockSubsidy(halfRewardblockCount-1))
+	assert.Equal(t, subsidy/2, BlockSubsidy(halfRewardblockCount))
+	assert.Equal(t, 0, BlockSubsidy(64*halfRewardblockCount))
+}
+
+func TestBlockFees(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		wallet := ws.Wallets[address]
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+
+		// a spend of the genesis coinbase paying 5, and a spend of it paying 3
+		parent := &Transaction{
+			Vin:  []TXInput{{genesis.Transactions[0].ID, 0, nil, wallet.PublicKey}},
+			Vout: []TXOutput{{subsidy - 5, HashPubKey(wallet.PublicKey)}},
+		}
+		parent.ID = parent.Hash()
+		child := &Transaction{
+			Vin:  []TXInput{{parent.ID, 0, nil, wallet.PublicKey}},
+			Vout: []TXOutput{{subsidy - 8, HashPubKey(wallet.PublicKey)}},
+		}
+		child.ID = child.Hash()
+		signer, err := wallet.Signer()
+		assert.Nil(t, err)
+		assert.Nil(t, parent.Sign(signer, map[string]Transaction{hex.EncodeToString(genesis.Transactions[0].ID): *genesis.Transactions[0]}))
+		assert.Nil(t, child.Sign(signer, map[string]Transaction{hex.EncodeToString(parent.ID): *parent}))
+
+		fees, err := u.BlockFees([]*Transaction{parent, child})
+		assert.Nil(t, err)
+		assert.Equal(t, 8, fees, "The child resolves against its parent in the block")
+		_, err = u.BlockFees([]*Transaction{child, parent})
+		assert.IsType(t, &TxInputsError{}, err, "Not against a parent after it")
+		_, err = u.BlockFees([]*Transaction{parent, parent})
+		assert.IsType(t, &TxInputsError{}, err, "Nor twice")
+
+		txs := mustTxs(u.BlockTransactions([]*Transaction{child, parent}, address))
+		assert.True(t, txs[0].IsCoinbase())
+		assert.Equal(t, []*Transaction{parent, child}, txs[1:], "The parent goes first")
+		assert.Equal(t, subsidy+8, txs[0].Vout[0].Value, "The coinbase collects the fees")
+
+		block := &Block{BlockHeader: BlockHeader{Height: big.NewInt(1)}, Transactions: txs}
+		assert.Nil(t, u.CheckCoinbase(block))
+		block.Transactions = []*Transaction{parent, child, NewCoinbaseTX(address, "")}
+		assert.Nil(t, u.CheckCoinbase(block), "A coinbase may leave fees unclaimed")
+		block.Transactions = []*Transaction{parent, child, NewCoinbaseTXValue(address, "", subsidy+9)}
+		assert.Equal(t, ErrCoinbaseOverpays, u.CheckCoinbase(block))
+		block.Transactions = []*Transaction{parent, child}
+		assert.Equal(t, ErrCoinbaseCount, u.CheckCoinbase(block))
+		block.Transactions = append(txs, NewCoinbaseTX(address, ""))
+		assert.Equal(t, ErrCoinbaseCount, u.CheckCoinbase(block))
+
+		mined := mustMine(bc, txs)
+		u.Update(mined)
+		stats, err := u.Stats()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), stats.Discrepancy, "No coins are destroyed")
+	})
+}
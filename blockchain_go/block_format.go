@@ -0,0 +1,398 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Blocks are encoded in a format of their own: a version byte, then the
+// fields of the version in order, each a section prefixed with its length
+// as a uvarint. A version adds fields after those of the one before. Blocks
+// encoded before the format are gob streams, which start with the length of
+// their first message, a type definition, never as short as a version
+const (
+	// BlockVersionGob is the gob encoding of storedBlock, decoded by every
+	// node
+	BlockVersionGob = 0
+	// BlockVersion1 holds the fields of the first blocks: the previous
+	// hash, the timestamp, the nonce, the height, the difficulty, the
+	// transactions, the hash and the time the block was received
+	BlockVersion1 = 1
+	// BlockVersion2 adds the compact bits, the merkle root and the UTXO
+	// commitment
+	BlockVersion2 = 2
+	// MaxBlockVersion is the newest version, which Serialize encodes
+	MaxBlockVersion = BlockVersion2
+)
+
+// ErrBlockVersion is returned by Encode for a format version this node
+// doesn't know
+var ErrBlockVersion = errors.New("unknown block format version")
+
+// blockField encodes a field of Block into a section and back
+type blockField struct {
+	encode func(b *Block) []byte
+	decode func(b *Block, data []byte) error
+}
+
+var blockFieldsV1 = []blockField{
+	{
+		func(b *Block) []byte { return b.PrevBlockHash },
+		func(b *Block, data []byte) error { b.PrevBlockHash = copyBytes(data); return nil },
+	},
+	{
+		func(b *Block) []byte { return encodeBig(b.Timestamp) },
+		func(b *Block, data []byte) (err error) { b.Timestamp, err = decodeBig(data); return },
+	},
+	{
+		func(b *Block) []byte { return encodeInt(int64(b.Nonce)) },
+		func(b *Block, data []byte) error {
+			nonce, err := decodeInt(data)
+			b.Nonce = int(nonce)
+			return err
+		},
+	},
+	{
+		func(b *Block) []byte { return encodeBig(b.Height) },
+		func(b *Block, data []byte) (err error) { b.Height, err = decodeBig(data); return },
+	},
+	{
+		func(b *Block) []byte { return encodeBig(b.Difficulty) },
+		func(b *Block, data []byte) (err error) { b.Difficulty, err = decodeBig(data); return },
+	},
+	{
+		func(b *Block) []byte { return encodeTransactions(b.Transactions) },
+		func(b *Block, data []byte) (err error) { b.Transactions, err = decodeTransactions(data); return },
+	},
+	{
+		func(b *Block) []byte { return b.Hash },
+		func(b *Block, data []byte) error { b.Hash = copyBytes(data); return nil },
+	},
+	{
+		func(b *Block) []byte {
+			data, err := b.ReceivedAt.MarshalBinary()
+			if err != nil {
+				// offsets of a fraction of a minute
+				data, _ = b.ReceivedAt.UTC().MarshalBinary()
+			}
+			return data
+		},
+		func(b *Block, data []byte) error { return b.ReceivedAt.UnmarshalBinary(data) },
+	},
+}
+
+var blockFieldsV2 = append(blockFieldsV1[:len(blockFieldsV1):len(blockFieldsV1)],
+	blockField{
+		func(b *Block) []byte {
+			data := make([]byte, 4)
+			binary.BigEndian.PutUint32(data, b.Bits)
+			return data
+		},
+		func(b *Block, data []byte) error {
+			if len(data) != 4 {
+				return errors.New("bits aren't 4 bytes")
+			}
+			b.Bits = binary.BigEndian.Uint32(data)
+			return nil
+		},
+	},
+	blockField{
+		func(b *Block) []byte { return b.MerkleRoot },
+		func(b *Block, data []byte) error { b.MerkleRoot = copyBytes(data); return nil },
+	},
+	blockField{
+		func(b *Block) []byte { return b.UTXOCommitment },
+		func(b *Block, data []byte) error { b.UTXOCommitment = copyBytes(data); return nil },
+	},
+)
+
+// blockFormats are the fields of each version of the format
+var blockFormats = map[int][]blockField{
+	BlockVersion1: blockFieldsV1,
+	BlockVersion2: blockFieldsV2,
+}
+
+// Encode encodes the block in a version of the format. Version 1 can't hold
+// a block with compact bits, a UTXO commitment or a merkle tree root
+func (b *Block) Encode(version int) ([]byte, error) {
+	if version == BlockVersionGob {
+		return b.gobEncode()
+	}
+	fields, ok := blockFormats[version]
+	if !ok {
+		return nil, ErrBlockVersion
+	}
+	if version < BlockVersion2 && (b.Bits != 0 || len(b.UTXOCommitment) > 0 ||
+		len(b.MerkleRoot) > 0 && !bytes.Equal(b.MerkleRoot, b.legacyHashTransactions())) {
+		return nil, fmt.Errorf("block %x has fields version %d doesn't hold", b.Hash, version)
+	}
+
+	data := []byte{byte(version)}
+	for _, field := range fields {
+		data = appendSection(data, field.encode(b))
+	}
+
+	return data, nil
+}
+
+// SerializeFor encodes the block in the newest version both this node and
+// one decoding up to maxVersion know that holds the block, the gob encoding
+// if none does
+func (b *Block) SerializeFor(maxVersion int) []byte {
+	if maxVersion > MaxBlockVersion {
+		maxVersion = MaxBlockVersion
+	}
+	for version := maxVersion; version > BlockVersionGob; version-- {
+		if data, err := b.Encode(version); err == nil {
+			return data
+		}
+	}
+	data, err := b.Encode(BlockVersionGob)
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+// DecodeBlock decodes a block in any version of the format
+func DecodeBlock(d []byte) (*Block, error) {
+	if len(d) == 0 {
+		return nil, errors.New("the block is empty")
+	}
+
+	var block *Block
+	if fields, ok := blockFormats[int(d[0])]; ok {
+		block = &Block{}
+		rest := d[1:]
+		for i, field := range fields {
+			var section []byte
+			var err error
+			if section, rest, err = readSection(rest); err != nil {
+				return nil, fmt.Errorf("block field %d: %v", i, err)
+			}
+			if err := field.decode(block, section); err != nil {
+				return nil, fmt.Errorf("block field %d: %v", i, err)
+			}
+		}
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("%d bytes after the block", len(rest))
+		}
+	} else {
+		var err error
+		if block, err = gobDecodeBlock(d); err != nil {
+			return nil, fmt.Errorf("the block is in no known format version nor gob encoded: %v", err)
+		}
+	}
+	if err := block.checkDifficulty(); err != nil {
+		return nil, err
+	}
+	// blocks stored before BlockHeader don't carry their merkle root, and
+	// predate the merkle tree
+	if len(block.MerkleRoot) == 0 {
+		block.MerkleRoot = block.legacyHashTransactions()
+	}
+
+	return block, nil
+}
+
+// encodeTransactions encodes the count of transactions, then a section for
+// each: the ID, the timestamp, the inputs and the outputs sections
+func encodeTransactions(txs []*Transaction) []byte {
+	data := appendUvarint(nil, uint64(len(txs)))
+	for _, tx := range txs {
+		var inputs, outputs []byte
+		inputs = appendUvarint(inputs, uint64(len(tx.Vin)))
+		for _, in := range tx.Vin {
+			var input []byte
+			input = appendSection(input, in.Txid)
+			input = appendSection(input, encodeInt(int64(in.Vout)))
+			input = appendSection(input, in.Signature)
+			input = appendSection(input, in.PubKey)
+			inputs = appendSection(inputs, input)
+		}
+		outputs = appendUvarint(outputs, uint64(len(tx.Vout)))
+		for _, out := range tx.Vout {
+			var output []byte
+			output = appendSection(output, encodeInt(int64(out.Value)))
+			output = appendSection(output, out.PubKeyHash)
+			outputs = appendSection(outputs, output)
+		}
+
+		var encoded []byte
+		encoded = appendSection(encoded, tx.ID)
+		encoded = appendSection(encoded, encodeInt(tx.Timestamp))
+		encoded = appendSection(encoded, inputs)
+		encoded = appendSection(encoded, outputs)
+		data = appendSection(data, encoded)
+	}
+
+	return data
+}
+
+func decodeTransactions(data []byte) ([]*Transaction, error) {
+	var txs []*Transaction
+	err := readList(data, func(encoded []byte) error {
+		sections, err := readSections(encoded, 4)
+		if err != nil {
+			return err
+		}
+		tx := &Transaction{ID: copyBytes(sections[0])}
+		if tx.Timestamp, err = decodeInt(sections[1]); err != nil {
+			return err
+		}
+		err = readList(sections[2], func(input []byte) error {
+			fields, err := readSections(input, 4)
+			if err != nil {
+				return err
+			}
+			vout, err := decodeInt(fields[1])
+			if err != nil {
+				return err
+			}
+			tx.Vin = append(tx.Vin, TXInput{copyBytes(fields[0]), int(vout), copyBytes(fields[2]), copyBytes(fields[3])})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = readList(sections[3], func(output []byte) error {
+			fields, err := readSections(output, 2)
+			if err != nil {
+				return err
+			}
+			value, err := decodeInt(fields[0])
+			if err != nil {
+				return err
+			}
+			tx.Vout = append(tx.Vout, TXOutput{int(value), copyBytes(fields[1])})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		txs = append(txs, tx)
+		return nil
+	})
+
+	return txs, err
+}
+
+func appendUvarint(data []byte, x uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	return append(data, buf[:binary.PutUvarint(buf, x)]...)
+}
+
+func appendSection(data, section []byte) []byte {
+	data = appendUvarint(data, uint64(len(section)))
+
+	return append(data, section...)
+}
+
+func readSection(data []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return nil, nil, errors.New("truncated section")
+	}
+	end := n + int(length)
+
+	return data[n:end], data[end:], nil
+}
+
+// readSections reads the count sections data is made of
+func readSections(data []byte, count int) ([][]byte, error) {
+	sections := make([][]byte, count)
+	for i := range sections {
+		var err error
+		if sections[i], data, err = readSection(data); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%d bytes after the sections", len(data))
+	}
+
+	return sections, nil
+}
+
+// readList calls f with each section of a list, its count first
+func readList(data []byte, f func(section []byte) error) error {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return errors.New("truncated list")
+	}
+	data = data[n:]
+	for i := uint64(0); i < count; i++ {
+		var section []byte
+		var err error
+		if section, data, err = readSection(data); err != nil {
+			return err
+		}
+		if err := f(section); err != nil {
+			return err
+		}
+	}
+	if len(data) > 0 {
+		return fmt.Errorf("%d bytes after the list", len(data))
+	}
+
+	return nil
+}
+
+func encodeInt(x int64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(x))
+
+	return data
+}
+
+func decodeInt(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, errors.New("an integer isn't 8 bytes")
+	}
+
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+// encodeBig encodes nil as no bytes, other integers as a sign byte, 1 for
+// negative ones, then the absolute value big endian
+func encodeBig(x *big.Int) []byte {
+	if x == nil {
+		return nil
+	}
+	sign := byte(0)
+	if x.Sign() < 0 {
+		sign = 1
+	}
+
+	return append([]byte{sign}, x.Bytes()...)
+}
+
+func decodeBig(data []byte) (*big.Int, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] > 1 {
+		return nil, errors.New("an integer has no valid sign")
+	}
+	x := new(big.Int).SetBytes(data[1:])
+	if data[0] == 1 {
+		x.Neg(x)
+	}
+
+	return x, nil
+}
+
+// copyBytes copies a section out of the encoded block, nil when empty as
+// gob decodes empty slices
+func copyBytes(data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return append([]byte(nil), data...)
+}
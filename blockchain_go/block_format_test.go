@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// goldenBlock returns a block with fields of every kind, with the header
+// fields of version 2 when v2 is set
+func goldenBlock(v2 bool) *Block {
+	coinbase := &Transaction{
+		ID:        bytes.Repeat([]byte{0x11}, 32),
+		Vin:       []TXInput{{nil, -1, nil, []byte("golden")}},
+		Vout:      []TXOutput{{subsidy, bytes.Repeat([]byte{0x22}, 20)}},
+		Timestamp: 1546300800,
+	}
+	spend := &Transaction{
+		ID:        bytes.Repeat([]byte{0x33}, 32),
+		Vin:       []TXInput{{coinbase.ID, 0, bytes.Repeat([]byte{0x44}, 64), bytes.Repeat([]byte{0x55}, 64)}},
+		Vout:      []TXOutput{{30, bytes.Repeat([]byte{0x66}, 20)}, {19, bytes.Repeat([]byte{0x22}, 20)}},
+		Timestamp: 1546300801,
+	}
+	block := &Block{
+		BlockHeader: BlockHeader{
+			PrevBlockHash: bytes.Repeat([]byte{0x77}, 32),
+			Timestamp:     big.NewInt(1546300900),
+			Nonce:         42,
+			Height:        big.NewInt(7),
+		},
+		Transactions: []*Transaction{coinbase, spend},
+		Hash:         bytes.Repeat([]byte{0x88}, 32),
+		ReceivedAt:   time.Unix(1546300901, 0).UTC(),
+	}
+	if v2 {
+		block.Bits = 0x207fffff
+		block.UTXOCommitment = bytes.Repeat([]byte{0x99}, 32)
+		block.MerkleRoot = block.HashTransactions()
+	} else {
+		block.Difficulty = big.NewInt(4)
+		block.MerkleRoot = block.legacyHashTransactions()
+	}
+
+	return block
+}
+
+func assertSameBlock(t *testing.T, expected, actual *Block) {
+	assert.Equal(t, expected.BlockHeader, actual.BlockHeader)
+	assert.Equal(t, expected.Hash, actual.Hash)
+	assert.True(t, expected.ReceivedAt.Equal(actual.ReceivedAt))
+	assert.Equal(t, len(expected.Transactions), len(actual.Transactions))
+	for i, tx := range expected.Transactions {
+		assert.Equal(t, tx.ID, actual.Transactions[i].ID)
+		assert.Equal(t, tx.Timestamp, actual.Transactions[i].Timestamp)
+		assert.Equal(t, tx.Vin, actual.Transactions[i].Vin)
+		assert.Equal(t, tx.Vout, actual.Transactions[i].Vout)
+	}
+}
+
+func TestBlockFormatGolden(t *testing.T) {
+	for _, test := range []struct {
+		version int
+		file    string
+	}{
+		{BlockVersion1, "block_v1.golden"},
+		{BlockVersion2, "block_v2.golden"},
+	} {
+		block := goldenBlock(test.version == BlockVersion2)
+		data, err := block.Encode(test.version)
+		assert.Nil(t, err)
+		path := filepath.Join("testdata", test.file)
+		if *updateGolden {
+			assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+		}
+		golden, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, golden, data, "The encoding of version %d changed", test.version)
+
+		decoded, err := DecodeBlock(golden)
+		assert.Nil(t, err)
+		assertSameBlock(t, block, decoded)
+	}
+}
+
+func TestBlockFormat(t *testing.T) {
+	v1, v2 := goldenBlock(false), goldenBlock(true)
+	_, err := v2.Encode(BlockVersion1)
+	assert.NotNil(t, err, "Version 1 has no bits")
+	_, err = v2.Encode(MaxBlockVersion + 1)
+	assert.Equal(t, ErrBlockVersion, err)
+
+	// gob encoded blocks, stored before the format had versions, still decode
+	data, err := v2.Encode(BlockVersionGob)
+	assert.Nil(t, err)
+	decoded, err := DecodeBlock(data)
+	assert.Nil(t, err)
+	assertSameBlock(t, v2, decoded)
+	assertSameBlock(t, v2, DeserializeBlock(v2.Serialize()))
+	assert.Equal(t, byte(MaxBlockVersion), v2.Serialize()[0])
+
+	// what a peer is sent
+	assert.Equal(t, byte(BlockVersion2), v2.SerializeFor(MaxBlockVersion + 1)[0])
+	assert.Equal(t, byte(BlockVersion1), v1.SerializeFor(BlockVersion1)[0], "A block version 1 holds")
+	gob, _ := v2.Encode(BlockVersionGob)
+	assert.Equal(t, gob, v2.SerializeFor(BlockVersion1), "Gob for a block version 1 can't hold")
+	gob, _ = v1.Encode(BlockVersionGob)
+	assert.Equal(t, gob, v1.SerializeFor(BlockVersionGob))
+
+	data = v2.Serialize()
+	_, err = DecodeBlock(append([]byte{MaxBlockVersion + 1}, data[1:]...))
+	assert.NotNil(t, err, "An unknown version")
+	_, err = DecodeBlock(data[:len(data)-1])
+	assert.NotNil(t, err, "Truncated")
+	_, err = DecodeBlock(append(data, 0))
+	assert.NotNil(t, err, "Trailing bytes")
+
+	// a peer can't send a difficulty Target can't shift by
+	v1.Difficulty = big.NewInt(maxDifficulty + 1)
+	for _, version := range []int{BlockVersionGob, BlockVersion1} {
+		data, err := v1.Encode(version)
+		assert.Nil(t, err)
+		_, err = DecodeBlock(data)
+		assert.Equal(t, ErrBadDifficulty, err)
+	}
+}
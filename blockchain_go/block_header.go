@@ -0,0 +1,204 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// headersBucket maps block hashes to block headers. It holds the header of
+// every stored block, and headers added with AddHeader beyond them
+const headersBucket = "headers"
+
+// MaxFutureBlockTime is how far ahead of the local clock a header may be
+// timestamped
+var MaxFutureBlockTime = 2 * time.Hour
+
+// BlockHeader is the part of a block its hash and proof of work cover. The
+// transactions are committed to by MerkleRoot
+type BlockHeader struct {
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     *big.Int
+	// Bits is the target of the block in compact form, see CompactToBig
+	Bits uint32
+	// Difficulty is the number of leading zero bits the hash of blocks
+	// mined before Bits had. Nil on newer blocks
+	Difficulty *big.Int
+	Nonce      int
+	Height     *big.Int
+	// UTXOCommitment is the commitment to the UTXO set the parent block
+	// left, see UTXOSet.Commitment. Empty below UTXOCommitmentHeight
+	UTXOCommitment []byte
+}
+
+// Hash returns the hash of the header, the hash of its block
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.prepareData(h.Nonce))
+
+	return hash[:]
+}
+
+// prepareData returns the data proof of work hashes with nonce
+func (h *BlockHeader) prepareData(nonce int) []byte {
+	// blocks without compact bits hash their difficulty in their place
+	bits := int64(h.Bits)
+	if bits == 0 && h.Difficulty != nil {
+		bits = h.Difficulty.Int64()
+	}
+	data := bytes.Join(
+		[][]byte{
+			h.PrevBlockHash,
+			h.MerkleRoot,
+			IntToHex(h.Timestamp.Int64()),
+			IntToHex(bits),
+			IntToHex(int64(nonce)),
+		},
+		[]byte{},
+	)
+	// blocks without a commitment hash as they did before it existed
+	if len(h.UTXOCommitment) > 0 {
+		data = append(data, h.UTXOCommitment...)
+	}
+
+	return data
+}
+
+// Serialize serializes the header
+func (h *BlockHeader) Serialize() []byte {
+	var result bytes.Buffer
+	err := gob.NewEncoder(&result).Encode(h)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// DeserializeHeader deserializes a header
+func DeserializeHeader(d []byte) *BlockHeader {
+	var header BlockHeader
+	err := gob.NewDecoder(bytes.NewReader(d)).Decode(&header)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return &header
+}
+
+// ValidateHeader checks a header against the header of its parent, without
+// the bodies: the linkage, the height, the timestamp, at most
+// MaxFutureBlockTime ahead of the network time, and the proof of work
+// against the header's own target. Whether that target is the one
+// retargeting gives, and the timestamp after the median time past, takes
+// the chain, see Blockchain.CalcNextBits and Blockchain.MedianTimePast
+func ValidateHeader(header, prevHeader *BlockHeader) error {
+	if !bytes.Equal(header.PrevBlockHash, prevHeader.Hash()) {
+		return fmt.Errorf("header doesn't link to parent %x", prevHeader.Hash())
+	}
+	if header.Height == nil || header.Height.Cmp(new(big.Int).Add(prevHeader.Height, big1)) != 0 {
+		return fmt.Errorf("header isn't at height %d", prevHeader.Height.Int64()+1)
+	}
+	if header.Timestamp == nil {
+		return ErrTimeTooOld
+	}
+	if tooNew(header) {
+		return ErrTimeTooNew
+	}
+	if new(big.Int).SetBytes(header.Hash()).Cmp(header.Target()) >= 0 {
+		return fmt.Errorf("header %x doesn't meet its target", header.Hash())
+	}
+
+	return nil
+}
+
+// putBlock stores a block and its header
+func putBlock(tx *bolt.Tx, block *Block) error {
+	if err := tx.Bucket([]byte(blocksBucket)).Put(block.Hash, block.Serialize()); err != nil {
+		return err
+	}
+	headers, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+	if err != nil {
+		return err
+	}
+	if err := headers.Put(block.Hash, block.BlockHeader.Serialize()); err != nil {
+		return err
+	}
+
+	return putMedianTime(tx, block.Hash)
+}
+
+// headerAt returns the stored header with the given hash, from the headers
+// bucket or, for blocks stored before it existed, the block
+func headerAt(tx *bolt.Tx, hash []byte) (*BlockHeader, error) {
+	if headers := tx.Bucket([]byte(headersBucket)); headers != nil {
+		if data := headers.Get(hash); data != nil {
+			return DeserializeHeader(data), nil
+		}
+	}
+	if data := tx.Bucket([]byte(blocksBucket)).Get(hash); data != nil {
+		return &DeserializeBlock(data).BlockHeader, nil
+	}
+
+	return nil, fmt.Errorf("header %x is not found", hash)
+}
+
+// GetHeader returns the header with the given hash, of a stored block or
+// added with AddHeader
+func (bc *Blockchain) GetHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		var err error
+		header, err = headerAt(tx, hash)
+		return err
+	})
+
+	return header, err
+}
+
+// AddHeader stores a header whose parent header is stored, once it passes
+// ValidateHeader, is timestamped after the median time past of its parent
+// and has the bits retargeting gives, so the chain of headers can run ahead
+// of the blocks. It returns the hash of the header
+func (bc *Blockchain) AddHeader(header *BlockHeader) ([]byte, error) {
+	parent, err := bc.GetHeader(header.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateHeader(header, parent); err != nil {
+		return nil, err
+	}
+	if err := bc.checkTimestamp(header); err != nil {
+		return nil, err
+	}
+	bits, err := bc.CalcNextBits(parent)
+	if err != nil {
+		return nil, err
+	}
+	if header.Bits != bits {
+		return nil, fmt.Errorf("header has bits %08x instead of %08x", header.Bits, bits)
+	}
+
+	hash := header.Hash()
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
+		headers, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+		if err := headers.Put(hash, header.Serialize()); err != nil {
+			return err
+		}
+		return putMedianTime(tx, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
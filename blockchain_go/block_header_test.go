@@ -0,0 +1,103 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockHeader(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		mined := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		assert.Equal(t, genesis.Hash, genesis.BlockHeader.Hash())
+		assert.Equal(t, mined.Hash, mined.BlockHeader.Hash())
+		assert.Equal(t, mined.HashTransactions(), mined.MerkleRoot)
+		assert.Equal(t, &mined.BlockHeader, DeserializeHeader(mined.BlockHeader.Serialize()))
+		header, err := bc.GetHeader(mined.Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, &mined.BlockHeader, header)
+
+		// blocks encoded before headers get their merkle root back
+		MerkleTreeHeight = math.MaxInt64
+		defer func() { MerkleTreeHeight = 0 }()
+		mined = mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		var legacy bytes.Buffer
+		assert.Nil(t, gob.NewEncoder(&legacy).Encode(struct {
+			Timestamp     *big.Int
+			Transactions  []*Transaction
+			PrevBlockHash []byte
+			Hash          []byte
+			Nonce         int
+			Height        *big.Int
+			Bits          uint32
+		}{mined.Timestamp, mined.Transactions, mined.PrevBlockHash, mined.Hash, mined.Nonce, mined.Height, mined.Bits}))
+		decoded := DeserializeBlock(legacy.Bytes())
+		assert.Equal(t, mined.MerkleRoot, decoded.MerkleRoot)
+		assert.Equal(t, mined.Hash, decoded.BlockHeader.Hash())
+	})
+}
+
+func TestValidateHeader(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		b1 := minedBlock(&genesis, address)
+		assert.Nil(t, ValidateHeader(&b1.BlockHeader, &genesis.BlockHeader))
+
+		header := b1.BlockHeader
+		header.PrevBlockHash = b1.Hash
+		assert.NotNil(t, ValidateHeader(&header, &genesis.BlockHeader), "Headers must link to their parent")
+		header = b1.BlockHeader
+		header.Height = big.NewInt(2)
+		assert.NotNil(t, ValidateHeader(&header, &genesis.BlockHeader), "Headers must be one above their parent")
+		header = b1.BlockHeader
+		header.Timestamp = big.NewInt(time.Now().Add(MaxFutureBlockTime + time.Hour).Unix())
+		assert.Equal(t, ErrTimeTooNew, ValidateHeader(&header, &genesis.BlockHeader), "Headers must not be far in the future")
+		header = b1.BlockHeader
+		header.Bits = 0x03000001
+		assert.NotNil(t, ValidateHeader(&header, &genesis.BlockHeader), "Headers must meet their target")
+	})
+}
+
+func TestAddHeader(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		b1 := minedBlock(&genesis, address)
+		b2 := minedBlock(b1, address)
+
+		// the headers run ahead of the blocks
+		_, err := bc.AddHeader(&b2.BlockHeader)
+		assert.NotNil(t, err, "The parent header must be stored")
+		for _, block := range []*Block{b1, b2} {
+			hash, err := bc.AddHeader(&block.BlockHeader)
+			assert.Nil(t, err)
+			assert.Equal(t, block.Hash, hash)
+		}
+		header, err := bc.GetHeader(b2.Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, &b2.BlockHeader, header)
+		_, err = bc.GetBlock(b2.Hash)
+		assert.NotNil(t, err)
+
+		b3 := forkBlock(b2, address)
+		b3.Bits = ActiveNetParams.PowLimitBits - 1
+		b3.Nonce, b3.Hash = NewProofOfWork(b3).Run()
+		_, err = bc.AddHeader(&b3.BlockHeader)
+		assert.NotNil(t, err, "Headers must have the bits retargeting gives")
+	})
+}
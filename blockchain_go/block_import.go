@@ -0,0 +1,106 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// BlockImporter connects blocks to the chain tip in batches. The blocks of
+// a batch are staged in memory, then stored with the chain tip and their
+// UTXO set changes in a single bolt transaction, so a crash mid-batch leaves
+// the chain, the set and BestBlock at the last block of the previous batch.
+// Blocks must be validated by the caller: ProcessBlock writes one block at
+// a time, VerifyBlock reading the set at its parent, while a reorganisation
+// reconnects a branch validated before in one batch
+type BlockImporter struct {
+	bc        *Blockchain
+	batchSize int
+	staged    []*Block
+	tip       []byte // the last block staged, or the chain tip
+	height    int64
+}
+
+// NewBlockImporter returns an importer writing batchSize blocks per bolt
+// transaction, or all of them on Flush when it isn't positive
+func NewBlockImporter(bc *Blockchain, batchSize int) *BlockImporter {
+	return &BlockImporter{bc: bc, batchSize: batchSize}
+}
+
+// Add stages a block, which must extend the last block staged or the chain
+// tip, and writes the batch once it is full
+func (im *BlockImporter) Add(block *Block) error {
+	if im.tip == nil {
+		height, tip, err := im.bc.GetBestHeightLastHash()
+		if err != nil {
+			return err
+		}
+		im.tip, im.height = tip, height.Int64()
+	}
+	if !bytes.Equal(block.PrevBlockHash, im.tip) || block.Height == nil || block.Height.Int64() != im.height+1 {
+		return fmt.Errorf("block %x doesn't extend block %x at height %d", block.Hash, im.tip, im.height)
+	}
+	im.staged = append(im.staged, block)
+	im.tip, im.height = block.Hash, block.Height.Int64()
+	if im.batchSize <= 0 || len(im.staged) < im.batchSize {
+		return nil
+	}
+
+	return im.Flush()
+}
+
+// Flush writes the staged blocks and sends them to the subscribers, see
+// SubscribeBlockConnected. On error none of them is written and they are
+// dropped, the next Add must extend the chain tip again
+func (im *BlockImporter) Flush() error {
+	if len(im.staged) == 0 {
+		return nil
+	}
+	staged := im.staged
+	im.staged = nil
+	last := staged[len(staged)-1].Hash
+
+	u := UTXOSet{Blockchain: im.bc}
+	err := u.write(func(tx *bolt.Tx, w *utxoWriter) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		if !bytes.Equal(blocks.Get([]byte("l")), staged[0].PrevBlockHash) {
+			return errors.New("the chain tip moved during the import")
+		}
+		undo, err := tx.CreateBucketIfNotExists([]byte(utxoUndoBucket))
+		if err != nil {
+			return err
+		}
+		heights, err := undoHeights(tx, undo)
+		if err != nil {
+			return err
+		}
+
+		for _, block := range staged {
+			if err := putBlock(tx, block); err != nil {
+				return err
+			}
+			if _, err := putChainWork(tx, block); err != nil {
+				return err
+			}
+			if err := applyBlock(w, undo, heights, block); err != nil {
+				return err
+			}
+		}
+		if err := putTip(tx, last); err != nil {
+			return err
+		}
+
+		return storeUTXOTip(tx, last)
+	})
+	if err != nil {
+		im.tip = nil
+		return err
+	}
+	im.bc.tip = last
+	u.pruneUndoInBackground()
+	im.bc.notifyConnected(staged...)
+
+	return nil
+}
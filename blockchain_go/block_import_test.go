@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockImporter(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		assert.Equal(t, bc.GenesisHash, mustTip(UTXOSet.BestBlock()))
+
+		blocks := syntheticBlocks(bc, 6)
+		importer := NewBlockImporter(bc, 3)
+		for _, block := range blocks[:5] {
+			assert.Nil(t, importer.Add(block))
+		}
+		assert.NotNil(t, importer.Add(blocks[0]), "Blocks must extend the last one staged")
+
+		// only the first batch is written, as after a crash mid-batch
+		height, tip := bestTip(bc)
+		assert.Equal(t, blocks[2].Hash, tip)
+		assert.Equal(t, int64(3), height.Int64())
+		assert.Equal(t, blocks[2].Hash, mustTip(UTXOSet.BestBlock()))
+		_, err := bc.GetBlock(blocks[3].Hash)
+		assert.NotNil(t, err, "Staged blocks aren't stored")
+		imported := utxoSnapshot(t, UTXOSet)
+		UTXOSet.Reindex()
+		assert.Equal(t, imported, utxoSnapshot(t, UTXOSet), "The set matches the stored chain")
+
+		// a batch that no longer extends the tip is dropped whole
+		other := NewBlockImporter(bc, 3)
+		assert.Nil(t, other.Add(blocks[3]))
+		assert.Nil(t, other.Flush())
+		assert.NotNil(t, importer.Flush())
+		_, tip = bestTip(bc)
+		assert.Equal(t, blocks[3].Hash, tip)
+		assert.Equal(t, blocks[3].Hash, mustTip(UTXOSet.BestBlock()))
+
+		assert.Nil(t, importer.Add(blocks[4]), "The importer continues from the chain tip")
+		assert.Nil(t, importer.Flush())
+		assert.Nil(t, importer.Flush(), "Nothing left to write")
+		imported = utxoSnapshot(t, UTXOSet)
+		UTXOSet.Reindex()
+		assert.Equal(t, imported, utxoSnapshot(t, UTXOSet))
+		assert.Equal(t, blocks[4].Hash, mustTip(UTXOSet.BestBlock()))
+
+		assert.Nil(t, UTXOSet.Undo(blocks[4]))
+		assert.Equal(t, blocks[3].Hash, mustTip(UTXOSet.BestBlock()))
+	})
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+)
+
+// MaxBlockHashes is the most hashes GetBlockHashes returns, what fits in
+// one inv message
+const MaxBlockHashes = 2000
+
+// BlockLocator returns the hashes a peer finds the fork point of the chain
+// with, highest first: the tip and the 9 blocks below it, then blocks
+// twice as far apart at each step, and the genesis block last
+func (bc *Blockchain) BlockLocator() ([][]byte, error) {
+	var locator [][]byte
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(heightBucket))
+		tipHeight, _ := bestFromIndex(tx)
+		if index == nil || tipHeight == nil {
+			return ErrNoBlockchain
+		}
+		step := int64(1)
+		for height := tipHeight.Int64(); height > 0; height -= step {
+			locator = append(locator, append([]byte(nil), index.Get(heightKey(height))...))
+			if len(locator) >= 10 {
+				step *= 2
+			}
+		}
+		locator = append(locator, append([]byte(nil), index.Get(heightKey(0))...))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return locator, nil
+}
+
+// GetBlockHashes returns the hashes of the blocks of the chain after the
+// highest block of locator it holds, or after the genesis block when it
+// holds none, lowest first: at most maxCount of them, MaxBlockHashes for 0
+// or more, and none after stopHash. A locator forking below the pruned
+// blocks gets none, they can't be served
+func (bc *Blockchain) GetBlockHashes(locator [][]byte, stopHash []byte, maxCount int) ([][]byte, error) {
+	if maxCount <= 0 || maxCount > MaxBlockHashes {
+		maxCount = MaxBlockHashes
+	}
+
+	var hashes [][]byte
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(heightBucket))
+		if index == nil {
+			return ErrNoBlockchain
+		}
+		start := int64(0)
+		for _, hash := range locator {
+			if height, ok := chainHeight(tx, hash); ok {
+				start = height
+				break
+			}
+		}
+		if start < prunedHeight(tx) {
+			return nil
+		}
+
+		c := index.Cursor()
+		for k, v := c.Seek(heightKey(start + 1)); k != nil && len(hashes) < maxCount; k, v = c.Next() {
+			hashes = append(hashes, append([]byte(nil), v...))
+			if bytes.Equal(v, stopHash) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// chainHeight returns the height of the block with the given hash when it is
+// in the chain, read from its header so pruned blocks are found too
+func chainHeight(tx *bolt.Tx, hash []byte) (int64, bool) {
+	index := tx.Bucket([]byte(heightBucket))
+	header, err := headerAt(tx, hash)
+	if index == nil || err != nil {
+		return 0, false
+	}
+	height := header.Height.Int64()
+
+	return height, bytes.Equal(index.Get(heightKey(height)), hash)
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// download syncs to from from as the p2p downloader does, asking for
+// maxCount hashes at a time until there are none left. It returns the
+// number of requests answered with hashes
+func download(t *testing.T, to, from *Blockchain, maxCount int) int {
+	requests := 0
+	for {
+		locator, err := to.BlockLocator()
+		assert.Nil(t, err)
+		hashes, err := from.GetBlockHashes(locator, nil, maxCount)
+		assert.Nil(t, err)
+		if len(hashes) == 0 {
+			return requests
+		}
+		assert.True(t, len(hashes) <= maxCount)
+		requests++
+		for _, hash := range hashes {
+			block, err := from.GetBlock(hash)
+			assert.Nil(t, err)
+			_, err = to.ProcessBlock(&block)
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestBlockLocator(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		for _, block := range syntheticBlocks(bc, 15) {
+			status, err := bc.AddBlock(block)
+			assert.Nil(t, err)
+			assert.Equal(t, BlockExtendsTip, status)
+		}
+
+		locator, err := bc.BlockLocator()
+		assert.Nil(t, err)
+		var heights []int64
+		for _, hash := range locator {
+			block, err := bc.GetBlock(hash)
+			assert.Nil(t, err)
+			heights = append(heights, block.Height.Int64())
+		}
+		assert.Equal(t, []int64{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 4, 0}, heights)
+
+		// the highest hash held is where the walk starts
+		hashes, err := bc.GetBlockHashes([][]byte{[]byte("unknown"), locator[5], locator[8]}, nil, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{locator[4], locator[3], locator[2], locator[1], locator[0]}, hashes)
+		hashes, err = bc.GetBlockHashes([][]byte{[]byte("unknown")}, nil, 3)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(hashes), "From the genesis block")
+		hashes, err = bc.GetBlockHashes(locator[11:], locator[9], 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 6, len(hashes), "Up to the stop hash")
+		assert.Equal(t, locator[9], hashes[5])
+	})
+}
+
+func TestGetBlockHashesSync(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		fresh := freshSync(t, bc, "fresh")
+		defer fresh.Db.Close()
+		for i := 0; i < 5; i++ {
+			mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		}
+		tipHeight, tip := bestTip(bc)
+
+		// a fresh node loops until the tip
+		assert.Equal(t, 3, download(t, fresh, bc, 2))
+		height, hash := bestTip(fresh)
+		assert.Equal(t, tipHeight, height)
+		assert.Equal(t, tip, hash)
+
+		// a node one block behind gets that block
+		mined := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		locator, err := fresh.BlockLocator()
+		assert.Nil(t, err)
+		hashes, err := bc.GetBlockHashes(locator, nil, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{mined.Hash}, hashes)
+		assert.Equal(t, 1, download(t, fresh, bc, 2))
+
+		// a node on a fork gets the blocks after the fork point and moves to
+		// the branch with more work
+		forked := mustMine(fresh, []*Transaction{NewCoinbaseTX(address, "")})
+		a := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		b := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		locator, err = fresh.BlockLocator()
+		assert.Nil(t, err)
+		assert.Equal(t, forked.Hash, locator[0])
+		hashes, err = bc.GetBlockHashes(locator, nil, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{a.Hash, b.Hash}, hashes)
+		assert.Equal(t, 1, download(t, fresh, bc, 2))
+		_, hash = bestTip(fresh)
+		assert.Equal(t, b.Hash, hash)
+	})
+}
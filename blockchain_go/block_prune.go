@@ -0,0 +1,193 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+)
+
+// Errors of a pruned chain. ErrBlockPruned is returned for a block of the
+// chain whose body PruneBlocks deleted, its header is still stored, and
+// ReindexContext returns ErrChainPruned once any body was
+var (
+	ErrBlockPruned = errors.New("the block body was pruned")
+	ErrChainPruned = errors.New("the chain is pruned, the UTXO set can't be rebuilt without a resync")
+)
+
+// prunedBucket holds under prunedHeightKey the height up to which the
+// bodies of the blocks of the chain were deleted, big endian
+const prunedBucket = "pruned"
+
+var prunedHeightKey = []byte("height")
+
+// PruneTarget is how many of the most recent block bodies PruneBlocks
+// keeps: Blocks bodies, or the bodies fitting in Bytes. The zero value keeps
+// them all
+type PruneTarget struct {
+	Blocks int64
+	Bytes  int64
+}
+
+// DefaultPrune is the prune target of the Blockchains opened, see SetPrune
+var DefaultPrune PruneTarget
+
+// ParsePruneTarget reads a prune target, a number of blocks like 1000 or of
+// megabytes like 550MB. An empty string or 0 keeps every body
+func ParsePruneTarget(s string) (PruneTarget, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return PruneTarget{}, nil
+	}
+	number, megabytes := s, false
+	if strings.HasSuffix(strings.ToUpper(s), "MB") {
+		number, megabytes = s[:len(s)-2], true
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return PruneTarget{}, fmt.Errorf("invalid prune target %q, want a number of blocks or megabytes like 550MB", s)
+	}
+	if megabytes {
+		return PruneTarget{Bytes: n << 20}, nil
+	}
+
+	return PruneTarget{Blocks: n}, nil
+}
+
+// SetPrune sets how many of the most recent block bodies are kept. The
+// bodies of the blocks whose undo records are kept, see SetUndoDepth, and of
+// the genesis block are never pruned
+func (bc *Blockchain) SetPrune(target PruneTarget) {
+	atomic.StoreInt64(&bc.pruneBlocks, target.Blocks)
+	atomic.StoreInt64(&bc.pruneBytes, target.Bytes)
+}
+
+// prunedHeight returns the height up to which bodies were pruned, 0 when
+// none were
+func prunedHeight(tx *bolt.Tx) int64 {
+	b := tx.Bucket([]byte(prunedBucket))
+	if b == nil {
+		return 0
+	}
+	data := b.Get(prunedHeightKey)
+	if len(data) != 8 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+// PrunedHeight returns the height up to which the bodies of the blocks of
+// the chain were deleted, 0 when the chain holds them all
+func (bc *Blockchain) PrunedHeight() (int64, error) {
+	var height int64
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		height = prunedHeight(tx)
+		return nil
+	})
+
+	return height, err
+}
+
+// bodyPruned reports whether the missing body of the block with the given
+// hash was pruned: the block is in the chain, at or below the pruned height
+func bodyPruned(tx *bolt.Tx, hash []byte) bool {
+	pruned := prunedHeight(tx)
+	headers := tx.Bucket([]byte(headersBucket))
+	index := tx.Bucket([]byte(heightBucket))
+	if pruned == 0 || headers == nil || index == nil {
+		return false
+	}
+	data := headers.Get(hash)
+	if data == nil {
+		return false
+	}
+	height := DeserializeHeader(data).Height.Int64()
+
+	return height <= pruned && bytes.Equal(index.Get(heightKey(height)), hash)
+}
+
+// PruneBlocks deletes the bodies of the blocks of the chain below the prune
+// target whose undo records were pruned, keeping their headers and height
+// index entries, and returns how many it deleted. GetBlock returns
+// ErrBlockPruned for them and the UTXO set can't be reindexed anymore
+func (bc *Blockchain) PruneBlocks() (int, error) {
+	target := PruneTarget{atomic.LoadInt64(&bc.pruneBlocks), atomic.LoadInt64(&bc.pruneBytes)}
+	if target.Blocks <= 0 && target.Bytes <= 0 {
+		return 0, nil
+	}
+
+	pruned := 0
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		index := tx.Bucket([]byte(heightBucket))
+		tipHeight, _ := bestFromIndex(tx)
+		horizon := undoPrunedHeight(tx.Bucket([]byte(utxoUndoHeightBucket)))
+		if index == nil || tipHeight == nil || horizon <= 0 {
+			return nil
+		}
+		from := prunedHeight(tx)
+		if target.Blocks > 0 && tipHeight.Int64()-target.Blocks < horizon {
+			horizon = tipHeight.Int64() - target.Blocks
+		}
+		if target.Bytes > 0 {
+			// the bodies from the tip down fitting in the target are kept
+			size := int64(0)
+			height := tipHeight.Int64()
+			for ; height > from; height-- {
+				size += int64(len(blocks.Get(index.Get(heightKey(height)))))
+				if size > target.Bytes {
+					break
+				}
+			}
+			if height < horizon {
+				horizon = height
+			}
+		}
+		if horizon <= from {
+			return nil
+		}
+
+		headers, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+		for height := from + 1; height <= horizon; height++ {
+			hash := append([]byte(nil), index.Get(heightKey(height))...)
+			data := blocks.Get(hash)
+			if data == nil {
+				continue
+			}
+			// blocks stored before the headers bucket have their header
+			// only in the body
+			if headers.Get(hash) == nil {
+				if err := headers.Put(hash, DeserializeBlock(data).BlockHeader.Serialize()); err != nil {
+					return err
+				}
+			}
+			if err := blocks.Delete(hash); err != nil {
+				return err
+			}
+			pruned++
+		}
+
+		b, err := tx.CreateBucketIfNotExists([]byte(prunedBucket))
+		if err != nil {
+			return err
+		}
+		var height [8]byte
+		binary.BigEndian.PutUint64(height[:], uint64(horizon))
+
+		return b.Put(prunedHeightKey, height[:])
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
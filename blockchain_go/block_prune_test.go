@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePruneTarget(t *testing.T) {
+	for s, want := range map[string]PruneTarget{
+		"":      {},
+		"0":     {},
+		"1000":  {Blocks: 1000},
+		"550MB": {Bytes: 550 << 20},
+		"2mb":   {Bytes: 2 << 20},
+	} {
+		target, err := ParsePruneTarget(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, target, s)
+	}
+	for _, s := range []string{"MB", "-1", "10GB", "ten"} {
+		_, err := ParsePruneTarget(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestPruneBlocks(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		bc.SetUndoDepth(2)
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		blocks := syntheticBlocks(bc, 8)
+		for _, block := range blocks {
+			status, err := bc.AddBlock(block)
+			assert.Nil(t, err)
+			assert.Equal(t, BlockExtendsTip, status)
+		}
+		u.UpdateBlocks(blocks)
+		assert.Nil(t, u.PruneUndo())
+
+		// without a target every body is kept
+		pruned, err := bc.PruneBlocks()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, pruned)
+
+		// the bodies below the last 3 go, heights 1 to 5
+		bc.SetPrune(PruneTarget{Blocks: 3})
+		_, err = bc.PruneBlocks()
+		assert.Nil(t, err)
+		height, err := bc.PrunedHeight()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(5), height)
+		info, err := bc.ChainInfo()
+		assert.Nil(t, err)
+		assert.True(t, info.Pruned)
+		assert.Equal(t, int64(5), info.PruneHeight)
+
+		for i, block := range blocks {
+			// the body a getdata for the block is answered with
+			_, err := bc.GetBlock(block.Hash)
+			header, headerErr := bc.GetHeader(block.Hash)
+			assert.Nil(t, headerErr)
+			assert.Equal(t, block.PrevBlockHash, header.PrevBlockHash)
+			byHeight, heightErr := bc.GetBlockByHeight(i + 1)
+			if i < 5 {
+				assert.Equal(t, ErrBlockPruned, err)
+				assert.Equal(t, ErrBlockPruned, heightErr)
+			} else {
+				assert.Nil(t, err)
+				assert.Nil(t, heightErr)
+				assert.Equal(t, block.Hash, byHeight.Hash)
+			}
+		}
+		_, err = bc.GetBlock(bc.GenesisHash)
+		assert.Nil(t, err, "The genesis block is kept")
+		_, err = bc.GetBlock([]byte("unknown"))
+		assert.Equal(t, ErrBlockNotFound, err)
+
+		// peers are offered the blocks still held only
+		hashes, err := bc.GetBlockHashes([][]byte{bc.GenesisHash}, nil, 0)
+		assert.Nil(t, err)
+		assert.Empty(t, hashes)
+		hashes, err = bc.GetBlockHashes([][]byte{blocks[4].Hash}, nil, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{blocks[5].Hash, blocks[6].Hash, blocks[7].Hash}, hashes)
+
+		assert.Equal(t, ErrChainPruned, u.ReindexContext(context.Background(), nil))
+		assert.Equal(t, ErrBlockPruned, bc.ForEachBlock(func(*Block) error { return nil }))
+
+		// the undo records of the last 2 blocks keep their bodies
+		bc.SetPrune(PruneTarget{Blocks: 1})
+		_, err = bc.PruneBlocks()
+		assert.Nil(t, err)
+		height, _ = bc.PrunedHeight()
+		assert.Equal(t, int64(6), height)
+	})
+}
+
+func TestPruneBlocksToSize(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		bc.SetUndoDepth(1)
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		blocks := syntheticBlocks(bc, 6)
+		for _, block := range blocks {
+			status, err := bc.AddBlock(block)
+			assert.Nil(t, err)
+			assert.Equal(t, BlockExtendsTip, status)
+		}
+		u.UpdateBlocks(blocks)
+		assert.Nil(t, u.PruneUndo())
+
+		// room for the last 2 bodies
+		size := int64(len(blocks[5].Serialize()) + len(blocks[4].Serialize()))
+		bc.SetPrune(PruneTarget{Bytes: size})
+		pruned, err := bc.PruneBlocks()
+		assert.Nil(t, err)
+		assert.Equal(t, 4, pruned)
+		_, err = bc.GetBlock(blocks[3].Hash)
+		assert.Equal(t, ErrBlockPruned, err)
+		_, err = bc.GetBlock(blocks[4].Hash)
+		assert.Nil(t, err)
+	})
+}
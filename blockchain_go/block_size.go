@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrBlockTooBig is returned for a block encoded in more than
+	// MaxBlockSerializedSize bytes
+	ErrBlockTooBig = errors.New("the block exceeds the maximum serialized size")
+	// ErrBlockTooManyTxs is returned for a block holding more than
+	// MaxBlockTxCount transactions
+	ErrBlockTooManyTxs = errors.New("the block holds more than the maximum number of transactions")
+)
+
+// CheckBlockSize checks a block against the MaxBlockTxCount and the
+// MaxBlockSerializedSize of the active network
+func CheckBlockSize(block *Block) error {
+	if len(block.Transactions) > ActiveNetParams.MaxBlockTxCount {
+		return ErrBlockTooManyTxs
+	}
+	if len(block.Serialize()) > ActiveNetParams.MaxBlockSerializedSize {
+		return ErrBlockTooBig
+	}
+
+	return nil
+}
+
+// encodedTxSize returns how many bytes tx takes in an encoded block: its
+// gob encoding without the type descriptions, which a block carries once.
+// It errs a few bytes large, for the length a message starts with
+func encodedTxSize(tx *Transaction) int {
+	var buff bytes.Buffer
+	enc := gob.NewEncoder(&buff)
+	if err := enc.Encode(tx); err != nil {
+		log.Panic(err)
+	}
+	described := buff.Len()
+	if err := enc.Encode(tx); err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Len() - described
+}
+
+// blockSizeSlack covers the growth of the transaction count in an encoded
+// block as transactions are added
+const blockSizeSlack = 16
+
+// templateSize returns the encoded size of a block holding only coinbase,
+// its header fields at their largest
+func templateSize(coinbase *Transaction) int {
+	hash := make([]byte, 32)
+	template := &Block{
+		BlockHeader: BlockHeader{
+			PrevBlockHash:  hash,
+			MerkleRoot:     hash,
+			Timestamp:      big.NewInt(time.Now().Unix()),
+			Bits:           math.MaxUint32,
+			Nonce:          math.MaxInt64,
+			Height:         big.NewInt(math.MaxInt64),
+			UTXOCommitment: hash,
+		},
+		Transactions: []*Transaction{coinbase},
+		Hash:         hash,
+		ReceivedAt:   time.Now(),
+	}
+
+	return len(template.Serialize()) + blockSizeSlack
+}
+
+// SelectTransactions returns the transactions of candidates a block mined
+// with coinbase has room for, after the coinbase, by fee rate, the highest
+// first. A candidate spending another goes after it, the other pulled ahead
+// if its rate is lower. It stops at the first that would take the block
+// past the MaxBlockSerializedSize or the MaxBlockTxCount of the active
+// network. Candidates whose inputs resolve neither in the set nor to
+// another candidate are left out, and so are those spending an output a
+// candidate before them spends
+func (u UTXOSet) SelectTransactions(candidates []*Transaction, coinbase *Transaction) ([]*Transaction, error) {
+	type candidate struct {
+		tx      *Transaction
+		fee     int
+		size    int
+		parents []string // IDs of the candidates it spends
+	}
+	created := make(map[string][]TXOutput)
+	for _, tx := range candidates {
+		created[string(tx.ID)] = tx.Vout
+	}
+	var pool []*candidate
+	byID := make(map[string]*candidate)
+	u.Blockchain.utxoMu.RLock()
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(dbTx *bolt.Tx) error {
+		b := dbTx.Bucket([]byte(utxoBucket))
+		for _, tx := range candidates {
+			fee, err := txFee(b, cache, gen, created, make(OutpointSet), tx)
+			if err != nil {
+				continue
+			}
+			c := &candidate{tx: tx, fee: fee, size: encodedTxSize(tx)}
+			for _, vin := range tx.Vin {
+				if _, ok := created[string(vin.Txid)]; ok {
+					c.parents = append(c.parents, string(vin.Txid))
+				}
+			}
+			pool = append(pool, c)
+			byID[string(tx.ID)] = c
+		}
+		return nil
+	})
+	u.Blockchain.utxoMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	// fee/size above the other's, multiplied out
+	sort.SliceStable(pool, func(i, j int) bool {
+		return int64(pool[i].fee)*int64(pool[j].size) > int64(pool[j].fee)*int64(pool[i].size)
+	})
+
+	// parents first
+	var ordered []*candidate
+	visited := make(map[*candidate]bool)
+	var visit func(c *candidate)
+	visit = func(c *candidate) {
+		if visited[c] {
+			return
+		}
+		visited[c] = true
+		for _, id := range c.parents {
+			if parent, ok := byID[id]; ok {
+				visit(parent)
+			}
+		}
+		ordered = append(ordered, c)
+	}
+	for _, c := range pool {
+		visit(c)
+	}
+
+	var selected []*Transaction
+	in := make(map[string]bool)
+	spent := make(OutpointSet)
+	size := templateSize(coinbase)
+	for _, c := range ordered {
+		skip := false
+		for _, id := range c.parents {
+			skip = skip || !in[id]
+		}
+		for _, vin := range c.tx.Vin {
+			skip = skip || spent.Has(vin.Txid, vin.Vout)
+		}
+		if skip {
+			continue
+		}
+		if len(selected)+2 > ActiveNetParams.MaxBlockTxCount || size+c.size > ActiveNetParams.MaxBlockSerializedSize {
+			break
+		}
+		selected = append(selected, c.tx)
+		in[string(c.tx.ID)] = true
+		spent.AddInputs(c.tx)
+		size += c.size
+	}
+
+	return append([]*Transaction{coinbase}, selected...), nil
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withParams runs f with a copy of the active network parameters changed
+// by change
+func withParams(change func(params *NetParams), f func()) {
+	defer func(params *NetParams) { ActiveNetParams = params }(ActiveNetParams)
+	params := *ActiveNetParams
+	change(&params)
+	ActiveNetParams = &params
+	f()
+}
+
+func TestCheckBlockSize(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		block := minedBlock(&genesis, address, NewCoinbaseTX(address, ""))
+		size := len(block.Serialize())
+
+		withParams(func(params *NetParams) { params.MaxBlockSerializedSize = size }, func() {
+			assert.Nil(t, CheckBlockSize(block), "A block at the limit is valid")
+		})
+		withParams(func(params *NetParams) { params.MaxBlockSerializedSize = size - 1 }, func() {
+			assert.Equal(t, ErrBlockTooBig, CheckBlockSize(block), "A byte over isn't")
+			valid, reason := bc.IsBlockValid(block)
+			assert.False(t, valid)
+			assert.Equal(t, 13, reason)
+			// side blocks and orphans too
+			mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+			side := minedBlock(&genesis, address, NewCoinbaseTX(address, ""), NewCoinbaseTX(address, ""))
+			_, err := bc.ProcessBlock(side)
+			assert.Equal(t, ErrBlockTooBig, err)
+			orphan := minedBlock(minedBlock(side, address), address, NewCoinbaseTX(address, ""), NewCoinbaseTX(address, ""))
+			assert.Equal(t, ErrBlockTooBig, NewOrphanPool(10, time.Hour).Process(bc, orphan, nil))
+		})
+
+		withParams(func(params *NetParams) { params.MaxBlockTxCount = 2 }, func() {
+			assert.Nil(t, CheckBlockSize(block))
+		})
+		withParams(func(params *NetParams) { params.MaxBlockTxCount = 1 }, func() {
+			assert.Equal(t, ErrBlockTooManyTxs, CheckBlockSize(block))
+		})
+	})
+}
+
+func TestSelectTransactions(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		wallet := ws.Wallets[address]
+		bc := newTestChain(address, address, address, address)
+		defer bc.Db.Close()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+
+		// a spend of each coinbase, paying a fee of 1, 2, 3 and 4
+		var spends []*Transaction
+		fee := 1
+		for bci := bc.Iterator(); ; fee++ {
+			block, err := bci.Next()
+			assert.Nil(t, err)
+			coinbase := block.Transactions[0]
+			tx := &Transaction{
+				Vin:  []TXInput{{coinbase.ID, 0, nil, wallet.PublicKey}},
+				Vout: []TXOutput{{subsidy - fee, HashPubKey(wallet.PublicKey)}},
+			}
+			tx.ID = tx.Hash()
+			spends = append(spends, tx)
+			if len(block.PrevBlockHash) == 0 {
+				break
+			}
+		}
+		unknown := &Transaction{ID: []byte("unknown"), Vin: []TXInput{{[]byte("unknown"), 0, nil, wallet.PublicKey}}}
+		coinbase := NewCoinbaseTX(address, "")
+		candidates := append([]*Transaction{unknown}, spends...)
+
+		selected := mustTxs(u.SelectTransactions(candidates, coinbase))
+		assert.Equal(t, []*Transaction{coinbase, spends[3], spends[2], spends[1], spends[0]}, selected, "The highest fee rate goes first")
+
+		withParams(func(params *NetParams) { params.MaxBlockTxCount = 3 }, func() {
+			assert.Equal(t, []*Transaction{coinbase, spends[3], spends[2]}, mustTxs(u.SelectTransactions(candidates, coinbase)))
+		})
+
+		// the estimate holds the block under the limit
+		block := &Block{BlockHeader: genesis.BlockHeader, Transactions: selected}
+		limit := len(block.Serialize()) + 8
+		withParams(func(params *NetParams) { params.MaxBlockSerializedSize = limit }, func() {
+			picked := mustTxs(u.SelectTransactions(candidates, coinbase))
+			assert.True(t, len(picked) < len(selected))
+			block.Transactions = picked
+			assert.Nil(t, CheckBlockSize(block))
+		})
+	})
+}
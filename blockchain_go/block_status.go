@@ -0,0 +1,62 @@
+package core
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+)
+
+// BlockStatus is where a block received stands against the chain, what
+// AddBlock and ClassifyBlock return
+type BlockStatus string
+
+// Kinds of BlockStatus
+const (
+	BlockDuplicate  = BlockStatus("duplicate")   // stored already, pruned or not
+	BlockExtendsTip = BlockStatus("extends-tip") // its parent is the tip
+	BlockSideChain  = BlockStatus("side-chain")  // its parent is stored, not the tip
+	BlockOrphan     = BlockStatus("orphan")      // its parent isn't stored
+)
+
+// classifyBlock returns the status of block, looked up by hash without
+// decoding it or any stored block. A header added with AddHeader alone
+// doesn't make it a duplicate, its body is still to be stored
+func classifyBlock(tx *bolt.Tx, block *Block) BlockStatus {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	if blocks.Get(block.Hash) != nil || bodyPruned(tx, block.Hash) {
+		return BlockDuplicate
+	}
+	if bytes.Equal(block.PrevBlockHash, blocks.Get([]byte("l"))) {
+		return BlockExtendsTip
+	}
+	if blocks.Get(block.PrevBlockHash) != nil || bodyPruned(tx, block.PrevBlockHash) {
+		return BlockSideChain
+	}
+
+	return BlockOrphan
+}
+
+// ClassifyBlock returns the status of block, for the p2p layer to ignore a
+// block sent again before validating it, ask for the parents of an orphan,
+// or process the others
+func (bc *Blockchain) ClassifyBlock(block *Block) (BlockStatus, error) {
+	var status BlockStatus
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		status = classifyBlock(tx, block)
+		return nil
+	})
+
+	return status, err
+}
+
+// HasBlock tells whether the block of hash is stored, pruned or not, for the
+// p2p layer to ask only for the blocks it lacks
+func (bc *Blockchain) HasBlock(hash []byte) (bool, error) {
+	var has bool
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		has = tx.Bucket([]byte(blocksBucket)).Get(hash) != nil || bodyPruned(tx, hash)
+		return nil
+	})
+
+	return has, err
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddBlockStatus(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		blocks := syntheticBlocks(bc, 2)
+		add := func(block *Block) BlockStatus {
+			status, err := bc.AddBlock(block)
+			assert.Nil(t, err)
+			return status
+		}
+
+		assert.Equal(t, BlockExtendsTip, add(blocks[0]))
+		assert.Equal(t, BlockDuplicate, add(blocks[0]))
+		assert.Equal(t, BlockExtendsTip, add(blocks[1]))
+		// the tip sent again changes nothing
+		height, tip := bestTip(bc)
+		assert.Equal(t, BlockDuplicate, add(blocks[1]))
+		status, err := bc.ClassifyBlock(blocks[1])
+		assert.Nil(t, err)
+		assert.Equal(t, BlockDuplicate, status)
+		has, err := bc.HasBlock(blocks[1].Hash)
+		assert.Nil(t, err)
+		assert.True(t, has)
+
+		// a side branch higher than the chain leaves the tip and the heights
+		side := forkBlock(blocks[0], address)
+		assert.Equal(t, BlockSideChain, add(side))
+		higher := forkBlock(side, address)
+		assert.Equal(t, BlockSideChain, add(higher))
+		newHeight, newTip := bestTip(bc)
+		assert.Equal(t, height, newHeight)
+		assert.Equal(t, tip, newTip)
+		atTip, err := bc.GetBlockByHeight(int(height.Int64()))
+		assert.Nil(t, err)
+		assert.Equal(t, tip, atTip.Hash)
+
+		// an orphan isn't stored
+		orphan := forkBlock(forkBlock(higher, address), address)
+		assert.Equal(t, BlockOrphan, add(orphan))
+		_, err = bc.GetBlock(orphan.Hash)
+		assert.Equal(t, ErrBlockNotFound, err)
+		status, err = bc.ClassifyBlock(orphan)
+		assert.Nil(t, err)
+		assert.Equal(t, BlockOrphan, status)
+		has, err = bc.HasBlock(orphan.Hash)
+		assert.Nil(t, err)
+		assert.False(t, has)
+	})
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"math/big"
+)
+
+// Reasons VerifyBlock refuses a block, each its own so a peer serving such
+// blocks can be told apart. The timestamp, size and coinbase checks return
+// ErrTimeTooOld, ErrTimeTooNew, ErrBlockTooBig, ErrBlockTooManyTxs,
+// ErrCoinbaseCount and ErrCoinbaseOverpays
+var (
+	ErrBadPrevBlock      = errors.New("the block doesn't link to its parent")
+	ErrBadHeight         = errors.New("the block isn't at the height after its parent")
+	ErrBadBits           = errors.New("the block doesn't have the bits retargeting gives")
+	ErrBadBlockHash      = errors.New("the block doesn't hash to its hash")
+	ErrBadProofOfWork    = errors.New("the block doesn't meet its target")
+	ErrBadMerkleRoot     = errors.New("the merkle root of the block doesn't commit to its transactions")
+	ErrBadTxID           = errors.New("a transaction of the block doesn't hash to its ID")
+	ErrNoCoinbase        = errors.New("the first transaction of the block isn't a coinbase")
+	ErrDuplicateTx       = errors.New("the block holds a transaction twice")
+	ErrBadTxInputs       = errors.New("a transaction of the block spends outputs it can't")
+	ErrBadTxSignature    = errors.New("a transaction of the block has an invalid signature")
+	ErrBadTxAddress      = errors.New("a transaction of the block sends to its own input key")
+	ErrBadUTXOCommitment = errors.New("the block doesn't commit to the UTXO set of its parent")
+	// ErrPrevNotTip is returned by VerifyBlock for a block not extending the
+	// tip, whose transactions the UTXO set can't check
+	ErrPrevNotTip = errors.New("the parent of the block isn't the tip")
+)
+
+// blockInvalidReasons maps the errors of VerifyBlock, and ErrInvalidAncestor,
+// to the reasons IsBlockValid returns, 1 standing for any other
+var blockInvalidReasons = map[error]int{
+	ErrBadHeight:         2,
+	ErrBadPrevBlock:      3,
+	ErrBadBlockHash:      4,
+	ErrBadProofOfWork:    5,
+	ErrNoCoinbase:        6,
+	ErrCoinbaseCount:     6,
+	ErrBadTxInputs:       6,
+	ErrCoinbaseOverpays:  6,
+	ErrBadTxAddress:      7,
+	ErrBadUTXOCommitment: 8,
+	ErrBadBits:           9,
+	ErrBadMerkleRoot:     10,
+	ErrTimeTooOld:        11,
+	ErrTimeTooNew:        12,
+	ErrBlockTooBig:       13,
+	ErrBlockTooManyTxs:   13,
+	ErrBadTxSignature:    14,
+	ErrDuplicateTx:       15,
+	ErrInvalidAncestor:   16,
+	ErrBadTxID:           17,
+}
+
+// IsInvalidBlock reports whether err is VerifyBlock refusing a block, rather
+// than failing to read the chain
+func IsInvalidBlock(err error) bool {
+	_, ok := blockInvalidReasons[err]
+	return ok
+}
+
+// VerifyBlock checks a block extending prev, the tip, against the consensus
+// rules and the UTXO set at prev: its inputs, signatures, fees and UTXO
+// commitment. A failure to read the chain or the set is returned as is
+func (bc *Blockchain) VerifyBlock(block *Block, prev *Block) error {
+	if err := bc.verifyBlockAgainstParent(block, prev); err != nil {
+		return err
+	}
+	if !bytes.Equal(prev.Hash, bc.tip) {
+		return ErrPrevNotTip
+	}
+
+	u := UTXOSet{Blockchain: bc}
+	fees, err := u.BlockFees(block.Transactions)
+	if _, ok := err.(*TxInputsError); ok {
+		log.Printf("block %x: %v", block.Hash, err)
+		return ErrBadTxInputs
+	}
+	if err != nil {
+		// reading the set failed, the block may well be valid
+		return err
+	}
+	skipSigs := SkipSigsBelowCheckpoint && block.Height.Int64() <= ActiveNetParams.LastCheckpointHeight()
+	if !skipSigs {
+		if err := bc.verifyBlockSignatures(block); err != nil {
+			log.Printf("block %x: %v", block.Hash, err)
+			return ErrBadTxSignature
+		}
+	}
+	for _, tx := range block.Transactions {
+		if !VeryfyFromToAddress(tx) {
+			return ErrBadTxAddress
+		}
+	}
+
+	paid := 0
+	for _, vout := range block.Transactions[0].Vout {
+		paid += vout.Value
+	}
+	if paid > BlockSubsidy(block.Height.Int64())+fees {
+		return ErrCoinbaseOverpays
+	}
+
+	// the set is at the parent block
+	if commitsUTXOSet(block.Height) {
+		commitment, err := u.Commitment()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(commitment, block.UTXOCommitment) {
+			return ErrBadUTXOCommitment
+		}
+	}
+
+	return nil
+}
+
+// verifyBlockAgainstParent runs the checks of VerifyBlock the UTXO set
+// isn't needed for, which a block of a side branch gets until the chain is
+// reorganised to it
+func (bc *Blockchain) verifyBlockAgainstParent(block, prev *Block) error {
+	if !bytes.Equal(block.PrevBlockHash, prev.Hash) {
+		return ErrBadPrevBlock
+	}
+	if block.Height == nil || block.Height.Cmp(new(big.Int).Add(prev.Height, big1)) != 0 {
+		return ErrBadHeight
+	}
+
+	bits, err := bc.CalcNextBits(&prev.BlockHeader)
+	if err != nil {
+		return err
+	}
+	if block.Bits != bits {
+		return ErrBadBits
+	}
+	// the header is hashed with its timestamp
+	if block.Timestamp == nil {
+		return ErrTimeTooOld
+	}
+	hash, pow := calculateHash(block)
+	if !bytes.Equal(hash, block.Hash) {
+		return ErrBadBlockHash
+	}
+	if new(big.Int).SetBytes(hash).Cmp(pow.target) >= 0 {
+		return ErrBadProofOfWork
+	}
+
+	if err := bc.checkTimestamp(&block.BlockHeader); err != nil {
+		return err
+	}
+	if err := CheckBlockSize(block); err != nil {
+		return err
+	}
+
+	// the merkle leaves are the IDs, a field not covered by a signature
+	// could be changed otherwise
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, tx.computeID()) {
+			return ErrBadTxID
+		}
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ErrBadMerkleRoot
+	}
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return ErrNoCoinbase
+	}
+	seen := make(map[string]bool, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ErrCoinbaseCount
+		}
+		if seen[string(tx.ID)] {
+			return ErrDuplicateTx
+		}
+		seen[string(tx.ID)] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyBlock(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		wallet := ws.Wallets[address]
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		tip, _ := bc.GetBlock(bc.tip)
+		send, err := NewUTXOTransaction(wallet, address, 1, &u, nil, 1)
+		assert.Nil(t, err)
+
+		// build returns a block on the tip with txs after its coinbase,
+		// changed by tamper before it's mined
+		build := func(tamper func(b *Block), txs ...*Transaction) *Block {
+			block := forkBlock(&tip, address, txs...)
+			block.Bits = ActiveNetParams.PowLimitBits
+			if tamper != nil {
+				tamper(block)
+			}
+			block.MerkleRoot = block.HashTransactions()
+			block.Nonce, block.Hash = NewProofOfWork(block).Run()
+			return block
+		}
+		assert.Nil(t, bc.VerifyBlock(build(nil, send), &tip))
+
+		signed := func(tx *Transaction) *Transaction {
+			tx.ID = tx.Hash()
+			signer, err := wallet.Signer()
+			assert.Nil(t, err)
+			prev := *genesis.Transactions[0]
+			assert.Nil(t, tx.Sign(signer, map[string]Transaction{hex.EncodeToString(prev.ID): prev}))
+			return tx
+		}
+		toItself := signed(&Transaction{
+			Vin:  []TXInput{{genesis.Transactions[0].ID, 0, nil, wallet.PublicKey}},
+			Vout: []TXOutput{{1, wallet.PublicKey}},
+		})
+		unknown := &Transaction{
+			Vin:  []TXInput{{[]byte("unknown"), 0, nil, wallet.PublicKey}},
+			Vout: []TXOutput{{1, HashPubKey(wallet.PublicKey)}},
+		}
+		unknown.ID = unknown.Hash()
+		forged := *send
+		forged.Vin = append([]TXInput(nil), send.Vin...)
+		forged.Vin[0].Signature = append([]byte(nil), send.Vin[0].Signature...)
+		forged.Vin[0].Signature[0] ^= 0xff
+
+		for _, test := range []struct {
+			err    error
+			tamper func(b *Block)
+			txs    []*Transaction
+		}{
+			{ErrBadHeight, func(b *Block) { b.Height = big.NewInt(5) }, nil},
+			{ErrBadBits, func(b *Block) { b.Bits-- }, nil},
+			{ErrTimeTooOld, func(b *Block) { b.Timestamp = genesis.Timestamp }, nil},
+			{ErrNoCoinbase, func(b *Block) { b.Transactions = b.Transactions[1:] }, []*Transaction{send}},
+			{ErrBadTxID, func(b *Block) { b.Transactions[0].Vout[0].Value++ }, nil},
+			{ErrCoinbaseCount, nil, []*Transaction{NewCoinbaseTX(address, "")}},
+			{ErrDuplicateTx, nil, []*Transaction{send, send}},
+			{ErrBadTxInputs, nil, []*Transaction{unknown}},
+			{ErrBadTxSignature, nil, []*Transaction{&forged}},
+			{ErrBadTxAddress, nil, []*Transaction{toItself}},
+			{ErrCoinbaseOverpays, func(b *Block) {
+				b.Transactions[0] = NewCoinbaseTXValue(address, "", subsidy+2)
+			}, []*Transaction{send}},
+		} {
+			assert.Equal(t, test.err, bc.VerifyBlock(build(test.tamper, test.txs...), &tip))
+		}
+		withParams(func(params *NetParams) { params.MaxBlockTxCount = 1 }, func() {
+			assert.Equal(t, ErrBlockTooManyTxs, bc.VerifyBlock(build(nil, send), &tip))
+		})
+
+		// changed once mined
+		block := build(nil, send)
+		block.PrevBlockHash = genesis.Hash
+		assert.Equal(t, ErrBadPrevBlock, bc.VerifyBlock(block, &tip))
+		block = build(nil, send)
+		block.Nonce++
+		assert.Equal(t, ErrBadBlockHash, bc.VerifyBlock(block, &tip))
+		block = build(nil, send)
+		for {
+			block.Nonce++
+			hash, pow := calculateHash(block)
+			if new(big.Int).SetBytes(hash).Cmp(pow.target) >= 0 {
+				block.Hash = hash
+				break
+			}
+		}
+		assert.Equal(t, ErrBadProofOfWork, bc.VerifyBlock(block, &tip))
+		block = build(nil, send)
+		block.Transactions = block.Transactions[:1]
+		block.Nonce, block.Hash = NewProofOfWork(block).Run()
+		assert.Equal(t, ErrBadMerkleRoot, bc.VerifyBlock(block, &tip))
+		side := minedBlock(&genesis, address)
+		assert.Nil(t, bc.verifyBlockAgainstParent(side, &genesis))
+		assert.Equal(t, ErrPrevNotTip, bc.VerifyBlock(side, &genesis))
+
+		// what peers send and what is mined
+		_, err = bc.ProcessBlock(build(nil, &forged))
+		assert.Equal(t, ErrBadTxSignature, err)
+		assert.True(t, IsInvalidBlock(err))
+		assert.False(t, IsInvalidBlock(ErrOrphanBlock))
+		_, err = bc.MineBlockContext(context.Background(), []*Transaction{send})
+		assert.Equal(t, ErrNoCoinbase, err, "The miner checks its block")
+		_, err = bc.ProcessBlock(build(nil, send))
+		assert.Nil(t, err)
+	})
+}
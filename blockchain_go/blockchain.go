@@ -2,17 +2,19 @@ package core
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/boltdb/bolt"
 	"strings"
 	"math/big"
 	"crypto/sha256"
+	"sync"
 )
 
 const dbFile = "blockchain_%s.db"
@@ -26,199 +28,301 @@ type Blockchain struct {
 	GenesisHash []byte
 	tip []byte
 	Db  *bolt.DB
+
+	utxoCache *utxoCache
+	utxoMu    sync.RWMutex // held for writing while the UTXO set changes
+
+	undoDepth   int64 // see SetUndoDepth
+	undoPruning int32 // set while PruneUndo runs in the background
+
+	pruneBlocks, pruneBytes int64 // see SetPrune
+
+	reorgMu   sync.Mutex
+	reorgSubs []chan<- ReorgEvent // see SubscribeReorgs
 }
 
 func genBlockChainDbName(nodeID string)string{
+	return DataPath(blockChainDbFile(nodeID));
+}
+
+// blockChainDbFile returns the name of the database of nodeID
+func blockChainDbFile(nodeID string) string {
 	nodeID = strings.Replace(nodeID, ":", "_", -1)
-	dbFile := fmt.Sprintf(dbFile, nodeID)
 
-	return dbFile;
+	return fmt.Sprintf(dbFile, nodeID)
 }
 
+// Errors of the Blockchain methods, for the cases callers tell apart. The
+// transaction lookups return ErrTxNotFound
+var (
+	ErrBlockNotFound    = errors.New("block is not found")
+	ErrNoBlockchain     = errors.New("no existing blockchain found, create one first")
+	ErrBlockchainExists = errors.New("blockchain already exists")
+	// ErrDBClosed is returned by the methods of a Blockchain whose database
+	// was closed
+	ErrDBClosed = bolt.ErrDatabaseNotOpen
+)
+
 // CreateBlockchain creates a new blockchain DB
-func CreateBlockchain(address, nodeID string) *Blockchain {
+func CreateBlockchain(address, nodeID string) (*Blockchain, error) {
 	dbFile := genBlockChainDbName(nodeID)
 	fmt.Printf("Blockchain file %s\n",dbFile)
 	if dbExists(dbFile) {
-		fmt.Println("Blockchain already exists.")
-		os.Exit(1)
+		return nil, ErrBlockchainExists
 	}
 
 	var tip []byte
 	var genesisHash []byte
 
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-	genesis := NewGenesisBlock(cbtx)
+	genesis := ActiveNetParams.Genesis.Block(address)
 
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := openDB(dbFile, false, 0)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		if TxIndexEnabled {
+			if _, err := tx.CreateBucket([]byte(txIndexBucket)); err != nil {
+				return err
+			}
 		}
 
-		err = b.Put(genesis.Hash, genesis.Serialize())
+		err = putBlock(tx, genesis)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		err = b.Put([]byte("l"), genesis.Hash)
+		err = putTip(tx, genesis.Hash)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 		tip = genesis.Hash
 
 		err = b.Put([]byte("g"), genesis.Hash)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 		genesisHash = genesis.Hash
 
-		return nil
+		err = b.Put([]byte(genesisConfigKey), ActiveNetParams.Genesis.Hash())
+		if err != nil {
+			return err
+		}
+
+		_, err = putChainWork(tx, genesis)
+		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	bc := Blockchain{genesisHash,tip, db}
-	return &bc
+	bc := Blockchain{GenesisHash: genesisHash, tip: tip, Db: db, utxoCache: newUTXOCache(DefaultUTXOCacheSize), undoDepth: int64(DefaultUndoDepth), pruneBlocks: DefaultPrune.Blocks, pruneBytes: DefaultPrune.Bytes}
+	return &bc, nil
 }
 
 // NewBlockchain creates a new Blockchain with genesis Block
-func NewBlockchain(nodeID string) *Blockchain {
-	var dbFile = genBlockChainDbName(nodeID)
+func NewBlockchain(nodeID string) (*Blockchain, error) {
+	return NewBlockchainWithOptions(DataDir(), Options{NodeID: nodeID})
+}
+
+// NewBlockchainWithOptions opens the chain of opts.NodeID in dataDir, the
+// data directory for "". A read-only open neither upgrades nor recovers the
+// database, it returns ErrDBNeedsWrite when it must be
+func NewBlockchainWithOptions(dataDir string, opts Options) (*Blockchain, error) {
+	if dataDir == "" {
+		dataDir = DataDir()
+	}
+	var dbFile = filepath.Join(dataDir, blockChainDbFile(opts.NodeID))
 	if dbExists(dbFile) == false {
-		fmt.Println("No existing blockchain found. Create one first.")
-		os.Exit(1)
+		return nil, ErrNoBlockchain
 	}
 
 	fmt.Println("--- bf Open dbFile:")
 	var tip []byte
 	var genesisHash []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := openDB(dbFile, opts.ReadOnly, opts.Timeout)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	fmt.Println("--- bf db.View:")
 	err = db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
-		genesisHash = b.Get([]byte("g"))
+		if b == nil {
+			return ErrNoBlockchain
+		}
+		// bolt values are only valid inside the transaction
+		tip = append([]byte(nil), b.Get([]byte("l"))...)
+		genesisHash = append([]byte(nil), b.Get([]byte("g"))...)
 		return nil
 	})
+	if err == nil && opts.ReadOnly {
+		err = checkReadOnlyDB(db)
+	} else if err == nil {
+		err = openBlockchainDB(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	bc := Blockchain{GenesisHash: genesisHash, tip: tip, Db: db, utxoCache: newUTXOCache(DefaultUTXOCacheSize), undoDepth: int64(DefaultUndoDepth), pruneBlocks: DefaultPrune.Blocks, pruneBytes: DefaultPrune.Bytes}
+	if opts.ReadOnly {
+		return &bc, nil
+	}
+	// a reorganisation or a block the node went down in the middle of
+	err = bc.resumeReorg()
+	if err == nil {
+		err = bc.recoverUTXOSet()
+	}
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	bc := Blockchain{genesisHash,tip, db}
+	return &bc, nil
+}
+
+// openBlockchainDB checks the database is of the active network, then
+// indexes and hashes the UTXO set if it was written before they existed
+func openBlockchainDB(db *bolt.DB) error {
+	if err := checkGenesisConfig(db); err != nil {
+		return err
+	}
+	if err := ensureAddrIndex(db); err != nil {
+		return err
+	}
+	if err := ensureUTXOHash(db); err != nil {
+		return err
+	}
+	if err := ensureHeightIndex(db); err != nil {
+		return err
+	}
+	if err := ensureChainState(db); err != nil {
+		return err
+	}
+	if TxIndexEnabled {
+		return ensureTxIndex(db)
+	}
 
-	return &bc
+	return nil
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
+// AddBlock stores a block without validating it, see ProcessBlock, and
+// returns its status. A block extending the tip becomes the tip, one of a
+// side branch is only stored, whatever its height. Neither a block stored
+// already, the tip included, nor an orphan is written
+func (bc *Blockchain) AddBlock(block *Block) (BlockStatus, error) {
+	status, err := bc.ClassifyBlock(block)
+	if err != nil || status == BlockDuplicate || status == BlockOrphan {
+		return status, err
+	}
 
-		if blockInDb != nil {
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
+		// another writer may have stored it or moved the tip meanwhile
+		status = classifyBlock(tx, block)
+		if status == BlockDuplicate || status == BlockOrphan {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
-		if err != nil {
-			log.Panic(err)
+		err := putBlock(tx, block)
+		if err != nil || status != BlockExtendsTip {
+			return err
 		}
-
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
-
-		if block.Height.Cmp(lastBlock.Height) > 0 {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			bc.tip = block.Hash
+		err = putTip(tx, block.Hash)
+		if err != nil {
+			return err
 		}
+		bc.tip = block.Hash
 
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
+	if err == nil && status == BlockExtendsTip {
+		bc.notifyConnected(block)
 	}
+
+	return status, err
 }
 
-// FindTransaction finds a transaction by its ID
+// FindTransaction finds a transaction by its ID, see FindTransactionBlock
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
-		var ids = hex.EncodeToString(ID)
-		fmt.Printf("tx.ID: \"%s\" \n", ids)
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	fmt.Printf("tx.ID: \"%s\" \n", hex.EncodeToString(ID))
+	tx, _, err := bc.FindTransactionBlock(ID)
+	if err != nil {
+		return Transaction{}, err
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	return *tx, nil
 }
 
-// FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
+// FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs replaced by empty placeholders
 func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	UTXO := make(map[string]TXOutputs)
+
+	bc.walkUTXO(context.Background(), func(block *Block, unspent map[string]TXOutputs) error {
+		for txID, outs := range unspent {
+			UTXO[txID] = outs
+		}
+		return nil
+	})
+
+	return UTXO
+}
+
+// walkUTXO walks the chain from the tip to the genesis block and calls visit
+// after each block with its transactions that still have unspent outputs,
+// keyed by hex encoded ID. Only the spent outputs are kept in memory. The walk
+// stops with the error of visit or when ctx is done
+func (bc *Blockchain) walkUTXO(ctx context.Context, visit func(block *Block, unspent map[string]TXOutputs) error) error {
 	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
 
-	for {
-		block := bci.Next()
+	return bc.ForEachBlockContext(ctx, func(block *Block) error {
+		unspent := make(map[string]TXOutputs)
 
+		// inputs first, a transaction may spend an earlier one of the block
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() == false {
+				for _, in := range tx.Vin {
+					inTxID := hex.EncodeToString(in.Txid)
+					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
+				}
+			}
+		}
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 
+			outs := TXOutputs{Height: block.Height.Int64(), Coinbase: tx.IsCoinbase()}
+			found := false
 		Outputs:
 			for outIdx, out := range tx.Vout {
-				// Was the output spent?
+				// Was the output spent? Keep a placeholder so indexes match
 				if spentTXOs[txID] != nil {
 					for _, spentOutIdx := range spentTXOs[txID] {
 						if spentOutIdx == outIdx {
+							outs.Outputs = append(outs.Outputs, TXOutput{})
 							continue Outputs
 						}
 					}
 				}
 
-				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
+				found = true
 			}
-
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Vin {
-					inTxID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-				}
+			if found {
+				unspent[txID] = outs
 			}
+			// only newer transactions spend its outputs, they were walked already
+			delete(spentTXOs, txID)
 		}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
-	}
-
-	return UTXO
+		return visit(block, unspent)
+	})
 }
 
 // Iterator returns a BlockchainIterat
@@ -228,14 +332,26 @@ func (bc *Blockchain) Iterator() *BlockchainIterator {
 	return bci
 }
 
-// GetBestHeight returns the height of the latest block
-func (bc *Blockchain) GetBestHeightLastHash() (*big.Int,[]byte) {
+// GetBestHeightLastHash returns the height and the hash of the latest block
+func (bc *Blockchain) GetBestHeightLastHash() (*big.Int, []byte, error) {
 	var lastBlock Block
 
 	err := bc.Db.View(func(tx *bolt.Tx) error {
+		// the chainstate record, or the height index, ends at the tip
+		if s, err := readChainState(tx); err == nil && s != nil {
+			lastBlock.Height, lastBlock.Hash = big.NewInt(s.height), s.tip
+			return nil
+		}
+		if height, hash := bestFromIndex(tx); height != nil {
+			lastBlock.Height, lastBlock.Hash = height, hash
+			return nil
+		}
 		b := tx.Bucket([]byte(blocksBucket))
 		lastHash := b.Get([]byte("l"))
 		blockData := b.Get(lastHash)
+		if blockData == nil {
+			return ErrBlockNotFound
+		}
 		lastBlock = *DeserializeBlock(blockData)
 		//fmt.Println("bf lastBlock.Hash set value：", lastBlock.Hash)
 		//lastBlock.Hash = lastHash
@@ -243,19 +359,23 @@ func (bc *Blockchain) GetBestHeightLastHash() (*big.Int,[]byte) {
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, nil, err
 	}
 
-	return lastBlock.Height,lastBlock.Hash
+	return lastBlock.Height, lastBlock.Hash, nil
 }
 
 // GetBestHeight returns the height of the latest block
-func (bc *Blockchain) GetBestHeight() (*big.Int,string) {
-	height,lastHash:= bc.GetBestHeightLastHash()
-	return height,hex.EncodeToString(lastHash)
+func (bc *Blockchain) GetBestHeight() (*big.Int, string, error) {
+	height, lastHash, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return nil, "", err
+	}
+	return height, hex.EncodeToString(lastHash), nil
 }
 
-// GetBlock finds a block by its hash and returns it
+// GetBlock finds a block by its hash and returns it, ErrBlockPruned for a
+// block of the chain whose body was pruned
 func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
@@ -265,7 +385,10 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 		blockData := b.Get(blockHash)
 
 		if blockData == nil {
-			return errors.New("Block is not found.")
+			if bodyPruned(tx, blockHash) {
+				return ErrBlockPruned
+			}
+			return ErrBlockNotFound
 		}
 
 		block = *DeserializeBlock(blockData)
@@ -279,42 +402,22 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
-// GetBlockHashes returns a list of hashes of all the blocks after a block in the chain
-func (bc *Blockchain) GetBlockHashes(lastHash string) [][]byte {
-	var blocks [][]byte
+// GetBlockHashesMap returns the hashes of all the blocks after a block in the chain,
+// down to the pruned ones
+func (bc *Blockchain) GetBlockHashesMap(lastHash []byte) (map[string][]byte, error) {
+	var blocks = make(map[string][]byte)
 	bci := bc.Iterator()
 
 	stopBlock := false
 	for {
-		block := bci.Next()
-		fmt.Printf("--------->GetBlockHashes 1 len(block.PrevBlockHash) %s\n", len(block.PrevBlockHash))
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
-		fmt.Printf("--------->GetBlockHashes 2 lastHash %s\n", lastHash)
-		if(lastHash == hex.EncodeToString(block.Hash)) {
-			stopBlock = true
-			continue
+		block, err := bci.Next()
+		if err == ErrBlockPruned {
+			break // the pruned blocks can't be served
 		}
-		fmt.Printf("--------->GetBlockHashes 3 stopBlock %s\n", stopBlock)
-		if(!stopBlock){
-			blocks = append(blocks, block.Hash)
+		if err != nil {
+			return nil, err
 		}
-	}
-	fmt.Printf("prepare blocks with %d \n", len(blocks))
-
-	return blocks
-}
-
-// GetBlockHashes returns a list of hashes of all the blocks after a block in the chain
-func (bc *Blockchain) GetBlockHashesMap(lastHash []byte) map[string][]byte {
-	var blocks = make(map[string][]byte)
-	bci := bc.Iterator()
-
-	stopBlock := false
-	for {
-		block := bci.Next()
-		fmt.Printf("--------->GetBlockHashes 1 len(block.PrevBlockHash) %s\n", len(block.PrevBlockHash))
+		fmt.Printf("--------->GetBlockHashes 1 len(block.PrevBlockHash) %d\n", len(block.PrevBlockHash))
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
@@ -324,26 +427,45 @@ func (bc *Blockchain) GetBlockHashesMap(lastHash []byte) map[string][]byte {
 			continue
 		}
 		hashstr := hex.EncodeToString(block.Hash)
-		fmt.Printf("--------->GetBlockHashes 3 stopBlock %s\n", stopBlock)
+		fmt.Printf("--------->GetBlockHashes 3 stopBlock %t\n", stopBlock)
 		if(!stopBlock){
 			blocks[hashstr] = block.Hash
 		}
 	}
 	fmt.Printf("prepare blocks with %d \n", len(blocks))
 
-	return blocks
+	return blocks, nil
+}
+
+// MineBlock mines a new block with the provided transactions. It returns a
+// nil block and no error if another block became the tip while it mined
+func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
+	block, err := bc.MineBlockContext(context.Background(), transactions)
+	if err == ErrMiningAborted {
+		return nil, nil
+	}
+
+	return block, err
 }
 
-// MineBlock mines a new block with the provided transactions
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
+// MineBlockContext mines a new block with the provided transactions on the
+// tip. It returns ErrMiningAborted once ctx is done, or if another block
+// became the tip while it mined. The block is checked with VerifyBlock
+// before it's stored, its error returned, and applied to the UTXO set in the
+// same bolt transaction when the set is at the tip. It is then sent to the
+// subscribers, see SubscribeBlockConnected
+func (bc *Blockchain) MineBlockContext(ctx context.Context, transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight *big.Int
 	var block *Block
 	err := bc.Db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+		lastHash = append([]byte(nil), b.Get([]byte("l"))...)
 
 		blockData := b.Get(lastHash)
+		if blockData == nil {
+			return ErrBlockNotFound
+		}
 		block = DeserializeBlock(blockData)
 
 		lastHeight = block.Height
@@ -352,55 +474,115 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 	})
 
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 
 	x := new(big.Int)
-	newBlock := NewBlock(transactions, lastHash, x.Add(lastHeight,big1), false,bc)
+	newBlock, err := newBlockContext(ctx, transactions, lastHash, x.Add(lastHeight,big1), false,bc)
+	if err != nil {
+		return nil, err
+	}
+	// a block the peers would refuse is neither stored nor sent
+	err = bc.VerifyBlock(newBlock, block)
+	if err == ErrPrevNotTip {
+		return nil, ErrMiningAborted
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	err = bc.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
+	// the UTXO set moves with the tip when it is at the parent, a set lagging
+	// behind is left to Update or the recovery at open
+	u := UTXOSet{Blockchain: bc}
+	store := func(tx *bolt.Tx, w *utxoWriter) error {
+		if !bytes.Equal(tx.Bucket([]byte(blocksBucket)).Get([]byte("l")), lastHash) {
+			return ErrMiningAborted
+		}
+		if err := putBlock(tx, newBlock); err != nil {
+			return err
+		}
+		if err := putTip(tx, newBlock.Hash); err != nil {
+			return err
+		}
+		if _, err := putChainWork(tx, newBlock); err != nil {
+			return err
+		}
+		if w == nil || !bytes.Equal(utxoTipOf(tx), lastHash) {
+			return nil
+		}
+		undo, err := tx.CreateBucketIfNotExists([]byte(utxoUndoBucket))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-
-		err = b.Put([]byte("l"), newBlock.Hash)
+		heights, err := undoHeights(tx, undo)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		if err := applyBlock(w, undo, heights, newBlock); err != nil {
+			return err
 		}
+		return storeUTXOTip(tx, newBlock.Hash)
+	}
+	at, err := u.BestBlock()
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(at, lastHash) {
+		err = u.write(store)
+	} else {
+		err = bc.Db.Update(func(tx *bolt.Tx) error { return store(tx, nil) })
+	}
+	if err != nil {
+		return nil, err
+	}
+	bc.tip = newBlock.Hash
+	u.pruneUndoInBackground()
+	bc.notifyConnected(newBlock)
 
-		bc.tip = newBlock.Hash
+	return newBlock, nil
+}
 
-		return nil
-	})
+// TransactionConfirmations returns the number of blocks from the tip down to
+// and including the block holding the transaction
+func (bc *Blockchain) TransactionConfirmations(ID []byte) (int, error) {
+	_, block, err := bc.FindTransactionBlock(ID)
 	if err != nil {
-		log.Panic(err)
+		return 0, err
+	}
+	height, _, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return 0, err
 	}
 
-	return newBlock
+	return int(height.Int64()-block.Height.Int64()) + 1, nil
 }
 
 // SignTransaction signs inputs of a Transaction
-func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+func (bc *Blockchain) SignTransaction(tx *Transaction, signer Signer) error {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
 		prevTX, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			// the change of a mempool transaction, see FindSpendableOutputs
+			prevTX, err = findMempoolTransaction(vin.Txid)
+		}
+		if err != nil {
+			return err
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return tx.Sign(signer, prevTXs)
 }
 
-// VerifyTransaction verifies transaction input signatures
-func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+// VerifyTransaction verifies transaction input signatures. It returns the
+// error of FindTransaction for an input spending a transaction not in the
+// chain, ErrTxNotFound if it isn't there
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) (bool, error) {
 	if tx.IsCoinbase() {
-		return true
+		return true, nil
 	}
 
 	prevTXs := make(map[string]Transaction)
@@ -408,13 +590,42 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	for _, vin := range tx.Vin {
 		prevTX, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return false, err
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.Verify(prevTXs)
+	return tx.Verify(prevTXs), nil
+}
 
+// verifyBlockSignatures verifies the input signatures of the transactions of
+// a block extending the tip, whose parents are in the chain or earlier in
+// the block. The outputs spent are resolved in block order, then the
+// signatures checked by runSigChecks
+func (bc *Blockchain) verifyBlockSignatures(block *Block) error {
+	var checks []sigCheck
+	inBlock := make(map[string]Transaction)
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for in, vin := range tx.Vin {
+				id := hex.EncodeToString(vin.Txid)
+				prevTX, ok := inBlock[id]
+				if !ok {
+					var err error
+					if prevTX, err = bc.FindTransaction(vin.Txid); err != nil {
+						return fmt.Errorf("transaction %x: %v", tx.ID, err)
+					}
+				}
+				if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+					return fmt.Errorf("transaction %x spends no output %x:%d", tx.ID, vin.Txid, vin.Vout)
+				}
+				checks = append(checks, sigCheck{tx, in, prevTX.Vout[vin.Vout].PubKeyHash})
+			}
+		}
+		inBlock[hex.EncodeToString(tx.ID)] = *tx
+	}
+
+	return runSigChecks(checks)
 }
 
 
@@ -427,8 +638,8 @@ func dbExists(dbFile string) bool {
 	return true
 }
 
-// make sure block is valid by checking height, and comparing the hash of the previous block
-// ,and block hash,and block pow result,and transaction consistent(time line,utxo,tx address,coinbasetx)
+// make sure block is valid on the tip with VerifyBlock, the reason tells which check failed,
+// see blockInvalidReasons
 func (bc *Blockchain)IsBlockValid(newBlock *Block) (bool,int) {
 	var oldBlock *Block
 	var lastHashS string
@@ -445,61 +656,30 @@ func (bc *Blockchain)IsBlockValid(newBlock *Block) (bool,int) {
 		lastHashS = hex.EncodeToString(lastHash[:])
 		fmt.Printf("lastHash %x \n", lastHashS)
 		lastBlockData := b.Get(lastHash)
+		if lastBlockData == nil {
+			return ErrBlockNotFound
+		}
 		oldBlock = DeserializeBlock(lastBlockData)
 
 		return nil
 	})
 
 	if err != nil {
-		log.Panic(err)
+		log.Printf("IsBlockValid: %v", err)
+		return false, 1
 	}
 	if(oldBlock==nil){
-		fmt.Printf("newBlock.Hash:%x",newBlock.Hash)
-		log.Panic("last block inconsistent with new block!lastHashS:"+lastHashS)
-		reason = 1
+		// the block is stored already
+		fmt.Printf("newBlock.Hash:%x last block lastHashS:%s\n",newBlock.Hash,lastHashS)
+		return false, 1
 	}
-	//fmt.Printf("oldBlock.Height %n \n", oldBlock.Height)
-	//fmt.Printf("newBlock.Height %n \n", newBlock.Height)
-	x := new(big.Int)
-	if x.Add(oldBlock.Height,big1).Cmp(newBlock.Height) !=0 {
-		reason = 2
-		return false,reason
-	}
-
-	if lastHashS != hex.EncodeToString(newBlock.PrevBlockHash[:]) {
-		reason = 3
-		return false,reason
-	}
-
-	//fmt.Printf("newBlock %s \n", newBlock)
-	newHashA,pow := calculateHash(newBlock)
-	newHash := hex.EncodeToString(newHashA[:])
-	//fmt.Printf("calculateHash(newBlock) %s \n", newHash)
-	//fmt.Printf("newBlock.hash %s \n", hex.EncodeToString(newBlock.Hash[:]))
-	if newHash != hex.EncodeToString(newBlock.Hash[:]) {
-		reason = 4
-		return false,reason
-	}
-
-	//pow validate
-	var hashInt big.Int
-	hashInt.SetBytes(newHashA[:])
-	if hashInt.Cmp(pow.target) != -1 {
-		reason = 5
-		return false,reason
-	}
-
-	//transaction consistent validate
-	UTXOSet := UTXOSet{bc}
-	if(!UTXOSet.VerifyTxTimeLineAndUTXOAmount(oldBlock.Timestamp,newBlock)){
-		reason = 6
-		return false,reason
-	}
-	for _,tx := range newBlock.Transactions{
-		if(!VeryfyFromToAddress(tx)){
-			reason = 7
-			return false,reason
+	//the checks and their order, see VerifyBlock
+	if err := bc.VerifyBlock(newBlock, oldBlock); err != nil {
+		reason = 1
+		if r, ok := blockInvalidReasons[err]; ok {
+			reason = r
 		}
+		return false,reason
 	}
 	return true,reason
 }
@@ -523,7 +703,7 @@ func calculateHash(block *Block) ([]byte,*ProofOfWork) {
 	//record := prepareData(block)
 	//hashed := sha256.Sum256(record)
 	var hash [32]byte
-	pow := NewProofOfWork(block,block.Difficulty.Int64())
+	pow := NewProofOfWork(block)
 	data := pow.prepareData(block.Nonce)
 	hash = sha256.Sum256(data)
 	/*
@@ -541,34 +721,36 @@ func calculateHash(block *Block) ([]byte,*ProofOfWork) {
 	fmt.Printf("newBlock.PrevBlockHash %s \n", int64(block.Nonce))
 	fmt.Printf("newBlock.PrevBlockHash %x \n", sha256.Sum256(IntToHex(int64(block.Nonce))))
 	*/
-	fmt.Printf("calculateHash Blockdata len %n \n", len(data))
+	fmt.Printf("calculateHash Blockdata len %d \n", len(data))
 	return hash[:],pow
 }
 
-//func GetTd(blockHash []byte)big.Int{
-
-//}
-
 // DleteBlocks returns a list of hashes of all the blocks after a block in the chain
-func (bc *Blockchain) DelBlockHashes(hashs map[string][]byte) [][]byte {
+func (bc *Blockchain) DelBlockHashes(hashs map[string][]byte) ([][]byte, error) {
 	var blocks [][]byte
 	bci := bc.Iterator()
 
 	for{
-		block := bci.Next()
+		block, err := bci.Next()
+		if err == ErrBlockPruned {
+			break
+		}
+		if err != nil {
+			return blocks, err
+		}
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
 		if _, ok := hashs[hex.EncodeToString(block.Hash)]; ok  {
 			err := bc.Db.Update(func(tx *bolt.Tx) error {
-				b, err := tx.CreateBucket([]byte(blocksBucket))
-				if err != nil {
-					log.Panic(err)
+				b := tx.Bucket([]byte(blocksBucket))
+				if err := unindexTransactions(tx, block.Hash); err != nil {
+					return err
 				}
 				return b.Delete(block.Hash)
 			})
 			if(err != nil){
-				log.Panic(err)
+				return blocks, err
 			}
 			blocks = append(blocks, block.Hash)
 		}
@@ -576,5 +758,5 @@ func (bc *Blockchain) DelBlockHashes(hashs map[string][]byte) [][]byte {
 	}
 	fmt.Printf("delete blocks with %d \n", len(blocks))
 
-	return blocks
+	return blocks, nil
 }
\ No newline at end of file
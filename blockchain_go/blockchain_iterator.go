@@ -1,7 +1,8 @@
 package core
 
 import (
-	"log"
+	"context"
+	"errors"
 
 	"github.com/boltdb/bolt"
 )
@@ -12,23 +13,94 @@ type BlockchainIterator struct {
 	db          *bolt.DB
 }
 
-// Next returns next block starting from the tip
-func (i *BlockchainIterator) Next() *Block {
+// Next returns next block starting from the tip, ErrBlockNotFound if the
+// database doesn't hold it and ErrBlockPruned once the walk reaches the
+// pruned blocks
+func (i *BlockchainIterator) Next() (*Block, error) {
 	var block *Block
 
 	err := i.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		encodedBlock := b.Get(i.currentHash)
+		if encodedBlock == nil {
+			if bodyPruned(tx, i.currentHash) {
+				return ErrBlockPruned
+			}
+			return ErrBlockNotFound
+		}
 		block = DeserializeBlock(encodedBlock)
 
 		return nil
 	})
-
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	i.currentHash = block.PrevBlockHash
 
-	return block
+	return block, nil
+}
+
+// StopIteration, returned by the function of ForEachBlock or IterateRange,
+// ends the walk without an error
+var StopIteration = errors.New("stop iteration")
+
+// ForEachBlock calls fn with each block of the chain, from the tip down to
+// the genesis block. It stops at the first error of fn and returns it
+func (bc *Blockchain) ForEachBlock(fn func(*Block) error) error {
+	return bc.ForEachBlockContext(context.Background(), fn)
+}
+
+// ForEachBlockContext is ForEachBlock, stopping with ctx.Err() once ctx is
+// done
+func (bc *Blockchain) ForEachBlockContext(ctx context.Context, fn func(*Block) error) error {
+	bci := bc.Iterator()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			if err == StopIteration {
+				return nil
+			}
+			return err
+		}
+		if len(block.PrevBlockHash) == 0 {
+			return nil
+		}
+	}
+}
+
+// IterateRange calls fn with the blocks of the chain from fromHeight to
+// toHeight, both included, read through the height index: forwards when
+// fromHeight is the lower, backwards otherwise. It stops at the first error
+// of fn and returns it, and with ctx.Err() once ctx is done. Heights past
+// the tip are an error
+func (bc *Blockchain) IterateRange(ctx context.Context, fromHeight, toHeight int64, fn func(*Block) error) error {
+	step := int64(1)
+	if fromHeight > toHeight {
+		step = -1
+	}
+	for height := fromHeight; ; height += step {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		block, err := bc.GetBlockByHeight(int(height))
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			if err == StopIteration {
+				return nil
+			}
+			return err
+		}
+		if height == toHeight {
+			return nil
+		}
+	}
 }
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForEachBlock(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address, address, address)
+		defer bc.Db.Close()
+
+		var heights []int64
+		assert.Nil(t, bc.ForEachBlock(func(block *Block) error {
+			heights = append(heights, block.Height.Int64())
+			return nil
+		}))
+		assert.Equal(t, []int64{3, 2, 1, 0}, heights, "From the tip down")
+
+		heights = nil
+		assert.Nil(t, bc.ForEachBlock(func(block *Block) error {
+			heights = append(heights, block.Height.Int64())
+			if len(heights) == 2 {
+				return StopIteration
+			}
+			return nil
+		}))
+		assert.Equal(t, []int64{3, 2}, heights)
+
+		failed := errors.New("failed")
+		assert.Equal(t, failed, bc.ForEachBlock(func(block *Block) error { return failed }))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := bc.ForEachBlockContext(ctx, func(block *Block) error {
+			calls++
+			cancel()
+			return nil
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, calls)
+
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		tx, err := bc.FindTransaction(genesis.Transactions[0].ID)
+		assert.Nil(t, err)
+		assert.Equal(t, genesis.Transactions[0].ID, tx.ID)
+		_, err = bc.FindTransaction([]byte("unknown"))
+		assert.Equal(t, ErrTxNotFound, err)
+	})
+}
+
+func TestBlockchainErrors(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, err := NewBlockchain("test")
+		assert.Equal(t, ErrNoBlockchain, err)
+		_, address := newTestWallets()
+		bc := newTestChain(address, address)
+		_, err = CreateBlockchain(address, "test")
+		assert.Equal(t, ErrBlockchainExists, err)
+
+		_, err = bc.GetBlock([]byte("unknown"))
+		assert.Equal(t, ErrBlockNotFound, err)
+		tx := NewCoinbaseTX(address, "")
+		_, err = bc.VerifyTransaction(&Transaction{Vin: []TXInput{{tx.ID, 0, nil, nil}}})
+		assert.Equal(t, ErrTxNotFound, err)
+
+		// a block missing from the database ends the walk with an error
+		bci := &BlockchainIterator{[]byte("unknown"), bc.Db}
+		_, err = bci.Next()
+		assert.Equal(t, ErrBlockNotFound, err)
+
+		bc.Db.Close()
+		_, _, err = bc.GetBestHeightLastHash()
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = bc.MineBlock([]*Transaction{tx})
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = bc.AddBlock(&Block{})
+		assert.Equal(t, ErrDBClosed, err)
+		assert.Equal(t, ErrDBClosed, bc.ForEachBlock(func(block *Block) error { return nil }))
+		// and so do the queries of the UTXO set, mining among them
+		u := UTXOSet{Blockchain: bc}
+		_, err = u.BlockTransactions(nil, address)
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = u.SelectTransactions([]*Transaction{tx}, tx)
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = u.ListUnspent(tx.Vout[0].PubKeyHash, 0)
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = u.FindUTXO(tx.Vout[0].PubKeyHash)
+		assert.Equal(t, ErrDBClosed, err)
+		_, _, err = u.FindSpendableOutputs(tx.Vout[0].PubKeyHash, 1, nil, 1, false)
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = u.CheckUTXOAmount(tx)
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = u.BestBlock()
+		assert.Equal(t, ErrDBClosed, err)
+		_, err = u.CountTransactions()
+		assert.Equal(t, ErrDBClosed, err)
+
+		bc, err = NewBlockchain("test")
+		assert.Nil(t, err, "The closed chain opens again")
+		bc.Db.Close()
+	})
+}
+
+func TestIterateRange(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address, address, address)
+		defer bc.Db.Close()
+
+		collect := func(from, to int64) ([]int64, error) {
+			var heights []int64
+			err := bc.IterateRange(context.Background(), from, to, func(block *Block) error {
+				heights = append(heights, block.Height.Int64())
+				return nil
+			})
+			return heights, err
+		}
+		heights, err := collect(0, 3)
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{0, 1, 2, 3}, heights, "Forwards")
+		heights, err = collect(3, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{3, 2, 1}, heights, "And backwards")
+		heights, err = collect(2, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{2}, heights)
+		heights, err = collect(2, 5)
+		assert.NotNil(t, err, "Past the tip")
+		assert.Equal(t, []int64{2, 3}, heights)
+
+		heights = nil
+		err = bc.IterateRange(context.Background(), 0, 3, func(block *Block) error {
+			heights = append(heights, block.Height.Int64())
+			if block.Height.Int64() == 1 {
+				return StopIteration
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []int64{0, 1}, heights)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, context.Canceled, bc.IterateRange(ctx, 0, 3, func(block *Block) error { return nil }))
+	})
+}
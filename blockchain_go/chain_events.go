@@ -0,0 +1,132 @@
+package core
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// eventFeed sends events to the channels subscribed to it without blocking:
+// an event a channel has no room for is dropped, so the channels should be
+// buffered to what their reader may fall behind by. A closed channel is
+// unsubscribed at the next event
+type eventFeed struct {
+	mu   sync.Mutex
+	subs []func(event interface{}) bool // false once the channel is closed
+}
+
+func (f *eventFeed) subscribe(send func(event interface{}) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs = append(f.subs, send)
+}
+
+// send holds the lock while it sends, so every subscriber gets the events in
+// the order they happened
+func (f *eventFeed) send(event interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	open := f.subs[:0]
+	for _, send := range f.subs {
+		if send(event) {
+			open = append(open, send)
+		}
+	}
+	f.subs = open
+}
+
+// trySendBlock sends block to ch unless it is full. It returns false when ch
+// is closed
+func trySendBlock(ch chan<- *Block, block *Block) (open bool) {
+	defer func() {
+		if recover() != nil {
+			open = false
+		}
+	}()
+	select {
+	case ch <- block:
+	default:
+		log.Printf("dropped the event of block %x, the subscriber is behind", block.Hash)
+	}
+
+	return true
+}
+
+// trySendTx is trySendBlock for a transaction
+func trySendTx(ch chan<- *Transaction, tx *Transaction) (open bool) {
+	defer func() {
+		if recover() != nil {
+			open = false
+		}
+	}()
+	select {
+	case ch <- tx:
+	default:
+		log.Printf("dropped the event of transaction %x, the subscriber is behind", tx.ID)
+	}
+
+	return true
+}
+
+// chainFeed holds the subscribers of a chain. There is one per database
+// rather than per Blockchain, the p2p handlers opening their own
+type chainFeed struct {
+	connected    eventFeed
+	disconnected eventFeed
+}
+
+var (
+	chainFeedsMu sync.Mutex
+	chainFeeds   = make(map[string]*chainFeed)
+)
+
+// events returns the feed of the database of bc
+func (bc *Blockchain) events() *chainFeed {
+	path, err := filepath.Abs(bc.Db.Path())
+	if err != nil {
+		path = bc.Db.Path()
+	}
+	chainFeedsMu.Lock()
+	defer chainFeedsMu.Unlock()
+	feed, ok := chainFeeds[path]
+	if !ok {
+		feed = &chainFeed{}
+		chainFeeds[path] = feed
+	}
+
+	return feed
+}
+
+// SubscribeBlockConnected sends every block connected to the tip to ch once
+// it is committed, along with its UTXO set changes when they are written
+// with it. The subscription holds for every Blockchain of the database,
+// until ch is closed. Sends don't block, see eventFeed, ch should be buffered
+func (bc *Blockchain) SubscribeBlockConnected(ch chan<- *Block) {
+	bc.events().connected.subscribe(func(event interface{}) bool {
+		return trySendBlock(ch, event.(*Block))
+	})
+}
+
+// SubscribeBlockDisconnected sends every block disconnected from the tip by a
+// reorganisation to ch, from the old tip down, as SubscribeBlockConnected
+// does. The blocks of the new branch follow on the connected feed, a reader
+// of both should drain this one before handling a connected block
+func (bc *Blockchain) SubscribeBlockDisconnected(ch chan<- *Block) {
+	bc.events().disconnected.subscribe(func(event interface{}) bool {
+		return trySendBlock(ch, event.(*Block))
+	})
+}
+
+func (bc *Blockchain) notifyConnected(blocks ...*Block) {
+	feed := bc.events()
+	for _, block := range blocks {
+		feed.connected.send(block)
+	}
+}
+
+func (bc *Blockchain) notifyDisconnected(blocks ...*Block) {
+	feed := bc.events()
+	for _, block := range blocks {
+		feed.disconnected.send(block)
+	}
+}
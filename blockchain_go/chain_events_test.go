@@ -0,0 +1,98 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// received returns the blocks waiting in ch
+func received(ch chan *Block) [][]byte {
+	var hashes [][]byte
+	for {
+		select {
+		case block := <-ch:
+			hashes = append(hashes, block.Hash)
+		default:
+			return hashes
+		}
+	}
+}
+
+func TestBlockEvents(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		UTXOSet{Blockchain: bc}.Reindex()
+		connected := make(chan *Block, 10)
+		disconnected := make(chan *Block, 10)
+		bc.SubscribeBlockConnected(connected)
+		bc.SubscribeBlockDisconnected(disconnected)
+		bc.Close()
+
+		// the subscriptions hold for the database, a mined block is sent
+		// once it is stored with its UTXO set changes
+		bc, err := NewBlockchain("test")
+		assert.Nil(t, err)
+		defer bc.Close()
+		mined := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		assert.Equal(t, [][]byte{mined.Hash}, received(connected))
+		assert.Equal(t, mined.Hash, mustTip(UTXOSet{Blockchain: bc}.BestBlock()))
+
+		// a reorganisation sends the blocks it disconnects, then those it
+		// connects
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		a1 := minedBlock(&genesis, address)
+		a2 := minedBlock(a1, address)
+		_, err = bc.Reorganize([]*Block{a1, a2})
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{mined.Hash}, received(disconnected))
+		assert.Equal(t, [][]byte{a1.Hash, a2.Hash}, received(connected))
+
+		// side blocks aren't sent
+		status, err := bc.AddBlock(forkBlock(a1, fmt.Sprintf("%s", NewWallet().GetAddress())))
+		assert.Nil(t, err)
+		assert.Equal(t, BlockSideChain, status)
+		assert.Empty(t, received(connected))
+	})
+}
+
+func TestBlockEventsDropAndClose(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		full := make(chan *Block, 1)
+		closed := make(chan *Block, 1)
+		bc.SubscribeBlockConnected(full)
+		bc.SubscribeBlockConnected(closed)
+		close(closed)
+
+		// the sends don't block, what doesn't fit is dropped
+		first := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		assert.Equal(t, [][]byte{first.Hash}, received(full))
+
+		// the closed channel was unsubscribed
+		assert.Equal(t, 1, len(bc.events().connected.subs))
+	})
+}
+
+func TestNewPendingTxEvents(t *testing.T) {
+	_, address := newTestWallets()
+	m := NewMempool()
+	ch := make(chan *Transaction, 10)
+	m.SubscribeNewPendingTx(ch)
+
+	tx := NewCoinbaseTX(address, "")
+	assert.Nil(t, m.Add(tx))
+	assert.Nil(t, m.Add(tx))
+	assert.Equal(t, 1, len(ch))
+	assert.Equal(t, tx.ID, (<-ch).ID)
+
+	close(ch)
+	assert.Nil(t, m.Add(NewCoinbaseTX(address, "other")))
+	assert.Equal(t, 0, len(m.newTx.subs))
+}
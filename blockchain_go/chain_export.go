@@ -0,0 +1,149 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An export of the chain is exportMagic, exportVersion, the network magic
+// as 4 bytes big endian, the genesis hash as a section, the count of blocks
+// as a uvarint, then the blocks as sections, encoded with Serialize, from
+// the lowest height up. Sections are prefixed with their length as a
+// uvarint, see block_format.go
+var exportMagic = []byte("SWCCHAIN")
+
+const exportVersion = 1
+
+var (
+	// ErrNotChainExport is returned by Import for a file that isn't an
+	// export of a chain
+	ErrNotChainExport = errors.New("not a chain export")
+	// ErrForeignExport is returned by Import for an export of a chain of
+	// another network or with another genesis block
+	ErrForeignExport = errors.New("the export is of a chain with a different genesis block")
+)
+
+// ImportError is returned by Import for the first block of an export the
+// chain refuses
+type ImportError struct {
+	Height int64
+	Hash   []byte
+	Err    error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("block %x at height %d: %v", e.Hash, e.Height, e.Err)
+}
+
+// Export writes the blocks of the chain from fromHeight to toHeight, both
+// included, to w in the export format, for Import
+func (bc *Blockchain) Export(w io.Writer, fromHeight, toHeight int) error {
+	best, _, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return err
+	}
+	if fromHeight < 0 || toHeight < fromHeight || int64(toHeight) > best.Int64() {
+		return fmt.Errorf("no blocks from height %d to %d, the tip is at %d", fromHeight, toHeight, best)
+	}
+
+	bw := bufio.NewWriter(w)
+	header := append(append([]byte(nil), exportMagic...), exportVersion)
+	magic := make([]byte, 4)
+	binary.BigEndian.PutUint32(magic, ActiveNetParams.Genesis.Magic)
+	header = append(header, magic...)
+	header = appendSection(header, bc.GenesisHash)
+	header = appendUvarint(header, uint64(toHeight-fromHeight+1))
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	err = bc.IterateRange(context.Background(), int64(fromHeight), int64(toHeight), func(block *Block) error {
+		_, err := bw.Write(appendSection(nil, block.Serialize()))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Import connects the blocks of an export with ProcessBlock, as blocks from
+// peers are, so each is validated, and a branch with more work than the
+// chain reorganises it. The genesis block and the blocks the chain holds
+// are skipped. It stops at the first block refused with an ImportError, the
+// blocks before it staying connected, and refuses an export of another
+// chain with ErrForeignExport. progress is called, if not nil, after each
+// block with the blocks imported and their count
+func (bc *Blockchain) Import(r io.Reader, progress func(imported, total int)) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(exportMagic)+1+4)
+	if _, err := io.ReadFull(br, header); err != nil || !bytes.Equal(header[:len(exportMagic)], exportMagic) {
+		return ErrNotChainExport
+	}
+	if version := header[len(exportMagic)]; version != exportVersion {
+		return fmt.Errorf("unknown chain export version %d", version)
+	}
+	magic := binary.BigEndian.Uint32(header[len(exportMagic)+1:])
+	genesisHash, err := readStreamSection(br, 64)
+	if err != nil {
+		return err
+	}
+	if magic != ActiveNetParams.Genesis.Magic || !bytes.Equal(genesisHash, bc.GenesisHash) {
+		return ErrForeignExport
+	}
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < int(count); i++ {
+		// the blocks of an export are as large as Serialize makes them
+		data, err := readStreamSection(br, ActiveNetParams.MaxBlockSerializedSize)
+		if err != nil {
+			return fmt.Errorf("block %d of the export: %v", i, err)
+		}
+		block, err := DecodeBlock(data)
+		if err != nil {
+			return fmt.Errorf("block %d of the export: %v", i, err)
+		}
+		if len(block.PrevBlockHash) == 0 {
+			if !bytes.Equal(block.Hash, bc.GenesisHash) {
+				return ErrForeignExport
+			}
+		} else if _, err := bc.ProcessBlock(block); err != nil {
+			var height int64
+			if block.Height != nil {
+				height = block.Height.Int64()
+			}
+			return &ImportError{Height: height, Hash: block.Hash, Err: err}
+		}
+		if progress != nil {
+			progress(i+1, int(count))
+		}
+	}
+
+	return nil
+}
+
+// readStreamSection reads a section of up to max bytes
+func readStreamSection(r *bufio.Reader, max int) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(max) {
+		return nil, fmt.Errorf("a section of %d bytes, more than %d", length, max)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
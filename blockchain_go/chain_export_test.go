@@ -0,0 +1,75 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		other := fmt.Sprintf("%s", NewWallet().GetAddress())
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		send, err := NewUTXOTransaction(ws.Wallets[address], other, 10, &u, nil, 1)
+		assert.Nil(t, err)
+		u.Update(mustMine(bc, mustTxs(u.BlockTransactions([]*Transaction{send}, address))))
+		u.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(other, "")}))
+		height, tip := bestTip(bc)
+		assert.Equal(t, int64(3), height.Int64())
+
+		var export bytes.Buffer
+		assert.Nil(t, bc.Export(&export, 0, 3))
+		assert.NotNil(t, bc.Export(&bytes.Buffer{}, 2, 4), "Past the tip")
+
+		imported := mustCreate(address, "import")
+		defer imported.Db.Close()
+		UTXOSet{Blockchain: imported}.Reindex()
+		var calls []int
+		err = imported.Import(bytes.NewReader(export.Bytes()), func(done, total int) {
+			assert.Equal(t, 4, total)
+			calls = append(calls, done)
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4}, calls)
+		_, importedTip := bestTip(imported)
+		assert.Equal(t, tip, importedTip)
+		balances, err := u.GetBalances([][]byte{HashPubKey(ws.Wallets[address].PublicKey)})
+		assert.Nil(t, err)
+		importedBalances, err := UTXOSet{Blockchain: imported}.GetBalances([][]byte{HashPubKey(ws.Wallets[address].PublicKey)})
+		assert.Nil(t, err)
+		assert.Equal(t, balances, importedBalances)
+		assert.Nil(t, imported.Import(bytes.NewReader(export.Bytes()), nil), "Blocks held are skipped")
+
+		// a block the chain refuses stops the import at its height
+		partial := mustCreate(address, "partial")
+		defer partial.Db.Close()
+		UTXOSet{Blockchain: partial}.Reindex()
+		var tampered bytes.Buffer
+		assert.Nil(t, bc.Export(&tampered, 0, 1))
+		block, _ := bc.GetBlockByHeight(2)
+		block.Nonce++
+		tampered.Write(appendSection(nil, block.Serialize()))
+		data := tampered.Bytes()
+		// the count in the header, 2 blocks one byte long as a uvarint
+		assert.Equal(t, byte(2), data[len(exportMagic)+1+4+1+len(bc.GenesisHash)])
+		data[len(exportMagic)+1+4+1+len(bc.GenesisHash)] = 3
+		err = partial.Import(bytes.NewReader(data), nil)
+		importErr, ok := err.(*ImportError)
+		assert.True(t, ok, "%v", err)
+		assert.Equal(t, int64(2), importErr.Height)
+		partialHeight, _ := bestTip(partial)
+		assert.Equal(t, int64(1), partialHeight.Int64(), "The blocks before it stay")
+
+		// another genesis block
+		foreign := mustCreate(other, "foreign")
+		defer foreign.Db.Close()
+		assert.Equal(t, ErrForeignExport, foreign.Import(bytes.NewReader(export.Bytes()), nil))
+		assert.Equal(t, ErrNotChainExport, foreign.Import(bytes.NewReader([]byte("nope")), nil))
+	})
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// chainStateBucket holds the chainstate record under chainStateKey: the tip
+// of the chain with its height, total work and timestamp, and when the tip
+// last moved. putTip rewrites it in the transaction moving the tip
+const chainStateBucket = "bestchain"
+
+var chainStateKey = []byte("best")
+
+// ErrNoChainState is returned by ChainInfo for a database without the
+// chainstate record
+var ErrNoChainState = errors.New("the chainstate record is missing")
+
+// chainState is the chainstate record
+type chainState struct {
+	tip       []byte
+	height    int64
+	work      *big.Int
+	tipTime   int64
+	updatedAt int64
+}
+
+// The record is its fields as sections, see block_format.go
+func (s *chainState) serialize() []byte {
+	var data []byte
+	for _, section := range [][]byte{s.tip, encodeInt(s.height), encodeBig(s.work), encodeInt(s.tipTime), encodeInt(s.updatedAt)} {
+		data = appendSection(data, section)
+	}
+
+	return data
+}
+
+func deserializeChainState(data []byte) (*chainState, error) {
+	sections, err := readSections(data, 5)
+	if err != nil {
+		return nil, err
+	}
+	s := &chainState{tip: copyBytes(sections[0])}
+	if s.height, err = decodeInt(sections[1]); err != nil {
+		return nil, err
+	}
+	if s.work, err = decodeBig(sections[2]); err != nil {
+		return nil, err
+	}
+	if s.tipTime, err = decodeInt(sections[3]); err != nil {
+		return nil, err
+	}
+	if s.updatedAt, err = decodeInt(sections[4]); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// putChainState writes the record of the chain ending at tip
+func putChainState(tx *bolt.Tx, tip []byte) error {
+	data := tx.Bucket([]byte(blocksBucket)).Get(tip)
+	if data == nil {
+		return fmt.Errorf("block %x is not found", tip)
+	}
+	block := DeserializeBlock(data)
+	work, err := chainWork(tx, tip)
+	if err != nil {
+		return err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(chainStateBucket))
+	if err != nil {
+		return err
+	}
+	s := chainState{
+		tip:       block.Hash,
+		height:    block.Height.Int64(),
+		work:      work,
+		tipTime:   block.Timestamp.Int64(),
+		updatedAt: time.Now().Unix(),
+	}
+
+	return b.Put(chainStateKey, s.serialize())
+}
+
+// readChainState returns the chainstate record, nil without one
+func readChainState(tx *bolt.Tx) (*chainState, error) {
+	b := tx.Bucket([]byte(chainStateBucket))
+	if b == nil {
+		return nil, nil
+	}
+	data := b.Get(chainStateKey)
+	if data == nil {
+		return nil, nil
+	}
+
+	return deserializeChainState(data)
+}
+
+// ensureChainState writes the chainstate record of a database written
+// before it existed
+func ensureChainState(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(chainStateBucket)); b != nil && b.Get(chainStateKey) != nil {
+			return nil
+		}
+		tip := tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))
+		if tip == nil {
+			return nil
+		}
+		return putChainState(tx, append([]byte(nil), tip...))
+	})
+}
+
+// ChainInfo describes the tip of the chain
+type ChainInfo struct {
+	Chain     string   `json:"chain"`
+	Height    int64    `json:"blocks"`
+	Tip       []byte   `json:"-"`
+	BestBlock string   `json:"bestblockhash"`
+	ChainWork *big.Int `json:"chainwork"`
+	TipTime   int64    `json:"time"`    // timestamp of the tip
+	UpdatedAt int64    `json:"updated"` // when the tip last moved
+	// Progress estimates the share of the blocks up to now the chain holds,
+	// a block every TargetSpacing seconds after the tip
+	Progress float64 `json:"verificationprogress"`
+	// Pruned tells whether block bodies were pruned, up to PruneHeight
+	Pruned      bool  `json:"pruned"`
+	PruneHeight int64 `json:"pruneheight,omitempty"`
+	// Path is the file of the database
+	Path string `json:"dbpath"`
+}
+
+// ChainInfo returns the chainstate record, read without walking the chain
+func (bc *Blockchain) ChainInfo() (*ChainInfo, error) {
+	var s *chainState
+	var pruned int64
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		var err error
+		s, err = readChainState(tx)
+		pruned = prunedHeight(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, ErrNoChainState
+	}
+
+	return &ChainInfo{
+		Chain:       ActiveNetParams.Name,
+		Height:      s.height,
+		Tip:         s.tip,
+		BestBlock:   hex.EncodeToString(s.tip),
+		ChainWork:   s.work,
+		TipTime:     s.tipTime,
+		UpdatedAt:   s.updatedAt,
+		Progress:    verificationProgress(s.height, s.tipTime, time.Now().Unix()),
+		Pruned:      pruned > 0,
+		PruneHeight: pruned,
+		Path:        bc.Db.Path(),
+	}, nil
+}
+
+// verificationProgress estimates the share of the blocks up to now a chain
+// of height holds, a block every TargetSpacing seconds after its tip
+func verificationProgress(height, tipTime, now int64) float64 {
+	if now <= tipTime || ActiveNetParams.TargetSpacing <= 0 {
+		return 1
+	}
+	held := float64(height + 1)
+	missing := float64(now-tipTime) / float64(ActiveNetParams.TargetSpacing)
+
+	return held / (held + missing)
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertChainInfo checks the chainstate record against the tip and its
+// work as the chain computes them
+func assertChainInfo(t *testing.T, bc *Blockchain, height int64, tip []byte) {
+	info, err := bc.ChainInfo()
+	assert.Nil(t, err)
+	assert.Equal(t, height, info.Height)
+	assert.Equal(t, tip, info.Tip)
+	work, err := bc.GetTd(tip)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, work.Cmp(info.ChainWork), "%s, not %s", info.ChainWork, work)
+	block, err := bc.GetBlock(tip)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Timestamp.Int64(), info.TipTime)
+	assert.True(t, time.Now().Unix()-info.UpdatedAt < 5)
+	bestHeight, bestHash := bestTip(bc)
+	assert.Equal(t, height, bestHeight.Int64())
+	assert.Equal(t, tip, bestHash)
+}
+
+func TestChainInfo(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		assertChainInfo(t, bc, 0, bc.GenesisHash)
+		info, _ := bc.ChainInfo()
+		assert.Equal(t, ActiveNetParams.Name, info.Chain)
+
+		mined := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		assertChainInfo(t, bc, 1, mined.Hash)
+
+		// a branch with more work moves it
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		a1 := minedBlock(&genesis, address)
+		a2 := minedBlock(a1, address)
+		for _, block := range []*Block{a1, a2} {
+			_, err := bc.ProcessBlock(block)
+			assert.Nil(t, err)
+		}
+		assertChainInfo(t, bc, 2, a2.Hash)
+
+		// a database written before the record has it once opened
+		assert.Nil(t, bc.Db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket([]byte(chainStateBucket)) }))
+		_, err := bc.ChainInfo()
+		assert.Equal(t, ErrNoChainState, err)
+		assert.Nil(t, ensureChainState(bc.Db))
+		assertChainInfo(t, bc, 2, a2.Hash)
+	})
+}
+
+func TestVerificationProgress(t *testing.T) {
+	spacing := ActiveNetParams.TargetSpacing
+	assert.Equal(t, 1.0, verificationProgress(9, 1000, 1000))
+	assert.Equal(t, 1.0, verificationProgress(9, 1000, 900), "A tip ahead of the clock")
+	assert.Equal(t, 0.5, verificationProgress(9, 1000, 1000+10*spacing))
+	assert.Equal(t, 0.2, verificationProgress(9, 1000, 1000+40*spacing))
+}
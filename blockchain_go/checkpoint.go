@@ -0,0 +1,158 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// Checkpoint pins the block of a network at a height
+type Checkpoint struct {
+	Height int64  `json:"height"`
+	Hash   string `json:"hash"` // hex encoded
+}
+
+var (
+	// ErrCheckpointMismatch is returned by ProcessBlock for a block at the
+	// height of a checkpoint with another hash
+	ErrCheckpointMismatch = errors.New("the block conflicts with a checkpoint")
+	// ErrForkBeforeCheckpoint is returned by ProcessBlock for a block of a
+	// branch forking below a checkpoint the chain has passed
+	ErrForkBeforeCheckpoint = errors.New("the block forks from the chain below a checkpoint")
+	// ErrReorgTooDeep is returned by ProcessBlock for a block whose branch
+	// has more work than the chain but forks deeper than MaxReorgDepth. The
+	// block is stored, the chain stays
+	ErrReorgTooDeep = errors.New("the reorganisation is deeper than the limit")
+)
+
+// MaxReorgDepth is the most blocks a reorganisation may disconnect, 0 for
+// no limit
+var MaxReorgDepth = 0
+
+// SkipSigsBelowCheckpoint skips checking the signatures of the blocks up to
+// the last checkpoint, which vouches for them. A branch not leading to the
+// checkpoint is refused at its height
+var SkipSigsBelowCheckpoint = true
+
+// LoadCheckpoints reads a JSON list of checkpoints from a file
+func LoadCheckpoints(path string) ([]Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []Checkpoint
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Height < 0 {
+			return nil, fmt.Errorf("%s: negative checkpoint height %d", path, checkpoint.Height)
+		}
+		hash, err := hex.DecodeString(checkpoint.Hash)
+		if err != nil || len(hash) != 32 {
+			return nil, fmt.Errorf("%s: the checkpoint at height %d has no valid hash", path, checkpoint.Height)
+		}
+	}
+
+	return checkpoints, nil
+}
+
+// UseCheckpoints makes the active network take checkpoints over its own at
+// the same heights
+func UseCheckpoints(checkpoints []Checkpoint) {
+	params := *ActiveNetParams
+	byHeight := make(map[int64]Checkpoint)
+	for _, checkpoint := range params.Checkpoints {
+		byHeight[checkpoint.Height] = checkpoint
+	}
+	for _, checkpoint := range checkpoints {
+		byHeight[checkpoint.Height] = checkpoint
+	}
+	params.Checkpoints = nil
+	for _, checkpoint := range byHeight {
+		params.Checkpoints = append(params.Checkpoints, checkpoint)
+	}
+	sort.Slice(params.Checkpoints, func(i, j int) bool {
+		return params.Checkpoints[i].Height < params.Checkpoints[j].Height
+	})
+	ActiveNetParams = &params
+}
+
+// CheckpointAt returns the hash the checkpoint at height pins, nil without
+// one
+func (p *NetParams) CheckpointAt(height int64) []byte {
+	for _, checkpoint := range p.Checkpoints {
+		if checkpoint.Height == height {
+			hash, _ := hex.DecodeString(checkpoint.Hash)
+			return hash
+		}
+	}
+
+	return nil
+}
+
+// LastCheckpointHeight returns the height of the highest checkpoint, -1
+// without any
+func (p *NetParams) LastCheckpointHeight() int64 {
+	last := int64(-1)
+	for _, checkpoint := range p.Checkpoints {
+		if checkpoint.Height > last {
+			last = checkpoint.Height
+		}
+	}
+
+	return last
+}
+
+// checkCheckpoints refuses a block at the height of a checkpoint with another
+// hash, and a block at or below the highest checkpoint the chain holds
+func (bc *Blockchain) checkCheckpoints(block *Block) error {
+	height := block.Height.Int64()
+	if hash := ActiveNetParams.CheckpointAt(height); hash != nil && !bytes.Equal(hash, block.Hash) {
+		return ErrCheckpointMismatch
+	}
+
+	return bc.Db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(heightBucket))
+		if index == nil {
+			return nil
+		}
+		for _, checkpoint := range ActiveNetParams.Checkpoints {
+			if height > checkpoint.Height {
+				continue
+			}
+			held := index.Get(heightKey(checkpoint.Height))
+			if held != nil && bytes.Equal(held, ActiveNetParams.CheckpointAt(checkpoint.Height)) {
+				return ErrForkBeforeCheckpoint
+			}
+		}
+		return nil
+	})
+}
+
+// reorgDepth returns the number of blocks of the chain above the block of
+// the chain block descends from
+func reorgDepth(tx *bolt.Tx, block *Block) (int, error) {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	index := tx.Bucket([]byte(heightBucket))
+	tipHeight, _ := bestFromIndex(tx)
+	if index == nil || tipHeight == nil {
+		return 0, errors.New("the chain has no height index")
+	}
+	for {
+		if bytes.Equal(index.Get(heightKey(block.Height.Int64())), block.Hash) {
+			return int(tipHeight.Int64() - block.Height.Int64()), nil
+		}
+		data := blocks.Get(block.PrevBlockHash)
+		if data == nil {
+			return 0, fmt.Errorf("block %x is not found", block.PrevBlockHash)
+		}
+		block = DeserializeBlock(data)
+	}
+}
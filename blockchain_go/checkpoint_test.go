@@ -0,0 +1,137 @@
+package core
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoints(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		block1, _ := bc.GetBlockByHeight(1)
+		block2, _ := bc.GetBlockByHeight(2)
+		pin := func(block *Block) Checkpoint {
+			return Checkpoint{block.Height.Int64(), hex.EncodeToString(block.Hash)}
+		}
+
+		withParams(func(params *NetParams) { params.Checkpoints = []Checkpoint{pin(block1)} }, func() {
+			_, err := bc.ProcessBlock(minedBlock(&genesis, address))
+			assert.Equal(t, ErrCheckpointMismatch, err, "A fork at the checkpoint is refused")
+			_, err = bc.ProcessBlock(minedBlock(block1, address))
+			assert.Nil(t, err, "One above it isn't")
+		})
+		withParams(func(params *NetParams) { params.Checkpoints = []Checkpoint{pin(block2)} }, func() {
+			_, err := bc.ProcessBlock(minedBlock(&genesis, address))
+			assert.Equal(t, ErrForkBeforeCheckpoint, err, "A fork below the checkpoint is refused")
+		})
+
+		// a checkpoint the chain hasn't reached pins the next block
+		next := minedBlock(block2, address)
+		other := Checkpoint{3, hex.EncodeToString(make([]byte, 32))}
+		withParams(func(params *NetParams) { params.Checkpoints = []Checkpoint{other} }, func() {
+			_, err := bc.ProcessBlock(next)
+			assert.Equal(t, ErrCheckpointMismatch, err)
+		})
+		withParams(func(params *NetParams) { params.Checkpoints = []Checkpoint{pin(next)} }, func() {
+			_, err := bc.ProcessBlock(next)
+			assert.Nil(t, err)
+		})
+		assert.Equal(t, next.Hash, bc.tip)
+	})
+}
+
+func TestMaxReorgDepth(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		tip := bc.tip
+
+		defer func() { MaxReorgDepth = 0 }()
+		MaxReorgDepth = 1
+		side1 := minedBlock(&genesis, address)
+		side2 := minedBlock(side1, address)
+		side3 := minedBlock(side2, address)
+		for _, block := range []*Block{side1, side2} {
+			_, err := bc.ProcessBlock(block)
+			assert.Nil(t, err)
+		}
+		_, err := bc.ProcessBlock(side3)
+		assert.Equal(t, ErrReorgTooDeep, err, "Two blocks would be disconnected")
+		assert.Equal(t, tip, bc.tip)
+		_, err = bc.GetBlock(side3.Hash)
+		assert.Nil(t, err, "The block is kept")
+
+		MaxReorgDepth = 2
+		side4 := minedBlock(side3, address)
+		reorg, err := bc.ProcessBlock(side4)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, reorg.Depth)
+	})
+}
+
+func TestSkipSigsBelowCheckpoint(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		other := string(NewWallet().GetAddress())
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+
+		send, err := NewUTXOTransaction(ws.Wallets[address], other, 10, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		valid, _ := bc.IsBlockValid(minedBlock(&genesis, address, send))
+		assert.True(t, valid)
+
+		send.Vin[0].Signature[0] ^= 1
+		block := minedBlock(&genesis, address, send)
+		valid, reason := bc.IsBlockValid(block)
+		assert.False(t, valid)
+		assert.Equal(t, 14, reason)
+
+		above := Checkpoint{2, hex.EncodeToString(make([]byte, 32))}
+		withParams(func(params *NetParams) { params.Checkpoints = []Checkpoint{above} }, func() {
+			valid, _ := bc.IsBlockValid(block)
+			assert.True(t, valid, "The checkpoint vouches for the signatures")
+			defer func() { SkipSigsBelowCheckpoint = true }()
+			SkipSigsBelowCheckpoint = false
+			valid, reason := bc.IsBlockValid(block)
+			assert.False(t, valid)
+			assert.Equal(t, 14, reason)
+		})
+	})
+}
+
+func TestLoadCheckpoints(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		hash := hex.EncodeToString(make([]byte, 32))
+		path := filepath.Join(dir, "checkpoints.json")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(`[{"height": 10, "hash": "`+hash+`"}, {"height": 5, "hash": "`+hash+`"}]`), 0600))
+		checkpoints, err := LoadCheckpoints(path)
+		assert.Nil(t, err)
+		assert.Equal(t, []Checkpoint{{10, hash}, {5, hash}}, checkpoints)
+
+		withParams(func(params *NetParams) { params.Checkpoints = []Checkpoint{{5, "aa"}, {7, hash}} }, func() {
+			UseCheckpoints(checkpoints)
+			assert.Equal(t, []Checkpoint{{5, hash}, {7, hash}, {10, hash}}, ActiveNetParams.Checkpoints, "The file overrides the network")
+			assert.Equal(t, int64(10), ActiveNetParams.LastCheckpointHeight())
+			assert.Nil(t, ActiveNetParams.CheckpointAt(6))
+		})
+
+		assert.Nil(t, ioutil.WriteFile(path, []byte(`[{"height": 10, "hash": "beef"}]`), 0600))
+		_, err = LoadCheckpoints(path)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/boltdb/bolt"
+)
+
+// compactTxSize is the most bytes of keys and values CompactDB writes to the
+// new file in one transaction
+const compactTxSize = 16 << 20
+
+// CompactDB copies the buckets of the database into a fresh file at
+// targetPath, the database path with .compact appended if empty, and
+// replaces the database with it. Bolt files never shrink, the copy holds the
+// live data only. The copy is made in a read transaction, the chain is read
+// and written meanwhile. Then the writes are paused with a write transaction
+// of the database, the copy redone in it if anything was written since, and
+// the copy renamed over the database, which must be on the same file system.
+// The original file is untouched until the rename. progress is called, if
+// not nil, with the bytes of keys and values copied and their total
+func (bc *Blockchain) CompactDB(targetPath string, progress func(bytesCopied, bytesTotal int64)) error {
+	path := bc.Db.Path()
+	if targetPath == "" {
+		targetPath = path + ".compact"
+	}
+	if _, err := os.Stat(targetPath); err == nil {
+		return fmt.Errorf("%s already exists", targetPath)
+	}
+
+	var snapshot int
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		snapshot = tx.ID()
+		return compactInto(tx, targetPath, progress)
+	})
+	if err != nil {
+		os.Remove(targetPath)
+		return err
+	}
+
+	// the writes wait until the database is replaced
+	tx, err := bc.Db.Begin(true)
+	if err != nil {
+		os.Remove(targetPath)
+		return err
+	}
+	if tx.ID() != snapshot+1 {
+		os.Remove(targetPath)
+		if err := compactInto(tx, targetPath, progress); err != nil {
+			tx.Rollback()
+			os.Remove(targetPath)
+			return err
+		}
+	}
+	if err := os.Rename(targetPath, path); err != nil {
+		tx.Rollback()
+		os.Remove(targetPath)
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	db, err := openDB(path, false, 0)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	old := bc.Db
+	bc.Db = db
+	tx.Rollback()
+
+	return old.Close()
+}
+
+// compactor writes the keys and values of a database into another, in
+// transactions of up to compactTxSize bytes
+type compactor struct {
+	db       *bolt.DB
+	tx       *bolt.Tx
+	size     int64
+	copied   int64
+	total    int64
+	progress func(bytesCopied, bytesTotal int64)
+}
+
+// compactInto copies the buckets src holds into a new database at path,
+// synced to disk when it returns
+func compactInto(src *bolt.Tx, path string, progress func(bytesCopied, bytesTotal int64)) error {
+	c := &compactor{progress: progress}
+	err := walkBuckets(src, func(path [][]byte, k, v []byte, seq uint64) error {
+		c.total += int64(len(k) + len(v))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.db, err = bolt.Open(path, 0600, nil); err != nil {
+		return err
+	}
+	defer c.db.Close()
+	if c.tx, err = c.db.Begin(true); err != nil {
+		return err
+	}
+	if err := walkBuckets(src, c.put); err != nil {
+		c.tx.Rollback()
+		return err
+	}
+	if err := c.tx.Commit(); err != nil {
+		return err
+	}
+	if c.progress != nil {
+		c.progress(c.total, c.total)
+	}
+
+	return c.db.Close()
+}
+
+// put writes a key and value, or a bucket when v is nil, into the bucket at
+// path
+func (c *compactor) put(path [][]byte, k, v []byte, seq uint64) error {
+	n := int64(len(k) + len(v))
+	if c.size > 0 && c.size+n > compactTxSize {
+		if err := c.tx.Commit(); err != nil {
+			return err
+		}
+		var err error
+		if c.tx, err = c.db.Begin(true); err != nil {
+			return err
+		}
+		c.size = 0
+	}
+	c.size += n
+
+	if len(path) == 0 {
+		b, err := c.tx.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return b.SetSequence(seq)
+	}
+	b := c.tx.Bucket(path[0])
+	for _, name := range path[1:] {
+		b = b.Bucket(name)
+	}
+	// the keys come in order, full pages hold them in the fewest
+	b.FillPercent = 1
+	if v == nil {
+		child, err := b.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return child.SetSequence(seq)
+	}
+	if err := b.Put(k, v); err != nil {
+		return err
+	}
+	c.copied += n
+	if c.progress != nil {
+		c.progress(c.copied, c.total)
+	}
+
+	return nil
+}
+
+// walkBuckets calls fn with every bucket, v nil, and every key and value
+// of tx, parents first, with the names of the buckets holding them
+func walkBuckets(tx *bolt.Tx, fn func(path [][]byte, k, v []byte, seq uint64) error) error {
+	var walk func(path [][]byte, b *bolt.Bucket) error
+	walk = func(path [][]byte, b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				if child := b.Bucket(k); child != nil {
+					if err := fn(path, k, nil, child.Sequence()); err != nil {
+						return err
+					}
+					return walk(append(path[:len(path):len(path)], k), child)
+				}
+				v = []byte{}
+			}
+			return fn(path, k, v, 0)
+		})
+	}
+
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if err := fn(nil, name, nil, b.Sequence()); err != nil {
+			return err
+		}
+		return walk([][]byte{name}, b)
+	})
+}
+
+// syncDir makes a rename in dir durable, where directories can be synced
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// dbContents returns every bucket and key of a database with its value, the
+// bucket names joined with /
+func dbContents(t *testing.T, db *bolt.DB) map[string]string {
+	contents := make(map[string]string)
+	err := db.View(func(tx *bolt.Tx) error {
+		return walkBuckets(tx, func(path [][]byte, k, v []byte, seq uint64) error {
+			key := string(bytes.Join(append(path, k), []byte("/")))
+			if v == nil {
+				contents[key] = "bucket"
+			} else {
+				contents[key] = string(v)
+			}
+			return nil
+		})
+	})
+	assert.Nil(t, err)
+
+	return contents
+}
+
+func TestCompactDB(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer func() { bc.Db.Close() }()
+		UTXOSet{Blockchain: bc}.Reindex()
+
+		// fill the file with pages that are free once the bucket is gone
+		err := bc.Db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("garbage"))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 2000; i++ {
+				if err := b.Put(heightKey(int64(i)), make([]byte, 1024)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, bc.Db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket([]byte("garbage")) }))
+		path := bc.Db.Path()
+		before, _ := os.Stat(path)
+		contents := dbContents(t, bc.Db)
+
+		// the existing target and a target that can't be created leave it be
+		old := bc.Db
+		target := filepath.Join(dir, "taken")
+		assert.Nil(t, ioutil.WriteFile(target, nil, 0600))
+		assert.NotNil(t, bc.CompactDB(target, nil))
+		assert.NotNil(t, bc.CompactDB(filepath.Join(dir, "missing", "db"), nil))
+		assert.Equal(t, old, bc.Db)
+		assert.Equal(t, contents, dbContents(t, bc.Db))
+
+		// a write during the copy is in the compacted database. It may wait
+		// for the copy to commit, growing the file
+		var calls int
+		var copied, total int64
+		done := make(chan error, 1)
+		err = bc.CompactDB("", func(bytesCopied, bytesTotal int64) {
+			calls++
+			copied, total = bytesCopied, bytesTotal
+			if calls == 1 {
+				begun := make(chan bool)
+				go func() {
+					done <- old.Update(func(tx *bolt.Tx) error {
+						close(begun)
+						return tx.Bucket([]byte(blocksBucket)).Put([]byte("written"), []byte("meanwhile"))
+					})
+				}()
+				<-begun
+			}
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, <-done)
+		assert.NotEqual(t, old, bc.Db)
+		assert.Equal(t, total, copied)
+		assert.True(t, total > 0)
+		_, err = os.Stat(path + ".compact")
+		assert.True(t, os.IsNotExist(err), "The copy was renamed")
+		after, _ := os.Stat(path)
+		assert.True(t, after.Size() < before.Size(), "%d bytes from %d", after.Size(), before.Size())
+
+		contents[blocksBucket+"/written"] = "meanwhile"
+		assert.Equal(t, contents, dbContents(t, bc.Db))
+		assert.NotNil(t, mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")}), "The chain goes on")
+	})
+}
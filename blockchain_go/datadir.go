@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DataDirEnv is the environment variable overriding DefaultDataDir
+const DataDirEnv = "SWC_DATADIR"
+
+// dataDir holds the wallet files, key.txt, the pending transaction queues
+// and the chain database. Empty means the working directory
+var dataDir string
+
+// legacyDataFiles match the files that used to be created in the working
+// directory before the data directory existed
+var legacyDataFiles = []string{"wallet_*.dat*", "blockchain_*.db", "*_tx.db", "version_*.db", "key.txt"}
+
+// DefaultDataDir returns $DataDirEnv if set, otherwise the swarmchain
+// directory in the user configuration directory of the OS. It falls back to
+// the working directory when neither is known
+func DefaultDataDir() string {
+	if dir := os.Getenv(DataDirEnv); dir != "" {
+		return dir
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(config, "swarmchain")
+}
+
+// SetDataDir makes dir the data directory, creating it if needed. An empty
+// dir selects the working directory
+func SetDataDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	dataDir = dir
+
+	return nil
+}
+
+// DataDir returns the data directory, empty for the working directory
+func DataDir() string {
+	return dataDir
+}
+
+// DataPath returns the path of the named file in the data directory
+func DataPath(name string) string {
+	if dataDir == "" {
+		return name
+	}
+
+	return filepath.Join(dataDir, name)
+}
+
+// PendingQueueFile returns the pending transaction queue of an address
+func PendingQueueFile(address []byte) string {
+	return DataPath(fmt.Sprintf("%x_tx.db", address))
+}
+
+// PeersFile returns the addresses of the peers the node nodeID learnt about
+func PeersFile(nodeID string) string {
+	return DataPath(fmt.Sprintf("peers_%s.json", nodeID))
+}
+
+// LegacyDataFiles lists the data files left in dir by versions that wrote
+// to the working directory. It returns nothing when dir is the data
+// directory or the data directory already has content, since moving files
+// then could mix two nodes' data
+func LegacyDataFiles(dir string) ([]string, error) {
+	if dataDir == "" {
+		return nil, nil
+	}
+	from, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	to, err := filepath.Abs(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(dataDir)
+	if err != nil || len(entries) > 0 {
+		return nil, err
+	}
+
+	var files []string
+	for _, pattern := range legacyDataFiles {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+// MigrateDataFiles moves files into the data directory
+func MigrateDataFiles(files []string) error {
+	for _, file := range files {
+		err := os.Rename(file, DataPath(filepath.Base(file)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
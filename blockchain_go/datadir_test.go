@@ -0,0 +1,65 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataDir(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		defer SetDataDir("")
+
+		assert.Equal(t, "wallet_test.dat", genWalletDbName("test"), "No data directory means the working directory")
+
+		data := filepath.Join(dir, "data")
+		assert.Nil(t, SetDataDir(data))
+		info, err := os.Stat(data)
+		assert.Nil(t, err)
+		assert.True(t, info.IsDir())
+		assert.Equal(t, filepath.Join(data, "wallet_test_1.dat"), genWalletDbName("test:1"))
+		assert.Equal(t, filepath.Join(data, "blockchain_test.db"), genBlockChainDbName("test"))
+
+		ws, address := newTestWallets()
+		assert.Nil(t, ws.SaveToFile("test"))
+		loaded, err := NewWalletsReadOnly("test")
+		assert.Nil(t, err)
+		assert.Contains(t, loaded.Wallets, address)
+		_, err = os.Stat("wallet_test.dat")
+		assert.True(t, os.IsNotExist(err), "Nothing is written to the working directory")
+
+		os.Setenv(DataDirEnv, data)
+		defer os.Unsetenv(DataDirEnv)
+		assert.Equal(t, data, DefaultDataDir())
+	})
+}
+
+func TestMigrateDataFiles(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		defer SetDataDir("")
+
+		for _, name := range []string{"wallet_test.dat", "blockchain_test.db", "key.txt", "notes.txt"} {
+			assert.Nil(t, ioutil.WriteFile(name, []byte(name), 0600))
+		}
+
+		data := filepath.Join(dir, "data")
+		assert.Nil(t, SetDataDir(data))
+		files, err := LegacyDataFiles(".")
+		assert.Nil(t, err)
+		assert.Len(t, files, 3)
+
+		assert.Nil(t, MigrateDataFiles(files))
+		_, err = os.Stat(filepath.Join(data, "wallet_test.dat"))
+		assert.Nil(t, err)
+		_, err = os.Stat("notes.txt")
+		assert.Nil(t, err, "Unrelated files stay")
+
+		assert.Nil(t, ioutil.WriteFile("wallet_other.dat", nil, 0600))
+		files, err = LegacyDataFiles(".")
+		assert.Nil(t, err)
+		assert.Empty(t, files, "A data directory in use is left alone")
+	})
+}
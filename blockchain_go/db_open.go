@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// DBOpenTimeout is how long opening a blockchain database waits for the
+// lock another process holds on it before returning a DBLockedError
+var DBOpenTimeout = 3 * time.Second
+
+// ErrDBLocked is what a DBLockedError is, for errors.Is
+var ErrDBLocked = errors.New("the blockchain database is locked, is another node running?")
+
+// ErrDBNeedsWrite is returned opening read-only a database that must be
+// upgraded or recovered first, which takes a writable open
+var ErrDBNeedsWrite = errors.New("the blockchain database must be upgraded or recovered, open it writable first, e.g. by starting the node")
+
+// DBLockedError is returned when another process holds the lock of a
+// blockchain database longer than the timeout
+type DBLockedError struct {
+	File string
+	// PID is the running process that last opened the database writable,
+	// likely the holder, 0 when unknown
+	PID int
+}
+
+func (e *DBLockedError) Error() string {
+	if e.PID != 0 {
+		return fmt.Sprintf("the blockchain database %s is locked, likely by PID %d, a node running on it", e.File, e.PID)
+	}
+	return fmt.Sprintf("the blockchain database %s is locked by another process, is a node running on it?", e.File)
+}
+
+// Is makes a DBLockedError match ErrDBLocked
+func (e *DBLockedError) Is(target error) bool {
+	return target == ErrDBLocked
+}
+
+// Options are how NewBlockchainWithOptions opens a chain
+type Options struct {
+	// NodeID names the database, blockchain_NodeID.db
+	NodeID string
+	// ReadOnly opens the database under a shared lock, alongside the other
+	// read-only opens. The methods writing to it return
+	// bolt.ErrDatabaseReadOnly
+	ReadOnly bool
+	// Timeout is how long to wait for the lock of another process,
+	// DBOpenTimeout for 0
+	Timeout time.Duration
+}
+
+// openDBs holds the databases this process opened by path. A lock held by
+// one of them is waited for until it's closed, as the message handlers of
+// the node take turns with the chain, only the lock of another process
+// times out
+var openDBs = struct {
+	sync.Mutex
+	dbs map[string][]*bolt.DB
+}{dbs: make(map[string][]*bolt.DB)}
+
+// openDB opens the bolt database at path, waiting up to timeout for the
+// lock of another process. A writable open records the PID of the process
+// for the DBLockedError of the next ones
+func openDB(path string, readOnly bool, timeout time.Duration) (*bolt.DB, error) {
+	if timeout == 0 {
+		timeout = DBOpenTimeout
+	}
+	for {
+		wait := timeout
+		if heldByProcess(path) {
+			wait = 0
+		}
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: wait, ReadOnly: readOnly})
+		if err == bolt.ErrTimeout {
+			// it may have been opened here meanwhile
+			if heldByProcess(path) {
+				continue
+			}
+			return nil, &DBLockedError{File: path, PID: dbHolder(path)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !readOnly {
+			recordDBHolder(path)
+		}
+
+		openDBs.Lock()
+		openDBs.dbs[path] = append(openDBs.dbs[path], db)
+		openDBs.Unlock()
+		return db, nil
+	}
+}
+
+// dbHolderFile is the file holding the PID of the process that last opened
+// the database at path writable
+func dbHolderFile(path string) string {
+	return path + ".pid"
+}
+
+// recordDBHolder writes the PID of this process to the holder file of the
+// database at path, unless it is there already. It is only a hint, failing
+// to write it isn't an error
+func recordDBHolder(path string) {
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	if content, err := ioutil.ReadFile(dbHolderFile(path)); err == nil && bytes.Equal(content, pid) {
+		return
+	}
+	ioutil.WriteFile(dbHolderFile(path), pid, 0600)
+}
+
+// dbHolder returns the PID of the holder file of the database at path when
+// that process is another one still running, otherwise 0
+func dbHolder(path string) int {
+	content, err := ioutil.ReadFile(dbHolderFile(path))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 || pid == os.Getpid() || !processAlive(pid) {
+		return 0
+	}
+
+	return pid
+}
+
+// checkReadOnlyDB refuses a read-only open of a database of another network,
+// or one openBlockchainDB or the recovery of NewBlockchainWithOptions would
+// write to
+func checkReadOnlyDB(db *bolt.DB) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if b == nil {
+			return ErrNoBlockchain
+		}
+		if stored := b.Get([]byte(genesisConfigKey)); stored != nil && !bytes.Equal(stored, ActiveNetParams.Genesis.Hash()) {
+			return ErrGenesisMismatch
+		}
+
+		tip := b.Get([]byte("l"))
+		if tip == nil {
+			return nil
+		}
+		if tx.Bucket([]byte(heightBucket)) == nil || (TxIndexEnabled && tx.Bucket([]byte(txIndexBucket)) == nil) {
+			return ErrDBNeedsWrite
+		}
+		if s := tx.Bucket([]byte(chainStateBucket)); s == nil || s.Get(chainStateKey) == nil {
+			return ErrDBNeedsWrite
+		}
+		if r := tx.Bucket([]byte(reorgBucket)); r != nil && len(r.Get(reorgTargetKey)) > 0 {
+			return ErrDBNeedsWrite
+		}
+		if tx.Bucket([]byte(utxoBucket)) == nil {
+			return nil
+		}
+		if h := tx.Bucket([]byte(utxoHashBucket)); tx.Bucket([]byte(utxoAddrBucket)) == nil || h == nil || h.Get(utxoHashKey) == nil {
+			return ErrDBNeedsWrite
+		}
+		if t := tx.Bucket([]byte(utxoTipBucket)); t != nil && len(t.Get(utxoTipKey)) > 0 && !bytes.Equal(t.Get(utxoTipKey), tip) {
+			return ErrDBNeedsWrite
+		}
+
+		return nil
+	})
+}
+
+// heldByProcess reports whether a database this process opened at path is
+// still open, forgetting those closed since
+func heldByProcess(path string) bool {
+	openDBs.Lock()
+	defer openDBs.Unlock()
+
+	var open []*bolt.DB
+	for _, db := range openDBs.dbs[path] {
+		if db.View(func(*bolt.Tx) error { return nil }) != bolt.ErrDatabaseNotOpen {
+			open = append(open, db)
+		}
+	}
+	if len(open) == 0 {
+		delete(openDBs.dbs, path)
+		return false
+	}
+	openDBs.dbs[path] = open
+
+	return true
+}
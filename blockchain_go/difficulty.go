@@ -0,0 +1,277 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// NetParams are the proof-of-work and block limit parameters of a network
+type NetParams struct {
+	Name string
+	// AddressVersion is the version byte of the Base58Check addresses of the
+	// network, so an address of one network is refused on another
+	AddressVersion byte
+	// Bech32HRP is the human readable part of the Bech32 addresses of the
+	// network, which refuses those of another the same way
+	Bech32HRP string
+	// PowLimitBits is the compact target of the genesis block, the easiest
+	// target a block may have
+	PowLimitBits uint32
+	// TargetSpacing is the desired number of seconds between blocks
+	TargetSpacing int64
+	// RetargetInterval is the number of blocks between two adjustments of
+	// the target
+	RetargetInterval int64
+	// MaxRetargetFactor bounds the change of the target per adjustment
+	MaxRetargetFactor int64
+	// NoRetargeting keeps the target of the genesis block forever
+	NoRetargeting bool
+	// MaxBlockSerializedSize is the most bytes a block may take encoded with
+	// Block.Serialize, as the network sends it
+	MaxBlockSerializedSize int
+	// MaxBlockTxCount is the most transactions a block may hold, the
+	// coinbase included
+	MaxBlockTxCount int
+	// Genesis describes the genesis block and the subsidy schedule
+	Genesis GenesisConfig
+	// Checkpoints pin blocks of the chain, ordered by height. Blocks
+	// conflicting with them are refused, see UseCheckpoints for more
+	Checkpoints []Checkpoint
+}
+
+// MainNetParams retarget every 100 blocks towards a block every 10 seconds.
+// The limit is the target blocks had with 4 bits of difficulty
+var MainNetParams = NetParams{
+	Name:              "main",
+	AddressVersion:    0x00,
+	Bech32HRP:         "swc",
+	PowLimitBits:      0x20100000,
+	TargetSpacing:     10,
+	RetargetInterval:  100,
+	MaxRetargetFactor: 4,
+
+	MaxBlockSerializedSize: 1 << 20,
+	MaxBlockTxCount:        10000,
+
+	Genesis: MainNetGenesis,
+}
+
+// TestNetParams are those of the main network, with a genesis block of
+// their own
+var TestNetParams = NetParams{
+	Name:              "testnet",
+	AddressVersion:    0x6f,
+	Bech32HRP:         "tswc",
+	PowLimitBits:      0x20100000,
+	TargetSpacing:     10,
+	RetargetInterval:  100,
+	MaxRetargetFactor: 4,
+
+	MaxBlockSerializedSize: 1 << 20,
+	MaxBlockTxCount:        10000,
+
+	Genesis: TestNetGenesis,
+}
+
+// RegTestParams never retarget from a target half the hashes meet, for
+// tests and local networks
+var RegTestParams = NetParams{
+	Name:              "regtest",
+	AddressVersion:    0x6f,
+	Bech32HRP:         "rswc",
+	PowLimitBits:      0x207fffff,
+	TargetSpacing:     10,
+	RetargetInterval:  100,
+	MaxRetargetFactor: 4,
+	NoRetargeting:     true,
+
+	MaxBlockSerializedSize: 1 << 20,
+	MaxBlockTxCount:        10000,
+
+	Genesis: RegTestGenesis,
+}
+
+// ActiveNetParams are the parameters blocks are mined and validated with
+var ActiveNetParams = &MainNetParams
+
+// SelectNetParams makes the network called name active
+func SelectNetParams(name string) error {
+	params, ok := knownNetParams(name)
+	if !ok {
+		return fmt.Errorf("unknown network %q", name)
+	}
+	ActiveNetParams = params
+
+	return nil
+}
+
+// knownNetParams returns the parameters of the known network called name,
+// or MainNetParams and false
+func knownNetParams(name string) (*NetParams, bool) {
+	for _, params := range []*NetParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if params.Name == name {
+			return params, true
+		}
+	}
+
+	return &MainNetParams, false
+}
+
+// PowLimit returns the easiest target of the network
+func (p *NetParams) PowLimit() *big.Int {
+	return CompactToBig(p.PowLimitBits)
+}
+
+// TargetTimespan returns the number of seconds a retarget interval should
+// take
+func (p *NetParams) TargetTimespan() int64 {
+	return p.RetargetInterval * p.TargetSpacing
+}
+
+// retarget returns the compact target following target when the last
+// interval took timespan seconds, the change clamped to MaxRetargetFactor
+// either way and the target to PowLimit
+func (p *NetParams) retarget(target *big.Int, timespan int64) uint32 {
+	expected := p.TargetTimespan()
+	if timespan < expected/p.MaxRetargetFactor {
+		timespan = expected / p.MaxRetargetFactor
+	}
+	if timespan > expected*p.MaxRetargetFactor {
+		timespan = expected * p.MaxRetargetFactor
+	}
+
+	next := new(big.Int).Mul(target, big.NewInt(timespan))
+	next.Div(next, big.NewInt(expected))
+	if limit := p.PowLimit(); next.Cmp(limit) > 0 {
+		next = limit
+	}
+
+	return BigToCompact(next)
+}
+
+// CompactToBig returns the target a compact representation stands for. The
+// high byte of compact is the length of the target in bytes, the low three
+// its most significant bytes. Negative targets are returned as 0
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	exponent := uint(compact >> 24)
+	if compact&0x00800000 != 0 {
+		return new(big.Int)
+	}
+
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		return target.Rsh(target, 8*(3-exponent))
+	}
+
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact returns the compact representation of a non-negative target,
+// rounded down to its three most significant bytes
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	exponent := uint(len(target.Bytes()))
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+	// the sign bit of the mantissa must stay clear
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+// CalcWork returns the expected number of hashes mining a block with the
+// compact target takes, 2^256 / (target + 1)
+func CalcWork(bits uint32) *big.Int {
+	return workForTarget(CompactToBig(bits))
+}
+
+func workForTarget(target *big.Int) *big.Int {
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	denominator := new(big.Int).Add(target, big1)
+
+	return new(big.Int).Div(new(big.Int).Lsh(big1, 256), denominator)
+}
+
+// maxDifficulty is the most leading zero bits a hash has
+const maxDifficulty = 256
+
+// ErrBadDifficulty is returned for a block without bits whose difficulty
+// isn't a number of leading zero bits of a hash
+var ErrBadDifficulty = errors.New("the difficulty of the block is out of range")
+
+// checkDifficulty returns ErrBadDifficulty for a header without bits whose
+// difficulty is negative or above maxDifficulty
+func (b *BlockHeader) checkDifficulty() error {
+	if b.Bits == 0 && b.Difficulty != nil &&
+		(b.Difficulty.Sign() < 0 || b.Difficulty.Cmp(big.NewInt(maxDifficulty)) > 0) {
+		return ErrBadDifficulty
+	}
+
+	return nil
+}
+
+// Target returns the target the hash of the block must be below. Blocks
+// mined before compact targets have their number of leading zero bits in
+// Difficulty instead of Bits. A difficulty out of range has a target of 0,
+// which no hash meets
+func (b *BlockHeader) Target() *big.Int {
+	if b.Bits != 0 {
+		return CompactToBig(b.Bits)
+	}
+	if b.Difficulty == nil || b.checkDifficulty() != nil {
+		return new(big.Int)
+	}
+
+	return new(big.Int).Lsh(big1, uint(256-b.Difficulty.Int64()))
+}
+
+// Work returns the expected number of hashes mining the block took
+func (b *BlockHeader) Work() *big.Int {
+	return workForTarget(b.Target())
+}
+
+// CalcNextBits returns the compact target of the child of parent, a stored
+// header. The target changes every RetargetInterval blocks by the ratio of
+// the time the last interval took to TargetTimespan
+func (bc *Blockchain) CalcNextBits(parent *BlockHeader) (uint32, error) {
+	params := ActiveNetParams
+	target := parent.Target()
+	height := parent.Height.Int64() + 1
+	if params.NoRetargeting || height%params.RetargetInterval != 0 {
+		return BigToCompact(target), nil
+	}
+
+	// the first block of the interval
+	first := parent
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		for i := int64(1); i < params.RetargetInterval; i++ {
+			var err error
+			if first, err = headerAt(tx, first.PrevBlockHash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	timespan := new(big.Int).Sub(parent.Timestamp, first.Timestamp).Int64()
+
+	return params.retarget(target, timespan), nil
+}
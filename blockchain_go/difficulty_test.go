@@ -0,0 +1,124 @@
+package core
+
+import (
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain mines the test chains with the regtest parameters, half the
+// hashes meeting their target
+func TestMain(m *testing.M) {
+	ActiveNetParams = &RegTestParams
+	os.Exit(m.Run())
+}
+
+func TestCompact(t *testing.T) {
+	assert.Equal(t, new(big.Int).Lsh(big1, 252), CompactToBig(MainNetParams.PowLimitBits))
+	assert.Equal(t, MainNetParams.PowLimitBits, BigToCompact(new(big.Int).Lsh(big1, 252)))
+	bitcoin, _ := new(big.Int).SetString("ffff0000000000000000000000000000000000000000000000000000", 16)
+	assert.Equal(t, bitcoin, CompactToBig(0x1d00ffff))
+	assert.Equal(t, uint32(0x1d00ffff), BigToCompact(bitcoin))
+	assert.Equal(t, uint32(0x02008000), BigToCompact(big.NewInt(0x80)), "The sign bit stays clear")
+	assert.Equal(t, big.NewInt(0x80), CompactToBig(0x02008000))
+	assert.Equal(t, big.NewInt(0x12), CompactToBig(0x01120000))
+	assert.Equal(t, 0, CompactToBig(0x04923456).Sign(), "Negative targets are 0")
+	assert.Equal(t, uint32(0), BigToCompact(new(big.Int)))
+
+	assert.Equal(t, big.NewInt(2), CalcWork(RegTestParams.PowLimitBits))
+	assert.Equal(t, big.NewInt(15), CalcWork(MainNetParams.PowLimitBits))
+	assert.Equal(t, big.NewInt(15), (&BlockHeader{Difficulty: big.NewInt(4)}).Work(), "Blocks without bits use their difficulty")
+
+	// a difficulty no hash has leading zero bits for meets no target
+	for _, difficulty := range []int64{-1, maxDifficulty + 1, 1 << 40} {
+		header := &BlockHeader{Difficulty: big.NewInt(difficulty)}
+		assert.Equal(t, ErrBadDifficulty, header.checkDifficulty())
+		assert.Equal(t, 0, header.Target().Sign())
+		assert.Equal(t, 0, header.Work().Sign())
+	}
+	assert.Nil(t, (&BlockHeader{Difficulty: big.NewInt(maxDifficulty)}).checkDifficulty())
+}
+
+func TestRetarget(t *testing.T) {
+	params := MainNetParams
+	expected := params.TargetTimespan()
+	target := new(big.Int).Rsh(params.PowLimit(), 8)
+	for _, test := range []struct {
+		name     string
+		timespan int64
+		target   *big.Int
+	}{
+		{"on time", expected, target},
+		{"twice as fast", expected / 2, new(big.Int).Div(target, big.NewInt(2))},
+		{"clamped faster", 0, new(big.Int).Div(target, big.NewInt(4))},
+		{"twice as slow", 2 * expected, new(big.Int).Mul(target, big.NewInt(2))},
+		{"clamped slower", 100 * expected, new(big.Int).Mul(target, big.NewInt(4))},
+	} {
+		assert.Equal(t, BigToCompact(test.target), params.retarget(target, test.timespan), test.name)
+	}
+	assert.Equal(t, params.PowLimitBits, params.retarget(params.PowLimit(), 2*expected), "The target stays below the limit")
+}
+
+func TestCalcNextBits(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		defer func(params *NetParams) { ActiveNetParams = params }(ActiveNetParams)
+		params := RegTestParams
+		params.NoRetargeting = false
+		params.RetargetInterval = 4
+		ActiveNetParams = &params
+
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		// mines a block on the tip a second after it, with the given bits
+		// when they aren't 0
+		mine := func(bits uint32) *Block {
+			height, tip := bestTip(bc)
+			parent, err := bc.GetBlock(tip)
+			assert.Nil(t, err)
+			block := NewBlock([]*Transaction{NewCoinbaseTX(address, "")}, tip, new(big.Int).Add(height, big1), false, bc)
+			block.Timestamp = new(big.Int).Add(parent.Timestamp, big1)
+			if bits != 0 {
+				block.Bits = bits
+			}
+			block.Nonce, block.Hash = NewProofOfWork(block).Run()
+			return block
+		}
+
+		for i := 0; i < 3; i++ {
+			block := mine(0)
+			assert.Equal(t, params.PowLimitBits, block.Bits, "The target holds within an interval")
+			assert.Nil(t, NewBlockImporter(bc, 1).Add(block))
+		}
+		_, tip := bestTip(bc)
+		td, err := bc.GetTd(tip)
+		assert.Nil(t, err)
+		assert.Equal(t, new(big.Int).Mul(CalcWork(params.PowLimitBits), big.NewInt(4)), td)
+
+		// the interval took 3 seconds instead of 40, the target falls 4x
+		harder := BigToCompact(new(big.Int).Div(params.PowLimit(), big.NewInt(4)))
+		parent, _ := bc.GetBlock(tip)
+		bits, err := bc.CalcNextBits(&parent.BlockHeader)
+		assert.Nil(t, err)
+		assert.Equal(t, harder, bits)
+
+		easy := mine(params.PowLimitBits)
+		valid, reason := bc.IsBlockValid(easy)
+		assert.False(t, valid)
+		assert.Equal(t, 9, reason)
+		block := mine(0)
+		assert.Equal(t, harder, block.Bits)
+		valid, _ = bc.IsBlockValid(block)
+		assert.True(t, valid)
+
+		assert.Nil(t, NewBlockImporter(bc, 1).Add(block))
+		next, err := bc.GetTd(block.Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, new(big.Int).Add(td, CalcWork(harder)), next, "Harder blocks count for more work")
+		assert.Equal(t, 1, CalcWork(harder).Cmp(CalcWork(params.PowLimitBits)))
+	})
+}
@@ -0,0 +1,16 @@
+// +build !windows
+
+package core
+
+import "syscall"
+
+// FreeDiskSpace returns the bytes available to the user on the file system
+// holding path
+func FreeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,25 @@
+// +build windows
+
+package core
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// FreeDiskSpace returns the bytes available to the user on the volume
+// holding path
+func FreeDiskSpace(path string) (uint64, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	r, _, err := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW").Call(
+		uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+
+	return free, nil
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// FeeEstimateBlocks is the number of most recent blocks EstimateFee learns
+// the fee rates from. Their fees are read from their undo records, a
+// Blockchain keeping fewer, see SetUndoDepth, gets FallbackFeeRate
+var FeeEstimateBlocks = 100
+
+// FallbackFeeRate is the fee rate EstimateFee returns for a chain of fewer
+// than FeeEstimateBlocks blocks, or whose last ones hold no transaction but
+// their coinbase. It is 0, what transactions paid before fees were
+// estimated, no node asking for a fee
+var FallbackFeeRate int64 = 0
+
+// DefaultFeeTarget is the number of blocks NewUTXOTransaction aims to get
+// its transaction mined within
+const DefaultFeeTarget = 6
+
+// ErrFeeTarget is returned by EstimateFee for a target of less than a block
+var ErrFeeTarget = errors.New("the fee target must be at least one block")
+
+// blockFeeRates returns the fee rates, per 1000 bytes, of the transactions of a
+// block but its coinbase, their inputs read from the undo record of the
+// block. ok is false without the record
+func blockFeeRates(undo *bolt.Bucket, block *Block) (rates []int64, ok bool, err error) {
+	data := undo.Get(block.Hash)
+	if data == nil {
+		return nil, false, nil
+	}
+	spent, err := deserializeSpentOutputs(data)
+	if err != nil {
+		return nil, false, err
+	}
+	values := make(map[Outpoint]int, len(spent))
+	for _, s := range spent {
+		values[Outpoint{hex.EncodeToString(s.TxID), s.Vout}] = s.Output.Value
+	}
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		fee, known := 0, true
+		for _, vin := range tx.Vin {
+			value, ok := values[Outpoint{hex.EncodeToString(vin.Txid), vin.Vout}]
+			known = known && ok
+			fee += value
+		}
+		for _, vout := range tx.Vout {
+			fee -= vout.Value
+		}
+		if known && fee >= 0 {
+			rates = append(rates, int64(fee)*1000/int64(len(tx.Serialize())))
+		}
+	}
+
+	return rates, true, nil
+}
+
+// EstimateFee returns the fee rate, per 1000 bytes, that got transactions mined
+// within targetBlocks blocks over the last FeeEstimateBlocks blocks: of each
+// run of targetBlocks blocks holding transactions, the lowest rate one of
+// them included, and of those the median. Blocks holding only their
+// coinbase are left out. Without enough blocks it returns FallbackFeeRate
+func (bc *Blockchain) EstimateFee(targetBlocks int) (int64, error) {
+	if targetBlocks < 1 {
+		return 0, ErrFeeTarget
+	}
+
+	var lowest []int64 // the lowest rate of each block, oldest first
+	enough := false
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		index := tx.Bucket([]byte(heightBucket))
+		undo := tx.Bucket([]byte(utxoUndoBucket))
+		tipHeight, _ := bestFromIndex(tx)
+		if index == nil || undo == nil || tipHeight == nil || tipHeight.Int64() < int64(FeeEstimateBlocks) {
+			return nil
+		}
+		for height := tipHeight.Int64() - int64(FeeEstimateBlocks) + 1; height <= tipHeight.Int64(); height++ {
+			data := blocks.Get(index.Get(heightKey(height)))
+			if data == nil {
+				return nil // pruned
+			}
+			rates, ok, err := blockFeeRates(undo, DeserializeBlock(data))
+			if err != nil || !ok {
+				return err
+			}
+			if len(rates) == 0 {
+				continue
+			}
+			min := rates[0]
+			for _, rate := range rates[1:] {
+				if rate < min {
+					min = rate
+				}
+			}
+			lowest = append(lowest, min)
+		}
+		enough = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !enough || len(lowest) == 0 {
+		return FallbackFeeRate, nil
+	}
+
+	if targetBlocks > len(lowest) {
+		targetBlocks = len(lowest)
+	}
+	runs := make([]int64, 0, len(lowest)-targetBlocks+1)
+	for i := 0; i+targetBlocks <= len(lowest); i++ {
+		min := lowest[i]
+		for _, rate := range lowest[i+1 : i+targetBlocks] {
+			if rate < min {
+				min = rate
+			}
+		}
+		runs = append(runs, min)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i] < runs[j] })
+
+	return runs[(len(runs)-1)/2], nil
+}
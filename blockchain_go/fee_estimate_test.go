@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateFee(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		wallet := ws.Wallets[address]
+		other := fmt.Sprintf("%s", NewWallet().GetAddress())
+		bc := newTestChain(address, address, address, address)
+		defer bc.Db.Close()
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		defer func(blocks int) { FeeEstimateBlocks = blocks }(FeeEstimateBlocks)
+
+		_, err := bc.EstimateFee(0)
+		assert.Equal(t, ErrFeeTarget, err)
+		// too few blocks, then blocks holding their coinbase only
+		for _, blocks := range []int{4, 3} {
+			FeeEstimateBlocks = blocks
+			rate, err := bc.EstimateFee(1)
+			assert.Nil(t, err)
+			assert.Equal(t, FallbackFeeRate, rate)
+		}
+
+		// blocks paying about 10, 50 and 30 per 1000 bytes, then an empty one
+		FeeEstimateBlocks = 4
+		var lowest []int64
+		for _, feeRate := range []int64{10, 50, 30} {
+			tx, err := NewUTXOTransactionFee(wallet, other, 1, &u, nil, 1, feeRate)
+			assert.Nil(t, err)
+			fee, err := u.BlockFees([]*Transaction{tx})
+			assert.Nil(t, err)
+			assert.True(t, fee > 0 && int64(fee) <= (feeRate*int64(len(tx.Serialize()))+999)/1000, "%d", fee)
+			block := mustMine(bc, []*Transaction{NewCoinbaseTX(address, ""), tx})
+			u.Update(block)
+			bc.Db.View(func(dbTx *bolt.Tx) error {
+				rates, ok, err := blockFeeRates(dbTx.Bucket([]byte(utxoUndoBucket)), block)
+				assert.Nil(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 1, len(rates))
+				lowest = append(lowest, rates[0])
+				return nil
+			})
+		}
+		u.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")}))
+
+		// the median of the lowest rates of each run of target blocks
+		for target, want := range map[int]int64{1: lowest[2], 2: lowest[0], 5: lowest[0]} {
+			rate, err := bc.EstimateFee(target)
+			assert.Nil(t, err)
+			assert.Equal(t, want, rate, "%d blocks", target)
+		}
+		assert.True(t, lowest[0] < lowest[2] && lowest[2] < lowest[1])
+
+		_, err = NewUTXOTransactionFee(wallet, other, 1, &u, nil, 1, -1)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,249 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// chainWorkBucket maps the hash of a stored block to the total work of the
+// chain up to and including it
+const chainWorkBucket = "chainwork"
+
+// invalidBlocksBucket maps the hash of a stored block VerifyBlock refused
+// while the chain was reorganised to its branch, or of a block above it on
+// that branch, to the reason
+const invalidBlocksBucket = "invalidblocks"
+
+// ErrOrphanBlock is returned by ProcessBlock for a block whose parent isn't
+// stored
+var ErrOrphanBlock = errors.New("the parent of the block is unknown")
+
+// ErrInvalidAncestor is returned by ProcessBlock for a block extending a
+// block marked invalid, and for a branch through one
+var ErrInvalidAncestor = errors.New("the block descends from an invalid block")
+
+// ReorgEvent describes a switch of the chain to a branch with more work
+type ReorgEvent struct {
+	OldTip []byte
+	NewTip []byte
+	Depth  int // the number of blocks disconnected
+	// Disconnected are the blocks of the old chain above the fork, tip
+	// first, Connected those of the branch, oldest first
+	Disconnected []*Block
+	Connected    []*Block
+	// Orphaned are the transactions of the disconnected blocks the branch
+	// doesn't include, coinbases aside, oldest first, for the mempool
+	Orphaned []*Transaction
+}
+
+// GetTd returns the total work of the chain up to and including the block
+// with the given hash
+func (bc *Blockchain) GetTd(blockHash []byte) (*big.Int, error) {
+	var td *big.Int
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		var err error
+		td, err = chainWork(tx, blockHash)
+		return err
+	})
+
+	return td, err
+}
+
+// chainWork returns the total work up to hash, adding the work of the blocks
+// from hash down to the first one indexed, for blocks stored before the index
+func chainWork(tx *bolt.Tx, hash []byte) (*big.Int, error) {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	index := tx.Bucket([]byte(chainWorkBucket))
+	td := new(big.Int)
+	for len(hash) > 0 {
+		if index != nil {
+			if work := index.Get(hash); work != nil {
+				return td.Add(td, new(big.Int).SetBytes(work)), nil
+			}
+		}
+		data := blocks.Get(hash)
+		if data == nil {
+			return nil, fmt.Errorf("block %x is not found", hash)
+		}
+		block := DeserializeBlock(data)
+		td.Add(td, block.Work())
+		hash = block.PrevBlockHash
+	}
+
+	return td, nil
+}
+
+// putChainWork indexes the total work up to block, whose parent is stored
+func putChainWork(tx *bolt.Tx, block *Block) (*big.Int, error) {
+	td, err := chainWork(tx, block.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	td.Add(td, block.Work())
+	index, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return nil, err
+	}
+
+	return td, index.Put(block.Hash, td.Bytes())
+}
+
+// markInvalid marks blocks invalid for reason, keeping the reason of those
+// marked already
+func (bc *Blockchain) markInvalid(blocks []*Block, reason error) error {
+	return bc.Db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(invalidBlocksBucket))
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if b.Get(block.Hash) != nil {
+				continue
+			}
+			if err := b.Put(block.Hash, []byte(reason.Error())); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// isMarkedInvalid tells whether the block of hash is marked invalid
+func isMarkedInvalid(tx *bolt.Tx, hash []byte) bool {
+	b := tx.Bucket([]byte(invalidBlocksBucket))
+	return b != nil && b.Get(hash) != nil
+}
+
+// SubscribeReorgs sends every reorganisation of the chain to ch once it is
+// done. The sends block, ch must be drained
+func (bc *Blockchain) SubscribeReorgs(ch chan<- ReorgEvent) {
+	bc.reorgMu.Lock()
+	defer bc.reorgMu.Unlock()
+	bc.reorgSubs = append(bc.reorgSubs, ch)
+}
+
+func (bc *Blockchain) notifyReorg(event ReorgEvent) {
+	bc.reorgMu.Lock()
+	subs := append([]chan<- ReorgEvent(nil), bc.reorgSubs...)
+	bc.reorgMu.Unlock()
+	for _, ch := range subs {
+		ch <- event
+	}
+}
+
+// ProcessBlock stores a block and keeps the chain on the tip with the most
+// work. A block extending the tip is validated with VerifyBlock, whose
+// error tells why it's refused, and connected. A block of a side branch is
+// stored once the checks of VerifyBlock not needing the UTXO set pass
+// against its parent, and when its branch has more work than the chain, the
+// chain is reorganised to it: the event is returned and sent to the
+// subscribers. The blocks of the branch are validated with VerifyBlock as
+// they are connected, a refused one leaves the chain where it was and is
+// marked invalid with the blocks above it, and its error is returned; a
+// block extending a block marked invalid is refused with ErrInvalidAncestor.
+// On a tie the chain seen first stays. Blocks already stored are ignored.
+// Blocks conflicting with the checkpoints are refused, see
+// checkCheckpoints, and with MaxReorgDepth set a branch forking deeper is
+// stored without reorganising to it
+func (bc *Blockchain) ProcessBlock(block *Block) (*ReorgEvent, error) {
+	var parent *Block
+	var known, invalidParent bool
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		known = blocks.Get(block.Hash) != nil
+		if data := blocks.Get(block.PrevBlockHash); data != nil {
+			parent = DeserializeBlock(data)
+		}
+		invalidParent = isMarkedInvalid(tx, block.PrevBlockHash)
+		return nil
+	})
+	if err != nil || known {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, ErrOrphanBlock
+	}
+	if invalidParent {
+		return nil, ErrInvalidAncestor
+	}
+	if err := bc.checkCheckpoints(block); err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(block.PrevBlockHash, bc.tip) {
+		if err := bc.VerifyBlock(block, parent); err != nil {
+			return nil, err
+		}
+		return nil, NewBlockImporter(bc, 1).Add(block)
+	}
+
+	if err := bc.verifyBlockAgainstParent(block, parent); err != nil {
+		return nil, err
+	}
+	var heavier, tooDeep bool
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		if err := putBlock(tx, block); err != nil {
+			return err
+		}
+		td, err := putChainWork(tx, block)
+		if err != nil {
+			return err
+		}
+		tipTd, err := chainWork(tx, blocks.Get([]byte("l")))
+		if err != nil {
+			return err
+		}
+		heavier = td.Cmp(tipTd) > 0
+		if !heavier {
+			return nil
+		}
+		if MaxReorgDepth > 0 {
+			depth, err := reorgDepth(tx, block)
+			if err != nil {
+				return err
+			}
+			tooDeep = depth > MaxReorgDepth
+			if tooDeep {
+				return nil
+			}
+		}
+		return beginReorg(tx, block.Hash)
+	})
+	if err != nil || !heavier {
+		return nil, err
+	}
+	if tooDeep {
+		return nil, ErrReorgTooDeep
+	}
+
+	return bc.reorganize(block.Hash)
+}
+
+// reorganize moves the chain to target, a stored block, and notifies the
+// subscribers
+func (bc *Blockchain) reorganize(target []byte) (*ReorgEvent, error) {
+	oldTip := bc.tip
+	disconnected, connected, err := bc.reorganizeTo(target, true)
+	if err != nil {
+		return nil, err
+	}
+
+	event := ReorgEvent{
+		OldTip:       oldTip,
+		NewTip:       target,
+		Depth:        len(disconnected),
+		Disconnected: disconnected,
+		Connected:    connected,
+		Orphaned:     orphanedTransactions(disconnected, connected),
+	}
+	log.Printf("reorganised the chain from %x to %x, %d blocks deep", oldTip, target, event.Depth)
+	bc.notifyReorg(event)
+
+	return &event, nil
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// minedBlock returns a block on top of prev paying its coinbase to address,
+// mined with the target of the active network
+func minedBlock(prev *Block, address string, txs ...*Transaction) *Block {
+	block := forkBlock(prev, address, txs...)
+	block.Bits = ActiveNetParams.PowLimitBits
+	block.Nonce, block.Hash = NewProofOfWork(block).Run()
+
+	return block
+}
+
+// freshSync imports the chain of bc into a new chain of nodeID sharing its
+// genesis block, as a node syncing from scratch would
+func freshSync(t *testing.T, bc *Blockchain, nodeID string) *Blockchain {
+	var blocks []*Block
+	for bci := bc.Iterator(); ; {
+		block, err := bci.Next()
+		assert.Nil(t, err)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+		blocks = append([]*Block{block}, blocks...)
+	}
+	genesis, err := bc.GetBlock(bc.GenesisHash)
+	assert.Nil(t, err)
+
+	db, err := bolt.Open(genBlockChainDbName(nodeID), 0600, nil)
+	assert.Nil(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		for _, key := range []string{"l", "g"} {
+			if err := b.Put([]byte(key), genesis.Hash); err != nil {
+				return err
+			}
+		}
+		return b.Put(genesis.Hash, genesis.Serialize())
+	})
+	assert.Nil(t, err)
+	db.Close()
+
+	fresh, err := NewBlockchain(nodeID)
+	assert.Nil(t, err)
+	UTXOSet{Blockchain: fresh}.Reindex()
+	importer := NewBlockImporter(fresh, 0)
+	for _, block := range blocks {
+		assert.Nil(t, importer.Add(block))
+	}
+	assert.Nil(t, importer.Flush())
+
+	return fresh
+}
+
+func TestProcessBlock(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		f := newTestFork()
+		defer f.bc.Db.Close()
+		bc, address, other := f.bc, f.address, f.other
+		events := make(chan ReorgEvent, 2)
+		bc.SubscribeReorgs(events)
+		send, err := NewUTXOTransaction(f.wallet, other, 10, &f.utxo, nil, 1)
+		assert.Nil(t, err)
+		a1 := minedBlock(f.genesis, address, send)
+		reorg, err := bc.ProcessBlock(a1)
+		assert.Nil(t, err)
+		assert.Nil(t, reorg)
+
+		// a 1-block reorganisation, the tie keeps a1
+		b1 := minedBlock(f.genesis, other)
+		reorg, err = bc.ProcessBlock(b1)
+		assert.Nil(t, err)
+		assert.Nil(t, reorg, "The chain seen first wins a tie")
+		_, tip := bestTip(bc)
+		assert.Equal(t, a1.Hash, tip)
+		b2 := minedBlock(b1, other)
+		reorg, err = bc.ProcessBlock(b2)
+		assert.Nil(t, err)
+		assert.NotNil(t, reorg)
+		assert.Equal(t, a1.Hash, reorg.OldTip)
+		assert.Equal(t, b2.Hash, reorg.NewTip)
+		assert.Equal(t, 1, reorg.Depth)
+		assert.Len(t, reorg.Orphaned, 1, "The send goes back to the mempool")
+		assert.Equal(t, send.ID, reorg.Orphaned[0].ID)
+		assert.Equal(t, *reorg, <-events)
+		assert.Equal(t, b2.Hash, mustTip(f.utxo.BestBlock()))
+		mine, others := f.assertMatchesReplay(t)
+		assert.Equal(t, int64(subsidy), mine)
+		assert.Equal(t, int64(2*subsidy), others)
+
+		// a 3-block reorganisation back to a, which includes the send
+		b3 := minedBlock(b2, other)
+		reorg, err = bc.ProcessBlock(b3)
+		assert.Nil(t, err)
+		assert.Nil(t, reorg, "b3 extends the tip")
+		a2 := minedBlock(a1, address)
+		a3 := minedBlock(a2, address)
+		for _, side := range []*Block{a2, a3} {
+			reorg, err = bc.ProcessBlock(side)
+			assert.Nil(t, err)
+			assert.Nil(t, reorg)
+		}
+		a4 := minedBlock(a3, address)
+		reorg, err = bc.ProcessBlock(a4)
+		assert.Nil(t, err)
+		assert.NotNil(t, reorg)
+		assert.Equal(t, b3.Hash, reorg.OldTip)
+		assert.Equal(t, a4.Hash, reorg.NewTip)
+		assert.Equal(t, 3, reorg.Depth)
+		assert.Len(t, reorg.Connected, 4)
+		assert.Empty(t, reorg.Orphaned)
+		assert.Equal(t, *reorg, <-events)
+		td, err := bc.GetTd(a4.Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, new(big.Int).Mul(CalcWork(ActiveNetParams.PowLimitBits), big.NewInt(5)), td)
+		mine, others = f.assertMatchesReplay(t)
+		assert.Equal(t, int64(10), others)
+		assert.Equal(t, int64(5*subsidy-10), mine)
+
+		reorg, err = bc.ProcessBlock(b3)
+		assert.Nil(t, err, "Stored blocks are ignored")
+		assert.Nil(t, reorg)
+		_, err = bc.ProcessBlock(minedBlock(forkBlock(b3, other), other))
+		assert.Equal(t, ErrOrphanBlock, err)
+		forged := forkBlock(b3, other)
+		forged.Bits = MainNetParams.PowLimitBits
+		forged.Nonce, forged.Hash = NewProofOfWork(forged).Run()
+		_, err = bc.ProcessBlock(forged)
+		assert.NotNil(t, err, "Side blocks must have the bits retargeting gives")
+		unmined := forkBlock(b3, other)
+		_, err = bc.ProcessBlock(unmined)
+		assert.NotNil(t, err, "Side blocks must hash to their hash")
+	})
+}
+
+func TestProcessBlockInvalidBranch(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		f := newTestFork()
+		defer f.bc.Db.Close()
+		bc, address, other := f.bc, f.address, f.other
+		events := make(chan ReorgEvent, 1)
+		bc.SubscribeReorgs(events)
+
+		// both spend the genesis coinbase
+		send, err := NewUTXOTransaction(f.wallet, other, 10, &f.utxo, nil, 1)
+		assert.Nil(t, err)
+		double, err := NewUTXOTransaction(f.wallet, other, 20, &f.utxo, nil, 1)
+		assert.Nil(t, err)
+		a1 := minedBlock(f.genesis, address, send)
+		_, err = bc.ProcessBlock(a1)
+		assert.Nil(t, err)
+
+		// b2 passes the checks of a side block, the UTXO set refuses it
+		b1 := minedBlock(f.genesis, other, send)
+		_, err = bc.ProcessBlock(b1)
+		assert.Nil(t, err)
+		b2 := minedBlock(b1, other, double)
+		reorg, err := bc.ProcessBlock(b2)
+		assert.Equal(t, ErrBadTxInputs, err)
+		assert.Nil(t, reorg)
+		assert.Empty(t, events)
+		_, tip := bestTip(bc)
+		assert.Equal(t, a1.Hash, tip, "The chain goes back to its tip")
+		assert.Equal(t, a1.Hash, mustTip(f.utxo.BestBlock()))
+		_, others := f.assertMatchesReplay(t)
+		assert.Equal(t, int64(10), others)
+
+		_, err = bc.ProcessBlock(minedBlock(b2, other))
+		assert.Equal(t, ErrInvalidAncestor, err)
+		assert.True(t, IsInvalidBlock(err))
+		_, tip = bestTip(bc)
+		assert.Equal(t, a1.Hash, tip)
+
+		// b1 isn't marked, a valid branch through it wins
+		c2 := minedBlock(b1, other)
+		reorg, err = bc.ProcessBlock(c2)
+		assert.Nil(t, err)
+		assert.NotNil(t, reorg)
+		assert.Equal(t, c2.Hash, reorg.NewTip)
+		assert.Equal(t, *reorg, <-events)
+		assert.Equal(t, c2.Hash, mustTip(f.utxo.BestBlock()))
+	})
+}
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// genesisConfigKey holds the hash of the GenesisConfig a chain database was
+// created with, in the blocks bucket
+const genesisConfigKey = "genesisconfig"
+
+// ErrGenesisMismatch is returned when opening a chain database created with
+// a genesis config other than the active one
+var ErrGenesisMismatch = errors.New("the blockchain was created with a different genesis config")
+
+// PremineOutput is an output of the genesis coinbase
+type PremineOutput struct {
+	Address string `json:"address"`
+	Value   int    `json:"value"`
+}
+
+// GenesisConfig describes the genesis block of a network and its subsidy
+// schedule. Nodes with the same config create the same genesis block, so
+// their chains can meet
+type GenesisConfig struct {
+	Network string `json:"network"`
+	// Magic tells the nodes of the network apart in the handshake
+	Magic     uint32 `json:"magic"`
+	Timestamp int64  `json:"timestamp"`
+	// Message is the data of the genesis coinbase input
+	Message string `json:"message"`
+	// Bits is the compact target of the genesis block
+	Bits uint32 `json:"bits"`
+	// Subsidy is what a coinbase creates before the first halving, every
+	// HalvingInterval blocks. An interval of 0 never halves
+	Subsidy         int   `json:"subsidy"`
+	HalvingInterval int64 `json:"halving_interval"`
+	// Premine are the outputs of the genesis coinbase. Without them it pays
+	// the Subsidy to the address the chain is created with, a chain of its
+	// creator alone
+	Premine []PremineOutput `json:"premine,omitempty"`
+}
+
+// MainNetGenesis is the genesis config of MainNetParams
+var MainNetGenesis = GenesisConfig{
+	Network:         "main",
+	Magic:           0x53574300,
+	Timestamp:       1546300800,
+	Message:         genesisCoinbaseData,
+	Bits:            0x20100000,
+	Subsidy:         subsidy,
+	HalvingInterval: halfRewardblockCount,
+}
+
+// TestNetGenesis is the genesis config of TestNetParams
+var TestNetGenesis = GenesisConfig{
+	Network:         "testnet",
+	Magic:           0x53574301,
+	Timestamp:       1546300800,
+	Message:         "swarmchain testnet",
+	Bits:            0x20100000,
+	Subsidy:         subsidy,
+	HalvingInterval: halfRewardblockCount,
+}
+
+// RegTestGenesis is the genesis config of RegTestParams
+var RegTestGenesis = GenesisConfig{
+	Network:         "regtest",
+	Magic:           0x53574302,
+	Timestamp:       1546300800,
+	Message:         "swarmchain regtest",
+	Bits:            0x207fffff,
+	Subsidy:         subsidy,
+	HalvingInterval: halfRewardblockCount,
+}
+
+// LoadGenesisConfig reads a GenesisConfig from a JSON file and checks it
+func LoadGenesisConfig(path string) (*GenesisConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config GenesisConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return &config, nil
+}
+
+// Validate checks that the config describes a genesis block one can create
+func (c *GenesisConfig) Validate() error {
+	if c.Network == "" {
+		return errors.New("the network has no name")
+	}
+	if CompactToBig(c.Bits).Sign() <= 0 {
+		return fmt.Errorf("the bits %08x aren't a target", c.Bits)
+	}
+	if c.Subsidy < 0 || c.HalvingInterval < 0 {
+		return errors.New("the subsidy schedule is negative")
+	}
+	// premine addresses are of the network, not the active one
+	params, _ := knownNetParams(c.Network)
+	for _, out := range c.Premine {
+		if err := checkAddress(out.Address, params); err != nil {
+			return fmt.Errorf("the premine address %s is invalid: %v", out.Address, err)
+		}
+		if out.Value <= 0 {
+			return fmt.Errorf("the premine to %s isn't positive", out.Address)
+		}
+	}
+
+	return nil
+}
+
+// Hash returns the hash of the JSON encoding of the config, stored with the
+// chains created with it
+func (c *GenesisConfig) Hash() []byte {
+	data, err := json.Marshal(c)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// premineTotal returns the coins the premine outputs create
+func (c *GenesisConfig) premineTotal() int64 {
+	total := int64(0)
+	for _, out := range c.Premine {
+		total += int64(out.Value)
+	}
+
+	return total
+}
+
+// Block mines the genesis block of the config. With premine outputs it is
+// the same for every node, without them its coinbase pays to address
+func (c *GenesisConfig) Block(address string) *Block {
+	var outputs []TXOutput
+	for _, out := range c.Premine {
+		outputs = append(outputs, *NewTXOutput(out.Value, out.Address))
+	}
+	if len(outputs) == 0 {
+		outputs = append(outputs, *NewTXOutput(c.Subsidy, address))
+	}
+	var v = atomic.Value{}
+	v.Store(common.StorageSize(0))
+	coinbase := Transaction{nil, []TXInput{{[]byte{}, -1, nil, []byte(c.Message)}}, outputs, c.Timestamp, v}
+	coinbase.ID = coinbase.Hash()
+	coinbase.SetSize(uint64(len(coinbase.Serialize())))
+
+	header := BlockHeader{PrevBlockHash: []byte{}, Timestamp: big.NewInt(c.Timestamp), Bits: c.Bits, Height: big.NewInt(0)}
+	block := &Block{BlockHeader: header, Transactions: []*Transaction{&coinbase}, Hash: []byte{}, ReceivedAt: time.Now()}
+	block.MerkleRoot = block.HashTransactions()
+	block.Nonce, block.Hash = NewProofOfWork(block).Run()
+
+	return block
+}
+
+// UseGenesisConfig makes a network with the genesis config active, its other
+// parameters those of the network of the same name, or of MainNetParams
+func UseGenesisConfig(config *GenesisConfig) {
+	known, _ := knownNetParams(config.Network)
+	params := *known
+	params.Name = config.Network
+	params.Genesis = *config
+	ActiveNetParams = &params
+}
+
+// checkGenesisConfig refuses a chain database created with a genesis config
+// other than the active one. A database from before the config was stored
+// takes the active one
+func checkGenesisConfig(db *bolt.DB) error {
+	hash := ActiveNetParams.Genesis.Hash()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		stored := b.Get([]byte(genesisConfigKey))
+		if stored == nil {
+			return b.Put([]byte(genesisConfigKey), hash)
+		}
+		if !bytes.Equal(stored, hash) {
+			return ErrGenesisMismatch
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenesisConfig(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		// a private network has the addresses of the main one
+		defer func(params *NetParams) { ActiveNetParams = params }(ActiveNetParams)
+		ActiveNetParams = &MainNetParams
+		_, address := newTestWallets()
+		_, other := newTestWallets()
+		config := RegTestGenesis
+		config.Network = "private"
+		config.Premine = []PremineOutput{{address, 1000}, {other, 500}}
+		data, err := json.Marshal(config)
+		assert.Nil(t, err)
+		path := filepath.Join(dir, "genesis.json")
+		assert.Nil(t, ioutil.WriteFile(path, data, 0600))
+
+		loaded, err := LoadGenesisConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, config, *loaded)
+		assert.Equal(t, config.Hash(), loaded.Hash())
+
+		block := loaded.Block(address)
+		assert.Equal(t, block.Hash, config.Block(other).Hash, "A premine makes the genesis block the same for everyone")
+		assert.Equal(t, config.Timestamp, block.Timestamp.Int64())
+		assert.Equal(t, 2, len(block.Transactions[0].Vout))
+		assert.NotEqual(t, RegTestGenesis.Block(address).Hash, RegTestGenesis.Block(other).Hash)
+		assert.Equal(t, RegTestGenesis.Block(address).Hash, RegTestGenesis.Block(address).Hash)
+
+		UseGenesisConfig(loaded)
+		assert.Equal(t, "private", ActiveNetParams.Name)
+		assert.False(t, ActiveNetParams.NoRetargeting, "An unknown network takes the main parameters")
+		assert.Equal(t, config.Magic, ActiveNetParams.Genesis.Magic)
+		assert.Equal(t, int64(1500+2*subsidy), expectedSupply(2), "The premine replaces the genesis subsidy")
+
+		config.Premine[0].Address = "nope"
+		assert.NotNil(t, config.Validate())
+		config.Premine[0].Address = address
+		config.Network = RegTestParams.Name
+		assert.NotNil(t, config.Validate(), "A main network address in a regtest premine")
+		config = RegTestGenesis
+		config.Bits = 0
+		assert.NotNil(t, config.Validate())
+	})
+}
+
+func TestCheckGenesisConfig(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		db := bc.Db
+		defer db.Close()
+
+		assert.Nil(t, checkGenesisConfig(db))
+		withParams(func(params *NetParams) { params.Genesis.Message = "another chain" }, func() {
+			assert.Equal(t, ErrGenesisMismatch, checkGenesisConfig(db))
+		})
+
+		// a database from before the config was stored takes the active one
+		err := db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(blocksBucket)).Delete([]byte(genesisConfigKey))
+		})
+		assert.Nil(t, err)
+		withParams(func(params *NetParams) { params.Genesis.Message = "another chain" }, func() {
+			assert.Nil(t, checkGenesisConfig(db))
+		})
+		assert.Equal(t, ErrGenesisMismatch, checkGenesisConfig(db))
+	})
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// heightBucket maps the height of each block of the chain, big endian, to
+// its hash. Whatever moves the tip rewrites it with indexHeights, which
+// moves the transaction index along
+const heightBucket = "heights"
+
+func heightKey(height int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+// indexHeights points the height index at the chain ending at tip: the
+// entries above tip are deleted and those below rewritten down to the first
+// that already holds the right block, the fork point after a reorganisation
+func indexHeights(tx *bolt.Tx, tip []byte) error {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	index, err := tx.CreateBucketIfNotExists([]byte(heightBucket))
+	if err != nil {
+		return err
+	}
+	load := func(hash []byte) (*Block, error) {
+		data := blocks.Get(hash)
+		if data == nil {
+			return nil, fmt.Errorf("block %x is not found", hash)
+		}
+		return DeserializeBlock(data), nil
+	}
+	block, err := load(tip)
+	if err != nil {
+		return err
+	}
+
+	// deleting while iterating skips keys with bolt cursors
+	var above, aboveHashes [][]byte
+	c := index.Cursor()
+	for k, v := c.Seek(heightKey(block.Height.Int64() + 1)); k != nil; k, v = c.Next() {
+		above = append(above, append([]byte(nil), k...))
+		aboveHashes = append(aboveHashes, append([]byte(nil), v...))
+	}
+	for i, k := range above {
+		if err := index.Delete(k); err != nil {
+			return err
+		}
+		if err := unindexTransactions(tx, aboveHashes[i]); err != nil {
+			return err
+		}
+	}
+
+	for {
+		key := heightKey(block.Height.Int64())
+		old := index.Get(key)
+		if bytes.Equal(old, block.Hash) {
+			return nil
+		}
+		if old != nil {
+			if err := unindexTransactions(tx, append([]byte(nil), old...)); err != nil {
+				return err
+			}
+		}
+		if err := index.Put(key, block.Hash); err != nil {
+			return err
+		}
+		if err := indexTransactions(tx, block); err != nil {
+			return err
+		}
+		if len(block.PrevBlockHash) == 0 {
+			return nil
+		}
+		if block, err = load(block.PrevBlockHash); err != nil {
+			return err
+		}
+	}
+}
+
+// ensureHeightIndex backfills the height index of a database written before
+// it existed
+func ensureHeightIndex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(heightBucket)) != nil {
+			return nil
+		}
+		tip := tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))
+		if tip == nil {
+			return nil
+		}
+		return indexHeights(tx, tip)
+	})
+}
+
+// GetBlockByHeight returns the block of the chain at the given height
+func (bc *Blockchain) GetBlockByHeight(height int) (*Block, error) {
+	var block *Block
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(heightBucket))
+		if index == nil || height < 0 {
+			return fmt.Errorf("no block at height %d", height)
+		}
+		hash := index.Get(heightKey(int64(height)))
+		if hash == nil {
+			return fmt.Errorf("no block at height %d", height)
+		}
+		data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+		if data == nil {
+			if bodyPruned(tx, hash) {
+				return ErrBlockPruned
+			}
+			return fmt.Errorf("block %x is not found", hash)
+		}
+		block = DeserializeBlock(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// bestFromIndex returns the height and the hash of the tip from the last
+// entry of the height index, nil without one
+func bestFromIndex(tx *bolt.Tx) (*big.Int, []byte) {
+	index := tx.Bucket([]byte(heightBucket))
+	if index == nil {
+		return nil, nil
+	}
+	k, v := index.Cursor().Last()
+	if k == nil {
+		return nil, nil
+	}
+
+	return new(big.Int).SetUint64(binary.BigEndian.Uint64(k)), append([]byte(nil), v...)
+}
+
+// putTip makes hash the tip of the chain, pointing the height index at it
+// and rewriting the chainstate record
+func putTip(tx *bolt.Tx, hash []byte) error {
+	if err := tx.Bucket([]byte(blocksBucket)).Put([]byte("l"), hash); err != nil {
+		return err
+	}
+	if err := indexHeights(tx, hash); err != nil {
+		return err
+	}
+
+	return putChainState(tx, hash)
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertHeights checks that the index maps each height to the block of
+// chain there, and nothing above it
+func assertHeights(t *testing.T, bc *Blockchain, chain []*Block) {
+	for height, block := range chain {
+		indexed, err := bc.GetBlockByHeight(height)
+		assert.Nil(t, err)
+		if assert.NotNil(t, indexed) {
+			assert.Equal(t, block.Hash, indexed.Hash)
+		}
+	}
+	_, err := bc.GetBlockByHeight(len(chain))
+	assert.NotNil(t, err)
+	height, tip := bestTip(bc)
+	assert.Equal(t, int64(len(chain)-1), height.Int64())
+	assert.Equal(t, chain[len(chain)-1].Hash, tip)
+}
+
+func TestHeightIndex(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		mined := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		a2 := minedBlock(mined, address)
+		a3 := minedBlock(a2, address)
+		importer := NewBlockImporter(bc, 0)
+		assert.Nil(t, importer.Add(a2))
+		assert.Nil(t, importer.Add(a3))
+		assert.Nil(t, importer.Flush())
+		assertHeights(t, bc, []*Block{&genesis, mined, a2, a3})
+		_, err := bc.GetBlockByHeight(-1)
+		assert.NotNil(t, err)
+
+		// the entries above the fork are rewritten
+		b1 := minedBlock(&genesis, address)
+		b2 := minedBlock(b1, address)
+		b3 := minedBlock(b2, address)
+		b4 := minedBlock(b3, address)
+		_, err = bc.Reorganize([]*Block{b1, b2, b3, b4})
+		assert.Nil(t, err)
+		assertHeights(t, bc, []*Block{&genesis, b1, b2, b3, b4})
+
+		// and those above a shorter branch deleted
+		a4 := minedBlock(a3, address)
+		_, err = bc.Reorganize([]*Block{a4})
+		assert.Nil(t, err)
+		assertHeights(t, bc, []*Block{&genesis, mined, a2, a3, a4})
+
+		// databases written before the index get it backfilled
+		assert.Nil(t, bc.Db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte(heightBucket))
+		}))
+		_, err = bc.GetBlockByHeight(1)
+		assert.NotNil(t, err)
+		assert.Nil(t, ensureHeightIndex(bc.Db))
+		assertHeights(t, bc, []*Block{&genesis, mined, a2, a3, a4})
+	})
+}
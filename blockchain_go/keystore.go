@@ -0,0 +1,134 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ErrWalletExists is returned when an imported key is already in the wallet
+var ErrWalletExists = errors.New("key is already in the wallet")
+
+// keystoreMeta is stored in the "meta" section of an exported keystore so
+// the Base58 address of this chain can be matched to the Ethereum address
+type keystoreMeta struct {
+	Address    string `json:"address"`
+	EthAddress string `json:"ethAddress"`
+}
+
+// ExportKeystore encrypts the key of address into a Web3 keystore JSON
+// (scrypt KDF, AES-128-CTR) compatible with go-ethereum tooling
+func (ws *Wallets) ExportKeystore(address, passphrase string) ([]byte, error) {
+	ws.mu.RLock()
+	wallet, ok := ws.Wallets[address]
+	ws.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("address %s is not in the wallet", address)
+	}
+
+	if wallet.PrivateKey.D == nil {
+		return nil, fmt.Errorf("the key of %s is not stored in the wallet", address)
+	}
+	d := wallet.PrivateKey.D.Bytes()
+	privKey, err := crypto.ToECDSA(paddedAppend(privKeyBytesLen, make([]byte, 0, privKeyBytesLen), d))
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40 // version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // variant RFC4122
+
+	key := &keystore.Key{
+		Id:         id,
+		Address:    crypto.PubkeyToAddress(privKey.PublicKey),
+		PrivateKey: privKey,
+	}
+	keyJSON, err := keystore.EncryptKey(key, passphrase, keystore.StandardScryptN, keystore.StandardScryptP)
+	if err != nil {
+		return nil, err
+	}
+
+	var content map[string]interface{}
+	if err := json.Unmarshal(keyJSON, &content); err != nil {
+		return nil, err
+	}
+	content["meta"] = keystoreMeta{
+		Address:    address,
+		EthAddress: key.Address.Hex(),
+	}
+
+	return json.Marshal(content)
+}
+
+// ImportKeystore decrypts a Web3 keystore JSON and adds its key to Wallets,
+// returning the address of the imported key
+func (ws *Wallets) ImportKeystore(keyJSON []byte, passphrase string) (string, error) {
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return "", err
+	}
+	if key.PrivateKey == nil {
+		return "", errors.New("keystore does not contain a private key")
+	}
+
+	return ws.addKey(key.PrivateKey)
+}
+
+// ImportEthKeystore imports the key of a geth keystore file and persists
+// the wallet. It returns the Ethereum address of the key along with the
+// address derived from it on this chain, so the user can check that the
+// right key was imported. Both are set along with ErrWalletExists too
+func (ws *Wallets) ImportEthKeystore(path, passphrase string) (string, string, error) {
+	keyJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %v", path, err)
+	}
+	if key.PrivateKey == nil {
+		return "", "", fmt.Errorf("%s does not contain a private key", path)
+	}
+
+	ethAddress := crypto.PubkeyToAddress(key.PrivateKey.PublicKey).Hex()
+	address, err := ws.addKey(key.PrivateKey)
+	if err != nil {
+		return ethAddress, address, err
+	}
+	if ws.nodeID != "" {
+		if err := ws.SaveToFile(ws.nodeID); err != nil {
+			return ethAddress, address, err
+		}
+	}
+
+	return ethAddress, address, nil
+}
+
+// addKey wraps an imported private key into a Wallet. Keys are matched by
+// the derived address, an existing one is reported with ErrWalletExists
+func (ws *Wallets) addKey(privKey *ecdsa.PrivateKey) (string, error) {
+	wallet := newWalletFromKey(privKey)
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.Wallets[address]; ok {
+		return address, ErrWalletExists
+	}
+	ws.Wallets[address] = wallet
+	ws.balances = nil
+
+	return address, nil
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportKeystore(t *testing.T) {
+	ws := Wallets{Wallets: make(map[string]*Wallet)}
+	wallet := NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	ws.Wallets[address] = wallet
+
+	keyJSON, err := ws.ExportKeystore(address, "secret")
+	assert.Nil(t, err)
+
+	key, err := keystore.DecryptKey(keyJSON, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, wallet.PrivateKey.D, key.PrivateKey.D, "Decrypted key is the exported key")
+	assert.Equal(t, crypto.PubkeyToAddress(wallet.PrivateKey.PublicKey), key.Address)
+	assert.Contains(t, string(keyJSON), address, "Meta section has the Base58 address")
+	assert.Contains(t, string(keyJSON), key.Address.Hex(), "Meta section has the Ethereum address")
+
+	_, err = keystore.DecryptKey(keyJSON, "wrong")
+	assert.NotNil(t, err)
+
+	_, err = ws.ExportKeystore("missing", "secret")
+	assert.NotNil(t, err)
+}
+
+func TestImportKeystore(t *testing.T) {
+	privKey, _ := crypto.GenerateKey()
+	key := &keystore.Key{
+		Id:         make([]byte, 16),
+		Address:    crypto.PubkeyToAddress(privKey.PublicKey),
+		PrivateKey: privKey,
+	}
+	keyJSON, err := keystore.EncryptKey(key, "secret", keystore.LightScryptN, keystore.LightScryptP)
+	assert.Nil(t, err)
+
+	ws := Wallets{Wallets: make(map[string]*Wallet)}
+	_, err = ws.ImportKeystore(keyJSON, "wrong")
+	assert.NotNil(t, err)
+	assert.Len(t, ws.Wallets, 0)
+
+	address, err := ws.ImportKeystore(keyJSON, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.D, ws.Wallets[address].PrivateKey.D)
+
+	exported, err := ws.ExportKeystore(address, "other")
+	assert.Nil(t, err)
+	roundTrip, err := keystore.DecryptKey(exported, "other")
+	assert.Nil(t, err)
+	assert.Equal(t, key.Address, roundTrip.Address, "Round trip keeps the Ethereum address")
+}
+
+func TestImportEthKeystore(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		privKey, _ := crypto.GenerateKey()
+		key := &keystore.Key{
+			Id:         make([]byte, 16),
+			Address:    crypto.PubkeyToAddress(privKey.PublicKey),
+			PrivateKey: privKey,
+		}
+		keyJSON, err := keystore.EncryptKey(key, "secret", keystore.LightScryptN, keystore.LightScryptP)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile("UTC--key", keyJSON, 0600))
+		assert.Nil(t, ioutil.WriteFile("broken", []byte("{not json"), 0600))
+
+		ws := &Wallets{Wallets: make(map[string]*Wallet), nodeID: "test"}
+		_, _, err = ws.ImportEthKeystore("UTC--key", "wrong")
+		assert.NotNil(t, err)
+		_, _, err = ws.ImportEthKeystore("broken", "secret")
+		assert.NotNil(t, err)
+		assert.Len(t, ws.Wallets, 0)
+
+		ethAddress, address, err := ws.ImportEthKeystore("UTC--key", "secret")
+		assert.Nil(t, err)
+		assert.Equal(t, key.Address.Hex(), ethAddress)
+		assert.Equal(t, string(GetAddressFromPubkeyHash(HashPubKey(ws.Wallets[address].PublicKey))), address)
+
+		_, _, err = ws.ImportEthKeystore("UTC--key", "secret")
+		assert.Equal(t, ErrWalletExists, err)
+
+		loaded, err := NewWalletsReadOnly("test")
+		assert.Nil(t, err)
+		assert.Equal(t, privKey.D, loaded.Wallets[address].PrivateKey.D, "The imported key is persisted")
+	})
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// medianTimeBucket maps the hash of a stored header to its median time
+// past, see Blockchain.MedianTimePast
+const medianTimeBucket = "mediantime"
+
+// medianTimeBlocks is the number of blocks, ending at the parent, whose
+// median timestamp a block must be later than
+const medianTimeBlocks = 11
+
+// MaxTimeOffset bounds how far the network adjusted time strays from the
+// local clock
+const MaxTimeOffset = 70 * time.Minute
+
+// minTimeSamples is the number of peers whose clocks must be known before
+// they adjust the time, maxTimeSamples the number of peers asked at most
+const (
+	minTimeSamples = 5
+	maxTimeSamples = 200
+)
+
+var (
+	// ErrTimeTooOld is returned for a block timestamped at or before the
+	// median time past of its parent
+	ErrTimeTooOld = errors.New("the block isn't timestamped after the median time of the blocks before it")
+	// ErrTimeTooNew is returned for a block timestamped more than
+	// MaxFutureBlockTime ahead of the network adjusted time
+	ErrTimeTooNew = errors.New("the block is timestamped too far ahead of the network time")
+)
+
+// MedianTimeSource adjusts the local clock by the median offset of the
+// clocks of the peers, as they give it when they connect
+type MedianTimeSource struct {
+	mu      sync.Mutex
+	offsets map[string]time.Duration
+}
+
+// NewMedianTimeSource returns a time source without samples, which keeps
+// the local clock
+func NewMedianTimeSource() *MedianTimeSource {
+	return &MedianTimeSource{offsets: make(map[string]time.Duration)}
+}
+
+// NetworkTime is the time source block timestamps are checked against
+var NetworkTime = NewMedianTimeSource()
+
+// AddTimeSample records the time a peer gave. The first sample of a peer
+// counts, and once maxTimeSamples peers have given one no more do
+func (m *MedianTimeSource) AddTimeSample(peer string, peerTime time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.offsets[peer]; ok || len(m.offsets) >= maxTimeSamples {
+		return
+	}
+	m.offsets[peer] = peerTime.Sub(time.Now()).Round(time.Second)
+}
+
+// Offset returns the median offset of the clocks of the peers, clamped to
+// MaxTimeOffset, and 0 with fewer than minTimeSamples of them
+func (m *MedianTimeSource) Offset() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.offsets) < minTimeSamples {
+		return 0
+	}
+	offsets := make([]time.Duration, 0, len(m.offsets))
+	for _, offset := range m.offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	offset := offsets[len(offsets)/2]
+	if offset > MaxTimeOffset {
+		offset = MaxTimeOffset
+	} else if offset < -MaxTimeOffset {
+		offset = -MaxTimeOffset
+	}
+
+	return offset
+}
+
+// AdjustedTime returns the local time adjusted by Offset
+func (m *MedianTimeSource) AdjustedTime() time.Time {
+	return time.Now().Add(m.Offset())
+}
+
+// calcMedianTime returns the median timestamp of the header with the given
+// hash and the medianTimeBlocks-1 before it
+func calcMedianTime(tx *bolt.Tx, hash []byte) (int64, error) {
+	var timestamps []int64
+	for len(hash) > 0 && len(timestamps) < medianTimeBlocks {
+		header, err := headerAt(tx, hash)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Timestamp.Int64())
+		hash = header.PrevBlockHash
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// medianTimePast returns the median time past of a stored header, from the
+// cache if it is there
+func medianTimePast(tx *bolt.Tx, hash []byte) (int64, error) {
+	if cache := tx.Bucket([]byte(medianTimeBucket)); cache != nil {
+		if data := cache.Get(hash); data != nil {
+			return int64(binary.BigEndian.Uint64(data)), nil
+		}
+	}
+
+	return calcMedianTime(tx, hash)
+}
+
+// putMedianTime caches the median time past of a stored header
+func putMedianTime(tx *bolt.Tx, hash []byte) error {
+	median, err := calcMedianTime(tx, hash)
+	if err != nil {
+		return err
+	}
+	cache, err := tx.CreateBucketIfNotExists([]byte(medianTimeBucket))
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(median))
+
+	return cache.Put(hash, data)
+}
+
+// MedianTimePast returns the median of the timestamps of the stored header
+// with the given hash and the medianTimeBlocks-1 before it. The child of the
+// header must be timestamped after it
+func (bc *Blockchain) MedianTimePast(hash []byte) (int64, error) {
+	var median int64
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		var err error
+		median, err = medianTimePast(tx, hash)
+		return err
+	})
+
+	return median, err
+}
+
+// checkTimestamp checks the timestamp of a header whose parent is stored:
+// after the median time past of the parent and at most MaxFutureBlockTime
+// ahead of the network adjusted time
+func (bc *Blockchain) checkTimestamp(header *BlockHeader) error {
+	median, err := bc.MedianTimePast(header.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	if header.Timestamp == nil || header.Timestamp.Int64() <= median {
+		return ErrTimeTooOld
+	}
+	if tooNew(header) {
+		return ErrTimeTooNew
+	}
+
+	return nil
+}
+
+func tooNew(header *BlockHeader) bool {
+	return header.Timestamp.Int64() > NetworkTime.AdjustedTime().Add(MaxFutureBlockTime).Unix()
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stampedBlock returns a block on top of prev timestamped at timestamp,
+// mined with the target of the active network
+func stampedBlock(prev *Block, address string, timestamp int64) *Block {
+	block := forkBlock(prev, address)
+	block.Timestamp = big.NewInt(timestamp)
+	block.Bits = ActiveNetParams.PowLimitBits
+	block.Nonce, block.Hash = NewProofOfWork(block).Run()
+
+	return block
+}
+
+func TestMedianTimeSource(t *testing.T) {
+	m := NewMedianTimeSource()
+	for i, offset := range []time.Duration{10, 20, -5, 30} {
+		m.AddTimeSample(fmt.Sprintf("peer%d", i), time.Now().Add(offset*time.Second))
+	}
+	assert.Equal(t, time.Duration(0), m.Offset(), "Too few peers keep the local clock")
+	m.AddTimeSample("peer4", time.Now().Add(40*time.Second))
+	m.AddTimeSample("peer0", time.Now().Add(time.Hour))
+	assert.Equal(t, 20*time.Second, m.Offset(), "The first sample of a peer counts")
+	assert.True(t, m.AdjustedTime().Sub(time.Now()) > 19*time.Second)
+
+	for _, offset := range []time.Duration{3 * time.Hour, -3 * time.Hour} {
+		m = NewMedianTimeSource()
+		for i := 0; i < minTimeSamples; i++ {
+			m.AddTimeSample(fmt.Sprintf("peer%d", i), time.Now().Add(offset))
+		}
+		assert.Equal(t, offset/3*70/60, m.Offset(), "The offset is clamped")
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		g := genesis.Timestamp.Int64()
+
+		tip := &genesis
+		for i := int64(1); i <= 10; i++ {
+			tip = stampedBlock(tip, address, g+10*i)
+			_, err := bc.ProcessBlock(tip)
+			assert.Nil(t, err)
+		}
+		median, err := bc.MedianTimePast(tip.Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, g+50, median)
+
+		// a block may be earlier than its parent, not than the median
+		tip = stampedBlock(tip, address, g+75)
+		_, err = bc.ProcessBlock(tip)
+		assert.Nil(t, err)
+		median, err = bc.MedianTimePast(tip.Hash)
+		assert.Nil(t, err)
+		assert.Equal(t, g+60, median, "The genesis block left the window")
+		old := stampedBlock(tip, address, g+60)
+		_, err = bc.ProcessBlock(old)
+		assert.Equal(t, ErrTimeTooOld, err)
+		valid, reason := bc.IsBlockValid(old)
+		assert.False(t, valid)
+		assert.Equal(t, 11, reason)
+		_, err = bc.AddHeader(&old.BlockHeader)
+		assert.Equal(t, ErrTimeTooOld, err)
+
+		future := stampedBlock(tip, address, time.Now().Add(3*time.Hour).Unix())
+		_, err = bc.ProcessBlock(future)
+		assert.Equal(t, ErrTimeTooNew, err)
+		valid, reason = bc.IsBlockValid(future)
+		assert.False(t, valid)
+		assert.Equal(t, 12, reason)
+
+		// the network time moves the bound
+		defer func(m *MedianTimeSource) { NetworkTime = m }(NetworkTime)
+		NetworkTime = NewMedianTimeSource()
+		for i := 0; i < minTimeSamples; i++ {
+			NetworkTime.AddTimeSample(fmt.Sprintf("peer%d", i), time.Now().Add(2*time.Hour))
+		}
+		_, err = bc.ProcessBlock(future)
+		assert.Nil(t, err)
+
+		// a block mined behind the chain is stamped after the median
+		median, err = bc.MedianTimePast(future.Hash)
+		assert.Nil(t, err)
+		mined := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		assert.True(t, mined.Timestamp.Int64() > median)
+	})
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrTxConflict is returned by Mempool.Add for a transaction spending an
+// output another transaction of the pool spends, see Conflicts
+var ErrTxConflict = errors.New("the transaction spends an output a mempool transaction spends")
+
+// Mempool holds the transactions waiting to be mined, by hex encoded ID,
+// along with the outputs they spend, so no two of them spend the same one.
+// The node relays and mines from it and the wallets select coins around
+// the outputs it reserves, see Reserved
+type Mempool struct {
+	mu     sync.RWMutex
+	txs    map[string]*Transaction
+	spends map[Outpoint]string // the ID of the transaction spending each output
+	newTx  eventFeed           // see SubscribeNewPendingTx
+}
+
+// NewMempool returns an empty pool
+func NewMempool() *Mempool {
+	return &Mempool{
+		txs:    make(map[string]*Transaction),
+		spends: make(map[Outpoint]string),
+	}
+}
+
+// Add puts tx into the pool. A transaction spending an output a transaction
+// of the pool spends is refused with ErrTxConflict, there is no replacement.
+// Adding a transaction of the pool does nothing, a new one is sent to the
+// subscribers
+func (m *Mempool) Add(tx *Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := m.txs[id]; ok {
+		return nil
+	}
+	if len(m.conflicts(tx)) > 0 {
+		return ErrTxConflict
+	}
+	m.txs[id] = tx
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.Vin {
+			m.spends[Outpoint{hex.EncodeToString(vin.Txid), vin.Vout}] = id
+		}
+	}
+	m.newTx.send(tx)
+
+	return nil
+}
+
+// SubscribeNewPendingTx sends every transaction added to the pool to ch,
+// until ch is closed. Sends don't block, see eventFeed, ch should be buffered
+func (m *Mempool) SubscribeNewPendingTx(ch chan<- *Transaction) {
+	m.newTx.subscribe(func(event interface{}) bool {
+		return trySendTx(ch, event.(*Transaction))
+	})
+}
+
+// Conflicts returns the transactions of the pool spending an output tx
+// spends, ordered by ID
+func (m *Mempool) Conflicts(tx *Transaction) []*Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.conflicts(tx)
+}
+
+func (m *Mempool) conflicts(tx *Transaction) []*Transaction {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	id := hex.EncodeToString(tx.ID)
+	ids := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		if spender, ok := m.spends[Outpoint{hex.EncodeToString(vin.Txid), vin.Vout}]; ok && spender != id {
+			ids[spender] = true
+		}
+	}
+
+	return m.sorted(ids)
+}
+
+// Get returns the transaction of the pool with the hex encoded ID, nil if
+// there is none
+func (m *Mempool) Get(id string) *Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.txs[id]
+}
+
+// Count returns the number of transactions in the pool
+func (m *Mempool) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.txs)
+}
+
+// Transactions returns the transactions of the pool, ordered by ID
+func (m *Mempool) Transactions() []*Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make(map[string]bool, len(m.txs))
+	for id := range m.txs {
+		ids[id] = true
+	}
+
+	return m.sorted(ids)
+}
+
+// Reserved returns the outputs the transactions of the pool spend
+func (m *Mempool) Reserved() OutpointSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reserved := make(OutpointSet, len(m.spends))
+	for outpoint := range m.spends {
+		reserved[outpoint] = struct{}{}
+	}
+
+	return reserved
+}
+
+// Remove takes the transaction with the hex encoded ID out of the pool
+func (m *Mempool) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(id)
+}
+
+func (m *Mempool) remove(id string) {
+	tx, ok := m.txs[id]
+	if !ok {
+		return
+	}
+	delete(m.txs, id)
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.Vin {
+			outpoint := Outpoint{hex.EncodeToString(vin.Txid), vin.Vout}
+			if m.spends[outpoint] == id {
+				delete(m.spends, outpoint)
+			}
+		}
+	}
+}
+
+// RemoveBlock takes the transactions of a block connected to the tip out of
+// the pool, then those spending an output the block spends, which can't be
+// mined anymore, along with the transactions spending their outputs. It
+// returns the transactions dropped that way, ordered by ID
+func (m *Mempool) RemoveBlock(block *Block) []*Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		m.remove(hex.EncodeToString(tx.ID))
+	}
+
+	dropped := make(map[string]bool)
+	var queue []string
+	for _, tx := range block.Transactions {
+		for _, conflict := range m.conflicts(tx) {
+			queue = append(queue, hex.EncodeToString(conflict.ID))
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if dropped[id] || m.txs[id] == nil {
+			continue
+		}
+		dropped[id] = true
+		// its outputs are spent by its descendants only
+		for vout := range m.txs[id].Vout {
+			if spender, ok := m.spends[Outpoint{id, vout}]; ok {
+				queue = append(queue, spender)
+			}
+		}
+	}
+	txs := m.sorted(dropped)
+	for id := range dropped {
+		m.remove(id)
+	}
+
+	return txs
+}
+
+// sorted returns the transactions of the pool with the IDs, ordered by ID
+func (m *Mempool) sorted(ids map[string]bool) []*Transaction {
+	keys := make([]string, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, id)
+	}
+	sort.Strings(keys)
+
+	txs := make([]*Transaction, 0, len(keys))
+	for _, id := range keys {
+		txs = append(txs, m.txs[id])
+	}
+
+	return txs
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMempoolConflicts(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		wallet := ws.Wallets[address]
+		other := ws.CreateWallet()
+		bc := newTestChain(address)
+		defer bc.Close()
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+
+		// two sends of the coinbase of the genesis block
+		a, err := NewUTXOTransaction(wallet, other, 1, &u, nil, 1)
+		assert.Nil(t, err)
+		b, err := NewUTXOTransaction(wallet, other, 2, &u, nil, 1)
+		assert.Nil(t, err)
+		for _, order := range [][2]*Transaction{{a, b}, {b, a}} {
+			pool := NewMempool()
+			assert.Nil(t, pool.Add(order[0]))
+			assert.Equal(t, ErrTxConflict, pool.Add(order[1]))
+			assert.Nil(t, pool.Add(order[0]), "Adding it again does nothing")
+			assert.Equal(t, []*Transaction{order[0]}, pool.Transactions())
+			assert.Equal(t, []*Transaction{order[0]}, pool.Conflicts(order[1]))
+			assert.Empty(t, pool.Conflicts(order[0]))
+			reserved := make(OutpointSet)
+			reserved.AddInputs(order[0])
+			assert.Equal(t, reserved, pool.Reserved())
+		}
+
+		// the send of a block spends what a and its child spend
+		pool := NewMempool()
+		child := &Transaction{Vin: []TXInput{{a.ID, 0, nil, nil}}, Vout: []TXOutput{{1, nil}}}
+		child.ID = child.Hash()
+		assert.Nil(t, pool.Add(a))
+		assert.Nil(t, pool.Add(child))
+		unrelated := &Transaction{Vin: []TXInput{{[]byte("unrelated"), 0, nil, nil}}, Vout: []TXOutput{{1, nil}}}
+		unrelated.ID = unrelated.Hash()
+		assert.Nil(t, pool.Add(unrelated))
+		block := mustMine(bc, mustTxs(u.BlockTransactions([]*Transaction{b}, address)))
+		u.Update(block)
+		dropped := pool.RemoveBlock(block)
+		assert.Len(t, dropped, 2)
+		assert.Contains(t, dropped, a)
+		assert.Contains(t, dropped, child)
+		assert.Equal(t, []*Transaction{unrelated}, pool.Transactions())
+		assert.Len(t, pool.Reserved(), 1)
+
+		// a transaction the block holds leaves the pool
+		pool = NewMempool()
+		assert.Nil(t, pool.Add(b))
+		assert.Empty(t, pool.RemoveBlock(block))
+		assert.Equal(t, 0, pool.Count())
+		pool.Remove("unknown")
+	})
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+// MerkleTreeHeight is the height from which blocks commit to the IDs of
+// their transactions with NewMerkleTree, so that GenerateMerkleProof can
+// prove a transaction is in them. Blocks below it commit to the strings of
+// their transactions, see legacyMerkleRoot. Every node of a network must use
+// the same height; a network whose chain predates the tree sets it above its
+// tip
+var MerkleTreeHeight = int64(0)
+
+func usesMerkleTree(height *big.Int) bool {
+	return height == nil || height.Int64() >= MerkleTreeHeight
+}
+
+// ProofNode is the sibling of a node on the path from a transaction up to
+// the merkle root
+type ProofNode struct {
+	Hash []byte
+	// Left is whether the sibling is the left node of the pair
+	Left bool
+}
+
+// GenerateMerkleProof returns the siblings on the path from the transaction
+// with the given ID up to the merkle root of the block, leaf first
+func GenerateMerkleProof(block *Block, txID []byte) ([]ProofNode, error) {
+	if !usesMerkleTree(block.Height) {
+		return nil, fmt.Errorf("block %x predates the merkle tree", block.Hash)
+	}
+	index := -1
+	var level [][]byte
+	for i, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			index = i
+		}
+		level = append(level, hashMerkleLeaf(tx.ID))
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("transaction %x is not in block %x", txID, block.Hash)
+	}
+
+	var proof []ProofNode
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		sibling := index ^ 1
+		proof = append(proof, ProofNode{Hash: level[sibling], Left: sibling < index})
+		var next [][]byte
+		for j := 0; j < len(level); j += 2 {
+			next = append(next, hashMerkleNode(level[j], level[j+1]))
+		}
+		level = next
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that proof leads from the transaction with the
+// given ID up to root
+func VerifyMerkleProof(root, txID []byte, proof []ProofNode) bool {
+	hash := hashMerkleLeaf(txID)
+	for _, node := range proof {
+		if node.Left {
+			hash = hashMerkleNode(node.Hash, hash)
+		} else {
+			hash = hashMerkleNode(hash, node.Hash)
+		}
+	}
+
+	return bytes.Equal(hash, root)
+}
+
+// TxProof proves that a transaction is in a block to a light client, which
+// only holds the headers of the chain
+type TxProof struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int64
+	Proof     []ProofNode
+}
+
+// GetTxProof returns the proof that the transaction with the given ID is in
+// the chain
+func (bc *Blockchain) GetTxProof(txID []byte) (*TxProof, error) {
+	_, block, err := bc.FindTransactionBlock(txID)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := GenerateMerkleProof(block, txID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxProof{TxID: txID, BlockHash: block.Hash, Height: block.Height.Int64(), Proof: proof}, nil
+}
+
+// VerifyTxProof checks a proof against the merkle root of the stored header
+// it names, which may have been added with AddHeader without its block
+func (bc *Blockchain) VerifyTxProof(proof *TxProof) error {
+	header, err := bc.GetHeader(proof.BlockHash)
+	if err != nil {
+		return err
+	}
+	if header.Height.Int64() != proof.Height {
+		return fmt.Errorf("header %x is at height %d, not %d", proof.BlockHash, header.Height.Int64(), proof.Height)
+	}
+	if !VerifyMerkleProof(header.MerkleRoot, proof.TxID, proof.Proof) {
+		return fmt.Errorf("transaction %x is not in block %x", proof.TxID, proof.BlockHash)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockOfSize returns a block at height 1 with n transactions
+func blockOfSize(n int) *Block {
+	block := &Block{BlockHeader: BlockHeader{Height: big.NewInt(1)}}
+	for i := 0; i < n; i++ {
+		id := sha256.Sum256([]byte(fmt.Sprintf("tx%d", i)))
+		block.Transactions = append(block.Transactions, &Transaction{ID: id[:]})
+	}
+
+	return block
+}
+
+func TestMerkleProof(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 5, 7, 8, 13, 64} {
+		block := blockOfSize(n)
+		root := block.HashTransactions()
+		depth := int(math.Ceil(math.Log2(float64(n))))
+		for i, tx := range block.Transactions {
+			proof, err := GenerateMerkleProof(block, tx.ID)
+			assert.Nil(t, err)
+			assert.Len(t, proof, depth, "%d transactions", n)
+			assert.True(t, VerifyMerkleProof(root, tx.ID, proof), "transaction %d of %d", i, n)
+			other := block.Transactions[(i+1)%n].ID
+			if n > 1 {
+				assert.False(t, VerifyMerkleProof(root, other, proof), "transaction %d of %d", i, n)
+				// the top pair is never a node paired with itself
+				top := &proof[len(proof)-1]
+				top.Left = !top.Left
+				assert.False(t, VerifyMerkleProof(root, tx.ID, proof), "transaction %d of %d", i, n)
+			}
+		}
+	}
+
+	block := blockOfSize(4)
+	root := block.HashTransactions()
+	_, err := GenerateMerkleProof(block, []byte("missing"))
+	assert.NotNil(t, err)
+	assert.False(t, VerifyMerkleProof(root, []byte("missing"), nil))
+
+	// an inner node can't pass for a transaction
+	l0 := hashMerkleLeaf(block.Transactions[0].ID)
+	l1 := hashMerkleLeaf(block.Transactions[1].ID)
+	l23 := hashMerkleNode(hashMerkleLeaf(block.Transactions[2].ID), hashMerkleLeaf(block.Transactions[3].ID))
+	assert.False(t, VerifyMerkleProof(root, append(l0, l1...), []ProofNode{{Hash: l23}}))
+
+	MerkleTreeHeight = 2
+	defer func() { MerkleTreeHeight = 0 }()
+	_, err = GenerateMerkleProof(block, block.Transactions[0].ID)
+	assert.NotNil(t, err, "Blocks below the tree can't prove their transactions")
+}
+
+func TestTxProof(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		send, err := NewUTXOTransaction(ws.Wallets[address], address, 1, &u, nil, 1)
+		assert.Nil(t, err)
+		coinbase := NewCoinbaseTX(address, "")
+		mined := mustMine(bc, []*Transaction{coinbase, send})
+
+		proof, err := bc.GetTxProof(coinbase.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, mined.Hash, proof.BlockHash)
+		assert.Equal(t, int64(1), proof.Height)
+		assert.Nil(t, bc.VerifyTxProof(proof))
+		_, err = bc.GetTxProof([]byte("missing"))
+		assert.NotNil(t, err)
+
+		// a light client only needs the header
+		ahead := minedBlock(mined, address)
+		_, err = bc.AddHeader(&ahead.BlockHeader)
+		assert.Nil(t, err)
+		nodes, err := GenerateMerkleProof(ahead, ahead.Transactions[0].ID)
+		assert.Nil(t, err)
+		proof = &TxProof{TxID: ahead.Transactions[0].ID, BlockHash: ahead.Hash, Height: 2, Proof: nodes}
+		assert.Nil(t, bc.VerifyTxProof(proof))
+		proof.Height = 1
+		assert.NotNil(t, bc.VerifyTxProof(proof))
+		proof.Height = 2
+		proof.TxID = coinbase.ID
+		assert.NotNil(t, bc.VerifyTxProof(proof))
+	})
+}
@@ -4,6 +4,13 @@ import (
 	"crypto/sha256"
 )
 
+// Leaves and inner nodes hash behind different prefixes, so no inner node
+// can pass for a leaf in a proof
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
 // MerkleTree represent a Merkle tree
 type MerkleTree struct {
 	RootNode *MerkleNode
@@ -16,36 +23,30 @@ type MerkleNode struct {
 	Data  []byte
 }
 
-// NewMerkleTree creates a new Merkle tree from a sequence of data
+// NewMerkleTree creates a new Merkle tree from a sequence of data. The last
+// node of a level with an odd number of nodes is paired with itself
 func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	//fmt.Println("---@@data %d...",len(data))
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+	if len(data) == 0 {
+		return &MerkleTree{NewMerkleNode(nil, nil, nil)}
 	}
 
+	var nodes []*MerkleNode
 	for _, datum := range data {
-		node := NewMerkleNode(nil, nil, datum)
-		nodes = append(nodes, *node)
+		nodes = append(nodes, NewMerkleNode(nil, nil, datum))
 	}
 
-	//fmt.Println("---@@nodes %d...",len(nodes))
-
-	for i := 0; i < len(data)/2; i++ {
-		var newLevel []MerkleNode
-
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+		var newLevel []*MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
-			newLevel = append(newLevel, *node)
+			newLevel = append(newLevel, NewMerkleNode(nodes[j], nodes[j+1], nil))
 		}
-
 		nodes = newLevel
 	}
 
-	mTree := MerkleTree{&nodes[0]}
-
-	return &mTree
+	return &MerkleTree{nodes[0]}
 }
 
 // NewMerkleNode creates a new Merkle tree node
@@ -53,12 +54,9 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	mNode := MerkleNode{}
 
 	if left == nil && right == nil {
-		hash := sha256.Sum256(data)
-		mNode.Data = hash[:]
+		mNode.Data = hashMerkleLeaf(data)
 	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash := sha256.Sum256(prevHashes)
-		mNode.Data = hash[:]
+		mNode.Data = hashMerkleNode(left.Data, right.Data)
 	}
 
 	mNode.Left = left
@@ -66,3 +64,44 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 
 	return &mNode
 }
+
+func hashMerkleLeaf(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+
+	return hash[:]
+}
+
+func hashMerkleNode(left, right []byte) []byte {
+	data := append([]byte{merkleNodePrefix}, left...)
+	hash := sha256.Sum256(append(data, right...))
+
+	return hash[:]
+}
+
+// legacyMerkleRoot returns the root blocks below MerkleTreeHeight commit
+// to, hashed without the prefixes and with a lone leaf paired with itself
+func legacyMerkleRoot(data [][]byte) []byte {
+	if len(data)%2 != 0 {
+		data = append(data, data[len(data)-1])
+	}
+
+	var nodes [][]byte
+	for _, datum := range data {
+		hash := sha256.Sum256(datum)
+		nodes = append(nodes, hash[:])
+	}
+
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+		var newLevel [][]byte
+		for j := 0; j < len(nodes); j += 2 {
+			hash := sha256.Sum256(append(append([]byte(nil), nodes[j]...), nodes[j+1]...))
+			newLevel = append(newLevel, hash[:])
+		}
+		nodes = newLevel
+	}
+
+	return nodes[0]
+}
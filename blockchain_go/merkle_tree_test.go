@@ -1,6 +1,7 @@
 package core
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"testing"
@@ -31,19 +32,19 @@ func TestNewMerkleNode(t *testing.T) {
 
 	assert.Equal(
 		t,
-		"64b04b718d8b7c5b6fd17f7ec221945c034cfce3be4118da33244966150c4bd4",
+		"3cbea6c40e91c3bf19801606c56b3ed61d46707b12f98d007638a2e71bafeab3",
 		hex.EncodeToString(n5.Data),
 		"Level 1 hash 1 is correct",
 	)
 	assert.Equal(
 		t,
-		"08bd0d1426f87a78bfc2f0b13eccdf6f5b58dac6b37a7b9441c1a2fab415d76c",
+		"4339266e7296a485ce1cc97a6194eae7817da4753d9d6c6b4174fbff5104b0c1",
 		hex.EncodeToString(n6.Data),
 		"Level 1 hash 2 is correct",
 	)
 	assert.Equal(
 		t,
-		"4e3e44e55926330ab6c31892f980f8bfd1a6e910ff1ebc3f778211377f35227e",
+		"031821f82b630c276d9037c9af1c5b74a271a41bc04679ae0af6b8bb1b0f46d3",
 		hex.EncodeToString(n7.Data),
 		"Root hash is correct",
 	)
@@ -73,3 +74,40 @@ func TestNewMerkleTree(t *testing.T) {
 
 	assert.Equal(t, rootHash, fmt.Sprintf("%x", mTree.RootNode.Data), "Merkle tree root hash is correct")
 }
+
+func TestMerkleTreeSizes(t *testing.T) {
+	n1 := NewMerkleNode(nil, nil, []byte("node1"))
+	n2 := NewMerkleNode(nil, nil, []byte("node2"))
+	assert.Equal(t, "d93dbb4730f9739482de28e90462640c469dafca184e80575cf977f88fbefcf0", hex.EncodeToString(n1.Data))
+	assert.Equal(t, n1.Data, NewMerkleTree([][]byte{[]byte("node1")}).RootNode.Data, "A lone leaf is the root")
+	assert.Equal(t, NewMerkleNode(n1, n2, nil).Data, NewMerkleTree([][]byte{[]byte("node1"), []byte("node2")}).RootNode.Data)
+
+	// odd levels above the leaves pair their last node with itself too
+	var data [][]byte
+	var leaves []*MerkleNode
+	for i := 0; i < 5; i++ {
+		data = append(data, []byte(fmt.Sprintf("node%d", i)))
+		leaves = append(leaves, NewMerkleNode(nil, nil, data[i]))
+	}
+	n01 := NewMerkleNode(leaves[0], leaves[1], nil)
+	n23 := NewMerkleNode(leaves[2], leaves[3], nil)
+	n44 := NewMerkleNode(leaves[4], leaves[4], nil)
+	n0123 := NewMerkleNode(n01, n23, nil)
+	n4444 := NewMerkleNode(n44, n44, nil)
+	assert.Equal(t, NewMerkleNode(n0123, n4444, nil).Data, NewMerkleTree(data).RootNode.Data)
+}
+
+func TestLegacyMerkleRoot(t *testing.T) {
+	hash := func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+	pair := func(left, right []byte) []byte {
+		return hash(append(append([]byte(nil), left...), right...))
+	}
+	h1, h2, h3 := hash([]byte("node1")), hash([]byte("node2")), hash([]byte("node3"))
+
+	assert.Equal(t, pair(h1, h1), legacyMerkleRoot([][]byte{[]byte("node1")}))
+	assert.Equal(t, pair(h1, h2), legacyMerkleRoot([][]byte{[]byte("node1"), []byte("node2")}))
+	assert.Equal(t, pair(pair(h1, h2), pair(h3, h3)), legacyMerkleRoot([][]byte{[]byte("node1"), []byte("node2"), []byte("node3")}))
+}
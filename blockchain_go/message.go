@@ -0,0 +1,46 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// signedMessagePrefix separates message signatures from transaction
+// signatures, so a signed message can never be replayed as a spend
+const signedMessagePrefix = "SwarmChain Signed Message:\n"
+
+// messageDigest returns the hash signed by SignMessage
+func messageDigest(msg []byte) []byte {
+	prefix := fmt.Sprintf("%c%s%d", len(signedMessagePrefix), signedMessagePrefix, len(msg))
+
+	return crypto.Keccak256([]byte(prefix), msg)
+}
+
+// SignMessage signs an arbitrary message with the wallet key. The 65 byte
+// signature allows VerifyMessage to recover the public key
+func (w Wallet) SignMessage(msg []byte) ([]byte, error) {
+	if w.PrivateKey.D == nil {
+		return nil, errors.New("message signing needs the private key in the wallet")
+	}
+	return crypto.Sign(messageDigest(msg), &w.PrivateKey)
+}
+
+// VerifyMessage checks that sig is a signature of msg made by the key
+// behind address
+func VerifyMessage(address string, msg, sig []byte) bool {
+	pubKeyHash, err := GetPubKeyHashFromAddress(address)
+	if err != nil {
+		return false
+	}
+
+	pub, err := crypto.SigToPub(messageDigest(msg), sig)
+	if err != nil {
+		return false
+	}
+	pubKey := append(pub.X.Bytes(), pub.Y.Bytes()...)
+
+	return bytes.Equal(HashPubKey(pubKey), pubKeyHash)
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignMessage(t *testing.T) {
+	wallet := NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	other := fmt.Sprintf("%s", NewWallet().GetAddress())
+	msg := []byte("I control this address")
+
+	sig, err := wallet.SignMessage(msg)
+	assert.Nil(t, err)
+	assert.Len(t, sig, 65)
+
+	assert.True(t, VerifyMessage(address, msg, sig))
+	assert.False(t, VerifyMessage(other, msg, sig), "Signature belongs to another address")
+	assert.False(t, VerifyMessage(address, []byte("I control another address"), sig))
+	assert.False(t, VerifyMessage(address, msg, sig[:64]))
+	assert.False(t, VerifyMessage("1", msg, sig))
+}
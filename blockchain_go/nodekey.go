@@ -0,0 +1,98 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// DefaultNodeID names the wallets and files of the node when NODE_ID isn't
+// set
+const DefaultNodeID = "node"
+
+// NodeKeyFile returns the file of the key the node nodeID is known by to its
+// peers, kept apart from its wallet so removing an address keeps its identity
+func NodeKeyFile(nodeID string) string {
+	return DataPath(fmt.Sprintf("nodekey_%s", genWalletFileName(nodeID)))
+}
+
+// LoadNodeKey returns the key of the node nodeID, created on its first run
+// from walletNodeKey, so a node keeps the identity its peers knew it by, or
+// generated for a node without a wallet
+func LoadNodeKey(nodeID string) (*ecdsa.PrivateKey, error) {
+	key, err := ReadNodeKey(nodeID)
+	if err == nil || !os.IsNotExist(err) {
+		return key, err
+	}
+	if key = walletNodeKey(nodeID); key == nil {
+		if key, err = crypto.GenerateKey(); err != nil {
+			return nil, err
+		}
+	}
+	data := hex.EncodeToString(crypto.FromECDSA(key))
+	if err := ioutil.WriteFile(NodeKeyFile(nodeID), []byte(data), 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// ReadNodeKey returns the key of the node nodeID, an error satisfying
+// os.IsNotExist before its first run
+func ReadNodeKey(nodeID string) (*ecdsa.PrivateKey, error) {
+	file := NodeKeyFile(nodeID)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("node key %s: %v", file, err)
+	}
+	key, err := crypto.ToECDSA(raw)
+	if err != nil {
+		return nil, fmt.Errorf("node key %s: %v", file, err)
+	}
+
+	return key, nil
+}
+
+// PeerNodeKey returns the key of another node of this data directory, the
+// one LoadNodeKey gives it before its first run, nil when it has neither a
+// key nor a wallet
+func PeerNodeKey(nodeID string) (*ecdsa.PrivateKey, error) {
+	key, err := ReadNodeKey(nodeID)
+	if err == nil || !os.IsNotExist(err) {
+		return key, err
+	}
+
+	return walletNodeKey(nodeID), nil
+}
+
+// walletNodeKey returns the key of the first address of the wallet of the
+// node nodeID, the identity of the nodes started before the node key
+// existed, nil without a wallet
+func walletNodeKey(nodeID string) *ecdsa.PrivateKey {
+	wallets, err := NewWalletsReadOnly(nodeID)
+	if err != nil {
+		return nil
+	}
+	addresses := wallets.GetAddresses()
+	if len(addresses) == 0 {
+		return nil
+	}
+	sort.Strings(addresses)
+	wallet, err := wallets.GetWallet(addresses[0])
+	if err != nil {
+		return nil
+	}
+	key := wallet.PrivateKey
+
+	return &key
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeKey(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, err := ReadNodeKey("localhost:3000")
+		assert.True(t, os.IsNotExist(err))
+		key, err := PeerNodeKey("localhost:3000")
+		assert.Nil(t, err)
+		assert.Nil(t, key)
+
+		// a node without a wallet gets a new key, kept across runs
+		key, err = LoadNodeKey("localhost:3000")
+		assert.Nil(t, err)
+		again, err := LoadNodeKey("localhost:3000")
+		assert.Nil(t, err)
+		assert.Equal(t, key.D, again.D)
+		assert.Equal(t, "nodekey_localhost_3000", NodeKeyFile("localhost:3000"))
+
+		// a node that had a wallet keeps the key of its first address,
+		// which the other nodes of the directory know before it first runs
+		ws, err := NewWallets("localhost:3001")
+		assert.True(t, os.IsNotExist(err))
+		ws.CreateWallet()
+		ws.CreateWallet()
+		assert.Nil(t, ws.SaveToFile("localhost:3001"))
+		ws.Close()
+		addresses := ws.GetAddresses()
+		sort.Strings(addresses)
+		first, err := ws.GetWallet(addresses[0])
+		assert.Nil(t, err)
+		peer, err := PeerNodeKey("localhost:3001")
+		assert.Nil(t, err)
+		key, err = LoadNodeKey("localhost:3001")
+		assert.Nil(t, err)
+		assert.Equal(t, first.PrivateKey.D, key.D)
+		assert.Equal(t, key.D, peer.D)
+
+		assert.Nil(t, ioutil.WriteFile(NodeKeyFile("localhost:3002"), []byte("not hex"), 0600))
+		_, err = LoadNodeKey("localhost:3002")
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultMaxOrphans and DefaultOrphanAge bound the blocks an OrphanPool
+// holds
+var (
+	DefaultMaxOrphans = 100
+	DefaultOrphanAge  = time.Hour
+)
+
+// OrphanStats are the counters of an OrphanPool
+type OrphanStats struct {
+	Held      int    `json:"held"`
+	Connected uint64 `json:"connected"` // orphans stored once their parent was
+	Evicted   uint64 `json:"evicted"`   // orphans dropped for the size or age cap
+}
+
+// OrphanPool holds blocks that arrived before their parent, keyed by the
+// parent hash, until the parent is stored. Past its capacity the oldest
+// orphans are evicted, and orphans older than the age cap whenever one is
+// added
+type OrphanPool struct {
+	mu        sync.Mutex
+	max       int
+	maxAge    time.Duration
+	orphans   map[string]*orphan  // by block hash
+	children  map[string][]string // hashes of the orphans by parent hash
+	connected uint64
+	evicted   uint64
+}
+
+type orphan struct {
+	block *Block
+	added time.Time
+}
+
+// NewOrphanPool returns a pool holding up to max orphans for up to maxAge
+func NewOrphanPool(max int, maxAge time.Duration) *OrphanPool {
+	return &OrphanPool{
+		max:      max,
+		maxAge:   maxAge,
+		orphans:  make(map[string]*orphan),
+		children: make(map[string][]string),
+	}
+}
+
+// Process hands block to bc.ProcessBlock, then the orphans it is the
+// ancestor of as their parents get stored, calling stored for each block
+// stored. A block whose parent is unknown is held and ErrOrphanBlock
+// returned, unless it has no timestamp or one too far ahead, a target
+// easier than the network allows or not met, or is too big. Orphans that
+// turn out invalid are dropped
+func (p *OrphanPool) Process(bc *Blockchain, block *Block, stored func(block *Block, reorg *ReorgEvent)) error {
+	reorg, err := bc.ProcessBlock(block)
+	if err == ErrOrphanBlock {
+		// the header is hashed with its timestamp
+		if block.Timestamp == nil {
+			return ErrTimeTooOld
+		}
+		if tooNew(&block.BlockHeader) {
+			return ErrTimeTooNew
+		}
+		if err := block.checkDifficulty(); err != nil {
+			return err
+		}
+		if block.Target().Cmp(ActiveNetParams.PowLimit()) > 0 {
+			return fmt.Errorf("orphan block %x has a target above the limit of the network", block.Hash)
+		}
+		if hash, pow := calculateHash(block); !bytes.Equal(hash, block.Hash) || !pow.Validate() {
+			return fmt.Errorf("orphan block %x doesn't meet its target", block.Hash)
+		}
+		if err := CheckBlockSize(block); err != nil {
+			return err
+		}
+		p.add(block)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	stored(block, reorg)
+
+	queue := p.takeChildren(block.Hash)
+	for len(queue) > 0 {
+		child := queue[0]
+		queue = queue[1:]
+		reorg, err := bc.ProcessBlock(child)
+		if err != nil {
+			log.Printf("dropping orphan block %x: %v", child.Hash, err)
+			continue
+		}
+		p.mu.Lock()
+		p.connected++
+		p.mu.Unlock()
+		stored(child, reorg)
+		queue = append(queue, p.takeChildren(child.Hash)...)
+	}
+
+	return nil
+}
+
+// add holds block, once, evicting orphans past the caps
+func (p *OrphanPool) add(block *Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hash := hex.EncodeToString(block.Hash)
+	if _, ok := p.orphans[hash]; ok {
+		return
+	}
+
+	now := time.Now()
+	for hash, o := range p.orphans {
+		if now.Sub(o.added) > p.maxAge {
+			p.remove(hash)
+			p.evicted++
+		}
+	}
+	for len(p.orphans) >= p.max && len(p.orphans) > 0 {
+		var oldest string
+		for hash, o := range p.orphans {
+			if oldest == "" || o.added.Before(p.orphans[oldest].added) {
+				oldest = hash
+			}
+		}
+		p.remove(oldest)
+		p.evicted++
+	}
+
+	p.orphans[hash] = &orphan{block, now}
+	parent := hex.EncodeToString(block.PrevBlockHash)
+	p.children[parent] = append(p.children[parent], hash)
+}
+
+// remove drops an orphan, p.mu held
+func (p *OrphanPool) remove(hash string) {
+	o := p.orphans[hash]
+	delete(p.orphans, hash)
+	parent := hex.EncodeToString(o.block.PrevBlockHash)
+	siblings := p.children[parent][:0]
+	for _, sibling := range p.children[parent] {
+		if sibling != hash {
+			siblings = append(siblings, sibling)
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.children, parent)
+	} else {
+		p.children[parent] = siblings
+	}
+}
+
+// takeChildren removes and returns the orphans whose parent is hash, oldest
+// first
+func (p *OrphanPool) takeChildren(hash []byte) []*Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var blocks []*Block
+	for _, child := range p.children[hex.EncodeToString(hash)] {
+		blocks = append(blocks, p.orphans[child].block)
+		delete(p.orphans, child)
+	}
+	delete(p.children, hex.EncodeToString(hash))
+
+	return blocks
+}
+
+// Has tells if the block with the given hash is held
+func (p *OrphanPool) Has(hash []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.orphans[hex.EncodeToString(hash)]
+
+	return ok
+}
+
+// MissingAncestor returns the hash of the block the orphan with the given
+// hash waits for, the parent of its oldest ancestor held
+func (p *OrphanPool) MissingAncestor(hash []byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.orphans[hex.EncodeToString(hash)]
+	if !ok {
+		return nil
+	}
+	// the bound guards against a cycle of forged hashes
+	for i := 0; i < len(p.orphans); i++ {
+		parent, ok := p.orphans[hex.EncodeToString(o.block.PrevBlockHash)]
+		if !ok {
+			break
+		}
+		o = parent
+	}
+
+	return o.block.PrevBlockHash
+}
+
+// Stats returns the counters of the pool
+func (p *OrphanPool) Stats() OrphanStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return OrphanStats{Held: len(p.orphans), Connected: p.connected, Evicted: p.evicted}
+}
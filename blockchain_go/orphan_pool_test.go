@@ -0,0 +1,78 @@
+package core
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphanPool(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		b1 := minedBlock(&genesis, address)
+		b2 := minedBlock(b1, address)
+		b3 := minedBlock(b2, address)
+		b4 := minedBlock(b3, address)
+		var stored []*Block
+		collect := func(block *Block, reorg *ReorgEvent) {
+			assert.Nil(t, reorg)
+			stored = append(stored, block)
+		}
+
+		pool := NewOrphanPool(10, time.Hour)
+		for _, block := range []*Block{b3, b4, b3, b2} {
+			assert.Equal(t, ErrOrphanBlock, pool.Process(bc, block, collect))
+		}
+		assert.Equal(t, OrphanStats{Held: 3}, pool.Stats(), "The same orphan is held once")
+		assert.True(t, pool.Has(b4.Hash))
+		assert.Equal(t, b1.Hash, pool.MissingAncestor(b4.Hash))
+		assert.Nil(t, pool.MissingAncestor(b1.Hash))
+		assert.NotNil(t, pool.Process(bc, forkBlock(b4, address), collect), "Orphans must meet their target")
+
+		// a header that can't be hashed, or whose target is bogus, is refused
+		// before proof of work is checked
+		noTime := forkBlock(b4, address)
+		noTime.Timestamp = nil
+		assert.Equal(t, ErrTimeTooOld, pool.Process(bc, noTime, collect))
+		bogus := forkBlock(b4, address)
+		bogus.Difficulty = big.NewInt(maxDifficulty + 1)
+		assert.Equal(t, ErrBadDifficulty, pool.Process(bc, bogus, collect))
+		easy := forkBlock(b4, address)
+		easy.Bits = 0x2100ffff
+		assert.NotNil(t, pool.Process(bc, easy, collect), "Orphans can't have a target above the limit")
+		assert.Equal(t, OrphanStats{Held: 3}, pool.Stats())
+
+		assert.Nil(t, pool.Process(bc, b1, collect))
+		assert.Equal(t, []*Block{b1, b2, b3, b4}, stored)
+		_, tip := bestTip(bc)
+		assert.Equal(t, b4.Hash, tip)
+		assert.Equal(t, b4.Hash, mustTip(UTXOSet.BestBlock()))
+		assert.Equal(t, OrphanStats{Connected: 3}, pool.Stats())
+
+		// the oldest orphans go past the size cap, the expired ones at once
+		pool = NewOrphanPool(2, time.Hour)
+		c2 := minedBlock(minedBlock(b4, address), address)
+		d2 := minedBlock(minedBlock(b4, address), address)
+		e2 := minedBlock(minedBlock(b4, address), address)
+		for _, block := range []*Block{c2, d2, e2} {
+			assert.Equal(t, ErrOrphanBlock, pool.Process(bc, block, collect))
+		}
+		assert.False(t, pool.Has(c2.Hash))
+		assert.True(t, pool.Has(d2.Hash))
+		assert.Equal(t, OrphanStats{Held: 2, Evicted: 1}, pool.Stats())
+		pool.orphans[hex.EncodeToString(e2.Hash)].added = time.Now().Add(-2 * time.Hour)
+		f2 := minedBlock(minedBlock(b4, address), address)
+		assert.Equal(t, ErrOrphanBlock, pool.Process(bc, f2, collect))
+		assert.False(t, pool.Has(e2.Hash))
+		assert.True(t, pool.Has(d2.Hash))
+		assert.Equal(t, OrphanStats{Held: 2, Evicted: 2}, pool.Stats())
+	})
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Outpoint identifies a transaction output
+type Outpoint struct {
+	TxID string // hex encoded
+	Vout int
+}
+
+// OutpointSet is a set of outputs, used to keep coin selection away from
+// outputs already spent by unconfirmed transactions
+type OutpointSet map[Outpoint]struct{}
+
+// Add puts an output into the set
+func (s OutpointSet) Add(txID []byte, vout int) {
+	s[Outpoint{hex.EncodeToString(txID), vout}] = struct{}{}
+}
+
+// Has reports whether an output is in the set. A nil set is empty
+func (s OutpointSet) Has(txID []byte, vout int) bool {
+	_, ok := s[Outpoint{hex.EncodeToString(txID), vout}]
+	return ok
+}
+
+// AddInputs puts the outputs spent by tx into the set
+func (s OutpointSet) AddInputs(tx *Transaction) {
+	if tx.IsCoinbase() {
+		return
+	}
+	for _, vin := range tx.Vin {
+		s.Add(vin.Txid, vin.Vout)
+	}
+}
+
+// serializeOutpoint encodes an output as its transaction ID followed by the
+// big endian output index, the form kept in the pending queue
+func serializeOutpoint(txID []byte, vout int) []byte {
+	data := make([]byte, len(txID)+4)
+	copy(data, txID)
+	binary.BigEndian.PutUint32(data[len(txID):], uint32(vout))
+
+	return data
+}
+
+func deserializeOutpoint(data []byte) ([]byte, int, bool) {
+	if len(data) < 4 {
+		return nil, 0, false
+	}
+	split := len(data) - 4
+
+	return data[:split], int(binary.BigEndian.Uint32(data[split:])), true
+}
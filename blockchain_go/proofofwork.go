@@ -1,55 +1,55 @@
 package core
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
-	"bytes"
 )
 
 var (
 	maxNonce = math.MaxInt64
 )
 
-//const targetBits = 4
-var targetBitsVar int64
+// abortCheckInterval is the number of nonces RunContext tries between two
+// looks at its context
+const abortCheckInterval = 4096
+
+// ErrMiningAborted is returned when mining is stopped before it finds a
+// block. It isn't a failure: whoever mines stopped it
+var ErrMiningAborted = errors.New("mining aborted")
 
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
-// NewProofOfWork builds and returns a ProofOfWork
-func NewProofOfWork(b *Block,targetBits int64) *ProofOfWork {
-	target := big.NewInt(1)
-	fmt.Printf("--->",targetBits)
-	target.Lsh(target, uint(256-targetBits))
-	targetBitsVar = targetBits
-
-	pow := &ProofOfWork{b, target}
+// NewProofOfWork builds and returns a ProofOfWork against the target of
+// the block
+func NewProofOfWork(b *Block) *ProofOfWork {
+	pow := &ProofOfWork{&b.BlockHeader, b.Target()}
 
 	return pow
 }
 
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			IntToHex(pow.block.Timestamp.Int64()),
-			IntToHex(int64(targetBitsVar)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	return pow.header.prepareData(nonce)
 }
 
 // Run performs a proof-of-work
 func (pow *ProofOfWork) Run() (int, []byte) {
+	nonce, hash, _ := pow.RunContext(context.Background())
+
+	return nonce, hash
+}
+
+// RunContext performs a proof-of-work until it finds a nonce or ctx is done,
+// looking at ctx every abortCheckInterval nonces. Once ctx is done it
+// returns the nonce it stopped at and ErrMiningAborted
+func (pow *ProofOfWork) RunContext(ctx context.Context) (int, []byte, error) {
 	var hashInt big.Int
 	var hash [32]byte
 	var data []byte
@@ -57,6 +57,14 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 
 	fmt.Printf("Mining a new block")
 	for nonce < maxNonce {
+		if nonce%abortCheckInterval == 0 {
+			select {
+			case <-ctx.Done():
+				fmt.Print("\n\n")
+				return nonce, nil, ErrMiningAborted
+			default:
+			}
+		}
 		data = pow.prepareData(nonce)
 
 		hash = sha256.Sum256(data)
@@ -88,14 +96,14 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 	*/
 	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	return nonce, hash[:], nil
 }
 
 // Validate validates block's PoW
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
+	data := pow.prepareData(pow.header.Nonce)
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
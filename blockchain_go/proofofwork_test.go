@@ -0,0 +1,78 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkedContext calls onCheck each time its Done channel is asked for
+type checkedContext struct {
+	context.Context
+	onCheck func()
+}
+
+func (c checkedContext) Done() <-chan struct{} {
+	c.onCheck()
+	return c.Context.Done()
+}
+
+func TestRunContext(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		pow := NewProofOfWork(forkBlock(&genesis, address))
+		pow.target = big.NewInt(0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		checks := 0
+		nonce, hash, err := pow.RunContext(checkedContext{ctx, func() {
+			if checks++; checks == 3 {
+				cancel()
+			}
+		}})
+		assert.Equal(t, ErrMiningAborted, err)
+		assert.Nil(t, hash)
+		assert.Equal(t, 3, checks)
+		assert.True(t, nonce <= 3*abortCheckInterval, "The abort lands within the interval")
+
+		nonce, _, err = pow.RunContext(ctx)
+		assert.Equal(t, ErrMiningAborted, err)
+		assert.Equal(t, 0, nonce, "A done context stops mining before it starts")
+	})
+}
+
+func TestMineBlockContext(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		block, err := bc.MineBlockContext(ctx, []*Transaction{NewCoinbaseTX(address, "")})
+		assert.Equal(t, ErrMiningAborted, err)
+		assert.Nil(t, block)
+		assert.Equal(t, genesis.Hash, bc.tip)
+
+		// a block connecting meanwhile makes the one mined stale
+		other := minedBlock(&genesis, address)
+		block, err = bc.MineBlockContext(checkedContext{context.Background(), func() {
+			if !bytes.Equal(bc.tip, other.Hash) {
+				_, err := bc.ProcessBlock(other)
+				assert.Nil(t, err)
+			}
+		}}, []*Transaction{NewCoinbaseTX(address, "")})
+		assert.Equal(t, ErrMiningAborted, err)
+		assert.Nil(t, block)
+		assert.Equal(t, other.Hash, bc.tip)
+		assert.NotNil(t, mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")}), "Not otherwise")
+	})
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// reorgBucket holds the tip a reorganisation switches the chain to under
+// reorgTargetKey, and the tip it started at under reorgFromKey, from before
+// the first block is disconnected until the last one is connected
+const reorgBucket = "reorg"
+
+var (
+	reorgTargetKey = []byte("target")
+	reorgFromKey   = []byte("from")
+)
+
+// Reorganize switches the chain to branch, consecutive blocks oldest first,
+// the parent of the first being a block of the database. The blocks of the
+// chain above the fork are disconnected tip first, the UTXO set undoing
+// each, then the branch is connected as a BlockImporter does. Every step
+// moves the tip along with the set in one bolt transaction, and the branch
+// and its tip are stored before the first, so NewBlockchain finishes a
+// reorganisation a crash interrupted. It returns the transactions of the
+// disconnected blocks that the branch doesn't include, coinbases aside,
+// oldest first, for the mempool. Each block of the branch is validated with
+// VerifyBlock as it is connected; when one is refused the chain goes back to
+// where it was and the error of VerifyBlock is returned, see reorganizeTo.
+// The branch needn't have more work than the chain, see ProcessBlock for that
+func (bc *Blockchain) Reorganize(branch []*Block) ([]*Transaction, error) {
+	if len(branch) == 0 {
+		return nil, errors.New("the branch is empty")
+	}
+	for i := 1; i < len(branch); i++ {
+		if !bytes.Equal(branch[i].PrevBlockHash, branch[i-1].Hash) {
+			return nil, fmt.Errorf("block %x of the branch doesn't extend block %x", branch[i].Hash, branch[i-1].Hash)
+		}
+	}
+	target := branch[len(branch)-1].Hash
+
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		if blocks.Get(branch[0].PrevBlockHash) == nil {
+			return fmt.Errorf("the branch forks from block %x, which is unknown", branch[0].PrevBlockHash)
+		}
+		for _, block := range branch {
+			if err := putBlock(tx, block); err != nil {
+				return err
+			}
+			if _, err := putChainWork(tx, block); err != nil {
+				return err
+			}
+		}
+		return beginReorg(tx, target)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := bc.reorganize(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return event.Orphaned, nil
+}
+
+// beginReorg stores target as the tip a reorganisation switches the chain
+// to, and the chain tip as the one it goes back to if a block is refused
+func beginReorg(tx *bolt.Tx, target []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(reorgBucket))
+	if err != nil {
+		return err
+	}
+	from := append([]byte(nil), tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))...)
+	if err := b.Put(reorgFromKey, from); err != nil {
+		return err
+	}
+
+	return b.Put(reorgTargetKey, target)
+}
+
+// reorganizeTo moves the tip to target, a stored block, then forgets the
+// reorganisation. It returns the blocks disconnected, tip first, and the
+// blocks connected, oldest first. With verify set each block is validated
+// with VerifyBlock against the UTXO set of its parent before it is
+// connected; when one is refused the chain goes back to the tip the
+// reorganisation started at, see abortReorg, and the error is returned. A
+// branch through a block marked invalid is refused before anything is
+// disconnected. When an undo record is missing the UTXO set is rebuilt at
+// the fork. The blocks are sent to the subscribers as they are disconnected
+// and connected
+func (bc *Blockchain) reorganizeTo(target []byte, verify bool) ([]*Block, []*Block, error) {
+	var disconnect, connect []*Block
+	var fork *Block // the last block the chain and the branch share
+	var from []byte
+	invalid := -1 // the first block of the branch marked invalid
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		load := func(hash []byte) (*Block, error) {
+			data := blocks.Get(hash)
+			if data == nil {
+				return nil, fmt.Errorf("block %x is not found", hash)
+			}
+			return DeserializeBlock(data), nil
+		}
+
+		current, err := load(blocks.Get([]byte("l")))
+		if err != nil {
+			return err
+		}
+		from = current.Hash
+		if b := tx.Bucket([]byte(reorgBucket)); b != nil && b.Get(reorgFromKey) != nil {
+			from = append([]byte(nil), b.Get(reorgFromKey)...)
+		}
+		branch, err := load(target)
+		if err != nil {
+			return err
+		}
+		// walk both back to the fork, the higher one first
+		for !bytes.Equal(current.Hash, branch.Hash) {
+			if current.Height.Cmp(branch.Height) >= 0 {
+				disconnect = append(disconnect, current)
+				current, err = load(current.PrevBlockHash)
+			} else {
+				connect = append([]*Block{branch}, connect...)
+				branch, err = load(branch.PrevBlockHash)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		fork = current
+		for i, block := range connect {
+			if invalid < 0 && isMarkedInvalid(tx, block.Hash) {
+				invalid = i
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if verify && invalid >= 0 {
+		if err := bc.markInvalid(connect[invalid:], ErrInvalidAncestor); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, bc.abortReorg(from, ErrInvalidAncestor)
+	}
+
+	u := UTXOSet{Blockchain: bc}
+	for i, block := range disconnect {
+		err := u.write(func(tx *bolt.Tx, w *utxoWriter) error {
+			if err := undoBlock(tx, w, block); err != nil {
+				return err
+			}
+			return putTip(tx, block.PrevBlockHash)
+		})
+		if err != nil {
+			log.Printf("undoing block %x: %v, reindexing the UTXO set", block.Hash, err)
+			if err := bc.reindexAt(fork.Hash); err != nil {
+				return disconnect, connect, err
+			}
+			bc.notifyDisconnected(disconnect[i:]...)
+			break
+		}
+		bc.tip = block.PrevBlockHash
+		bc.notifyDisconnected(block)
+	}
+
+	if len(connect) > 0 {
+		// a block is checked against the set at its parent, so each is
+		// written before the next one is verified
+		batchSize := len(connect)
+		if verify {
+			batchSize = 1
+		}
+		importer := NewBlockImporter(bc, batchSize)
+		prev := fork
+		for i, block := range connect {
+			if verify {
+				if err := bc.VerifyBlock(block, prev); err != nil {
+					log.Printf("block %x of the branch refused: %v", block.Hash, err)
+					if IsInvalidBlock(err) {
+						if markErr := bc.markInvalid(connect[i:], err); markErr != nil {
+							log.Printf("marking block %x invalid: %v", block.Hash, markErr)
+						}
+					}
+					return nil, nil, bc.abortReorg(from, err)
+				}
+			}
+			if err := importer.Add(block); err != nil {
+				return nil, nil, err
+			}
+			prev = block
+		}
+		if err := importer.Flush(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return disconnect, connect, bc.endReorg()
+}
+
+// abortReorg takes the chain back to from, the tip a reorganisation started
+// at, after err refused a block of the branch, and returns err. The old chain
+// was validated already and is connected again without VerifyBlock. A crash
+// meanwhile is finished by resumeReorg towards from
+func (bc *Blockchain) abortReorg(from []byte, err error) error {
+	moveErr := bc.Db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(reorgBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(reorgTargetKey, from)
+	})
+	if moveErr == nil {
+		_, _, moveErr = bc.reorganizeTo(from, false)
+	}
+	if moveErr != nil {
+		log.Printf("going back to block %x: %v", from, moveErr)
+	}
+
+	return err
+}
+
+// reindexAt moves the tip to target and rebuilds the UTXO set there
+func (bc *Blockchain) reindexAt(target []byte) error {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		return putTip(tx, target)
+	})
+	if err != nil {
+		return err
+	}
+	bc.tip = target
+
+	return UTXOSet{Blockchain: bc}.Reindex()
+}
+
+func (bc *Blockchain) endReorg() error {
+	return bc.Db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(reorgBucket))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// resumeReorg finishes a reorganisation a crash interrupted
+func (bc *Blockchain) resumeReorg() error {
+	var target []byte
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(reorgBucket)); b != nil {
+			target = append([]byte(nil), b.Get(reorgTargetKey)...)
+		}
+		return nil
+	})
+	if err != nil || len(target) == 0 {
+		return err
+	}
+
+	log.Printf("finishing the reorganisation to block %x", target)
+	_, _, err = bc.reorganizeTo(target, true)
+
+	return err
+}
+
+// orphanedTransactions returns the transactions of the disconnected blocks,
+// tip first, that none of the connected blocks include, coinbases aside,
+// oldest first
+func orphanedTransactions(disconnected, connected []*Block) []*Transaction {
+	included := make(map[string]bool)
+	for _, block := range connected {
+		for _, tx := range block.Transactions {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	var orphaned []*Transaction
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if !tx.IsCoinbase() && !included[hex.EncodeToString(tx.ID)] {
+				orphaned = append(orphaned, tx)
+			}
+		}
+	}
+
+	return orphaned
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// strayCoinbase returns a coinbase paying to a key no test wallet holds, for
+// blocks mined to confirm a transaction without changing the balances
+func strayCoinbase() *Transaction {
+	return NewCoinbaseTX(fmt.Sprintf("%s", NewWallet().GetAddress()), "")
+}
+
+// forkBlock returns an unmined block on top of prev paying its coinbase to
+// address
+func forkBlock(prev *Block, address string, txs ...*Transaction) *Block {
+	coinbase := NewCoinbaseTX(address, "")
+	hash := sha256.Sum256(append(append([]byte(nil), prev.Hash...), coinbase.ID...))
+
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Timestamp:     new(big.Int).Add(prev.Timestamp, big1),
+			PrevBlockHash: prev.Hash,
+			Height:        new(big.Int).Add(prev.Height, big1),
+		},
+		Transactions: append([]*Transaction{coinbase}, txs...),
+		Hash:         hash[:],
+		ReceivedAt:   time.Now(),
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
+}
+
+func TestReorganize(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		f := newTestFork()
+		defer f.bc.Db.Close()
+		bc, address, other := f.bc, f.address, f.other
+
+		// branch a pays 10 to other, branch b doesn't but is longer
+		send, err := NewUTXOTransaction(f.wallet, other, 10, &f.utxo, nil, 1)
+		assert.Nil(t, err)
+		a1 := minedBlock(f.genesis, address, send)
+		a2 := minedBlock(a1, address)
+		_, err = bc.Reorganize([]*Block{a1, a2})
+		assert.Nil(t, err)
+		_, others := f.assertMatchesReplay(t)
+		assert.Equal(t, int64(10), others)
+
+		b1 := minedBlock(f.genesis, other)
+		b2 := minedBlock(b1, other)
+		b3 := minedBlock(b2, address)
+		orphaned, err := bc.Reorganize([]*Block{b1, b2, b3})
+		assert.Nil(t, err)
+		assert.Len(t, orphaned, 1, "The send goes back to the mempool")
+		assert.Equal(t, send.ID, orphaned[0].ID)
+		height, tip := bestTip(bc)
+		assert.Equal(t, b3.Hash, tip)
+		assert.Equal(t, int64(3), height.Int64())
+		assert.Equal(t, b3.Hash, mustTip(f.utxo.BestBlock()))
+		mine, others := f.assertMatchesReplay(t)
+		assert.Equal(t, int64(2*subsidy), others)
+		assert.Equal(t, int64(2*subsidy), mine)
+
+		// back to branch a, which now includes the send again
+		a3 := minedBlock(a2, address)
+		a4 := minedBlock(a3, address)
+		orphaned, err = bc.Reorganize([]*Block{a3, a4})
+		assert.Nil(t, err)
+		assert.Empty(t, orphaned)
+		_, tip = bestTip(bc)
+		assert.Equal(t, a4.Hash, tip)
+		mine, others = f.assertMatchesReplay(t)
+		assert.Equal(t, int64(10), others)
+		assert.Equal(t, int64(5*subsidy-10), mine)
+
+		_, err = bc.Reorganize([]*Block{forkBlock(a4, address), b3})
+		assert.NotNil(t, err, "The branch must be consecutive")
+		_, err = bc.Reorganize([]*Block{forkBlock(forkBlock(a4, address), address)})
+		assert.NotNil(t, err, "The branch must fork from a known block")
+	})
+}
+
+func TestResumeReorg(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		f := newTestFork()
+		defer f.bc.Db.Close()
+		bc, address, other := f.bc, f.address, f.other
+
+		a1 := minedBlock(f.genesis, address)
+		a2 := minedBlock(a1, address)
+		_, err := bc.Reorganize([]*Block{a1, a2})
+		assert.Nil(t, err)
+		b1 := minedBlock(f.genesis, other)
+		b2 := minedBlock(b1, other)
+		b3 := minedBlock(b2, other)
+
+		// a crash after the target was stored and a2 undone
+		bc.Db.Update(func(tx *bolt.Tx) error {
+			blocks := tx.Bucket([]byte(blocksBucket))
+			for _, block := range []*Block{b1, b2, b3} {
+				blocks.Put(block.Hash, block.Serialize())
+			}
+			b, _ := tx.CreateBucketIfNotExists([]byte(reorgBucket))
+			return b.Put(reorgTargetKey, b3.Hash)
+		})
+		err = f.utxo.write(func(tx *bolt.Tx, w *utxoWriter) error {
+			if err := undoBlock(tx, w, a2); err != nil {
+				return err
+			}
+			return putTip(tx, a1.Hash)
+		})
+		assert.Nil(t, err)
+		bc.tip = a1.Hash
+
+		assert.Nil(t, bc.resumeReorg())
+		_, tip := bestTip(bc)
+		assert.Equal(t, b3.Hash, tip)
+		assert.Equal(t, b3.Hash, mustTip(f.utxo.BestBlock()))
+		_, others := f.assertMatchesReplay(t)
+		assert.Equal(t, int64(3*subsidy), others)
+		assert.Nil(t, bc.resumeReorg(), "The reorganisation is done")
+	})
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrRescanInterrupted is returned by Rescan when it was stopped before
+// reaching the genesis block. Calling Rescan again with the same addresses
+// resumes where it left off
+var ErrRescanInterrupted = errors.New("rescan interrupted")
+
+// rescanCheckpointInterval is the number of blocks between two saves of the
+// rescan progress to the wallet file
+const rescanCheckpointInterval = 1000
+
+// RescanState records how far a Rescan got, walking back from StartTip
+type RescanState struct {
+	Addresses []string
+	StartTip  []byte // chain tip when the rescan started
+	LastBlock []byte // last block scanned
+	Scanned   int
+}
+
+// Rescan walks the blockchain from the tip to the genesis block and records
+// every transaction paying to or spending from addresses in the History of
+// their wallets. No addresses means all of them. progress, when set, is
+// called after each block with the number of blocks scanned so far and the
+// chain length. Closing stop interrupts the scan; the progress is kept in
+// RescanState and persisted for Wallets opened by NewWallets. Returns the
+// number of transactions added to the history
+func (ws *Wallets) Rescan(bc *Blockchain, addresses []string, progress func(scanned, total int), stop <-chan struct{}) (int, error) {
+	if len(addresses) == 0 {
+		addresses = ws.GetAddresses()
+	}
+	pubKeyHashes := make(map[string][]byte)
+	for _, address := range addresses {
+		ws.mu.RLock()
+		_, ok := ws.Wallets[address]
+		ws.mu.RUnlock()
+		if !ok {
+			return 0, fmt.Errorf("%s: %v", address, ErrWalletNotFound)
+		}
+		pubKeyHash, err := GetPubKeyHashFromAddress(address)
+		if err != nil {
+			return 0, err
+		}
+		pubKeyHashes[address] = pubKeyHash
+	}
+
+	height, tip, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return 0, err
+	}
+	total := int(height.Int64()) + 1
+
+	// state is only changed under the lock, SaveToFile may run concurrently
+	ws.mu.Lock()
+	state := ws.RescanState
+	if state == nil || !sameAddresses(state.Addresses, addresses) {
+		state = &RescanState{Addresses: addresses, StartTip: tip}
+	}
+	ws.RescanState = state
+	ws.mu.Unlock()
+
+	found := 0
+	scanBlock := func(block *Block, checkpoint bool) {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+
+		for _, tx := range block.Transactions {
+			for address, pubKeyHash := range pubKeyHashes {
+				wallet, ok := ws.Wallets[address]
+				if ok && txInvolves(tx, pubKeyHash) && wallet.addHistory(tx.ID) {
+					found++
+				}
+			}
+		}
+		if checkpoint {
+			state.LastBlock = block.Hash
+		}
+		state.Scanned++
+	}
+
+	var it *BlockchainIterator
+	var last Block
+	if state.LastBlock != nil {
+		var err error
+		if last, err = bc.GetBlock(state.LastBlock); err != nil {
+			// the block was reorganised away, start over
+			ws.mu.Lock()
+			state.LastBlock = nil
+			ws.mu.Unlock()
+		}
+	}
+
+	if state.LastBlock == nil {
+		ws.mu.Lock()
+		state.StartTip = tip
+		state.Scanned = 0
+		ws.mu.Unlock()
+		it = &BlockchainIterator{tip, bc.Db}
+	} else {
+		// resuming: first the blocks mined since the scan started, then on
+		// from where it stopped
+		complete := false
+		newer := &BlockchainIterator{tip, bc.Db}
+		for !bytes.Equal(newer.currentHash, state.StartTip) {
+			block, err := newer.Next()
+			if err != nil {
+				return found, err
+			}
+			scanBlock(block, false)
+			if len(block.PrevBlockHash) == 0 {
+				// the old tip is gone, the whole chain was just scanned
+				complete = true
+				break
+			}
+		}
+		ws.mu.Lock()
+		state.StartTip = tip
+		ws.mu.Unlock()
+
+		if !complete && len(last.PrevBlockHash) != 0 {
+			it = &BlockchainIterator{last.PrevBlockHash, bc.Db}
+		}
+	}
+
+	for it != nil {
+		select {
+		case <-stop:
+			if ws.nodeID != "" {
+				if err := ws.SaveToFile(ws.nodeID); err != nil {
+					return found, err
+				}
+			}
+			return found, ErrRescanInterrupted
+		default:
+		}
+
+		block, err := it.Next()
+		if err != nil {
+			return found, err
+		}
+		scanBlock(block, true)
+		if progress != nil {
+			progress(state.Scanned, total)
+		}
+		if state.Scanned%rescanCheckpointInterval == 0 && ws.nodeID != "" {
+			if err := ws.SaveToFile(ws.nodeID); err != nil {
+				return found, err
+			}
+		}
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	ws.mu.Lock()
+	ws.RescanState = nil
+	ws.mu.Unlock()
+	if ws.nodeID != "" {
+		if err := ws.SaveToFile(ws.nodeID); err != nil {
+			return found, err
+		}
+	}
+
+	return found, nil
+}
+
+// txInvolves reports whether tx pays to or spends from pubKeyHash
+func txInvolves(tx *Transaction, pubKeyHash []byte) bool {
+	for _, out := range tx.Vout {
+		if out.IsLockedWithKey(pubKeyHash) {
+			return true
+		}
+	}
+	if tx.IsCoinbase() {
+		return false
+	}
+	for _, in := range tx.Vin {
+		if in.UsesKey(pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addHistory records a transaction ID, reporting whether it was new
+func (w *Wallet) addHistory(txID []byte) bool {
+	id := hex.EncodeToString(txID)
+	for _, known := range w.History {
+		if known == id {
+			return false
+		}
+	}
+	w.History = append(w.History, id)
+
+	return true
+}
+
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool, len(a))
+	for _, address := range a {
+		seen[address] = true
+	}
+	for _, address := range b {
+		if !seen[address] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestChain creates a chain in the working directory whose genesis and
+// following blocks pay their coinbase to the given addresses in turn
+func newTestChain(addresses ...string) *Blockchain {
+	bc := mustCreate(addresses[0], "test")
+	for _, address := range addresses[1:] {
+		mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+	}
+
+	return bc
+}
+
+// testFork is a chain in the working directory with its UTXO set indexed,
+// whose genesis block pays to wallet, for the tests building branches on it
+type testFork struct {
+	bc      *Blockchain
+	utxo    UTXOSet
+	wallet  *Wallet
+	address string // the address of wallet
+	other   string // an address no test wallet holds
+	genesis *Block
+}
+
+// newTestFork creates a testFork, panicking on an error
+func newTestFork() *testFork {
+	ws, address := newTestWallets()
+	bc := newTestChain(address)
+	u := UTXOSet{Blockchain: bc}
+	if err := u.Reindex(); err != nil {
+		panic(err)
+	}
+	genesis, err := bc.GetBlock(bc.GenesisHash)
+	if err != nil {
+		panic(err)
+	}
+
+	return &testFork{
+		bc:      bc,
+		utxo:    u,
+		wallet:  ws.Wallets[address],
+		address: address,
+		other:   fmt.Sprintf("%s", NewWallet().GetAddress()),
+		genesis: &genesis,
+	}
+}
+
+// assertMatchesReplay checks the UTXO set and the balances of address and
+// other against a set rebuilt from the chain, and returns those balances
+func (f *testFork) assertMatchesReplay(t *testing.T) (int64, int64) {
+	pubKeyHash := HashPubKey(f.wallet.PublicKey)
+	otherPubKeyHash, _ := GetPubKeyHashFromAddress(f.other)
+	owners := [][]byte{pubKeyHash, otherPubKeyHash}
+	balances, err := f.utxo.GetBalances(owners)
+	assert.Nil(t, err)
+	set := utxoSnapshot(t, f.utxo)
+	assert.Nil(t, f.utxo.Reindex())
+	replayed, err := f.utxo.GetBalances(owners)
+	assert.Nil(t, err)
+	assert.Equal(t, replayed, balances)
+	assert.Equal(t, utxoSnapshot(t, f.utxo), set)
+
+	return balances[fmt.Sprintf("%x", pubKeyHash)], balances[fmt.Sprintf("%x", otherPubKeyHash)]
+}
+
+// mustCreate is CreateBlockchain, panicking on an error
+func mustCreate(address, nodeID string) *Blockchain {
+	bc, err := CreateBlockchain(address, nodeID)
+	if err != nil {
+		panic(err)
+	}
+
+	return bc
+}
+
+// mustMine is MineBlock, panicking on an error
+func mustMine(bc *Blockchain, txs []*Transaction) *Block {
+	block, err := bc.MineBlock(txs)
+	if err != nil {
+		panic(err)
+	}
+
+	return block
+}
+
+// bestTip is GetBestHeightLastHash, panicking on an error
+func bestTip(bc *Blockchain) (*big.Int, []byte) {
+	height, tip, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		panic(err)
+	}
+
+	return height, tip
+}
+
+// mustTxs takes the transactions of SelectTransactions or BlockTransactions,
+// panicking on an error
+func mustTxs(txs []*Transaction, err error) []*Transaction {
+	if err != nil {
+		panic(err)
+	}
+
+	return txs
+}
+
+// mustTip takes the result of UTXOSet.BestBlock, panicking on an error
+func mustTip(tip []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+
+	return tip
+}
+
+// mustOutputs takes the result of FindUTXO, panicking on an error
+func mustOutputs(outs []TXOutput, err error) []TXOutput {
+	if err != nil {
+		panic(err)
+	}
+
+	return outs
+}
+
+// mustUnspent takes the result of ListUnspent, panicking on an error
+func mustUnspent(unspent []UnspentOutput, err error) []UnspentOutput {
+	if err != nil {
+		panic(err)
+	}
+
+	return unspent
+}
+
+// mustSpendable takes the result of FindSpendableOutputs, panicking on an
+// error
+func mustSpendable(acc int, outs map[string][]int, err error) (int, map[string][]int) {
+	if err != nil {
+		panic(err)
+	}
+
+	return acc, outs
+}
+
+// mustBalance takes the result of SpendableBalance, panicking on an error
+func mustBalance(balance int, err error) int {
+	if err != nil {
+		panic(err)
+	}
+
+	return balance
+}
+
+// mustOutpoints takes the result of PendingOutpoints, panicking on an error
+func mustOutpoints(outpoints OutpointSet, err error) OutpointSet {
+	if err != nil {
+		panic(err)
+	}
+
+	return outpoints
+}
+
+// mustReport takes the result of CheckUTXOAmount, panicking on an error
+func mustReport(report AmountReport, err error) AmountReport {
+	if err != nil {
+		panic(err)
+	}
+
+	return report
+}
+
+func TestRescan(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		other := fmt.Sprintf("%s", NewWallet().GetAddress())
+		bc := newTestChain(address, other, address, other)
+		defer bc.Db.Close()
+
+		var calls []int
+		found, err := ws.Rescan(bc, nil, func(scanned, total int) {
+			assert.Equal(t, 4, total)
+			calls = append(calls, scanned)
+		}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, found)
+		assert.Equal(t, []int{1, 2, 3, 4}, calls)
+		assert.Len(t, ws.Wallets[address].History, 2)
+		assert.Nil(t, ws.RescanState)
+
+		genesis, err := bc.GetBlock(bc.GenesisHash)
+		assert.Nil(t, err)
+		assert.Contains(t, ws.Wallets[address].History, hex.EncodeToString(genesis.Transactions[0].ID))
+
+		found, err = ws.Rescan(bc, []string{address}, nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, found, "Known transactions are not added twice")
+
+		_, err = ws.Rescan(bc, []string{other}, nil, nil)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRescanResume(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address, address, address)
+		defer bc.Db.Close()
+
+		stop := make(chan struct{})
+		found, err := ws.Rescan(bc, nil, func(scanned, total int) {
+			if scanned == 2 {
+				close(stop)
+			}
+		}, stop)
+		assert.Equal(t, ErrRescanInterrupted, err)
+		assert.Equal(t, 2, found)
+		assert.NotNil(t, ws.RescanState)
+
+		// a block mined while the scan was interrupted is picked up too
+		mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+
+		var last int
+		found, err = ws.Rescan(bc, nil, func(scanned, total int) {
+			last = scanned
+		}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, found)
+		assert.Equal(t, 5, last)
+		assert.Len(t, ws.Wallets[address].History, 5)
+		assert.Nil(t, ws.RescanState)
+	})
+}
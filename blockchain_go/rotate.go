@@ -0,0 +1,195 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// signatureLen is the size of an r||s input signature, used to estimate the
+// size of a transaction before it is signed
+const signatureLen = 64
+
+// Rotate moves every mature output of oldAddress to a freshly created key
+// and retires the old entry, which keeps it from being the default address.
+// feeRate is paid per byte of the sweep transaction. Immature coinbase
+// outputs stay behind; rotate the retired address again once they mature.
+// RotateTx returns the sweep transaction for broadcasting, Rotate only its ID
+func (ws *Wallets) Rotate(oldAddress string, feeRate int64, UTXOSet *UTXOSet) (string, []byte, error) {
+	newAddress, tx, err := ws.RotateTx(oldAddress, feeRate, UTXOSet)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return newAddress, tx.ID, nil
+}
+
+// RotateTx is Rotate returning the signed sweep transaction
+func (ws *Wallets) RotateTx(oldAddress string, feeRate int64, UTXOSet *UTXOSet) (string, *Transaction, error) {
+	oldAddress = CanonicalAddress(oldAddress)
+	old, err := ws.GetWallet(oldAddress)
+	if err != nil {
+		return "", nil, err
+	}
+	if feeRate < 0 {
+		return "", nil, errors.New("fee rate can't be negative")
+	}
+	pending, err := UTXOSet.PendingOutpoints(HashPubKey(old.PublicKey))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(pending) > 0 {
+		return "", nil, fmt.Errorf("address %s has %d outputs spent by unconfirmed transactions, wait for them to confirm", oldAddress, len(pending))
+	}
+	signer, err := old.Signer()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var inputs []TXInput
+	total := 0
+	immature := 0
+	unspent, err := UTXOSet.ListUnspent(HashPubKey(old.PublicKey), 1)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, out := range unspent {
+		if out.Reserved {
+			return "", nil, fmt.Errorf("output %s:%d of %s is spent by an unconfirmed transaction", out.TxID, out.Vout, oldAddress)
+		}
+		if out.Immature {
+			immature++
+			continue
+		}
+		txID, _ := hex.DecodeString(out.TxID)
+		inputs = append(inputs, TXInput{txID, out.Vout, nil, old.PublicKey})
+		total += out.Value
+	}
+	if len(inputs) == 0 {
+		if immature > 0 {
+			return "", nil, fmt.Errorf("all %d outputs of %s are immature coinbase outputs", immature, oldAddress)
+		}
+		return "", nil, fmt.Errorf("address %s has no funds to move", oldAddress)
+	}
+
+	fresh := NewWallet()
+	newAddress := fmt.Sprintf("%s", fresh.GetAddress())
+
+	var v = atomic.Value{}
+	v.Store(common.StorageSize(0))
+	tx := Transaction{nil, inputs, []TXOutput{*NewTXOutput(total, newAddress)}, time.Now().Unix(), v}
+	fee := feeRate * int64(len(tx.Serialize())+signatureLen*len(inputs))
+	if fee >= int64(total) {
+		return "", nil, fmt.Errorf("fee of %d exceeds the %d coins of %s", fee, total, oldAddress)
+	}
+	tx.Vout[0].Value = total - int(fee)
+	tx.ID = tx.Hash()
+	tx.SetSize(uint64(len(tx.Serialize())))
+	err = UTXOSet.Blockchain.SignTransaction(&tx, signer)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ws.mu.Lock()
+	stored, ok := ws.Wallets[oldAddress]
+	if !ok {
+		ws.mu.Unlock()
+		return "", nil, ErrWalletNotFound
+	}
+	fresh.Label = stored.Label
+	stored.Label = ""
+	stored.Retired = true
+	stored.RetiredBy = hex.EncodeToString(tx.ID)
+	ws.Wallets[newAddress] = fresh
+	if ws.Default == oldAddress {
+		ws.Default = newAddress
+	}
+	ws.mu.Unlock()
+
+	if ws.nodeID != "" {
+		if err := ws.SaveToFile(ws.nodeID); err != nil {
+			return newAddress, &tx, err
+		}
+	}
+
+	return newAddress, &tx, nil
+}
+
+// DeleteRetiredAfter schedules a retired key for deletion by PruneRetired
+// once its sweep transaction has the given number of confirmations. Zero
+// keeps the key
+func (ws *Wallets) DeleteRetiredAfter(address string, confirmations int) error {
+	if confirmations < 0 {
+		return errors.New("confirmations can't be negative")
+	}
+	address = CanonicalAddress(address)
+	ws.mu.Lock()
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		ws.mu.Unlock()
+		return ErrWalletNotFound
+	}
+	if !wallet.Retired {
+		ws.mu.Unlock()
+		return fmt.Errorf("address %s is not retired", address)
+	}
+	wallet.DeleteAfter = confirmations
+	ws.mu.Unlock()
+
+	if ws.nodeID != "" {
+		return ws.SaveToFile(ws.nodeID)
+	}
+
+	return nil
+}
+
+// PruneRetired deletes retired keys that are scheduled for deletion, whose
+// sweep transaction is deep enough and that hold no outputs any more,
+// returning the deleted addresses
+func (ws *Wallets) PruneRetired(UTXOSet *UTXOSet) []string {
+	candidates := make(map[string]Wallet)
+	ws.mu.RLock()
+	for address, wallet := range ws.Wallets {
+		if wallet.Retired && wallet.DeleteAfter > 0 && wallet.RetiredBy != "" {
+			candidates[address] = *wallet
+		}
+	}
+	ws.mu.RUnlock()
+
+	var pruned []string
+	for address, wallet := range candidates {
+		// kept when its outputs can't be read
+		if outs, err := UTXOSet.FindUTXO(HashPubKey(wallet.PublicKey)); err != nil || len(outs) > 0 {
+			continue
+		}
+		txID, _ := hex.DecodeString(wallet.RetiredBy)
+		confirmations, err := UTXOSet.Blockchain.TransactionConfirmations(txID)
+		if err != nil || confirmations < wallet.DeleteAfter {
+			continue
+		}
+		pruned = append(pruned, address)
+	}
+
+	ws.mu.Lock()
+	for _, address := range pruned {
+		delete(ws.Wallets, address)
+		delete(ws.balances, address)
+		if ws.Default == address {
+			ws.Default = ""
+		}
+	}
+	ws.mu.Unlock()
+
+	if len(pruned) > 0 && ws.nodeID != "" {
+		if err := ws.SaveToFile(ws.nodeID); err != nil {
+			log.Printf("saving the wallet after pruning retired keys: %v", err)
+		}
+	}
+
+	return pruned
+}
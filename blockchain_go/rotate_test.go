@@ -0,0 +1,62 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotate(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, funder := newTestWallets()
+		old := NewWallet()
+		old.Label = "savings"
+		oldAddress := fmt.Sprintf("%s", old.GetAddress())
+		ws.Wallets[oldAddress] = old
+		miner := NewWallet()
+		minerAddress := fmt.Sprintf("%s", miner.GetAddress())
+		ws.Wallets[minerAddress] = miner
+		ws.Default = oldAddress
+
+		bc := newTestChain(funder)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		tx, err := NewUTXOTransaction(ws.Wallets[funder], oldAddress, 30, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		UTXOSet.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(minerAddress, ""), tx}))
+
+		_, _, err = ws.Rotate(minerAddress, 0, &UTXOSet)
+		assert.NotNil(t, err, "Immature coinbase outputs can't be moved")
+		_, _, err = ws.Rotate(oldAddress, 1000, &UTXOSet)
+		assert.NotNil(t, err, "The fee can't eat the whole balance")
+		_, _, err = ws.Rotate(oldAddress, -1, &UTXOSet)
+		assert.NotNil(t, err)
+
+		newAddress, sweep, err := ws.RotateTx(oldAddress, 0, &UTXOSet)
+		assert.Nil(t, err)
+		valid, err := bc.VerifyTransaction(sweep)
+		assert.Nil(t, err)
+		assert.True(t, valid)
+		assert.Len(t, sweep.Vout, 1)
+		assert.Equal(t, 30, sweep.Vout[0].Value)
+		assert.True(t, sweep.Vout[0].IsLockedWithKey(HashPubKey(ws.Wallets[newAddress].PublicKey)))
+
+		assert.True(t, ws.Wallets[oldAddress].Retired)
+		assert.Equal(t, hex.EncodeToString(sweep.ID), ws.Wallets[oldAddress].RetiredBy)
+		assert.Equal(t, "savings", ws.Wallets[newAddress].Label)
+		assert.Equal(t, newAddress, ws.Default)
+		assert.NotNil(t, ws.SetDefault(oldAddress), "A retired address can't be the default")
+
+		assert.NotNil(t, ws.DeleteRetiredAfter(newAddress, 1))
+		assert.Nil(t, ws.DeleteRetiredAfter(oldAddress, 1))
+		assert.Empty(t, ws.PruneRetired(&UTXOSet), "The sweep is not mined yet")
+
+		UTXOSet.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(minerAddress, ""), sweep}))
+		assert.Equal(t, []string{oldAddress}, ws.PruneRetired(&UTXOSet))
+		assert.NotContains(t, ws.Wallets, oldAddress)
+	})
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// recoverBatchSize is the number of blocks recoverUTXOSet applies per bolt
+// transaction
+const recoverBatchSize = 100
+
+// Close shuts the chain down: it waits for the UTXO set write in flight,
+// rewrites the chainstate record if it isn't at the tip and closes the
+// database, which waits for the other bolt transactions in flight. Closing
+// a closed chain does nothing, a read-only one only closes the database
+func (bc *Blockchain) Close() error {
+	bc.utxoMu.Lock()
+	defer bc.utxoMu.Unlock()
+	if bc.Db.IsReadOnly() {
+		return bc.Db.Close()
+	}
+
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		tip := tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))
+		if tip == nil {
+			return nil
+		}
+		// an unreadable record is written again
+		if s, err := readChainState(tx); err == nil && s != nil && bytes.Equal(s.tip, tip) {
+			return nil
+		}
+		return putChainState(tx, append([]byte(nil), tip...))
+	})
+	if err == bolt.ErrDatabaseNotOpen {
+		return nil
+	}
+	if closeErr := bc.Db.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// recoverUTXOSet brings the UTXO set to the tip when the node went down
+// between storing a block and applying it: the blocks the set applied that
+// left the chain are undone with their undo records, then the blocks of the
+// chain after them applied. A set that can't be undone is rebuilt with
+// Reindex. A set written before the block it's at was recorded is left as
+// it is
+func (bc *Blockchain) recoverUTXOSet() error {
+	u := UTXOSet{Blockchain: bc}
+	at, err := u.BestBlock()
+	if err != nil || at == nil || bytes.Equal(at, bc.tip) {
+		return err
+	}
+	log.Printf("the UTXO set is at block %x, not at the tip %x, recovering", at, bc.tip)
+
+	fork, err := bc.GetBlock(at)
+	for err == nil && !bc.inChain(&fork) {
+		if err = u.Undo(&fork); err == nil {
+			fork, err = bc.GetBlock(fork.PrevBlockHash)
+		}
+	}
+	if err != nil {
+		log.Printf("undoing the UTXO set failed, reindexing: %v", err)
+		return u.ReindexContext(context.Background(), nil)
+	}
+
+	height, _, err := bc.GetBestHeightLastHash()
+	if err != nil || fork.Height.Cmp(height) >= 0 {
+		return err
+	}
+	var blocks []*Block
+	err = bc.IterateRange(context.Background(), fork.Height.Int64()+1, height.Int64(), func(block *Block) error {
+		blocks = append(blocks, block)
+		if len(blocks) < recoverBatchSize {
+			return nil
+		}
+		err := u.UpdateBlocks(blocks)
+		blocks = nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return u.UpdateBlocks(blocks)
+}
+
+// inChain reports whether a block is in the chain ending at the tip
+func (bc *Blockchain) inChain(block *Block) bool {
+	b, err := bc.GetBlockByHeight(int(block.Height.Int64()))
+
+	return err == nil && bytes.Equal(b.Hash, block.Hash)
+}
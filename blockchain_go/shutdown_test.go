@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClose(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address)
+		assert.Nil(t, bc.Db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket([]byte(chainStateBucket)) }))
+
+		assert.Nil(t, bc.Close())
+		assert.Nil(t, bc.Close(), "Closing twice does nothing")
+		_, _, err := bc.GetBestHeightLastHash()
+		assert.Equal(t, ErrDBClosed, err)
+
+		db, err := bolt.Open(genBlockChainDbName("test"), 0600, nil)
+		assert.Nil(t, err)
+		err = db.View(func(tx *bolt.Tx) error {
+			s, err := readChainState(tx)
+			assert.NotNil(t, s, "The record is written back")
+			return err
+		})
+		assert.Nil(t, err)
+		db.Close()
+	})
+}
+
+func TestRecoverUTXOSet(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		u := UTXOSet{Blockchain: bc}
+		u.Reindex()
+		blocks := syntheticBlocks(bc, 5)
+		for _, block := range blocks {
+			status, err := bc.AddBlock(block)
+			assert.Nil(t, err)
+			assert.Equal(t, BlockExtendsTip, status)
+		}
+		// down after storing the blocks, with the set at the 2nd and a
+		// block of another branch applied on it
+		u.UpdateBlocks(blocks[:2])
+		side := forkBlock(blocks[1], address)
+		status, err := bc.AddBlock(side)
+		assert.Nil(t, err)
+		assert.Equal(t, BlockSideChain, status)
+		u.Update(side)
+		bc.Db.Close()
+
+		bc, err = NewBlockchain("test")
+		assert.Nil(t, err)
+		defer bc.Close()
+		u = UTXOSet{Blockchain: bc}
+		assert.Equal(t, blocks[4].Hash, mustTip(u.BestBlock()))
+		recovered := utxoSnapshot(t, u)
+		u.Reindex()
+		assert.Equal(t, utxoSnapshot(t, u), recovered)
+	})
+}
+
+func TestOpenLocked(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		newTestChain(address).Close()
+		timeout := DBOpenTimeout
+		DBOpenTimeout = 50 * time.Millisecond
+		defer func() { DBOpenTimeout = timeout }()
+
+		// as another process would hold it, the last to open it writable
+		path := genBlockChainDbName("test")
+		db, err := bolt.Open(path, 0600, nil)
+		assert.Nil(t, err)
+		_, err = NewBlockchain("test")
+		assert.True(t, errors.Is(err, ErrDBLocked))
+		assert.Equal(t, &DBLockedError{File: path}, err, "This process isn't the holder")
+		assert.Nil(t, ioutil.WriteFile(dbHolderFile(path), []byte(strconv.Itoa(os.Getppid())), 0600))
+		_, err = NewBlockchainWithOptions(dir, Options{NodeID: "test", ReadOnly: true, Timeout: time.Millisecond})
+		assert.Equal(t, &DBLockedError{File: filepath.Join(dir, blockChainDbFile("test")), PID: os.Getppid()}, err)
+		assert.Contains(t, err.Error(), fmt.Sprintf("PID %d", os.Getppid()))
+		db.Close()
+
+		// this process waits for its own
+		bc, err := NewBlockchain("test")
+		assert.Nil(t, err)
+		opened := make(chan error)
+		go func() {
+			other, err := NewBlockchain("test")
+			if err == nil {
+				other.Close()
+			}
+			opened <- err
+		}()
+		time.Sleep(2 * DBOpenTimeout)
+		assert.Nil(t, bc.Close())
+		assert.Nil(t, <-opened)
+	})
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address)
+		UTXOSet{Blockchain: bc}.Reindex()
+		height, tip, err := bc.GetBestHeightLastHash()
+		assert.Nil(t, err)
+		assert.Nil(t, bc.Close())
+
+		// read-only opens share the database
+		first, err := NewBlockchainWithOptions("", Options{NodeID: "test", ReadOnly: true})
+		assert.Nil(t, err)
+		second, err := NewBlockchainWithOptions(dir, Options{NodeID: "test", ReadOnly: true})
+		assert.Nil(t, err)
+		readHeight, readTip, err := second.GetBestHeightLastHash()
+		assert.Nil(t, err)
+		assert.Equal(t, height, readHeight)
+		assert.Equal(t, tip, readTip)
+		info, err := first.ChainInfo()
+		assert.Nil(t, err)
+		assert.Equal(t, genBlockChainDbName("test"), info.Path)
+		_, err = first.MineBlock([]*Transaction{NewCoinbaseTX(address, "")})
+		assert.Equal(t, bolt.ErrDatabaseReadOnly, err)
+		assert.Nil(t, first.Close())
+		assert.Nil(t, second.Close())
+
+		withParams(func(params *NetParams) { params.Genesis.Message = "another chain" }, func() {
+			_, err := NewBlockchainWithOptions("", Options{NodeID: "test", ReadOnly: true})
+			assert.Equal(t, ErrGenesisMismatch, err)
+		})
+
+		// a reorganisation to finish takes a writable open
+		db, err := bolt.Open(genBlockChainDbName("test"), 0600, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(reorgBucket))
+			if err != nil {
+				return err
+			}
+			return b.Put(reorgTargetKey, tip)
+		}))
+		db.Close()
+		_, err = NewBlockchainWithOptions("", Options{NodeID: "test", ReadOnly: true})
+		assert.Equal(t, ErrDBNeedsWrite, err)
+		_, err = NewBlockchainWithOptions("", Options{NodeID: "missing", ReadOnly: true})
+		assert.Equal(t, ErrNoBlockchain, err)
+	})
+}
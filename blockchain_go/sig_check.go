@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// SigCheckWorkers is the number of goroutines checking the input signatures
+// of a block, GOMAXPROCS for 0 or less. 1 checks them one after the other,
+// for debugging
+var SigCheckWorkers = 0
+
+// sigCheck is the signature check of input in of tx, spending an output
+// locked with pubKeyHash
+type sigCheck struct {
+	tx         *Transaction
+	in         int
+	pubKeyHash []byte
+}
+
+func (c sigCheck) verify() bool {
+	txCopy := c.tx.TrimmedCopy()
+
+	return c.tx.verifyInput(&txCopy, c.in, c.pubKeyHash)
+}
+
+// runSigChecks runs checks on SigCheckWorkers goroutines and returns the
+// error of the first failing one in the order of checks, so the error
+// doesn't depend on the scheduling. Once a check fails those after it are
+// skipped
+func runSigChecks(checks []sigCheck) error {
+	workers := SigCheckWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(checks) {
+		workers = len(checks)
+	}
+
+	failed := int64(len(checks)) // the index of the first failing check
+	if workers <= 1 {
+		for i, c := range checks {
+			if !c.verify() {
+				failed = int64(i)
+				break
+			}
+		}
+	} else {
+		next := int64(-1)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					// checks are taken in order, those after a failure can go
+					i := atomic.AddInt64(&next, 1)
+					if i >= int64(len(checks)) || i > atomic.LoadInt64(&failed) {
+						return
+					}
+					if checks[i].verify() {
+						continue
+					}
+					for {
+						first := atomic.LoadInt64(&failed)
+						if i >= first || atomic.CompareAndSwapInt64(&failed, first, i) {
+							break
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	if failed < int64(len(checks)) {
+		c := checks[failed]
+		return fmt.Errorf("transaction %x: invalid signature of input %d", c.tx.ID, c.in)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signedBlock returns a block of a coinbase with txs*inputs outputs to a new
+// wallet, followed by txs transactions spending inputs of them each
+func signedBlock(t testing.TB, txs, inputs int) *Block {
+	wallet := NewWallet()
+	signer, err := wallet.Signer()
+	assert.Nil(t, err)
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+
+	coinbase := NewCoinbaseTX(fmt.Sprintf("%s", wallet.GetAddress()), "")
+	coinbase.Vout = nil
+	for i := 0; i < txs*inputs; i++ {
+		coinbase.Vout = append(coinbase.Vout, TXOutput{1, pubKeyHash})
+	}
+	coinbase.ID = coinbase.Hash()
+	prevTXs := map[string]Transaction{hex.EncodeToString(coinbase.ID): *coinbase}
+
+	block := &Block{Transactions: []*Transaction{coinbase}}
+	for i := 0; i < txs; i++ {
+		tx := &Transaction{Vout: []TXOutput{{inputs, pubKeyHash}}}
+		for in := 0; in < inputs; in++ {
+			tx.Vin = append(tx.Vin, TXInput{coinbase.ID, i*inputs + in, nil, wallet.PublicKey})
+		}
+		tx.ID = tx.Hash()
+		assert.Nil(t, tx.Sign(signer, prevTXs))
+		block.Transactions = append(block.Transactions, tx)
+	}
+
+	return block
+}
+
+func TestVerifyBlockSignatures(t *testing.T) {
+	defer func(workers int) { SigCheckWorkers = workers }(SigCheckWorkers)
+	block := signedBlock(t, 6, 5)
+	bc := &Blockchain{}
+
+	for _, workers := range []int{1, 4} {
+		SigCheckWorkers = workers
+		assert.Nil(t, bc.verifyBlockSignatures(block), "%d workers", workers)
+	}
+
+	// of two bad signatures the first in the block is reported
+	for _, bad := range []struct{ tx, in int }{{5, 4}, {2, 1}} {
+		sig := block.Transactions[bad.tx].Vin[bad.in].Signature
+		sig[0] ^= 0xff
+	}
+	for _, workers := range []int{1, 4} {
+		SigCheckWorkers = workers
+		for i := 0; i < 10; i++ {
+			err := bc.verifyBlockSignatures(block)
+			assert.Equal(t, fmt.Sprintf("transaction %x: invalid signature of input 1", block.Transactions[2].ID), fmt.Sprint(err), "%d workers", workers)
+		}
+	}
+}
+
+func BenchmarkVerifyBlockSignatures(b *testing.B) {
+	defer func(workers int) { SigCheckWorkers = workers }(SigCheckWorkers)
+	block := signedBlock(b, 20, 100) // 2000 inputs
+	bc := &Blockchain{}
+
+	for workers := 1; ; workers *= 2 {
+		if workers > runtime.GOMAXPROCS(0) {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			SigCheckWorkers = workers
+			for i := 0; i < b.N; i++ {
+				if err := bc.verifyBlockSignatures(block); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		if workers == runtime.GOMAXPROCS(0) {
+			break
+		}
+	}
+}
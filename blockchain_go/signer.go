@@ -0,0 +1,124 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Signer signs transaction inputs on behalf of a wallet address. Keys held
+// outside the process (hardware wallets, HSMs, remote signers) implement it
+// without ever exposing the private key
+type Signer interface {
+	// PublicKey returns the uncompressed public key as X||Y
+	PublicKey() []byte
+	// Sign returns the r||s signature of digest
+	Sign(digest []byte) ([]byte, error)
+}
+
+// LocalSigner signs with an in-memory private key
+type LocalSigner struct {
+	key ecdsa.PrivateKey
+}
+
+// NewLocalSigner wraps a private key into a Signer
+func NewLocalSigner(key ecdsa.PrivateKey) *LocalSigner {
+	return &LocalSigner{key: key}
+}
+
+// PublicKey returns the public key of the wrapped private key
+func (s *LocalSigner) PublicKey() []byte {
+	return append(s.key.PublicKey.X.Bytes(), s.key.PublicKey.Y.Bytes()...)
+}
+
+// Sign signs digest with the wrapped private key
+func (s *LocalSigner) Sign(digest []byte) ([]byte, error) {
+	r, ss, err := ecdsa.Sign(rand.Reader, &s.key, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// r and s take half each, Verify splits the signature in the middle
+	size := (s.key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	ss.FillBytes(signature[size:])
+
+	return signature, nil
+}
+
+// SignerFactory opens the external signer behind uri. publicKey is the key
+// recorded in the wallet, nil when the entry is being created
+type SignerFactory func(uri string, publicKey []byte) (Signer, error)
+
+var (
+	signerFactories   = make(map[string]SignerFactory)
+	signerFactoriesMu sync.RWMutex
+)
+
+// RegisterSigner makes a Signer implementation available for URIs with the
+// given scheme, e.g. "clef" for "clef://..."
+func RegisterSigner(scheme string, factory SignerFactory) {
+	signerFactoriesMu.Lock()
+	defer signerFactoriesMu.Unlock()
+
+	signerFactories[scheme] = factory
+}
+
+// OpenSigner opens an external signer through the implementation registered
+// for the scheme of uri
+func OpenSigner(uri string, publicKey []byte) (Signer, error) {
+	i := strings.Index(uri, "://")
+	if i <= 0 {
+		return nil, fmt.Errorf("signer URI %q has no scheme", uri)
+	}
+	scheme := uri[:i]
+
+	signerFactoriesMu.RLock()
+	factory, ok := signerFactories[scheme]
+	signerFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no signer registered for scheme %q", scheme)
+	}
+
+	return factory(uri, publicKey)
+}
+
+// Signer returns the Signer of the wallet: the external signer its
+// SignerURI references, or its own private key
+func (w Wallet) Signer() (Signer, error) {
+	if w.SignerURI != "" {
+		return OpenSigner(w.SignerURI, w.PublicKey)
+	}
+	if w.PrivateKey.D == nil {
+		return nil, errors.New("wallet has neither a private key nor an external signer")
+	}
+
+	return NewLocalSigner(w.PrivateKey), nil
+}
+
+// AddExternalSigner adds a wallet entry whose key is held by the external
+// signer behind uri, returning its address
+func (ws *Wallets) AddExternalSigner(uri string) (string, error) {
+	signer, err := OpenSigner(uri, nil)
+	if err != nil {
+		return "", err
+	}
+	pubKey := signer.PublicKey()
+	if len(pubKey) == 0 {
+		return "", fmt.Errorf("signer %s returned no public key", uri)
+	}
+
+	wallet := newWatchWallet(pubKey)
+	wallet.SignerURI = uri
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	ws.mu.Lock()
+	ws.Wallets[address] = wallet
+	ws.balances = nil
+	ws.mu.Unlock()
+
+	return address, nil
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSigner is an external signer that holds its key outside the wallet
+type testSigner struct {
+	local *LocalSigner
+	err   error
+}
+
+func (s *testSigner) PublicKey() []byte { return s.local.PublicKey() }
+
+func (s *testSigner) Sign(digest []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.local.Sign(digest)
+}
+
+func TestLocalSignerMatchesVerify(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		wallet, err := ws.GetWallet(address)
+		assert.Nil(t, err)
+		tx, err := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 10, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		valid, err := bc.VerifyTransaction(tx)
+		assert.Nil(t, err)
+		assert.True(t, valid)
+
+		// r or s is shorter about once in 128 signatures
+		signer, err := wallet.Signer()
+		assert.Nil(t, err)
+		for i := 0; i < 500; i++ {
+			signature, err := signer.Sign([]byte{byte(i), byte(i >> 8)})
+			assert.Nil(t, err)
+			assert.Len(t, signature, 64)
+		}
+
+		_, err = NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), subsidy+1, &UTXOSet, nil, 1)
+		assert.Equal(t, ErrNotEnoughFunds, err)
+	})
+}
+
+func TestExternalSigner(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		key := NewWallet()
+		signer := &testSigner{local: NewLocalSigner(key.PrivateKey)}
+		RegisterSigner("test", func(uri string, publicKey []byte) (Signer, error) {
+			return signer, nil
+		})
+
+		ws := &Wallets{Wallets: make(map[string]*Wallet)}
+		address, err := ws.AddExternalSigner("test://device/0")
+		assert.Nil(t, err)
+		assert.Equal(t, string(key.GetAddress()), address)
+		assert.Nil(t, ws.Wallets[address].PrivateKey.D, "The key stays in the signer")
+
+		_, err = ws.AddExternalSigner("nosuchscheme://x")
+		assert.NotNil(t, err)
+
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		wallet, err := ws.GetWallet(address)
+		assert.Nil(t, err)
+		tx, err := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 10, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		valid, err := bc.VerifyTransaction(tx)
+		assert.Nil(t, err)
+		assert.True(t, valid)
+
+		signer.err = errors.New("device unplugged")
+		assert.NotPanics(t, func() {
+			tx, err = NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 10, &UTXOSet, nil, 1)
+		})
+		assert.Nil(t, tx)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "device unplugged")
+	})
+}
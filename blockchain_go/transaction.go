@@ -9,14 +9,13 @@ import (
 	"strings"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/common"
 	"sync/atomic"
-	"github.com/ethereum/go-ethereum/rlp"
-	"os"
 	."../boltqueue"
 )
 
@@ -32,12 +31,6 @@ type Transaction struct {
 }
 // Transactions is a Transaction slice type for basic sorting.
 type Transactions []*Transaction
-type writeCounter common.StorageSize
-
-func (c *writeCounter) Write(b []byte) (int, error) {
-	*c += writeCounter(len(b))
-	return len(b), nil
-}
 
 // IsCoinbase checks whether the transaction is coinbase
 func (tx Transaction) IsCoinbase() bool {
@@ -69,15 +62,28 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
+// computeID returns the ID tx must carry, its Hash before it was signed:
+// with the signatures of its inputs cleared
+func (tx *Transaction) computeID() []byte {
+	unsigned := *tx
+	unsigned.Vin = make([]TXInput, len(tx.Vin))
+	for i, vin := range tx.Vin {
+		vin.Signature = nil
+		unsigned.Vin[i] = vin
+	}
+
+	return unsigned.Hash()
+}
+
 // Sign signs each input of a Transaction
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx *Transaction) Sign(signer Signer, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
 	for _, vin := range tx.Vin {
 		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+			return errors.New("previous transaction is not correct")
 		}
 	}
 
@@ -90,15 +96,16 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 
 		dataToSign := fmt.Sprintf("%x\n", txCopy)
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		signature, err := signer.Sign([]byte(dataToSign))
 		if err != nil {
-			log.Panic(err)
+			return fmt.Errorf("signing input %d: %v", inID, err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 
 		tx.Vin[inID].Signature = signature
 		txCopy.Vin[inID].PubKey = nil
 	}
+
+	return nil
 }
 
 // String returns a human-readable representation of a transaction
@@ -160,39 +167,52 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	}
 
 	txCopy := tx.TrimmedCopy()
-	curve := crypto.S256()
-
 	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		if !tx.verifyInput(&txCopy, inID, prevTx.Vout[vin.Vout].PubKeyHash) {
 			return false
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true
 }
 
+// verifyInput checks the signature of input inID against pubKeyHash, the
+// key hash of the output it spends. txCopy is a TrimmedCopy of tx, left as
+// it was
+func (tx *Transaction) verifyInput(txCopy *Transaction, inID int, pubKeyHash []byte) bool {
+	vin := tx.Vin[inID]
+	txCopy.Vin[inID].Signature = nil
+	txCopy.Vin[inID].PubKey = pubKeyHash
+	defer func() { txCopy.Vin[inID].PubKey = nil }()
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(vin.Signature)
+	r.SetBytes(vin.Signature[:(sigLen / 2)])
+	s.SetBytes(vin.Signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(vin.PubKey)
+	x.SetBytes(vin.PubKey[:(keyLen / 2)])
+	y.SetBytes(vin.PubKey[(keyLen / 2):])
+
+	dataToVerify := fmt.Sprintf("%x\n", *txCopy)
+
+	rawPubKey := ecdsa.PublicKey{Curve: crypto.S256(), X: &x, Y: &y}
+
+	return ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s)
+}
+
 // NewCoinbaseTX creates a new coinbase transaction
 func NewCoinbaseTX(to, data string) *Transaction {
+	return NewCoinbaseTXValue(to, data, ActiveNetParams.Genesis.Subsidy)
+}
+
+// NewCoinbaseTXValue creates a coinbase transaction paying value to to, the
+// subsidy and the fees of its block, see BlockTransactions
+func NewCoinbaseTXValue(to, data string, value int) *Transaction {
 	if data == "" {
 		randData := make([]byte, subsidy)
 		_, err := rand.Read(randData)
@@ -204,7 +224,7 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	}
 
 	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
+	txout := NewTXOutput(value, to)
 	var v = atomic.Value{}
 	v.Store(common.StorageSize(0))
 	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}, time.Now().Unix(),v}
@@ -214,53 +234,92 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	return &tx
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+// ErrNotEnoughFunds is returned when the spendable outputs of an address
+// don't cover the amount to send
+var ErrNotEnoughFunds = errors.New("not enough funds")
 
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount,false,nil)
+// NewUTXOTransaction creates a new transaction signed by the Signer of
+// wallet, paying the fee rate EstimateFee gives for DefaultFeeTarget blocks,
+// see NewUTXOTransactionFee
+func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet, exclude OutpointSet, minConf int) (*Transaction, error) {
+	feeRate, err := UTXOSet.Blockchain.EstimateFee(DefaultFeeTarget)
+	if err != nil {
+		return nil, err
+	}
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+	return NewUTXOTransactionFee(wallet, to, amount, UTXOSet, exclude, minConf, feeRate)
+}
+
+// NewUTXOTransactionFee creates a new transaction signed by the Signer of
+// wallet, paying feeRate per 1000 bytes on top of amount. It spends outputs with
+// at least minConf confirmations, except those in exclude and those locked
+// with LockOutpoint, see FindSpendableOutputs
+func NewUTXOTransactionFee(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet, exclude OutpointSet, minConf int, feeRate int64) (*Transaction, error) {
+	if feeRate < 0 {
+		return nil, errors.New("fee rate can't be negative")
 	}
+	signer, err := wallet.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	from := fmt.Sprintf("%s", wallet.GetAddress())
+	var v = atomic.Value{}
+	v.Store(common.StorageSize(0))
+	var tx Transaction
 
-	// Build a list of inputs
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
+	// the fee grows with the inputs spent to pay it
+	fee := 0
+	for {
+		acc, validOutputs, err := UTXOSet.FindSpendableOutputs(pubKeyHash, amount+fee, exclude, minConf, false)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
-		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
-			inputs = append(inputs, input)
+		if acc < amount+fee {
+			return nil, ErrNotEnoughFunds
 		}
-	}
 
-	// Build a list of outputs
-	from := fmt.Sprintf("%s", wallet.GetAddress())
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
-	}
+		// Build a list of inputs
+		var inputs []TXInput
+		for txid, outs := range validOutputs {
+			txID, err := hex.DecodeString(txid)
+			if err != nil {
+				log.Panic(err)
+			}
+			for _, out := range outs {
+				input := TXInput{txID, out, nil, wallet.PublicKey}
+				inputs = append(inputs, input)
+			}
+		}
 
-	var v = atomic.Value{}
-	v.Store(common.StorageSize(0))
-	tx := Transaction{nil, inputs, outputs, time.Now().Unix(),v}
+		// Build a list of outputs
+		outputs := []TXOutput{*NewTXOutput(amount, to)}
+		if acc > amount+fee {
+			outputs = append(outputs, *NewTXOutput(acc-amount-fee, from)) // a change
+		}
+
+		tx = Transaction{nil, inputs, outputs, time.Now().Unix(), v}
+		size := int64(len(tx.Serialize()) + signatureLen*len(inputs))
+		needed := int((feeRate*size + 999) / 1000)
+		if needed <= fee {
+			break
+		}
+		fee = needed
+	}
 	tx.ID = tx.Hash()
 	tx.SetSize(uint64(len(tx.Serialize())))
-	UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+	err = UTXOSet.Blockchain.SignTransaction(&tx, signer)
+	if err != nil {
+		return nil, err
+	}
 
-	return &tx
+	return &tx, nil
 }
 
-// DeserializeTransaction deserializes a transaction
+// DeserializeTransaction deserializes a transaction, see DecodeTransaction
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := DecodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -268,6 +327,16 @@ func DeserializeTransaction(data []byte) Transaction {
 	return transaction
 }
 
+// DecodeTransaction decodes a transaction, received from a peer for one
+func DecodeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&transaction)
+
+	return transaction, err
+}
+
 func VeryfyFromToAddress(tx *Transaction) bool{
 	if tx.IsCoinbase() {
 		return true
@@ -301,16 +370,13 @@ func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
 }
 */
 
-// Size returns the true RLP encoded storage size of the transaction, either by
-// encoding and returning it, or returning a previsouly cached value.
+// Size returns the size of the serialized transaction, as sent on the wire,
+// either by serializing it, or returning a previsouly cached value.
 func (tx *Transaction) Size() common.StorageSize {
 	if size := tx.size.Load(); size != nil && size != common.StorageSize(0) {
 		return size.(common.StorageSize)
 	}
-	c := writeCounter(0)
-	rlp.Encode(&c, &tx)
-	tx.size.Store(common.StorageSize(c))
-	return common.StorageSize(c)
+	return tx.SetSize(uint64(len(tx.Serialize())))
 }
 
 func (tx *Transaction) SetSize(c uint64) common.StorageSize {
@@ -318,49 +384,55 @@ func (tx *Transaction) SetSize(c uint64) common.StorageSize {
 	return  common.StorageSize(c)
 }
 
+// PendingIn records the outputs spent by tx in the pending queue of
+// wallet, which keeps them out of PendingOutpoints-based coin selection
+// until tx is mined
 func PendingIn(wallet Wallet,tx *Transaction){
-	queueFile := fmt.Sprintf("%x_tx.db", wallet.GetAddress())
+	queueFile := PendingQueueFile(wallet.GetAddress())
 	txPQueue, err := NewPQueue(queueFile)
 	if err != nil {
 		log.Panic("create queue error",err)
 	}
-	//defer txPQueue.Close()
-	defer os.Remove(queueFile)
-	eqerr := txPQueue.Enqueue(1, NewMessageBytes(tx.Vin[0].Txid))
-	if err != nil {
-		log.Panic("Enqueue error",eqerr)
+	defer txPQueue.Close()
+
+	queued := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		// priority 1 keeps the spent transaction IDs, priority 3 the outputs
+		if !queued[hex.EncodeToString(vin.Txid)] {
+			queued[hex.EncodeToString(vin.Txid)] = true
+			err = txPQueue.Enqueue(1, NewMessageBytes(vin.Txid))
+			if err != nil {
+				log.Panic("Enqueue error",err)
+			}
+		}
+		err = txPQueue.Enqueue(pendingOutpointPriority, NewMessageBytes(serializeOutpoint(vin.Txid, vin.Vout)))
+		if err != nil {
+			log.Panic("Enqueue error",err)
+		}
 	}
-	txPQueue.Close()
 }
 
-func VerifyTx(tx Transaction,bc *Blockchain)bool{
-	if &tx == nil {
-		fmt.Println("transaction %x is nil:",&tx.ID)
-		return false
+// VerifyTx checks the signatures, the spent outputs and amounts, and the
+// addresses of a transaction before it's mined. The error tells why it's
+// invalid
+func VerifyTx(tx Transaction,bc *Blockchain) error{
+	// the spent outputs first, VerifyTransaction can't check unknown ones
+	UTXOSet := UTXOSet{Blockchain: bc}
+	if !tx.IsCoinbase() {
+		if _, err := UTXOSet.IsUTXOAmountValid(&tx); err != nil {
+			return fmt.Errorf("transaction %x: %v", tx.ID, err)
+		}
 	}
-	// ignore transaction if it's not valid
-	// 1 have received longest chain among knownodes(full nodes)
-	// 2 transaction have valid sign accounding to owner's pubkey by VerifyTransaction()
-	// 3 utxo amount >= transaction output amount
-	// 4 transaction from address not equal to address
-	var valid1 = true
-	var valid2 = true
-	var valid3 = true
-	if !bc.VerifyTransaction(&tx) {
-		//log.Panic("ERROR: Invalid transaction:sign")
-		valid1 = false
+	valid, err := bc.VerifyTransaction(&tx)
+	if err != nil {
+		return fmt.Errorf("transaction %x: %v", tx.ID, err)
 	}
-	UTXOSet := UTXOSet{bc}
-	if(tx.IsCoinbase()==false&&!UTXOSet.IsUTXOAmountValid(&tx)){
-		//log.Panic("ERROR: Invalid transaction:amount")
-		valid2 = false
+	if !valid {
+		return fmt.Errorf("transaction %x: invalid signature", tx.ID)
 	}
-	valid3 = VeryfyFromToAddress(&tx)
-	fmt.Printf("valid3  %s\n", valid3)
-
-	if(valid1 && valid2 && valid3){
-		return true
-	}else{
-		return false
+	if !VeryfyFromToAddress(&tx) {
+		return fmt.Errorf("transaction %x: sends to its own input key", tx.ID)
 	}
-}
\ No newline at end of file
+
+	return nil
+}
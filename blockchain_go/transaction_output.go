@@ -14,17 +14,26 @@ type TXOutput struct {
 
 // Lock signs the output
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	pubKeyHash, err := GetPubKeyHashFromAddress(string(address))
+	if err != nil {
+		log.Panic(err)
+	}
 	out.PubKeyHash = pubKeyHash
 }
 
+// isSpent reports whether out is the placeholder the UTXO set keeps for a
+// spent output, so the indexes of the remaining outputs don't shift
+func (out *TXOutput) isSpent() bool {
+	return out.PubKeyHash == nil
+}
+
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
 	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
 }
 
-// NewTXOutput create a new TXOutput
+// NewTXOutput create a new TXOutput. It panics on an address CheckAddress
+// refuses
 func NewTXOutput(value int, address string) *TXOutput {
 	txo := &TXOutput{value, nil}
 	txo.Lock([]byte(address))
@@ -32,9 +41,28 @@ func NewTXOutput(value int, address string) *TXOutput {
 	return txo
 }
 
-// TXOutputs collects TXOutput
+// TXOutputs collects TXOutput. In the UTXO set spent outputs are empty
+// placeholders, so Outputs[i] is output i of the transaction
 type TXOutputs struct {
 	Outputs []TXOutput
+	// Height of the block holding the transaction and whether it is a
+	// coinbase, in the UTXO set. Both are zero for entries written before
+	// they were stored, see heightKnown
+	Height   int64
+	Coinbase bool
+}
+
+// heightKnown reports whether Height is set. Only a coinbase is at height
+// 0, the genesis block holding nothing else
+func (outs TXOutputs) heightKnown() bool {
+	return outs.Height > 0 || outs.Coinbase
+}
+
+// clone returns a copy of outs the caller may modify
+func (outs TXOutputs) clone() TXOutputs {
+	outs.Outputs = append([]TXOutput(nil), outs.Outputs...)
+
+	return outs
 }
 
 // Serialize serializes TXOutputs
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// txIndexBucket maps the ID of each transaction of the chain to the hash of
+// its block and its position in it. It is optional: once it exists,
+// indexHeights keeps it in step with the tip
+const txIndexBucket = "txindex"
+
+// TxIndexEnabled creates the transaction index with the chains created or
+// opened, backfilling it from the chain if needed
+var TxIndexEnabled = false
+
+// ErrTxNotFound is returned for a transaction the chain doesn't hold
+var ErrTxNotFound = errors.New("Transaction is not found")
+
+func txIndexValue(blockHash []byte, index int) []byte {
+	value := make([]byte, len(blockHash)+4)
+	copy(value, blockHash)
+	binary.BigEndian.PutUint32(value[len(blockHash):], uint32(index))
+
+	return value
+}
+
+// indexTransactions adds the transactions of a block of the chain to the
+// index, if there is one
+func indexTransactions(tx *bolt.Tx, block *Block) error {
+	index := tx.Bucket([]byte(txIndexBucket))
+	if index == nil {
+		return nil
+	}
+	for i, t := range block.Transactions {
+		if err := index.Put(t.ID, txIndexValue(block.Hash, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions removes the transactions of a block leaving the chain
+// from the index, if there is one. An entry a later block rewrote stays, and
+// so does a block deleted already, see DelBlockHashes
+func unindexTransactions(tx *bolt.Tx, blockHash []byte) error {
+	index := tx.Bucket([]byte(txIndexBucket))
+	if index == nil {
+		return nil
+	}
+	data := tx.Bucket([]byte(blocksBucket)).Get(blockHash)
+	if data == nil {
+		return nil
+	}
+	for i, t := range DeserializeBlock(data).Transactions {
+		value := index.Get(t.ID)
+		if bytes.Equal(value, txIndexValue(blockHash, i)) {
+			if err := index.Delete(t.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ensureTxIndex creates the transaction index of a database without one and
+// fills it from the height index
+func ensureTxIndex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(txIndexBucket)) != nil {
+			return nil
+		}
+		if _, err := tx.CreateBucket([]byte(txIndexBucket)); err != nil {
+			return err
+		}
+		heights := tx.Bucket([]byte(heightBucket))
+		if heights == nil {
+			return nil
+		}
+		blocks := tx.Bucket([]byte(blocksBucket))
+		return heights.ForEach(func(k, hash []byte) error {
+			data := blocks.Get(hash)
+			if data == nil {
+				return fmt.Errorf("block %x is not found", hash)
+			}
+			return indexTransactions(tx, DeserializeBlock(data))
+		})
+	})
+}
+
+// HasTxIndex reports whether the chain has a transaction index
+func (bc *Blockchain) HasTxIndex() bool {
+	has := false
+	bc.Db.View(func(tx *bolt.Tx) error {
+		has = tx.Bucket([]byte(txIndexBucket)) != nil
+		return nil
+	})
+
+	return has
+}
+
+// FindTransactionBlock returns a transaction of the chain and the block
+// holding it, looked up in the transaction index when there is one and
+// walking the chain from the tip otherwise
+func (bc *Blockchain) FindTransactionBlock(ID []byte) (*Transaction, *Block, error) {
+	var tx *Transaction
+	var block *Block
+	indexed := false
+	err := bc.Db.View(func(dbTx *bolt.Tx) error {
+		index := dbTx.Bucket([]byte(txIndexBucket))
+		if index == nil {
+			return nil
+		}
+		indexed = true
+		value := index.Get(ID)
+		if len(value) < 4 {
+			return ErrTxNotFound
+		}
+		hash := value[:len(value)-4]
+		i := int(binary.BigEndian.Uint32(value[len(value)-4:]))
+		data := dbTx.Bucket([]byte(blocksBucket)).Get(hash)
+		if data == nil {
+			if bodyPruned(dbTx, hash) {
+				return ErrBlockPruned
+			}
+			return fmt.Errorf("block %x is not found", hash)
+		}
+		block = DeserializeBlock(data)
+		if i >= len(block.Transactions) {
+			return fmt.Errorf("block %x holds no transaction %d", hash, i)
+		}
+		tx = block.Transactions[i]
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if indexed {
+		return tx, block, nil
+	}
+
+	err = bc.ForEachBlock(func(b *Block) error {
+		for _, t := range b.Transactions {
+			if bytes.Equal(t.ID, ID) {
+				tx, block = t, b
+				return StopIteration
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if tx == nil {
+		return nil, nil, ErrTxNotFound
+	}
+
+	return tx, block, nil
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxIndex(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		defer func() { TxIndexEnabled = false }()
+		TxIndexEnabled = true
+		_, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		assert.True(t, bc.HasTxIndex())
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		tip, _ := bc.GetBlock(bc.tip)
+
+		tx, block, err := bc.FindTransactionBlock(tip.Transactions[0].ID)
+		assert.Nil(t, err)
+		assert.Equal(t, tip.Hash, block.Hash)
+		assert.Equal(t, tip.Transactions[0].ID, tx.ID)
+		confirmations, err := bc.TransactionConfirmations(genesis.Transactions[0].ID)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, confirmations)
+
+		// a reorganisation moves the index to the new branch
+		side := minedBlock(&genesis, address)
+		longer := minedBlock(side, address)
+		for _, b := range []*Block{side, longer} {
+			_, err := bc.ProcessBlock(b)
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, longer.Hash, bc.tip)
+		_, _, err = bc.FindTransactionBlock(tip.Transactions[0].ID)
+		assert.Equal(t, ErrTxNotFound, err, "The disconnected block left the index")
+		_, block, err = bc.FindTransactionBlock(side.Transactions[0].ID)
+		assert.Nil(t, err)
+		assert.Equal(t, side.Hash, block.Hash)
+		_, err = bc.FindTransaction(longer.Transactions[0].ID)
+		assert.Nil(t, err)
+	})
+}
+
+func TestTxIndexBackfill(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer bc.Db.Close()
+		assert.False(t, bc.HasTxIndex())
+
+		var ids [][]byte
+		walked := make(map[string][]byte)
+		bc.ForEachBlock(func(block *Block) error {
+			for _, tx := range block.Transactions {
+				ids = append(ids, tx.ID)
+				walked[string(tx.ID)] = block.Hash
+			}
+			return nil
+		})
+
+		assert.Nil(t, ensureTxIndex(bc.Db))
+		assert.True(t, bc.HasTxIndex())
+		for _, id := range ids {
+			_, block, err := bc.FindTransactionBlock(id)
+			assert.Nil(t, err)
+			assert.Equal(t, walked[string(id)], block.Hash, "The index agrees with the walk")
+		}
+	})
+}
+
+func BenchmarkFindTransaction(b *testing.B) {
+	inTempDir(b, func(dir string) {
+		bc := mustCreate(fmt.Sprintf("%s", NewWallet().GetAddress()), "bench")
+		defer bc.Db.Close()
+		UTXOSet{Blockchain: bc}.Reindex()
+		blocks := syntheticBlocks(bc, 50000)
+		importer := NewBlockImporter(bc, 1000)
+		for _, block := range blocks {
+			if err := importer.Add(block); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := importer.Flush(); err != nil {
+			b.Fatal(err)
+		}
+		// the oldest transaction after the genesis block, the longest walk
+		id := blocks[0].Transactions[1].ID
+
+		b.Run("walk", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := bc.FindTransactionBlock(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		if err := ensureTxIndex(bc.Db); err != nil {
+			b.Fatal(err)
+		}
+		b.Run("txindex", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := bc.FindTransactionBlock(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
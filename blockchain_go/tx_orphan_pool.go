@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxTxOrphans, DefaultTxOrphanAge and DefaultMaxTxOrphansPerPeer
+// bound the transactions a TxOrphanPool holds, MaxOrphanTxSize the size of
+// each
+var (
+	DefaultMaxTxOrphans        = 100
+	DefaultTxOrphanAge         = time.Hour
+	DefaultMaxTxOrphansPerPeer = 10
+	MaxOrphanTxSize            = 100000
+)
+
+// ErrTxOrphanQuota is returned by TxOrphanPool.Add for an orphan of a peer
+// holding its share of the pool already
+var ErrTxOrphanQuota = errors.New("the peer holds its share of the orphan transactions")
+
+// TxOrphanStats are the counters of a TxOrphanPool
+type TxOrphanStats struct {
+	Held     int    `json:"held"`
+	Accepted uint64 `json:"accepted"` // orphans accepted once their parents were
+	Evicted  uint64 `json:"evicted"`  // orphans dropped for the size or age cap
+}
+
+// TxOrphanPool holds transactions that arrived before the transactions
+// whose outputs they spend, keyed by those missing parents, until a parent
+// is accepted and they can be checked again. Past its capacity random
+// orphans are evicted, so a peer can't tell which it pushes out, and the
+// orphans older than the age cap whenever one is added. Each peer holds
+// a share of the pool at most
+type TxOrphanPool struct {
+	mu       sync.Mutex
+	max      int
+	maxAge   time.Duration
+	perPeer  int
+	orphans  map[string]*txOrphan       // by transaction ID
+	children map[string]map[string]bool // IDs of the orphans by missing parent ID
+	accepted uint64
+	evicted  uint64
+}
+
+type txOrphan struct {
+	tx      *Transaction
+	parents []string
+	from    string // the peer that sent it
+	added   time.Time
+}
+
+// NewTxOrphanPool returns a pool holding up to max orphans for up to
+// maxAge, perPeer of them from a single peer
+func NewTxOrphanPool(max int, maxAge time.Duration, perPeer int) *TxOrphanPool {
+	return &TxOrphanPool{
+		max:      max,
+		maxAge:   maxAge,
+		perPeer:  perPeer,
+		orphans:  make(map[string]*txOrphan),
+		children: make(map[string]map[string]bool),
+	}
+}
+
+// Add holds tx, sent by the peer from, until one of parents, the IDs of the
+// transactions it spends the node lacks, is accepted. Adding an orphan
+// held does nothing, one over MaxOrphanTxSize or past the share of the
+// peer is refused
+func (p *TxOrphanPool) Add(tx *Transaction, parents [][]byte, from string) error {
+	if size := int(tx.Size()); size > MaxOrphanTxSize {
+		return fmt.Errorf("orphan transaction %x is %d bytes, over %d", tx.ID, size, MaxOrphanTxSize)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := p.orphans[id]; ok {
+		return nil
+	}
+
+	now := time.Now()
+	held := 0
+	for orphanID, o := range p.orphans {
+		if now.Sub(o.added) > p.maxAge {
+			p.remove(orphanID)
+			p.evicted++
+		} else if o.from == from {
+			held++
+		}
+	}
+	if held >= p.perPeer {
+		return ErrTxOrphanQuota
+	}
+	for len(p.orphans) >= p.max && len(p.orphans) > 0 {
+		ids := make([]string, 0, len(p.orphans))
+		for orphanID := range p.orphans {
+			ids = append(ids, orphanID)
+		}
+		sort.Strings(ids)
+		p.remove(ids[rand.Intn(len(ids))])
+		p.evicted++
+	}
+
+	o := &txOrphan{tx: tx, from: from, added: now}
+	for _, parent := range parents {
+		key := hex.EncodeToString(parent)
+		if p.children[key] == nil {
+			p.children[key] = make(map[string]bool)
+		}
+		if !p.children[key][id] {
+			p.children[key][id] = true
+			o.parents = append(o.parents, key)
+		}
+	}
+	p.orphans[id] = o
+
+	return nil
+}
+
+// remove drops an orphan, p.mu held
+func (p *TxOrphanPool) remove(id string) {
+	o, ok := p.orphans[id]
+	if !ok {
+		return
+	}
+	delete(p.orphans, id)
+	for _, parent := range o.parents {
+		delete(p.children[parent], id)
+		if len(p.children[parent]) == 0 {
+			delete(p.children, parent)
+		}
+	}
+}
+
+// Children returns the orphans waiting for the transaction with the ID
+// parent, oldest first. They stay held, see Accept and Remove
+func (p *TxOrphanPool) Children(parent []byte) []*Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var orphans []*txOrphan
+	for id := range p.children[hex.EncodeToString(parent)] {
+		orphans = append(orphans, p.orphans[id])
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].added.Equal(orphans[j].added) {
+			return bytes.Compare(orphans[i].tx.ID, orphans[j].tx.ID) < 0
+		}
+		return orphans[i].added.Before(orphans[j].added)
+	})
+	txs := make([]*Transaction, len(orphans))
+	for i, o := range orphans {
+		txs[i] = o.tx
+	}
+
+	return txs
+}
+
+// Accept drops the orphan with the ID, accepted into the mempool
+func (p *TxOrphanPool) Accept(id []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.orphans[hex.EncodeToString(id)]; ok {
+		p.remove(hex.EncodeToString(id))
+		p.accepted++
+	}
+}
+
+// Remove drops the orphan with the ID, found invalid
+func (p *TxOrphanPool) Remove(id []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remove(hex.EncodeToString(id))
+}
+
+// RemoveFrom drops the orphans the peer from sent, it's gone, and returns
+// how many
+func (p *TxOrphanPool) RemoveFrom(from string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	removed := 0
+	for id, o := range p.orphans {
+		if o.from == from {
+			p.remove(id)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Has tells if the transaction with the ID is held
+func (p *TxOrphanPool) Has(id []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.orphans[hex.EncodeToString(id)]
+
+	return ok
+}
+
+// Stats returns the counters of the pool
+func (p *TxOrphanPool) Stats() TxOrphanStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return TxOrphanStats{Held: len(p.orphans), Accepted: p.accepted, Evicted: p.evicted}
+}
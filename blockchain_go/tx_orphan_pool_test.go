@@ -0,0 +1,64 @@
+package core
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// orphanTx returns a transaction spending the first output of parent
+func orphanTx(parent []byte, data string) *Transaction {
+	tx := &Transaction{
+		Vin:  []TXInput{{Txid: parent, Vout: 0, Signature: []byte(data)}},
+		Vout: []TXOutput{{Value: 1}},
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestTxOrphanPool(t *testing.T) {
+	pool := NewTxOrphanPool(3, time.Hour, 2)
+	parent := []byte("parent")
+	a := orphanTx(parent, "a")
+	b := orphanTx(parent, "b")
+	assert.Nil(t, pool.Add(a, [][]byte{parent}, "peer1"))
+	assert.Nil(t, pool.Add(a, [][]byte{parent}, "peer1"), "The same orphan is held once")
+	assert.Nil(t, pool.Add(b, [][]byte{parent, parent}, "peer1"))
+	assert.True(t, pool.Has(a.ID))
+	assert.Equal(t, []*Transaction{a, b}, pool.Children(parent))
+	assert.Empty(t, pool.Children([]byte("other")))
+
+	// a peer holds its share at most, a big orphan isn't held
+	c := orphanTx(parent, "c")
+	assert.Equal(t, ErrTxOrphanQuota, pool.Add(c, [][]byte{parent}, "peer1"))
+	big := orphanTx(parent, strings.Repeat("x", MaxOrphanTxSize))
+	assert.NotNil(t, pool.Add(big, [][]byte{parent}, "peer2"))
+	assert.False(t, pool.Has(big.ID))
+
+	pool.Accept(a.ID)
+	pool.Remove(b.ID)
+	assert.Empty(t, pool.Children(parent))
+	assert.Equal(t, TxOrphanStats{Accepted: 1}, pool.Stats())
+
+	// random orphans go past the size cap, the expired ones at once
+	for i, peer := range []string{"peer1", "peer2", "peer3", "peer4"} {
+		assert.Nil(t, pool.Add(orphanTx([]byte{byte(i)}, peer), [][]byte{{byte(i)}}, peer))
+	}
+	assert.Equal(t, TxOrphanStats{Held: 3, Accepted: 1, Evicted: 1}, pool.Stats())
+	for _, o := range pool.orphans {
+		o.added = time.Now().Add(-2 * time.Hour)
+	}
+	assert.Nil(t, pool.Add(c, [][]byte{parent}, "peer1"))
+	assert.Equal(t, TxOrphanStats{Held: 1, Accepted: 1, Evicted: 4}, pool.Stats())
+	assert.Equal(t, 1, len(pool.children))
+	assert.Contains(t, pool.children, hex.EncodeToString(parent))
+
+	// the orphans of a peer gone are dropped
+	assert.Equal(t, 1, pool.RemoveFrom("peer1"))
+	assert.Equal(t, 0, pool.RemoveFrom("peer1"))
+	assert.False(t, pool.Has(c.ID))
+	assert.Empty(t, pool.children)
+}
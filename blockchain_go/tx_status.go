@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/boltdb/bolt"
+)
+
+// The statuses of a transaction, see GetTransactionStatus
+const (
+	TxConfirmed = "confirmed" // in a block of the chain
+	TxPending   = "pending"   // in the mempool
+	TxUnknown   = "unknown"
+)
+
+// TxStatus tells whether a transaction is in the chain, how deep and where,
+// is waiting in the mempool or is unknown to the node
+type TxStatus struct {
+	TxID          string `json:"txid"`
+	Status        string `json:"status"`
+	BlockHash     string `json:"blockhash,omitempty"`
+	Height        int64  `json:"height"`
+	Confirmations int64  `json:"confirmations"`
+	Position      int    `json:"position"` // the index of the transaction in its block
+}
+
+// GetTransactionStatus returns the status of the transaction ID, looked up
+// in the transaction index, or the chain walked from the tip without one,
+// then in the mempool. A transaction of a block disconnected leaves the
+// index with it, it is pending again once back in the mempool
+func (bc *Blockchain) GetTransactionStatus(ID []byte) (*TxStatus, error) {
+	status := &TxStatus{TxID: hex.EncodeToString(ID), Status: TxUnknown}
+	var hash []byte
+	var height int64
+	indexed := false
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(txIndexBucket))
+		if index == nil {
+			return nil
+		}
+		indexed = true
+		value := index.Get(ID)
+		if len(value) < 4 {
+			return nil
+		}
+		// the header outlives a pruned body
+		header, err := headerAt(tx, value[:len(value)-4])
+		if err != nil {
+			return err
+		}
+		hash = append([]byte(nil), value[:len(value)-4]...)
+		height = header.Height.Int64()
+		status.Position = int(binary.BigEndian.Uint32(value[len(value)-4:]))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		_, block, err := bc.FindTransactionBlock(ID)
+		if err != nil && err != ErrTxNotFound {
+			return nil, err
+		}
+		if block != nil {
+			hash, height = block.Hash, block.Height.Int64()
+			for i, t := range block.Transactions {
+				if bytes.Equal(t.ID, ID) {
+					status.Position = i
+				}
+			}
+		}
+	}
+
+	if hash != nil {
+		best, _, err := bc.GetBestHeightLastHash()
+		if err != nil {
+			return nil, err
+		}
+		status.Status = TxConfirmed
+		status.BlockHash = hex.EncodeToString(hash)
+		status.Height = height
+		status.Confirmations = best.Int64() - height + 1
+		return status, nil
+	}
+	if _, err := findMempoolTransaction(ID); err == nil {
+		status.Status = TxPending
+	}
+
+	return status, nil
+}
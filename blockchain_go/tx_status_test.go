@@ -0,0 +1,67 @@
+package core
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTransactionStatus(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		inTempDir(t, func(dir string) {
+			defer func(enabled bool) { TxIndexEnabled = enabled }(TxIndexEnabled)
+			defer func(f func() []*Transaction) { MempoolTransactions = f }(MempoolTransactions)
+			TxIndexEnabled = indexed
+			MempoolTransactions = nil
+			_, address := newTestWallets()
+			bc := newTestChain(address, address)
+			defer bc.Db.Close()
+			UTXOSet{Blockchain: bc}.Reindex()
+			genesis, _ := bc.GetBlock(bc.GenesisHash)
+			tip, _ := bc.GetBlock(bc.tip)
+
+			status, err := bc.GetTransactionStatus(genesis.Transactions[0].ID)
+			assert.Nil(t, err)
+			assert.Equal(t, TxConfirmed, status.Status)
+			assert.Equal(t, hex.EncodeToString(genesis.Hash), status.BlockHash)
+			assert.Equal(t, int64(2), status.Confirmations)
+			assert.Equal(t, 0, status.Position)
+			status, err = bc.GetTransactionStatus(tip.Transactions[0].ID)
+			assert.Nil(t, err)
+			assert.Equal(t, tip.Height.Int64(), status.Height)
+			assert.Equal(t, int64(1), status.Confirmations)
+
+			pending := NewCoinbaseTX(address, "pending")
+			status, err = bc.GetTransactionStatus(pending.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, &TxStatus{TxID: hex.EncodeToString(pending.ID), Status: TxUnknown}, status)
+			MempoolTransactions = func() []*Transaction { return []*Transaction{pending} }
+			status, err = bc.GetTransactionStatus(pending.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, TxPending, status.Status)
+
+			// the transactions of a block disconnected are pending again once
+			// back in the mempool
+			side := minedBlock(&genesis, address)
+			longer := minedBlock(side, address)
+			for _, b := range []*Block{side, longer} {
+				_, err := bc.ProcessBlock(b)
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, longer.Hash, bc.tip)
+			status, err = bc.GetTransactionStatus(tip.Transactions[0].ID)
+			assert.Nil(t, err)
+			assert.Equal(t, TxUnknown, status.Status)
+			MempoolTransactions = func() []*Transaction { return tip.Transactions }
+			status, err = bc.GetTransactionStatus(tip.Transactions[0].ID)
+			assert.Nil(t, err)
+			assert.Equal(t, TxPending, status.Status)
+			assert.Equal(t, "", status.BlockHash)
+			status, err = bc.GetTransactionStatus(side.Transactions[0].ID)
+			assert.Nil(t, err)
+			assert.Equal(t, TxConfirmed, status.Status)
+			assert.Equal(t, int64(2), status.Confirmations)
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// DefaultUTXOCacheSize is the number of UTXO set entries a Blockchain keeps
+// decoded in memory, see SetUTXOCacheSize
+var DefaultUTXOCacheSize = 100000
+
+// utxoCache keeps the most recently used entries of the UTXO bucket decoded
+// in memory. The bucket stays authoritative: writes go to bolt and reach the
+// cache only once their bolt transaction committed, and a miss reads bolt.
+// The generation is odd while a write is in progress and bumped again when
+// it ends; a reader whose bolt transaction didn't start at the current even
+// generation bypasses the cache, so it never mixes two states
+type utxoCache struct {
+	writeMu sync.Mutex // serialises writers from bolt commit to cache commit
+
+	mu      sync.Mutex
+	size    int
+	gen     uint64
+	lru     *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type utxoCacheEntry struct {
+	key  string
+	outs TXOutputs
+}
+
+func newUTXOCache(size int) *utxoCache {
+	return &utxoCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// SetUTXOCacheSize sets the number of UTXO set entries kept in memory. Zero
+// or less disables the cache
+func (bc *Blockchain) SetUTXOCacheSize(size int) {
+	c := bc.utxoCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size = size
+	c.evict()
+}
+
+// generation returns the generation to pass to the reads of a bolt
+// transaction started after the call
+func (c *utxoCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// current reports whether readers at gen may use the cache, with c.mu held
+func (c *utxoCache) current(gen uint64) bool {
+	return c.gen == gen && gen%2 == 0
+}
+
+// peek returns a copy of a cached entry to the writer, which holds writeMu
+// so the cache matches the committed bucket
+func (c *utxoCache) peek(key []byte) (TXOutputs, bool) {
+	if c == nil {
+		return TXOutputs{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[string(key)]
+	if !ok {
+		return TXOutputs{}, false
+	}
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*utxoCacheEntry).outs.clone(), true
+}
+
+// decode returns the entry key of the UTXO bucket read as data, from the
+// cache when possible. The result is shared with the cache and must not be
+// modified
+func (c *utxoCache) decode(gen uint64, key, data []byte) TXOutputs {
+	if c == nil {
+		return DeserializeOutputs(data)
+	}
+	c.mu.Lock()
+	if c.current(gen) {
+		if e, ok := c.entries[string(key)]; ok {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			return e.Value.(*utxoCacheEntry).outs
+		}
+	}
+	c.mu.Unlock()
+
+	outs := DeserializeOutputs(data)
+	c.add(gen, key, outs)
+
+	return outs
+}
+
+// add caches an entry read from bolt at generation gen
+func (c *utxoCache) add(gen uint64, key []byte, outs TXOutputs) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.current(gen) || c.size <= 0 {
+		return
+	}
+	c.set(string(key), outs)
+	c.evict()
+}
+
+// begin marks a write in progress until commit or reset
+func (c *utxoCache) begin() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+}
+
+// commit ends a write, applying the changes of its bolt transaction if it
+// committed. A nil entry is a deletion
+func (c *utxoCache) commit(changes map[string]*TXOutputs) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for key, outs := range changes {
+		if outs == nil || c.size <= 0 {
+			if e, ok := c.entries[key]; ok {
+				c.lru.Remove(e)
+				delete(c.entries, key)
+			}
+			continue
+		}
+		c.set(key, *outs)
+	}
+	c.evict()
+}
+
+// reset ends a write that rebuilt the UTXO bucket, emptying the cache
+func (c *utxoCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// set stores an entry as the most recently used, with c.mu held
+func (c *utxoCache) set(key string, outs TXOutputs) {
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*utxoCacheEntry).outs = outs
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&utxoCacheEntry{key, outs})
+}
+
+// evict drops the least recently used entries over size, with c.mu held
+func (c *utxoCache) evict() {
+	for c.lru.Len() > c.size && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*utxoCacheEntry).key)
+	}
+}
+
+// utxoWriter changes the UTXO bucket and its index within a bolt
+// transaction, reading through its own changes, the cache and bolt in turn.
+// The changes go to the cache when the transaction committed, see
+// UTXOSet.write
+type utxoWriter struct {
+	b       *bolt.Bucket
+	index   *bolt.Bucket
+	hash    *utxoHash
+	cache   *utxoCache
+	changes map[string]*TXOutputs
+}
+
+// get returns the outputs of a transaction in the set, which the caller may
+// modify
+func (w *utxoWriter) get(txID []byte) (TXOutputs, bool) {
+	if outs, ok := w.changes[string(txID)]; ok {
+		if outs == nil {
+			return TXOutputs{}, false
+		}
+		return outs.clone(), true
+	}
+	if outs, ok := w.cache.peek(txID); ok {
+		return outs, true
+	}
+	data := w.b.Get(txID)
+	if data == nil {
+		return TXOutputs{}, false
+	}
+
+	return DeserializeOutputs(data), true
+}
+
+// put stores the outputs of a transaction, or deletes the entry once all of
+// them are spent
+func (w *utxoWriter) put(txID []byte, outs TXOutputs) error {
+	for _, out := range outs.Outputs {
+		if !out.isSpent() {
+			old, _ := w.get(txID)
+			if err := indexOutputs(w.index, txID, old, outs); err != nil {
+				return err
+			}
+			w.hash.update(txID, old, outs)
+			w.changes[string(txID)] = &outs
+			return w.b.Put(txID, outs.Serialize())
+		}
+	}
+
+	return w.delete(txID)
+}
+
+func (w *utxoWriter) delete(txID []byte) error {
+	old, _ := w.get(txID)
+	if err := indexOutputs(w.index, txID, old, TXOutputs{}); err != nil {
+		return err
+	}
+	w.hash.update(txID, old, TXOutputs{})
+	w.changes[string(txID)] = nil
+
+	return w.b.Delete(txID)
+}
+
+// write runs f in a bolt write transaction and updates the cache with the
+// changes made through w once it committed
+func (u UTXOSet) write(f func(tx *bolt.Tx, w *utxoWriter) error) error {
+	u.Blockchain.utxoMu.Lock()
+	defer u.Blockchain.utxoMu.Unlock()
+	cache := u.Blockchain.utxoCache
+	if cache != nil {
+		cache.writeMu.Lock()
+		defer cache.writeMu.Unlock()
+	}
+
+	w := &utxoWriter{cache: cache, changes: make(map[string]*TXOutputs)}
+	cache.begin()
+	err := u.Blockchain.Db.Update(func(tx *bolt.Tx) error {
+		w.b = tx.Bucket([]byte(utxoBucket))
+		w.index = tx.Bucket([]byte(utxoAddrBucket))
+		if w.index == nil {
+			if err := rebuildAddrIndex(tx); err != nil {
+				return err
+			}
+			w.index = tx.Bucket([]byte(utxoAddrBucket))
+		}
+		w.hash = loadUTXOHash(tx)
+		if err := f(tx, w); err != nil {
+			return err
+		}
+		return storeUTXOHash(tx, w.hash)
+	})
+	if err != nil {
+		w.changes = nil
+	}
+	cache.commit(w.changes)
+
+	return err
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// syntheticBlocks returns n blocks following the genesis of bc without
+// mining them. Each pays a coinbase with two outputs and spends the first
+// output of the previous block's coinbase
+func syntheticBlocks(bc *Blockchain, n int) []*Block {
+	genesis, _ := bc.GetBlock(bc.GenesisHash)
+	pubKeyHash := genesis.Transactions[0].Vout[0].PubKeyHash
+	prev := genesis.Transactions[0]
+	prevHash := genesis.Hash
+
+	var blocks []*Block
+	for i := 1; i <= n; i++ {
+		var height [8]byte
+		binary.BigEndian.PutUint64(height[:], uint64(i))
+		coinbaseID := sha256.Sum256(append([]byte("coinbase"), height[:]...))
+		coinbase := &Transaction{ID: coinbaseID[:], Vin: []TXInput{{[]byte{}, -1, nil, height[:]}}, Vout: []TXOutput{{subsidy, pubKeyHash}, {1, pubKeyHash}}}
+		spendID := sha256.Sum256(append([]byte("spend"), height[:]...))
+		spend := &Transaction{ID: spendID[:], Vin: []TXInput{{prev.ID, 0, nil, nil}}, Vout: []TXOutput{{prev.Vout[0].Value, pubKeyHash}}}
+		hash := sha256.Sum256(height[:])
+		blocks = append(blocks, &Block{BlockHeader: BlockHeader{PrevBlockHash: prevHash, Timestamp: big.NewInt(int64(i)), Height: big.NewInt(int64(i))}, Transactions: []*Transaction{coinbase, spend}, Hash: hash[:], ReceivedAt: time.Now()})
+		prev = coinbase
+		prevHash = hash[:]
+	}
+
+	return blocks
+}
+
+// assertCacheMatchesBolt checks every cached entry against the bucket
+func assertCacheMatchesBolt(t *testing.T, bc *Blockchain) {
+	c := bc.utxoCache
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		for key, e := range c.entries {
+			data := b.Get([]byte(key))
+			if assert.NotNil(t, data, "cached entry %x is in bolt", key) {
+				assert.Equal(t, DeserializeOutputs(data), e.Value.(*utxoCacheEntry).outs, "entry %x", key)
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestUTXOCacheConsistency(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		bc.SetUTXOCacheSize(8)
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		blocks := syntheticBlocks(bc, 20)
+		UTXOSet.UpdateBlocks(blocks[:10])
+		for _, block := range blocks[10:] {
+			UTXOSet.Update(block)
+			UTXOSet.GetBalances([][]byte{HashPubKey(nil)})
+		}
+		assert.Len(t, bc.utxoCache.entries, 8, "The cache holds at most its size")
+		assertCacheMatchesBolt(t, bc)
+		cached := utxoSnapshot(t, UTXOSet)
+
+		for i := len(blocks) - 1; i >= 15; i-- {
+			assert.Nil(t, UTXOSet.Undo(blocks[i]))
+		}
+		assertCacheMatchesBolt(t, bc)
+
+		// reread everything from disk
+		UTXOSet.UpdateBlocks(blocks[15:])
+		bc.SetUTXOCacheSize(0)
+		assert.Len(t, bc.utxoCache.entries, 0)
+		assert.Equal(t, cached, utxoSnapshot(t, UTXOSet))
+	})
+}
+
+func BenchmarkImportBlocks(b *testing.B) {
+	for _, bench := range []struct {
+		name      string
+		cacheSize int
+		batch     int
+	}{
+		{"nocache", 0, 1},
+		{"cache", DefaultUTXOCacheSize, 1},
+		{"cache-batch100", DefaultUTXOCacheSize, 100},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				inTempDir(b, func(dir string) {
+					b.StopTimer()
+					bc := mustCreate(fmt.Sprintf("%s", NewWallet().GetAddress()), "bench")
+					bc.SetUTXOCacheSize(bench.cacheSize)
+					UTXOSet := UTXOSet{Blockchain: bc}
+					UTXOSet.Reindex()
+					blocks := syntheticBlocks(bc, 10000)
+					b.StartTimer()
+
+					for start := 0; start < len(blocks); start += bench.batch {
+						UTXOSet.UpdateBlocks(blocks[start : start+bench.batch])
+					}
+
+					b.StopTimer()
+					bc.Db.Close()
+					b.StartTimer()
+				})
+			}
+		})
+	}
+}
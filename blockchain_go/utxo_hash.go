@@ -0,0 +1,226 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// UTXOCommitmentHeight is the height from which blocks commit to the UTXO
+// set in their header, see Block.UTXOCommitment. Blocks below it are exempt.
+// Every node of a network must use the same height; it is not activated by
+// default
+var UTXOCommitmentHeight = int64(math.MaxInt64)
+
+// utxoHashBucket holds the rolling hash of the UTXO set under utxoHashKey,
+// updated in the same bolt transactions as the set
+const utxoHashBucket = "chainstate_hash"
+
+var utxoHashKey = []byte("muhash")
+
+// utxoHashSize is the size of a MuHash3072 state
+const utxoHashSize = 384
+
+// utxoHashPrime is the MuHash3072 modulus, 2^3072 - 1103717
+var utxoHashPrime = new(big.Int).Sub(new(big.Int).Lsh(big1, 3072), big.NewInt(1103717))
+
+// utxoHash is a MuHash of the unspent outputs: the product of their hashes
+// modulo utxoHashPrime. It doesn't depend on the order the outputs are added
+// in, so it is updated output by output as blocks are applied and undone.
+// Removed outputs multiply the denominator, which is only inverted when the
+// state is read
+type utxoHash struct {
+	num, den *big.Int
+	changed  bool
+}
+
+// newUTXOHash returns the hash of a state, or of the empty set when state is
+// empty
+func newUTXOHash(state []byte) *utxoHash {
+	num := big.NewInt(1)
+	if len(state) > 0 {
+		num.SetBytes(state)
+	}
+
+	return &utxoHash{num: num, den: big.NewInt(1)}
+}
+
+// utxoHashElement maps an unspent output to a number modulo utxoHashPrime
+func utxoHashElement(txID []byte, vout int, out TXOutput) *big.Int {
+	var data bytes.Buffer
+	data.WriteByte(byte(len(txID)))
+	data.Write(txID)
+	binary.Write(&data, binary.BigEndian, uint32(vout))
+	binary.Write(&data, binary.BigEndian, uint64(out.Value))
+	data.WriteByte(byte(len(out.PubKeyHash)))
+	data.Write(out.PubKeyHash)
+	seed := sha256.Sum256(data.Bytes())
+
+	expanded := make([]byte, 0, utxoHashSize)
+	for i := byte(0); len(expanded) < utxoHashSize; i++ {
+		block := sha256.Sum256(append(seed[:], i))
+		expanded = append(expanded, block[:]...)
+	}
+
+	return new(big.Int).Mod(new(big.Int).SetBytes(expanded), utxoHashPrime)
+}
+
+func (h *utxoHash) add(txID []byte, vout int, out TXOutput) {
+	h.num.Mul(h.num, utxoHashElement(txID, vout, out)).Mod(h.num, utxoHashPrime)
+	h.changed = true
+}
+
+func (h *utxoHash) remove(txID []byte, vout int, out TXOutput) {
+	h.den.Mul(h.den, utxoHashElement(txID, vout, out)).Mod(h.den, utxoHashPrime)
+	h.changed = true
+}
+
+// update changes the hash for a transaction whose outputs in the set change
+// from old to outs
+func (h *utxoHash) update(txID []byte, old, outs TXOutputs) {
+	for i := 0; i < len(old.Outputs) || i < len(outs.Outputs); i++ {
+		var before, after TXOutput
+		if i < len(old.Outputs) {
+			before = old.Outputs[i]
+		}
+		if i < len(outs.Outputs) {
+			after = outs.Outputs[i]
+		}
+		if before.Value == after.Value && bytes.Equal(before.PubKeyHash, after.PubKeyHash) {
+			continue
+		}
+		if !before.isSpent() {
+			h.remove(txID, i, before)
+		}
+		if !after.isSpent() {
+			h.add(txID, i, after)
+		}
+	}
+}
+
+// state returns the hash as a single number, the form it is stored in
+func (h *utxoHash) state() []byte {
+	if h.den.Cmp(big1) != 0 {
+		inverse := new(big.Int).ModInverse(h.den, utxoHashPrime)
+		h.num.Mul(h.num, inverse).Mod(h.num, utxoHashPrime)
+		h.den.SetInt64(1)
+	}
+	state := make([]byte, utxoHashSize)
+
+	return h.num.FillBytes(state)
+}
+
+// sum returns the commitment to the set, the SHA-256 of the state
+func (h *utxoHash) sum() []byte {
+	sum := sha256.Sum256(h.state())
+	return sum[:]
+}
+
+// loadUTXOHash reads the hash of the set, computing it from the UTXO bucket
+// when it isn't stored yet; it is then marked changed
+func loadUTXOHash(tx *bolt.Tx) *utxoHash {
+	if b := tx.Bucket([]byte(utxoHashBucket)); b != nil {
+		if state := b.Get(utxoHashKey); state != nil {
+			return newUTXOHash(state)
+		}
+	}
+
+	h := newUTXOHash(nil)
+	h.changed = true
+	if b := tx.Bucket([]byte(utxoBucket)); b != nil {
+		b.ForEach(func(k, v []byte) error {
+			h.update(k, TXOutputs{}, DeserializeOutputs(v))
+			return nil
+		})
+	}
+
+	return h
+}
+
+// storeUTXOHash writes the hash if it changed since it was loaded
+func storeUTXOHash(tx *bolt.Tx, h *utxoHash) error {
+	if !h.changed {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(utxoHashBucket))
+	if err != nil {
+		return err
+	}
+	h.changed = false
+
+	return b.Put(utxoHashKey, h.state())
+}
+
+// rebuildUTXOHash computes the hash from the UTXO bucket, replacing it
+func rebuildUTXOHash(tx *bolt.Tx) error {
+	if b := tx.Bucket([]byte(utxoHashBucket)); b != nil {
+		if err := b.Delete(utxoHashKey); err != nil {
+			return err
+		}
+	}
+
+	return storeUTXOHash(tx, loadUTXOHash(tx))
+}
+
+// ensureUTXOHash stores the hash of a UTXO set written before it existed
+func ensureUTXOHash(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(utxoBucket)) == nil {
+			return nil
+		}
+		return storeUTXOHash(tx, loadUTXOHash(tx))
+	})
+}
+
+// Commitment returns the commitment to the UTXO set, a hash of its unspent
+// outputs that doesn't depend on how the set was built. A block from
+// UTXOCommitmentHeight carries the commitment to the set its parent left
+func (u UTXOSet) Commitment() ([]byte, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	var sum []byte
+
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		sum = loadUTXOHash(tx).sum()
+		return nil
+	})
+
+	return sum, err
+}
+
+// commitsUTXOSet reports whether a block at height must carry a UTXO
+// commitment
+func commitsUTXOSet(height *big.Int) bool {
+	return height != nil && height.Sign() > 0 && height.Int64() >= UTXOCommitmentHeight
+}
+
+// utxoCommitmentAfter returns the UTXO commitment of the block of the chain
+// whose parent is hash, nil when there is none or it carries none
+func (bc *Blockchain) utxoCommitmentAfter(hash []byte) []byte {
+	var commitment []byte
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		for current := blocks.Get([]byte("l")); len(current) > 0 && !bytes.Equal(current, hash); {
+			data := blocks.Get(current)
+			if data == nil {
+				return nil // pruned
+			}
+			block := DeserializeBlock(data)
+			if bytes.Equal(block.PrevBlockHash, hash) {
+				commitment = block.UTXOCommitment
+				return nil
+			}
+			current = block.PrevBlockHash
+		}
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	return commitment
+}
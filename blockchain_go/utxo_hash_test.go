@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTXOCommitment(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		commitment := func() []byte {
+			c, err := UTXOSet.Commitment()
+			assert.Nil(t, err)
+			return c
+		}
+		before := commitment()
+
+		tx, err := NewUTXOTransaction(ws.Wallets[address], string(NewWallet().GetAddress()), 3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		block := mustMine(bc, []*Transaction{NewCoinbaseTX(address, ""), tx})
+		UTXOSet.Update(block)
+		after := commitment()
+		assert.NotEqual(t, before, after)
+
+		// the rolling hash matches the one of the same set built from scratch
+		assert.Nil(t, UTXOSet.Undo(block))
+		assert.Equal(t, before, commitment())
+		UTXOSet.Update(block)
+		assert.Equal(t, after, commitment())
+		UTXOSet.Reindex()
+		assert.Equal(t, after, commitment())
+		bc.Db.Update(func(dbTx *bolt.Tx) error {
+			return dbTx.DeleteBucket([]byte(utxoHashBucket))
+		})
+		assert.Equal(t, after, commitment(), "A set without a stored hash is hashed on read")
+		assert.Nil(t, ensureUTXOHash(bc.Db))
+		assert.Equal(t, after, commitment())
+		stats, err := UTXOSet.Stats()
+		assert.Nil(t, err)
+		assert.Len(t, stats.Commitment, 64)
+	})
+}
+
+func TestUTXOCommitmentValidation(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		height, tip := bestTip(bc)
+		next := new(big.Int).Add(height, big1)
+		parent, err := bc.GetBlock(tip)
+		assert.Nil(t, err)
+		// mines a block on the tip, a second after it to be valid at once
+		mine := func(commitment []byte) *Block {
+			block := NewBlock([]*Transaction{NewCoinbaseTX(address, "")}, tip, next, false, bc)
+			block.Timestamp = new(big.Int).Add(parent.Timestamp, big1)
+			if commitment != nil {
+				block.UTXOCommitment = commitment
+			}
+			block.Nonce, block.Hash = NewProofOfWork(block).Run()
+			return block
+		}
+
+		unactivated := mine(nil)
+		assert.Nil(t, unactivated.UTXOCommitment, "Blocks below the activation height are exempt")
+		valid, _ := bc.IsBlockValid(unactivated)
+		assert.True(t, valid)
+
+		defer func(height int64) { UTXOCommitmentHeight = height }(UTXOCommitmentHeight)
+		UTXOCommitmentHeight = next.Int64()
+		valid, reason := bc.IsBlockValid(unactivated)
+		assert.False(t, valid, "A block from the activation height must commit")
+		assert.Equal(t, 8, reason)
+
+		expected, err := UTXOSet.Commitment()
+		assert.Nil(t, err)
+		block := mine(nil)
+		assert.Equal(t, expected, block.UTXOCommitment)
+		valid, _ = bc.IsBlockValid(block)
+		assert.True(t, valid)
+
+		// a block with the proof of work done over a wrong commitment
+		forged := mine(bytes.Repeat([]byte{1}, len(expected)))
+		valid, reason = bc.IsBlockValid(forged)
+		assert.False(t, valid)
+		assert.Equal(t, 8, reason)
+
+		// stripping the commitment changes the block hash
+		stripped := *block
+		stripped.UTXOCommitment = nil
+		valid, reason = bc.IsBlockValid(&stripped)
+		assert.False(t, valid)
+		assert.Equal(t, 4, reason)
+	})
+}
+
+func TestLoadSnapshotChecksCommitment(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		_, tip := bestTip(bc)
+		var honest bytes.Buffer
+		assert.Nil(t, UTXOSet.Snapshot(&honest))
+
+		// a consistent snapshot of a set where an output is worth more
+		var forged bytes.Buffer
+		last, err := bc.GetBlock(tip)
+		assert.Nil(t, err)
+		coinbase := last.Transactions[0].ID
+		err = UTXOSet.write(func(tx *bolt.Tx, w *utxoWriter) error {
+			outs, _ := w.get(coinbase)
+			outs.Outputs[0].Value++
+			return w.put(coinbase, outs)
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, UTXOSet.Snapshot(&forged))
+		assert.Nil(t, UTXOSet.LoadSnapshot(bytes.NewReader(honest.Bytes()), tip))
+		assert.Nil(t, UTXOSet.LoadSnapshot(bytes.NewReader(forged.Bytes()), tip), "Without a block committing to it only the snapshot itself is checked")
+
+		// once a block commits to the set at the tip, the forged one is rejected
+		UTXOSet.Reindex()
+		defer func(height int64) { UTXOCommitmentHeight = height }(UTXOCommitmentHeight)
+		UTXOCommitmentHeight = 0
+		UTXOSet.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")}))
+		want := utxoSnapshot(t, UTXOSet)
+		assert.NotNil(t, UTXOSet.LoadSnapshot(bytes.NewReader(forged.Bytes()), tip))
+		assert.Equal(t, want, utxoSnapshot(t, UTXOSet))
+		assert.Nil(t, UTXOSet.LoadSnapshot(bytes.NewReader(honest.Bytes()), tip))
+
+		// entries that don't hash to the commitment of the snapshot
+		data := append([]byte(nil), honest.Bytes()...)
+		body := data[:len(data)-sha256.Size]
+		body[len(body)-30] ^= 1
+		sum := sha256.Sum256(body)
+		copy(data[len(body):], sum[:])
+		err = UTXOSet.LoadSnapshot(bytes.NewReader(data), tip)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "commitment")
+	})
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/boltdb/bolt"
+)
+
+// utxoAddrBucket indexes the UTXO set by owner. A key is the length of a
+// public key hash, the hash, a transaction ID and a big endian uint32 output
+// index, the value is empty; the outputs of a public key hash are the keys
+// under its prefix. It changes along with the set in the same bolt
+// transactions
+const utxoAddrBucket = "chainstate_addr"
+
+// addrIndexPrefix returns the prefix of the index keys of pubKeyHash
+func addrIndexPrefix(pubKeyHash []byte) []byte {
+	return append([]byte{byte(len(pubKeyHash))}, pubKeyHash...)
+}
+
+func addrIndexKey(pubKeyHash, txID []byte, vout int) []byte {
+	key := append(addrIndexPrefix(pubKeyHash), txID...)
+	var idx [4]byte
+	binary.BigEndian.PutUint32(idx[:], uint32(vout))
+
+	return append(key, idx[:]...)
+}
+
+// indexOutputs updates the index for a transaction whose outputs in the set
+// change from old to outs
+func indexOutputs(index *bolt.Bucket, txID []byte, old, outs TXOutputs) error {
+	for i := 0; i < len(old.Outputs) || i < len(outs.Outputs); i++ {
+		var before, after TXOutput
+		if i < len(old.Outputs) {
+			before = old.Outputs[i]
+		}
+		if i < len(outs.Outputs) {
+			after = outs.Outputs[i]
+		}
+		if before.isSpent() == after.isSpent() && bytes.Equal(before.PubKeyHash, after.PubKeyHash) {
+			continue
+		}
+		if !before.isSpent() {
+			if err := index.Delete(addrIndexKey(before.PubKeyHash, txID, i)); err != nil {
+				return err
+			}
+		}
+		if !after.isSpent() {
+			if err := index.Put(addrIndexKey(after.PubKeyHash, txID, i), nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebuildAddrIndex builds the index from the UTXO bucket, replacing it
+func rebuildAddrIndex(tx *bolt.Tx) error {
+	err := tx.DeleteBucket([]byte(utxoAddrBucket))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	index, err := tx.CreateBucket([]byte(utxoAddrBucket))
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket([]byte(utxoBucket))
+	if b == nil {
+		return nil
+	}
+
+	return b.ForEach(func(k, v []byte) error {
+		return indexOutputs(index, k, TXOutputs{}, DeserializeOutputs(v))
+	})
+}
+
+// ensureAddrIndex builds the index of a UTXO set written before the index
+// existed. UTXOSet.write does the same for a set without one
+func ensureAddrIndex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(utxoBucket)) == nil || tx.Bucket([]byte(utxoAddrBucket)) != nil {
+			return nil
+		}
+		return rebuildAddrIndex(tx)
+	})
+}
+
+// forEachOwned calls f with the unspent outputs of pubKeyHash, ordered by
+// transaction ID then output index, until f returns false. It looks them up
+// in the index and reads their entries through the cache at gen
+func forEachOwned(tx *bolt.Tx, cache *utxoCache, gen uint64, pubKeyHash []byte, f func(txID []byte, vout int, out TXOutput) bool) {
+	walkOwned(tx, cache, gen, pubKeyHash, nil, func(key, txID []byte, vout int, out TXOutput) bool {
+		return f(txID, vout, out)
+	})
+}
+
+// walkOwned is forEachOwned starting after the index key after, or at the
+// first output when it is nil, and passing f the index key of each output
+func walkOwned(tx *bolt.Tx, cache *utxoCache, gen uint64, pubKeyHash, after []byte, f func(key, txID []byte, vout int, out TXOutput) bool) {
+	index := tx.Bucket([]byte(utxoAddrBucket))
+	if index == nil {
+		return
+	}
+	b := tx.Bucket([]byte(utxoBucket))
+
+	prefix := addrIndexPrefix(pubKeyHash)
+	c := index.Cursor()
+	k, _ := c.Seek(prefix)
+	if after != nil {
+		if k, _ = c.Seek(after); bytes.Equal(k, after) {
+			k, _ = c.Next()
+		}
+	}
+	var txID []byte
+	var outs TXOutputs
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		rest := k[len(prefix):]
+		if len(rest) < 4 {
+			continue
+		}
+		id := rest[:len(rest)-4]
+		vout := int(binary.BigEndian.Uint32(rest[len(rest)-4:]))
+		if !bytes.Equal(id, txID) {
+			txID = id
+			outs = TXOutputs{}
+			if data := b.Get(id); data != nil {
+				outs = cache.decode(gen, id, data)
+			}
+		}
+		if vout >= len(outs.Outputs) || !outs.Outputs[vout].IsLockedWithKey(pubKeyHash) {
+			continue
+		}
+		if !f(k, txID, vout, outs.Outputs[vout]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertAddrIndexMatchesSet checks the owner index against the UTXO bucket
+func assertAddrIndexMatchesSet(t *testing.T, bc *Blockchain) {
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		expected := make(map[string]bool)
+		tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			for i, out := range DeserializeOutputs(v).Outputs {
+				if !out.isSpent() {
+					expected[string(addrIndexKey(out.PubKeyHash, k, i))] = true
+				}
+			}
+			return nil
+		})
+		actual := make(map[string]bool)
+		tx.Bucket([]byte(utxoAddrBucket)).ForEach(func(k, v []byte) error {
+			actual[string(k)] = true
+			return nil
+		})
+		assert.Equal(t, expected, actual)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestAddrIndex(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		assertAddrIndexMatchesSet(t, bc)
+		pubKeyHash := HashPubKey(ws.Wallets[address].PublicKey)
+
+		other := NewWallet()
+		tx, err := NewUTXOTransaction(ws.Wallets[address], fmt.Sprintf("%s", other.GetAddress()), 3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		block := mustMine(bc, []*Transaction{strayCoinbase(), tx})
+		UTXOSet.Update(block)
+		assertAddrIndexMatchesSet(t, bc)
+		assert.Equal(t, []TXOutput{tx.Vout[0]}, mustOutputs(UTXOSet.FindUTXO(HashPubKey(other.PublicKey))))
+		acc, outputs := mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 2*subsidy, nil, 1, false))
+		assert.Equal(t, 2*subsidy-3, acc)
+		assert.Len(t, outputs, 2)
+		acc, _ = mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 1, nil, 1, false))
+		assert.True(t, acc > 0 && acc < 2*subsidy-3, "The selection stops at amount")
+
+		assert.Nil(t, UTXOSet.Undo(block))
+		assertAddrIndexMatchesSet(t, bc)
+		assert.Len(t, mustOutputs(UTXOSet.FindUTXO(HashPubKey(other.PublicKey))), 0)
+
+		// a set written before the index existed is indexed when opened
+		bc.Db.Update(func(dbTx *bolt.Tx) error {
+			return dbTx.DeleteBucket([]byte(utxoAddrBucket))
+		})
+		assert.Nil(t, ensureAddrIndex(bc.Db))
+		assertAddrIndexMatchesSet(t, bc)
+		balances, err := UTXOSet.GetBalances([][]byte{pubKeyHash})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2*subsidy), balances[hex.EncodeToString(pubKeyHash)])
+	})
+}
+
+// benchPubKeyHash is the public key hash of the i-th benchmark address
+func benchPubKeyHash(i int) []byte {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("address %d", i)))
+	return sum[:20]
+}
+
+// fillUTXOSet writes transactions with outputs outputs each to the UTXO set,
+// spreading the outputs over addresses
+func fillUTXOSet(u UTXOSet, transactions, outputs, addresses int) error {
+	const batch = 10000
+	for start := 0; start < transactions; start += batch {
+		err := u.write(func(tx *bolt.Tx, w *utxoWriter) error {
+			for i := start; i < start+batch && i < transactions; i++ {
+				var txID [32]byte
+				binary.BigEndian.PutUint64(txID[:], uint64(i))
+				txID = sha256.Sum256(txID[:])
+				outs := TXOutputs{}
+				for j := 0; j < outputs; j++ {
+					outs.Outputs = append(outs.Outputs, TXOutput{j + 1, benchPubKeyHash((i*outputs + j) % addresses)})
+				}
+				if err := w.put(txID[:], outs); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BenchmarkWalletQueries queries a set of 1M outputs over 10k addresses
+func BenchmarkWalletQueries(b *testing.B) {
+	inTempDir(b, func(dir string) {
+		bc := mustCreate(fmt.Sprintf("%s", NewWallet().GetAddress()), "bench")
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		if err := fillUTXOSet(UTXOSet, 100000, 10, 10000); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run("FindSpendableOutputs", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if acc, _ := mustSpendable(UTXOSet.FindSpendableOutputs(benchPubKeyHash(i%10000), 1<<30, nil, 1, false)); acc == 0 {
+					b.Fatal("no outputs found")
+				}
+			}
+		})
+		b.Run("GetBalance", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if confirmed, _, _ := UTXOSet.GetBalance(benchPubKeyHash(i%10000), 0); confirmed == 0 {
+					b.Fatal("no balance")
+				}
+			}
+		})
+	})
+}
+
+func TestFindUTXOPage(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address, address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		pubKeyHash := HashPubKey(ws.Wallets[address].PublicKey)
+
+		// pages in order until the cursor is nil, each output once
+		pages := func(limit int) []UnspentOutput {
+			var all []UnspentOutput
+			var cursor []byte
+			for {
+				page, next, err := UTXOSet.FindUTXOPage(pubKeyHash, cursor, limit)
+				assert.Nil(t, err)
+				assert.True(t, len(page) <= limit)
+				all = append(all, page...)
+				if next == nil {
+					return all
+				}
+				cursor = next
+			}
+		}
+		all := pages(10)
+		assert.Len(t, all, 4)
+		for i := 1; i < len(all); i++ {
+			assert.True(t, all[i-1].TxID < all[i].TxID, "Ordered by transaction ID")
+		}
+		assert.Equal(t, all, pages(1))
+		assert.Equal(t, all, pages(3))
+		listed := mustUnspent(UTXOSet.ListUnspent(pubKeyHash, 0))
+		sort.Slice(listed, func(i, j int) bool { return listed[i].TxID < listed[j].TxID })
+		assert.Equal(t, listed, all)
+
+		_, _, err := UTXOSet.FindUTXOPage(pubKeyHash, nil, 0)
+		assert.NotNil(t, err)
+		_, next, _ := UTXOSet.FindUTXOPage(pubKeyHash, nil, 2)
+		_, _, err = UTXOSet.FindUTXOPage(HashPubKey(NewWallet().PublicKey), next, 2)
+		assert.NotNil(t, err, "A cursor is bound to its address")
+		page, next, err := UTXOSet.FindUTXOPage(HashPubKey(NewWallet().PublicKey), nil, 2)
+		assert.Nil(t, err)
+		assert.Len(t, page, 0)
+		assert.Nil(t, next)
+
+		// spend every output between two pages: the set changes under the
+		// cursor and the next pages only hold what is left after it
+		first, next, _ := UTXOSet.FindUTXOPage(pubKeyHash, nil, 2)
+		tx, err := NewUTXOTransaction(ws.Wallets[address], address, 4*subsidy, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		UTXOSet.Update(mustMine(bc, []*Transaction{strayCoinbase(), tx}))
+		var rest []UnspentOutput
+		for cursor := next; cursor != nil; {
+			var page []UnspentOutput
+			page, cursor, err = UTXOSet.FindUTXOPage(pubKeyHash, cursor, 2)
+			assert.Nil(t, err)
+			rest = append(rest, page...)
+		}
+		for _, out := range rest {
+			assert.True(t, out.TxID > first[1].TxID, "Nothing before the cursor is returned again")
+			assert.Equal(t, hex.EncodeToString(tx.ID), out.TxID, "Spent outputs are gone")
+		}
+
+		UTXOSet.Reindex()
+		page, _, err = UTXOSet.FindUTXOPage(pubKeyHash, next, 2)
+		assert.Nil(t, err, "The cursor survives a reindex")
+		assert.Equal(t, rest, page)
+	})
+}
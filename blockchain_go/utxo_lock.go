@@ -0,0 +1,153 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+
+	. "../boltqueue"
+	"github.com/boltdb/bolt"
+)
+
+// lockedOutpointPriority is the pending queue priority holding the outputs
+// locked with LockOutpoint, keyed by the serialized outpoint
+const lockedOutpointPriority = 4
+
+// ErrOutpointNotLocked is returned by UnlockOutpoint for an output that
+// isn't locked
+var ErrOutpointNotLocked = errors.New("output not locked")
+
+// LockOutpoint keeps an unspent output out of coin selection until
+// UnlockOutpoint, for manual coin control. The lock is kept in the pending
+// queue of the address owning the output, so it survives restarts; locking
+// an output twice is a no-op. It returns ErrUnknownOutpoint or
+// ErrSpentOutpoint when the output isn't in the UTXO set
+func (u UTXOSet) LockOutpoint(txID []byte, vout int) error {
+	owner, err := u.outpointOwner(txID, vout)
+	if err != nil {
+		return err
+	}
+	queue, err := NewPQueue(PendingQueueFile(GetAddressFromPubkeyHash(owner)))
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+	key := serializeOutpoint(txID, vout)
+
+	return queue.Put(lockedOutpointPriority, key, *NewMessageBytes(key))
+}
+
+// UnlockOutpoint releases an output locked with LockOutpoint. It returns
+// ErrOutpointNotLocked when the output isn't locked; an output spent since
+// it was locked is no longer locked
+func (u UTXOSet) UnlockOutpoint(txID []byte, vout int) error {
+	owner, err := u.outpointOwner(txID, vout)
+	if err == ErrUnknownOutpoint || err == ErrSpentOutpoint {
+		return ErrOutpointNotLocked
+	}
+	if err != nil {
+		return err
+	}
+	queue := openPendingQueue(owner)
+	if queue == nil {
+		return ErrOutpointNotLocked
+	}
+	defer queue.Close()
+
+	found, err := queue.Delete(lockedOutpointPriority, serializeOutpoint(txID, vout))
+	if err == nil && !found {
+		err = ErrOutpointNotLocked
+	}
+
+	return err
+}
+
+// ListLocked returns the outputs of pubKeyHash locked with LockOutpoint
+// that are still unspent, ordered by transaction ID then output index
+func (u UTXOSet) ListLocked(pubKeyHash []byte) ([]UnspentOutput, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	locked := lockedOutpoints(pubKeyHash)
+	if len(locked) == 0 {
+		return nil, nil
+	}
+
+	var unspent []UnspentOutput
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		for outpoint := range locked {
+			txID, _ := hex.DecodeString(outpoint.TxID)
+			vout := outpoint.Vout
+			data := b.Get(txID)
+			if data == nil {
+				continue
+			}
+			outs := cache.decode(gen, txID, data)
+			if vout < len(outs.Outputs) && outs.Outputs[vout].IsLockedWithKey(pubKeyHash) {
+				unspent = append(unspent, UnspentOutput{TxID: outpoint.TxID, Vout: vout, Value: outs.Outputs[vout].Value})
+			}
+		}
+		return nil
+	})
+	if err != nil || len(unspent) == 0 {
+		return nil, err
+	}
+	u.describeUnspent(pubKeyHash, unspent)
+	sort.Slice(unspent, func(i, j int) bool {
+		if unspent[i].TxID != unspent[j].TxID {
+			return unspent[i].TxID < unspent[j].TxID
+		}
+		return unspent[i].Vout < unspent[j].Vout
+	})
+
+	return unspent, nil
+}
+
+// outpointOwner returns the public key hash an unspent output is locked to
+func (u UTXOSet) outpointOwner(txID []byte, vout int) ([]byte, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	var owner []byte
+
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(utxoBucket)).Get(txID)
+		if data == nil {
+			return ErrUnknownOutpoint
+		}
+		outs := cache.decode(gen, txID, data)
+		if vout < 0 || vout >= len(outs.Outputs) {
+			return ErrUnknownOutpoint
+		}
+		if outs.Outputs[vout].isSpent() {
+			return ErrSpentOutpoint
+		}
+		owner = append([]byte(nil), outs.Outputs[vout].PubKeyHash...)
+		return nil
+	})
+
+	return owner, err
+}
+
+// lockedOutpoints returns the outputs locked in the pending queue of
+// pubKeyHash, spent or not
+func lockedOutpoints(pubKeyHash []byte) OutpointSet {
+	locked := make(OutpointSet)
+	queue := openPendingQueue(pubKeyHash)
+	if queue == nil {
+		return locked
+	}
+	entries := queue.GetAll(lockedOutpointPriority)
+	queue.Close()
+
+	for _, entry := range entries {
+		if txID, vout, ok := deserializeOutpoint(entry); ok {
+			locked.Add(txID, vout)
+		}
+	}
+
+	return locked
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockOutpoint(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		wallet := ws.Wallets[address]
+		pubKeyHash := HashPubKey(wallet.PublicKey)
+
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		coinbase := genesis.Transactions[0].ID
+		assert.Nil(t, UTXOSet.LockOutpoint(coinbase, 0))
+		assert.Nil(t, UTXOSet.LockOutpoint(coinbase, 0), "Locking twice is a no-op")
+
+		acc, outputs := mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 1<<30, nil, 1, false))
+		assert.Equal(t, subsidy, acc)
+		assert.Len(t, outputs, 1)
+		assert.NotContains(t, outputs, hex.EncodeToString(coinbase))
+		acc, _ = mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 1<<30, nil, 1, true))
+		assert.Equal(t, 2*subsidy, acc, "The override selects locked outputs")
+		assert.Equal(t, subsidy, mustBalance(UTXOSet.SpendableBalance(pubKeyHash, nil)))
+
+		locked, err := UTXOSet.ListLocked(pubKeyHash)
+		assert.Nil(t, err)
+		assert.Len(t, locked, 1)
+		assert.Equal(t, hex.EncodeToString(coinbase), locked[0].TxID)
+		assert.True(t, locked[0].Locked)
+		assert.Equal(t, 2, locked[0].Confirmations)
+		unspent := mustUnspent(UTXOSet.ListUnspent(pubKeyHash, 0))
+		assert.True(t, unspent[0].Locked)
+		assert.False(t, unspent[1].Locked)
+
+		// no phantom locks
+		assert.Equal(t, ErrUnknownOutpoint, UTXOSet.LockOutpoint([]byte("no such transaction"), 0))
+		assert.Equal(t, ErrUnknownOutpoint, UTXOSet.LockOutpoint(coinbase, 1))
+		assert.Equal(t, ErrUnknownOutpoint, UTXOSet.LockOutpoint(coinbase, -1))
+
+		_, err = NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 2*subsidy, &UTXOSet, nil, 1)
+		assert.Equal(t, ErrNotEnoughFunds, err, "A send can't pick the locked output")
+		tx, err := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), subsidy-3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		block := mustMine(bc, []*Transaction{NewCoinbaseTX(address, ""), tx})
+		UTXOSet.Update(block)
+		assert.Equal(t, ErrUnknownOutpoint, UTXOSet.LockOutpoint(tx.Vin[0].Txid, tx.Vin[0].Vout), "Fully spent transactions leave the set")
+
+		// spend the change, leaving the other output of tx in the set
+		exclude := make(OutpointSet)
+		exclude.Add(block.Transactions[0].ID, 0)
+		change, err := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 3, &UTXOSet, exclude, 1)
+		assert.Nil(t, err)
+		UTXOSet.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(address, ""), change}))
+		assert.Equal(t, ErrSpentOutpoint, UTXOSet.LockOutpoint(tx.ID, 1))
+		assert.Nil(t, UTXOSet.LockOutpoint(tx.ID, 0), "Outputs of other addresses can be locked")
+
+		assert.Nil(t, UTXOSet.UnlockOutpoint(coinbase, 0))
+		assert.Equal(t, ErrOutpointNotLocked, UTXOSet.UnlockOutpoint(coinbase, 0))
+		locked, err = UTXOSet.ListLocked(pubKeyHash)
+		assert.Nil(t, err)
+		assert.Empty(t, locked)
+		acc, _ = mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 1<<30, nil, 1, false))
+		assert.Equal(t, 3*subsidy, acc, "The genesis output and the coinbases of the last two blocks")
+	})
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+)
+
+// DefaultUndoDepth is the number of most recent blocks whose undo records a
+// Blockchain keeps, see SetUndoDepth
+var DefaultUndoDepth = 288
+
+// ErrUndoDataPruned is returned by Undo for a block whose undo record was
+// pruned; the UTXO set must be rebuilt with Reindex instead
+var ErrUndoDataPruned = errors.New("UTXO undo record pruned, the set must be reindexed")
+
+// utxoUndoHeightBucket lists the undo records by height, for pruning. A key
+// is a big endian uint64 block height followed by the block hash, the value
+// is empty. undoPrunedKey holds the height up to which records were pruned
+const utxoUndoHeightBucket = "chainstate_undo_height"
+
+var undoPrunedKey = []byte("pruned")
+
+func undoHeightKey(height int64, hash []byte) []byte {
+	key := make([]byte, 8, 8+len(hash))
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return append(key, hash...)
+}
+
+// SetUndoDepth sets the number of most recent blocks whose undo records are
+// kept, the deepest reorganisation Undo supports. Zero or less keeps them
+// all
+func (bc *Blockchain) SetUndoDepth(depth int) {
+	atomic.StoreInt64(&bc.undoDepth, int64(depth))
+}
+
+// undoHeights returns the height list of the undo records, creating it for
+// records written before it existed
+func undoHeights(tx *bolt.Tx, undo *bolt.Bucket) (*bolt.Bucket, error) {
+	if heights := tx.Bucket([]byte(utxoUndoHeightBucket)); heights != nil {
+		return heights, nil
+	}
+	heights, err := tx.CreateBucket([]byte(utxoUndoHeightBucket))
+	if err != nil {
+		return nil, err
+	}
+	blocks := tx.Bucket([]byte(blocksBucket))
+
+	err = undo.ForEach(func(hash, v []byte) error {
+		data := blocks.Get(hash)
+		if data == nil {
+			return nil
+		}
+		return heights.Put(undoHeightKey(DeserializeBlock(data).Height.Int64(), hash), nil)
+	})
+
+	return heights, err
+}
+
+// PruneUndo deletes the undo records of the blocks more than the undo depth
+// below the last block applied to the set. Undo then returns
+// ErrUndoDataPruned for them
+func (u UTXOSet) PruneUndo() error {
+	depth := atomic.LoadInt64(&u.Blockchain.undoDepth)
+	if depth <= 0 {
+		return nil
+	}
+
+	return u.Blockchain.Db.Update(func(tx *bolt.Tx) error {
+		undo := tx.Bucket([]byte(utxoUndoBucket))
+		if undo == nil {
+			return nil
+		}
+		heights, err := undoHeights(tx, undo)
+		if err != nil {
+			return err
+		}
+
+		c := heights.Cursor()
+		last, _ := c.Last()
+		if last != nil && len(last) < 8 {
+			last, _ = c.Prev() // undoPrunedKey sorts after the heights
+		}
+		if last == nil {
+			return nil
+		}
+		horizon := int64(binary.BigEndian.Uint64(last)) - depth
+		if horizon < 0 {
+			return nil
+		}
+
+		for k, _ := c.First(); k != nil && len(k) >= 8 && int64(binary.BigEndian.Uint64(k)) <= horizon; k, _ = c.First() {
+			if err := undo.Delete(append([]byte(nil), k[8:]...)); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		if undoPruned(heights, horizon) {
+			return nil
+		}
+		var pruned [8]byte
+		binary.BigEndian.PutUint64(pruned[:], uint64(horizon))
+
+		return heights.Put(undoPrunedKey, pruned[:])
+	})
+}
+
+// pruneUndoInBackground starts PruneUndo then PruneBlocks unless they are
+// already running, the next connected block prunes what they would have
+func (u UTXOSet) pruneUndoInBackground() {
+	bc := u.Blockchain
+	if atomic.LoadInt64(&bc.undoDepth) <= 0 || !atomic.CompareAndSwapInt32(&bc.undoPruning, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&bc.undoPruning, 0)
+		if err := u.PruneUndo(); err != nil && err != bolt.ErrDatabaseNotOpen {
+			log.Println("pruning UTXO undo records:", err)
+			return
+		}
+		if _, err := bc.PruneBlocks(); err != nil && err != bolt.ErrDatabaseNotOpen {
+			log.Println("pruning block bodies:", err)
+		}
+	}()
+}
+
+// undoPruned reports whether the undo record of a block at height was pruned
+func undoPruned(heights *bolt.Bucket, height int64) bool {
+	return height <= undoPrunedHeight(heights)
+}
+
+// undoPrunedHeight returns the height up to which undo records were pruned,
+// -1 when none were
+func undoPrunedHeight(heights *bolt.Bucket) int64 {
+	if heights == nil {
+		return -1
+	}
+	pruned := heights.Get(undoPrunedKey)
+	if len(pruned) != 8 {
+		return -1
+	}
+
+	return int64(binary.BigEndian.Uint64(pruned))
+}
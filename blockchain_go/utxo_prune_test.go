@@ -0,0 +1,95 @@
+package core
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneUndo(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		bc.SetUndoDepth(2)
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		blocks := syntheticBlocks(bc, 5)
+		for _, block := range blocks {
+			status, err := bc.AddBlock(block)
+			assert.Nil(t, err)
+			assert.Equal(t, BlockExtendsTip, status)
+		}
+
+		UTXOSet.UpdateBlocks(blocks[:3])
+		atThree := utxoSnapshot(t, UTXOSet)
+		UTXOSet.UpdateBlocks(blocks[3:])
+		assert.Nil(t, UTXOSet.PruneUndo())
+
+		// heights 4 and 5 are kept, the reorganisation can go down to 3
+		assert.Nil(t, UTXOSet.Undo(blocks[4]))
+		assert.Nil(t, UTXOSet.Undo(blocks[3]))
+		assert.Equal(t, atThree, utxoSnapshot(t, UTXOSet))
+		assert.Equal(t, ErrUndoDataPruned, UTXOSet.Undo(blocks[2]))
+		assert.Equal(t, atThree, utxoSnapshot(t, UTXOSet))
+
+		// records written before the height list existed are pruned too
+		UTXOSet.UpdateBlocks(blocks[3:])
+		bc.Db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte(utxoUndoHeightBucket))
+		})
+		bc.SetUndoDepth(1)
+		assert.Nil(t, UTXOSet.PruneUndo())
+		assert.Equal(t, ErrUndoDataPruned, UTXOSet.Undo(blocks[3]))
+		bc.Db.View(func(tx *bolt.Tx) error {
+			assert.Equal(t, 1, tx.Bucket([]byte(utxoUndoBucket)).Stats().KeyN)
+			return nil
+		})
+
+		bc.SetUndoDepth(0)
+		assert.Nil(t, UTXOSet.PruneUndo())
+		assert.Nil(t, UTXOSet.Undo(blocks[4]), "Depth 0 keeps every record")
+	})
+}
+
+func TestUndoBlocksAtPruningBoundary(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		bc.SetUndoDepth(2)
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		var hashes []string
+		var atOne map[Outpoint]TXOutput
+		for i := 0; i < 3; i++ {
+			block := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+			UTXOSet.Update(block)
+			hashes = append(hashes, hex.EncodeToString(block.Hash))
+			if i == 0 {
+				atOne = utxoSnapshot(t, UTXOSet)
+			}
+		}
+		assert.Nil(t, UTXOSet.PruneUndo())
+
+		// a fork at height 1 disconnects the two blocks above it
+		fork := make(map[string][]byte)
+		for _, hash := range hashes[1:] {
+			fork[hash], _ = hex.DecodeString(hash)
+		}
+		assert.Nil(t, UTXOSet.UndoBlocks(fork))
+		assert.Equal(t, atOne, utxoSnapshot(t, UTXOSet))
+
+		// one block deeper is past the horizon
+		for _, hash := range hashes[1:] {
+			block, _ := bc.GetBlock(fork[hash])
+			UTXOSet.Update(&block)
+		}
+		assert.Nil(t, UTXOSet.PruneUndo())
+		fork[hashes[0]], _ = hex.DecodeString(hashes[0])
+		assert.Equal(t, ErrUndoDataPruned, UTXOSet.UndoBlocks(fork))
+	})
+}
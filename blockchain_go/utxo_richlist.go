@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+
+	"github.com/boltdb/bolt"
+)
+
+// AddressBalance is the balance of a public key hash in the UTXO set
+type AddressBalance struct {
+	Address    string `json:"address"` // Base58Check
+	PubKeyHash []byte `json:"-"`
+	Balance    int64  `json:"balance"`
+	Outputs    int    `json:"utxos"`
+}
+
+// TopBalances returns the n public key hashes with the highest balances,
+// highest first, ties ordered by public key hash. It sums the outputs of one
+// owner after the other along the owner index, keeping the n best in a heap,
+// or scans the whole set when it has no index yet. The lock is only held to
+// open the bolt read transaction, which reads the set as it was then while
+// blocks keep being applied
+func (u UTXOSet) TopBalances(n int) ([]AddressBalance, error) {
+	if n <= 0 {
+		return nil, errors.New("the number of addresses must be positive")
+	}
+	u.Blockchain.utxoMu.RLock()
+	tx, err := u.Blockchain.Db.Begin(false)
+	u.Blockchain.utxoMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	top := &balanceHeap{}
+	offer := func(balance AddressBalance) {
+		if top.Len() < n {
+			heap.Push(top, balance)
+		} else if balanceLess((*top)[0], balance) {
+			(*top)[0] = balance
+			heap.Fix(top, 0)
+		}
+	}
+	if index := tx.Bucket([]byte(utxoAddrBucket)); index != nil {
+		topBalancesIndexed(tx, index, offer)
+	} else {
+		topBalancesScan(tx, offer)
+	}
+
+	balances := make([]AddressBalance, top.Len())
+	for i := len(balances) - 1; i >= 0; i-- {
+		balances[i] = heap.Pop(top).(AddressBalance)
+		balances[i].PubKeyHash = append([]byte(nil), balances[i].PubKeyHash...)
+		balances[i].Address = string(GetAddressFromPubkeyHash(balances[i].PubKeyHash))
+	}
+
+	return balances, nil
+}
+
+// topBalancesIndexed offers the balance of each owner in the index, reading
+// the outputs of a transaction once for consecutive keys
+func topBalancesIndexed(tx *bolt.Tx, index *bolt.Bucket, offer func(AddressBalance)) {
+	b := tx.Bucket([]byte(utxoBucket))
+
+	var current AddressBalance
+	var prefix, txID []byte
+	var outs TXOutputs
+	c := index.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		size := int(k[0])
+		if len(k) < 1+size+4 {
+			continue
+		}
+		if !bytes.Equal(k[:1+size], prefix) {
+			if current.Outputs > 0 {
+				offer(current)
+			}
+			prefix = k[:1+size]
+			current = AddressBalance{PubKeyHash: k[1 : 1+size]}
+		}
+		id := k[1+size : len(k)-4]
+		vout := int(binary.BigEndian.Uint32(k[len(k)-4:]))
+		if !bytes.Equal(id, txID) {
+			txID = id
+			outs = TXOutputs{}
+			if data := b.Get(id); data != nil {
+				outs = DeserializeOutputs(data)
+			}
+		}
+		if vout >= len(outs.Outputs) || !outs.Outputs[vout].IsLockedWithKey(current.PubKeyHash) {
+			continue
+		}
+		current.Balance += int64(outs.Outputs[vout].Value)
+		current.Outputs++
+	}
+	if current.Outputs > 0 {
+		offer(current)
+	}
+}
+
+// topBalancesScan offers the balance of each owner in the UTXO bucket
+func topBalancesScan(tx *bolt.Tx, offer func(AddressBalance)) {
+	b := tx.Bucket([]byte(utxoBucket))
+	if b == nil {
+		return
+	}
+	balances := make(map[string]*AddressBalance)
+	b.ForEach(func(k, v []byte) error {
+		for _, out := range DeserializeOutputs(v).Outputs {
+			if out.isSpent() {
+				continue
+			}
+			balance, ok := balances[string(out.PubKeyHash)]
+			if !ok {
+				balance = &AddressBalance{PubKeyHash: out.PubKeyHash}
+				balances[string(out.PubKeyHash)] = balance
+			}
+			balance.Balance += int64(out.Value)
+			balance.Outputs++
+		}
+		return nil
+	})
+	for _, balance := range balances {
+		offer(*balance)
+	}
+}
+
+// balanceLess reports whether a ranks below b: a lower balance, or the same
+// balance and a greater public key hash
+func balanceLess(a, b AddressBalance) bool {
+	if a.Balance != b.Balance {
+		return a.Balance < b.Balance
+	}
+
+	return bytes.Compare(a.PubKeyHash, b.PubKeyHash) > 0
+}
+
+// balanceHeap is a min-heap of balances, the lowest ranked at the root
+type balanceHeap []AddressBalance
+
+func (h balanceHeap) Len() int            { return len(h) }
+func (h balanceHeap) Less(i, j int) bool  { return balanceLess(h[i], h[j]) }
+func (h balanceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *balanceHeap) Push(x interface{}) { *h = append(*h, x.(AddressBalance)) }
+func (h *balanceHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return x
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopBalances(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		assert.Nil(t, fillUTXOSet(UTXOSet, 300, 3, 40))
+
+		// every balance of the set, ranked by sorting
+		var expected []AddressBalance
+		for i := 0; i < 40; i++ {
+			expected = append(expected, AddressBalance{PubKeyHash: benchPubKeyHash(i)})
+		}
+		for i := 0; i < 300*3; i++ {
+			expected[i%40].Balance += int64(i%3 + 1)
+			expected[i%40].Outputs++
+		}
+		pubKeyHash := HashPubKey(ws.Wallets[address].PublicKey)
+		expected = append(expected, AddressBalance{PubKeyHash: pubKeyHash, Balance: 3 * subsidy, Outputs: 3})
+		sort.Slice(expected, func(i, j int) bool {
+			if expected[i].Balance != expected[j].Balance {
+				return expected[i].Balance > expected[j].Balance
+			}
+			return bytes.Compare(expected[i].PubKeyHash, expected[j].PubKeyHash) < 0
+		})
+		for i := range expected {
+			expected[i].Address = string(GetAddressFromPubkeyHash(expected[i].PubKeyHash))
+		}
+		assert.Equal(t, address, expected[0].Address)
+
+		top, err := UTXOSet.TopBalances(5)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[:5], top)
+		top, err = UTXOSet.TopBalances(100)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, top, "Every address when there are fewer than n")
+		_, err = UTXOSet.TopBalances(0)
+		assert.NotNil(t, err)
+
+		// without the index the set is scanned, with the same ranking
+		bc.Db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte(utxoAddrBucket))
+		})
+		top, err = UTXOSet.TopBalances(17)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[:17], top)
+	})
+}
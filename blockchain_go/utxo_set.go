@@ -1,117 +1,571 @@
 package core
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"encoding/hex"
+	"errors"
+	"io"
 	"log"
+	"strings"
 
 	"github.com/boltdb/bolt"
-	"math"
 	"fmt"
 	."../boltqueue"
-	"bytes"
 	"math/big"
 	"os"
+	"sort"
 )
 
 const utxoBucket = "chainstate"
 
-var MineNow_ = false
+// utxoUndoBucket holds the outputs each block spent, by block hash
+const utxoUndoBucket = "chainstate_undo"
+
+// utxoReindexBucket holds the UTXO set being rebuilt by ReindexContext or
+// LoadSnapshot
+const utxoReindexBucket = "chainstate_reindex"
+
+// utxoTipBucket holds the hash of the block the UTXO set was last brought
+// to under utxoTipKey, written in the same bolt transactions as the set
+const utxoTipBucket = "chainstate_tip"
+
+var utxoTipKey = []byte("l")
+
+// reindexBatchSize is the number of outputs written per bolt transaction
+// while the UTXO set is rebuilt
+const reindexBatchSize = 5000
+
+// MempoolTransactions returns the unconfirmed transactions the node knows
+// of, for GetBalance. It is set by the p2pprotocol package; nil means no
+// mempool
+var MempoolTransactions func() []*Transaction
+
+// pendingOutpointPriority is the pending queue priority holding the outputs
+// spent by unconfirmed transactions, see PendingIn
+const pendingOutpointPriority = 3
 
 // UTXOSet represents UTXO set
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-// FindSpendableOutputs finds and returns unspent outputs to reference in inputs
-func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int,minerCheck bool,spendTxid []byte) (int, map[string][]int) {
+// FindSpendableOutputs selects unspent outputs of pubKeyHash with at least
+// minConf confirmations worth at least amount, skipping the outputs in
+// exclude. Callers building a transaction pass PendingOutpoints along with
+// the inputs of transactions not yet recorded there, so two quick sends
+// never pick the same output. With minConf 0 the change of the mempool
+// transactions spending outputs of pubKeyHash is selected too, after the
+// outputs in the set, so a transaction can spend the change of one not yet
+// mined. Coinbase maturity isn't enforced by block validation yet, so
+// immature coinbase outputs are selected like any other; ListUnspent flags
+// them. Outputs locked with LockOutpoint are skipped unless includeLocked
+// is set
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int, exclude OutpointSet, minConf int, includeLocked bool) (int, map[string][]int, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
+	var locked OutpointSet
+	if !includeLocked {
+		locked = lockedOutpoints(pubKeyHash)
+	}
+	take := func(txID []byte, vout, value int) bool {
+		if accumulated >= amount {
+			return false
+		}
+		if !exclude.Has(txID, vout) && !locked.Has(txID, vout) {
+			accumulated += value
+			key := hex.EncodeToString(txID)
+			unspentOutputs[key] = append(unspentOutputs[key], vout)
+		}
+		return true
+	}
+
+	if minConf > 1 {
+		// every output in the set has a confirmation, more need the heights
+		unspent, err := u.unspentOutputs(pubKeyHash)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, out := range unspent {
+			txID, _ := hex.DecodeString(out.TxID)
+			if out.Confirmations >= minConf && !take(txID, out.Vout, out.Value) {
+				break
+			}
+		}
+		return accumulated, unspentOutputs, nil
+	}
+
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		forEachOwned(tx, cache, gen, pubKeyHash, func(txID []byte, vout int, out TXOutput) bool {
+			return take(txID, vout, out.Value)
+		})
+
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if minConf <= 0 {
+		for _, out := range mempoolChange(pubKeyHash) {
+			txID, _ := hex.DecodeString(out.TxID)
+			if !take(txID, out.Vout, out.Value) {
+				break
+			}
+		}
+	}
 
-	log.Println("--start  FindSpendableOutputs ")
-	queueFile := fmt.Sprintf("%x_tx.db", GetAddressFromPubkeyHash(pubkeyHash))
-	txPQueue, errcq := NewPQueue(queueFile)
-	//defer txPQueue.Close()
-	defer os.Remove(queueFile)
+	return accumulated, unspentOutputs, nil
+}
 
-	if errcq != nil {
-		log.Panic("create queue error",errcq)
+// mempoolChange returns the outputs paying to pubKeyHash of the mempool
+// transactions spending outputs of pubKeyHash, which no other mempool
+// transaction spends, ordered by transaction ID then output index
+func mempoolChange(pubKeyHash []byte) []UnspentOutput {
+	if MempoolTransactions == nil {
+		return nil
 	}
-	qsize, errqs := txPQueue.Size(1)
-	if (errqs != nil) {
-		fmt.Printf("get pending tx queue size error %s \n", errqs)
+	txs := MempoolTransactions()
+	spent := make(OutpointSet)
+	for _, tx := range txs {
+		spent.AddInputs(tx)
 	}
-	fmt.Printf("pending tx queue size %d \n", qsize)
 
-	log.Println("--start  FindSpendableOutputs  u.Blockchain.Db View")
+	var change []UnspentOutput
+	for _, tx := range txs {
+		if tx.IsCoinbase() || len(tx.Vin) == 0 || !bytes.Equal(HashPubKey(tx.Vin[0].PubKey), pubKeyHash) {
+			continue
+		}
+		for vout, out := range tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) && !spent.Has(tx.ID, vout) {
+				change = append(change, UnspentOutput{TxID: hex.EncodeToString(tx.ID), Vout: vout, Value: out.Value})
+			}
+		}
+	}
+	sort.Slice(change, func(i, j int) bool {
+		if change[i].TxID != change[j].TxID {
+			return change[i].TxID < change[j].TxID
+		}
+		return change[i].Vout < change[j].Vout
+	})
+
+	return change
+}
+
+// SpendableBalance sums the unspent outputs of pubKeyHash that are neither
+// in exclude nor locked, the amount FindSpendableOutputs can select from
+func (u UTXOSet) SpendableBalance(pubKeyHash []byte, exclude OutpointSet) (int, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	balance := 0
+	locked := lockedOutpoints(pubKeyHash)
+
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		forEachOwned(tx, cache, gen, pubKeyHash, func(txID []byte, vout int, out TXOutput) bool {
+			if !exclude.Has(txID, vout) && !locked.Has(txID, vout) {
+				balance += out.Value
+			}
+			return true
+		})
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// PendingOutpoints returns the outputs of pubKeyHash that transactions
+// recorded with PendingIn spend and that are still in the UTXO set, i.e.
+// whose spending transaction isn't mined yet
+func (u UTXOSet) PendingOutpoints(pubKeyHash []byte) (OutpointSet, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+
+	return u.pendingOutpoints(pubKeyHash)
+}
+
+func (u UTXOSet) pendingOutpoints(pubKeyHash []byte) (OutpointSet, error) {
+	pending := make(OutpointSet)
+	queue := openPendingQueue(pubKeyHash)
+	if queue == nil {
+		return pending, nil
+	}
+	entries := queue.GetAll(pendingOutpointPriority)
+	queue.Close()
+
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
 	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
-			//ignore pending tx
-			fmt.Printf("UTXO txID %s \n", txID)
-			if(minerCheck||(qsize==0||MineNow_ || !txPQueue.IsExist(1,k))) {
-				//miner check transaction is legal or not
-				if(minerCheck&&!bytes.Equal(spendTxid,k)){
-					continue
-				}
-				for outIdx, out := range outs.Outputs {
-					if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
-						fmt.Printf("out.Value %d \n", out.Value)
-						accumulated += out.Value
-						unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-					}
+		for _, entry := range entries {
+			txID, vout, ok := deserializeOutpoint(entry)
+			if !ok {
+				continue
+			}
+			if data := b.Get(txID); data != nil {
+				outs := cache.decode(gen, txID, data)
+				if vout < len(outs.Outputs) && !outs.Outputs[vout].isSpent() {
+					pending.Add(txID, vout)
 				}
-			}else{
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-				fmt.Println("--->  exist txid: ", txID)
+	return pending, nil
+}
+
+// CoinbaseMaturity is the number of confirmations a coinbase output needs
+// before it is considered mature
+const CoinbaseMaturity = 100
+
+// UnspentOutput describes a single unspent output for coin control
+type UnspentOutput struct {
+	TxID          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Value         int    `json:"value"`
+	Confirmations int    `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase"`
+	Immature      bool   `json:"immature"` // coinbase with less than CoinbaseMaturity confirmations
+	Reserved      bool   `json:"reserved"` // spent by a pending transaction
+	Locked        bool   `json:"locked"`   // locked with LockOutpoint
+}
+
+// findMempoolTransaction returns the mempool transaction with the given ID
+func findMempoolTransaction(ID []byte) (Transaction, error) {
+	if MempoolTransactions != nil {
+		for _, tx := range MempoolTransactions() {
+			if bytes.Equal(tx.ID, ID) {
+				return *tx, nil
 			}
 		}
+	}
+
+	return Transaction{}, errors.New("Transaction is not found")
+}
+
+// ListUnspent returns the unspent outputs locked to pubKeyHash with at least
+// minConfirmations confirmations, oldest first. With 0 the mempool change
+// FindSpendableOutputs may select is listed too, unconfirmed
+func (u UTXOSet) ListUnspent(pubKeyHash []byte, minConfirmations int) ([]UnspentOutput, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	unspent, err := u.unspentOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	if minConfirmations <= 0 {
+		unspent = append(unspent, mempoolChange(pubKeyHash)...)
+	}
+
+	result := unspent[:0]
+	for _, out := range unspent {
+		if out.Confirmations >= minConfirmations {
+			result = append(result, out)
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Confirmations > result[j].Confirmations
+	})
+
+	return result, nil
+}
+
+// unspentOutputs returns the unspent outputs locked to pubKeyHash with their
+// confirmations and flags, in no particular order
+func (u UTXOSet) unspentOutputs(pubKeyHash []byte) ([]UnspentOutput, error) {
+	var unspent []UnspentOutput
+
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		forEachOwned(tx, cache, gen, pubKeyHash, func(txID []byte, vout int, out TXOutput) bool {
+			unspent = append(unspent, UnspentOutput{
+				TxID:  hex.EncodeToString(txID),
+				Vout:  vout,
+				Value: out.Value,
+			})
+			return true
+		})
+
+		return nil
+	})
+	if err != nil || len(unspent) == 0 {
+		return nil, err
+	}
+	if err := u.describeUnspent(pubKeyHash, unspent); err != nil {
+		return nil, err
+	}
+
+	return unspent, nil
+}
+
+// FindUTXOPage returns up to limit unspent outputs of pubKeyHash, ordered by
+// transaction ID then output index, with a cursor to pass for the next page;
+// the cursor is nil after the last page. Pass a nil cursor for the first
+// page. The cursor is a position in that order, so it stays valid when the
+// set changes between pages or is reindexed: an output spent in between
+// isn't returned, one added before the cursor is missed by the later pages
+// and one added after it is returned. No output is returned twice
+func (u UTXOSet) FindUTXOPage(pubKeyHash, cursor []byte, limit int) ([]UnspentOutput, []byte, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	if limit <= 0 {
+		return nil, nil, errors.New("page limit must be positive")
+	}
+	if cursor != nil && !bytes.HasPrefix(cursor, addrIndexPrefix(pubKeyHash)) {
+		return nil, nil, errors.New("the cursor belongs to another address")
+	}
+
+	var page []UnspentOutput
+	var last, next []byte
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		walkOwned(tx, cache, gen, pubKeyHash, cursor, func(key, txID []byte, vout int, out TXOutput) bool {
+			if len(page) == limit {
+				// there are more outputs, the next page starts after this one
+				next = last
+				return false
+			}
+			page = append(page, UnspentOutput{
+				TxID:  hex.EncodeToString(txID),
+				Vout:  vout,
+				Value: out.Value,
+			})
+			last = append([]byte(nil), key...)
+			return true
+		})
+
+		return nil
+	})
+	if err != nil || len(page) == 0 {
+		return nil, nil, err
+	}
+	if err := u.describeUnspent(pubKeyHash, page); err != nil {
+		return nil, nil, err
+	}
+
+	return page, next, nil
+}
+
+// describeUnspent sets the confirmations and flags of unspent outputs of
+// pubKeyHash, from the heights stored in the set
+func (u UTXOSet) describeUnspent(pubKeyHash []byte, unspent []UnspentOutput) error {
+	type origin struct {
+		height   int64
+		coinbase bool
+	}
+	origins := make(map[string]origin)
+	missing := 0
+	tipHeight := int64(-1)
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		tipHeight = DeserializeBlock(blocks.Get(blocks.Get([]byte("l")))).Height.Int64()
+		b := tx.Bucket([]byte(utxoBucket))
+
+		for _, out := range unspent {
+			if _, ok := origins[out.TxID]; ok {
+				continue
+			}
+			o := origin{height: -1}
+			txID, _ := hex.DecodeString(out.TxID)
+			if data := b.Get(txID); data != nil {
+				if outs := cache.decode(gen, txID, data); outs.heightKnown() {
+					o = origin{outs.Height, outs.Coinbase}
+				}
+			}
+			if o.height < 0 {
+				missing++
+			}
+			origins[out.TxID] = o
+		}
 
 		return nil
 	})
-	txPQueue.Close()
-	log.Println("--after  FindSpendableOutputs ")
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	return accumulated, unspentOutputs
+	// entries written before the heights were stored: find the blocks
+	// holding the transactions, walking back from the tip
+	bci := u.Blockchain.Iterator()
+	for missing > 0 {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
+			if o, ok := origins[txID]; ok && o.height < 0 {
+				origins[txID] = origin{height: block.Height.Int64(), coinbase: tx.IsCoinbase()}
+				missing--
+			}
+		}
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	pending, err := u.pendingOutpoints(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	locked := lockedOutpoints(pubKeyHash)
+
+	for i := range unspent {
+		out := &unspent[i]
+		o := origins[out.TxID]
+		// a set applied past the stored chain tip counts as unconfirmed
+		if o.height >= 0 && o.height <= tipHeight {
+			out.Confirmations = int(tipHeight - o.height + 1)
+		}
+		out.Coinbase = o.coinbase
+		out.Immature = o.coinbase && out.Confirmations < CoinbaseMaturity
+		txID, _ := hex.DecodeString(out.TxID)
+		out.Reserved = pending.Has(txID, out.Vout)
+		out.Locked = locked.Has(txID, out.Vout)
+	}
+
+	return nil
+}
+
+// openPendingQueue opens the pending transaction queue of pubKeyHash, or
+// returns nil when there is none
+func openPendingQueue(pubKeyHash []byte) *PQueue {
+	queueFile := PendingQueueFile(GetAddressFromPubkeyHash(pubKeyHash))
+	if _, err := os.Stat(queueFile); os.IsNotExist(err) {
+		return nil
+	}
+	queue, err := NewPQueue(queueFile)
+	if err != nil {
+		log.Println("open pending tx queue:", err)
+		return nil
+	}
+
+	return queue
 }
 
 // FindUTXO finds UTXO for a public key hash
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TXOutput, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
 	var UTXOs []TXOutput
 	db := u.Blockchain.Db
 
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
 	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
+		forEachOwned(tx, cache, gen, pubKeyHash, func(txID []byte, vout int, out TXOutput) bool {
+			UTXOs = append(UTXOs, out)
+			return true
+		})
+
+		return nil
+	})
+	fmt.Printf("len(UTXOs) of : %d\n", len(UTXOs))
+	if err != nil {
+		return nil, err
+	}
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
+	return UTXOs, nil
+}
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
+// GetBalances sums the unspent outputs of several public key hashes in a
+// single bolt transaction, keyed by hex encoded public key hash
+func (u UTXOSet) GetBalances(pubKeyHashes [][]byte) (map[string]int64, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	balances := make(map[string]int64, len(pubKeyHashes))
+
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		for _, pubKeyHash := range pubKeyHashes {
+			key := hex.EncodeToString(pubKeyHash)
+			if _, ok := balances[key]; ok {
+				continue
 			}
+			balances[key] = 0
+			forEachOwned(tx, cache, gen, pubKeyHash, func(txID []byte, vout int, out TXOutput) bool {
+				balances[key] += int64(out.Value)
+				return true
+			})
 		}
 
 		return nil
 	})
-	fmt.Printf("len(UTXOs) of : %d\n", len(UTXOs))
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return UTXOs
+	return balances, nil
+}
+
+// GetBalance returns the balance of pubKeyHash in two parts: confirmed sums
+// its unspent outputs with at least minConf confirmations; pending is what
+// the unconfirmed activity adds to that, the outputs with fewer
+// confirmations plus the mempool outputs paying to pubKeyHash, minus the
+// outputs spent by transactions in its pending queue or in the mempool. It
+// may be negative
+func (u UTXOSet) GetBalance(pubKeyHash []byte, minConf int) (confirmed, pending int64, err error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	unspent, err := u.unspentOutputs(pubKeyHash)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	spentByMempool := make(OutpointSet)
+	if MempoolTransactions != nil {
+		for _, tx := range MempoolTransactions() {
+			spentByMempool.AddInputs(tx)
+			for _, out := range tx.Vout {
+				if out.IsLockedWithKey(pubKeyHash) {
+					pending += int64(out.Value)
+				}
+			}
+		}
+	}
+
+	for _, out := range unspent {
+		if out.Confirmations >= minConf {
+			confirmed += int64(out.Value)
+		} else {
+			pending += int64(out.Value)
+		}
+		txID, _ := hex.DecodeString(out.TxID)
+		if out.Reserved || spentByMempool.Has(txID, out.Vout) {
+			pending -= int64(out.Value)
+		}
+	}
+
+	return confirmed, pending, nil
 }
 
 // CountTransactions returns the number of transactions in the UTXO set
-func (u UTXOSet) CountTransactions() int {
+func (u UTXOSet) CountTransactions() (int, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
 	db := u.Blockchain.Db
 	counter := 0
 
@@ -126,169 +580,642 @@ func (u UTXOSet) CountTransactions() int {
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return 0, err
 	}
 
-	return counter
+	return counter, nil
 }
 
 // Reindex rebuilds the UTXO set
-func (u UTXOSet) Reindex() {
-	db := u.Blockchain.Db
-	bucketName := []byte(utxoBucket)
+func (u UTXOSet) Reindex() error {
+	return u.ReindexContext(context.Background(), nil)
+}
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
+// ReindexContext rebuilds the UTXO set from the chain into a temporary
+// bucket, written in batches of reindexBatchSize outputs, and replaces the
+// set with it at the end. progress, when set, is called after each block with
+// the number of blocks walked, the chain length and the outputs written so
+// far. When ctx is done the temporary bucket is dropped, the set is left as it
+// was and ctx.Err() is returned. A pruned chain returns ErrChainPruned
+func (u UTXOSet) ReindexContext(ctx context.Context, progress func(blocks, total, outputs int)) error {
+	pruned, err := u.Blockchain.PrunedHeight()
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		return ErrChainPruned
+	}
+	builder, err := u.newBuilder()
+	if err != nil {
+		return err
+	}
+	builder.tip = u.Blockchain.tip
+
+	height, _, err := u.Blockchain.GetBestHeightLastHash()
+	if err != nil {
+		return err
+	}
+	total := int(height.Int64()) + 1
+	blocks := 0
+	err = u.Blockchain.walkUTXO(ctx, func(block *Block, unspent map[string]TXOutputs) error {
+		for txID, outs := range unspent {
+			if err := builder.add(txID, outs); err != nil {
+				return err
+			}
+		}
+		blocks++
+		if progress != nil {
+			progress(blocks, total, builder.outputs)
+		}
+		return nil
+	})
+	if err != nil {
+		builder.drop()
+		return err
+	}
+
+	return builder.commit()
+}
+
+// utxoBuilder builds a UTXO set in a temporary bucket, written in batches of
+// reindexBatchSize outputs, then replaces the set and its index with it in
+// one go
+type utxoBuilder struct {
+	u            UTXOSet
+	batch        map[string]TXOutputs // keyed by hex encoded transaction ID
+	batchOutputs int
+	outputs      int
+	tip          []byte // the block the new set is at, see BestBlock
+}
+
+// newBuilder starts a build, dropping what an interrupted one left
+func (u UTXOSet) newBuilder() (*utxoBuilder, error) {
+	err := u.Blockchain.Db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(utxoReindexBucket))
 		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+			return err
 		}
+		_, err = tx.CreateBucket([]byte(utxoReindexBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &utxoBuilder{u: u, batch: make(map[string]TXOutputs)}, nil
+}
+
+// add stores the outputs of a transaction in the new set
+func (bl *utxoBuilder) add(txID string, outs TXOutputs) error {
+	bl.batch[txID] = outs
+	bl.batchOutputs += len(outs.Outputs)
+	bl.outputs += len(outs.Outputs)
+	if bl.batchOutputs < reindexBatchSize {
+		return nil
+	}
+
+	return bl.u.Blockchain.Db.Update(bl.flush)
+}
 
-		_, err = tx.CreateBucket(bucketName)
+func (bl *utxoBuilder) flush(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte(utxoReindexBucket))
+	for txID, outs := range bl.batch {
+		key, err := hex.DecodeString(txID)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
+		if err := b.Put(key, outs.Serialize()); err != nil {
+			return err
+		}
+	}
+	bl.batch = make(map[string]TXOutputs)
+	bl.batchOutputs = 0
 
-		return nil
+	return nil
+}
+
+// drop abandons the build, leaving the set as it was
+func (bl *utxoBuilder) drop() {
+	err := bl.u.Blockchain.Db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(utxoReindexBucket))
 	})
 	if err != nil {
-		log.Panic(err)
+		log.Println("dropping the temporary UTXO bucket:", err)
 	}
+}
 
-	UTXO := u.Blockchain.FindUTXO()
+// commit replaces the set with the new one. bolt can't rename buckets, the
+// final copy swaps the sets atomically
+func (bl *utxoBuilder) commit() error {
+	bl.u.Blockchain.utxoMu.Lock()
+	defer bl.u.Blockchain.utxoMu.Unlock()
+	cache := bl.u.Blockchain.utxoCache
+	if cache != nil {
+		cache.writeMu.Lock()
+		defer cache.writeMu.Unlock()
+	}
+	cache.begin()
+	defer cache.reset()
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+	return bl.u.Blockchain.Db.Update(func(tx *bolt.Tx) error {
+		if err := bl.flush(tx); err != nil {
+			return err
+		}
+		err := tx.DeleteBucket([]byte(utxoBucket))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket([]byte(utxoReindexBucket)).ForEach(func(k, v []byte) error {
+			return b.Put(k, v)
+		})
+		if err != nil {
+			return err
+		}
+		if err := rebuildAddrIndex(tx); err != nil {
+			return err
+		}
+		if err := rebuildUTXOHash(tx); err != nil {
+			return err
+		}
+		if err := storeUTXOTip(tx, bl.tip); err != nil {
+			return err
+		}
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
-			}
+		return tx.DeleteBucket([]byte(utxoReindexBucket))
+	})
+}
 
-			err = b.Put(key, outs.Serialize())
-			if err != nil {
-				log.Panic(err)
+// Update applies a block connected to the tip in a single bolt
+// transaction: the outputs it spends are removed and the outputs it creates
+// added. The spent outputs are kept in an undo record of the block for
+// Undo. A block that was already applied is ignored
+func (u UTXOSet) Update(block *Block) error {
+	return u.UpdateBlocks([]*Block{block})
+}
+
+// UpdateBlocks applies consecutive blocks, oldest first, as Update does but
+// in a single bolt transaction, saving a commit per block during an import
+func (u UTXOSet) UpdateBlocks(blocks []*Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	err := u.write(func(tx *bolt.Tx, w *utxoWriter) error {
+		undo, err := tx.CreateBucketIfNotExists([]byte(utxoUndoBucket))
+		if err != nil {
+			return err
+		}
+		heights, err := undoHeights(tx, undo)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if err := applyBlock(w, undo, heights, block); err != nil {
+				return err
 			}
 		}
 
-		return nil
+		return storeUTXOTip(tx, blocks[len(blocks)-1].Hash)
 	})
+	if err != nil {
+		return err
+	}
+	u.pruneUndoInBackground()
+
+	return nil
 }
 
-// Update updates the UTXO set with transactions from the Block
-// The Block is considered to be the tip of a blockchain
-func (u UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Db
+// storeUTXOTip records the block the UTXO set is at, nothing when tip is
+// unknown
+func storeUTXOTip(tx *bolt.Tx, tip []byte) error {
+	if len(tip) == 0 {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(utxoTipBucket))
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("--->update utxo \n")
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+	return b.Put(utxoTipKey, tip)
+}
 
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-
-					fmt.Printf("vin.Txid %x \n", vin.Txid)
-					outs := tx.Vout
-
-					fmt.Printf("len(outs.Outputs) %x \n", len(outs))
-					for outidx, out := range outs {
-						//if outidx != vin.Vout {
-							fmt.Printf("outidx %d \n", outidx)
-							fmt.Printf("vin.Vout %d \n", out.Value)
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						//}
-					}
-					fmt.Printf("len(updatedOuts.Outputs) %x \n", len(updatedOuts.Outputs))
-
-
-					if len(updatedOuts.Outputs) != 0 {
-						err := b.Put(tx.ID, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
-					err := b.Delete(vin.Txid)
-					if err != nil {
-						log.Panic(err)
-					}
+// utxoTipOf returns the block the UTXO set is at, see BestBlock
+func utxoTipOf(tx *bolt.Tx) []byte {
+	if b := tx.Bucket([]byte(utxoTipBucket)); b != nil {
+		return b.Get(utxoTipKey)
+	}
 
-				}
-			}else{
+	return nil
+}
 
-				newOutputs := TXOutputs{}
-				for _, out := range tx.Vout {
-					newOutputs.Outputs = append(newOutputs.Outputs, out)
-				}
+// BestBlock returns the hash of the block the UTXO set was last brought to
+// by Update, Undo, Reindex or LoadSnapshot, nil when the set was written
+// before it was recorded. The set and the record change in the same bolt
+// transaction, so after a crash it tells which block the set is at, which
+// may be behind the chain tip when a block was stored without BlockImporter
+func (u UTXOSet) BestBlock() ([]byte, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	var tip []byte
+
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		tip = append([]byte(nil), utxoTipOf(tx)...)
+		return nil
+	})
+	if err != nil || len(tip) == 0 {
+		return nil, err
+	}
 
-				err := b.Put(tx.ID, newOutputs.Serialize())
-				if err != nil {
-					log.Panic(err)
+	return tip, nil
+}
+
+func applyBlock(w *utxoWriter, undo, heights *bolt.Bucket, block *Block) error {
+	if undo.Get(block.Hash) != nil {
+		return nil
+	}
+
+	var spent []spentOutput
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, vin := range t.Vin {
+				outs, ok := w.get(vin.Txid)
+				if !ok || vin.Vout >= len(outs.Outputs) || outs.Outputs[vin.Vout].isSpent() {
+					log.Printf("UTXO update: output %x:%d of block %x is not unspent", vin.Txid, vin.Vout, block.Hash)
+					continue
+				}
+				spent = append(spent, spentOutput{vin.Txid, vin.Vout, outs.Outputs[vin.Vout], outs.Height, outs.Coinbase})
+				outs.Outputs[vin.Vout] = TXOutput{}
+				if err := w.put(vin.Txid, outs); err != nil {
+					return err
 				}
 			}
 		}
 
-		return nil
+		newOutputs := TXOutputs{Height: block.Height.Int64(), Coinbase: t.IsCoinbase()}
+		newOutputs.Outputs = append(newOutputs.Outputs, t.Vout...)
+		if err := w.put(t.ID, newOutputs); err != nil {
+			return err
+		}
+	}
+
+	if err := heights.Put(undoHeightKey(block.Height.Int64(), block.Hash), nil); err != nil {
+		return err
+	}
+
+	return undo.Put(block.Hash, serializeSpentOutputs(spent))
+}
+
+// Undo reverts Update for a block disconnected from the tip. During a
+// reorganisation blocks are undone from the old tip down to the fork, then
+// the new branch is applied, see Blockchain.Reorganize. Blocks applied before undo records
+// existed can't be undone, nor blocks whose record was pruned, for which
+// ErrUndoDataPruned is returned; Reindex the set instead
+func (u UTXOSet) Undo(block *Block) error {
+	return u.write(func(tx *bolt.Tx, w *utxoWriter) error {
+		return undoBlock(tx, w, block)
 	})
+}
+
+// undoBlock reverts applyBlock for a block
+func undoBlock(tx *bolt.Tx, w *utxoWriter, block *Block) error {
+	undo := tx.Bucket([]byte(utxoUndoBucket))
+	heights := tx.Bucket([]byte(utxoUndoHeightBucket))
+	var data []byte
+	if undo != nil {
+		data = undo.Get(block.Hash)
+	}
+	if data == nil {
+		if undoPruned(heights, block.Height.Int64()) {
+			return ErrUndoDataPruned
+		}
+		return fmt.Errorf("no UTXO undo record for block %x", block.Hash)
+	}
+	spent, err := deserializeSpentOutputs(data)
+	if err != nil {
+		return err
+	}
+
+	// restore first, an output created and spent in this block is then
+	// removed along with the other outputs of the block
+	for i := len(spent) - 1; i >= 0; i-- {
+		s := spent[i]
+		outs, ok := w.get(s.TxID)
+		if !ok {
+			outs.Height, outs.Coinbase = s.Height, s.Coinbase
+		}
+		for len(outs.Outputs) <= s.Vout {
+			outs.Outputs = append(outs.Outputs, TXOutput{})
+		}
+		outs.Outputs[s.Vout] = s.Output
+		if err := w.put(s.TxID, outs); err != nil {
+			return err
+		}
+	}
+	for _, t := range block.Transactions {
+		if err := w.delete(t.ID); err != nil {
+			return err
+		}
+	}
+	if heights != nil {
+		if err := heights.Delete(undoHeightKey(block.Height.Int64(), block.Hash)); err != nil {
+			return err
+		}
+	}
+	if err := storeUTXOTip(tx, block.PrevBlockHash); err != nil {
+		return err
+	}
+
+	return undo.Delete(block.Hash)
+}
+
+// UndoBlocks undoes the blocks in hashes, keyed by hex encoded hash, from the
+// tip down before a reorganisation deletes them. On error the set must be
+// rebuilt with Reindex once the blocks are gone
+func (u UTXOSet) UndoBlocks(hashes map[string][]byte) error {
+	bci := u.Blockchain.Iterator()
+
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if _, ok := hashes[hex.EncodeToString(block.Hash)]; ok {
+			if err := u.Undo(block); err != nil {
+				return err
+			}
+		}
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// spentOutput is an output removed from the UTXO set by a block, kept in
+// the undo record of the block with the height and coinbase flag of its
+// entry
+type spentOutput struct {
+	TxID     []byte
+	Vout     int
+	Output   TXOutput
+	Height   int64
+	Coinbase bool
+}
+
+func serializeSpentOutputs(spent []spentOutput) []byte {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(spent)
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return buff.Bytes()
 }
 
+func deserializeSpentOutputs(data []byte) ([]spentOutput, error) {
+	var spent []spentOutput
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&spent)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return spent, nil
+}
 
 // verify transaction:timeLine UTXOAmount coinbaseTX
 func (u UTXOSet) VerifyTxTimeLineAndUTXOAmount(lastBlockTime *big.Int,block *Block) bool {
-	//TODO timeline check
-	var coinbaseNumber = 0
-	var coinbaseReward = 0
-	for _, tx := range block.Transactions {
-
-		if tx.IsCoinbase() == false {
-			return u.IsUTXOAmountValid(tx)
-		}else{
-			coinbaseNumber = coinbaseNumber +1;
-			coinbaseReward = tx.Vout[0].Value
-		}
-	}
-	//fmt.Printf("coinbaseReward %s \n", math.Pow(0.5, math.Floor(float64(block.Height/halfRewardblockCount)))*subsidy )
-	//fmt.Printf("coinbaseReward %s \n", coinbaseReward)
-	//in that block reward timeperiod less than that currenteward
-	if(math.Pow(0.5, math.Floor(float64(block.Height.Int64()/halfRewardblockCount)))*subsidy != float64(coinbaseReward)){
-		return false
-	}
-	if(block.Timestamp.Cmp(lastBlockTime) <=0 ){
-		fmt.Println("Timestamp.Cmp(lastBlockTime)  \n")
-		return false
-	}
-	//fmt.Printf("coinbaseNumber %s \n", coinbaseNumber)
-	if(coinbaseNumber>1){
-		return false
-	}
-
-	return true
-}
-
-func (u UTXOSet) IsUTXOAmountValid(tx *Transaction) bool{
-	pubKeyHash := HashPubKey(tx.Vin[0].PubKey)
-	acc, _ :=
-		u.FindSpendableOutputs(pubKeyHash, tx.Vout[0].Value,true,tx.Vin[0].Txid)
-	//var acc = 0
-	//UTXOs := UTXOSet.FindUTXO(u,pubKeyHash)
-	//fmt.Printf("len UTXOs %d \n", len(UTXOs))
-	//for _, out := range UTXOs {
-	//	acc += out.Value
-	//}
-	var change = 0
-	if(len(tx.Vout)>1){
-		change = tx.Vout[1].Value
-	}
-	if(acc != ( tx.Vout[0].Value+ change)){
-		fmt.Printf("tx.Vin[0].PubKey %d \n", tx.Vin[0].PubKey)
-		fmt.Printf("acc %d \n", acc)
-		fmt.Printf("Vout %d \n", tx.Vout[0].Value)
-		fmt.Printf("change %d \n", change)
-		return false
-	}
-	return true
+	//the timeline is checked against the median time past, see checkTimestamp
+	//the amounts and the coinbase reward, see CheckCoinbase
+	return u.CheckCoinbase(block) == nil
+}
+
+// Reasons an input of a transaction doesn't resolve, see CheckUTXOAmount
+var (
+	ErrUnknownOutpoint  = errors.New("no such transaction")
+	ErrSpentOutpoint    = errors.New("output already spent")
+	ErrOutpointNotOwned = errors.New("output not locked to the input key")
+	ErrOutpointReused   = errors.New("output spent twice by the transaction")
+)
+
+// InputCheck is how CheckUTXOAmount resolved an input
+type InputCheck struct {
+	Txid  []byte
+	Vout  int
+	Value int   // value of the spent output, when Err is nil
+	Err   error // why the input doesn't resolve to an unspent output
+}
+
+// AmountReport is the outcome of CheckUTXOAmount
+type AmountReport struct {
+	Inputs      []InputCheck
+	InputTotal  int // of the inputs that resolved
+	OutputTotal int
+}
+
+// Err returns nil if every input resolved and the outputs don't exceed them,
+// or an error naming the inputs that failed or the shortfall
+func (r AmountReport) Err() error {
+	var failed []string
+	for i, in := range r.Inputs {
+		if in.Err != nil {
+			failed = append(failed, fmt.Sprintf("input %d (%x:%d): %v", i, in.Txid, in.Vout, in.Err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	if r.OutputTotal > r.InputTotal {
+		return fmt.Errorf("outputs of %d exceed inputs of %d by %d", r.OutputTotal, r.InputTotal, r.OutputTotal-r.InputTotal)
+	}
+
+	return nil
+}
+
+// CheckUTXOAmount resolves every input of tx to the unspent output it
+// spends, and totals them against the outputs of tx. The error is of the
+// database, not of tx
+func (u UTXOSet) CheckUTXOAmount(tx *Transaction) (AmountReport, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+
+	return u.checkUTXOAmount(tx)
+}
+
+func (u UTXOSet) checkUTXOAmount(tx *Transaction) (AmountReport, error) {
+	report := AmountReport{Inputs: make([]InputCheck, len(tx.Vin))}
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+	err := u.Blockchain.Db.View(func(dbTx *bolt.Tx) error {
+		b := dbTx.Bucket([]byte(utxoBucket))
+		used := make(OutpointSet)
+
+		for i, vin := range tx.Vin {
+			check := &report.Inputs[i]
+			check.Txid, check.Vout = vin.Txid, vin.Vout
+			if used.Has(vin.Txid, vin.Vout) {
+				check.Err = ErrOutpointReused
+				continue
+			}
+			used.Add(vin.Txid, vin.Vout)
+
+			data := b.Get(vin.Txid)
+			if data == nil {
+				check.Err = ErrUnknownOutpoint
+				continue
+			}
+			outs := cache.decode(gen, vin.Txid, data)
+			switch {
+			case vin.Vout < 0 || vin.Vout >= len(outs.Outputs) || outs.Outputs[vin.Vout].isSpent():
+				check.Err = ErrSpentOutpoint
+			case !outs.Outputs[vin.Vout].IsLockedWithKey(HashPubKey(vin.PubKey)):
+				check.Err = ErrOutpointNotOwned
+			default:
+				check.Value = outs.Outputs[vin.Vout].Value
+				report.InputTotal += check.Value
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return AmountReport{}, err
+	}
+
+	// the set drops a transaction once all its outputs are spent, the chain
+	// tells that apart from one that never existed
+	unknown := make(map[string][]int)
+	for i, in := range report.Inputs {
+		if in.Err == ErrUnknownOutpoint {
+			unknown[string(in.Txid)] = append(unknown[string(in.Txid)], i)
+		}
+	}
+	for bci := u.Blockchain.Iterator(); len(unknown) > 0; {
+		block, err := bci.Next()
+		if err != nil {
+			// unknown to the set is all that's known of them
+			break
+		}
+		for _, t := range block.Transactions {
+			for _, i := range unknown[string(t.ID)] {
+				report.Inputs[i].Err = ErrSpentOutpoint
+			}
+			delete(unknown, string(t.ID))
+		}
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	for _, out := range tx.Vout {
+		report.OutputTotal += out.Value
+	}
+
+	return report, nil
+}
+
+// IsUTXOAmountValid checks that every input of tx spends an unspent output
+// of its key and that the outputs of tx don't exceed them. The error tells
+// which input failed or by how much the outputs exceed the inputs
+func (u UTXOSet) IsUTXOAmountValid(tx *Transaction) (bool, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+
+	report, err := u.checkUTXOAmount(tx)
+	if err != nil {
+		return false, err
+	}
+	err = report.Err()
+
+	return err == nil, err
+}
+
+// UTXOStats describes the UTXO set at a chain tip
+type UTXOStats struct {
+	Height         int64  `json:"height"`
+	BestBlock      string `json:"bestblock"`
+	Transactions   int    `json:"transactions"`
+	Outputs        int    `json:"txouts"`
+	PubKeyHashes   int    `json:"pubkeyhashes"`
+	DiskSize       int    `json:"disk_size"` // bytes allocated to the bucket
+	TotalAmount    int64  `json:"total_amount"`
+	ExpectedSupply int64  `json:"expected_supply"`
+	Commitment     string `json:"utxo_commitment"` // see UTXOSet.Commitment
+	// Discrepancy is TotalAmount minus ExpectedSupply. Fees a coinbase
+	// leaves unclaimed make it negative; a positive value means coins were
+	// created outside the subsidy schedule
+	Discrepancy int64 `json:"discrepancy"`
+}
+
+// Stats counts the UTXO set in one pass, reading the tip in the same bolt
+// transaction so the numbers match it
+func (u UTXOSet) Stats() (UTXOStats, error) {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	var stats UTXOStats
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		tip := blocks.Get([]byte("l"))
+		stats.BestBlock = hex.EncodeToString(tip)
+		stats.Height = DeserializeBlock(blocks.Get(tip)).Height.Int64()
+		stats.Commitment = hex.EncodeToString(loadUTXOHash(tx).sum())
+
+		b := tx.Bucket([]byte(utxoBucket))
+		pubKeyHashes := make(map[string]struct{})
+		err := b.ForEach(func(k, v []byte) error {
+			stats.Transactions++
+			for _, out := range cache.decode(gen, k, v).Outputs {
+				if out.isSpent() {
+					continue
+				}
+				stats.Outputs++
+				stats.TotalAmount += int64(out.Value)
+				pubKeyHashes[string(out.PubKeyHash)] = struct{}{}
+			}
+			return nil
+		})
+		stats.PubKeyHashes = len(pubKeyHashes)
+		bs := b.Stats()
+		stats.DiskSize = bs.BranchAlloc + bs.LeafAlloc + bs.InlineBucketInuse
+
+		return err
+	})
+	if err != nil {
+		return UTXOStats{}, err
+	}
+	stats.ExpectedSupply = expectedSupply(stats.Height)
+	stats.Discrepancy = stats.TotalAmount - stats.ExpectedSupply
+
+	return stats, nil
+}
+
+// expectedSupply returns the coins the coinbases of blocks 0 to height
+// create, on the schedule of the active genesis config. A premine takes the
+// place of the subsidy of the genesis block
+func expectedSupply(height int64) int64 {
+	genesis := &ActiveNetParams.Genesis
+	supply := int64(0)
+	if genesis.HalvingInterval == 0 {
+		supply = (height + 1) * int64(genesis.Subsidy)
+	}
+	for era := int64(0); genesis.HalvingInterval > 0 && era*genesis.HalvingInterval <= height && era < 64; era++ {
+		blocks := genesis.HalvingInterval
+		if remaining := height - era*genesis.HalvingInterval + 1; remaining < blocks {
+			blocks = remaining
+		}
+		supply += blocks * int64(BlockSubsidy(era*genesis.HalvingInterval))
+	}
+	if len(genesis.Premine) > 0 {
+		supply += genesis.premineTotal() - int64(BlockSubsidy(0))
+	}
+
+	return supply
 }
@@ -0,0 +1,519 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListUnspent(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		pubKeyHash := HashPubKey(ws.Wallets[address].PublicKey)
+		unspent := mustUnspent(UTXOSet.ListUnspent(pubKeyHash, 0))
+		assert.Len(t, unspent, 2)
+
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		oldest := unspent[0]
+		assert.Equal(t, hex.EncodeToString(genesis.Transactions[0].ID), oldest.TxID)
+		assert.Equal(t, 0, oldest.Vout)
+		assert.Equal(t, subsidy, oldest.Value)
+		assert.Equal(t, 2, oldest.Confirmations)
+		assert.True(t, oldest.Coinbase)
+		assert.True(t, oldest.Immature, "Immature coinbase outputs are listed, not hidden")
+		assert.False(t, oldest.Reserved)
+		assert.Equal(t, 1, unspent[1].Confirmations)
+
+		assert.Len(t, mustUnspent(UTXOSet.ListUnspent(pubKeyHash, 2)), 1)
+		assert.Len(t, mustUnspent(UTXOSet.ListUnspent(HashPubKey(NewWallet().PublicKey), 0)), 0)
+	})
+}
+
+func TestFindSpendableOutputsMinConf(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		wallet := ws.Wallets[address]
+		pubKeyHash := HashPubKey(wallet.PublicKey)
+
+		// the outputs at heights 0, 1 and 2 have 3, 2 and 1 confirmations
+		spendable := func(minConf int) int {
+			acc, _ := mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 1<<30, nil, minConf, false))
+			return acc
+		}
+		assert.Equal(t, 2*subsidy, spendable(2), "Outputs with exactly minConf confirmations are spendable")
+		assert.Equal(t, subsidy, spendable(3))
+		assert.Equal(t, 0, spendable(4))
+		assert.Equal(t, 3*subsidy, spendable(1))
+		UTXOSet.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")}))
+		assert.Equal(t, 3*subsidy, spendable(2), "Confirmations count from the best height")
+
+		// entries written before the heights were stored find them in the chain
+		bc.Db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(utxoBucket))
+			return b.ForEach(func(k, v []byte) error {
+				return b.Put(k, TXOutputs{Outputs: DeserializeOutputs(v).Outputs}.Serialize())
+			})
+		})
+		bc.utxoCache.reset()
+		assert.Equal(t, 3*subsidy, spendable(2))
+		assert.Equal(t, 0, spendable(5))
+
+		// with minConf 0 a send can spend the change of a pending one
+		defer func(f func() []*Transaction) { MempoolTransactions = f }(MempoolTransactions)
+		var mempool []*Transaction
+		MempoolTransactions = func() []*Transaction { return mempool }
+		first, err := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 4*subsidy-5, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		mempool = append(mempool, first)
+		pending := make(OutpointSet)
+		pending.AddInputs(first)
+		assert.Equal(t, 5, mustUnspent(UTXOSet.ListUnspent(pubKeyHash, 0))[4].Value, "The pending change is listed unconfirmed")
+
+		_, err = NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 3, &UTXOSet, pending, 1)
+		assert.Equal(t, ErrNotEnoughFunds, err)
+		second, err := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 3, &UTXOSet, pending, 0)
+		assert.Nil(t, err)
+		assert.Len(t, second.Vin, 1)
+		assert.Equal(t, first.ID, second.Vin[0].Txid)
+		assert.Equal(t, 1, second.Vin[0].Vout)
+		assert.True(t, second.Verify(map[string]Transaction{hex.EncodeToString(first.ID): *first}))
+
+		mempool = append(mempool, second)
+		pending.AddInputs(second)
+		acc, _ := mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 1<<30, pending, 0, false))
+		assert.Equal(t, 2, acc, "Only the change no mempool transaction spends")
+	})
+}
+
+func TestFindSpendableOutputsExclude(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		wallet := ws.Wallets[address]
+		pubKeyHash := HashPubKey(wallet.PublicKey)
+
+		acc, outputs := mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 10, nil, 1, false))
+		assert.Equal(t, subsidy, acc, "Immature coinbase outputs are spendable until consensus enforces maturity")
+		assert.Len(t, outputs, 1)
+		assert.True(t, mustUnspent(UTXOSet.ListUnspent(pubKeyHash, 0))[0].Immature)
+
+		tx, err := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 10, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		PendingIn(*wallet, tx)
+
+		pending := mustOutpoints(UTXOSet.PendingOutpoints(pubKeyHash))
+		assert.Len(t, pending, 1)
+		assert.True(t, pending.Has(tx.Vin[0].Txid, tx.Vin[0].Vout))
+		acc, outputs = mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 10, pending, 1, false))
+		assert.Equal(t, 0, acc)
+		assert.Empty(t, outputs)
+		assert.Equal(t, 0, mustBalance(UTXOSet.SpendableBalance(pubKeyHash, pending)))
+		assert.Equal(t, subsidy, mustBalance(UTXOSet.SpendableBalance(pubKeyHash, nil)))
+		assert.True(t, mustUnspent(UTXOSet.ListUnspent(pubKeyHash, 0))[0].Reserved)
+
+		_, err = NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 10, &UTXOSet, pending, 1)
+		assert.Equal(t, ErrNotEnoughFunds, err, "A second send can't pick the pending output")
+
+		UTXOSet.Update(mustMine(bc, []*Transaction{NewCoinbaseTX(address, ""), tx}))
+		assert.Empty(t, mustOutpoints(UTXOSet.PendingOutpoints(pubKeyHash)), "Mined transactions are no longer pending")
+	})
+}
+
+// utxoSnapshot returns the unspent outputs in the UTXO set
+func utxoSnapshot(t *testing.T, u UTXOSet) map[Outpoint]TXOutput {
+	snapshot := make(map[Outpoint]TXOutput)
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			for outIdx, out := range DeserializeOutputs(v).Outputs {
+				if !out.isSpent() {
+					snapshot[Outpoint{hex.EncodeToString(k), outIdx}] = out
+				}
+			}
+			return nil
+		})
+	})
+	assert.Nil(t, err)
+
+	return snapshot
+}
+
+func TestUpdateMatchesReindex(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		seed := time.Now().UnixNano()
+		r := rand.New(rand.NewSource(seed))
+		wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+		address := func(w *Wallet) string { return string(w.GetAddress()) }
+		bc := mustCreate(address(wallets[0]), "test")
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+
+		var before map[Outpoint]TXOutput
+		var last *Block
+		for i := 0; i < 3; i++ {
+			before = utxoSnapshot(t, UTXOSet)
+			txs := []*Transaction{NewCoinbaseTX(address(wallets[r.Intn(len(wallets))]), "")}
+			spent := make(OutpointSet)
+			for _, w := range wallets {
+				balance := mustBalance(UTXOSet.SpendableBalance(HashPubKey(w.PublicKey), spent))
+				if balance == 0 || r.Intn(4) == 0 {
+					continue
+				}
+				to := wallets[r.Intn(len(wallets))]
+				tx, err := NewUTXOTransaction(w, address(to), 1+r.Intn(balance), &UTXOSet, spent, 1)
+				assert.Nil(t, err)
+				spent.AddInputs(tx)
+				txs = append(txs, tx)
+			}
+			last = mustMine(bc, txs)
+			UTXOSet.Update(last)
+		}
+		UTXOSet.Update(last)
+
+		incremental := utxoSnapshot(t, UTXOSet)
+		UTXOSet.Reindex()
+		assert.Equal(t, utxoSnapshot(t, UTXOSet), incremental, "seed %d", seed)
+
+		assert.Nil(t, UTXOSet.Undo(last))
+		assert.Equal(t, before, utxoSnapshot(t, UTXOSet), "seed %d", seed)
+		assert.NotNil(t, UTXOSet.Undo(last), "The undo record is consumed")
+	})
+}
+
+func TestUndoBlocks(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		before := utxoSnapshot(t, UTXOSet)
+
+		hashes := make(map[string][]byte)
+		for i := 0; i < 2; i++ {
+			block := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+			UTXOSet.Update(block)
+			hashes[hex.EncodeToString(block.Hash)] = block.Hash
+		}
+		assert.Len(t, utxoSnapshot(t, UTXOSet), 3)
+
+		assert.Nil(t, UTXOSet.UndoBlocks(hashes))
+		assert.Equal(t, before, utxoSnapshot(t, UTXOSet))
+
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		assert.NotNil(t, UTXOSet.Undo(&genesis), "Blocks reindexed rather than updated have no undo record")
+	})
+}
+
+func TestReindexContext(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		before := utxoSnapshot(t, UTXOSet)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := UTXOSet.ReindexContext(ctx, func(blocks, total, outputs int) {
+			calls++
+			assert.Equal(t, 3, total)
+			cancel()
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, before, utxoSnapshot(t, UTXOSet), "A cancelled reindex keeps the set")
+		bc.Db.View(func(tx *bolt.Tx) error {
+			assert.Nil(t, tx.Bucket([]byte(utxoReindexBucket)), "The temporary bucket is dropped")
+			return nil
+		})
+
+		var last [3]int
+		assert.Nil(t, UTXOSet.ReindexContext(context.Background(), func(blocks, total, outputs int) {
+			last = [3]int{blocks, total, outputs}
+		}))
+		assert.Equal(t, [3]int{3, 3, 3}, last)
+		assert.Equal(t, before, utxoSnapshot(t, UTXOSet))
+	})
+}
+
+func TestGetBalance(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		wallet := ws.Wallets[address]
+		pubKeyHash := HashPubKey(wallet.PublicKey)
+
+		confirmed, pending, err := UTXOSet.GetBalance(pubKeyHash, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2*subsidy), confirmed)
+		assert.Equal(t, int64(0), pending)
+
+		confirmed, pending, _ = UTXOSet.GetBalance(pubKeyHash, 2)
+		assert.Equal(t, int64(subsidy), confirmed)
+		assert.Equal(t, int64(subsidy), pending, "Outputs below minConf are pending")
+
+		other := NewWallet()
+		tx, err := NewUTXOTransaction(wallet, string(other.GetAddress()), 3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		PendingIn(*wallet, tx)
+		confirmed, pending, _ = UTXOSet.GetBalance(pubKeyHash, 1)
+		assert.Equal(t, int64(2*subsidy), confirmed)
+		assert.Equal(t, int64(-subsidy), pending, "The reserved output is subtracted")
+
+		MempoolTransactions = func() []*Transaction { return []*Transaction{tx} }
+		defer func() { MempoolTransactions = nil }()
+		confirmed, pending, _ = UTXOSet.GetBalance(pubKeyHash, 1)
+		assert.Equal(t, int64(2*subsidy), confirmed)
+		assert.Equal(t, int64(-3), pending, "The change in the mempool is added back")
+		_, pending, _ = UTXOSet.GetBalance(HashPubKey(other.PublicKey), 1)
+		assert.Equal(t, int64(3), pending)
+
+		balances, err := UTXOSet.GetBalances([][]byte{pubKeyHash, HashPubKey(other.PublicKey)})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]int64{
+			hex.EncodeToString(pubKeyHash):                  int64(2 * subsidy),
+			hex.EncodeToString(HashPubKey(other.PublicKey)): 0,
+		}, balances)
+	})
+}
+
+func TestStats(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		other := NewWallet()
+		tx, err := NewUTXOTransaction(ws.Wallets[address], string(other.GetAddress()), 3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		last := mustMine(bc, []*Transaction{NewCoinbaseTX(address, ""), tx})
+		UTXOSet.Update(last)
+
+		stats, err := UTXOSet.Stats()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), stats.Height)
+		assert.Equal(t, hex.EncodeToString(last.Hash), stats.BestBlock)
+		assert.Equal(t, 3, stats.Transactions, "The spent coinbase is gone")
+		assert.Equal(t, 4, stats.Outputs)
+		assert.Equal(t, 2, stats.PubKeyHashes)
+		assert.True(t, stats.DiskSize > 0)
+		assert.Equal(t, int64(3*subsidy), stats.TotalAmount)
+		assert.Equal(t, int64(3*subsidy), stats.ExpectedSupply)
+		assert.Equal(t, int64(0), stats.Discrepancy)
+	})
+}
+
+func TestExpectedSupply(t *testing.T) {
+	assert.Equal(t, int64(subsidy), expectedSupply(0))
+	assert.Equal(t, int64(halfRewardblockCount*subsidy), expectedSupply(halfRewardblockCount-1))
+	assert.Equal(t, int64(halfRewardblockCount*subsidy+subsidy/2), expectedSupply(halfRewardblockCount))
+}
+
+func TestCheckUTXOAmount(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		wallet := ws.Wallets[address]
+		other := fmt.Sprintf("%s", NewWallet().GetAddress())
+
+		tx, err := NewUTXOTransaction(wallet, other, 3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		valid, err := UTXOSet.IsUTXOAmountValid(tx)
+		assert.True(t, valid)
+		assert.Nil(t, err)
+		assert.Nil(t, VerifyTx(*tx, bc))
+
+		report := mustReport(UTXOSet.CheckUTXOAmount(tx))
+		assert.Equal(t, subsidy, report.Inputs[0].Value)
+		assert.Equal(t, subsidy, report.InputTotal)
+		assert.Equal(t, subsidy, report.OutputTotal)
+
+		inflated := *tx
+		inflated.Vout = []TXOutput{*NewTXOutput(subsidy+5, other)}
+		report = mustReport(UTXOSet.CheckUTXOAmount(&inflated))
+		assert.Nil(t, report.Inputs[0].Err)
+		assert.Equal(t, subsidy+5, report.OutputTotal)
+		valid, err = UTXOSet.IsUTXOAmountValid(&inflated)
+		assert.False(t, valid)
+		assert.Equal(t, fmt.Sprintf("outputs of %d exceed inputs of %d by 5", subsidy+5, subsidy), err.Error())
+
+		missing := *tx
+		missing.Vin = append([]TXInput{{Txid: []byte("no such transaction"), PubKey: wallet.PublicKey}}, tx.Vin...)
+		report = mustReport(UTXOSet.CheckUTXOAmount(&missing))
+		assert.Equal(t, ErrUnknownOutpoint, report.Inputs[0].Err)
+		assert.Nil(t, report.Inputs[1].Err)
+		assert.Equal(t, subsidy, report.InputTotal)
+		assert.Contains(t, VerifyTx(missing, bc).Error(), "input 0 (")
+
+		reused := *tx
+		reused.Vin = append(tx.Vin, tx.Vin[0])
+		assert.Equal(t, ErrOutpointReused, mustReport(UTXOSet.CheckUTXOAmount(&reused)).Inputs[1].Err)
+
+		notOwned := *tx
+		notOwned.Vin = []TXInput{tx.Vin[0]}
+		notOwned.Vin[0].PubKey = NewWallet().PublicKey
+		assert.Equal(t, ErrOutpointNotOwned, mustReport(UTXOSet.CheckUTXOAmount(&notOwned)).Inputs[0].Err)
+
+		UTXOSet.Update(mustMine(bc, []*Transaction{strayCoinbase(), tx}))
+		report = mustReport(UTXOSet.CheckUTXOAmount(tx))
+		assert.Equal(t, ErrSpentOutpoint, report.Inputs[0].Err, "The spent coinbase left the set, the chain still has it")
+		assert.Equal(t, 0, report.InputTotal)
+		_, err = UTXOSet.IsUTXOAmountValid(tx)
+		assert.Contains(t, err.Error(), "output already spent")
+
+		outOfRange := Transaction{Vin: []TXInput{{Txid: tx.ID, Vout: 2, PubKey: wallet.PublicKey}}}
+		assert.Equal(t, ErrSpentOutpoint, mustReport(UTXOSet.CheckUTXOAmount(&outOfRange)).Inputs[0].Err)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		tx, err := NewUTXOTransaction(ws.Wallets[address], fmt.Sprintf("%s", NewWallet().GetAddress()), 3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		UTXOSet.Update(mustMine(bc, []*Transaction{strayCoinbase(), tx}))
+
+		result, err := UTXOSet.Verify(1, func(m UTXOMismatch) { t.Errorf("unexpected mismatch %+v", m) })
+		assert.Nil(t, err)
+		assert.Equal(t, UTXOVerifyResult{Transactions: 4, Outputs: 5}, result)
+		_, err = UTXOSet.Verify(0, nil)
+		assert.NotNil(t, err)
+
+		// lose a coinbase, pay the change twice and invent a transaction
+		var lost []byte
+		bc.Db.Update(func(dbTx *bolt.Tx) error {
+			b := dbTx.Bucket([]byte(utxoBucket))
+			c := b.Cursor()
+			for k, _ := c.First(); lost == nil; k, _ = c.Next() {
+				if string(k) != string(tx.ID) {
+					lost = append([]byte(nil), k...)
+				}
+			}
+			b.Delete(lost)
+			outs := DeserializeOutputs(b.Get(tx.ID))
+			outs.Outputs[1].Value *= 2
+			b.Put(tx.ID, outs.Serialize())
+			return b.Put([]byte("invented"), TXOutputs{Outputs: []TXOutput{{1, []byte("key")}}}.Serialize())
+		})
+		UTXOSet.Blockchain.utxoCache.reset()
+
+		var found []UTXOMismatch
+		result, err = UTXOSet.Verify(1, func(m UTXOMismatch) { found = append(found, m) })
+		assert.Nil(t, err)
+		assert.Equal(t, 3, result.Mismatches())
+		assert.Equal(t, []string{UTXOMissing, UTXOExtra, UTXOValue}, []string{kindOf(found, lost), kindOf(found, []byte("invented")), kindOf(found, tx.ID)})
+		for _, m := range found {
+			if m.Kind == UTXOValue {
+				assert.Equal(t, 2*m.Expected.Value, m.Actual.Value)
+			}
+		}
+
+		sampled := 0
+		for i := 0; i < 20; i++ {
+			result, err = UTXOSet.Verify(0.5, nil)
+			assert.Nil(t, err)
+			assert.True(t, result.Transactions <= 5)
+			sampled += result.Transactions
+		}
+		assert.True(t, sampled > 0 && sampled < 100, "Half of the transactions are checked")
+
+		assert.Nil(t, UTXOSet.ReindexContext(context.Background(), nil))
+		result, _ = UTXOSet.Verify(1, nil)
+		assert.Equal(t, 0, result.Mismatches())
+	})
+}
+
+func kindOf(found []UTXOMismatch, txID []byte) string {
+	for _, m := range found {
+		if string(m.TxID) == string(txID) {
+			return m.Kind
+		}
+	}
+
+	return ""
+}
+
+func TestConcurrentQueries(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		bc.SetUTXOCacheSize(16)
+		genesis, _ := bc.GetBlock(bc.GenesisHash)
+		pubKeyHash := genesis.Transactions[0].Vout[0].PubKeyHash
+		blocks := syntheticBlocks(bc, 200)
+
+		// each block adds a coinbase worth subsidy+1 and moves an output of
+		// the same key, a query sees whole blocks only
+		whole := func(name string, balance int64) {
+			if (balance-subsidy)%(subsidy+1) != 0 {
+				t.Errorf("%s saw a partial block: %d", name, balance)
+			}
+		}
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					confirmed, _, err := UTXOSet.GetBalance(pubKeyHash, 0)
+					assert.Nil(t, err)
+					whole("GetBalance", confirmed)
+					acc, _ := mustSpendable(UTXOSet.FindSpendableOutputs(pubKeyHash, 1<<30, nil, 1, false))
+					whole("FindSpendableOutputs", int64(acc))
+					balances, _ := UTXOSet.GetBalances([][]byte{pubKeyHash})
+					whole("GetBalances", balances[hex.EncodeToString(pubKeyHash)])
+				}
+			}()
+		}
+		for _, block := range blocks {
+			UTXOSet.Update(block)
+		}
+		close(done)
+		wg.Wait()
+
+		confirmed, _, _ := UTXOSet.GetBalance(pubKeyHash, 0)
+		assert.Equal(t, int64(subsidy+200*(subsidy+1)), confirmed)
+	})
+}
@@ -0,0 +1,304 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/boltdb/bolt"
+)
+
+// snapshotFormatVersion is the version written by Snapshot. Version 1 has
+// no commitment
+const snapshotFormatVersion = 2
+
+// snapshotMagic starts every UTXO snapshot, followed by a big endian uint16
+// format version, the chain tip hash, its height, the number of entries and
+// the commitment to the set, see UTXOSet.Commitment. Each entry is an unspent output: transaction ID, output index, value,
+// public key hash and the height of the block holding the transaction. The
+// SHA-256 of everything before it closes the snapshot. Byte strings are
+// prefixed by a one byte length, integers are big endian
+var snapshotMagic = []byte("SWCUTXO")
+
+// maxSnapshotVout bounds the output indexes LoadSnapshot accepts, the
+// outputs of a transaction are padded up to the index
+const maxSnapshotVout = 1 << 20
+
+// snapshotEntry is an unspent output in a snapshot
+type snapshotEntry struct {
+	TxID       []byte
+	Vout       uint32
+	Value      int64
+	PubKeyHash []byte
+	Height     int64
+}
+
+// Snapshot writes every unspent output with the chain tip it was built at,
+// for LoadSnapshot on another node
+func (u UTXOSet) Snapshot(w io.Writer) error {
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	cache := u.Blockchain.utxoCache
+	gen := cache.generation()
+
+	return u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		tip := blocks.Get([]byte("l"))
+		b := tx.Bucket([]byte(utxoBucket))
+		commitment := loadUTXOHash(tx).sum()
+
+		count := uint64(0)
+		heights := make(map[string]int64)
+		err := b.ForEach(func(k, v []byte) error {
+			for _, out := range cache.decode(gen, k, v).Outputs {
+				if !out.isSpent() {
+					count++
+				}
+			}
+			heights[string(k)] = -1
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// find the blocks holding the transactions, walking back from the tip
+		tipHeight := int64(-1)
+		missing := len(heights)
+		for hash := tip; len(hash) > 0 && missing > 0; {
+			block := DeserializeBlock(blocks.Get(hash))
+			if tipHeight < 0 {
+				tipHeight = block.Height.Int64()
+			}
+			for _, t := range block.Transactions {
+				if h, ok := heights[string(t.ID)]; ok && h < 0 {
+					heights[string(t.ID)] = block.Height.Int64()
+					missing--
+				}
+			}
+			hash = block.PrevBlockHash
+		}
+		if missing > 0 {
+			return fmt.Errorf("%d transactions of the UTXO set aren't in the chain, run reindexutxo", missing)
+		}
+		if tipHeight < 0 {
+			tipHeight = DeserializeBlock(blocks.Get(tip)).Height.Int64()
+		}
+
+		hasher := sha256.New()
+		bw := bufio.NewWriter(w)
+		sw := &snapshotWriter{w: io.MultiWriter(bw, hasher)}
+		sw.raw(snapshotMagic)
+		sw.uint(uint64(snapshotFormatVersion), 2)
+		sw.bytes(tip)
+		sw.uint(uint64(tipHeight), 8)
+		sw.uint(count, 8)
+		sw.bytes(commitment)
+		err = b.ForEach(func(k, v []byte) error {
+			for outIdx, out := range cache.decode(gen, k, v).Outputs {
+				if out.isSpent() {
+					continue
+				}
+				sw.bytes(k)
+				sw.uint(uint64(outIdx), 4)
+				sw.uint(uint64(out.Value), 8)
+				sw.bytes(out.PubKeyHash)
+				sw.uint(uint64(heights[string(k)]), 8)
+			}
+			return sw.err
+		})
+		if err == nil {
+			err = sw.err
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(hasher.Sum(nil)); err != nil {
+			return err
+		}
+
+		return bw.Flush()
+	})
+}
+
+// LoadSnapshot replaces the UTXO set with a snapshot written by Snapshot.
+// The snapshot must be taken at expectedTip, a block hash the operator
+// trusts, or at the tip of the local chain when expectedTip is nil. The set
+// is only replaced once the whole snapshot is read and its checksum
+// verified, along with its commitment: the entries must hash to it, and it
+// must match the one of the block after the tip when the local chain has
+// it. On any error the set is left as it was
+func (u UTXOSet) LoadSnapshot(r io.Reader, expectedTip []byte) error {
+	hasher := sha256.New()
+	br := bufio.NewReader(r)
+	sr := &snapshotReader{r: io.TeeReader(br, hasher)}
+
+	magic := sr.raw(len(snapshotMagic))
+	if sr.err != nil || !bytes.Equal(magic, snapshotMagic) {
+		return fmt.Errorf("not a UTXO snapshot, expected magic %q", snapshotMagic)
+	}
+	version := sr.uint(2)
+	if sr.err == nil && (version < 1 || version > snapshotFormatVersion) {
+		return fmt.Errorf("unsupported UTXO snapshot version %d", version)
+	}
+	tip := sr.bytes()
+	tipHeight := int64(sr.uint(8))
+	count := sr.uint(8)
+	var commitment []byte
+	if version >= 2 {
+		commitment = sr.bytes()
+	}
+	if sr.err != nil {
+		return fmt.Errorf("reading UTXO snapshot header: %v", sr.err)
+	}
+
+	if expectedTip != nil {
+		if !bytes.Equal(tip, expectedTip) {
+			return fmt.Errorf("UTXO snapshot is at block %x, expected %x", tip, expectedTip)
+		}
+	} else {
+		height, localTip, err := u.Blockchain.GetBestHeightLastHash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tip, localTip) || tipHeight != height.Int64() {
+			return fmt.Errorf("UTXO snapshot is at block %x height %d, the chain tip is %x height %d", tip, tipHeight, localTip, height.Int64())
+		}
+	}
+	if committed := u.Blockchain.utxoCommitmentAfter(tip); committed != nil {
+		if commitment == nil {
+			return fmt.Errorf("UTXO snapshot has no commitment, block %x after it has one", tip)
+		}
+		if !bytes.Equal(commitment, committed) {
+			return fmt.Errorf("UTXO snapshot commitment %x differs from %x in the block after it", commitment, committed)
+		}
+	}
+
+	builder, err := u.newBuilder()
+	if err != nil {
+		return err
+	}
+	builder.tip = tip
+	hash := newUTXOHash(nil)
+	err = loadSnapshotEntries(sr, count, builder, hash)
+	if err == nil {
+		sum := hasher.Sum(nil)
+		trailer := make([]byte, sha256.Size)
+		if _, err = io.ReadFull(br, trailer); err != nil || !bytes.Equal(trailer, sum) {
+			err = errors.New("UTXO snapshot checksum mismatch")
+		} else if _, extra := br.ReadByte(); extra != io.EOF {
+			err = errors.New("UTXO snapshot has trailing data")
+		} else if commitment != nil && !bytes.Equal(hash.sum(), commitment) {
+			err = errors.New("UTXO snapshot entries don't match its commitment")
+		}
+	}
+	if err != nil {
+		builder.drop()
+		return err
+	}
+
+	return builder.commit()
+}
+
+// loadSnapshotEntries reads count entries into builder and hash. Entries
+// come sorted by transaction ID then output index, as Snapshot writes them
+func loadSnapshotEntries(sr *snapshotReader, count uint64, builder *utxoBuilder, hash *utxoHash) error {
+	var prev snapshotEntry
+	var outs TXOutputs
+	for i := uint64(0); i < count; i++ {
+		e := snapshotEntry{
+			TxID:       sr.bytes(),
+			Vout:       uint32(sr.uint(4)),
+			Value:      int64(sr.uint(8)),
+			PubKeyHash: sr.bytes(),
+			Height:     int64(sr.uint(8)),
+		}
+		if sr.err != nil {
+			return fmt.Errorf("reading UTXO snapshot entry %d: %v", i, sr.err)
+		}
+		if len(e.TxID) == 0 || len(e.PubKeyHash) == 0 || e.Value < 0 || e.Vout > maxSnapshotVout {
+			return fmt.Errorf("UTXO snapshot entry %d is invalid", i)
+		}
+
+		order := bytes.Compare(e.TxID, prev.TxID)
+		if i > 0 && (order < 0 || order == 0 && e.Vout <= prev.Vout) {
+			return fmt.Errorf("UTXO snapshot entry %d is out of order", i)
+		}
+		if i > 0 && order != 0 {
+			if err := builder.add(hex.EncodeToString(prev.TxID), outs); err != nil {
+				return err
+			}
+			outs = TXOutputs{}
+		}
+		for uint32(len(outs.Outputs)) < e.Vout {
+			outs.Outputs = append(outs.Outputs, TXOutput{})
+		}
+		outs.Outputs = append(outs.Outputs, TXOutput{int(e.Value), e.PubKeyHash})
+		hash.add(e.TxID, int(e.Vout), outs.Outputs[e.Vout])
+		prev = e
+	}
+	if count > 0 {
+		return builder.add(hex.EncodeToString(prev.TxID), outs)
+	}
+
+	return nil
+}
+
+// snapshotWriter writes the snapshot encoding, keeping the first error
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *snapshotWriter) raw(data []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(data)
+	}
+}
+
+func (sw *snapshotWriter) uint(v uint64, size int) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	sw.raw(buf[8-size:])
+}
+
+func (sw *snapshotWriter) bytes(data []byte) {
+	if len(data) > 255 && sw.err == nil {
+		sw.err = fmt.Errorf("%x is too long for a UTXO snapshot", data)
+	}
+	sw.raw([]byte{byte(len(data))})
+	sw.raw(data)
+}
+
+// snapshotReader reads the snapshot encoding, keeping the first error
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *snapshotReader) raw(n int) []byte {
+	data := make([]byte, n)
+	if sr.err == nil {
+		_, sr.err = io.ReadFull(sr.r, data)
+	}
+
+	return data
+}
+
+func (sr *snapshotReader) uint(size int) uint64 {
+	buf := make([]byte, 8)
+	copy(buf[8-size:], sr.raw(size))
+
+	return binary.BigEndian.Uint64(buf)
+}
+
+func (sr *snapshotReader) bytes() []byte {
+	n := sr.raw(1)[0]
+
+	return sr.raw(int(n))
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		tx, err := NewUTXOTransaction(ws.Wallets[address], string(NewWallet().GetAddress()), 3, &UTXOSet, nil, 1)
+		assert.Nil(t, err)
+		last := mustMine(bc, []*Transaction{NewCoinbaseTX(address, ""), tx})
+		UTXOSet.Update(last)
+		want := utxoSnapshot(t, UTXOSet)
+
+		var snapshot bytes.Buffer
+		assert.Nil(t, UTXOSet.Snapshot(&snapshot))
+
+		assert.Nil(t, UTXOSet.Undo(last))
+		assert.Nil(t, UTXOSet.LoadSnapshot(bytes.NewReader(snapshot.Bytes()), nil))
+		assert.Equal(t, want, utxoSnapshot(t, UTXOSet))
+
+		assert.Nil(t, UTXOSet.LoadSnapshot(bytes.NewReader(snapshot.Bytes()), last.Hash))
+		assert.Equal(t, want, utxoSnapshot(t, UTXOSet))
+	})
+}
+
+func TestLoadSnapshotRejects(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		_, address := newTestWallets()
+		bc := newTestChain(address, address)
+		defer bc.Db.Close()
+		UTXOSet := UTXOSet{Blockchain: bc}
+		UTXOSet.Reindex()
+		var snapshot bytes.Buffer
+		assert.Nil(t, UTXOSet.Snapshot(&snapshot))
+		data := snapshot.Bytes()
+
+		last := mustMine(bc, []*Transaction{NewCoinbaseTX(address, "")})
+		UTXOSet.Update(last)
+		want := utxoSnapshot(t, UTXOSet)
+
+		corrupted := append([]byte(nil), data...)
+		corrupted[len(corrupted)-40] ^= 1
+		for name, bad := range map[string][]byte{
+			"stale tip": data,
+			"corrupted": corrupted,
+			"truncated": data[:len(data)-1],
+			"trailing":  append(append([]byte(nil), data...), 0),
+			"magic":     append([]byte("X"), data[1:]...),
+		} {
+			err := UTXOSet.LoadSnapshot(bytes.NewReader(bad), nil)
+			if name != "stale tip" {
+				// accept the old tip, so the content checks are reached
+				err = UTXOSet.LoadSnapshot(bytes.NewReader(bad), last.PrevBlockHash)
+			}
+			assert.NotNil(t, err, name)
+			assert.Equal(t, want, utxoSnapshot(t, UTXOSet), name)
+		}
+		assert.NotNil(t, UTXOSet.LoadSnapshot(bytes.NewReader(data), last.Hash), "The snapshot isn't at the trusted tip")
+	})
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/boltdb/bolt"
+)
+
+// Kinds of UTXOMismatch
+const (
+	UTXOMissing = "missing" // unspent in the chain, not in the set
+	UTXOExtra   = "extra"   // in the set, spent or unknown in the chain
+	UTXOValue   = "value"   // in both, with a different value or key
+)
+
+// UTXOMismatch is an outpoint where the UTXO set and the chain disagree
+type UTXOMismatch struct {
+	Kind     string
+	TxID     []byte
+	Vout     int
+	Expected TXOutput // from the chain, empty when Kind is UTXOExtra
+	Actual   TXOutput // from the set, empty when Kind is UTXOMissing
+}
+
+// UTXOVerifyResult counts what Verify checked and found
+type UTXOVerifyResult struct {
+	Transactions int // transactions of the chain or the set checked
+	Outputs      int // unspent outputs of the chain checked
+	Missing      int
+	Extra        int
+	Mismatched   int
+}
+
+// Mismatches is the number of outpoints in error
+func (r UTXOVerifyResult) Mismatches() int {
+	return r.Missing + r.Extra + r.Mismatched
+}
+
+// Verify rebuilds the UTXO set from the chain and compares it with the
+// chainstate bucket, calling found for each outpoint they disagree on. With
+// a sampleRate below 1 only that fraction of the transactions, picked at
+// random on each call, is rebuilt and compared. The expected set is written
+// to the temporary reindex bucket and both are compared with cursors, so
+// neither is held in memory; Verify must not run along Reindex
+func (u UTXOSet) Verify(sampleRate float64, found func(UTXOMismatch)) (UTXOVerifyResult, error) {
+	var result UTXOVerifyResult
+	if sampleRate <= 0 || sampleRate > 1 {
+		return result, errors.New("sample rate must be above 0 and at most 1")
+	}
+	sampled := newUTXOSampler(sampleRate)
+
+	builder, err := u.newBuilder()
+	if err != nil {
+		return result, err
+	}
+	defer builder.drop()
+
+	tip := u.Blockchain.tip
+	err = u.Blockchain.walkUTXO(context.Background(), func(block *Block, unspent map[string]TXOutputs) error {
+		for txID, outs := range unspent {
+			key, err := hex.DecodeString(txID)
+			if err != nil {
+				return err
+			}
+			if sampled(key) {
+				if err := builder.add(txID, outs); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = u.Blockchain.Db.Update(builder.flush)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	u.Blockchain.utxoMu.RLock()
+	defer u.Blockchain.utxoMu.RUnlock()
+	err = u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		if !bytes.Equal(tx.Bucket([]byte(blocksBucket)).Get([]byte("l")), tip) {
+			return errors.New("the chain tip moved during the check, run it again")
+		}
+
+		actual := tx.Bucket([]byte(utxoBucket)).Cursor()
+		expected := tx.Bucket([]byte(utxoReindexBucket)).Cursor()
+		ak, av := actual.First()
+		ek, ev := expected.First()
+		for ak != nil || ek != nil {
+			order := bytes.Compare(ak, ek)
+			switch {
+			case ek == nil || ak != nil && order < 0:
+				if sampled(ak) {
+					result.compare(ak, TXOutputs{}, DeserializeOutputs(av), found)
+				}
+				ak, av = actual.Next()
+			case ak == nil || order > 0:
+				result.compare(ek, DeserializeOutputs(ev), TXOutputs{}, found)
+				ek, ev = expected.Next()
+			default:
+				result.compare(ek, DeserializeOutputs(ev), DeserializeOutputs(av), found)
+				ak, av = actual.Next()
+				ek, ev = expected.Next()
+			}
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// compare reports the outputs of a transaction that differ between the
+// expected and the actual set
+func (r *UTXOVerifyResult) compare(txID []byte, expected, actual TXOutputs, found func(UTXOMismatch)) {
+	r.Transactions++
+	for i := 0; i < len(expected.Outputs) || i < len(actual.Outputs); i++ {
+		var exp, act TXOutput
+		if i < len(expected.Outputs) {
+			exp = expected.Outputs[i]
+		}
+		if i < len(actual.Outputs) {
+			act = actual.Outputs[i]
+		}
+		if !exp.isSpent() {
+			r.Outputs++
+		}
+
+		// bolt keys are only valid inside the transaction
+		m := UTXOMismatch{TxID: append([]byte(nil), txID...), Vout: i, Expected: exp, Actual: act}
+		switch {
+		case exp.isSpent() && act.isSpent():
+			continue
+		case act.isSpent():
+			m.Kind = UTXOMissing
+			r.Missing++
+		case exp.isSpent():
+			m.Kind = UTXOExtra
+			r.Extra++
+		case exp.Value != act.Value || !bytes.Equal(exp.PubKeyHash, act.PubKeyHash):
+			m.Kind = UTXOValue
+			r.Mismatched++
+		default:
+			continue
+		}
+		if found != nil {
+			found(m)
+		}
+	}
+}
+
+// newUTXOSampler returns a function picking the fraction rate of the
+// transaction IDs, a different fraction for each sampler
+func newUTXOSampler(rate float64) func(txID []byte) bool {
+	if rate >= 1 {
+		return func([]byte) bool { return true }
+	}
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint64(seed, rand.Uint64())
+	limit := uint64(rate * math.MaxUint64)
+
+	return func(txID []byte) bool {
+		h := fnv.New64a()
+		h.Write(seed)
+		h.Write(txID)
+		return h.Sum64() < limit
+	}
+}
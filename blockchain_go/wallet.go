@@ -4,46 +4,74 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"errors"
 	"log"
+	"time"
 
 	"golang.org/x/crypto/ripemd160"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-const version = byte(0x00)
 const addressChecksumLen = 4
 
-// Wallet stores private and public keys
+// The reasons CheckAddress refuses a Base58Check address
+var (
+	ErrAddressEncoding = errors.New("address holds a character outside the Base58 alphabet")
+	ErrAddressLength   = errors.New("address doesn't hold a 20 byte public key hash")
+	ErrAddressChecksum = errors.New("address checksum doesn't match")
+	ErrAddressNetwork  = errors.New("address is of another network")
+)
+
+// Wallet stores private and public keys along with user metadata
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
-	PublicKey  []byte
+	PrivateKey  ecdsa.PrivateKey
+	PublicKey   []byte
+	Label       string
+	CreatedAt   int64
+	History     []string // hex IDs of transactions found by Rescan
+	SignerURI   string   // external signer holding the key, PrivateKey is empty then
+	Retired     bool     // funds were moved away by Rotate
+	RetiredBy   string   // hex ID of the sweep transaction
+	DeleteAfter int      // confirmations of RetiredBy after which PruneRetired deletes the key
 }
 
 // NewWallet creates and returns a Wallet
 func NewWallet() *Wallet {
 	private, public := newKeyPair()
-	wallet := Wallet{private, public}
+	wallet := Wallet{PrivateKey: private, PublicKey: public, CreatedAt: time.Now().Unix()}
 
 	return &wallet
 }
 
-// GetAddress returns wallet address
-func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+// newWalletFromKey wraps an existing private key into a Wallet
+func newWalletFromKey(private *ecdsa.PrivateKey) *Wallet {
+	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	wallet := Wallet{PrivateKey: *private, PublicKey: pubKey, CreatedAt: time.Now().Unix()}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
-	checksum := checksum(versionedPayload)
+	return &wallet
+}
 
-	fullPayload := append(versionedPayload, checksum...)
-	address := Base58Encode(fullPayload)
+// newWatchWallet creates a Wallet holding only a public key
+func newWatchWallet(pubKey []byte) *Wallet {
+	wallet := Wallet{PublicKey: pubKey, CreatedAt: time.Now().Unix()}
 
-	return address
+	return &wallet
 }
 
-// HashPubKey hashes public key
-func GetAddressFromPubkeyHash(pubKeyHash []byte) []byte {
+// GetAddress returns wallet address
+func (w Wallet) GetAddress() []byte {
+	return GetAddressFromPubkeyHash(HashPubKey(w.PublicKey))
+}
+
+// GetBech32Address returns the Bech32 form of the wallet address
+func (w Wallet) GetBech32Address() string {
+	return EncodeBech32Address(HashPubKey(w.PublicKey))
+}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+// GetAddressFromPubkeyHash returns the Base58Check address of a public key
+// hash on the active network
+func GetAddressFromPubkeyHash(pubKeyHash []byte) []byte {
+	versionedPayload := append([]byte{ActiveNetParams.AddressVersion}, pubKeyHash...)
 	checksum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
@@ -66,15 +94,49 @@ func HashPubKey(pubKey []byte) []byte {
 	return publicRIPEMD160
 }
 
-// ValidateAddress check if address if valid
+// ValidateAddress check if address if valid, accepting both the Base58Check
+// and the Bech32 form. CheckAddress tells why it isn't
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	return CheckAddress(address) == nil
+}
+
+// CheckAddress returns why address is no valid address of the active network,
+// nil when it is. A Base58Check address gets one of ErrAddressEncoding,
+// ErrAddressLength, ErrAddressChecksum and ErrAddressNetwork
+func CheckAddress(address string) error {
+	return checkAddress(address, ActiveNetParams)
+}
+
+// checkAddress is CheckAddress on the network of params
+func checkAddress(address string, params *NetParams) error {
+	if isBech32Address(address, params.Bech32HRP) {
+		_, err := decodeBech32Address(address, params.Bech32HRP)
+		return err
+	}
+	_, err := decodeBase58Address(address, params.AddressVersion)
+
+	return err
+}
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+// decodeBase58Address returns the public key hash of a Base58Check address
+// with the given version byte
+func decodeBase58Address(address string, version byte) ([]byte, error) {
+	payload := Base58Decode([]byte(address))
+	if payload == nil {
+		return nil, ErrAddressEncoding
+	}
+	if len(payload) != 1+pubKeyHashLen+addressChecksumLen {
+		return nil, ErrAddressLength
+	}
+	versionedPayload := payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(payload[len(versionedPayload):], checksum(versionedPayload)) {
+		return nil, ErrAddressChecksum
+	}
+	if versionedPayload[0] != version {
+		return nil, ErrAddressNetwork
+	}
+
+	return versionedPayload[1:], nil
 }
 
 // Checksum generates a checksum for a public key
@@ -88,11 +150,16 @@ func checksum(payload []byte) []byte {
 func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	//curve := elliptic.P256()
 	//private, err := ecdsa.GenerateKey(curve, rand.Reader)
-	private, err := crypto.GenerateKey()
-	if err != nil {
-		log.Panic(err)
+	for {
+		private, err := crypto.GenerateKey()
+		if err != nil {
+			log.Panic(err)
+		}
+		pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+		// Verify splits the key in the middle, a coordinate with leading
+		// zero bytes would move it. Such keys are drawn again
+		if len(pubKey) == 64 {
+			return *private, pubKey
+		}
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-
-	return *private, pubKey
 }
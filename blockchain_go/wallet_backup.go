@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// backupFormatVersion is the version written by Backup. The encrypted
+// payload is a wallet file and carries its own walletFormatVersion
+const backupFormatVersion = 1
+
+// backupMagic starts every wallet backup archive, followed by a big endian
+// uint16 format version, the scrypt salt and the AES-GCM nonce
+var backupMagic = []byte("SWCBACKUP")
+
+const (
+	backupSaltLen   = 16
+	backupNonceLen  = 12
+	backupHeaderLen = 9 + 2 + backupSaltLen + backupNonceLen
+
+	backupScryptN = 1 << 15
+	backupScryptR = 8
+	backupScryptP = 1
+)
+
+// Backup writes every wallet entry with its labels and metadata to a single
+// archive at path, encrypted with a key derived from passphrase
+func (ws *Wallets) Backup(path, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("backup passphrase can't be empty")
+	}
+	payload, err := encodeWalletFile(ws.snapshot())
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, backupHeaderLen)
+	header = append(header, backupMagic...)
+	header = append(header, 0, 0)
+	binary.BigEndian.PutUint16(header[len(backupMagic):], backupFormatVersion)
+	random := make([]byte, backupSaltLen+backupNonceLen)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	header = append(header, random...)
+
+	aead, err := newBackupCipher(passphrase, header)
+	if err != nil {
+		return err
+	}
+	nonce := header[backupHeaderLen-backupNonceLen:]
+	archive := aead.Seal(header, nonce, payload, header)
+
+	return WriteFileAtomic(path, archive, 0600)
+}
+
+// Restore reads an archive written by Backup. Without merge the wallet
+// entries are replaced by the archive; with merge the archived addresses
+// missing from Wallets are added and the others skipped. An address whose
+// key differs from the archived one is an error in both cases and leaves
+// Wallets unchanged. Returns the number of addresses added
+func (ws *Wallets) Restore(path, passphrase string, merge bool) (int, error) {
+	archive, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(archive) < backupHeaderLen || !bytes.HasPrefix(archive, backupMagic) {
+		return 0, fmt.Errorf("%s is not a wallet backup, expected magic %q", path, backupMagic)
+	}
+	header := archive[:backupHeaderLen]
+	version := binary.BigEndian.Uint16(header[len(backupMagic):])
+	if version != backupFormatVersion {
+		return 0, fmt.Errorf("%s: unsupported backup format version %d", path, version)
+	}
+
+	aead, err := newBackupCipher(passphrase, header)
+	if err != nil {
+		return 0, err
+	}
+	nonce := header[backupHeaderLen-backupNonceLen:]
+	payload, err := aead.Open(nil, nonce, archive[backupHeaderLen:], header)
+	if err != nil {
+		return 0, fmt.Errorf("%s: wrong passphrase or damaged backup", path)
+	}
+	restored, _, err := decodeWalletFile(path, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	ws.mu.Lock()
+	for address, wallet := range restored.Wallets {
+		if existing, ok := ws.Wallets[address]; ok && !sameKey(existing, wallet) {
+			ws.mu.Unlock()
+			return 0, fmt.Errorf("address %s in the backup has a different key than the wallet", address)
+		}
+	}
+
+	added := 0
+	if merge {
+		for address, wallet := range restored.Wallets {
+			if _, ok := ws.Wallets[address]; ok {
+				continue
+			}
+			if _, err := ws.getByLabel(wallet.Label); err == nil {
+				wallet.Label = ""
+			}
+			ws.Wallets[address] = wallet
+			added++
+		}
+		if ws.Default == "" {
+			ws.Default = restored.Default
+		}
+	} else {
+		for address := range restored.Wallets {
+			if _, ok := ws.Wallets[address]; !ok {
+				added++
+			}
+		}
+		ws.Wallets = restored.Wallets
+		ws.Default = restored.Default
+		ws.RescanState = restored.RescanState
+	}
+	ws.balances = nil
+	ws.mu.Unlock()
+
+	if ws.nodeID != "" {
+		if err := ws.SaveToFile(ws.nodeID); err != nil {
+			return added, err
+		}
+	}
+
+	return added, nil
+}
+
+// newBackupCipher derives the archive key from passphrase and the salt in
+// header
+func newBackupCipher(passphrase string, header []byte) (cipher.AEAD, error) {
+	salt := header[len(backupMagic)+2 : len(backupMagic)+2+backupSaltLen]
+	key, err := scrypt.Key([]byte(passphrase), salt, backupScryptN, backupScryptR, backupScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sameKey reports whether two entries for an address hold the same key
+func sameKey(a, b *Wallet) bool {
+	if !bytes.Equal(a.PublicKey, b.PublicKey) || a.SignerURI != b.SignerURI {
+		return false
+	}
+	if a.PrivateKey.D == nil || b.PrivateKey.D == nil {
+		return a.PrivateKey.D == nil && b.PrivateKey.D == nil
+	}
+
+	return a.PrivateKey.D.Cmp(b.PrivateKey.D) == 0
+}
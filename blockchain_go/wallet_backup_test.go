@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRestore(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		ws.Wallets[address].Label = "savings"
+		ws.Default = address
+		assert.NotNil(t, ws.Backup("wallet.backup", ""))
+		assert.Nil(t, ws.Backup("wallet.backup", "secret"))
+
+		restored := &Wallets{Wallets: make(map[string]*Wallet)}
+		_, err := restored.Restore("wallet.backup", "wrong", false)
+		assert.NotNil(t, err)
+		assert.Len(t, restored.Wallets, 0)
+
+		added, err := restored.Restore("wallet.backup", "secret", false)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, added)
+		assert.Equal(t, ws.Wallets[address].PrivateKey.D, restored.Wallets[address].PrivateKey.D)
+		assert.Equal(t, "savings", restored.Wallets[address].Label)
+		assert.Equal(t, address, restored.Default)
+
+		_, err = restored.Restore(genWalletDbName("missing"), "secret", false)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRestoreMerge(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		assert.Nil(t, ws.Backup("wallet.backup", "secret"))
+
+		current, other := newTestWallets()
+		current.Wallets[address] = ws.Wallets[address]
+		added, err := current.Restore("wallet.backup", "secret", true)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, added, "Duplicate addresses are skipped")
+		assert.Contains(t, current.Wallets, other)
+
+		current, _ = newTestWallets()
+		added, err = current.Restore("wallet.backup", "secret", true)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, added)
+		assert.Len(t, current.Wallets, 2)
+	})
+}
+
+func TestRestoreKeyConflict(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		assert.Nil(t, ws.Backup("wallet.backup", "secret"))
+
+		current := &Wallets{Wallets: make(map[string]*Wallet)}
+		tampered := *ws.Wallets[address]
+		tampered.PrivateKey.D = new(big.Int).Add(tampered.PrivateKey.D, big.NewInt(1))
+		current.Wallets[address] = &tampered
+
+		for _, merge := range []bool{false, true} {
+			_, err := current.Restore("wallet.backup", "secret", merge)
+			assert.NotNil(t, err, fmt.Sprintf("merge %v", merge))
+			assert.Equal(t, tampered.PrivateKey.D, current.Wallets[address].PrivateKey.D, "The wallet key is kept")
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// walletFormatVersion is the version written by SaveToFile. Bump it, and add
+// a decoder to walletDecoders, whenever the layout of Wallet or Wallets changes
+const walletFormatVersion = 6
+
+// walletMagic starts every versioned wallet file, followed by a big endian
+// uint16 format version
+var walletMagic = []byte("SWCWALLET")
+
+const walletHeaderLen = 9 + 2
+
+// walletDecoders decode the payload of each known wallet file version into
+// the current in-memory layout. Version 0 is the legacy headerless gob,
+// version 2 added Wallet.Label and Wallet.CreatedAt, version 3 added
+// Wallet.History and Wallets.RescanState, version 4 added Wallets.Default,
+// version 5 added Wallet.SignerURI, version 6 added Wallet.Retired,
+// Wallet.RetiredBy and Wallet.DeleteAfter
+var walletDecoders = map[uint16]func(payload []byte) (*Wallets, error){
+	0: decodeWalletsGob,
+	1: decodeWalletsGob,
+	2: decodeWalletsGob,
+	3: decodeWalletsGob,
+	4: decodeWalletsGob,
+	5: decodeWalletsGob,
+	6: decodeWalletsGob,
+}
+
+func init() {
+	// the curve is stored as an interface value inside ecdsa.PrivateKey
+	gob.Register(crypto.S256())
+}
+
+// decodeWalletsGob decodes a gob encoded Wallets struct
+func decodeWalletsGob(payload []byte) (wallets *Wallets, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			wallets, err = nil, fmt.Errorf("gob decoder failed: %v", r)
+		}
+	}()
+
+	wallets = &Wallets{}
+	decoder := gob.NewDecoder(bytes.NewReader(payload))
+	err = decoder.Decode(wallets)
+	if err != nil {
+		return nil, err
+	}
+	// gob omits empty maps, so a file saved after deleting the last
+	// wallet decodes to a nil map
+	if wallets.Wallets == nil {
+		wallets.Wallets = make(map[string]*Wallet)
+	}
+
+	return wallets, nil
+}
+
+// encodeWalletFile serializes wallets in the current file format
+func encodeWalletFile(ws *Wallets) ([]byte, error) {
+	var content bytes.Buffer
+
+	content.Write(walletMagic)
+	binary.Write(&content, binary.BigEndian, uint16(walletFormatVersion))
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	return content.Bytes(), nil
+}
+
+// decodeWalletFile parses the content of a wallet file and returns the
+// wallets along with the format version the file was written in
+func decodeWalletFile(walletFile string, content []byte) (*Wallets, uint16, error) {
+	if !bytes.HasPrefix(content, walletMagic) {
+		wallets, err := walletDecoders[0](content)
+		if err != nil {
+			return nil, 0, fmt.Errorf("wallet file %s: missing header, expected magic %q, and not a legacy wallet: %v", walletFile, walletMagic, err)
+		}
+		return wallets, 0, nil
+	}
+	if len(content) < walletHeaderLen {
+		return nil, 0, fmt.Errorf("wallet file %s: truncated header after magic %q", walletFile, walletMagic)
+	}
+
+	version := binary.BigEndian.Uint16(content[len(walletMagic):walletHeaderLen])
+	decode, ok := walletDecoders[version]
+	if !ok || version == 0 {
+		return nil, version, fmt.Errorf("wallet file %s: unsupported format version %d (this build reads up to %d)", walletFile, version, walletFormatVersion)
+	}
+	wallets, err := decode(content[walletHeaderLen:])
+	if err != nil {
+		return nil, version, fmt.Errorf("wallet file %s: version %d: %v", walletFile, version, err)
+	}
+
+	return wallets, version, nil
+}
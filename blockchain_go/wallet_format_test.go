@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadLegacyWalletFile(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+
+		var legacy bytes.Buffer
+		err := gob.NewEncoder(&legacy).Encode(ws)
+		assert.Nil(t, err)
+		err = ioutil.WriteFile(genWalletDbName("test"), legacy.Bytes(), 0600)
+		assert.Nil(t, err)
+
+		loaded := Wallets{}
+		err = loaded.LoadFromFile("test")
+		assert.Nil(t, err)
+		assert.Contains(t, loaded.Wallets, address)
+
+		content, _ := ioutil.ReadFile(genWalletDbName("test"))
+		assert.True(t, bytes.HasPrefix(content, walletMagic), "Legacy file is re-saved with a header")
+		_, version, err := decodeWalletFile("test", content)
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(walletFormatVersion), version)
+	})
+}
+
+func TestDecodeWalletFileBadHeader(t *testing.T) {
+	ws, _ := newTestWallets()
+	content, err := encodeWalletFile(ws)
+	assert.Nil(t, err)
+
+	_, _, err = decodeWalletFile("wallet_test.dat", []byte("garbage that is not a wallet"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "wallet_test.dat")
+	assert.Contains(t, err.Error(), string(walletMagic))
+
+	future := append([]byte{}, content...)
+	future[len(walletMagic)] = 0xff
+	_, _, err = decodeWalletFile("wallet_test.dat", future)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unsupported format version")
+
+	_, _, err = decodeWalletFile("wallet_test.dat", walletMagic)
+	assert.NotNil(t, err)
+}
+
+func TestLoadTruncatedWalletFile(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, _ := newTestWallets()
+		content, err := encodeWalletFile(ws)
+		assert.Nil(t, err)
+		truncated := content[:len(content)/2]
+		err = ioutil.WriteFile(genWalletDbName("test"), truncated, 0600)
+		assert.Nil(t, err)
+
+		loaded := Wallets{}
+		assert.NotPanics(t, func() { err = loaded.LoadFromFile("test") })
+		assert.NotNil(t, err)
+		assert.False(t, os.IsNotExist(err))
+		assert.Contains(t, err.Error(), genWalletDbName("test"))
+
+		loaded.Wallets = map[string]*Wallet{}
+		err = loaded.SaveToFile("test")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "refusing to overwrite")
+		onDisk, _ := ioutil.ReadFile(genWalletDbName("test"))
+		assert.Equal(t, truncated, onDisk, "Unreadable wallet file is left alone")
+
+		WalletRecovery = true
+		defer func() { WalletRecovery = false }()
+		assert.Nil(t, loaded.SaveToFile("test"))
+		corrupt, _ := filepath.Glob(genWalletDbName("test") + ".corrupt.*")
+		assert.Len(t, corrupt, 1)
+		if len(corrupt) == 1 {
+			kept, _ := ioutil.ReadFile(corrupt[0])
+			assert.Equal(t, truncated, kept)
+		}
+		_, _, err = readWalletFile(genWalletDbName("test"))
+		assert.Nil(t, err)
+	})
+}
+
+func TestLoadCorruptedWalletFileFromBackup(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		assert.Nil(t, ws.SaveToFile("test"))
+		assert.Nil(t, ws.SaveToFile("test"))
+
+		corrupted, _ := ioutil.ReadFile(genWalletDbName("test"))
+		for i := walletHeaderLen; i < len(corrupted); i++ {
+			corrupted[i] ^= 0x5a
+		}
+		err := ioutil.WriteFile(genWalletDbName("test"), corrupted, 0600)
+		assert.Nil(t, err)
+
+		loaded := Wallets{}
+		err = loaded.LoadFromFile("test")
+		assert.Nil(t, err)
+		assert.Contains(t, loaded.Wallets, address)
+
+		err = loaded.SaveToFile("test")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "refusing to overwrite")
+		onDisk, _ := ioutil.ReadFile(genWalletDbName("test"))
+		assert.Equal(t, corrupted, onDisk)
+		_, _, err = readWalletFile(walletBackupName(genWalletDbName("test"), 0))
+		assert.Nil(t, err, "Backup is not rotated away")
+	})
+}
+
+func TestLoadMissingWalletFile(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws := Wallets{}
+		err := ws.LoadFromFile("test")
+		assert.True(t, os.IsNotExist(err))
+	})
+}
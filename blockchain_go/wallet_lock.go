@@ -0,0 +1,109 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walletLockStaleAfter is how old an unreadable lock file must be before it
+// is considered left over from a crash rather than still being written
+const walletLockStaleAfter = 10 * time.Second
+
+// WalletInUseError is returned when another process, or another Wallets
+// handle of this process, holds the lock of a wallet file
+type WalletInUseError struct {
+	File string
+	PID  int
+}
+
+func (e *WalletInUseError) Error() string {
+	return fmt.Sprintf("wallet %s is in use by PID %d", e.File, e.PID)
+}
+
+// heldWalletLocks tracks the lock files owned by this process, so a lock file
+// naming our own PID that we don't hold is recognised as stale
+var (
+	heldWalletLocks   = make(map[string]bool)
+	heldWalletLocksMu sync.Mutex
+)
+
+// walletLock is an advisory lock on a wallet file, implemented as a
+// wallet_X.dat.lock file holding the PID of the owner
+type walletLock struct {
+	path string
+}
+
+// acquireWalletLock locks walletFile, removing the lock file of a process
+// that no longer exists
+func acquireWalletLock(walletFile string) (*walletLock, error) {
+	path := walletFile + ".lock"
+
+	heldWalletLocksMu.Lock()
+	defer heldWalletLocksMu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		fl, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = fl.WriteString(strconv.Itoa(os.Getpid()))
+			if closeErr := fl.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			heldWalletLocks[path] = true
+			return &walletLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		pid, stale := inspectWalletLock(path)
+		if !stale {
+			return nil, &WalletInUseError{File: walletFile, PID: pid}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("can't lock wallet %s", walletFile)
+}
+
+// inspectWalletLock returns the PID recorded in a lock file and whether the
+// lock is stale. The caller holds heldWalletLocksMu
+func inspectWalletLock(path string) (int, bool) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, true
+	}
+	pid, parseErr := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || parseErr != nil {
+		info, statErr := os.Stat(path)
+		return 0, statErr == nil && time.Since(info.ModTime()) > walletLockStaleAfter
+	}
+
+	if pid == os.Getpid() {
+		return pid, !heldWalletLocks[path]
+	}
+	return pid, !processAlive(pid)
+}
+
+// release removes the lock file
+func (l *walletLock) release() error {
+	heldWalletLocksMu.Lock()
+	defer heldWalletLocksMu.Unlock()
+
+	delete(heldWalletLocks, l.path)
+	err := os.Remove(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletLockExcludesSecondHandle(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		first, err := NewWallets("test")
+		assert.True(t, os.IsNotExist(err))
+		wallet := NewWallet()
+		first.Wallets[fmt.Sprintf("%s", wallet.GetAddress())] = wallet
+		assert.Nil(t, first.SaveToFile("test"))
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		handles := make([]*Wallets, 2)
+		for i := range handles {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				handles[i], errs[i] = NewWallets("test")
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			inUse, ok := err.(*WalletInUseError)
+			assert.True(t, ok)
+			if ok {
+				assert.Equal(t, os.Getpid(), inUse.PID)
+			}
+		}
+
+		assert.Nil(t, first.Close())
+		second, err := NewWallets("test")
+		assert.Nil(t, err)
+		assert.Equal(t, len(first.Wallets), len(second.Wallets))
+
+		_, err = NewWallets("test")
+		assert.NotNil(t, err)
+
+		unlocked := Wallets{Wallets: second.Wallets}
+		err = unlocked.SaveToFile("test")
+		var inUse *WalletInUseError
+		assert.True(t, errors.As(err, &inUse))
+
+		readOnly, err := NewWalletsReadOnly("test")
+		assert.Nil(t, err)
+		assert.Equal(t, len(second.Wallets), len(readOnly.Wallets))
+
+		assert.Nil(t, second.Close())
+	})
+}
+
+func TestWalletLockStale(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		lockFile := genWalletDbName("test") + ".lock"
+
+		// left over by an earlier run of this process that crashed
+		err := ioutil.WriteFile(lockFile, []byte(strconv.Itoa(os.Getpid())), 0600)
+		assert.Nil(t, err)
+
+		ws, err := NewWallets("test")
+		assert.True(t, os.IsNotExist(err))
+		assert.Nil(t, ws.Close())
+
+		_, err = os.Stat(lockFile)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,16 @@
+// +build windows
+
+package core
+
+import "os"
+
+// processAlive reports whether a process with the given PID exists. On
+// Windows FindProcess fails when there is no such process
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
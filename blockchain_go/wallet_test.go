@@ -0,0 +1,61 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase58LeadingZeros(t *testing.T) {
+	for _, data := range [][]byte{{0, 0, 1, 2}, {0}, {1, 0}, {0xff, 0xff}} {
+		encoded := Base58Encode(data)
+		assert.Equal(t, data, Base58Decode(encoded), "%x encoded as %s", data, encoded)
+	}
+	assert.Equal(t, "11Ldp", string(Base58Encode([]byte{0, 0, 1, 2, 3})))
+	assert.Nil(t, Base58Decode([]byte("1O0")))
+
+	// a public key hash starting with zero bytes
+	pubKeyHash := append(make([]byte, 2), bytes.Repeat([]byte{7}, pubKeyHashLen-2)...)
+	address := string(GetAddressFromPubkeyHash(pubKeyHash))
+	decoded, err := GetPubKeyHashFromAddress(address)
+	assert.Nil(t, err)
+	assert.Equal(t, pubKeyHash, decoded)
+}
+
+func TestCheckAddress(t *testing.T) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+	assert.Nil(t, CheckAddress(address))
+	assert.Nil(t, CheckAddress(wallet.GetBech32Address()))
+
+	flipped := []byte(address)
+	if flipped[10] == 'z' {
+		flipped[10] = 'y'
+	} else {
+		flipped[10] = 'z'
+	}
+	assert.Equal(t, ErrAddressChecksum, CheckAddress(string(flipped)))
+	// a character short may still decode to 25 bytes, failing the checksum
+	assert.NotNil(t, CheckAddress(address[:len(address)-1]))
+	assert.Equal(t, ErrAddressLength, CheckAddress(address[:len(address)-3]))
+	assert.Equal(t, ErrAddressLength, CheckAddress(""))
+	assert.Equal(t, ErrAddressEncoding, CheckAddress(address[:5]+"0"+address[6:]))
+	assert.False(t, ValidateAddress(string(flipped)))
+
+	// the same key on the main network
+	versionedPayload := append([]byte{MainNetParams.AddressVersion}, HashPubKey(wallet.PublicKey)...)
+	mainAddress := string(Base58Encode(append(versionedPayload, checksum(versionedPayload)...)))
+	assert.NotEqual(t, MainNetParams.AddressVersion, ActiveNetParams.AddressVersion)
+	assert.Equal(t, ErrAddressNetwork, CheckAddress(mainAddress))
+	_, err := GetPubKeyHashFromAddress(mainAddress)
+	assert.Equal(t, fmt.Sprintf("invalid address %s: %v", mainAddress, ErrAddressNetwork), err.Error())
+	assert.Panics(t, func() { NewTXOutput(1, mainAddress) })
+
+	defer func(params *NetParams) { ActiveNetParams = params }(ActiveNetParams)
+	ActiveNetParams = &MainNetParams
+	assert.Nil(t, CheckAddress(mainAddress))
+	assert.Equal(t, mainAddress, string(wallet.GetAddress()))
+	assert.Equal(t, ErrAddressNetwork, CheckAddress(address))
+}
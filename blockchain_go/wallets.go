@@ -1,42 +1,107 @@
 package core
 
 import (
-	"bytes"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"os/exec"
 	"path/filepath"
+	"time"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
 const walletFile = "wallet_%s.dat"
 const privKeyBytesLen = 32
 
-// Wallets stores a collection of wallets
+// ErrWalletNotFound is returned when an address is not in the wallet file
+var ErrWalletNotFound = errors.New("wallet not found")
+
+// MaxLabelLength is the maximum length of an address label
+const MaxLabelLength = 64
+
+// WalletBackups is the number of previous wallet file versions kept
+// by SaveToFile (wallet_X.dat.bak, wallet_X.dat.bak.1, ...)
+var WalletBackups = 1
+
+// WalletRecovery lets SaveToFile replace a wallet file that failed to load.
+// The unreadable file is kept as wallet_X.dat.corrupt.TIMESTAMP
+var WalletRecovery = false
+
+// Wallets stores a collection of wallets. Its methods are safe for concurrent
+// use; code reading the exported fields directly must not race with them
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets     map[string]*Wallet
+	RescanState *RescanState // progress of an interrupted Rescan
+	Default     string       // address used when a command omits one
+
+	nodeID   string         // wallet file the collection was loaded from
+	lock     *walletLock    // held from NewWallets until Close
+	readOnly bool           // loaded by NewWalletsReadOnly
+	damaged  string         // wallet file that failed to decode in LoadFromFile
+	balances map[string]int // balance cache by address, nil when it needs a recount
+
+	mu     sync.RWMutex // guards the exported fields and balances
+	saveMu sync.Mutex   // orders concurrent SaveToFile calls
 }
 
-// NewWallets creates Wallets and fills it from a file if it exists
+// NewWallets locks the wallet file and fills Wallets from it if it exists.
+// The lock is held until Close, so a long running node keeps other processes
+// from overwriting its wallet; a *WalletInUseError is returned when another
+// process holds it
 func NewWallets(nodeID string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
 	nodeID = genWalletFileName(nodeID)
+	wallets.nodeID = nodeID
+
+	lock, err := acquireWalletLock(genWalletDbName(nodeID))
+	if err != nil {
+		return &wallets, err
+	}
+	wallets.lock = lock
+	err = wallets.LoadFromFile(nodeID)
+
+	return &wallets, err
+}
+
+// NewWalletsReadOnly fills Wallets from the wallet file without locking it,
+// for commands that only read keys while a node may hold the lock. Saving
+// such Wallets fails when the file is locked
+func NewWalletsReadOnly(nodeID string) (*Wallets, error) {
+	wallets := Wallets{}
+	wallets.Wallets = make(map[string]*Wallet)
+	nodeID = genWalletFileName(nodeID)
+	wallets.nodeID = nodeID
+	wallets.readOnly = true
 	err := wallets.LoadFromFile(nodeID)
 
 	return &wallets, err
 }
 
+// Close releases the lock on the wallet file
+func (ws *Wallets) Close() error {
+	if ws.lock == nil {
+		return nil
+	}
+	err := ws.lock.release()
+	ws.lock = nil
+
+	return err
+}
+
 // CreateWallet adds a Wallet to Wallets
 func (ws *Wallets) CreateWallet() string {
 	wallet := NewWallet()
 	address := fmt.Sprintf("%s", wallet.GetAddress())
 
+	ws.mu.Lock()
 	ws.Wallets[address] = wallet
+	ws.mu.Unlock()
 
 	d := wallet.PrivateKey.D.Bytes()
 
@@ -44,7 +109,7 @@ func (ws *Wallets) CreateWallet() string {
 	priKey := paddedAppend(privKeyBytesLen, b, d)
 	priKeySt := fmt.Sprintf("%s:%x\n", address,priKey)
 	//err := ioutil.WriteFile("key.txt", priKey, 0644)
-	fl, err := os.OpenFile("./key.txt", os.O_APPEND|os.O_CREATE, 0644)
+	fl, err := os.OpenFile(DataPath("key.txt"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	//fmt.Sprintf("%s", fl.Name())
 	if(err!=nil){
 		log.Fatal("create key file failed!")
@@ -61,6 +126,9 @@ func (ws *Wallets) CreateWallet() string {
 
 // GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []string {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
 	var addresses []string
 
 	for address := range ws.Wallets {
@@ -70,39 +138,256 @@ func (ws *Wallets) GetAddresses() []string {
 	return addresses
 }
 
-// GetWallet returns a Wallet by its address
-func (ws Wallets) GetWallet(address string) Wallet {
-	wallet := *ws.Wallets[address]
-	prv,_ := crypto.ToECDSA(wallet.PrivateKey.D.Bytes())
+// GetWallet returns a Wallet by its address in either encoding
+func (ws *Wallets) GetWallet(address string) (*Wallet, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	stored, ok := ws.Wallets[CanonicalAddress(address)]
+	if !ok || stored == nil {
+		return nil, ErrWalletNotFound
+	}
+	if stored.SignerURI != "" {
+		wallet := *stored
+		return &wallet, nil
+	}
+	if stored.PrivateKey.D == nil {
+		return nil, fmt.Errorf("wallet %s has no private key", address)
+	}
+
+	// D.Bytes() drops leading zeros, ToECDSA wants exactly 32 bytes
+	d := paddedAppend(privKeyBytesLen, make([]byte, 0, privKeyBytesLen), stored.PrivateKey.D.Bytes())
+	prv, err := crypto.ToECDSA(d)
+	if err != nil {
+		return nil, fmt.Errorf("wallet %s has an invalid private key: %v", address, err)
+	}
+	wallet := *stored
 	wallet.PrivateKey = *prv
-	return wallet
+
+	return &wallet, nil
 }
 
-// LoadFromFile loads wallets from the file
+// CanonicalAddress returns the Base58Check form of address, under which
+// wallets are stored. Invalid addresses are returned unchanged
+func CanonicalAddress(address string) string {
+	if !isBech32Address(address, ActiveNetParams.Bech32HRP) {
+		return address
+	}
+	pubKeyHash, err := DecodeBech32Address(address)
+	if err != nil {
+		return address
+	}
+
+	return fmt.Sprintf("%s", GetAddressFromPubkeyHash(pubKeyHash))
+}
+
+// SetLabel attaches a label to address. Labels are unique within Wallets,
+// an empty label removes it
+func (ws *Wallets) SetLabel(address, label string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return ErrWalletNotFound
+	}
+	label = strings.TrimSpace(label)
+	if len(label) > MaxLabelLength {
+		return fmt.Errorf("label is longer than %d characters", MaxLabelLength)
+	}
+	if label != "" {
+		if other, err := ws.getByLabel(label); err == nil && other != address {
+			return fmt.Errorf("label %q is already used by %s", label, other)
+		}
+	}
+
+	wallet.Label = label
+
+	return nil
+}
+
+// GetByLabel returns the address carrying label
+func (ws *Wallets) GetByLabel(label string) (string, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	return ws.getByLabel(label)
+}
+
+func (ws *Wallets) getByLabel(label string) (string, error) {
+	if label == "" {
+		return "", ErrWalletNotFound
+	}
+	for address, wallet := range ws.Wallets {
+		if wallet.Label == label {
+			return address, nil
+		}
+	}
+
+	return "", ErrWalletNotFound
+}
+
+// SetDefault makes address the default address and persists the change. An
+// empty address clears the default
+func (ws *Wallets) SetDefault(address string) error {
+	ws.mu.Lock()
+	if address != "" {
+		address = CanonicalAddress(address)
+		wallet, ok := ws.Wallets[address]
+		if !ok {
+			ws.mu.Unlock()
+			return ErrWalletNotFound
+		}
+		if wallet.Retired {
+			ws.mu.Unlock()
+			return fmt.Errorf("address %s is retired", address)
+		}
+	}
+	ws.Default = address
+	ws.mu.Unlock()
+
+	if ws.nodeID != "" {
+		return ws.SaveToFile(ws.nodeID)
+	}
+
+	return nil
+}
+
+// GetDefault returns the default address, or the only address that isn't
+// retired when there is just one. Otherwise the error lists the candidates
+func (ws *Wallets) GetDefault() (string, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	if ws.Default != "" {
+		return ws.Default, nil
+	}
+
+	var addresses []string
+	for address, wallet := range ws.Wallets {
+		if !wallet.Retired {
+			addresses = append(addresses, address)
+		}
+	}
+	switch len(addresses) {
+	case 0:
+		return "", errors.New("the wallet has no addresses, create one first")
+	case 1:
+		return addresses[0], nil
+	}
+	sort.Strings(addresses)
+
+	return "", fmt.Errorf("no default address set, choose one of:\n  %s", strings.Join(addresses, "\n  "))
+}
+
+// DeleteWallet removes address from Wallets and persists the change. An
+// address still holding confirmed or pending funds is only removed when force
+// is set; UTXOSet may be nil in that case
+func (ws *Wallets) DeleteWallet(address string, force bool, UTXOSet *UTXOSet) error {
+	ws.mu.RLock()
+	wallet, ok := ws.Wallets[address]
+	ws.mu.RUnlock()
+	if !ok {
+		return ErrWalletNotFound
+	}
+
+	if !force {
+		if UTXOSet == nil {
+			return fmt.Errorf("can't check the balance of %s without a blockchain", address)
+		}
+		outs, err := UTXOSet.FindUTXO(HashPubKey(wallet.PublicKey))
+		if err != nil {
+			return err
+		}
+		balance := 0
+		for _, out := range outs {
+			balance += out.Value
+		}
+		if balance > 0 {
+			return fmt.Errorf("address %s still holds %d coins", address, balance)
+		}
+		pending, err := UTXOSet.PendingOutpoints(HashPubKey(wallet.PublicKey))
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("address %s has %d outputs spent by pending transactions", address, len(pending))
+		}
+	}
+
+	ws.mu.Lock()
+	delete(ws.Wallets, address)
+	delete(ws.balances, address)
+	if ws.Default == address {
+		ws.Default = ""
+	}
+	ws.mu.Unlock()
+
+	if ws.nodeID != "" {
+		return ws.SaveToFile(ws.nodeID)
+	}
+
+	return nil
+}
+
+// LoadFromFile loads wallets from the file, falling back to the most recent
+// readable backup when the file itself can't be decoded. A missing file is
+// reported with an error satisfying os.IsNotExist. A file that is present but
+// unreadable is never overwritten by SaveToFile unless WalletRecovery is set
 func (ws *Wallets) LoadFromFile(nodeID string) error {
 	walletFile := genWalletDbName(nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	var wallets Wallets
-	gob.Register(crypto.S256())
-	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
+	wallets, version, err := readWalletFile(walletFile)
 	if err != nil {
-		log.Panic(err)
+		ws.damaged = walletFile
+		log.Printf("WARNING: wallet file %s is unreadable: %v", walletFile, err)
+		for i := 0; i < WalletBackups; i++ {
+			backupFile := walletBackupName(walletFile, i)
+			if _, statErr := os.Stat(backupFile); os.IsNotExist(statErr) {
+				break
+			}
+			wallets, version, err = readWalletFile(backupFile)
+			if err == nil {
+				log.Printf("WARNING: wallet restored from backup %s, addresses created after it was written are missing", backupFile)
+				break
+			}
+			log.Printf("WARNING: wallet backup %s is unreadable: %v", backupFile, err)
+		}
+		if err != nil {
+			return fmt.Errorf("wallet file %s is unreadable and no backup could be restored: %v", walletFile, err)
+		}
 	}
 
+	ws.mu.Lock()
 	ws.Wallets = wallets.Wallets
+	ws.RescanState = wallets.RescanState
+	ws.Default = wallets.Default
+	ws.balances = nil
+	ws.mu.Unlock()
+
+	if version != walletFormatVersion && !ws.readOnly && ws.damaged == "" {
+		log.Printf("Upgrading wallet file %s from format version %d to %d", walletFile, version, walletFormatVersion)
+		if err := ws.SaveToFile(nodeID); err != nil {
+			return fmt.Errorf("upgrading wallet file %s: %v", walletFile, err)
+		}
+	}
 
 	return nil
 }
 
+// readWalletFile decodes a single wallet file of any known format version
+func readWalletFile(walletFile string) (*Wallets, uint16, error) {
+	fileContent, err := ioutil.ReadFile(walletFile)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return decodeWalletFile(walletFile, fileContent)
+}
+
 func genWalletFileName(nodeID string)string{
 	nodeID = strings.Replace(nodeID, ":", "_", -1)
 	return nodeID
@@ -112,29 +397,118 @@ func genWalletDbName(nodeID string)string{
 	nodeID = genWalletFileName(nodeID)
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 
-	return walletFile
+	return DataPath(walletFile)
 }
 
-// SaveToFile saves wallets to a file
-func (ws Wallets) SaveToFile(nodeID string) {
-	var content bytes.Buffer
+// SaveToFile saves wallets to a file. The file is replaced atomically and
+// the previous version is kept as a backup. A snapshot of the wallets is
+// written, so they may change while the file is written. Wallets not opened
+// by NewWallets lock the file for the duration of the write, a
+// *WalletInUseError is returned when another process holds it
+func (ws *Wallets) SaveToFile(nodeID string) error {
 	walletFile := genWalletDbName(nodeID)
 
-	//gob.Register(elliptic.P256())
-	gob.Register(crypto.S256())
+	ws.saveMu.Lock()
+	defer ws.saveMu.Unlock()
+
+	if ws.lock == nil || ws.nodeID != genWalletFileName(nodeID) {
+		lock, err := acquireWalletLock(walletFile)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+
+	if ws.damaged == walletFile {
+		if _, _, err := readWalletFile(walletFile); err != nil && !os.IsNotExist(err) {
+			if !WalletRecovery {
+				return fmt.Errorf("wallet file %s failed to load, refusing to overwrite it; set WalletRecovery to replace it", walletFile)
+			}
+			// keep the unreadable file out of the backup rotation
+			corrupt := fmt.Sprintf("%s.corrupt.%d", walletFile, time.Now().UnixNano())
+			if err := os.Rename(walletFile, corrupt); err != nil {
+				return fmt.Errorf("moving the unreadable wallet file %s aside: %v", walletFile, err)
+			}
+			log.Printf("WARNING: unreadable wallet file moved to %s", corrupt)
+		}
+	}
 
-	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	content, err := encodeWalletFile(ws.snapshot())
 	if err != nil {
-	log.Panic(err)
+		return fmt.Errorf("encoding wallet file %s: %v", walletFile, err)
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	err = rotateWalletBackups(walletFile)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("rotating the backups of wallet file %s: %v", walletFile, err)
 	}
+
+	err = WriteFileAtomic(walletFile, content, 0600)
+	if err != nil {
+		return fmt.Errorf("writing wallet file %s: %v", walletFile, err)
+	}
+
+	return nil
 }
 
+// snapshot returns a copy of the persisted part of ws that later changes to
+// ws don't touch
+func (ws *Wallets) snapshot() *Wallets {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	copied := &Wallets{
+		Wallets: make(map[string]*Wallet, len(ws.Wallets)),
+		Default: ws.Default,
+	}
+	for address, wallet := range ws.Wallets {
+		w := *wallet
+		w.History = append([]string(nil), wallet.History...)
+		copied.Wallets[address] = &w
+	}
+	if ws.RescanState != nil {
+		state := *ws.RescanState
+		copied.RescanState = &state
+	}
+
+	return copied
+}
+
+// walletBackupName returns the name of the n-th backup of a wallet file,
+// n == 0 being the most recent one
+func walletBackupName(walletFile string, n int) string {
+	if n == 0 {
+		return walletFile + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", walletFile, n)
+}
+
+// rotateWalletBackups shifts existing backups by one and copies the current
+// wallet file to the most recent backup slot
+func rotateWalletBackups(walletFile string) error {
+	if WalletBackups <= 0 {
+		return nil
+	}
+	current, err := ioutil.ReadFile(walletFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := WalletBackups - 1; i > 0; i-- {
+		older := walletBackupName(walletFile, i-1)
+		if _, err := os.Stat(older); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(older, walletBackupName(walletFile, i)); err != nil {
+			return err
+		}
+	}
+
+	return WriteFileAtomic(walletBackupName(walletFile, 0), current, 0600)
+}
 
 // used to turn private key to size bytes
 // paddedAppend appends the src byte slice to dst, returning the new slice.
@@ -147,13 +521,14 @@ func paddedAppend(size uint, dst, src []byte) []byte {
 	return append(dst, src...)
 }
 
-/*get current exec program path*/
+// GetCurrPath returns the directory of the running executable, ending with
+// a path separator
 func GetCurrPath() string {
-	file, _ := exec.LookPath(os.Args[0])
+	file, err := os.Executable()
+	if err != nil {
+		file, _ = exec.LookPath(os.Args[0])
+	}
 	path, _ := filepath.Abs(file)
-	splitstring := strings.Split(path, "\\")
-	size := len(splitstring)
-	splitstring = strings.Split(path, splitstring[size-1])
-	ret := strings.Replace(splitstring[0], "\\", "/", size-1)
-	return ret
+
+	return filepath.Dir(path) + string(filepath.Separator)
 }
\ No newline at end of file
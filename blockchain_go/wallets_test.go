@@ -0,0 +1,271 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWallets() (*Wallets, string) {
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	wallet := NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	ws.Wallets[address] = wallet
+
+	return ws, address
+}
+
+func TestGetWallet(t *testing.T) {
+	ws, address := newTestWallets()
+
+	wallet, err := ws.GetWallet(address)
+	assert.Nil(t, err)
+	assert.Equal(t, ws.Wallets[address].PrivateKey.D, wallet.PrivateKey.D)
+	assert.Equal(t, address, fmt.Sprintf("%s", wallet.GetAddress()))
+}
+
+func TestGetWalletMissingAddress(t *testing.T) {
+	ws, _ := newTestWallets()
+
+	wallet, err := ws.GetWallet("1NoSuchAddressInTheWalletFile")
+	assert.Nil(t, wallet)
+	assert.Equal(t, ErrWalletNotFound, err)
+}
+
+func TestGetWalletCorruptedKey(t *testing.T) {
+	ws, address := newTestWallets()
+	ws.Wallets[address].PrivateKey.D = big.NewInt(0)
+
+	wallet, err := ws.GetWallet(address)
+	assert.Nil(t, wallet)
+	assert.NotNil(t, err)
+
+	ws.Wallets[address].PrivateKey.D = nil
+	wallet, err = ws.GetWallet(address)
+	assert.Nil(t, wallet)
+	assert.NotNil(t, err)
+}
+
+func TestGetWalletLeadingZeroKey(t *testing.T) {
+	ws, _ := newTestWallets()
+
+	d := make([]byte, privKeyBytesLen)
+	for i := 1; i < len(d); i++ {
+		d[i] = byte(i)
+	}
+	prv, err := crypto.ToECDSA(d)
+	assert.Nil(t, err)
+	assert.Equal(t, privKeyBytesLen-1, len(prv.D.Bytes()))
+
+	wallet := newWalletFromKey(prv)
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	ws.Wallets[address] = wallet
+
+	restored, err := ws.GetWallet(address)
+	assert.Nil(t, err)
+	assert.Equal(t, prv.D, restored.PrivateKey.D)
+	assert.Equal(t, prv.PublicKey.X, restored.PrivateKey.PublicKey.X)
+	assert.Equal(t, address, fmt.Sprintf("%s", restored.GetAddress()))
+}
+
+// inTempDir runs f with a fresh temp directory as working directory
+func inTempDir(t testing.TB, f func(dir string)) {
+	dir, err := ioutil.TempDir("", "wallets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	f(dir)
+}
+
+func TestSaveToFileKeepsBackup(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, first := newTestWallets()
+		assert.Nil(t, ws.SaveToFile("test"))
+
+		second := NewWallet()
+		ws.Wallets[fmt.Sprintf("%s", second.GetAddress())] = second
+		assert.Nil(t, ws.SaveToFile("test"))
+
+		info, err := os.Stat(genWalletDbName("test"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// simulate a crash in the middle of a write
+		err = os.Truncate(genWalletDbName("test"), 10)
+		assert.Nil(t, err)
+
+		loaded := Wallets{}
+		err = loaded.LoadFromFile("test")
+		assert.Nil(t, err)
+		assert.Len(t, loaded.Wallets, 1, "Backup holds the previous version")
+		assert.Contains(t, loaded.Wallets, first)
+	})
+}
+
+func TestSaveToFileRotatesBackups(t *testing.T) {
+	defer func(n int) { WalletBackups = n }(WalletBackups)
+	WalletBackups = 3
+
+	inTempDir(t, func(dir string) {
+		ws, _ := newTestWallets()
+		for i := 0; i < 5; i++ {
+			assert.Nil(t, ws.SaveToFile("test"))
+		}
+
+		walletFile := genWalletDbName("test")
+		for i := 0; i < 3; i++ {
+			_, err := os.Stat(walletBackupName(walletFile, i))
+			assert.Nil(t, err)
+		}
+		_, err := os.Stat(walletBackupName(walletFile, 3))
+		assert.True(t, os.IsNotExist(err))
+
+		files, _ := ioutil.ReadDir(dir)
+		assert.Len(t, files, 4, "No temp files are left behind")
+	})
+}
+
+func TestDeleteWallet(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		ws.nodeID = "test"
+
+		err := ws.DeleteWallet("1NoSuchAddressInTheWalletFile", true, nil)
+		assert.Equal(t, ErrWalletNotFound, err)
+
+		err = ws.DeleteWallet(address, false, nil)
+		assert.NotNil(t, err, "Balance can't be checked without a blockchain")
+		assert.Contains(t, ws.Wallets, address)
+
+		err = ws.DeleteWallet(address, true, nil)
+		assert.Nil(t, err)
+		assert.Len(t, ws.Wallets, 0)
+
+		loaded := Wallets{}
+		err = loaded.LoadFromFile("test")
+		assert.Nil(t, err, "Deleting the last wallet leaves a valid file")
+		assert.NotNil(t, loaded.Wallets)
+		assert.Len(t, loaded.Wallets, 0)
+	})
+}
+
+func TestDefaultAddress(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		ws.nodeID = "test"
+
+		def, err := ws.GetDefault()
+		assert.Nil(t, err)
+		assert.Equal(t, address, def, "The only address is the default")
+
+		other := NewWallet()
+		otherAddress := fmt.Sprintf("%s", other.GetAddress())
+		ws.Wallets[otherAddress] = other
+		_, err = ws.GetDefault()
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), address)
+		assert.Contains(t, err.Error(), otherAddress)
+
+		err = ws.SetDefault("1NoSuchAddressInTheWalletFile")
+		assert.Equal(t, ErrWalletNotFound, err)
+		err = ws.SetDefault(other.GetBech32Address())
+		assert.Nil(t, err)
+
+		loaded := Wallets{}
+		err = loaded.LoadFromFile("test")
+		assert.Nil(t, err)
+		def, err = loaded.GetDefault()
+		assert.Nil(t, err)
+		assert.Equal(t, otherAddress, def, "The default is persisted")
+
+		err = ws.DeleteWallet(otherAddress, true, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "", ws.Default)
+		loaded = Wallets{}
+		loaded.LoadFromFile("test")
+		assert.Equal(t, "", loaded.Default)
+	})
+}
+
+func TestSetLabel(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws, address := newTestWallets()
+		other := NewWallet()
+		otherAddress := fmt.Sprintf("%s", other.GetAddress())
+		ws.Wallets[otherAddress] = other
+
+		err := ws.SetLabel(address, "savings")
+		assert.Nil(t, err)
+		err = ws.SetLabel(otherAddress, "savings")
+		assert.NotNil(t, err, "Labels are unique")
+		err = ws.SetLabel(otherAddress, strings.Repeat("x", MaxLabelLength+1))
+		assert.NotNil(t, err)
+		err = ws.SetLabel("1NoSuchAddressInTheWalletFile", "x")
+		assert.Equal(t, ErrWalletNotFound, err)
+
+		assert.Nil(t, ws.SaveToFile("test"))
+		loaded := Wallets{}
+		err = loaded.LoadFromFile("test")
+		assert.Nil(t, err)
+
+		found, err := loaded.GetByLabel("savings")
+		assert.Nil(t, err)
+		assert.Equal(t, address, found)
+		assert.Equal(t, ws.Wallets[address].CreatedAt, loaded.Wallets[address].CreatedAt)
+
+		_, err = loaded.GetByLabel("checking")
+		assert.Equal(t, ErrWalletNotFound, err)
+	})
+}
+
+// TestWalletsConcurrentUse is meant to be run with -race
+func TestWalletsConcurrentUse(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		ws := &Wallets{Wallets: make(map[string]*Wallet), nodeID: "test"}
+
+		var wg sync.WaitGroup
+		created := make(chan string, 40)
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					created <- ws.CreateWallet()
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					for _, address := range ws.GetAddresses() {
+						_, err := ws.GetWallet(address)
+						assert.Nil(t, err)
+					}
+					assert.Nil(t, ws.SaveToFile("test"))
+				}
+			}()
+		}
+		wg.Wait()
+		close(created)
+
+		assert.Len(t, ws.GetAddresses(), 40)
+		for address := range created {
+			assert.Nil(t, ws.DeleteWallet(address, true, nil))
+		}
+		assert.Empty(t, ws.GetAddresses())
+	})
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const whitelistBucket = "whitelist"
+
+// WhitelistedPeer is a peer the node trusts, by its IP address, its node ID
+// in hex or its enode URL, the node keeping connected to the latter
+type WhitelistedPeer struct {
+	Address string    `json:"address"`
+	Added   time.Time `json:"added"`
+}
+
+// WhitelistFile returns the whitelist of the node nodeID, kept apart from
+// the chain like its ban list
+func WhitelistFile(nodeID string) string {
+	return DataPath(fmt.Sprintf("whitelist_%s.db", nodeID))
+}
+
+// updateWhitelist calls f with the whitelist bucket of the node nodeID in a
+// writable transaction
+func updateWhitelist(nodeID string, f func(b *bolt.Bucket) error) error {
+	db, err := openDB(WhitelistFile(nodeID), false, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(whitelistBucket))
+		if err != nil {
+			return err
+		}
+		return f(b)
+	})
+}
+
+// WhitelistPeer adds an address to the whitelist, it returns whether it
+// wasn't there already
+func WhitelistPeer(nodeID, address string) (bool, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(WhitelistedPeer{Address: address, Added: time.Now()}); err != nil {
+		return false, err
+	}
+
+	var added bool
+	err := updateWhitelist(nodeID, func(b *bolt.Bucket) error {
+		if b.Get([]byte(address)) != nil {
+			return nil
+		}
+		added = true
+		return b.Put([]byte(address), buf.Bytes())
+	})
+
+	return added, err
+}
+
+// UnwhitelistPeer removes an address from the whitelist, it returns whether
+// it was there
+func UnwhitelistPeer(nodeID, address string) (bool, error) {
+	var removed bool
+	err := updateWhitelist(nodeID, func(b *bolt.Bucket) error {
+		removed = b.Get([]byte(address)) != nil
+		return b.Delete([]byte(address))
+	})
+
+	return removed, err
+}
+
+// ListWhitelisted returns the whitelist of the node nodeID by address, none
+// before the first entry
+func ListWhitelisted(nodeID string) ([]WhitelistedPeer, error) {
+	path := WhitelistFile(nodeID)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	db, err := openDB(path, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var peers []WhitelistedPeer
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(whitelistBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var peer WhitelistedPeer
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&peer); err != nil {
+				return err
+			}
+			peers = append(peers, peer)
+			return nil
+		})
+	})
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+
+	return peers, err
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhitelist(t *testing.T) {
+	inTempDir(t, func(dir string) {
+		peers, err := ListWhitelisted("test")
+		assert.Nil(t, err)
+		assert.Empty(t, peers)
+
+		for _, address := range []string{"10.0.0.2", "10.0.0.1", "10.0.0.2"} {
+			_, err := WhitelistPeer("test", address)
+			assert.Nil(t, err)
+		}
+		added, err := WhitelistPeer("test", "10.0.0.1")
+		assert.Nil(t, err)
+		assert.False(t, added)
+		peers, err = ListWhitelisted("test")
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(peers)) {
+			assert.Equal(t, "10.0.0.1", peers[0].Address)
+			assert.Equal(t, "10.0.0.2", peers[1].Address)
+			assert.False(t, peers[0].Added.IsZero())
+		}
+
+		removed, err := UnwhitelistPeer("test", "10.0.0.1")
+		assert.Nil(t, err)
+		assert.True(t, removed)
+		removed, err = UnwhitelistPeer("test", "10.0.0.1")
+		assert.Nil(t, err)
+		assert.False(t, removed)
+		peers, err = ListWhitelisted("test")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(peers))
+	})
+}
@@ -111,6 +111,7 @@ func (b *PQueue) Size(priority int) (int, error) {
 	}
 	bucket := tx.Bucket([]byte{byte(uint8(priority))})
 	if bucket == nil {
+		tx.Rollback()
 		return 0, nil
 	}
 	count := bucket.Stats().KeyN
@@ -299,6 +300,9 @@ func (b *PQueue)GetAll(priority int)[][]byte{
 	var mv = [][]byte{}
 	err := b.conn.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte{byte(uint8(priority))})
+		if b == nil {
+			return nil
+		}
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
@@ -313,3 +317,18 @@ func (b *PQueue)GetAll(priority int)[][]byte{
 	}
 	return mv
 }
+
+// Delete removes the message stored under key with Put, reporting whether
+// there was one
+func (b *PQueue) Delete(priority int, key []byte) (bool, error) {
+	found := false
+	err := b.conn.Update(func(tx *bolt.Tx) error {
+		pb := tx.Bucket([]byte{byte(uint8(priority))})
+		if pb == nil || pb.Get(key) == nil {
+			return nil
+		}
+		found = true
+		return pb.Delete(key)
+	})
+	return found, err
+}
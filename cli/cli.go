@@ -4,23 +4,122 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
 	"os"
+	"../blockchain_go"
+	"../p2p/nat"
+	"../p2pprotocol"
 )
 
 // CLI responsible for processing command line arguments
 type CLI struct{}
 
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: [-regtest|-testnet|-genesis FILE] [-txindex] [-datadir DIR] COMMAND")
+	fmt.Println("  -regtest - Use the regtest network, whose difficulty never retargets")
+	fmt.Println("  -testnet - Use the test network")
+	fmt.Println("  -genesis FILE - Use the network whose genesis config is in the JSON file FILE")
+	fmt.Println("  -txindex - Keep an index of the transactions of the chain")
+	fmt.Println("  -datadir DIR - Keep wallets and the blockchain in DIR")
+	fmt.Println("  addnode NODE add|remove|onetry [-rpcport PORT] [-rpchost HOST] - Make the node keep connected to NODE, stop to, or dial it once")
+	fmt.Println("  backupwallet FILE [-passphrase PASSPHRASE] - Write all wallet keys, labels and metadata to the encrypted archive FILE")
+	fmt.Println("  clearbanned - Lift the bans of all peer addresses")
+	fmt.Println("  compactdb [FILE] - Compact the blockchain database through FILE")
 	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
-	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
-	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
+	fmt.Println("  createwallet [-format base58|bech32|both] - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("  disconnectnode ADDRESS [-rpcport PORT] [-rpchost HOST] - Disconnect the peers of ADDRESS from the node")
+	fmt.Println("  dumputxo FILE - Write a snapshot of the UTXO set at the chain tip to FILE")
+	fmt.Println("  estimatefee [N] [-json] - Print the fee rate per 1000 bytes that gets a transaction mined within N blocks")
+	fmt.Println("  exportchain FILE [-from HEIGHT] [-to HEIGHT] - Write the blocks of the chain to FILE")
+	fmt.Println("  getblockchaininfo [-json] - Print the height, tip, work and verification progress of the chain")
+	fmt.Println("  getbalance [-address ADDRESS] [-minconf N] [-all] [-rescan] - Get balance of ADDRESS, or of every address with -all")
+	fmt.Println("  getconnectioncount [-slots] [-rpcport PORT] [-rpchost HOST] - Print the number of peers of the node")
+	fmt.Println("  getpeerinfo [-json] [-rpcport PORT] [-rpchost HOST] - List the peers of the node")
+	fmt.Println("  getrichlist [N] [-json] - List the N addresses with the highest balances in the UTXO set, 10 if omitted")
+	fmt.Println("  gettransaction TXID - Print transaction TXID of the chain and the block holding it")
+	fmt.Println("  gettxproof TXID [-json] - Print the merkle proof that transaction TXID is in the chain")
+	fmt.Println("  gettxoutsetinfo [-json] - Print statistics of the UTXO set and check the total amount against the subsidy schedule")
+	fmt.Println("  importethkeystore FILE [-passphrase PASSPHRASE] - Import the key of the geth keystore FILE")
+	fmt.Println("  importchain FILE - Validate and connect the blocks of FILE, written by exportchain")
+	fmt.Println("  listaddresses [-format base58|bech32|both] - Lists all addresses from the wallet file")
+	fmt.Println("  listbanned [-json] - List the banned peer addresses and node IDs, until when and why")
+	fmt.Println("  listlockunspent [ADDRESS] [-json] - List the unspent outputs locked with lockunspent")
+	fmt.Println("  listtransactions [ADDRESS] [-json] [-rpcport PORT] [-rpchost HOST] - List the transactions of ADDRESS with their status")
+	fmt.Println("  listunspent [ADDRESS] [-minconf N] [-json] [-limit N] [-cursor CURSOR] - List the unspent outputs of ADDRESS, or of all wallet addresses")
+	fmt.Println("  listwhitelist [-json] [-rpcport PORT] [-rpchost HOST] - List the whitelist of the node")
+	fmt.Println("  loadutxo FILE [-tip HASH] - Replace the UTXO set with the snapshot FILE, which must be at the chain tip or at block HASH")
+	fmt.Println("  lockunspent TXID VOUT [-unlock] - Keep output VOUT of transaction TXID out of the outputs send spends")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  removeaddress ADDRESS [-force] - Remove ADDRESS from the wallet file")
+	fmt.Println("  rescan [-address ADDRESS] - Scan the blockchain for transactions of ADDRESS, or of all wallet addresses")
+	fmt.Println("  restorewallet FILE [-merge] [-passphrase PASSPHRASE] - Replace the wallet with the backup FILE")
+	fmt.Println("  rotatekey [-address ADDRESS] [-fee RATE] [-deleteafter N] [-mine] - Move all mature funds of ADDRESS to a new key and retire ADDRESS")
+	fmt.Println("  send [-from FROM] -to TO -amount AMOUNT [-minconf N] [-fee RATE] -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
+	fmt.Println("  setban IP|NODEID [-duration D] [-remove] - Refuse connections from a peer for D, or lift the ban")
+	fmt.Println("  setdefault ADDRESS - Make ADDRESS the default for send and getbalance, an empty ADDRESS clears it")
+	fmt.Println("  setlabel -address ADDRESS -label LABEL - Attach LABEL to ADDRESS in the wallet file")
+	fmt.Println("  signmessage -address ADDRESS -message MESSAGE - Sign MESSAGE with the key of ADDRESS")
+	fmt.Println("  startnode [-miner ADDRESS] [FLAGS] - Start the node named by the NODE_ID env. var.")
+	fmt.Println("    -miner ADDRESS - Mine blocks, rewarded to ADDRESS")
+	fmt.Println("    -listen ADDR - Listen on host:port ADDR and advertise it, NODE_ID if omitted")
+	fmt.Println("    -prune-undo N - Keep the UTXO undo data of the last N blocks, 0 keeps all of it")
+	fmt.Println("    -prune N|NMB - Delete the bodies of the blocks below the last N, or below those fitting in N megabytes")
+	fmt.Println("    -checkpoints FILE - Add the checkpoints of the JSON file FILE to those of the network")
+	fmt.Println("    -max-reorg-depth N - Refuse reorganisations disconnecting more than N blocks, 0 allows any")
+	fmt.Println("    -verify-all-sigs - Check the signatures of the blocks below the last checkpoint too")
+	fmt.Println("    -sigcheck-workers N - Check the signatures of a block on N goroutines, 0 for one per CPU")
+	fmt.Println("    -serve-mempool=false - Don't answer the mempool requests of the peers")
+	fmt.Println("    -banscore N - Ban a peer whose misbehaviours score N, 100 if omitted")
+	fmt.Println("    -bantime D - Ban misbehaving peers for D, 24h if omitted")
+	fmt.Println("    -maxupload KB - Send at most KB kilobytes per second to all the peers, 0 for no limit")
+	fmt.Println("    -rpcport PORT - Serve the JSON-RPC API over HTTP on PORT")
+	fmt.Println("    -rpchost HOST - Bind the JSON-RPC and WebSocket listeners to HOST, 127.0.0.1 if omitted")
+	fmt.Println("    -wsport PORT - Serve the JSON-RPC API and its subscriptions over WebSocket on PORT")
+	fmt.Println("    -rest ADDR - Serve the read-only REST queries on ADDR")
+	fmt.Println("    -metrics ADDR - Serve the Prometheus metrics on ADDR/metrics")
+	fmt.Println("    -nat none|upnp|pmp|extip:IP - Map the listen port on the NAT gateway")
+	fmt.Println("    -whitelist PEERS - Whitelist the comma separated IP addresses, node IDs and enode URLs PEERS")
+	fmt.Println("    -connect - Connect to the whitelisted peers only")
+	fmt.Println("    -maxinbound N - Keep at most N inbound peers, 117 if omitted")
+	fmt.Println("    -maxoutbound N - Connect to N peers, 8 if omitted")
+	fmt.Println("  syncstatus [-json] [-rpcport PORT] [-rpchost HOST] - Print whether the node is catching up with its peers")
+	fmt.Println("  verifychainstate [-sample RATE] [-repair] [-threshold N] - Check the UTXO set against the chain")
+	fmt.Println("  verifymessage -address ADDRESS -message MESSAGE -signature SIGNATURE - Check that SIGNATURE of MESSAGE was made by ADDRESS")
+	fmt.Println("  whitelist IP|NODEID|ENODE [-remove] [-rpcport PORT] [-rpchost HOST] - Make the node trust a peer, or stop to with -remove")
+}
+
+// parsePositional sets the values still empty to the positional arguments
+// fs was left with, in order, parsing the flags after each
+func parsePositional(fs *flag.FlagSet, values ...*string) {
+	for _, value := range values {
+		if *value != "" || fs.NArg() == 0 {
+			continue
+		}
+		*value = fs.Arg(0)
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// parsePositionalInt is parsePositional for a number, which the positional
+// argument overrides. One that isn't a number prints the usage of fs
+func parsePositionalInt(fs *flag.FlagSet, value *int) {
+	if fs.NArg() == 0 {
+		return
+	}
+	n, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		fs.Usage()
+		os.Exit(1)
+	}
+	*value = n
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		log.Panic(err)
+	}
 }
 
 func (cli *CLI) validateArgs() {
@@ -32,39 +131,198 @@ func (cli *CLI) validateArgs() {
 
 // Run parses command line arguments and processes commands
 func (cli *CLI) Run() {
+	cli.setupNetwork()
+	cli.setupTxIndex()
+	cli.setupDataDir()
 	cli.validateArgs()
 
 	nodeID := os.Getenv("NODE_ID")
 	//nodeID := "localhost:2000"
 
 	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!")
-		os.Exit(1)
+		// a node given its -listen address needs no NODE_ID
+		nodeID = core.DefaultNodeID
+		os.Setenv("NODE_ID", nodeID)
 	}
 
+	addNodeCmd := flag.NewFlagSet("addnode", flag.ExitOnError)
+	backupWalletCmd := flag.NewFlagSet("backupwallet", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	compactDBCmd := flag.NewFlagSet("compactdb", flag.ExitOnError)
+	clearBannedCmd := flag.NewFlagSet("clearbanned", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	disconnectNodeCmd := flag.NewFlagSet("disconnectnode", flag.ExitOnError)
+	dumpUTXOCmd := flag.NewFlagSet("dumputxo", flag.ExitOnError)
+	estimateFeeCmd := flag.NewFlagSet("estimatefee", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	getBlockchainInfoCmd := flag.NewFlagSet("getblockchaininfo", flag.ExitOnError)
+	getConnectionCountCmd := flag.NewFlagSet("getconnectioncount", flag.ExitOnError)
+	getPeerInfoCmd := flag.NewFlagSet("getpeerinfo", flag.ExitOnError)
+	getRichListCmd := flag.NewFlagSet("getrichlist", flag.ExitOnError)
+	getTxOutSetInfoCmd := flag.NewFlagSet("gettxoutsetinfo", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	getTxProofCmd := flag.NewFlagSet("gettxproof", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	importEthKeystoreCmd := flag.NewFlagSet("importethkeystore", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	listBannedCmd := flag.NewFlagSet("listbanned", flag.ExitOnError)
+	listWhitelistCmd := flag.NewFlagSet("listwhitelist", flag.ExitOnError)
+	listLockUnspentCmd := flag.NewFlagSet("listlockunspent", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	loadUTXOCmd := flag.NewFlagSet("loadutxo", flag.ExitOnError)
+	lockUnspentCmd := flag.NewFlagSet("lockunspent", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	removeAddressCmd := flag.NewFlagSet("removeaddress", flag.ExitOnError)
+	rescanCmd := flag.NewFlagSet("rescan", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	rotateKeyCmd := flag.NewFlagSet("rotatekey", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	setBanCmd := flag.NewFlagSet("setban", flag.ExitOnError)
+	setDefaultCmd := flag.NewFlagSet("setdefault", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	verifyChainstateCmd := flag.NewFlagSet("verifychainstate", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	syncStatusCmd := flag.NewFlagSet("syncstatus", flag.ExitOnError)
+	whitelistCmd := flag.NewFlagSet("whitelist", flag.ExitOnError)
 
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for, the default address if empty")
+	getBalanceAll := getBalanceCmd.Bool("all", false, "Get the balance of every wallet address")
+	getBalanceRescan := getBalanceCmd.Bool("rescan", false, "Rebuild the UTXO set before counting, with -all")
+	getBalanceMinConf := getBalanceCmd.Int("minconf", 1, "Confirmations for an output to count as confirmed")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
+	createWalletFormat := createWalletCmd.String("format", "base58", "Address encoding to display: base58, bech32 or both")
+	importEthKeystoreFile := importEthKeystoreCmd.String("file", "", "The keystore file to import")
+	importEthKeystorePassphrase := importEthKeystoreCmd.String("passphrase", "", "The passphrase of the keystore, asked for if empty")
+	listAddressesFormat := listAddressesCmd.String("format", "base58", "Address encoding to display: base58, bech32 or both")
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list outputs of, all wallet addresses if empty")
+	listUnspentMinConf := listUnspentCmd.Int("minconf", 0, "Only list outputs with at least this many confirmations")
+	listUnspentJSON := listUnspentCmd.Bool("json", false, "Print the outputs as JSON")
+	listUnspentLimit := listUnspentCmd.Int("limit", 0, "List at most this many outputs of the address, 0 lists all of them")
+	listUnspentCursor := listUnspentCmd.String("cursor", "", "Continue with the page after the one that printed this cursor")
+	listLockUnspentAddress := listLockUnspentCmd.String("address", "", "The address to list locked outputs of, all wallet addresses if empty")
+	listLockUnspentJSON := listLockUnspentCmd.Bool("json", false, "Print the outputs as JSON")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "The address to list the transactions of, all wallet addresses if empty")
+	listTransactionsJSON := listTransactionsCmd.Bool("json", false, "Print the transactions as JSON")
+	lockUnspentTxID := lockUnspentCmd.String("txid", "", "The hex encoded ID of the transaction holding the output")
+	lockUnspentVout := lockUnspentCmd.Int("vout", -1, "The index of the output in the transaction")
+	lockUnspentUnlock := lockUnspentCmd.Bool("unlock", false, "Release the output instead of locking it")
+	sendFrom := sendCmd.String("from", "", "Source wallet address, the default address if empty")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendMinConf := sendCmd.Int("minconf", 1, "Only spend outputs with at least this many confirmations, 0 also spends the change of pending transactions")
+	sendFee := sendCmd.Int64("fee", -1, "Fee per 1000 bytes, the estimate for the next blocks when negative")
+	listBannedJSON := listBannedCmd.Bool("json", false, "Print the bans as JSON")
+	setBanAddress := setBanCmd.String("address", "", "The IP address or the node ID of the peer")
+	setBanDuration := setBanCmd.Duration("duration", p2pprotocol.BanDuration, "How long the address stays banned")
+	setBanRemove := setBanCmd.Bool("remove", false, "Lift the ban of the address instead")
+	setLabelAddress := setLabelCmd.String("address", "", "The address to label")
+	setLabelLabel := setLabelCmd.String("label", "", "The label, empty to remove it")
+	signMessageAddress := signMessageCmd.String("address", "", "The address whose key signs the message")
+	signMessageMessage := signMessageCmd.String("message", "", "The message to sign")
+	verifyChainstateSample := verifyChainstateCmd.Float64("sample", 1, "Fraction of the transactions to check, above 0 and at most 1")
+	verifyChainstateRepair := verifyChainstateCmd.Bool("repair", false, "Rebuild the UTXO set when more than -threshold outputs mismatch")
+	verifyChainstateThreshold := verifyChainstateCmd.Int("threshold", 0, "Mismatched outputs tolerated by -repair")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "The address that signed the message")
+	verifyMessageMessage := verifyMessageCmd.String("message", "", "The signed message")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "The hex encoded signature")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodePruneUndo := startNodeCmd.Int("prune-undo", core.DefaultUndoDepth, "Keep the UTXO undo data of this many recent blocks, 0 keeps all of it")
+	startNodePrune := startNodeCmd.String("prune", "", "Keep the bodies of this many recent blocks, or of those fitting in this many megabytes like 550MB")
+	startNodeCheckpoints := startNodeCmd.String("checkpoints", "", "Add the checkpoints of this JSON file to those of the network")
+	startNodeMaxReorgDepth := startNodeCmd.Int("max-reorg-depth", core.MaxReorgDepth, "Refuse reorganisations disconnecting more blocks, 0 allows any")
+	startNodeVerifyAllSigs := startNodeCmd.Bool("verify-all-sigs", !core.SkipSigsBelowCheckpoint, "Check the signatures of the blocks below the last checkpoint too")
+	startNodeSigCheckWorkers := startNodeCmd.Int("sigcheck-workers", core.SigCheckWorkers, "Check the signatures of a block on this many goroutines, 0 for one per CPU")
+	startNodeServeMempool := startNodeCmd.Bool("serve-mempool", p2pprotocol.ServeMempool, "Answer the mempool requests of the peers")
+	startNodeBanScore := startNodeCmd.Int("banscore", p2pprotocol.BanThreshold, "Ban the address of a peer whose misbehaviours score this much")
+	startNodeBanTime := startNodeCmd.Duration("bantime", p2pprotocol.BanDuration, "How long a misbehaving peer stays banned")
+	startNodeMaxUpload := startNodeCmd.Float64("maxupload", p2pprotocol.MaxUploadRate/1024, "Send at most this many KB per second to all the peers, 0 for no limit")
+	startNodeRPCPort := startNodeCmd.Int("rpcport", p2pprotocol.RPCPort, "Serve the JSON-RPC API on this port, 0 for none")
+	startNodeRPCHost := startNodeCmd.String("rpchost", p2pprotocol.RPCHost, "Bind the JSON-RPC listener to this address")
+	startNodeWSPort := startNodeCmd.Int("wsport", p2pprotocol.WSPort, "Serve the JSON-RPC API and its subscriptions over WebSocket on this port, 0 for none")
+	startNodeREST := startNodeCmd.String("rest", p2pprotocol.RESTAddr, "Serve the read-only REST queries on this address, none if empty")
+	startNodeMetrics := startNodeCmd.String("metrics", p2pprotocol.MetricsAddr, "Serve the Prometheus metrics on this address, none if empty")
+	startNodeNAT := startNodeCmd.String("nat", "none", "Map the listen port on the NAT gateway: none, upnp, pmp, pmp:GATEWAY, any or extip:IP")
+	startNodeWhitelist := startNodeCmd.String("whitelist", "", "Whitelist these comma separated IP addresses, node IDs and enode URLs")
+	startNodeConnect := startNodeCmd.Bool("connect", p2pprotocol.WhitelistOnly, "Connect to the whitelisted peers only")
+	startNodeListen := startNodeCmd.String("listen", p2pprotocol.ListenAddr, "Listen on this host:port, NODE_ID if empty")
+	startNodeMaxInbound := startNodeCmd.Int("maxinbound", p2pprotocol.MaxInbound, "Keep at most this many peers connecting to the node, the whitelisted ones aside")
+	startNodeMaxOutbound := startNodeCmd.Int("maxoutbound", p2pprotocol.MaxOutbound, "Connect to this many peers picked from the addresses known")
+	rescanAddress := rescanCmd.String("address", "", "The address to rescan, all wallet addresses if empty")
+	removeAddressAddress := removeAddressCmd.String("address", "", "The address to remove")
+	removeAddressForce := removeAddressCmd.Bool("force", false, "Remove the address even if it holds funds")
+	backupWalletFile := backupWalletCmd.String("file", "", "The archive to write")
+	backupWalletPassphrase := backupWalletCmd.String("passphrase", "", "The passphrase to encrypt the archive with, asked for if empty")
+	restoreWalletFile := restoreWalletCmd.String("file", "", "The archive to restore")
+	restoreWalletMerge := restoreWalletCmd.Bool("merge", false, "Add the missing addresses instead of replacing the wallet")
+	restoreWalletPassphrase := restoreWalletCmd.String("passphrase", "", "The passphrase of the archive, asked for if empty")
+	rotateKeyAddress := rotateKeyCmd.String("address", "", "The address to retire, only delete retired keys that are due if empty")
+	rotateKeyFee := rotateKeyCmd.Int64("fee", 0, "Fee per byte of the sweep transaction")
+	rotateKeyDeleteAfter := rotateKeyCmd.Int("deleteafter", 0, "Delete the retired key once the sweep has this many confirmations, 0 keeps it")
+	rotateKeyMine := rotateKeyCmd.Bool("mine", false, "Mine immediately on the same node")
+	estimateFeeBlocks := estimateFeeCmd.Int("blocks", core.DefaultFeeTarget, "The number of blocks the transaction should be mined within")
+	estimateFeeJSON := estimateFeeCmd.Bool("json", false, "Print the estimate as JSON")
+	getRichListCount := getRichListCmd.Int("count", 10, "The number of addresses to list")
+	getRichListJSON := getRichListCmd.Bool("json", false, "Print the addresses as JSON")
+	getBlockchainInfoJSON := getBlockchainInfoCmd.Bool("json", false, "Print the information as JSON")
+	getPeerInfoJSON := getPeerInfoCmd.Bool("json", false, "Print the peers as JSON")
+	getConnectionCountSlots := getConnectionCountCmd.Bool("slots", false, "Print the connection slots used")
+	syncStatusJSON := syncStatusCmd.Bool("json", false, "Print the sync status as JSON")
+	addNodeNode := addNodeCmd.String("node", "", "The node, enode://NODEID@IP:PORT")
+	addNodeCommand := addNodeCmd.String("command", "", "add, remove or onetry")
+	disconnectNodeAddress := disconnectNodeCmd.String("address", "", "The IP, IP:PORT or node ID of the peer")
+	listWhitelistJSON := listWhitelistCmd.Bool("json", false, "Print the whitelist as JSON")
+	whitelistAddress := whitelistCmd.String("address", "", "The IP address, the node ID or the enode URL of the peer")
+	whitelistRemove := whitelistCmd.Bool("remove", false, "Remove the peer from the whitelist instead")
+	// the commands managing the connections and syncstatus call the JSON-RPC
+	// API of the running node, listtransactions when given -rpcport
+	rpcPorts := make(map[*flag.FlagSet]*int)
+	rpcHosts := make(map[*flag.FlagSet]*string)
+	for _, cmd := range []*flag.FlagSet{addNodeCmd, disconnectNodeCmd, getConnectionCountCmd, getPeerInfoCmd, listTransactionsCmd, listWhitelistCmd, syncStatusCmd, whitelistCmd} {
+		rpcPorts[cmd] = cmd.Int("rpcport", p2pprotocol.RPCPort, "The port the node serves the JSON-RPC API on")
+		rpcHosts[cmd] = cmd.String("rpchost", p2pprotocol.RPCHost, "The address the JSON-RPC API of the node is bound to")
+	}
+	getTxOutSetInfoJSON := getTxOutSetInfoCmd.Bool("json", false, "Print the statistics as JSON")
+	getTransactionTxID := getTransactionCmd.String("txid", "", "The hex encoded ID of the transaction")
+	getTxProofTxID := getTxProofCmd.String("txid", "", "The hex encoded ID of the transaction to prove")
+	getTxProofJSON := getTxProofCmd.Bool("json", false, "Print the proof as JSON")
+	dumpUTXOFile := dumpUTXOCmd.String("file", "", "The snapshot to write")
+	compactDBFile := compactDBCmd.String("file", "", "The file to copy the database into, next to it if omitted")
+	exportChainFile := exportChainCmd.String("file", "", "The export to write")
+	exportChainFrom := exportChainCmd.Int("from", 0, "The height of the first block to export")
+	exportChainTo := exportChainCmd.Int("to", -1, "The height of the last block to export, the tip if negative")
+	importChainFile := importChainCmd.String("file", "", "The export to import")
+	loadUTXOFile := loadUTXOCmd.String("file", "", "The snapshot to load")
+	loadUTXOTip := loadUTXOCmd.String("tip", "", "The hash of the block the snapshot must be taken at, the chain tip if empty")
 
 	switch os.Args[1] {
+	case "backupwallet":
+		err := backupWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(backupWalletCmd, backupWalletFile)
 	case "getbalance":
 		err := getBalanceCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "clearbanned":
+		err := clearBannedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "compactdb":
+		err := compactDBCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(compactDBCmd, compactDBFile)
 	case "createblockchain":
 		err := createBlockchainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -75,11 +333,135 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "dumputxo":
+		err := dumpUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(dumpUTXOCmd, dumpUTXOFile)
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(exportChainCmd, exportChainFile)
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(importChainCmd, importChainFile)
+	case "estimatefee":
+		err := estimateFeeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositionalInt(estimateFeeCmd, estimateFeeBlocks)
+	case "getrichlist":
+		err := getRichListCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositionalInt(getRichListCmd, getRichListCount)
+	case "getblockchaininfo":
+		err := getBlockchainInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "addnode":
+		err := addNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(addNodeCmd, addNodeNode, addNodeCommand)
+	case "disconnectnode":
+		err := disconnectNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(disconnectNodeCmd, disconnectNodeAddress)
+	case "getconnectioncount":
+		err := getConnectionCountCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpeerinfo":
+		err := getPeerInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxoutsetinfo":
+		err := getTxOutSetInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettransaction":
+		err := getTransactionCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(getTransactionCmd, getTransactionTxID)
+	case "gettxproof":
+		err := getTxProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(getTxProofCmd, getTxProofTxID)
+	case "importethkeystore":
+		err := importEthKeystoreCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(importEthKeystoreCmd, importEthKeystoreFile)
 	case "listaddresses":
 		err := listAddressesCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "listbanned":
+		err := listBannedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listwhitelist":
+		err := listWhitelistCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listlockunspent":
+		err := listLockUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(listLockUnspentCmd, listLockUnspentAddress)
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(listTransactionsCmd, listTransactionsAddress)
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(listUnspentCmd, listUnspentAddress)
+	case "loadutxo":
+		err := loadUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(loadUTXOCmd, loadUTXOFile)
+	case "lockunspent":
+		err := lockUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		// the outpoint is given whole or not at all
+		if *lockUnspentTxID == "" && lockUnspentCmd.NArg() >= 2 {
+			parsePositional(lockUnspentCmd, lockUnspentTxID)
+			parsePositionalInt(lockUnspentCmd, lockUnspentVout)
+		}
 	case "printchain":
 		err := printChainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -90,27 +472,99 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "rescan":
+		err := rescanCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "removeaddress":
+		err := removeAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(removeAddressCmd, removeAddressAddress)
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(restoreWalletCmd, restoreWalletFile)
+	case "rotatekey":
+		err := rotateKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "setban":
+		err := setBanCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(setBanCmd, setBanAddress)
+	case "setdefault":
+		err := setDefaultCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setlabel":
+		err := setLabelCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmessage":
+		err := signMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychainstate":
+		err := verifyChainstateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "startnode":
 		err := startNodeCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "syncstatus":
+		err := syncStatusCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "whitelist":
+		err := whitelistCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		parsePositional(whitelistCmd, whitelistAddress)
 	default:
 		cli.printUsage()
 		os.Exit(1)
 	}
 
-	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
+	if backupWalletCmd.Parsed() {
+		if *backupWalletFile == "" {
+			backupWalletCmd.Usage()
 			os.Exit(1)
 		}
-		cli.getBalance(*getBalanceAddress, nodeID)
+		cli.backupWallet(*backupWalletFile, *backupWalletPassphrase, nodeID)
+	}
+
+	if getBalanceCmd.Parsed() {
+		if *getBalanceAll {
+			cli.getBalanceAll(*getBalanceRescan, nodeID)
+			return
+		}
+		cli.getBalance(*getBalanceAddress, *getBalanceMinConf, nodeID)
 	}
 
 	if createBlockchainCmd.Parsed() {
@@ -121,12 +575,173 @@ func (cli *CLI) Run() {
 		cli.createBlockchain(*createBlockchainAddress, nodeID)
 	}
 
+	if clearBannedCmd.Parsed() {
+		cli.clearBanned(nodeID)
+	}
+
+	if compactDBCmd.Parsed() {
+		cli.compactDB(*compactDBFile, nodeID)
+	}
+
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		cli.createWallet(*createWalletFormat, nodeID)
+	}
+
+	if dumpUTXOCmd.Parsed() {
+		if *dumpUTXOFile == "" {
+			dumpUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpUTXO(*dumpUTXOFile, nodeID)
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainFile == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(*exportChainFile, *exportChainFrom, *exportChainTo, nodeID)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainFile == "" {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importChain(*importChainFile, nodeID)
+	}
+
+	if estimateFeeCmd.Parsed() {
+		if *estimateFeeBlocks <= 0 {
+			estimateFeeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.estimateFee(*estimateFeeBlocks, *estimateFeeJSON, nodeID)
+	}
+
+	if getRichListCmd.Parsed() {
+		if *getRichListCount <= 0 {
+			getRichListCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getRichList(*getRichListCount, *getRichListJSON, nodeID)
+	}
+
+	if getBlockchainInfoCmd.Parsed() {
+		cli.getBlockchainInfo(*getBlockchainInfoJSON, nodeID)
+	}
+
+	if addNodeCmd.Parsed() {
+		if *addNodeNode == "" || *addNodeCommand == "" {
+			addNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.addNode(*addNodeNode, *addNodeCommand, *rpcHosts[addNodeCmd], *rpcPorts[addNodeCmd])
+	}
+
+	if disconnectNodeCmd.Parsed() {
+		if *disconnectNodeAddress == "" {
+			disconnectNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.disconnectNode(*disconnectNodeAddress, *rpcHosts[disconnectNodeCmd], *rpcPorts[disconnectNodeCmd])
+	}
+
+	if getConnectionCountCmd.Parsed() {
+		cli.getConnectionCount(*getConnectionCountSlots, *rpcHosts[getConnectionCountCmd], *rpcPorts[getConnectionCountCmd])
+	}
+
+	if getPeerInfoCmd.Parsed() {
+		cli.getPeerInfo(*getPeerInfoJSON, *rpcHosts[getPeerInfoCmd], *rpcPorts[getPeerInfoCmd])
+	}
+
+	if syncStatusCmd.Parsed() {
+		cli.syncStatus(*syncStatusJSON, *rpcHosts[syncStatusCmd], *rpcPorts[syncStatusCmd])
+	}
+
+	if whitelistCmd.Parsed() {
+		if *whitelistAddress == "" {
+			whitelistCmd.Usage()
+			os.Exit(1)
+		}
+		cli.whitelist(*whitelistAddress, *whitelistRemove, *rpcHosts[whitelistCmd], *rpcPorts[whitelistCmd])
+	}
+
+	if listWhitelistCmd.Parsed() {
+		cli.listWhitelist(*listWhitelistJSON, *rpcHosts[listWhitelistCmd], *rpcPorts[listWhitelistCmd])
+	}
+
+	if getTxOutSetInfoCmd.Parsed() {
+		cli.getTxOutSetInfo(*getTxOutSetInfoJSON, nodeID)
+	}
+
+	if getTransactionCmd.Parsed() {
+		if *getTransactionTxID == "" {
+			getTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTransaction(*getTransactionTxID, nodeID)
+	}
+
+	if getTxProofCmd.Parsed() {
+		if *getTxProofTxID == "" {
+			getTxProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTxProof(*getTxProofTxID, *getTxProofJSON, nodeID)
+	}
+
+	if importEthKeystoreCmd.Parsed() {
+		if *importEthKeystoreFile == "" {
+			importEthKeystoreCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importEthKeystore(*importEthKeystoreFile, *importEthKeystorePassphrase, nodeID)
+	}
+
+	if listBannedCmd.Parsed() {
+		cli.listBanned(*listBannedJSON, nodeID)
 	}
 
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+		cli.listAddresses(*listAddressesFormat, nodeID)
+	}
+
+	if listLockUnspentCmd.Parsed() {
+		cli.listLockUnspent(*listLockUnspentAddress, *listLockUnspentJSON, nodeID)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		cli.listTransactions(*listTransactionsAddress, *listTransactionsJSON, *rpcHosts[listTransactionsCmd], *rpcPorts[listTransactionsCmd], nodeID)
+	}
+
+	if listUnspentCmd.Parsed() {
+		// pages are of a single address
+		if *listUnspentLimit < 0 || *listUnspentCursor != "" && *listUnspentLimit == 0 || *listUnspentLimit > 0 && *listUnspentAddress == "" {
+			listUnspentCmd.Usage()
+			os.Exit(1)
+		}
+		if *listUnspentLimit > 0 {
+			cli.listUnspentPage(*listUnspentAddress, *listUnspentMinConf, *listUnspentJSON, *listUnspentLimit, *listUnspentCursor, nodeID)
+			return
+		}
+		cli.listUnspent(*listUnspentAddress, *listUnspentMinConf, *listUnspentJSON, nodeID)
+	}
+
+	if loadUTXOCmd.Parsed() {
+		if *loadUTXOFile == "" {
+			loadUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadUTXO(*loadUTXOFile, *loadUTXOTip, nodeID)
+	}
+
+	if lockUnspentCmd.Parsed() {
+		if *lockUnspentTxID == "" || *lockUnspentVout < 0 {
+			lockUnspentCmd.Usage()
+			os.Exit(1)
+		}
+		cli.lockUnspent(*lockUnspentTxID, *lockUnspentVout, *lockUnspentUnlock, nodeID)
 	}
 
 	if printChainCmd.Parsed() {
@@ -137,13 +752,81 @@ func (cli *CLI) Run() {
 		cli.reindexUTXO(nodeID)
 	}
 
+	if rescanCmd.Parsed() {
+		cli.rescan(*rescanAddress, nodeID)
+	}
+
+	if removeAddressCmd.Parsed() {
+		if *removeAddressAddress == "" {
+			removeAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.removeAddress(*removeAddressAddress, nodeID, *removeAddressForce)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletFile == "" {
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(*restoreWalletFile, *restoreWalletPassphrase, *restoreWalletMerge, nodeID)
+	}
+
+	if rotateKeyCmd.Parsed() {
+		cli.rotateKey(*rotateKeyAddress, *rotateKeyFee, *rotateKeyDeleteAfter, nodeID, *rotateKeyMine)
+	}
+
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendTo == "" || *sendAmount <= 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendMinConf, *sendFee, nodeID, *sendMine)
+	}
+
+	if setBanCmd.Parsed() {
+		if *setBanAddress == "" {
+			setBanCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setBan(*setBanAddress, *setBanDuration, *setBanRemove, nodeID)
+	}
+
+	if setDefaultCmd.Parsed() {
+		if setDefaultCmd.NArg() > 1 {
+			setDefaultCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setDefault(setDefaultCmd.Arg(0), nodeID)
+	}
+
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" {
+			setLabelCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setLabel(*setLabelAddress, *setLabelLabel, nodeID)
+	}
+
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMessage(*signMessageAddress, *signMessageMessage, nodeID)
+	}
+
+	if verifyChainstateCmd.Parsed() {
+		cli.verifyChainstate(*verifyChainstateSample, *verifyChainstateRepair, *verifyChainstateThreshold, nodeID)
+	}
+
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyMessage(*verifyMessageAddress, *verifyMessageMessage, *verifyMessageSignature)
 	}
 
 	// Get address from localmachine
@@ -156,6 +839,52 @@ func (cli *CLI) Run() {
 			os.Exit(1)
 		}
 
-		cli.startNode(nodeID, *startNodeMiner)
+		if *startNodeCheckpoints != "" {
+			checkpoints, err := core.LoadCheckpoints(*startNodeCheckpoints)
+			if err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+				os.Exit(1)
+			}
+			core.UseCheckpoints(checkpoints)
+		}
+		prune, err := core.ParsePruneTarget(*startNodePrune)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		natm, err := nat.Parse(*startNodeNAT)
+		if err != nil {
+			fmt.Printf("ERROR: -nat %s: %s\n", *startNodeNAT, err)
+			os.Exit(1)
+		}
+		core.DefaultPrune = prune
+		core.MaxReorgDepth = *startNodeMaxReorgDepth
+		core.SkipSigsBelowCheckpoint = !*startNodeVerifyAllSigs
+		core.SigCheckWorkers = *startNodeSigCheckWorkers
+		p2pprotocol.ServeMempool = *startNodeServeMempool
+		p2pprotocol.BanThreshold = *startNodeBanScore
+		p2pprotocol.BanDuration = *startNodeBanTime
+		p2pprotocol.MaxUploadRate = *startNodeMaxUpload * 1024
+		p2pprotocol.RPCPort = *startNodeRPCPort
+		p2pprotocol.RPCHost = *startNodeRPCHost
+		p2pprotocol.WSPort = *startNodeWSPort
+		p2pprotocol.RESTAddr = *startNodeREST
+		p2pprotocol.MetricsAddr = *startNodeMetrics
+		p2pprotocol.NAT = natm
+		for _, peer := range strings.Split(*startNodeWhitelist, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				p2pprotocol.Whitelist = append(p2pprotocol.Whitelist, peer)
+			}
+		}
+		p2pprotocol.WhitelistOnly = *startNodeConnect
+		p2pprotocol.ListenAddr = *startNodeListen
+		if *startNodeMaxInbound < 0 || *startNodeMaxOutbound < 0 {
+			fmt.Println("ERROR: -maxinbound and -maxoutbound can't be negative")
+			os.Exit(1)
+		}
+		p2pprotocol.MaxInbound = *startNodeMaxInbound
+		p2pprotocol.MaxOutbound = *startNodeMaxOutbound
+
+		cli.startNode(nodeID, *startNodeMiner, *startNodePruneUndo)
 	}
 }
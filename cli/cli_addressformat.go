@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"../blockchain_go"
+)
+
+// checkAddressFormat exits when format is not a known address encoding
+func checkAddressFormat(format string) {
+	switch format {
+	case "base58", "bech32", "both":
+		return
+	}
+
+	fmt.Printf("ERROR: unknown address format %q\n", format)
+	os.Exit(1)
+}
+
+// formatAddress renders the address of wallet in the requested encoding
+func formatAddress(wallet *core.Wallet, format string) string {
+	switch format {
+	case "bech32":
+		return wallet.GetBech32Address()
+	case "both":
+		return fmt.Sprintf("%s\t%s", wallet.GetAddress(), wallet.GetBech32Address())
+	}
+
+	return fmt.Sprintf("%s", wallet.GetAddress())
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) backupWallet(path, passphrase, nodeID string) {
+	wallets, err := core.NewWalletsReadOnly(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if passphrase == "" {
+		passphrase = readPassphrase("Backup passphrase: ")
+		if readPassphrase("Repeat the passphrase: ") != passphrase {
+			fmt.Println("ERROR: the passphrases don't match")
+			os.Exit(1)
+		}
+	}
+	err = wallets.Backup(path, passphrase)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Backed up %d addresses to %s\n", len(wallets.GetAddresses()), path)
+}
+
+func (cli *CLI) restoreWallet(path, passphrase string, merge bool, nodeID string) {
+	wallets, err := core.NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer wallets.Close()
+
+	if passphrase == "" {
+		passphrase = readPassphrase("Passphrase of " + path + ": ")
+	}
+	added, err := wallets.Restore(path, passphrase, merge)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if merge {
+		fmt.Printf("Merged %d new addresses from %s\n", added, path)
+	} else {
+		fmt.Printf("Restored %d addresses from %s\n", len(wallets.GetAddresses()), path)
+	}
+}
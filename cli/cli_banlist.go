@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"../blockchain_go"
+	"../p2pprotocol"
+)
+
+func (cli *CLI) setBan(target string, duration time.Duration, remove bool, nodeID string) {
+	address, err := p2pprotocol.BanKey(target)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if remove {
+		banned, err := core.UnbanPeer(nodeID, address)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		if !banned {
+			fmt.Printf("%s is not banned\n", address)
+			return
+		}
+		fmt.Printf("Lifted the ban of %s\n", address)
+		return
+	}
+
+	if duration <= 0 {
+		fmt.Println("ERROR: the ban duration must be positive")
+		os.Exit(1)
+	}
+	ban := core.BannedPeer{Address: address, Until: time.Now().Add(duration), Reason: "setban"}
+	if err := core.BanPeer(nodeID, ban); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Banned %s until %s\n", address, ban.Until.Format(time.RFC3339))
+}
+
+func (cli *CLI) listBanned(asJSON bool, nodeID string) {
+	bans, err := core.ListBanned(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		if bans == nil {
+			bans = []core.BannedPeer{}
+		}
+		printJSON(bans)
+		return
+	}
+	for _, ban := range bans {
+		fmt.Printf("%s banned until %s: %s\n", ban.Address, ban.Until.Format(time.RFC3339), ban.Reason)
+	}
+}
+
+func (cli *CLI) clearBanned(nodeID string) {
+	count, err := core.ClearBanned(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Lifted %d bans\n", count)
+}
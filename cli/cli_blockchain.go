@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+// openBlockchain opens the blockchain of nodeID, or prints why it can't and
+// exits
+func openBlockchain(nodeID string) *core.Blockchain {
+	bc, err := core.NewBlockchain(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	return bc
+}
+
+// openBlockchainReadOnly is openBlockchain for the commands that only read
+// the chain, which may run alongside the node and each other
+func openBlockchainReadOnly(nodeID string) *core.Blockchain {
+	bc, err := core.NewBlockchainWithOptions("", core.Options{NodeID: nodeID, ReadOnly: true})
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	return bc
+}
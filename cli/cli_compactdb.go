@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"../blockchain_go"
+)
+
+func (cli *CLI) compactDB(target, nodeID string) {
+	bc := openBlockchain(nodeID)
+	defer bc.Close()
+
+	path := bc.Db.Path()
+	if target == "" {
+		target = path + ".compact"
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	// the copy is never larger than the database
+	free, err := core.FreeDiskSpace(filepath.Dir(target))
+	if err != nil {
+		fmt.Printf("WARNING: can't check the free disk space: %s\n", err)
+	} else if free < uint64(before.Size()) {
+		fmt.Printf("ERROR: the copy needs up to %d bytes in %s, %d are free\n", before.Size(), filepath.Dir(target), free)
+		os.Exit(1)
+	}
+
+	last := -1
+	err = bc.CompactDB(target, func(copied, total int64) {
+		percent := 100
+		if total > 0 {
+			percent = int(copied * 100 / total)
+		}
+		if percent != last {
+			last = percent
+			fmt.Printf("\rCompacting %s: %3d%% (%d of %d bytes)", path, percent, copied, total)
+		}
+	})
+	fmt.Println()
+	if err != nil {
+		fmt.Printf("ERROR: %s, %s is unchanged\n", err, path)
+		os.Exit(1)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Compacted %s from %d to %d bytes\n", path, before.Size(), after.Size())
+}
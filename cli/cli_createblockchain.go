@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"../blockchain_go"
 )
 
@@ -10,11 +11,19 @@ func (cli *CLI) createBlockchain(address, nodeID string) {
 	if !core.ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	bc := core.CreateBlockchain(address, nodeID)
-	defer bc.Db.Close()
+	bc, err := core.CreateBlockchain(address, nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer bc.Close()
 
-	UTXOSet := core.UTXOSet{bc}
-	UTXOSet.Reindex()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	if err := UTXOSet.Reindex(); err != nil {
+		bc.Close()
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
 
 	fmt.Println("Done!")
 }
@@ -2,13 +2,23 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
 	"../blockchain_go"
 )
 
-func (cli *CLI) createWallet(nodeID string) {
-	wallets, _ := core.NewWallets(nodeID)
+func (cli *CLI) createWallet(format, nodeID string) {
+	checkAddressFormat(format)
+	wallets, err := core.NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	defer wallets.Close()
 	address := wallets.CreateWallet()
-	wallets.SaveToFile(nodeID)
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	fmt.Printf("Your new address: %s\n", address)
+	fmt.Printf("Your new address: %s\n", formatAddress(wallets.Wallets[address], format))
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"../blockchain_go"
+)
+
+// setupDataDir takes a -datadir DIR or -datadir=DIR in front of the command
+// off the arguments and selects the data directory. When that directory is
+// empty and the working directory holds files of an older version, it
+// offers to move them
+func (cli *CLI) setupDataDir() {
+	dir := core.DefaultDataDir()
+	if len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "-") {
+		arg := strings.TrimLeft(os.Args[1], "-")
+		switch {
+		case arg == "datadir" && len(os.Args) > 2:
+			dir = os.Args[2]
+			os.Args = append(os.Args[:1], os.Args[3:]...)
+		case strings.HasPrefix(arg, "datadir="):
+			dir = strings.TrimPrefix(arg, "datadir=")
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
+	err := core.SetDataDir(dir)
+	if err != nil {
+		fmt.Printf("ERROR: can't use data directory %s: %s\n", dir, err)
+		os.Exit(1)
+	}
+
+	files, err := core.LegacyDataFiles(".")
+	if err != nil || len(files) == 0 {
+		return
+	}
+	fmt.Printf("The working directory holds data files of an older version, %s is empty:\n", dir)
+	for _, file := range files {
+		fmt.Printf("  %s\n", file)
+	}
+	answer := strings.ToLower(strings.TrimSpace(readLine("Move them to the data directory? [y/N] ")))
+	if answer != "y" && answer != "yes" {
+		return
+	}
+	err = core.MigrateDataFiles(files)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Moved %d files to %s\n", len(files), dir)
+}
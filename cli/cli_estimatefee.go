@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// feeEstimate is what estimatefee -json prints
+type feeEstimate struct {
+	FeeRate int64 `json:"feerate"`
+	Blocks  int   `json:"blocks"`
+}
+
+func (cli *CLI) estimateFee(blocks int, asJSON bool, nodeID string) {
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+
+	feeRate, err := bc.EstimateFee(blocks)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		printJSON(feeEstimate{feeRate, blocks})
+		return
+	}
+	fmt.Printf("Fee rate to be mined within %d blocks: %d per 1000 bytes\n", blocks, feeRate)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func (cli *CLI) exportChain(path string, from, to int, nodeID string) {
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+	if to < 0 {
+		height, _, err := bc.GetBestHeightLastHash()
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		to = int(height.Int64())
+	}
+
+	// write next to path and rename, so a failed export leaves no partial file
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	err = bc.Export(f, from, to)
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Exported the blocks from height %d to %d to %s\n", from, to, path)
+}
+
+func (cli *CLI) importChain(path, nodeID string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	bc := openBlockchain(nodeID)
+	defer bc.Close()
+
+	err = bc.Import(f, func(imported, total int) {
+		if imported%100 == 0 || imported == total {
+			fmt.Printf("\rimported %d/%d blocks", imported, total)
+		}
+	})
+	fmt.Println()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	height, tip, err := bc.GetBestHeight()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %s, the tip is block %s at height %s\n", path, tip, height)
+}
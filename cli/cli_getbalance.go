@@ -1,27 +1,85 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"sort"
 	"../blockchain_go"
 )
 
-func (cli *CLI) getBalance(address, nodeID string) {
-	if !core.ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+func (cli *CLI) getBalance(address string, minConf int, nodeID string) {
+	if address == "" {
+		wallets, err := core.NewWalletsReadOnly(nodeID)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		address, err = wallets.GetDefault()
+		if err != nil {
+			fmt.Printf("ERROR: -address is not set and %s\n", err)
+			os.Exit(1)
+		}
 	}
-	bc := core.NewBlockchain(nodeID)
-	UTXOSet := core.UTXOSet{bc}
-	defer bc.Db.Close()
+	if err := core.CheckAddress(address); err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
+	}
+	bc := openBlockchainReadOnly(nodeID)
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	defer bc.Close()
+
+	pubKeyHash, err := core.GetPubKeyHashFromAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	confirmed, pending, err := UTXOSet.GetBalance(pubKeyHash, minConf)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if pending != 0 {
+		fmt.Printf("Balance of '%s': %d (%+d pending)\n", address, confirmed, pending)
+		return
+	}
+	fmt.Printf("Balance of '%s': %d\n", address, confirmed)
+}
 
-	balance := 0
-	pubKeyHash := core.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+func (cli *CLI) getBalanceAll(rescan bool, nodeID string) {
+	wallets, err := core.NewWalletsReadOnly(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	var bc *core.Blockchain
+	if rescan {
+		bc = openBlockchain(nodeID)
+	} else {
+		bc = openBlockchainReadOnly(nodeID)
+	}
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	defer bc.Close()
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	if rescan {
+		if err := UTXOSet.ReindexContext(context.Background(), nil); err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		wallets.InvalidateBalances()
 	}
 
-	fmt.Printf("Balance of '%s': %d\n", address, balance)
+	addresses := wallets.GetAddresses()
+	sort.Strings(addresses)
+	total := 0
+	for _, address := range addresses {
+		balance, err := wallets.Balance(address, &UTXOSet)
+		if err != nil {
+			fmt.Printf("ERROR: %s: %s\n", address, err)
+			os.Exit(1)
+		}
+		total += balance
+		fmt.Printf("Balance of '%s': %d\n", address, balance)
+	}
+	fmt.Printf("Total: %d\n", total)
 }
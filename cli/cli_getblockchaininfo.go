@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+func (cli *CLI) getBlockchainInfo(asJSON bool, nodeID string) {
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+
+	info, err := bc.ChainInfo()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		content, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(content))
+		return
+	}
+	fmt.Printf("Chain:                 %s\n", info.Chain)
+	fmt.Printf("Height:                %d\n", info.Height)
+	fmt.Printf("Best block:            %s\n", info.BestBlock)
+	fmt.Printf("Chain work:            %x\n", info.ChainWork)
+	fmt.Printf("Tip time:              %s\n", time.Unix(info.TipTime, 0))
+	fmt.Printf("Updated:               %s\n", time.Unix(info.UpdatedAt, 0))
+	fmt.Printf("Verification progress: %.4f\n", info.Progress)
+	if info.Pruned {
+		fmt.Printf("Pruned up to:          %d\n", info.PruneHeight)
+	}
+	fmt.Printf("Database:              %s\n", info.Path)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"../blockchain_go"
+)
+
+func (cli *CLI) getRichList(count int, asJSON bool, nodeID string) {
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	balances, err := UTXOSet.TopBalances(count)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		printJSON(balances)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tADDRESS\tBALANCE\tUTXOS")
+	for i, balance := range balances {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\n", i+1, balance.Address, balance.Balance, balance.Outputs)
+	}
+	w.Flush()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"../blockchain_go"
+)
+
+// setupTxIndex takes a -txindex in front of the command off the arguments
+// and keeps a transaction index with the blockchain, built from the chain
+// the first time
+func (cli *CLI) setupTxIndex() {
+	if len(os.Args) < 2 || !strings.HasPrefix(os.Args[1], "-") || strings.TrimLeft(os.Args[1], "-") != "txindex" {
+		return
+	}
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	core.TxIndexEnabled = true
+}
+
+func (cli *CLI) getTransaction(txID string, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		fmt.Println("ERROR: the transaction ID is not hex encoded")
+		os.Exit(1)
+	}
+
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+
+	tx, block, err := bc.FindTransactionBlock(id)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	height, _, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Block:         %x\n", block.Hash)
+	fmt.Printf("Height:        %d\n", block.Height)
+	fmt.Printf("Confirmations: %d\n", height.Int64()-block.Height.Int64()+1)
+	fmt.Println(tx)
+}
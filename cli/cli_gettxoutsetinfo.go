@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) getTxOutSetInfo(asJSON bool, nodeID string) {
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	stats, err := UTXOSet.Stats()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		content, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(content))
+	} else {
+		fmt.Printf("Height:          %d\n", stats.Height)
+		fmt.Printf("Best block:      %s\n", stats.BestBlock)
+		fmt.Printf("Transactions:    %d\n", stats.Transactions)
+		fmt.Printf("Outputs:         %d\n", stats.Outputs)
+		fmt.Printf("Pubkey hashes:   %d\n", stats.PubKeyHashes)
+		fmt.Printf("Disk size:       %d bytes\n", stats.DiskSize)
+		fmt.Printf("Total amount:    %d\n", stats.TotalAmount)
+		fmt.Printf("Expected supply: %d\n", stats.ExpectedSupply)
+		fmt.Printf("UTXO commitment: %s\n", stats.Commitment)
+	}
+	if stats.Discrepancy != 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: the total amount differs from the subsidy schedule by %+d\n", stats.Discrepancy)
+	}
+}
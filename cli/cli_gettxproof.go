@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type proofNodeJSON struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+type txProofJSON struct {
+	TxID       string          `json:"txid"`
+	BlockHash  string          `json:"blockhash"`
+	Height     int64           `json:"height"`
+	MerkleRoot string          `json:"merkleroot"`
+	Proof      []proofNodeJSON `json:"proof"`
+}
+
+func (cli *CLI) getTxProof(txID string, asJSON bool, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		fmt.Println("ERROR: the transaction ID is not hex encoded")
+		os.Exit(1)
+	}
+
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+
+	proof, err := bc.GetTxProof(id)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if err := bc.VerifyTxProof(proof); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	header, err := bc.GetHeader(proof.BlockHash)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		out := txProofJSON{
+			TxID:       hex.EncodeToString(proof.TxID),
+			BlockHash:  hex.EncodeToString(proof.BlockHash),
+			Height:     proof.Height,
+			MerkleRoot: hex.EncodeToString(header.MerkleRoot),
+			Proof:      []proofNodeJSON{},
+		}
+		for _, node := range proof.Proof {
+			out.Proof = append(out.Proof, proofNodeJSON{hex.EncodeToString(node.Hash), node.Left})
+		}
+		content, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(content))
+		return
+	}
+
+	fmt.Printf("Transaction: %x\n", proof.TxID)
+	fmt.Printf("Block:       %x\n", proof.BlockHash)
+	fmt.Printf("Height:      %d\n", proof.Height)
+	fmt.Printf("Merkle root: %x\n", header.MerkleRoot)
+	for _, node := range proof.Proof {
+		side := "right"
+		if node.Left {
+			side = "left "
+		}
+		fmt.Printf("  %s %x\n", side, node.Hash)
+	}
+	fmt.Println("The proof checks out against the header.")
+}
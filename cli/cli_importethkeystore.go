@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) importEthKeystore(path, passphrase, nodeID string) {
+	wallets, err := core.NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer wallets.Close()
+
+	if passphrase == "" {
+		passphrase = readPassphrase("Passphrase of " + path + ": ")
+	}
+	ethAddress, address, err := wallets.ImportEthKeystore(path, passphrase)
+	if err == core.ErrWalletExists {
+		fmt.Printf("ERROR: %s is already in the wallet as %s\n", ethAddress, address)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Imported %s as %s\n", ethAddress, address)
+}
@@ -6,14 +6,20 @@ import (
 	"../blockchain_go"
 )
 
-func (cli *CLI) listAddresses(nodeID string) {
-	wallets, err := core.NewWallets(nodeID)
+func (cli *CLI) listAddresses(format, nodeID string) {
+	wallets, err := core.NewWalletsReadOnly(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
 	addresses := wallets.GetAddresses()
 
+	checkAddressFormat(format)
 	for _, address := range addresses {
-		fmt.Println(address)
+		wallet := wallets.Wallets[address]
+		if wallet.Label != "" {
+			fmt.Printf("%s\t%s\n", formatAddress(wallet, format), wallet.Label)
+		} else {
+			fmt.Println(formatAddress(wallet, format))
+		}
 	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"../blockchain_go"
+)
+
+type walletTransaction struct {
+	Address string `json:"address"`
+	core.TxStatus
+}
+
+// listTransactions lists the transactions rescan found for address, or for
+// every wallet address, with their confirmations. The statuses are those of
+// the node running with -rpcport port, which knows its mempool, or of the
+// chain read from disk when port is 0
+func (cli *CLI) listTransactions(address string, asJSON bool, host string, port int, nodeID string) {
+	wallets, err := core.NewWalletsReadOnly(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	addresses := wallets.GetAddresses()
+	if address != "" {
+		addresses = []string{core.CanonicalAddress(address)}
+	}
+	sort.Strings(addresses)
+
+	var bc *core.Blockchain
+	if port == 0 {
+		bc = openBlockchainReadOnly(nodeID)
+		defer bc.Close()
+	}
+	txs := []walletTransaction{}
+	for _, address := range addresses {
+		wallet, err := wallets.GetWallet(address)
+		if err != nil {
+			fmt.Printf("ERROR: %s: %s\n", address, err)
+			os.Exit(1)
+		}
+		for _, txID := range wallet.History {
+			entry := walletTransaction{Address: address}
+			if bc == nil {
+				callNode(host, port, &entry.TxStatus, "swc_getTransactionStatus", txID)
+			} else {
+				id, _ := hex.DecodeString(txID)
+				status, err := bc.GetTransactionStatus(id)
+				if err != nil {
+					fmt.Printf("ERROR: %s\n", err)
+					os.Exit(1)
+				}
+				entry.TxStatus = *status
+			}
+			txs = append(txs, entry)
+		}
+	}
+
+	if asJSON {
+		printJSON(txs)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tTXID\tSTATUS\tCONFIRMATIONS\tHEIGHT")
+	for _, tx := range txs {
+		height := "-"
+		if tx.Status == core.TxConfirmed {
+			height = fmt.Sprint(tx.Height)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", tx.Address, tx.TxID, tx.Status, tx.Confirmations, height)
+	}
+	w.Flush()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"../blockchain_go"
+)
+
+type addressOutput struct {
+	Address string `json:"address"`
+	core.UnspentOutput
+}
+
+func (cli *CLI) listUnspent(address string, minConfirmations int, asJSON bool, nodeID string) {
+	var addresses []string
+	if address != "" {
+		if !core.ValidateAddress(address) {
+			fmt.Printf("ERROR: Address %s is not valid\n", address)
+			os.Exit(1)
+		}
+		addresses = []string{address}
+	} else {
+		wallets, err := core.NewWalletsReadOnly(nodeID)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		addresses = wallets.GetAddresses()
+	}
+
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	var outputs []addressOutput
+	for _, address := range addresses {
+		pubKeyHash, err := core.GetPubKeyHashFromAddress(address)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		unspent, err := UTXOSet.ListUnspent(pubKeyHash, minConfirmations)
+		if err != nil {
+			bc.Close()
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		for _, out := range unspent {
+			outputs = append(outputs, addressOutput{address, out})
+		}
+	}
+
+	if asJSON {
+		printJSON(outputs)
+		return
+	}
+	printUnspent(outputs)
+}
+
+// listUnspentPage lists a page of limit outputs of address, the one after
+// cursor or the first. Outputs with less than minConfirmations are left out
+// of the page, which may then hold fewer than limit
+func (cli *CLI) listUnspentPage(address string, minConfirmations int, asJSON bool, limit int, cursor string, nodeID string) {
+	if !core.ValidateAddress(address) {
+		fmt.Printf("ERROR: Address %s is not valid\n", address)
+		os.Exit(1)
+	}
+	pubKeyHash, err := core.GetPubKeyHashFromAddress(address)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	var after []byte
+	if cursor != "" {
+		if after, err = hex.DecodeString(cursor); err != nil {
+			fmt.Println("ERROR: the cursor is not hex encoded")
+			os.Exit(1)
+		}
+	}
+
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	page, next, err := UTXOSet.FindUTXOPage(pubKeyHash, after, limit)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	var outputs []addressOutput
+	for _, out := range page {
+		if out.Confirmations >= minConfirmations {
+			outputs = append(outputs, addressOutput{address, out})
+		}
+	}
+
+	if asJSON {
+		printJSON(struct {
+			Outputs []addressOutput `json:"outputs"`
+			Cursor  string          `json:"cursor,omitempty"`
+		}{outputs, hex.EncodeToString(next)})
+		return
+	}
+	printUnspent(outputs)
+	if next != nil {
+		fmt.Printf("More outputs follow, continue with -cursor %x\n", next)
+	}
+}
+
+func printJSON(v interface{}) {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(content))
+}
+
+func printUnspent(outputs []addressOutput) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tTXID\tVOUT\tVALUE\tCONFIRMATIONS\tFLAGS")
+	for _, out := range outputs {
+		flags := ""
+		if out.Immature {
+			flags += "immature "
+		}
+		if out.Reserved {
+			flags += "reserved "
+		}
+		if out.Locked {
+			flags += "locked"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", out.Address, out.TxID, out.Vout, out.Value, out.Confirmations, flags)
+	}
+	w.Flush()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) lockUnspent(txID string, vout int, unlock bool, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		fmt.Println("ERROR: the transaction ID is not hex encoded")
+		os.Exit(1)
+	}
+
+	bc := openBlockchain(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	if unlock {
+		err = UTXOSet.UnlockOutpoint(id, vout)
+	} else {
+		err = UTXOSet.LockOutpoint(id, vout)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %s:%d: %s\n", txID, vout, err)
+		os.Exit(1)
+	}
+	if unlock {
+		fmt.Printf("Unlocked %s:%d\n", txID, vout)
+	} else {
+		fmt.Printf("Locked %s:%d\n", txID, vout)
+	}
+}
+
+func (cli *CLI) listLockUnspent(address string, asJSON bool, nodeID string) {
+	var addresses []string
+	if address != "" {
+		if !core.ValidateAddress(address) {
+			fmt.Printf("ERROR: Address %s is not valid\n", address)
+			os.Exit(1)
+		}
+		addresses = []string{address}
+	} else {
+		wallets, err := core.NewWalletsReadOnly(nodeID)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		addresses = wallets.GetAddresses()
+	}
+
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	var outputs []addressOutput
+	for _, address := range addresses {
+		pubKeyHash, err := core.GetPubKeyHashFromAddress(address)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		locked, err := UTXOSet.ListLocked(pubKeyHash)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		for _, out := range locked {
+			outputs = append(outputs, addressOutput{address, out})
+		}
+	}
+
+	if asJSON {
+		printJSON(outputs)
+		return
+	}
+	printUnspent(outputs)
+}
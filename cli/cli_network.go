@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"../blockchain_go"
+)
+
+// setupNetwork takes a -regtest, -testnet or -genesis FILE in front of the
+// command off the arguments and selects the network. -regtest mines and
+// validates blocks with the regtest parameters, which never retarget.
+// -genesis FILE reads the genesis config of a network from a JSON file
+func (cli *CLI) setupNetwork() {
+	if len(os.Args) < 2 || !strings.HasPrefix(os.Args[1], "-") {
+		return
+	}
+	arg := strings.TrimLeft(os.Args[1], "-")
+	var err error
+	switch {
+	case arg == core.RegTestParams.Name || arg == core.TestNetParams.Name:
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		err = core.SelectNetParams(arg)
+	case arg == "genesis" && len(os.Args) > 2:
+		path := os.Args[2]
+		os.Args = append(os.Args[:1], os.Args[3:]...)
+		err = useGenesisConfig(path)
+	case strings.HasPrefix(arg, "genesis="):
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		err = useGenesisConfig(strings.TrimPrefix(arg, "genesis="))
+	default:
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func useGenesisConfig(path string) error {
+	config, err := core.LoadGenesisConfig(path)
+	if err != nil {
+		return err
+	}
+	core.UseGenesisConfig(config)
+
+	return nil
+}
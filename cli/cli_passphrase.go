@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readPassphrase prompts for a passphrase on stdin, so it doesn't end up in
+// the shell history
+func readPassphrase(prompt string) string {
+	return readLine(prompt)
+}
+
+// readLine prompts for a line on stdin and returns it without the newline
+func readLine(prompt string) string {
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Printf("\nERROR: can't read the answer: %s\n", err)
+		os.Exit(1)
+	}
+
+	return strings.TrimRight(line, "\r\n")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"../blockchain_go"
+	"../p2pprotocol"
+	"../rpc"
+)
+
+// callNode calls method of the JSON-RPC API of the node running with
+// -rpcport port, bound to host, and stores the result into result
+func callNode(host string, port int, result interface{}, method string, args ...interface{}) {
+	if port == 0 {
+		fmt.Println("ERROR: -rpcport is needed, the port the node serves the JSON-RPC API on")
+		os.Exit(1)
+	}
+	client, err := rpc.DialHTTP("http://" + net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	if err := client.Call(result, method, args...); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func (cli *CLI) getPeerInfo(asJSON bool, host string, port int) {
+	var infos []p2pprotocol.PeerInfo
+	callNode(host, port, &infos, "admin_getPeerInfo")
+
+	if asJSON {
+		if infos == nil {
+			infos = []p2pprotocol.PeerInfo{}
+		}
+		printJSON(infos)
+		return
+	}
+	printPeers(infos, time.Now())
+}
+
+// since returns how long ago the Unix time unix was at now, - for 0
+func since(unix int64, now time.Time) string {
+	if unix == 0 {
+		return "-"
+	}
+	return now.Sub(time.Unix(unix, 0)).Truncate(time.Second).String()
+}
+
+func printPeers(infos []p2pprotocol.PeerInfo, now time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tDIRECTION\tVERSION\tHEIGHT\tLAST SEND\tLAST RECV\tPING\tSENT\tRECEIVED\tBAN SCORE")
+	for _, info := range infos {
+		direction := "outbound"
+		if info.Inbound {
+			direction = "inbound"
+		}
+		if info.Whitelisted {
+			direction += " whitelisted"
+		}
+		ping := "-"
+		if info.PingTime > 0 {
+			ping = time.Duration(info.PingTime * float64(time.Second)).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\t%d\n", info.ID, info.Address, direction, info.Version, info.BestHeight,
+			since(info.LastSend, now), since(info.LastRecv, now), ping, info.BytesSent, info.BytesRecv, info.BanScore)
+	}
+	w.Flush()
+}
+
+func (cli *CLI) addNode(node, command, host string, port int) {
+	callNode(host, port, nil, "admin_addNode", node, command)
+
+	switch command {
+	case p2pprotocol.AddNodeAdd:
+		fmt.Printf("Keeping connected to %s\n", node)
+	case p2pprotocol.AddNodeRemove:
+		fmt.Printf("No longer keeping connected to %s\n", node)
+	default:
+		fmt.Printf("Dialing %s\n", node)
+	}
+}
+
+func (cli *CLI) disconnectNode(target, host string, port int) {
+	callNode(host, port, nil, "admin_disconnectNode", target)
+	fmt.Printf("Disconnected %s\n", target)
+}
+
+func (cli *CLI) getConnectionCount(slots bool, host string, port int) {
+	if !slots {
+		var count int
+		callNode(host, port, &count, "admin_getConnectionCount")
+		fmt.Println(count)
+		return
+	}
+	var used p2pprotocol.ConnectionSlots
+	callNode(host, port, &used, "admin_getConnectionSlots")
+	fmt.Printf("Inbound:     %d/%d\n", used.Inbound, used.MaxInbound)
+	fmt.Printf("Outbound:    %d/%d\n", used.Outbound, used.MaxOutbound)
+	fmt.Printf("Whitelisted: %d\n", used.Whitelisted)
+}
+
+func (cli *CLI) whitelist(target string, remove bool, host string, port int) {
+	if remove {
+		callNode(host, port, nil, "admin_removeWhitelist", target)
+		fmt.Printf("Removed %s from the whitelist\n", target)
+		return
+	}
+	callNode(host, port, nil, "admin_addWhitelist", target)
+	fmt.Printf("Whitelisted %s\n", target)
+}
+
+func (cli *CLI) listWhitelist(asJSON bool, host string, port int) {
+	var entries []core.WhitelistedPeer
+	callNode(host, port, &entries, "admin_listWhitelist")
+
+	if asJSON {
+		if entries == nil {
+			entries = []core.WhitelistedPeer{}
+		}
+		printJSON(entries)
+		return
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s whitelisted since %s\n", entry.Address, entry.Added.Format(time.RFC3339))
+	}
+}
@@ -2,18 +2,15 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"../blockchain_go"
 )
 
 func (cli *CLI) printChain(nodeID string) {
-	bc := core.NewBlockchain(nodeID)
-	defer bc.Db.Close()
-
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
 
+	err := bc.ForEachBlock(func(block *core.Block) error {
 		fmt.Printf("============ Block %x ============\n", block.Hash)
 		fmt.Printf("Height: %d\n", block.Height)
 		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
@@ -23,9 +20,10 @@ func (cli *CLI) printChain(nodeID string) {
 			fmt.Println(tx)
 		}
 		fmt.Printf("\n\n")
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
 	}
 }
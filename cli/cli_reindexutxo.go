@@ -1,16 +1,51 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 	"../blockchain_go"
 )
 
 func (cli *CLI) reindexUTXO(nodeID string) {
-	bc := core.NewBlockchain(nodeID)
-	defer bc.Db.Close()
-	UTXOSet := core.UTXOSet{bc}
-	UTXOSet.Reindex()
+	bc := openBlockchain(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
 
-	count := UTXOSet.CountTransactions()
+	// Ctrl-C cancels the reindex and keeps the current UTXO set
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			cancel()
+		}
+	}()
+
+	percent := -1
+	err := UTXOSet.ReindexContext(ctx, func(blocks, total, outputs int) {
+		if p := blocks * 100 / total; p != percent {
+			percent = p
+			fmt.Printf("\rreindexed %d%% (%d/%d blocks, %d outputs)", p, blocks, total, outputs)
+		}
+	})
+	fmt.Println()
+	if err == context.Canceled {
+		fmt.Println("Reindex cancelled, the UTXO set is unchanged.")
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) removeAddress(address, nodeID string, force bool) {
+	wallets, err := core.NewWallets(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer wallets.Close()
+
+	var UTXOSet *core.UTXOSet
+	if !force {
+		bc := openBlockchainReadOnly(nodeID)
+		defer bc.Close()
+		UTXOSet = &core.UTXOSet{Blockchain: bc}
+	}
+
+	err = wallets.DeleteWallet(address, force, UTXOSet)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		if !force {
+			fmt.Println("Use -force to remove it anyway.")
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("Removed address %s\n", address)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"../blockchain_go"
+)
+
+func (cli *CLI) rescan(address, nodeID string) {
+	wallets, err := core.NewWallets(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer wallets.Close()
+
+	var addresses []string
+	if address != "" {
+		addresses = []string{address}
+	}
+
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+
+	// Ctrl-C stops the scan, running the command again resumes it
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			close(stop)
+		}
+	}()
+
+	found, err := wallets.Rescan(bc, addresses, func(scanned, total int) {
+		if scanned%1000 == 0 || scanned == total {
+			fmt.Printf("\rrescanned %d/%d blocks", scanned, total)
+		}
+	}, stop)
+	fmt.Println()
+	if err == core.ErrRescanInterrupted {
+		fmt.Printf("Rescan interrupted, %d transactions found so far. Run rescan again to resume.\n", found)
+		return
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Done! Found %d new transactions.\n", found)
+}
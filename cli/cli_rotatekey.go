@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+	"../blockchain_go"
+	"../p2pprotocol"
+)
+
+func (cli *CLI) rotateKey(address string, feeRate int64, deleteAfter int, nodeID string, mineNow bool) {
+	wallets, err := core.NewWallets(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer wallets.Close()
+
+	bc := openBlockchain(nodeID)
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	for _, pruned := range wallets.PruneRetired(&UTXOSet) {
+		fmt.Printf("Deleted retired key %s\n", pruned)
+	}
+	if address == "" {
+		bc.Close()
+		return
+	}
+
+	newAddress, tx, err := wallets.RotateTx(address, feeRate, &UTXOSet)
+	if err != nil {
+		bc.Close()
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if deleteAfter > 0 {
+		err = wallets.DeleteRetiredAfter(address, deleteAfter)
+		if err != nil {
+			bc.Close()
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if mineNow {
+		txs, err := UTXOSet.BlockTransactions([]*core.Transaction{tx}, newAddress)
+		if err == nil {
+			_, err = bc.MineBlock(txs)
+		}
+		if err != nil {
+			bc.Close()
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		bc.Close()
+	} else {
+		bc.Close()
+		wallet, _ := wallets.GetWallet(address)
+		var server *p2pprotocol.Server
+		if p2pprotocol.CurrentNodeInfo == nil {
+			server, err = p2pprotocol.StartServer(context.Background(), nodeID, "")
+			if err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		time.Sleep(2 * time.Second)
+		if err := p2pprotocol.Manager.TxMempool.Add(tx); err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		core.PendingIn(*wallet, tx)
+		p2pprotocol.Manager.TrackWalletTx(tx)
+		for _, p := range p2pprotocol.Manager.Peers.Peers {
+			p2pprotocol.SendTx(p, p.Rw, tx)
+		}
+		// the peers ask for the transaction announced, the node answers
+		// until stopped
+		time.Sleep(2 * time.Second)
+		if server != nil {
+			server.Stop()
+		}
+	}
+
+	fmt.Printf("Moved the funds of %s to %s in transaction %x\n", address, newAddress, tx.ID)
+	if deleteAfter > 0 {
+		fmt.Printf("%s will be deleted by rotatekey once the move has %d confirmations\n", address, deleteAfter)
+	}
+}
@@ -1,77 +1,122 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"../blockchain_go"
 	"../p2pprotocol"
 	"time"
-	"encoding/hex"
+	"os"
 )
 
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
-	core.MineNow_ = mineNow
-	if !core.ValidateAddress(from) {
-		log.Panic("ERROR: Sender address is not valid")
+func (cli *CLI) send(from, to string, amount, minConf int, feeRate int64, nodeID string, mineNow bool) {
+	wallets, err := core.NewWalletsReadOnly(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if from == "" {
+		from, err = wallets.GetDefault()
+		if err != nil {
+			fmt.Printf("ERROR: -from is not set and %s\n", err)
+			os.Exit(1)
+		}
 	}
-	if !core.ValidateAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
+	// a label of one of our own addresses can stand in for the recipient
+	if address, err := wallets.GetByLabel(to); err == nil {
+		to = address
 	}
-	if from == to {
+
+	if err := core.CheckAddress(from); err != nil {
+		log.Panicf("ERROR: Sender address is not valid: %s", err)
+	}
+	if err := core.CheckAddress(to); err != nil {
+		log.Panicf("ERROR: Recipient address is not valid: %s", err)
+	}
+	if core.CanonicalAddress(from) == core.CanonicalAddress(to) {
 		log.Panic("ERROR: Wallet from equal Wallet to is not valid")
 	}
 	log.Println("--start cli send ")
 
 	var bc *core.Blockchain
-	bc = core.NewBlockchain(nodeID)
+	bc = openBlockchain(nodeID)
 
-	UTXOSet := core.UTXOSet{bc}
-	//defer bc.Db.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	//defer bc.Close()
 
-	wallets, err := core.NewWallets(nodeID)
+	wallet, err := wallets.GetWallet(from)
 	if err != nil {
-		log.Panic(err)
+		bc.Close()
+		fmt.Printf("ERROR: %s: %s\n", from, err)
+		os.Exit(1)
 	}
-	wallet := wallets.GetWallet(from)
 
-	tx := core.NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	var tx *core.Transaction
+	exclude, err := pendingOutpoints(&UTXOSet, wallet)
+	if err == nil {
+		if feeRate < 0 {
+			tx, err = core.NewUTXOTransaction(wallet, to, amount, &UTXOSet, exclude, minConf)
+		} else {
+			tx, err = core.NewUTXOTransactionFee(wallet, to, amount, &UTXOSet, exclude, minConf, feeRate)
+		}
+	}
+	if err != nil {
+		bc.Close()
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
 
 
 	if mineNow {
-		cbTx := core.NewCoinbaseTX(from, "")
-		txs := []*core.Transaction{cbTx, tx}
-
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
-		bc.Db.Close()
+		txs, err := UTXOSet.BlockTransactions([]*core.Transaction{tx}, from)
+		if err == nil {
+			// the block is applied to the UTXO set as it is stored
+			_, err = bc.MineBlock(txs)
+		}
+		if err != nil {
+			bc.Close()
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		bc.Close()
 	} else {
-		bc.Db.Close()
-		//TODO remove comfirmed transaction from persistent tx queue
-		//In case of double spend check fail need to store prev uncomfirmed transaction input tx
-		core.PendingIn(wallet,tx)
-		go func(){
-			if(p2pprotocol.CurrentNodeInfo == nil){
-				p2pprotocol.StartServer(nodeID,"")
+		bc.Close()
+		if p2pprotocol.CurrentNodeInfo == nil {
+			if _, err := p2pprotocol.StartServer(context.Background(), nodeID, ""); err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+				os.Exit(1)
 			}
-		}()
+		}
 		time.Sleep(2*time.Second)
 		select{
 			case ch := <- p2pprotocol.Manager.BestTd:
 
-				bc1 := core.NewBlockchain(nodeID)
-				td,_ := bc1.GetBestHeight()
-				bc1.Db.Close()
+				bc1 := openBlockchain(nodeID)
+				td,_,err := bc1.GetBestHeight()
+				bc1.Close()
+				if err != nil {
+					fmt.Printf("ERROR: %s\n", err)
+					os.Exit(1)
+				}
 				if(td.Cmp(ch) == 0){
 					log.Println("---td:",ch)
 					break
 				}
 		}
+		// the wallet records the transaction once the mempool took it, a
+		// double spend of one it holds is neither recorded nor relayed
+		if err := p2pprotocol.Manager.TxMempool.Add(tx); err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		//TODO remove comfirmed transaction from persistent tx queue
+		core.PendingIn(*wallet,tx)
+		p2pprotocol.Manager.TrackWalletTx(tx)
 		//p2pprotocol.SendTx(core.BootNodes[0], tx)
 		//go func(){
 			for _, p := range p2pprotocol.Manager.Peers.Peers {
 				p2pprotocol.SendTx(p, p.Rw, tx)
 			}
-			p2pprotocol.Manager.TxMempool[hex.EncodeToString(tx.ID)] = tx
 		//}()
 		//select{}
 		for{
@@ -102,18 +147,51 @@ func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
 				log.Panic("need amount  param")
 			}
 
-			bc = core.NewBlockchain(nodeID)
-			UTXOSet := core.UTXOSet{bc}
+			bc = openBlockchain(nodeID)
+			UTXOSet := core.UTXOSet{Blockchain: bc}
 			log.Println("--send to",toaddress)
-			tx := core.NewUTXOTransaction(&wallet, toaddress, amountnum, &UTXOSet)
+			exclude, err := pendingOutpoints(&UTXOSet, wallet)
+			if err != nil {
+				bc.Close()
+				fmt.Printf("ERROR: %s\n", err)
+				continue
+			}
+			tx, err := core.NewUTXOTransaction(wallet, toaddress, amountnum, &UTXOSet, exclude, minConf)
+			if err != nil {
+				bc.Close()
+				fmt.Printf("ERROR: %s\n", err)
+				continue
+			}
+			if err := p2pprotocol.Manager.TxMempool.Add(tx); err != nil {
+				bc.Close()
+				fmt.Printf("ERROR: %s\n", err)
+				continue
+			}
+			core.PendingIn(*wallet,tx)
+			p2pprotocol.Manager.TrackWalletTx(tx)
 			for _, p := range p2pprotocol.Manager.Peers.Peers {
 				p2pprotocol.SendTx(p, p.Rw, tx)
 			}
-			p2pprotocol.Manager.TxMempool[hex.EncodeToString(tx.ID)] = tx
-			bc.Db.Close()
+			bc.Close()
 			//cli.send(fromaddress,toaddress,amountnum,nodeID,false)
 		}
 	}
 
 	//fmt.Println("Success!")
 }
+
+// pendingOutpoints returns the outputs of wallet spent by its pending
+// transactions and those the mempool of this node reserves
+func pendingOutpoints(UTXOSet *core.UTXOSet, wallet *core.Wallet) (core.OutpointSet, error) {
+	exclude, err := UTXOSet.PendingOutpoints(core.HashPubKey(wallet.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	if p2pprotocol.Manager != nil {
+		for outpoint := range p2pprotocol.Manager.TxMempool.Reserved() {
+			exclude[outpoint] = struct{}{}
+		}
+	}
+
+	return exclude, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) setDefault(address, nodeID string) {
+	wallets, err := core.NewWallets(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer wallets.Close()
+
+	err = wallets.SetDefault(address)
+	if err != nil {
+		fmt.Printf("ERROR: %s: %s\n", address, err)
+		os.Exit(1)
+	}
+
+	if address == "" {
+		fmt.Println("Default address cleared")
+	} else {
+		fmt.Printf("Default address set to %s\n", address)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) signMessage(address, message, nodeID string) {
+	wallets, err := core.NewWalletsReadOnly(nodeID)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	wallet, err := wallets.GetWallet(address)
+	if err != nil {
+		fmt.Printf("ERROR: %s: %s\n", address, err)
+		os.Exit(1)
+	}
+
+	sig, err := wallet.SignMessage([]byte(message))
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%x\n", sig)
+}
+
+func (cli *CLI) verifyMessage(address, message, signature string) {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		fmt.Printf("ERROR: signature is not hex: %s\n", err)
+		os.Exit(1)
+	}
+
+	if !core.VerifyMessage(address, []byte(message), sig) {
+		fmt.Println("Signature is NOT valid")
+		os.Exit(1)
+	}
+	fmt.Println("Signature is valid")
+}
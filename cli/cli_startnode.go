@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"../blockchain_go"
 	"../p2pprotocol"
 )
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
+func (cli *CLI) startNode(nodeID, minerAddress string, undoDepth int) {
 	fmt.Printf("Starting node %s\n", nodeID)
+	core.DefaultUndoDepth = undoDepth
 	if len(minerAddress) > 0 {
 		if core.ValidateAddress(minerAddress) {
 			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
@@ -17,5 +23,18 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 		}
 	}
 
-	p2pprotocol.StartServer(nodeID, minerAddress)
+	// the node shuts down on SIGINT or SIGTERM, see Server.Stop
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	server, err := p2pprotocol.StartServer(ctx, nodeID, minerAddress)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	<-ctx.Done()
+	log.Println("Got interrupt, shutting down...")
+	if err := server.Stop(); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
 }
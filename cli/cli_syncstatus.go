@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"../p2pprotocol"
+)
+
+func (cli *CLI) syncStatus(asJSON bool, host string, port int) {
+	var status p2pprotocol.SyncStatus
+	callNode(host, port, &status, "swc_syncing")
+
+	if asJSON {
+		printJSON(status)
+		return
+	}
+	if !status.Syncing {
+		fmt.Printf("Synced at height %d\n", status.CurrentHeight)
+		return
+	}
+	fmt.Printf("Syncing:        yes\n")
+	fmt.Printf("Started at:     %d\n", status.StartingHeight)
+	fmt.Printf("Height:         %d\n", status.CurrentHeight)
+	fmt.Printf("Headers:        %d\n", status.HeadersHeight)
+	fmt.Printf("Highest known:  %d\n", status.HighestKnownHeight)
+	fmt.Printf("Peers used:     %d\n", status.PeersUsed)
+	if status.EstimatedTimeRemaining > 0 {
+		fmt.Printf("Time remaining: %s\n", time.Duration(status.EstimatedTimeRemaining)*time.Second)
+	} else {
+		fmt.Printf("Time remaining: unknown\n")
+	}
+}
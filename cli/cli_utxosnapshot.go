@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) dumpUTXO(path, nodeID string) {
+	bc := openBlockchainReadOnly(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	// write next to path and rename, so a failed dump leaves no partial file
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	err = UTXOSet.Snapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	height, tip, err := bc.GetBestHeight()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote the UTXO set at block %s height %s to %s\n", tip, height, path)
+}
+
+func (cli *CLI) loadUTXO(path, tip, nodeID string) {
+	var expectedTip []byte
+	if tip != "" {
+		var err error
+		expectedTip, err = hex.DecodeString(tip)
+		if err != nil {
+			fmt.Printf("ERROR: -tip: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	bc := openBlockchain(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	err = UTXOSet.LoadSnapshot(f, expectedTip)
+	if err != nil {
+		fmt.Printf("ERROR: %s, the UTXO set is unchanged\n", err)
+		os.Exit(1)
+	}
+
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+}
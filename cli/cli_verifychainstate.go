@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"../blockchain_go"
+)
+
+func (cli *CLI) verifyChainstate(sampleRate float64, repair bool, threshold int, nodeID string) {
+	bc := openBlockchain(nodeID)
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+
+	result, err := UTXOSet.Verify(sampleRate, func(m core.UTXOMismatch) {
+		switch m.Kind {
+		case core.UTXOMissing:
+			fmt.Printf("missing %x:%d, %d to %x\n", m.TxID, m.Vout, m.Expected.Value, m.Expected.PubKeyHash)
+		case core.UTXOExtra:
+			fmt.Printf("extra   %x:%d, %d to %x\n", m.TxID, m.Vout, m.Actual.Value, m.Actual.PubKeyHash)
+		default:
+			fmt.Printf("value   %x:%d, %d to %x instead of %d to %x\n", m.TxID, m.Vout, m.Actual.Value, m.Actual.PubKeyHash, m.Expected.Value, m.Expected.PubKeyHash)
+		}
+	})
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Checked %d transactions with %d unspent outputs: %d missing, %d extra, %d mismatched.\n",
+		result.Transactions, result.Outputs, result.Missing, result.Extra, result.Mismatched)
+	if result.Mismatches() == 0 {
+		return
+	}
+	if !repair || result.Mismatches() <= threshold {
+		os.Exit(1)
+	}
+
+	fmt.Println("Rebuilding the UTXO set...")
+	if err := UTXOSet.ReindexContext(context.Background(), nil); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+}
@@ -34,6 +34,10 @@ const (
 	// redialing a certain node.
 	dialHistoryExpiration = 30 * time.Second
 
+	// Static nodes failing to connect are redialed less and less often,
+	// the wait doubling from dialHistoryExpiration up to this.
+	maxStaticDialBackoff = 10 * time.Minute
+
 	// Discovery lookups are throttled and can only run
 	// once every few seconds.
 	lookupInterval = 4 * time.Second
@@ -78,6 +82,7 @@ type dialstate struct {
 	lookupBuf     []*discover.Node // current discovery lookup results
 	randomNodes   []*discover.Node // filled from Table
 	static        map[discover.NodeID]*dialTask
+	staticFails   map[discover.NodeID]int // dials of static nodes since they were last connected
 	hist          *dialHistory
 
 	start     time.Time        // time when the dialer was first used
@@ -133,6 +138,7 @@ func newDialState(static []*discover.Node, BootNodes []*discover.Node, ntab disc
 		ntab:        ntab,
 		netrestrict: netrestrict,
 		static:      make(map[discover.NodeID]*dialTask),
+		staticFails: make(map[discover.NodeID]int),
 		dialing:     make(map[discover.NodeID]connFlag),
 		BootNodes:   make([]*discover.Node, len(BootNodes)),
 		randomNodes: make([]*discover.Node, maxdyn/2),
@@ -154,6 +160,7 @@ func (s *dialstate) addStatic(n *discover.Node) {
 func (s *dialstate) removeStatic(n *discover.Node) {
 	// This removes a task so future attempts to connect will not be made.
 	delete(s.static, n.ID)
+	delete(s.staticFails, n.ID)
 	// This removes a previous dial timestamp so that application
 	// can force a server to reconnect with chosen peer immediately.
 	s.hist.remove(n.ID)
@@ -198,6 +205,10 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 		case errNotWhitelisted, errSelf:
 			log.Warn("Removing static dial candidate", "id", t.dest.ID, "addr", &net.TCPAddr{IP: t.dest.IP, Port: int(t.dest.TCP)}, "err", err)
 			delete(s.static, t.dest.ID)
+			delete(s.staticFails, t.dest.ID)
+		case errAlreadyConnected:
+			// The backoff starts over once it connects.
+			delete(s.staticFails, id)
 		case nil:
 			s.dialing[id] = t.flags
 			newtasks = append(newtasks, t)
@@ -280,7 +291,12 @@ func (s *dialstate) checkDial(n *discover.Node, peers map[discover.NodeID]*Peer)
 func (s *dialstate) taskDone(t task, now time.Time) {
 	switch t := t.(type) {
 	case *dialTask:
-		s.hist.add(t.dest.ID, now.Add(dialHistoryExpiration))
+		exp := dialHistoryExpiration
+		if t.flags&staticDialedConn != 0 {
+			exp = staticDialBackoff(s.staticFails[t.dest.ID])
+			s.staticFails[t.dest.ID]++
+		}
+		s.hist.add(t.dest.ID, now.Add(exp))
 		delete(s.dialing, t.dest.ID)
 	case *discoverTask:
 		s.lookupRunning = false
@@ -288,6 +304,19 @@ func (s *dialstate) taskDone(t task, now time.Time) {
 	}
 }
 
+// staticDialBackoff returns how long a static node isn't redialed after
+// fails dials since it was last connected.
+func staticDialBackoff(fails int) time.Duration {
+	backoff := dialHistoryExpiration
+	for i := 0; i < fails && backoff < maxStaticDialBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxStaticDialBackoff {
+		backoff = maxStaticDialBackoff
+	}
+	return backoff
+}
+
 func (t *dialTask) Do(srv *Server) {
 	if t.dest.Incomplete() {
 		if !t.resolve(srv) {
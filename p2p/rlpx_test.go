@@ -629,3 +629,120 @@ func tcpPipe() (net.Conn, net.Conn, error) {
 	}
 	return aconn, dconn, nil
 }
+
+// tapConn records what is written to a connection, what an eavesdropper
+// on the path sees
+type tapConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *tapConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *tapConn) seen(b []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Contains(c.written.Bytes(), b)
+}
+
+func TestEncryptedTransport(t *testing.T) {
+	var (
+		prv0, _ = crypto.GenerateKey()
+		prv1, _ = crypto.GenerateKey()
+		node1   = &discover.Node{ID: discover.PubkeyID(&prv1.PublicKey), IP: net.IP{5, 6, 7, 8}, TCP: 44}
+		secret  = []byte("a transaction not confirmed yet")
+		wg      sync.WaitGroup
+	)
+	fd0, fd1, err := tcpPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tap := &tapConn{Conn: fd0}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer fd0.Close()
+		rlpx := newRLPX(tap)
+		if _, err := rlpx.doEncHandshake(prv0, node1); err != nil {
+			t.Errorf("dial side enc handshake failed: %v", err)
+			return
+		}
+		size, r, _ := rlp.EncodeToReader(secret)
+		if err := rlpx.WriteMsg(Msg{Code: 16, Size: uint32(size), Payload: r}); err != nil {
+			t.Errorf("dial side write failed: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer fd1.Close()
+		rlpx := newRLPX(fd1)
+		remid, err := rlpx.doEncHandshake(prv1, nil)
+		if err != nil {
+			t.Errorf("listen side enc handshake failed: %v", err)
+			return
+		}
+		// the key of the dialer is authenticated by the handshake
+		if remid != discover.PubkeyID(&prv0.PublicKey) {
+			t.Errorf("listen side remote id mismatch: got %v", remid)
+		}
+		if err := ExpectMsg(rlpx, 16, secret); err != nil {
+			t.Errorf("listen side read failed: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if tap.seen(secret) {
+		t.Error("the message was sent in plaintext")
+	}
+}
+
+func TestPlaintextPeerRefused(t *testing.T) {
+	var (
+		prv0, _ = crypto.GenerateKey()
+		node0   = &discover.Node{ID: discover.PubkeyID(&prv0.PublicKey), IP: net.IP{1, 2, 3, 4}, TCP: 33}
+		// what a peer speaking plaintext sends first, a version command
+		plaintext = append([]byte("version\x00\x00\x00\x00\x00"), bytes.Repeat([]byte("plaintext"), 50)...)
+	)
+
+	// a plaintext peer dialing an encrypted node
+	fd0, fd1, err := tcpPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer fd1.Close()
+		fd1.Write(plaintext)
+		io.Copy(ioutil.Discard, fd1)
+	}()
+	// refused at the latest when the handshake times out, shortened here
+	rlpx := newRLPX(fd0)
+	fd0.SetDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := rlpx.doEncHandshake(prv0, nil); err == nil {
+		t.Error("listen side accepted a plaintext peer")
+	}
+	fd0.Close()
+
+	// an encrypted node dialing a plaintext peer
+	fd0, fd1, err = tcpPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prv1, _ := crypto.GenerateKey()
+	go func() {
+		defer fd1.Close()
+		// the auth message of the dialer is read as a command would be
+		fd1.Read(make([]byte, 1024))
+		fd1.Write(plaintext)
+	}()
+	if _, err := newRLPX(fd0).doEncHandshake(prv1, node0); err == nil {
+		t.Error("dial side accepted a plaintext peer")
+	}
+	fd0.Close()
+}
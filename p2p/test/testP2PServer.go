@@ -125,7 +125,10 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet("1NWUWL17WtxzSMVWhGm8UD7Y45ikFUHZCx")
+	wallet, err := wallets.GetWallet("1NWUWL17WtxzSMVWhGm8UD7Y45ikFUHZCx")
+	if err != nil {
+		log.Panic(err)
+	}
 	nodekey := &wallet.PrivateKey
 
 	manager = &ProtocolManager{
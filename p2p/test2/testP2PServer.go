@@ -55,7 +55,10 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet("1NWUWL17WtxzSMVWhGm8UD7Y45ikFUHZCx")
+	wallet, err := wallets.GetWallet("1NWUWL17WtxzSMVWhGm8UD7Y45ikFUHZCx")
+	if err != nil {
+		log.Panic(err)
+	}
 
 	peers := []*discover.Node{&discover.Node{IP: net.ParseIP("192.168.1.196"),TCP:30301,UDP:30301,ID: discover.PubkeyID(&wallet.PrivateKey.PublicKey)}}
 
@@ -63,7 +66,10 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet1 := wallets1.GetWallet("1EgyiGniMHR1jvu5T4xSP5J3QWLjNskc1D")
+	wallet1, err := wallets1.GetWallet("1EgyiGniMHR1jvu5T4xSP5J3QWLjNskc1D")
+	if err != nil {
+		log.Panic(err)
+	}
 	nodekey := &wallet1.PrivateKey
 
 	fmt.Println("nodekey:", nodekey)
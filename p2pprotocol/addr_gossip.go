@@ -0,0 +1,331 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"../p2p/discover"
+)
+
+// MaxOutbound is the number of peers the node connects to, picked from the
+// addresses it knows
+var MaxOutbound = 8
+
+// AddrGossipInterval is how often the node advertises its address, with a
+// few good ones, to its peers
+var AddrGossipInterval = 10 * time.Minute
+
+const (
+	connectInterval  = 30 * time.Second // how often outbound peers are dialed, a dial not connected by the next is given up
+	addrSaveInterval = 15 * time.Minute // how often the address manager is saved
+	gossipAddrs      = 3                // good addresses advertised with the address of the node
+	maxRelayAddrs    = 10               // an addr message of at most as many fresh addresses is relayed
+	relayPeers       = 2                // peers an addr message is relayed to
+	freshAddr        = 10 * time.Minute // an address seen since is relayed
+	maxKnownAddrs    = 5000             // addresses to keep in the known list of a peer
+)
+
+// peerDialer connects to the peers the address manager picks and keeps the
+// whitelisted ones a slot, the p2p server
+type peerDialer interface {
+	AddPeer(node *discover.Node)
+	RemovePeer(node *discover.Node)
+	AddTrustedPeer(node *discover.Node)
+	RemoveTrustedPeer(node *discover.Node)
+}
+
+// pendingDial is a node dialed, not connected yet
+type pendingDial struct {
+	node *discover.Node
+	at   time.Time
+}
+
+// sendAddr sends addresses to the peer, marked as known to it, none to a
+// peer older than invVersion
+func sendAddr(p *Peer, addrs []netAddress) error {
+	if !p.speaks("addr") {
+		return nil
+	}
+	for _, na := range addrs {
+		p.knownAddrs.Add([]byte(na.Addr))
+	}
+
+	return sendDataC(p.Rw, Command{Command: "addr", Data: gobEncode(addr{Addresses: addrs})})
+}
+
+// requestAddrs asks the peer for the addresses it knows, once per
+// connection, unless it's older than invVersion
+func requestAddrs(p *Peer) {
+	if !p.speaks("getaddr") {
+		return
+	}
+	p.lock.Lock()
+	asked := p.addrAsked
+	p.addrAsked = true
+	p.lock.Unlock()
+	if asked {
+		return
+	}
+
+	sendDataC(p.Rw, Command{Command: "getaddr"})
+}
+
+// addressOf returns the address the peer listens on, from its version. The
+// IP address of the connection replaces a host that isn't one
+func addressOf(p *Peer, version verzion) netAddress {
+	address := version.AddrFrom
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+			if remote := peerAddress(p); net.ParseIP(remote) != nil {
+				address = net.JoinHostPort(remote, port)
+			}
+		}
+	}
+
+	return netAddress{ID: p.ID(), Addr: address, LastSeen: time.Now().Unix()}
+}
+
+// exchangeAddresses follows the handshake with the peer: the node
+// advertises its address, and the address of an outbound peer, known to
+// accept connections, is marked good and the peer asked for those it
+// knows. An inbound peer advertises its address itself
+func (pm *ProtocolManager) exchangeAddresses(p *Peer, version verzion) {
+	if pm.Addrs == nil {
+		return
+	}
+	if !p.Inbound() {
+		na := addressOf(p, version)
+		pm.Addrs.Add(na, peerAddress(p))
+		pm.Addrs.Good(na)
+		p.knownAddrs.Add([]byte(na.Addr))
+	}
+	pm.advertise(p)
+	if !p.Inbound() {
+		requestAddrs(p)
+	}
+}
+
+// advertise sends the peer the address of the node, and up to gossipAddrs
+// good addresses it doesn't know
+func (pm *ProtocolManager) advertise(p *Peer) {
+	local := pm.Addrs.Local()
+	local.LastSeen = time.Now().Unix()
+	addrs := []netAddress{local}
+	for _, na := range pm.Addrs.Sample(maxAddrs, true) {
+		if len(addrs) > gossipAddrs {
+			break
+		}
+		if na.ID != p.ID() && !p.knownAddrs.Has([]byte(na.Addr)) {
+			addrs = append(addrs, na)
+		}
+	}
+	sendAddr(p, addrs)
+}
+
+// handleGetAddr answers the peer with a random sample of the addresses the
+// node knows, once per connection
+func handleGetAddr(p *Peer) {
+	if Manager.Addrs == nil {
+		return
+	}
+	p.lock.Lock()
+	served := p.addrServed
+	p.addrServed = true
+	p.lock.Unlock()
+	if served {
+		log.Printf("peer %s asked for addresses again, ignored", p.id)
+		return
+	}
+
+	var addrs []netAddress
+	for _, na := range Manager.Addrs.Sample(maxAddrs, false) {
+		if na.ID != p.ID() {
+			addrs = append(addrs, na)
+		}
+	}
+	if len(addrs) > 0 {
+		sendAddr(p, addrs)
+	}
+}
+
+// handleAddr adds the addresses the peer sent to the address manager. The
+// new ones of a small message, seen lately, are relayed to a few peers
+func handleAddr(p *Peer, command Command) {
+	var payload addr
+	if err := gobDecode(command.Data, &payload); err != nil {
+		malformed(p, command, err)
+		return
+	}
+	if len(payload.Addresses) > maxAddrs {
+		p.Misbehaving(scoreAddrFlood, fmt.Sprintf("addr message of %d addresses", len(payload.Addresses)))
+		return
+	}
+	if Manager.Addrs == nil {
+		return
+	}
+
+	source := peerAddress(p)
+	now := time.Now()
+	var fresh []netAddress
+	for _, na := range payload.Addresses {
+		p.knownAddrs.Add([]byte(na.Addr))
+		if Manager.Addrs.Add(na, source) && now.Sub(time.Unix(na.LastSeen, 0)) < freshAddr {
+			fresh = append(fresh, na)
+		}
+	}
+	fmt.Printf("Received %d addresses, %d new\n", len(payload.Addresses), len(fresh))
+	if len(payload.Addresses) <= maxRelayAddrs && len(fresh) > 0 {
+		Manager.relayAddrs(p, fresh)
+	}
+}
+
+// relayAddrs sends addrs to up to relayPeers peers picked at random, other
+// than from, those they don't know
+func (pm *ProtocolManager) relayAddrs(from *Peer, addrs []netAddress) {
+	peers := pm.Peers.List()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	relayed := 0
+	for _, p := range peers {
+		if relayed == relayPeers {
+			break
+		}
+		if p == from {
+			continue
+		}
+		var unknown []netAddress
+		for _, na := range addrs {
+			if !p.knownAddrs.Has([]byte(na.Addr)) {
+				unknown = append(unknown, na)
+			}
+		}
+		if len(unknown) > 0 {
+			sendAddr(p, unknown)
+		}
+		relayed++
+	}
+}
+
+// connectOutbound dials the addresses the address manager picks while the
+// node has fewer than MaxOutbound outbound peers and dials. A node without
+// any dials first the addresses it connected to most recently, then those
+// of /16s apart from its peers when it can, and the seeds when there's no
+// address left to try. A dial not connected within connectInterval is
+// given up, the p2p server would retry it forever. Under WhitelistOnly only
+// the whitelisted nodes are dialed
+func (pm *ProtocolManager) connectOutbound() {
+	if pm.Addrs == nil || pm.dialer == nil || WhitelistOnly {
+		return
+	}
+	pm.dialLock.Lock()
+	defer pm.dialLock.Unlock()
+	connected := make(map[discover.NodeID]bool)
+	// the dialer keeps connecting to the nodes added by hand and to those
+	// whitelisted
+	for id := range pm.added {
+		connected[id] = true
+	}
+	_, whitelisted := whitelist.trusted()
+	for _, node := range whitelisted {
+		connected[node.ID] = true
+	}
+	groups := make(map[string]bool)
+	outbound := 0
+	for _, p := range pm.Peers.List() {
+		connected[p.ID()] = true
+		groups[group(peerAddress(p))] = true
+		if !p.Inbound() {
+			outbound++
+		}
+	}
+	now := time.Now()
+	for id, dial := range pm.dials {
+		switch {
+		case connected[id]:
+			delete(pm.dials, id)
+		case now.Sub(dial.at) >= connectInterval:
+			pm.dialer.RemovePeer(dial.node)
+			delete(pm.dials, id)
+		default:
+			connected[id] = true
+			groups[group(dial.node.IP.String())] = true
+			outbound++
+		}
+	}
+
+	dialNode := func(node *discover.Node) {
+		if pm.dials == nil {
+			pm.dials = make(map[discover.NodeID]pendingDial)
+		}
+		pm.dials[node.ID] = pendingDial{node, now}
+		connected[node.ID] = true
+		groups[group(node.IP.String())] = true
+		outbound++
+		pm.dialer.AddPeer(node)
+	}
+	var addrs []netAddress
+	if outbound == 0 {
+		addrs = pm.Addrs.Recent(MaxOutbound, connected)
+	}
+	for outbound < MaxOutbound {
+		var na netAddress
+		if len(addrs) > 0 {
+			na, addrs = addrs[0], addrs[1:]
+		} else if picked, ok := pm.Addrs.Select(connected, groups); ok {
+			na = picked
+		} else {
+			break
+		}
+		pm.Addrs.Attempt(na.Addr)
+		node, err := na.node()
+		if err != nil {
+			log.Printf("dialing %s: %v", na.Addr, err)
+			continue
+		}
+		log.Printf("dialing %s", na.Addr)
+		dialNode(node)
+	}
+	if outbound == 0 {
+		for _, seed := range pm.seeds {
+			if !connected[seed.ID] {
+				log.Printf("dialing the seed %s", seed.IP)
+				dialNode(seed)
+			}
+		}
+	}
+}
+
+// addrLoop connects to outbound peers, advertises the address of the node
+// every AddrGossipInterval and saves the address manager, until quit is
+// closed
+func (pm *ProtocolManager) addrLoop(quit <-chan struct{}) {
+	connect := time.NewTicker(connectInterval)
+	defer connect.Stop()
+	gossip := time.NewTicker(AddrGossipInterval)
+	defer gossip.Stop()
+	save := time.NewTicker(addrSaveInterval)
+	defer save.Stop()
+
+	pm.connectOutbound()
+	for {
+		select {
+		case <-connect.C:
+			pm.connectOutbound()
+		case <-gossip.C:
+			for _, p := range pm.Peers.List() {
+				pm.advertise(p)
+			}
+		case <-save.C:
+			if err := pm.Addrs.save(); err != nil {
+				log.Println("saving the peer addresses:", err)
+			}
+		case <-quit:
+			if err := pm.Addrs.save(); err != nil {
+				log.Println("saving the peer addresses:", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package p2pprotocol
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"../blockchain_go"
+	"../p2p/discover"
+)
+
+// The address manager keeps the addresses gossiped by the peers in
+// newBucketCount buckets, and those the node connected to in
+// triedBucketCount buckets. The bucket of an address is picked by a keyed
+// hash of its /16 and, for a new one, of the /16 of the peer telling about
+// it: the peers of one /16 fill newBucketsPerSource buckets at most however
+// many addresses they send, the addresses of one /16 triedBucketsPerGroup
+const (
+	newBucketCount       = 64
+	triedBucketCount     = 16
+	bucketSize           = 64
+	newBucketsPerSource  = 8
+	triedBucketsPerGroup = 4
+
+	maxAddrs      = 1000                // most addresses in an addr message
+	addrHorizon   = 30 * 24 * time.Hour // an address not seen for that long is dropped
+	maxFailures   = 3                   // failed attempts after which an address is dropped
+	retryInterval = 10 * time.Minute    // before an address failing is attempted again
+)
+
+// netAddress is the address a node listens on, as gossiped in addr
+// messages
+type netAddress struct {
+	ID       discover.NodeID
+	Addr     string // ip:port
+	LastSeen int64  // unix time the node was last heard of
+}
+
+// node returns the node to dial at the address
+func (na netAddress) node() (*discover.Node, error) {
+	host, port, err := net.SplitHostPort(na.Addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("address %s: %v", na.Addr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("address %s isn't an IP address", na.Addr)
+	}
+
+	return discover.NewNode(na.ID, ip, uint16(tcp), uint16(tcp)), nil
+}
+
+// validAddress returns whether addr is an ip:port a node may listen on
+func validAddress(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if tcp, err := strconv.ParseUint(port, 10, 16); err != nil || tcp == 0 {
+		return false
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// group returns the /16 of an IPv4 address, the /32 of an IPv6 one, with
+// or without a port. What isn't an IP address is its own group
+func group(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d", ip4[0], ip4[1])
+	}
+
+	return fmt.Sprintf("%x", []byte(ip[:4]))
+}
+
+// knownAddress is an address in the address manager
+type knownAddress struct {
+	ID          discover.NodeID `json:"id"`
+	Addr        string          `json:"addr"`
+	Source      string          `json:"source"` // the peer telling about it
+	LastSeen    time.Time       `json:"last_seen"`
+	LastAttempt time.Time       `json:"last_attempt"`
+	LastSuccess time.Time       `json:"last_success"`
+	Attempts    int             `json:"attempts"` // failed since the last success
+	Successes   int             `json:"successes"`
+	Tried       bool            `json:"tried"`
+
+	bucket int
+}
+
+func (ka *knownAddress) netAddress() netAddress {
+	return netAddress{ID: ka.ID, Addr: ka.Addr, LastSeen: ka.LastSeen.Unix()}
+}
+
+// retrying returns whether the last attempt to connect to the address
+// failed within retryInterval, or is still under way
+func (ka *knownAddress) retrying(now time.Time) bool {
+	return ka.Attempts > 0 && now.Sub(ka.LastAttempt) < retryInterval
+}
+
+// isTerrible returns whether the address isn't worth keeping: not seen
+// within addrHorizon, or failing maxFailures times in a row without a
+// success within a week. An address just attempted isn't
+func (ka *knownAddress) isTerrible(now time.Time) bool {
+	if now.Sub(ka.LastAttempt) < time.Minute {
+		return false
+	}
+	if now.Sub(ka.LastSeen) > addrHorizon {
+		return true
+	}
+
+	return ka.Attempts >= maxFailures && now.Sub(ka.LastSuccess) > 7*24*time.Hour
+}
+
+// addrManager holds the addresses of the nodes the node knows of, to
+// answer getaddr and to pick the peers it connects to
+type addrManager struct {
+	mu           sync.Mutex
+	path         string   // where it's saved, nowhere if empty
+	key          [32]byte // the secret the buckets are picked with
+	local        netAddress
+	external     string // the address the node is reachable at through its NAT, see mapPort
+	addrs        map[string]*knownAddress
+	newBuckets   [newBucketCount]map[string]*knownAddress
+	triedBuckets [triedBucketCount]map[string]*knownAddress
+}
+
+// addrFile is the content of the file of the address manager
+type addrFile struct {
+	Key       []byte          `json:"key"`
+	Addresses []*knownAddress `json:"addresses"`
+}
+
+// newAddrManager returns an empty address manager of the node listening
+// on local, saved to path
+func newAddrManager(path string, local netAddress) *addrManager {
+	m := &addrManager{path: path, local: local, addrs: make(map[string]*knownAddress)}
+	for i := range m.newBuckets {
+		m.newBuckets[i] = make(map[string]*knownAddress)
+	}
+	for i := range m.triedBuckets {
+		m.triedBuckets[i] = make(map[string]*knownAddress)
+	}
+	if _, err := crand.Read(m.key[:]); err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// loadAddrManager returns the address manager saved to path, an empty one
+// if there's no such file
+func loadAddrManager(path string, local netAddress) (*addrManager, error) {
+	m := newAddrManager(path, local)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file addrFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	if len(file.Key) != len(m.key) {
+		return nil, fmt.Errorf("reading %s: no bucket key", path)
+	}
+	copy(m.key[:], file.Key)
+
+	now := time.Now()
+	for _, ka := range file.Addresses {
+		if !validAddress(ka.Addr) || ka.isTerrible(now) || m.addrs[ka.Addr] != nil {
+			continue
+		}
+		if ka.Tried {
+			m.placeTried(ka)
+		} else {
+			m.placeNew(ka, now)
+		}
+	}
+
+	return m, nil
+}
+
+// save writes the addresses to the file of the manager, those not worth
+// keeping left out
+func (m *addrManager) save() error {
+	m.mu.Lock()
+	if m.path == "" {
+		m.mu.Unlock()
+		return nil
+	}
+	file := addrFile{Key: m.key[:]}
+	now := time.Now()
+	for _, ka := range m.addrs {
+		if !ka.isTerrible(now) {
+			file.Addresses = append(file.Addresses, ka)
+		}
+	}
+	content, err := json.Marshal(file)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return core.WriteFileAtomic(m.path, content, 0600)
+}
+
+func (m *addrManager) hash(parts ...string) uint64 {
+	h := sha256.New()
+	h.Write(m.key[:])
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// newBucket returns the new bucket of addr told about by source
+func (m *addrManager) newBucket(addr, source string) int {
+	sourceGroup := group(source)
+	i := m.hash(group(addr), sourceGroup) % newBucketsPerSource
+
+	return int(m.hash(sourceGroup, strconv.FormatUint(i, 10)) % newBucketCount)
+}
+
+// triedBucket returns the tried bucket of addr
+func (m *addrManager) triedBucket(addr string) int {
+	i := m.hash(addr) % triedBucketsPerGroup
+
+	return int(m.hash(group(addr), strconv.FormatUint(i, 10)) % triedBucketCount)
+}
+
+// placeNew puts ka in its new bucket, the address of the bucket seen
+// least recently evicted if it's full, a terrible one first
+func (m *addrManager) placeNew(ka *knownAddress, now time.Time) {
+	ka.Tried = false
+	ka.bucket = m.newBucket(ka.Addr, ka.Source)
+	bucket := m.newBuckets[ka.bucket]
+	if len(bucket) >= bucketSize {
+		var evict *knownAddress
+		for _, old := range bucket {
+			if old.isTerrible(now) {
+				evict = old
+				break
+			}
+			if evict == nil || old.LastSeen.Before(evict.LastSeen) {
+				evict = old
+			}
+		}
+		delete(bucket, evict.Addr)
+		delete(m.addrs, evict.Addr)
+	}
+	bucket[ka.Addr] = ka
+	m.addrs[ka.Addr] = ka
+}
+
+// placeTried puts ka in its tried bucket. If it's full the address
+// connected to least recently goes back to the new buckets
+func (m *addrManager) placeTried(ka *knownAddress) {
+	ka.Tried = true
+	ka.bucket = m.triedBucket(ka.Addr)
+	bucket := m.triedBuckets[ka.bucket]
+	var evict *knownAddress
+	if len(bucket) >= bucketSize {
+		for _, old := range bucket {
+			if evict == nil || old.LastSuccess.Before(evict.LastSuccess) {
+				evict = old
+			}
+		}
+		delete(bucket, evict.Addr)
+	}
+	bucket[ka.Addr] = ka
+	m.addrs[ka.Addr] = ka
+	if evict != nil {
+		m.placeNew(evict, time.Now())
+	}
+}
+
+// Local returns the address the node advertises, the external one while
+// its port is mapped, its listen address otherwise
+func (m *addrManager) Local() netAddress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	local := m.local
+	if m.external != "" {
+		local.Addr = m.external
+	}
+
+	return local
+}
+
+// SetExternal sets the address the node is reachable at through its NAT,
+// none when empty
+func (m *addrManager) SetExternal(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.external = addr
+}
+
+// Add adds the address of a node told about by source, the IP address of
+// a peer. It returns whether the address is new, a known one only has its
+// last seen time updated. The address of the node itself, invalid ones and
+// those not seen within addrHorizon aren't added
+func (m *addrManager) Add(na netAddress, source string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !validAddress(na.Addr) || na.ID == m.local.ID || na.Addr == m.local.Addr || na.Addr == m.external {
+		return false
+	}
+	now := time.Now()
+	lastSeen := time.Unix(na.LastSeen, 0)
+	if lastSeen.After(now) {
+		lastSeen = now
+	}
+	if now.Sub(lastSeen) > addrHorizon {
+		return false
+	}
+	if ka, ok := m.addrs[na.Addr]; ok {
+		if lastSeen.After(ka.LastSeen) {
+			ka.LastSeen = lastSeen
+		}
+		return false
+	}
+	m.placeNew(&knownAddress{ID: na.ID, Addr: na.Addr, Source: source, LastSeen: lastSeen}, now)
+
+	return true
+}
+
+// Good marks the address of a node the node completed the handshake with,
+// moving it to the tried buckets. The node is known by its ID from then on
+func (m *addrManager) Good(na netAddress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ka, ok := m.addrs[na.Addr]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	ka.ID = na.ID
+	ka.LastSeen = now
+	ka.LastAttempt = now
+	ka.LastSuccess = now
+	ka.Attempts = 0
+	ka.Successes++
+	if ka.Tried {
+		return
+	}
+	delete(m.newBuckets[ka.bucket], ka.Addr)
+	m.placeTried(ka)
+}
+
+// Attempt records a connection attempt to addr, it counts as failed until
+// Good is called
+func (m *addrManager) Attempt(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ka, ok := m.addrs[addr]; ok {
+		ka.LastAttempt = time.Now()
+		ka.Attempts++
+	}
+}
+
+// Sample returns at most max addresses picked at random, the good ones
+// only if tried is set
+func (m *addrManager) Sample(max int, tried bool) []netAddress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var addrs []netAddress
+	for _, ka := range m.addrs {
+		if (tried && !ka.Tried) || ka.isTerrible(now) {
+			continue
+		}
+		addrs = append(addrs, ka.netAddress())
+	}
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+
+	return addrs
+}
+
+// Recent returns at most max of the addresses connected to, by the time of
+// the last connection, the most recent first. The connected nodes and the
+// addresses failing lately are left out
+func (m *addrManager) Recent(max int, connected map[discover.NodeID]bool) []netAddress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var recent []*knownAddress
+	for _, ka := range m.addrs {
+		if ka.Tried && !connected[ka.ID] && !ka.retrying(now) && !ka.isTerrible(now) {
+			recent = append(recent, ka)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i].LastSuccess.After(recent[j].LastSuccess) })
+	if len(recent) > max {
+		recent = recent[:max]
+	}
+	addrs := make([]netAddress, len(recent))
+	for i, ka := range recent {
+		addrs[i] = ka.netAddress()
+	}
+
+	return addrs
+}
+
+// Select picks an address to connect to, not one of the connected nodes
+// nor one failing lately. It prefers an address out of groups, the /16s of
+// the peers, then picks a tried or a new address with even odds
+func (m *addrManager) Select(connected map[discover.NodeID]bool, groups map[string]bool) (netAddress, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var diverse, same [2][]*knownAddress
+	for _, ka := range m.addrs {
+		if connected[ka.ID] || ka.retrying(now) || ka.isTerrible(now) {
+			continue
+		}
+		table := 0
+		if ka.Tried {
+			table = 1
+		}
+		if groups[group(ka.Addr)] {
+			same[table] = append(same[table], ka)
+		} else {
+			diverse[table] = append(diverse[table], ka)
+		}
+	}
+	candidates := diverse
+	if len(diverse[0])+len(diverse[1]) == 0 {
+		candidates = same
+	}
+	table := rand.Intn(2)
+	if len(candidates[table]) == 0 {
+		table = 1 - table
+	}
+	if len(candidates[table]) == 0 {
+		return netAddress{}, false
+	}
+
+	return candidates[table][rand.Intn(len(candidates[table]))].netAddress(), true
+}
+
+// Len returns the number of addresses known, and how many of them were
+// tried
+func (m *addrManager) Len() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tried := 0
+	for _, bucket := range m.triedBuckets {
+		tried += len(bucket)
+	}
+
+	return len(m.addrs), tried
+}
@@ -0,0 +1,212 @@
+package p2pprotocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrManager(t *testing.T) {
+	local := netAddress{ID: discover.NodeID{9}, Addr: "10.9.0.1:2000"}
+	now := time.Now().Unix()
+
+	m := newAddrManager("", local)
+	assert.False(t, m.Add(local, "10.1.0.1"))
+	assert.False(t, m.Add(netAddress{discover.NodeID{1}, "localhost:2000", now}, "10.1.0.1"))
+	assert.False(t, m.Add(netAddress{discover.NodeID{1}, "10.1.0.1:2000", now - int64(addrHorizon/time.Second) - 60}, "10.1.0.1"))
+	assert.True(t, m.Add(netAddress{discover.NodeID{1}, "10.1.0.1:2000", now}, "10.1.0.1"))
+	assert.False(t, m.Add(netAddress{discover.NodeID{1}, "10.1.0.1:2000", now}, "10.5.0.1"))
+	assert.True(t, m.Add(netAddress{discover.NodeID{2}, "10.2.0.1:2000", now}, "10.1.0.1"))
+	m.Good(netAddress{discover.NodeID{2}, "10.2.0.1:2000", now})
+	total, tried := m.Len()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, tried)
+
+	// an address of other /16s than the peers is picked first, not one
+	// connected to or failing lately
+	assert.True(t, m.Add(netAddress{discover.NodeID{3}, "10.3.0.1:2000", now}, "10.1.0.1"))
+	connected := map[discover.NodeID]bool{{2}: true}
+	for i := 0; i < 20; i++ {
+		na, ok := m.Select(connected, map[string]bool{"10.1": true})
+		assert.True(t, ok)
+		assert.Equal(t, "10.3.0.1:2000", na.Addr)
+	}
+	connected[discover.NodeID{3}] = true
+	na, ok := m.Select(connected, map[string]bool{"10.1": true})
+	assert.True(t, ok)
+	assert.Equal(t, "10.1.0.1:2000", na.Addr)
+	m.Attempt(na.Addr)
+	_, ok = m.Select(connected, nil)
+	assert.False(t, ok)
+
+	// a peer sending thousands of addresses fills a few buckets only
+	flooded := newAddrManager("", local)
+	for i := 0; i < 5000; i++ {
+		na := netAddress{discover.NodeID{byte(i), byte(i >> 8)}, fmt.Sprintf("10.%d.%d.1:2000", i%250, i/250), now}
+		flooded.Add(na, "10.66.0.1")
+	}
+	buckets := 0
+	for _, bucket := range flooded.newBuckets {
+		if len(bucket) > 0 {
+			buckets++
+		}
+	}
+	assert.True(t, buckets <= newBucketsPerSource)
+	total, _ = flooded.Len()
+	assert.True(t, total <= newBucketsPerSource*bucketSize)
+	assert.True(t, flooded.Add(netAddress{discover.NodeID{1}, "10.251.0.1:2000", now}, "10.1.0.1"))
+}
+
+func TestAddrManagerFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers.json")
+	local := netAddress{ID: discover.NodeID{9}, Addr: "10.9.0.1:2000"}
+	now := time.Now()
+
+	m := newAddrManager(path, local)
+	good := netAddress{discover.NodeID{1}, "10.1.0.1:2000", now.Unix()}
+	m.Add(good, "10.5.0.1")
+	m.Good(good)
+	m.Good(good)
+	m.Add(netAddress{discover.NodeID{2}, "10.2.0.1:2000", now.Unix()}, "10.5.0.1")
+	m.Attempt("10.2.0.1:2000")
+	assert.Nil(t, m.save())
+
+	// with an address of an older run not seen for over 30 days
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	var file addrFile
+	assert.Nil(t, json.Unmarshal(content, &file))
+	assert.Equal(t, 2, len(file.Addresses))
+	stale := &knownAddress{ID: discover.NodeID{3}, Addr: "10.3.0.1:2000", LastSeen: now.Add(-addrHorizon - time.Hour)}
+	file.Addresses = append(file.Addresses, stale)
+	content, err = json.Marshal(file)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(path, content, 0600))
+
+	loaded, err := loadAddrManager(path, local)
+	assert.Nil(t, err)
+	assert.Equal(t, m.key, loaded.key)
+	total, tried := loaded.Len()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, tried)
+	if ka := loaded.addrs["10.1.0.1:2000"]; assert.NotNil(t, ka) {
+		assert.Equal(t, discover.NodeID{1}, ka.ID)
+		assert.Equal(t, "10.5.0.1", ka.Source)
+		assert.Equal(t, 2, ka.Successes)
+		assert.True(t, ka.LastSuccess.Equal(m.addrs["10.1.0.1:2000"].LastSuccess))
+		assert.Equal(t, m.addrs["10.1.0.1:2000"].bucket, ka.bucket)
+	}
+	if ka := loaded.addrs["10.2.0.1:2000"]; assert.NotNil(t, ka) {
+		assert.Equal(t, 1, ka.Attempts)
+		assert.False(t, ka.Tried)
+		assert.Equal(t, m.addrs["10.2.0.1:2000"].bucket, ka.bucket)
+	}
+	assert.Nil(t, loaded.addrs["10.3.0.1:2000"])
+
+	// no file is no address, a damaged one an error
+	loaded, err = loadAddrManager(filepath.Join(dir, "none.json"), local)
+	assert.Nil(t, err)
+	total, _ = loaded.Len()
+	assert.Equal(t, 0, total)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("peers"), 0600))
+	_, err = loadAddrManager(path, local)
+	assert.NotNil(t, err)
+}
+
+// testDialer records the nodes dialed and removed, trusted and no longer
+type testDialer struct {
+	dialed, removed, trusted, untrusted []*discover.Node
+}
+
+func (d *testDialer) AddPeer(node *discover.Node)           { d.dialed = append(d.dialed, node) }
+func (d *testDialer) RemovePeer(node *discover.Node)        { d.removed = append(d.removed, node) }
+func (d *testDialer) AddTrustedPeer(node *discover.Node)    { d.trusted = append(d.trusted, node) }
+func (d *testDialer) RemoveTrustedPeer(node *discover.Node) { d.untrusted = append(d.untrusted, node) }
+
+func TestAddrGossip(t *testing.T) {
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	quit := make(chan struct{})
+	defer close(quit)
+	newNode := func(id byte, address string) *ProtocolManager {
+		pm := newTestManager(quit)
+		pm.Addrs = newAddrManager("", netAddress{ID: discover.NodeID{id}, Addr: address})
+		return pm
+	}
+	a := newNode(1, "10.1.0.1:2000")
+	b := newNode(2, "10.2.0.1:2000")
+	c := newNode(3, "10.3.0.1:2000")
+	dialer := &testDialer{}
+	a.dialer = dialer
+	seed, err := b.Addrs.local.node()
+	assert.Nil(t, err)
+	a.seeds = []*discover.Node{seed}
+
+	// connect connects two nodes, each exchanging addresses after the
+	// handshake. The peers of the test are outbound on both ends
+	connect := func(from, to *ProtocolManager) (testNode, testNode) {
+		rwFrom, rwTo := p2p.MsgPipe()
+		fromNode := testNode{pm: from, received: readCommands(rwFrom)}
+		fromNode.peer = newPeer(nodeVersion, p2p.NewPeer(to.Addrs.local.ID, "", nil), rwFrom)
+		toNode := testNode{pm: to, received: readCommands(rwTo)}
+		toNode.peer = newPeer(nodeVersion, p2p.NewPeer(from.Addrs.local.ID, "", nil), rwTo)
+		assert.Nil(t, from.Peers.Register(fromNode.peer))
+		assert.Nil(t, to.Peers.Register(toNode.peer))
+		Manager = from
+		from.exchangeAddresses(fromNode.peer, verzion{AddrFrom: to.Addrs.local.Addr})
+		Manager = to
+		to.exchangeAddresses(toNode.peer, verzion{AddrFrom: from.Addrs.local.Addr})
+		return fromNode, toNode
+	}
+
+	// c connects to b, each advertises itself and asks for addresses the
+	// other has none of
+	cb, bc := connect(c, b)
+	defer cb.peer.meter.MsgReadWriter.(*p2p.MsgPipeRW).Close()
+	bc.handle(2, nil)
+	cb.handle(2, nil)
+
+	// a knows no address but its seed b, b gossips c to it
+	Manager = a
+	a.connectOutbound()
+	assert.Equal(t, []*discover.Node{seed}, dialer.dialed)
+	ab, ba := connect(a, b)
+	defer ab.peer.meter.MsgReadWriter.(*p2p.MsgPipeRW).Close()
+	ba.handle(2, nil)
+	ab.handle(3, nil)
+	total, tried := a.Addrs.Len()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, tried)
+
+	// a dials c, the only address it isn't connected to
+	Manager = a
+	a.connectOutbound()
+	if assert.Equal(t, 2, len(dialer.dialed)) {
+		assert.Equal(t, discover.NodeID{3}, dialer.dialed[1].ID)
+		assert.Equal(t, "10.3.0.1", dialer.dialed[1].IP.String())
+		assert.Equal(t, uint16(2000), dialer.dialed[1].TCP)
+	}
+	a.connectOutbound()
+	assert.Equal(t, 2, len(dialer.dialed))
+
+	// and connects, c is a good address from then on
+	ac, _ := connect(a, c)
+	defer ac.peer.meter.MsgReadWriter.(*p2p.MsgPipeRW).Close()
+	_, tried = a.Addrs.Len()
+	assert.Equal(t, 2, tried)
+	a.connectOutbound()
+	assert.Equal(t, 2, len(dialer.dialed))
+	assert.Empty(t, a.dials)
+}
@@ -0,0 +1,161 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+)
+
+// the commands of AddNode
+const (
+	AddNodeAdd    = "add"    // keep connected to the node, reconnecting when it drops
+	AddNodeRemove = "remove" // stop keeping connected to a node added, and disconnect it
+	AddNodeOneTry = "onetry" // dial the node once, given up if not connected within connectInterval
+)
+
+var (
+	errNotRunning       = errors.New("the node isn't running")
+	errNodeAdded        = errors.New("the node is already added")
+	errNodeNotAdded     = errors.New("the node wasn't added")
+	errPeerNotConnected = errors.New("no such peer connected")
+)
+
+// parseNode returns the node of url, enode://NODEID@IP:PORT, the scheme may
+// be omitted
+func parseNode(url string) (*discover.Node, error) {
+	if !strings.HasPrefix(url, "enode://") {
+		url = "enode://" + url
+	}
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %v", url, err)
+	}
+	if node.Incomplete() {
+		return nil, fmt.Errorf("node %s has no address", url)
+	}
+
+	return node, nil
+}
+
+// AddNode keeps connected to the node of url, stops to, or dials it once,
+// by command, see AddNodeAdd, AddNodeRemove and AddNodeOneTry. The nodes
+// added are dialed on top of the outbound peers of connectOutbound, a node
+// added isn't dialed once
+func (pm *ProtocolManager) AddNode(url, command string) error {
+	node, err := parseNode(url)
+	if err != nil {
+		return err
+	}
+	pm.dialLock.Lock()
+	defer pm.dialLock.Unlock()
+	if pm.dialer == nil {
+		return errNotRunning
+	}
+
+	_, added := pm.added[node.ID]
+	switch command {
+	case AddNodeAdd:
+		if added {
+			return errNodeAdded
+		}
+		if pm.added == nil {
+			pm.added = make(map[discover.NodeID]*discover.Node)
+		}
+		pm.added[node.ID] = node
+		// no longer given up by connectOutbound
+		delete(pm.dials, node.ID)
+		pm.dialer.AddPeer(node)
+	case AddNodeRemove:
+		if !added {
+			return errNodeNotAdded
+		}
+		delete(pm.added, node.ID)
+		pm.dialer.RemovePeer(node)
+	case AddNodeOneTry:
+		if added {
+			return nil
+		}
+		if pm.dials == nil {
+			pm.dials = make(map[discover.NodeID]pendingDial)
+		}
+		pm.dials[node.ID] = pendingDial{node, time.Now()}
+		pm.dialer.AddPeer(node)
+	default:
+		return fmt.Errorf("unknown command %q, want %s, %s or %s", command, AddNodeAdd, AddNodeRemove, AddNodeOneTry)
+	}
+
+	return nil
+}
+
+// DisconnectNode disconnects the peers of target: an IP, an IP and port,
+// a node ID or the ID of GetPeerInfo. A node added reconnects, see AddNode
+func (pm *ProtocolManager) DisconnectNode(target string) error {
+	disconnected := 0
+	for _, p := range pm.Peers.List() {
+		info := p.info()
+		if target != info.ID && target != info.NodeID && target != info.Address && target != peerAddress(p) {
+			continue
+		}
+		if p.Peer != nil {
+			p.Peer.Disconnect(p2p.DiscRequested)
+		}
+		disconnected++
+	}
+	if disconnected == 0 {
+		return errPeerNotConnected
+	}
+
+	return nil
+}
+
+// AdminAPI manages the connections of the node, served in the admin
+// namespace of the JSON-RPC API
+type AdminAPI struct{}
+
+// GetPeerInfo describes the peers of the node, see GetPeerInfo
+func (api *AdminAPI) GetPeerInfo() []PeerInfo {
+	infos := GetPeerInfo()
+	if infos == nil {
+		infos = []PeerInfo{}
+	}
+	return infos
+}
+
+// AddNode keeps connected to the node, enode://NODEID@IP:PORT, stops to, or
+// dials it once, by command: add, remove or onetry
+func (api *AdminAPI) AddNode(node, command string) error {
+	if Manager == nil {
+		return errNotRunning
+	}
+	return Manager.AddNode(node, command)
+}
+
+// DisconnectNode disconnects the peer of target, its IP, IP and port or
+// node ID
+func (api *AdminAPI) DisconnectNode(target string) error {
+	if Manager == nil {
+		return errNotRunning
+	}
+	return Manager.DisconnectNode(target)
+}
+
+// GetConnectionCount returns the number of peers of the node
+func (api *AdminAPI) GetConnectionCount() int {
+	if Manager == nil {
+		return 0
+	}
+	return Manager.Peers.Len()
+}
+
+// GetConnectionSlots returns how many inbound and outbound slots the peers
+// use, against MaxInbound and MaxOutbound
+func (api *AdminAPI) GetConnectionSlots() ConnectionSlots {
+	if Manager == nil {
+		return ConnectionSlots{MaxInbound: MaxInbound, MaxOutbound: MaxOutbound}
+	}
+	return Manager.Slots()
+}
@@ -0,0 +1,97 @@
+package p2pprotocol
+
+import (
+	"testing"
+
+	"../p2p"
+	"../p2p/discover"
+	"../rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddNode(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	pm := newTestManager(quit)
+	url := discover.NodeID{3}.String() + "@10.3.0.1:2000"
+	assert.Equal(t, errNotRunning, pm.AddNode(url, AddNodeAdd))
+	dialer := &testDialer{}
+	pm.dialer = dialer
+	pm.Addrs = newAddrManager("", netAddress{ID: discover.NodeID{1}, Addr: "10.1.0.1:2000"})
+
+	for _, malformed := range []string{"", "10.3.0.1:2000", discover.NodeID{3}.String(), "enode://" + discover.NodeID{3}.String() + "@host:2000"} {
+		assert.NotNil(t, pm.AddNode(malformed, AddNodeAdd), malformed)
+	}
+	assert.NotNil(t, pm.AddNode(url, "connect"))
+	assert.Equal(t, errNodeNotAdded, pm.AddNode(url, AddNodeRemove))
+
+	// a node added is dialed and kept, connectOutbound neither dials it
+	// again nor gives it up
+	assert.Nil(t, pm.AddNode("enode://"+url, AddNodeAdd))
+	assert.Equal(t, errNodeAdded, pm.AddNode(url, AddNodeAdd))
+	assert.Nil(t, pm.AddNode(url, AddNodeOneTry))
+	if assert.Equal(t, 1, len(dialer.dialed)) {
+		assert.Equal(t, discover.NodeID{3}, dialer.dialed[0].ID)
+		assert.Equal(t, "10.3.0.1", dialer.dialed[0].IP.String())
+		assert.Equal(t, uint16(2000), dialer.dialed[0].TCP)
+	}
+	pm.connectOutbound()
+	assert.Equal(t, 1, len(dialer.dialed))
+	assert.Empty(t, dialer.removed)
+	assert.Nil(t, pm.AddNode(url, AddNodeRemove))
+	if assert.Equal(t, 1, len(dialer.removed)) {
+		assert.Equal(t, discover.NodeID{3}, dialer.removed[0].ID)
+	}
+
+	// a node tried once is a dial of connectOutbound
+	assert.Nil(t, pm.AddNode(url, AddNodeOneTry))
+	assert.Equal(t, 2, len(dialer.dialed))
+	assert.Contains(t, pm.dials, discover.NodeID{3})
+	assert.Nil(t, pm.AddNode(url, AddNodeAdd))
+	assert.NotContains(t, pm.dials, discover.NodeID{3})
+}
+
+func TestAdminAPI(t *testing.T) {
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	quit := make(chan struct{})
+	defer close(quit)
+	Manager = newTestManager(quit)
+	rw, remote := p2p.MsgPipe()
+	defer rw.Close()
+	received := readCommands(remote)
+	p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "", nil), rw)
+	assert.Nil(t, Manager.Peers.Register(p))
+	assert.Nil(t, p.sendPing())
+	expectCommand(t, received, "ping")
+
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("admin", &AdminAPI{}))
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var count int
+	assert.Nil(t, client.Call(&count, "admin_getConnectionCount"))
+	assert.Equal(t, 1, count)
+	var slots ConnectionSlots
+	assert.Nil(t, client.Call(&slots, "admin_getConnectionSlots"))
+	assert.Equal(t, ConnectionSlots{MaxInbound: MaxInbound, Outbound: 1, MaxOutbound: MaxOutbound}, slots)
+	var infos []PeerInfo
+	assert.Nil(t, client.Call(&infos, "admin_getPeerInfo"))
+	if assert.Equal(t, 1, len(infos)) {
+		assert.Equal(t, p.id, infos[0].ID)
+		assert.Equal(t, discover.NodeID{2}.String(), infos[0].NodeID)
+		assert.Equal(t, nodeVersion, infos[0].Version)
+		assert.False(t, infos[0].Inbound)
+		assert.True(t, infos[0].BytesSent > 0)
+		assert.True(t, infos[0].LastSend > 0)
+		assert.Equal(t, int64(0), infos[0].LastRecv)
+		assert.True(t, infos[0].PingWait > 0)
+	}
+
+	// a peer is disconnected by its node ID or its ID, an unknown one is an
+	// error
+	assert.Nil(t, client.Call(nil, "admin_disconnectNode", discover.NodeID{2}.String()))
+	assert.Nil(t, client.Call(nil, "admin_disconnectNode", p.id))
+	assert.NotNil(t, client.Call(nil, "admin_disconnectNode", discover.NodeID{3}.String()))
+	assert.NotNil(t, client.Call(nil, "admin_addNode", "10.3.0.1:2000", AddNodeAdd))
+}
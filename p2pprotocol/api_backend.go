@@ -1,29 +1,72 @@
 package p2pprotocol
 
 import (
-	"math/big"
-	"github.com/ethereum/go-ethereum/ethdb"
-	"github.com/ethereum/go-ethereum/event"
-	"github.com/ethereum/go-ethereum/params"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/rpc"
-	"github.com/ethereum/go-ethereum/core/state"
+	"context"
+	"errors"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/rawdb"
-	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
 )
 
-//import "github.com/ethereum/go-ethereum/eth/gasprice"
+// Errors of the calls made before the subsystem they need started
+var (
+	errMinerNotStarted  = errors.New("the miner is not started, there is no pending block")
+	errTxPoolNotStarted = errors.New("the transaction pool is not started")
+)
 
 // SWCAPIBackend implements ethapi.Backend for full nodes
 type SWCAPIBackend struct {
 	swc *SwarmChain
-	//gpo *gasprice.Oracle
+	gpo *gasPriceOracle
+}
+
+// NewSWCAPIBackend returns the backend of swc, suggesting gas prices from
+// its recent blocks with gpo
+func NewSWCAPIBackend(swc *SwarmChain, gpo GasPriceConfig) *SWCAPIBackend {
+	b := &SWCAPIBackend{swc: swc}
+	b.gpo = newGasPriceOracle(backendPrices{b}, gpo)
+
+	return b
+}
+
+// backendPrices reads the gas prices of the chain of the backend for its
+// oracle
+type backendPrices struct {
+	b *SWCAPIBackend
 }
 
+func (c backendPrices) Head() (uint64, []byte) {
+	if c.b.swc.blockchain == nil {
+		return 0, nil
+	}
+	head := c.b.CurrentBlock()
+	if head == nil {
+		return 0, nil
+	}
+	return head.NumberU64(), head.Hash().Bytes()
+}
+
+func (c backendPrices) TxPrices(ctx context.Context, number uint64) ([]*big.Int, error) {
+	block, err := c.b.BlockByNumber(ctx, rpc.BlockNumber(number))
+	if block == nil {
+		return nil, err
+	}
+	var prices []*big.Int
+	for _, tx := range block.Transactions() {
+		prices = append(prices, tx.GasPrice())
+	}
+	return prices, nil
+}
 
 func (b *SWCAPIBackend) ChainConfig() *params.ChainConfig {
 	return b.swc.chainConfig
@@ -34,13 +77,19 @@ func (b *SWCAPIBackend) CurrentBlock() *types.Block {
 }
 
 func (b *SWCAPIBackend) SetHead(number uint64) {
-	b.swc.protocolManager.downloader.Cancel()
+	// a sync started before would go on from the old head
+	if d := b.swc.Downloader(); d != nil {
+		d.Cancel()
+	}
 	b.swc.blockchain.SetHead(number)
 }
 
 func (b *SWCAPIBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error) {
 	// Pending block is only known by the miner
 	if blockNr == rpc.PendingBlockNumber {
+		if b.swc.miner == nil {
+			return nil, errMinerNotStarted
+		}
 		block := b.swc.miner.PendingBlock()
 		return block.Header(), nil
 	}
@@ -54,6 +103,9 @@ func (b *SWCAPIBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNum
 func (b *SWCAPIBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Block, error) {
 	// Pending block is only known by the miner
 	if blockNr == rpc.PendingBlockNumber {
+		if b.swc.miner == nil {
+			return nil, errMinerNotStarted
+		}
 		block := b.swc.miner.PendingBlock()
 		return block, nil
 	}
@@ -67,6 +119,9 @@ func (b *SWCAPIBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNumb
 func (b *SWCAPIBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
 	// Pending state is only known by the miner
 	if blockNr == rpc.PendingBlockNumber {
+		if b.swc.miner == nil {
+			return nil, nil, errMinerNotStarted
+		}
 		block, state := b.swc.miner.Pending()
 		return state, block.Header(), nil
 	}
@@ -139,10 +194,16 @@ func (b *SWCAPIBackend) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscri
 }
 
 func (b *SWCAPIBackend) SendTx(ctx context.Context, signedTx *types.Transaction) error {
+	if b.swc.txPool == nil {
+		return errTxPoolNotStarted
+	}
 	return b.swc.txPool.AddLocal(signedTx)
 }
 
 func (b *SWCAPIBackend) GetPoolTransactions() (types.Transactions, error) {
+	if b.swc.txPool == nil {
+		return nil, errTxPoolNotStarted
+	}
 	pending, err := b.swc.txPool.Pending()
 	if err != nil {
 		return nil, err
@@ -155,18 +216,30 @@ func (b *SWCAPIBackend) GetPoolTransactions() (types.Transactions, error) {
 }
 
 func (b *SWCAPIBackend) GetPoolTransaction(hash common.Hash) *types.Transaction {
+	if b.swc.txPool == nil {
+		return nil
+	}
 	return b.swc.txPool.Get(hash)
 }
 
 func (b *SWCAPIBackend) GetPoolNonce(ctx context.Context, addr common.Address) (uint64, error) {
+	if b.swc.txPool == nil {
+		return 0, errTxPoolNotStarted
+	}
 	return b.swc.txPool.State().GetNonce(addr), nil
 }
 
 func (b *SWCAPIBackend) Stats() (pending int, queued int) {
+	if b.swc.txPool == nil {
+		return 0, 0
+	}
 	return b.swc.txPool.Stats()
 }
 
 func (b *SWCAPIBackend) TxPoolContent() (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
+	if b.swc.txPool == nil {
+		return nil, nil
+	}
 	return b.swc.TxPool().Content()
 }
 
@@ -183,6 +256,9 @@ func (b *SWCAPIBackend) ProtocolVersion() int {
 }
 
 func (b *SWCAPIBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
+	if b.gpo == nil {
+		return new(big.Int).Set(DefaultGasPriceConfig.Default), nil
+	}
 	return b.gpo.SuggestPrice(ctx)
 }
 
@@ -192,4 +268,4 @@ func (b *SWCAPIBackend) ChainDb() ethdb.Database {
 
 func (b *SWCAPIBackend) EventMux() *event.TypeMux {
 	return b.swc.EventMux()
-}
\ No newline at end of file
+}
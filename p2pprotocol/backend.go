@@ -1,51 +1,149 @@
 package p2pprotocol
 
 import (
-	"github.com/ethereum/go-ethereum/params"
-	//"github.com/ethereum/go-ethereum/ethdb"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
-	"github.com/ethereum/go-ethereum/eth"
-	"github.com/ethereum/go-ethereum/light"
-	"github.com/ethereum/go-ethereum/node"
-	"github.com/ethereum/go-ethereum/core/bloombits"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/miner"
+	"github.com/ethereum/go-ethereum/params"
 )
 
+// swcProtocolVersion is the protocol version reported over the API
+const swcProtocolVersion = int(nodeVersion)
+
+// Config configures the chain of a SwarmChain
+type Config struct {
+	Genesis *core.Genesis // nil for the default genesis
+
+	NoPruning      bool
+	TrieCleanCache int
+	TrieDirtyCache int
+	TrieTimeout    time.Duration
+
+	Ethash ethash.Config // the engine unless the genesis configures clique
+	TxPool core.TxPoolConfig
+	GPO    GasPriceConfig
+}
+
+// DefaultConfig is the Config of a node on the default genesis
+var DefaultConfig = Config{
+	TrieCleanCache: 256,
+	TrieDirtyCache: 256,
+	TrieTimeout:    60 * time.Minute,
+	Ethash:         ethash.Config{CachesInMem: 2, CachesOnDisk: 3, DatasetsInMem: 1, DatasetsOnDisk: 2},
+	TxPool:         core.DefaultTxPoolConfig,
+	GPO:            DefaultGasPriceConfig,
+}
+
 type SwarmChain struct {
-	config *eth.Config
+	config *Config
 
 	chainConfig *params.ChainConfig
-	// Channel for shutting down the service
-	//shutdownChan chan bool
 	// Handlers
 	peers           *peerSet
-	txPool          *light.TxPool
-	blockchain      *light.LightChain
+	txPool          *core.TxPool
+	blockchain      *core.BlockChain
 	protocolManager *ProtocolManager
+	// miner and downloader are nil until they start, the API calls needing
+	// them fail meanwhile
+	miner      *miner.Miner
+	downloader *downloader.Downloader
 	// DB interfaces
-	//chainDb ethdb.Database // Block chain database
-
-	ApiBackend *LesApiBackend
+	chainDb ethdb.Database // Block chain database
 
-	eventMux       *event.TypeMux
+	ApiBackend *SWCAPIBackend
 
+	eventMux *event.TypeMux
 }
 
+// New creates a new SwarmChain object, its chain and transaction pool kept
+// in chainDb. mux is the event mux of the node
+func New(chainDb ethdb.Database, mux *event.TypeMux, config *Config) (*SwarmChain, error) {
+	chainConfig, _, genesisErr := core.SetupGenesisBlock(chainDb, config.Genesis)
+	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
+		return nil, genesisErr
+	}
+	log.Info("Initialised chain configuration", "config", chainConfig)
 
-// New creates a new SwarmChain object
-func New(ctx *node.ServiceContext, config *Config) (*SwarmChain, error) {
-	eth := &SwarmChain{
-		config:         config,
-		chainDb:        chainDb,
-		chainConfig:    chainConfig,
-		eventMux:       ctx.EventMux,
-		accountManager: ctx.AccountManager,
-		engine:         CreateConsensusEngine(ctx, &config.Ethash, chainConfig, chainDb),
-		shutdownChan:   make(chan bool),
-		networkId:      config.NetworkId,
-		gasPrice:       config.GasPrice,
-		etherbase:      config.Etherbase,
-		bloomRequests:  make(chan chan *bloombits.Retrieval),
-		bloomIndexer:   NewBloomIndexer(chainDb, params.BloomBitsBlocks),
+	swc := &SwarmChain{
+		config:      config,
+		chainDb:     chainDb,
+		chainConfig: chainConfig,
+		eventMux:    mux,
+		peers:       newPeerSet(),
+	}
+	cacheConfig := &core.CacheConfig{
+		Disabled:       config.NoPruning,
+		TrieCleanLimit: config.TrieCleanCache,
+		TrieDirtyLimit: config.TrieDirtyCache,
+		TrieTimeLimit:  config.TrieTimeout,
 	}
-	return eth, nil
+	var err error
+	engine := createConsensusEngine(chainConfig, config, chainDb)
+	swc.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, engine, vm.Config{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rewind the chain in case of an incompatible config upgrade
+	if compat, ok := genesisErr.(*params.ConfigCompatError); ok {
+		log.Warn("Rewinding chain to upgrade configuration", "err", compat)
+		swc.blockchain.SetHead(compat.RewindTo)
+	}
+	swc.txPool = core.NewTxPool(config.TxPool, chainConfig, swc.blockchain)
+	swc.ApiBackend = NewSWCAPIBackend(swc, config.GPO)
+
+	return swc, nil
+}
+
+// createConsensusEngine returns clique when the chain configures it, ethash
+// otherwise
+func createConsensusEngine(chainConfig *params.ChainConfig, config *Config, db ethdb.Database) consensus.Engine {
+	if chainConfig.Clique != nil {
+		return clique.New(chainConfig.Clique, db)
+	}
+	return ethash.New(config.Ethash, nil, false)
+}
+
+// Stop stops the transaction pool and the chain
+func (s *SwarmChain) Stop() {
+	s.txPool.Stop()
+	s.blockchain.Stop()
+}
+
+// BlockChain returns the chain
+func (s *SwarmChain) BlockChain() *core.BlockChain {
+	return s.blockchain
+}
+
+// TxPool returns the transaction pool
+func (s *SwarmChain) TxPool() *core.TxPool {
+	return s.txPool
+}
+
+// ChainDb returns the database of the chain
+func (s *SwarmChain) ChainDb() ethdb.Database {
+	return s.chainDb
+}
+
+// EventMux returns the event mux of the node
+func (s *SwarmChain) EventMux() *event.TypeMux {
+	return s.eventMux
+}
+
+// Downloader returns the downloader of the chain, nil before it started
+func (s *SwarmChain) Downloader() *downloader.Downloader {
+	return s.downloader
+}
+
+// swcVersion returns the version of the protocol spoken with the peers
+func (s *SwarmChain) swcVersion() int {
+	return swcProtocolVersion
 }
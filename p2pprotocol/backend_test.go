@@ -0,0 +1,119 @@
+package p2pprotocol
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSWCAPIBackend(t *testing.T) {
+	ctx := context.Background()
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.Address{1}
+	signer := types.MakeSigner(params.TestChainConfig, big.NewInt(0))
+	genesis := &core.Genesis{
+		Config:   params.TestChainConfig,
+		GasLimit: params.GenesisGasLimit,
+		Alloc:    core.GenesisAlloc{from: {Balance: big.NewInt(1e18)}},
+	}
+	config := DefaultConfig
+	config.Genesis = genesis
+	config.Ethash = ethash.Config{PowMode: ethash.ModeFake}
+	config.GPO = GasPriceConfig{Blocks: 2, Percentile: 50, Default: big.NewInt(10), Max: big.NewInt(1000)}
+
+	swc, err := New(ethdb.NewMemDatabase(), new(event.TypeMux), &config)
+	assert.Nil(t, err)
+	defer swc.Stop()
+	b := swc.ApiBackend
+
+	// the subsystems not started fail instead of panicking
+	_, err = b.HeaderByNumber(ctx, rpc.PendingBlockNumber)
+	assert.Equal(t, errMinerNotStarted, err)
+	_, _, err = b.StateAndHeaderByNumber(ctx, rpc.PendingBlockNumber)
+	assert.Equal(t, errMinerNotStarted, err)
+	assert.Nil(t, b.Downloader())
+	b.SetHead(0)
+
+	// the floor on the genesis alone
+	price, err := b.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(10), price)
+
+	// the median of the prices of the last two blocks with transactions
+	nonce := uint64(0)
+	send := func(gen *core.BlockGen, price int64) {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, to, big.NewInt(1), params.TxGas, big.NewInt(price), nil), signer, key)
+		gen.AddTx(tx)
+		nonce++
+	}
+	db := ethdb.NewMemDatabase()
+	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis.MustCommit(db), ethash.NewFaker(), db, 4, func(i int, gen *core.BlockGen) {
+		switch i {
+		case 0:
+			send(gen, 900)
+		case 1:
+			send(gen, 20)
+			send(gen, 30)
+		case 3:
+			send(gen, 40)
+			send(gen, 50)
+			send(gen, 60)
+		}
+	})
+	_, err = swc.BlockChain().InsertChain(blocks)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), b.CurrentBlock().NumberU64())
+	price, err = b.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(40), price)
+
+	// the pool resets to the new head on its own goroutine
+	for deadline := time.Now().Add(5 * time.Second); swc.TxPool().State().GetNonce(from) != nonce; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The pool didn't reach the new head")
+		}
+	}
+
+	// transactions go to the pool
+	tx, _ := types.SignTx(types.NewTransaction(nonce, to, big.NewInt(1), params.TxGas, big.NewInt(100), nil), signer, key)
+	assert.Nil(t, b.SendTx(ctx, tx))
+	assert.Equal(t, tx.Hash(), b.GetPoolTransaction(tx.Hash()).Hash())
+	poolNonce, err := b.GetPoolNonce(ctx, from)
+	assert.Nil(t, err)
+	assert.Equal(t, nonce+1, poolNonce)
+	pending, queued := b.Stats()
+	assert.Equal(t, 1, pending)
+	assert.Equal(t, 0, queued)
+}
+
+func TestSWCAPIBackendNotStarted(t *testing.T) {
+	ctx := context.Background()
+	b := NewSWCAPIBackend(&SwarmChain{}, GasPriceConfig{})
+
+	_, err := b.HeaderByNumber(ctx, rpc.PendingBlockNumber)
+	assert.Equal(t, errMinerNotStarted, err)
+	_, err = b.BlockByNumber(ctx, rpc.PendingBlockNumber)
+	assert.Equal(t, errMinerNotStarted, err)
+	assert.Equal(t, errTxPoolNotStarted, b.SendTx(ctx, new(types.Transaction)))
+	_, err = b.GetPoolTransactions()
+	assert.Equal(t, errTxPoolNotStarted, err)
+	_, err = b.GetPoolNonce(ctx, common.Address{})
+	assert.Equal(t, errTxPoolNotStarted, err)
+	assert.Nil(t, b.GetPoolTransaction(common.Hash{}))
+
+	_, err = b.SuggestPrice(ctx)
+	assert.Equal(t, errNoChainHead, err)
+}
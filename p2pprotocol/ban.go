@@ -0,0 +1,228 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+)
+
+// BanThreshold is the ban score at which a peer is disconnected and its
+// address banned
+var BanThreshold = 100
+
+// BanDuration is how long a misbehaving peer stays banned
+var BanDuration = 24 * time.Hour
+
+// The scores misbehaviours add to the ban score of a peer
+const (
+	scoreMalformed = 10  // a message that doesn't decode
+	scoreOrphanTx  = 1   // a transaction spending outputs the node doesn't know, maybe of one it missed
+	scoreInvalidTx = 10  // a transaction failing VerifyTx otherwise
+	scoreTooBig    = 100 // a block over the size limit, a frame over that of its command
+	scoreAddrFlood = 20  // an addr message of over maxAddrs addresses
+)
+
+// blockScores are the scores of the errors VerifyBlock refuses a block for,
+// scoreInvalidBlock for those missing. A block dated in the future may
+// only tell the clocks differ
+var blockScores = map[error]int{
+	core.ErrTimeTooNew:           0,
+	core.ErrTimeTooOld:           10,
+	core.ErrCheckpointMismatch:   100,
+	core.ErrForkBeforeCheckpoint: 100,
+}
+
+const scoreInvalidBlock = 100
+
+// errBanned is returned for a connection from a banned address or node
+var errBanned = errors.New("the address or the node ID of the peer is banned")
+
+// blockScore returns the score of a block refused with err, 0 for an
+// orphan, a reorganisation too deep, or a failure to read the chain
+func blockScore(err error) int {
+	if score, ok := blockScores[err]; ok {
+		return score
+	}
+	if core.IsInvalidBlock(err) {
+		return scoreInvalidBlock
+	}
+
+	return 0
+}
+
+// txScore returns the score of tx refused by VerifyTx, an orphan spending
+// outputs the UTXO set doesn't know scores the least. A transaction the
+// UTXO set couldn't be read for isn't scored, the peer isn't at fault
+func txScore(tx *core.Transaction, bc *core.Blockchain) int {
+	report, err := core.UTXOSet{Blockchain: bc}.CheckUTXOAmount(tx)
+	if err != nil {
+		return 0
+	}
+	for _, in := range report.Inputs {
+		if in.Err == core.ErrUnknownOutpoint {
+			return scoreOrphanTx
+		}
+	}
+
+	return scoreInvalidTx
+}
+
+// malformed scores a message of the peer that doesn't decode
+func malformed(p *Peer, command Command, err error) {
+	p.Misbehaving(scoreMalformed, fmt.Sprintf("malformed %s message: %v", command.Command, err))
+}
+
+// Misbehaving adds score to the ban score of the peer for reason. Crossing
+// BanThreshold bans its address and its node ID for BanDuration and
+// disconnects it. A whitelisted peer isn't scored
+func (p *Peer) Misbehaving(score int, reason string) {
+	if score <= 0 {
+		return
+	}
+	if p.whitelisted() {
+		log.Printf("whitelisted peer %s misbehaving, not scored: %s", p.id, reason)
+		return
+	}
+	p.lock.Lock()
+	before := p.banScore
+	p.banScore += score
+	after := p.banScore
+	p.lock.Unlock()
+	log.Printf("peer %s misbehaving, ban score %d: %s", p.id, after, reason)
+	if before >= BanThreshold || after < BanThreshold {
+		return
+	}
+
+	for _, address := range []string{peerAddress(p), peerKey(p)} {
+		if address == "" {
+			continue
+		}
+		ban := core.BannedPeer{Address: address, Until: time.Now().Add(BanDuration), Reason: reason}
+		if err := bans.add(os.Getenv("NODE_ID"), ban); err != nil {
+			log.Printf("banning peer %s: %v", p.id, err)
+		} else {
+			log.Printf("banned %s until %s: %s", address, ban.Until.Format(time.RFC3339), reason)
+		}
+	}
+	if p.Peer != nil {
+		p.Peer.Disconnect(p2p.DiscUselessPeer)
+	}
+}
+
+// peerAddress returns the IP address of the peer, what bans apply to, empty
+// when unknown
+func peerAddress(p *Peer) string {
+	if p.Peer == nil {
+		return ""
+	}
+	addr := p.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// peerKey returns the node ID of the peer, its public key the encryption
+// handshake authenticated, in hex. Unlike its address it stays the same
+// wherever the peer connects from, empty when unknown
+func peerKey(p *Peer) string {
+	if p.Peer == nil {
+		return ""
+	}
+
+	return p.ID().String()
+}
+
+// BanKey returns what a ban of target is recorded under, target being an IP
+// address or a node ID in hex, or why it is neither
+func BanKey(target string) (string, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return ip.String(), nil
+	}
+	id, err := discover.HexID(target)
+	if err != nil {
+		return "", fmt.Errorf("%s is neither an IP address nor a node ID: %v", target, err)
+	}
+
+	return id.String(), nil
+}
+
+// banList caches the ban list of the node, read again when its file
+// changes, by setban or clearbanned for one
+type banList struct {
+	mu      sync.Mutex
+	modTime time.Time
+	bans    map[string]core.BannedPeer
+}
+
+var bans = &banList{}
+
+// banned returns the ban of address, if it's banned
+func (l *banList) banned(nodeID, address string) (core.BannedPeer, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var modTime time.Time
+	if info, err := os.Stat(core.BanListFile(nodeID)); err == nil {
+		modTime = info.ModTime()
+	}
+	if l.bans == nil || !modTime.Equal(l.modTime) {
+		list, err := core.ListBanned(nodeID)
+		if err != nil {
+			log.Println("reading the ban list:", err)
+		} else {
+			l.bans = make(map[string]core.BannedPeer)
+			for _, ban := range list {
+				l.bans[ban.Address] = ban
+			}
+			l.modTime = modTime
+		}
+	}
+	ban, ok := l.bans[address]
+	if !ok || !ban.Until.After(time.Now()) {
+		return core.BannedPeer{}, false
+	}
+
+	return ban, true
+}
+
+// add bans an address, the list is read again at the next check
+func (l *banList) add(nodeID string, ban core.BannedPeer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bans = nil
+	return core.BanPeer(nodeID, ban)
+}
+
+// checkBanned returns errBanned for a peer whose address or node ID is
+// banned, unless it's whitelisted
+func checkBanned(p *Peer) error {
+	if p.whitelisted() {
+		return nil
+	}
+	for _, address := range []string{peerAddress(p), peerKey(p)} {
+		if address == "" {
+			continue
+		}
+		if ban, ok := bans.banned(os.Getenv("NODE_ID"), address); ok {
+			log.Printf("peer %s, %s, is banned until %s: %s", p.id, address, ban.Until.Format(time.RFC3339), ban.Reason)
+			return errBanned
+		}
+	}
+
+	return nil
+}
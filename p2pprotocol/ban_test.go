@@ -0,0 +1,98 @@
+package p2pprotocol
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockScore(t *testing.T) {
+	// what a peer may send while the node syncs scores nothing
+	assert.Equal(t, 0, blockScore(core.ErrOrphanBlock))
+	assert.Equal(t, 0, blockScore(core.ErrPrevNotTip))
+	assert.Equal(t, 0, blockScore(core.ErrReorgTooDeep))
+	assert.Equal(t, 0, blockScore(core.ErrTimeTooNew))
+	assert.Equal(t, BanThreshold, blockScore(core.ErrBadProofOfWork))
+	assert.Equal(t, BanThreshold, blockScore(core.ErrBadTxSignature))
+}
+
+func TestMisbehaving(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer os.Setenv("NODE_ID", os.Getenv("NODE_ID"))
+	os.Setenv("NODE_ID", "bantest")
+	defer func(l *banList) { bans = l }(bans)
+	bans = &banList{}
+
+	rw, remote := p2p.MsgPipe()
+	defer remote.Close()
+	p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rw)
+	address := peerAddress(p)
+	assert.NotEqual(t, "", address)
+
+	// messages that don't decode add up to the threshold
+	garbage := Command{"inv", []byte("garbage")}
+	for i := 0; i < BanThreshold/scoreMalformed-1; i++ {
+		HandleConnection(p, garbage, nil)
+	}
+	assert.Nil(t, checkBanned(p))
+	HandleConnection(p, garbage, nil)
+	assert.Equal(t, errBanned, checkBanned(p))
+
+	// the ban of its address and its node ID outlives the node, until lifted
+	banned, err := core.ListBanned("bantest")
+	assert.Nil(t, err)
+	var addresses []string
+	for _, ban := range banned {
+		addresses = append(addresses, ban.Address)
+	}
+	sort.Strings(addresses)
+	expected := []string{address, peerKey(p)}
+	sort.Strings(expected)
+	assert.Equal(t, expected, addresses)
+	bans = &banList{}
+	assert.Equal(t, errBanned, checkBanned(p))
+	_, err = core.UnbanPeer("bantest", address)
+	assert.Nil(t, err)
+	assert.Nil(t, checkBanned(newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rw)))
+
+	// the node ID stays banned wherever the peer connects from
+	assert.Equal(t, errBanned, checkBanned(p))
+	_, err = core.UnbanPeer("bantest", peerKey(p))
+	assert.Nil(t, err)
+	assert.Nil(t, checkBanned(p))
+}
+
+func TestBanKey(t *testing.T) {
+	key, err := BanKey("10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", key)
+	key, err = BanKey("::ffff:10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", key)
+
+	id := discover.NodeID{0xab, 1}
+	key, err = BanKey("0x" + strings.ToUpper(id.String()))
+	assert.Nil(t, err)
+	assert.Equal(t, id.String(), key)
+
+	for _, target := range []string{"", "10.0.0", "peer", id.String()[:64]} {
+		_, err = BanKey(target)
+		assert.NotNil(t, err, target)
+	}
+}
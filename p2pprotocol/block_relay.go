@@ -0,0 +1,223 @@
+package p2pprotocol
+
+import (
+	"container/list"
+	"encoding/hex"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// blockFetchTimeout is how long a block asked for is waited for before the
+// next peer announcing it is asked
+var blockFetchTimeout = 20 * time.Second
+
+// maxSeenBlocks is the number of block hashes seenBlocks holds
+const maxSeenBlocks = 2048
+
+// blockState is how far the node got with a block announced or received
+type blockState int
+
+const (
+	blockUnseen     blockState = iota
+	blockRequested             // asked for from a peer
+	blockValidating            // received, being processed
+	blockStored                // stored, on the main chain or a side branch
+	blockRejected              // invalid
+)
+
+// seenBlock is the state of a block hash, the peers to ask for it and the
+// one it came from
+type seenBlock struct {
+	hash       []byte
+	state      blockState
+	peer       *Peer   // the peer asked for the block, then the one it came from
+	announcers []*Peer // the other peers announcing the block, asked in turn
+	timer      *time.Timer
+}
+
+// blockCache holds the states of the blocks seen lately, at most limit,
+// those updated the least recently dropped first. A block is asked for
+// from one peer at a time
+type blockCache struct {
+	mu    sync.Mutex
+	limit int
+	order *list.List // of *seenBlock, the most recently updated in front
+	items map[string]*list.Element
+}
+
+// seenBlocks are the blocks the node saw lately
+var seenBlocks = newBlockCache(maxSeenBlocks)
+
+func newBlockCache(limit int) *blockCache {
+	return &blockCache{
+		limit: limit,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the entry of hash, added unseen when it's missing. The lock
+// is held
+func (c *blockCache) get(hash []byte) *seenBlock {
+	key := hex.EncodeToString(hash)
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*seenBlock)
+	}
+	seen := &seenBlock{hash: hash}
+	c.items[key] = c.order.PushFront(seen)
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		evicted := oldest.Value.(*seenBlock)
+		if evicted.timer != nil {
+			evicted.timer.Stop()
+		}
+		delete(c.items, hex.EncodeToString(evicted.hash))
+	}
+
+	return seen
+}
+
+// State returns the state of the block of hash
+func (c *blockCache) State(hash []byte) blockState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[hex.EncodeToString(hash)]; ok {
+		return e.Value.(*seenBlock).state
+	}
+	return blockUnseen
+}
+
+// announce records that p announced the block of hash, and returns whether
+// p is to be asked for it: the block isn't seen yet. Another announcer of
+// a block asked for is asked in turn if it doesn't come within
+// blockFetchTimeout, the announcements of the others are answered from the
+// cache
+func (c *blockCache) announce(hash []byte, p *Peer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := c.get(hash)
+	switch seen.state {
+	case blockUnseen:
+		seen.state = blockRequested
+		seen.peer = p
+		seen.timer = time.AfterFunc(blockFetchTimeout, func() { c.refetch(seen) })
+		return true
+	case blockRequested:
+		if p != seen.peer && !hasPeer(seen.announcers, p) {
+			seen.announcers = append(seen.announcers, p)
+		}
+	}
+	atomic.AddUint64(&inventoryStats.AnnouncesSuppressed, 1)
+	return false
+}
+
+func hasPeer(peers []*Peer, p *Peer) bool {
+	for _, peer := range peers {
+		if peer == p {
+			return true
+		}
+	}
+	return false
+}
+
+// refetch asks the next announcer of a block still not received, still
+// connected, for it. The block is forgotten when there's none left, the
+// next announcement asks for it again
+func (c *blockCache) refetch(seen *seenBlock) {
+	c.mu.Lock()
+	if seen.state != blockRequested {
+		c.mu.Unlock()
+		return
+	}
+	var next *Peer
+	for next == nil && len(seen.announcers) > 0 {
+		next, seen.announcers = seen.announcers[0], seen.announcers[1:]
+		if Manager == nil || Manager.Peers.Peer(next.id) != next {
+			next = nil
+		}
+	}
+	if next == nil {
+		c.remove(seen)
+		c.mu.Unlock()
+		return
+	}
+	seen.peer = next
+	seen.timer = time.AfterFunc(blockFetchTimeout, func() { c.refetch(seen) })
+	c.mu.Unlock()
+
+	log.Printf("block %x not received in %s, asking peer %s", seen.hash, blockFetchTimeout, next.id)
+	atomic.AddUint64(&inventoryStats.BlockRefetches, 1)
+	requestBlock(next, seen.hash)
+}
+
+// remove drops seen from the cache. The lock is held
+func (c *blockCache) remove(seen *seenBlock) {
+	key := hex.EncodeToString(seen.hash)
+	if e, ok := c.items[key]; ok && e.Value == seen {
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+	if seen.timer != nil {
+		seen.timer.Stop()
+	}
+}
+
+// receive records that p sent the block of hash, and returns the state it
+// had. The block is to be processed when it was neither validating,
+// stored or rejected, it's validating then
+func (c *blockCache) receive(hash []byte, p *Peer) blockState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := c.get(hash)
+	previous := seen.state
+	switch previous {
+	case blockValidating, blockStored, blockRejected:
+		atomic.AddUint64(&inventoryStats.BlocksSuppressed, 1)
+		return previous
+	}
+	if seen.timer != nil {
+		seen.timer.Stop()
+		seen.timer = nil
+	}
+	seen.state = blockValidating
+	seen.peer = p
+	seen.announcers = nil
+
+	return previous
+}
+
+// processed records how the processing of the block of hash ended: state
+// stored or rejected, unseen to forget it, e.g. an orphan or a block
+// not stored for an error of the node
+func (c *blockCache) processed(hash []byte, state blockState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := c.get(hash)
+	if state == blockUnseen {
+		c.remove(seen)
+		return
+	}
+	seen.state = state
+}
+
+// source returns the peer the block of hash came from, nil if it's not
+// known
+func (c *blockCache) source(hash []byte) *Peer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[hex.EncodeToString(hash)]; ok {
+		if seen := e.Value.(*seenBlock); seen.state >= blockValidating {
+			return seen.peer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package p2pprotocol
+
+import (
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockCache(t *testing.T) {
+	defer func(m *ProtocolManager, c *blockCache, timeout time.Duration) {
+		Manager, seenBlocks, blockFetchTimeout = m, c, timeout
+	}(Manager, seenBlocks, blockFetchTimeout)
+	Manager = &ProtocolManager{Peers: newPeerSet()}
+	seenBlocks = newBlockCache(maxSeenBlocks)
+	blockFetchTimeout = 20 * time.Millisecond
+	var peers []*Peer
+	var received []<-chan Command
+	for i := 0; i < 3; i++ {
+		rw, remote := p2p.MsgPipe()
+		defer rw.Close()
+		p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{byte(i + 1)}, "", nil), rw)
+		assert.Nil(t, Manager.Peers.Register(p))
+		peers = append(peers, p)
+		received = append(received, readCommands(remote))
+	}
+	a, b, c := peers[0], peers[1], peers[2]
+	before := inventoryStats.snapshot()
+
+	// the first announcer is asked for the block, the others in turn while
+	// it doesn't come, then it's forgotten
+	hash := []byte{1}
+	assert.True(t, seenBlocks.announce(hash, a))
+	assert.False(t, seenBlocks.announce(hash, b))
+	assert.False(t, seenBlocks.announce(hash, c))
+	assert.False(t, seenBlocks.announce(hash, b))
+	for _, i := range []int{1, 2} {
+		select {
+		case command := <-received[i]:
+			var payload getdata
+			assert.Nil(t, gobDecode(command.Data, &payload))
+			assert.Equal(t, hash, payload.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("Announcer %d wasn't asked for the block", i)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); seenBlocks.State(hash) != blockUnseen; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The block not received was kept")
+		}
+	}
+	assert.True(t, seenBlocks.announce(hash, c))
+
+	// a block is processed once, whoever sends it, and isn't announced
+	// back to the peer it came from
+	hash = []byte{2}
+	assert.True(t, seenBlocks.announce(hash, a))
+	assert.Equal(t, blockRequested, seenBlocks.receive(hash, b))
+	assert.Equal(t, blockValidating, seenBlocks.receive(hash, a))
+	seenBlocks.processed(hash, blockStored)
+	assert.False(t, seenBlocks.announce(hash, c))
+	assert.Equal(t, blockStored, seenBlocks.State(hash))
+	Manager.AnnounceBlock(&core.Block{Hash: hash})
+	for _, i := range []int{0, 2} {
+		select {
+		case command := <-received[i]:
+			assert.Equal(t, "inv", command.Command)
+		case <-time.After(time.Second):
+			t.Fatalf("Peer %d wasn't announced the block", i)
+		}
+	}
+	select {
+	case command := <-received[1]:
+		t.Fatalf("The peer the block came from was sent %s", command.Command)
+	case <-time.After(3 * blockFetchTimeout):
+	}
+
+	// nor is a rejected one
+	hash = []byte{3}
+	assert.Equal(t, blockUnseen, seenBlocks.receive(hash, a))
+	seenBlocks.processed(hash, blockRejected)
+	assert.Equal(t, blockRejected, seenBlocks.receive(hash, b))
+	assert.False(t, seenBlocks.announce(hash, c))
+
+	after := inventoryStats.snapshot()
+	assert.Equal(t, uint64(5), after.AnnouncesSuppressed-before.AnnouncesSuppressed)
+	assert.Equal(t, uint64(2), after.BlocksSuppressed-before.BlocksSuppressed)
+	assert.Equal(t, uint64(2), after.BlockRefetches-before.BlockRefetches)
+	assert.Equal(t, uint64(1), after.RelaysSuppressed-before.RelaysSuppressed)
+
+	// the least recently updated blocks are dropped past the limit
+	cache := newBlockCache(2)
+	cache.processed([]byte{1}, blockStored)
+	cache.processed([]byte{2}, blockStored)
+	cache.processed([]byte{3}, blockRejected)
+	assert.Equal(t, blockUnseen, cache.State([]byte{1}))
+	assert.Equal(t, blockStored, cache.State([]byte{2}))
+	assert.Equal(t, blockRejected, cache.State([]byte{3}))
+}
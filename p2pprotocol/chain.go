@@ -0,0 +1,89 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"../blockchain_go"
+)
+
+// shutdownWait is how long the node waits on shutdown for the message
+// handlers to be done with the chain before closing it under them
+const shutdownWait = 10 * time.Second
+
+// errShuttingDown is returned by openChain once the node is going down
+var errShuttingDown = errors.New("the node is shutting down")
+
+// openChains holds the chains the message handlers have open, see
+// closeChains
+var openChains = struct {
+	sync.Mutex
+	closing bool
+	chains  map[*core.Blockchain]bool
+}{chains: make(map[*core.Blockchain]bool)}
+
+// openChain opens the chain of nodeID for a message handler. It waits for
+// the other handlers to close it, so it isn't opened under the lock
+func openChain(nodeID string) (*core.Blockchain, error) {
+	bc, err := core.NewBlockchain(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	openChains.Lock()
+	defer openChains.Unlock()
+	if openChains.closing {
+		bc.Close()
+		return nil, errShuttingDown
+	}
+	openChains.chains[bc] = true
+
+	return bc, nil
+}
+
+// closeChain closes a chain of openChain
+func closeChain(bc *core.Blockchain) {
+	openChains.Lock()
+	delete(openChains.chains, bc)
+	openChains.Unlock()
+
+	if err := bc.Close(); err != nil {
+		log.Println("closing the blockchain:", err)
+	}
+}
+
+// openChainsAgain lets the handlers open the chain again after closeChains,
+// for a node started again in the same process
+func openChainsAgain() {
+	openChains.Lock()
+	defer openChains.Unlock()
+	openChains.closing = false
+}
+
+// closeChains refuses the chain to new handlers, waits up to shutdownWait
+// for the open ones to be closed and closes those left
+func closeChains() {
+	openChains.Lock()
+	openChains.closing = true
+	openChains.Unlock()
+
+	for deadline := time.Now().Add(shutdownWait); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		openChains.Lock()
+		open := len(openChains.chains)
+		openChains.Unlock()
+		if open == 0 {
+			return
+		}
+	}
+
+	openChains.Lock()
+	defer openChains.Unlock()
+	for bc := range openChains.chains {
+		if err := bc.Close(); err != nil {
+			log.Println("closing the blockchain:", err)
+		}
+		delete(openChains.chains, bc)
+	}
+}
@@ -0,0 +1,103 @@
+package p2pprotocol
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"../blockchain_go"
+)
+
+// chainEventBuffer is the number of blocks the node may fall behind the
+// chain by before their events are dropped
+const chainEventBuffer = 256
+
+// followChain moves the miner, the mempool and the wallets along with the
+// blocks connected to and disconnected from the tip of the chain of bc,
+// whichever handler stores them, until quit is closed. wg is done then
+func followChain(bc *core.Blockchain, quit <-chan struct{}, wg *sync.WaitGroup) {
+	connected := make(chan *core.Block, chainEventBuffer)
+	disconnected := make(chan *core.Block, chainEventBuffer)
+	bc.SubscribeBlockConnected(connected)
+	bc.SubscribeBlockDisconnected(disconnected)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// a closed channel is unsubscribed at the next event
+		defer close(connected)
+		defer close(disconnected)
+		for {
+			select {
+			case <-quit:
+				return
+			case block := <-disconnected:
+				blockDisconnected(block)
+			case block := <-connected:
+				// a reorganisation sends the blocks it disconnects first
+				for drained := false; !drained; {
+					select {
+					case block := <-disconnected:
+						blockDisconnected(block)
+					default:
+						drained = true
+					}
+				}
+				blockConnected(block)
+			}
+		}
+	}()
+}
+
+// blockConnected drops the block in flight, which a new tip made stale, and
+// the transactions of the mempool the block includes or conflicts with. The
+// block, mined or received, is announced to the peers, the wallet
+// transactions it includes are no longer rebroadcast and the orphans of
+// its transactions are checked again
+func blockConnected(block *core.Block) {
+	seenBlocks.processed(block.Hash, blockStored)
+	Manager.Miner.Connected(block)
+	Manager.AnnounceBlock(block)
+	Manager.confirmWalletTxs(block)
+	for _, tx := range Manager.TxMempool.RemoveBlock(block) {
+		log.Printf("dropped transaction %x, block %x spends its inputs", tx.ID, block.Hash)
+	}
+	orphansConnected(block)
+	withWallets(func(ws *core.Wallets, bc *core.Blockchain) {
+		ws.ConnectBlock(block, bc)
+	})
+}
+
+// blockDisconnected puts the transactions of the block back in the mempool,
+// the blocks of the new branch remove those they include as they connect
+func blockDisconnected(block *core.Block) {
+	Manager.Miner.Interrupt()
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		if err := Manager.TxMempool.Add(tx); err != nil {
+			log.Printf("dropped transaction %x: %v", tx.ID, err)
+		}
+	}
+	withWallets(func(ws *core.Wallets, bc *core.Blockchain) {
+		ws.DisconnectBlock(block, bc)
+	})
+}
+
+// withWallets calls f with the node wallets and the chain, which it waits
+// for the handlers to close. The cached balances are dropped when the chain
+// can't be opened
+func withWallets(f func(ws *core.Wallets, bc *core.Blockchain)) {
+	if NodeWallets == nil {
+		return
+	}
+	bc, err := openChain(os.Getenv("NODE_ID"))
+	if err != nil {
+		log.Println("updating the wallet balances:", err)
+		NodeWallets.InvalidateBalances()
+		return
+	}
+	defer closeChain(bc)
+	f(NodeWallets, bc)
+}
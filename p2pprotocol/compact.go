@@ -0,0 +1,335 @@
+package p2pprotocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"../blockchain_go"
+)
+
+// compactRelayAge is how old a block may be to be relayed compactly, the
+// transactions of older blocks aren't in the mempools any more
+const compactRelayAge = 10 * time.Minute
+
+// shortIDSize is the number of bytes of a short transaction ID
+const shortIDSize = 6
+
+// cmpctBlock announces a block with its header and the short IDs of its
+// transactions, see shortID, those the receiver can't have prefilled
+type cmpctBlock struct {
+	AddrFrom  string
+	Header    core.BlockHeader
+	Nonce     uint64   // the salt of the short IDs
+	ShortIDs  []uint64 // of the transactions not prefilled, in block order
+	Prefilled []prefilledTx
+}
+
+// prefilledTx is a transaction of a compact block sent in full, the
+// coinbase, at its index in the block
+type prefilledTx struct {
+	Index int
+	Tx    []byte
+}
+
+// getBlockTxn asks for the transactions of a compact block the receiver
+// couldn't find in its mempool, at their indexes in the block
+type getBlockTxn struct {
+	AddrFrom string
+	Hash     []byte
+	Indexes  []int
+}
+
+// blockTxn answers a getBlockTxn with the transactions, in its order
+type blockTxn struct {
+	AddrFrom string
+	Hash     []byte
+	Txs      [][]byte
+}
+
+// errCompactBlock is returned for a compact block whose indexes don't fit
+var errCompactBlock = errors.New("prefilled transaction index out of range or repeated")
+
+// shortID returns the short ID of the transaction of ID txID in the block
+// of hash, salted with nonce: the first shortIDSize bytes of their hash.
+// The salt makes the collisions differ from a block to the next
+func shortID(hash []byte, nonce uint64, txID []byte) uint64 {
+	var salt [8]byte
+	binary.LittleEndian.PutUint64(salt[:], nonce)
+	h := sha256.New()
+	h.Write(hash)
+	h.Write(salt[:])
+	h.Write(txID)
+	var id [8]byte
+	copy(id[:shortIDSize], h.Sum(nil))
+
+	return binary.LittleEndian.Uint64(id[:])
+}
+
+// CompactStats are the counters of the compact block relay
+type CompactStats struct {
+	Sent           uint64 `json:"sent"`             // compact blocks sent
+	Received       uint64 `json:"received"`         // compact blocks received
+	Reconstructed  uint64 `json:"reconstructed"`    // blocks rebuilt from them
+	TxsFromMempool uint64 `json:"txs_from_mempool"` // transactions found in the mempool
+	TxsRequested   uint64 `json:"txs_requested"`    // transactions asked for with getblocktxn
+	FullFallbacks  uint64 `json:"full_fallbacks"`   // blocks asked for in full, not rebuilt
+	// HitRate is the share of the transactions not prefilled found in the
+	// mempool
+	HitRate float64 `json:"hit_rate"`
+}
+
+var compactStats CompactStats
+
+func (s *CompactStats) snapshot() CompactStats {
+	stats := CompactStats{
+		Sent:           atomic.LoadUint64(&s.Sent),
+		Received:       atomic.LoadUint64(&s.Received),
+		Reconstructed:  atomic.LoadUint64(&s.Reconstructed),
+		TxsFromMempool: atomic.LoadUint64(&s.TxsFromMempool),
+		TxsRequested:   atomic.LoadUint64(&s.TxsRequested),
+		FullFallbacks:  atomic.LoadUint64(&s.FullFallbacks),
+	}
+	if total := stats.TxsFromMempool + stats.TxsRequested; total > 0 {
+		stats.HitRate = float64(stats.TxsFromMempool) / float64(total)
+	}
+
+	return stats
+}
+
+// partialBlock is a compact block being rebuilt, waiting for the
+// transactions asked for with getblocktxn
+type partialBlock struct {
+	block    *core.Block
+	missing  []int    // the indexes of the transactions asked for
+	shortIDs []uint64 // theirs, the transactions received are checked against
+	nonce    uint64
+	found    int // transactions found in the mempool
+}
+
+// compactRelay tells whether the block is announced to the peer compactly:
+// it speaks compactVersion and takes compact blocks, see ServiceCompact, and
+// the block is fresh
+func (p *Peer) compactRelay(block *core.Block) bool {
+	p.lock.RLock()
+	compact := p.services&ServiceCompact != 0 && p.version >= compactVersion
+	p.lock.RUnlock()
+	if !compact || block.Timestamp == nil {
+		return false
+	}
+
+	return time.Since(time.Unix(block.Timestamp.Int64(), 0)) < compactRelayAge
+}
+
+// announceBlock announces a block to the peer, compactly when it takes
+// compact blocks and the block is fresh, with an inv otherwise. A peer older
+// than invVersion is sent the block whole
+func (p *Peer) announceBlock(block *core.Block) error {
+	if p.protocolVersion() < invVersion {
+		return p.SendNewBlock(block, block.Height)
+	}
+	if !p.compactRelay(block) {
+		return p.SendNewBlockHashes([][]byte{block.Hash})
+	}
+	p.MarkBlock(block.Hash)
+
+	return sendCompactBlock(p, block)
+}
+
+// sendCompactBlock sends the header of a block and the short IDs of its
+// transactions, the coinbase prefilled
+func sendCompactBlock(p *Peer, block *core.Block) error {
+	payload := cmpctBlock{AddrFrom: nodeAddress, Header: block.BlockHeader, Nonce: rand.Uint64()}
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			payload.Prefilled = append(payload.Prefilled, prefilledTx{i, tx.Serialize()})
+			continue
+		}
+		payload.ShortIDs = append(payload.ShortIDs, shortID(block.Hash, payload.Nonce, tx.ID))
+	}
+	atomic.AddUint64(&compactStats.Sent, 1)
+
+	return sendDataC(p.Rw, Command{"cmpctblock", gobEncode(payload)})
+}
+
+// handleCmpctBlock rebuilds a compact block from the mempool. The
+// transactions not found are asked for with getblocktxn, the block in full
+// when it can't be rebuilt. Blocks the node has or asked for already are
+// ignored, like their inv would be
+func handleCmpctBlock(p *Peer, command Command, bc *core.Blockchain) {
+	var payload cmpctBlock
+	if err := gobDecode(command.Data, &payload); err != nil {
+		malformed(p, command, err)
+		return
+	}
+	atomic.AddUint64(&compactStats.Received, 1)
+	hash := payload.Header.Hash()
+	p.MarkBlock(hash)
+	if haveBlock(bc, hash) || (Manager.download != nil && Manager.download.Has(hash)) {
+		atomic.AddUint64(&inventoryStats.GetDataSkipped, 1)
+		return
+	}
+	if !seenBlocks.announce(hash, p) {
+		return
+	}
+
+	// the transactions go at their indexes, the prefilled ones first
+	count := len(payload.ShortIDs) + len(payload.Prefilled)
+	txs := make([]*core.Transaction, count)
+	for _, prefilled := range payload.Prefilled {
+		if prefilled.Index < 0 || prefilled.Index >= count || txs[prefilled.Index] != nil {
+			seenBlocks.processed(hash, blockUnseen)
+			malformed(p, command, errCompactBlock)
+			return
+		}
+		tx, err := core.DecodeTransaction(prefilled.Tx)
+		if err != nil {
+			seenBlocks.processed(hash, blockUnseen)
+			malformed(p, command, err)
+			return
+		}
+		txs[prefilled.Index] = &tx
+	}
+
+	// the mempool transactions by short ID, those colliding are asked for
+	byShortID := make(map[uint64]*core.Transaction)
+	for _, tx := range Manager.TxMempool.Transactions() {
+		id := shortID(hash, payload.Nonce, tx.ID)
+		if _, ok := byShortID[id]; ok {
+			byShortID[id] = nil
+			continue
+		}
+		byShortID[id] = tx
+	}
+	partial := &partialBlock{
+		block: &core.Block{BlockHeader: payload.Header, Transactions: txs, Hash: hash, ReceivedAt: time.Now()},
+		nonce: payload.Nonce,
+	}
+	next := 0
+	for i := range txs {
+		if txs[i] != nil {
+			continue
+		}
+		id := payload.ShortIDs[next]
+		next++
+		if tx := byShortID[id]; tx != nil {
+			txs[i] = tx
+			partial.found++
+			continue
+		}
+		partial.missing = append(partial.missing, i)
+		partial.shortIDs = append(partial.shortIDs, id)
+	}
+	atomic.AddUint64(&compactStats.TxsFromMempool, uint64(partial.found))
+
+	if len(partial.missing) == 0 {
+		completeCompactBlock(p, partial, bc)
+		return
+	}
+	atomic.AddUint64(&compactStats.TxsRequested, uint64(len(partial.missing)))
+	p.lock.Lock()
+	p.compactBlock = partial
+	p.blockRequested = time.Now()
+	p.lock.Unlock()
+	request := getBlockTxn{AddrFrom: nodeAddress, Hash: hash, Indexes: partial.missing}
+	sendDataC(p.Rw, Command{"getblocktxn", gobEncode(request)})
+}
+
+// handleGetBlockTxn sends the transactions of a block the peer asked for,
+// the whole block when an index is out of range
+func handleGetBlockTxn(p *Peer, command Command, bc *core.Blockchain) {
+	var payload getBlockTxn
+	if err := gobDecode(command.Data, &payload); err != nil {
+		malformed(p, command, err)
+		return
+	}
+	block, err := bc.GetBlock(payload.Hash)
+	if err != nil {
+		log.Printf("peer %s asked for transactions of block %x: %v", p.id, payload.Hash, err)
+		return
+	}
+	response := blockTxn{AddrFrom: nodeAddress, Hash: block.Hash}
+	for _, i := range payload.Indexes {
+		if i < 0 || i >= len(block.Transactions) {
+			sendBlock(p, &block)
+			return
+		}
+		response.Txs = append(response.Txs, block.Transactions[i].Serialize())
+	}
+
+	sendDataC(p.Rw, Command{"blocktxn", gobEncode(response)})
+}
+
+// handleBlockTxn completes the compact block waiting for the transactions
+// received. Those not matching the short IDs asked for get the block asked
+// for in full
+func handleBlockTxn(p *Peer, command Command, bc *core.Blockchain) {
+	var payload blockTxn
+	if err := gobDecode(command.Data, &payload); err != nil {
+		malformed(p, command, err)
+		return
+	}
+	p.lock.Lock()
+	partial := p.compactBlock
+	if partial == nil || !bytes.Equal(partial.block.Hash, payload.Hash) {
+		p.lock.Unlock()
+		log.Printf("peer %s sent transactions of block %x not asked for", p.id, payload.Hash)
+		return
+	}
+	p.compactBlock = nil
+	p.lock.Unlock()
+
+	if len(payload.Txs) != len(partial.missing) {
+		compactFallback(p, partial, fmt.Sprintf("%d transactions sent for %d asked", len(payload.Txs), len(partial.missing)))
+		return
+	}
+	for j, data := range payload.Txs {
+		tx, err := core.DecodeTransaction(data)
+		if err != nil {
+			malformed(p, command, err)
+			compactFallback(p, partial, err.Error())
+			return
+		}
+		if shortID(partial.block.Hash, partial.nonce, tx.ID) != partial.shortIDs[j] {
+			compactFallback(p, partial, fmt.Sprintf("transaction %x isn't the one asked for", tx.ID))
+			return
+		}
+		partial.block.Transactions[partial.missing[j]] = &tx
+	}
+
+	completeCompactBlock(p, partial, bc)
+}
+
+// completeCompactBlock processes a compact block rebuilt, whose
+// transactions match its merkle root. A short ID colliding with another
+// transaction of the mempool gets the block asked for in full
+func completeCompactBlock(p *Peer, partial *partialBlock, bc *core.Blockchain) {
+	block := partial.block
+	if !bytes.Equal(block.HashTransactions(), block.MerkleRoot) {
+		compactFallback(p, partial, "the transactions don't match the merkle root")
+		return
+	}
+	atomic.AddUint64(&compactStats.Reconstructed, 1)
+	shorts := partial.found + len(partial.missing)
+	rate := 100.0
+	if shorts > 0 {
+		rate = 100 * float64(partial.found) / float64(shorts)
+	}
+	log.Printf("compact block %x from peer %s rebuilt: %d of %d transactions from the mempool (%.0f%%), %d asked for",
+		block.Hash, p.id, partial.found, shorts, rate, len(partial.missing))
+
+	receiveBlock(p, block, bc)
+}
+
+// compactFallback asks the peer for the block it failed to rebuild in full
+func compactFallback(p *Peer, partial *partialBlock, reason string) {
+	log.Printf("compact block %x from peer %s not rebuilt, asking for it in full: %s", partial.block.Hash, p.id, reason)
+	atomic.AddUint64(&compactStats.FullFallbacks, 1)
+	requestBlock(p, partial.block.Hash)
+}
@@ -0,0 +1,160 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectCommand returns the next command received named name, those before
+// it are skipped
+func expectCommand(t *testing.T, received <-chan Command, name string) Command {
+	for {
+		select {
+		case command := <-received:
+			if command.Command == name {
+				return command
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("No %s received", name)
+		}
+	}
+}
+
+func TestCompactBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager, c *blockCache) { Manager, seenBlocks = m, c }(Manager, seenBlocks)
+	seenBlocks = newBlockCache(maxSeenBlocks)
+
+	// six coinbase outputs to spend, in six pending transactions
+	wallet := core.NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	other := fmt.Sprintf("%s", core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "compacta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	UTXOSet.Reindex()
+	for i := 0; i < 5; i++ {
+		_, err := bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+		assert.Nil(t, err)
+	}
+	pending := core.NewMempool()
+	for i := 0; i < 6; i++ {
+		tx, err := core.NewUTXOTransaction(wallet, other, 1, &UTXOSet, pending.Reserved(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending.Add(tx)
+	}
+	txs := pending.Transactions()
+
+	// node b has a copy of the chain, node a mines two blocks of the
+	// transactions on it
+	bc.Close()
+	data, err := ioutil.ReadFile("blockchain_compacta.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("blockchain_compactb.db", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	bcA, err := core.NewBlockchain("compacta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bcA.Close()
+	bcB, err := core.NewBlockchain("compactb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bcB.Close()
+	first, err := bcA.MineBlock(append([]*core.Transaction{core.NewCoinbaseTX(address, "")}, txs[:3]...))
+	assert.Nil(t, err)
+	second, err := bcA.MineBlock(append([]*core.Transaction{core.NewCoinbaseTX(address, "")}, txs[3:]...))
+	assert.Nil(t, err)
+
+	quit := make(chan struct{})
+	defer close(quit)
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	a := testNode{pm: newTestManager(quit, txs...), received: readCommands(rwA)}
+	a.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rwA)
+	a.peer.services = ServiceFull | ServiceCompact
+	b := testNode{pm: newTestManager(quit, txs[0], txs[1], txs[3], txs[4]), received: readCommands(rwB)}
+	b.pm.Orphans = core.NewOrphanPool(core.DefaultMaxOrphans, core.DefaultOrphanAge)
+	b.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+	before := compactStats.snapshot()
+
+	// b rebuilds the first block from its mempool and the transaction it
+	// lacks
+	Manager = a.pm
+	assert.Nil(t, a.peer.announceBlock(first))
+	Manager = b.pm
+	HandleConnection(b.peer, expectCommand(t, b.received, "cmpctblock"), bcB)
+	request := expectCommand(t, a.received, "getblocktxn")
+	var asked getBlockTxn
+	assert.Nil(t, gobDecode(request.Data, &asked))
+	assert.Equal(t, []int{3}, asked.Indexes)
+	Manager = a.pm
+	HandleConnection(a.peer, request, bcA)
+	Manager = b.pm
+	HandleConnection(b.peer, expectCommand(t, b.received, "blocktxn"), bcB)
+	has, err := bcB.HasBlock(first.Hash)
+	assert.Nil(t, err)
+	assert.True(t, has)
+	stats := compactStats.snapshot()
+	assert.Equal(t, uint64(1), stats.Reconstructed-before.Reconstructed)
+	assert.Equal(t, uint64(2), stats.TxsFromMempool-before.TxsFromMempool)
+	assert.Equal(t, uint64(1), stats.TxsRequested-before.TxsRequested)
+
+	// the wrong transactions get the block asked for in full
+	Manager = a.pm
+	assert.Nil(t, a.peer.announceBlock(second))
+	Manager = b.pm
+	HandleConnection(b.peer, expectCommand(t, b.received, "cmpctblock"), bcB)
+	expectCommand(t, a.received, "getblocktxn")
+	wrong := blockTxn{Hash: second.Hash, Txs: [][]byte{txs[0].Serialize()}}
+	HandleConnection(b.peer, Command{"blocktxn", gobEncode(wrong)}, bcB)
+	getData := expectCommand(t, a.received, "getdata")
+	Manager = a.pm
+	HandleConnection(a.peer, getData, bcA)
+	Manager = b.pm
+	HandleConnection(b.peer, expectCommand(t, b.received, "block"), bcB)
+	has, err = bcB.HasBlock(second.Hash)
+	assert.Nil(t, err)
+	assert.True(t, has)
+	stats = compactStats.snapshot()
+	assert.Equal(t, uint64(2), stats.Sent-before.Sent)
+	assert.Equal(t, uint64(1), stats.FullFallbacks-before.FullFallbacks)
+	assert.Equal(t, uint64(1), stats.Reconstructed-before.Reconstructed)
+
+	// a peer not taking compact blocks, or a block not fresh, gets an inv
+	a.peer.services = ServiceFull
+	assert.Nil(t, a.peer.announceBlock(&core.Block{Hash: []byte{1}, BlockHeader: core.BlockHeader{Timestamp: big.NewInt(time.Now().Unix())}}))
+	a.peer.services = ServiceFull | ServiceCompact
+	assert.Nil(t, a.peer.announceBlock(&core.Block{Hash: []byte{2}, BlockHeader: core.BlockHeader{Timestamp: big.NewInt(1)}}))
+	for _, hash := range [][]byte{{1}, {2}} {
+		var payload inv
+		assert.Nil(t, gobDecode(expectCommand(t, b.received, "inv").Data, &payload))
+		assert.Equal(t, [][]byte{hash}, payload.Items)
+	}
+}
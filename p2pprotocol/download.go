@@ -0,0 +1,362 @@
+package p2pprotocol
+
+import (
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"../blockchain_go"
+)
+
+// DownloadStallTimeout is how long a peer has to send a block assigned to
+// it before its blocks are assigned to the other peers
+var DownloadStallTimeout = 10 * time.Second
+
+const (
+	maxBlocksInFlight = 16              // blocks assigned to one peer at a time
+	defaultBlockRange = 4               // blocks assigned to a peer whose throughput isn't known yet
+	maxReorderBlocks  = 64              // blocks assigned past the next to process, those received wait for it
+	blockRangeTime    = 2 * time.Second // a peer is assigned the blocks its throughput downloads in as long
+	downloadInterval  = time.Second     // how often the stalls are checked for
+)
+
+// downloadPeer is what a peer did of the blocks assigned to it
+type downloadPeer struct {
+	inFlight int
+	rate     float64   // blocks per second, a moving average, 0 while unknown
+	last     time.Time // when it last sent a block assigned, or was assigned some idle
+	stalled  bool      // it let a block stall, it isn't assigned any more
+	blocks   int       // blocks it sent
+}
+
+// rangeSize returns the number of blocks the peer is to have in flight
+func (dp *downloadPeer) rangeSize() int {
+	if dp.rate == 0 {
+		return defaultBlockRange
+	}
+	size := int(dp.rate * blockRangeTime.Seconds())
+	if size < 1 {
+		return 1
+	}
+	if size > maxBlocksInFlight {
+		return maxBlocksInFlight
+	}
+	return size
+}
+
+type downloadedBlock struct {
+	peer  *Peer
+	block *core.Block
+}
+
+// blockDownload downloads the bodies of a list of blocks from all the peers
+// at once: each is assigned disjoint ranges of it, larger the faster it
+// is, and its blocks are assigned to the others when it stalls or
+// disconnects. The blocks received are processed in height order, those
+// ahead of the next wait for it. Those assigned stay within
+// maxReorderBlocks of the next, which caps the blocks waiting
+type blockDownload struct {
+	mu      sync.Mutex
+	process sync.Mutex // held while the blocks are processed, one after the other
+
+	source    *Peer    // the peer that announced the blocks
+	base      int64    // the height of the chain below the blocks
+	wanted    [][]byte // in height order
+	index     map[string]int
+	next      int   // the first block never assigned
+	retry     []int // blocks to assign again, ascending
+	assigned  map[int]*Peer
+	received  map[int]downloadedBlock // ahead of delivered
+	delivered int                     // the next block to process
+	peers     map[*Peer]*downloadPeer
+
+	request func(p *Peer, hash []byte) // requestBlock, faked by the tests
+}
+
+func newBlockDownload() *blockDownload {
+	d := &blockDownload{request: requestBlock}
+	d.reset()
+
+	return d
+}
+
+// reset forgets the download. The lock is held
+func (d *blockDownload) reset() {
+	d.source = nil
+	d.wanted = nil
+	d.index = make(map[string]int)
+	d.next, d.delivered = 0, 0
+	d.retry = nil
+	d.assigned = make(map[int]*Peer)
+	d.received = make(map[int]downloadedBlock)
+	d.peers = make(map[*Peer]*downloadPeer)
+}
+
+// start adds the blocks from announced, in height order above base, to the
+// download. Those wanted already aren't added again
+func (d *blockDownload) start(from *Peer, base int64, wanted [][]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.source == nil {
+		d.source = from
+		d.base = base
+	}
+	for _, hash := range wanted {
+		key := hex.EncodeToString(hash)
+		if _, ok := d.index[key]; !ok {
+			d.index[key] = len(d.wanted)
+			d.wanted = append(d.wanted, hash)
+		}
+	}
+}
+
+// Has tells whether the block of hash is downloading
+func (d *blockDownload) Has(hash []byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.index[hex.EncodeToString(hash)]
+	return ok && i >= d.delivered
+}
+
+// peer returns the download state of p. The lock is held
+func (d *blockDownload) peer(p *Peer) *downloadPeer {
+	dp, ok := d.peers[p]
+	if !ok {
+		dp = &downloadPeer{}
+		d.peers[p] = dp
+	}
+	return dp
+}
+
+// serves tells whether p can serve block i, as far as its best height
+// tells
+func (d *blockDownload) serves(p *Peer, i int) bool {
+	if p == d.source {
+		return true
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.Td != nil && p.Td.Int64() >= d.base+int64(i)+1
+}
+
+// take returns the next block to assign to p, those to assign again
+// first. The lock is held
+func (d *blockDownload) take(p *Peer) (int, bool) {
+	for j, i := range d.retry {
+		if d.serves(p, i) {
+			d.retry = append(d.retry[:j], d.retry[j+1:]...)
+			return i, true
+		}
+	}
+	if d.next < len(d.wanted) && d.next < d.delivered+maxReorderBlocks && d.serves(p, d.next) {
+		d.next++
+		return d.next - 1, true
+	}
+
+	return 0, false
+}
+
+// schedule assigns the blocks not in flight to peers, the fastest first, up
+// to the range each is to have in flight, and asks them for the blocks
+func (d *blockDownload) schedule(peers []*Peer) {
+	type request struct {
+		peer *Peer
+		hash []byte
+	}
+	var requests []request
+
+	d.mu.Lock()
+	now := time.Now()
+	// peers all stalled are given another chance
+	stalled := 0
+	for _, p := range peers {
+		if d.peer(p).stalled {
+			stalled++
+		}
+	}
+	if stalled == len(peers) {
+		for _, p := range peers {
+			d.peer(p).stalled = false
+		}
+	}
+	peers = append([]*Peer(nil), peers...)
+	sort.SliceStable(peers, func(i, j int) bool { return d.peer(peers[i]).rate > d.peer(peers[j]).rate })
+	for _, p := range peers {
+		dp := d.peer(p)
+		if dp.stalled {
+			continue
+		}
+		for dp.inFlight < dp.rangeSize() {
+			i, ok := d.take(p)
+			if !ok {
+				break
+			}
+			if dp.inFlight == 0 {
+				dp.last = now
+			}
+			dp.inFlight++
+			d.assigned[i] = p
+			requests = append(requests, request{p, d.wanted[i]})
+		}
+	}
+	d.mu.Unlock()
+
+	for _, r := range requests {
+		d.request(r.peer, r.hash)
+	}
+}
+
+// unassign takes the blocks assigned to p back to assign them again. The
+// lock is held
+func (d *blockDownload) unassign(p *Peer) {
+	for i, peer := range d.assigned {
+		if peer == p {
+			delete(d.assigned, i)
+			d.retry = append(d.retry, i)
+		}
+	}
+	sort.Ints(d.retry)
+	if dp, ok := d.peers[p]; ok {
+		dp.inFlight = 0
+	}
+}
+
+// checkStalls takes their blocks back from the peers with blocks in flight
+// that didn't send one within DownloadStallTimeout, they aren't assigned
+// any more. It returns whether there are blocks to assign again
+func (d *blockDownload) checkStalls(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for p, dp := range d.peers {
+		if dp.inFlight > 0 && !dp.stalled && now.Sub(dp.last) > DownloadStallTimeout {
+			log.Printf("peer %s stalled the block download, its blocks are assigned to the others", p.id)
+			dp.stalled = true
+			d.unassign(p)
+		}
+	}
+
+	return len(d.retry) > 0
+}
+
+// peerGone takes the blocks assigned to p, disconnected, back
+func (d *blockDownload) peerGone(p *Peer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.unassign(p)
+	delete(d.peers, p)
+	if p == d.source {
+		d.source = nil
+	}
+}
+
+// deliver adds a block p sent, and returns whether it belongs to the
+// download. The blocks ready are processed in height order with process,
+// whose false, a block not connected, ends the download
+func (d *blockDownload) deliver(p *Peer, block *core.Block, process func(*Peer, *core.Block) bool) bool {
+	d.mu.Lock()
+	i, ok := d.index[hex.EncodeToString(block.Hash)]
+	if !ok {
+		d.mu.Unlock()
+		return false
+	}
+	if _, received := d.received[i]; received || i < d.delivered {
+		d.mu.Unlock()
+		return true
+	}
+	// a block of a peer deemed stalled is taken still
+	if peer, ok := d.assigned[i]; ok {
+		delete(d.assigned, i)
+		dp := d.peer(peer)
+		if dp.inFlight > 0 {
+			dp.inFlight--
+		}
+		if peer == p {
+			dp.sent(time.Now())
+		}
+	}
+	for j, r := range d.retry {
+		if r == i {
+			d.retry = append(d.retry[:j], d.retry[j+1:]...)
+			break
+		}
+	}
+	d.received[i] = downloadedBlock{p, block}
+	d.mu.Unlock()
+
+	d.process.Lock()
+	defer d.process.Unlock()
+	for {
+		d.mu.Lock()
+		ready, ok := d.received[d.delivered]
+		if ok {
+			delete(d.received, d.delivered)
+			d.delivered++
+		}
+		d.mu.Unlock()
+		if !ok {
+			return true
+		}
+		if !process(ready.peer, ready.block) {
+			log.Printf("block %x of the download not connected, the download ends", ready.block.Hash)
+			d.mu.Lock()
+			d.reset()
+			d.mu.Unlock()
+			return true
+		}
+	}
+}
+
+// sent updates the throughput of the peer for a block it sent at now
+func (dp *downloadPeer) sent(now time.Time) {
+	interval := now.Sub(dp.last)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	rate := 1 / interval.Seconds()
+	if dp.rate == 0 {
+		dp.rate = rate
+	} else {
+		dp.rate = 0.7*dp.rate + 0.3*rate
+	}
+	dp.last = now
+	dp.blocks++
+}
+
+// complete returns whether the blocks are all processed, and the peer that
+// announced them, nil when it's gone. The download is forgotten then
+func (d *blockDownload) complete() (*Peer, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.wanted) == 0 || d.delivered < len(d.wanted) {
+		return nil, false
+	}
+	source := d.source
+	d.reset()
+
+	return source, true
+}
+
+// downloadLoop assigns the blocks of the peers stalling the download to
+// the others every downloadInterval, until quit is closed
+func (pm *ProtocolManager) downloadLoop(quit <-chan struct{}) {
+	ticker := time.NewTicker(downloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if pm.download.checkStalls(now) {
+				pm.download.schedule(pm.Peers.List())
+			}
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package p2pprotocol
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockDownload(t *testing.T) {
+	defer func(timeout time.Duration) { DownloadStallTimeout = timeout }(DownloadStallTimeout)
+	DownloadStallTimeout = 50 * time.Millisecond
+	const count = 200
+	var wanted [][]byte
+	blocks := make(map[string]*core.Block)
+	for i := 0; i < count; i++ {
+		block := &core.Block{Hash: []byte{byte(i >> 8), byte(i)}}
+		wanted = append(wanted, block.Hash)
+		blocks[string(block.Hash)] = block
+	}
+
+	// three peers of different speeds, and one stalling after a few blocks
+	type simulated struct {
+		peer     *Peer
+		delay    time.Duration
+		serves   int
+		requests chan []byte
+	}
+	var peers []*Peer
+	var simulations []*simulated
+	for _, s := range []struct {
+		id     string
+		delay  time.Duration
+		serves int
+	}{{"fast", time.Millisecond, count}, {"medium", 3 * time.Millisecond, count}, {"slow", 8 * time.Millisecond, count}, {"stalling", time.Millisecond, 3}} {
+		p := &Peer{id: s.id, Td: big.NewInt(count)}
+		peers = append(peers, p)
+		simulations = append(simulations, &simulated{p, s.delay, s.serves, make(chan []byte, count)})
+	}
+	d := newBlockDownload()
+	d.request = func(p *Peer, hash []byte) {
+		for _, s := range simulations {
+			if s.peer == p {
+				s.requests <- hash
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	var processed [][]byte
+	sent := make(map[string]int)
+	maxWaiting := 0
+	done := make(chan *Peer, 1)
+	process := func(p *Peer, block *core.Block) bool {
+		processed = append(processed, block.Hash)
+		return true
+	}
+	for _, s := range simulations {
+		go func(s *simulated) {
+			for hash := range s.requests {
+				if s.serves == 0 {
+					continue
+				}
+				s.serves--
+				time.Sleep(s.delay)
+				mu.Lock()
+				sent[s.peer.id]++
+				mu.Unlock()
+				assert.True(t, d.deliver(s.peer, blocks[string(hash)], process))
+				d.mu.Lock()
+				if len(d.received) > maxWaiting {
+					maxWaiting = len(d.received)
+				}
+				d.mu.Unlock()
+				if source, ok := d.complete(); ok {
+					done <- source
+					return
+				}
+				d.schedule(peers)
+			}
+		}(s)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			select {
+			case <-time.After(10 * time.Millisecond):
+				if d.checkStalls(time.Now()) {
+					d.schedule(peers)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	d.start(peers[2], 0, wanted)
+	d.schedule(peers)
+	select {
+	case source := <-done:
+		assert.Equal(t, peers[2], source)
+	case <-time.After(10 * time.Second):
+		t.Fatal("The download didn't complete")
+	}
+
+	// every block is processed once, in height order, the blocks waiting
+	// for those below them capped
+	assert.Equal(t, wanted, processed)
+	assert.True(t, maxWaiting <= maxReorderBlocks)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, sent["stalling"])
+	assert.True(t, sent["fast"] > sent["medium"], "%v", sent)
+	assert.True(t, sent["medium"] > sent["slow"], "%v", sent)
+	assert.False(t, d.Has(wanted[0]))
+}
+
+func TestBlockDownloadPeerGone(t *testing.T) {
+	d := newBlockDownload()
+	var requests []string
+	d.request = func(p *Peer, hash []byte) { requests = append(requests, p.id) }
+	a := &Peer{id: "a", Td: big.NewInt(10)}
+	b := &Peer{id: "b", Td: big.NewInt(3)}
+	var wanted [][]byte
+	for i := 0; i < 6; i++ {
+		wanted = append(wanted, []byte{byte(i)})
+	}
+
+	// a peer is assigned the blocks its height tells it has only
+	d.start(a, 0, wanted)
+	d.schedule([]*Peer{b, a})
+	assert.Equal(t, []string{"b", "b", "b", "a", "a", "a"}, requests)
+
+	// those of a peer gone go to the others
+	requests = nil
+	d.peerGone(b)
+	d.schedule([]*Peer{a})
+	assert.Equal(t, []string{"a"}, requests)
+	d.mu.Lock()
+	assert.Equal(t, []int{1, 2}, d.retry)
+	d.mu.Unlock()
+
+	// a block not part of the download is left to the caller, one received
+	// twice is processed once
+	assert.False(t, d.deliver(a, &core.Block{Hash: []byte{9}}, nil))
+	processed := 0
+	process := func(*Peer, *core.Block) bool { processed++; return true }
+	assert.True(t, d.deliver(a, &core.Block{Hash: []byte{1}}, process))
+	assert.True(t, d.deliver(a, &core.Block{Hash: []byte{1}}, process))
+	assert.Equal(t, 0, processed)
+	assert.True(t, d.deliver(a, &core.Block{Hash: []byte{0}}, process))
+	assert.Equal(t, 2, processed)
+
+	// a block not connected ends the download
+	assert.True(t, d.deliver(a, &core.Block{Hash: []byte{2}}, func(*Peer, *core.Block) bool { return false }))
+	assert.False(t, d.Has(wanted[3]))
+	_, done := d.complete()
+	assert.False(t, done)
+}
@@ -0,0 +1,209 @@
+package p2pprotocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"../blockchain_go"
+	"../p2p"
+)
+
+// A frame is the network magic, the command zero padded to commandLength
+// bytes, the length of the payload and its checksum, the first bytes of
+// its double SHA-256, then the payload, the numbers little endian
+const (
+	frameHeaderSize = 4 + commandLength + 4 + 4
+
+	maxBlockPayload = 4 << 20   // the payload of a block command
+	maxTxPayload    = 100 << 10 // the payload of a tx command
+	maxPayload      = 1 << 20   // the payload of any other command
+)
+
+// maxChecksumErrors is the number of frames failing their checksum a peer
+// is disconnected at, its link corrupts what it sends
+const maxChecksumErrors = 5
+
+// maxPayloads are the payload limits of the commands with their own
+var maxPayloads = map[string]uint32{
+	"block":    maxBlockPayload,
+	"blocktxn": maxBlockPayload,
+	"tx":       maxTxPayload,
+}
+
+var (
+	// ErrBadMagic is returned for a frame of another network, the peer
+	// is disconnected
+	ErrBadMagic = errors.New("frame of another network")
+	// ErrBadChecksum is returned for a frame whose payload doesn't match
+	// its checksum
+	ErrBadChecksum = errors.New("frame checksum mismatch")
+	// ErrFrameTooBig is returned for a frame whose payload exceeds the
+	// limit of its command
+	ErrFrameTooBig = errors.New("frame payload too big")
+	// ErrTruncatedFrame is returned for a frame shorter than its header
+	// tells
+	ErrTruncatedFrame = errors.New("truncated frame")
+	// ErrMalformedFrame is returned for a message that isn't a frame, or
+	// longer than its header tells
+	ErrMalformedFrame = errors.New("malformed frame")
+
+	errChecksumErrors = errors.New("too many frames failing their checksum")
+)
+
+// checksumFailures counts the frames of the peers failing their checksum,
+// updated atomically
+var checksumFailures uint64
+
+// isFrameError returns whether err is about the frame just read only, the
+// next one can be read
+func isFrameError(err error) bool {
+	return err == ErrBadChecksum || err == ErrFrameTooBig || err == ErrTruncatedFrame || err == ErrMalformedFrame
+}
+
+// maxPayloadSize returns the payload limit of command
+func maxPayloadSize(command string) uint32 {
+	if max, ok := maxPayloads[command]; ok {
+		return max
+	}
+
+	return maxPayload
+}
+
+// Framer writes the commands of the protocol as frames, one per p2p
+// message, and reads them back, checking them against the network magic,
+// the limit of the command and the checksum. The commands are sent and
+// received through it only
+type Framer struct {
+	Magic uint32
+}
+
+// framer returns the framer of the active network
+func framer() Framer {
+	return Framer{Magic: core.ActiveNetParams.Genesis.Magic}
+}
+
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
+
+// Encode returns the frame of command
+func (f Framer) Encode(command Command) ([]byte, error) {
+	if len(command.Command) == 0 || len(command.Command) > commandLength {
+		return nil, fmt.Errorf("command %q isn't 1 to %d bytes", command.Command, commandLength)
+	}
+	if uint64(len(command.Data)) > uint64(maxPayloadSize(command.Command)) {
+		return nil, fmt.Errorf("%s command of %d bytes: %v", command.Command, len(command.Data), ErrFrameTooBig)
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(command.Data))
+	binary.LittleEndian.PutUint32(frame[0:4], f.Magic)
+	copy(frame[4:4+commandLength], command.Command)
+	binary.LittleEndian.PutUint32(frame[4+commandLength:8+commandLength], uint32(len(command.Data)))
+	copy(frame[8+commandLength:frameHeaderSize], checksum(command.Data))
+
+	return append(frame, command.Data...), nil
+}
+
+// Decode returns the command of a frame. The header is checked before the
+// payload is looked at
+func (f Framer) Decode(frame []byte) (Command, error) {
+	if len(frame) < frameHeaderSize {
+		return Command{}, ErrTruncatedFrame
+	}
+	if binary.LittleEndian.Uint32(frame[0:4]) != f.Magic {
+		return Command{}, ErrBadMagic
+	}
+	name := frame[4 : 4+commandLength]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	command := Command{Command: string(name)}
+	length := binary.LittleEndian.Uint32(frame[4+commandLength : 8+commandLength])
+	if length > maxPayloadSize(command.Command) {
+		return command, ErrFrameTooBig
+	}
+	payload := frame[frameHeaderSize:]
+	if uint64(len(payload)) < uint64(length) {
+		return command, ErrTruncatedFrame
+	}
+	if uint64(len(payload)) > uint64(length) {
+		return command, ErrMalformedFrame
+	}
+	if !bytes.Equal(checksum(payload), frame[8+commandLength:frameHeaderSize]) {
+		return command, ErrBadChecksum
+	}
+	if len(payload) > 0 {
+		command.Data = payload
+	}
+
+	return command, nil
+}
+
+// WriteCommand sends command to w in a frame
+func (f Framer) WriteCommand(w p2p.MsgWriter, command Command) error {
+	frame, err := f.Encode(command)
+	if err != nil {
+		return err
+	}
+
+	return p2p.Send(w, StatusMsg, frame)
+}
+
+// ReadCommand reads the next command from r. A message too big for any
+// frame is discarded unread. After an error isFrameError tells about the
+// next frame can be read, ErrBadMagic and the errors reading r mean the
+// peer is to be disconnected
+func (f Framer) ReadCommand(r p2p.MsgReader) (Command, error) {
+	msg, err := r.ReadMsg()
+	if err != nil {
+		return Command{}, err
+	}
+	// a few bytes for the encoding of the message
+	if msg.Size > frameHeaderSize+maxBlockPayload+16 {
+		msg.Discard()
+		return Command{}, ErrFrameTooBig
+	}
+	var frame []byte
+	if err := msg.Decode(&frame); err != nil {
+		return Command{}, ErrMalformedFrame
+	}
+
+	return f.Decode(frame)
+}
+
+// readCommand reads the next command of the peer with f, the frames in error
+// skipped. The payload of a frame is checked against its checksum before
+// anything decodes it: one failing it is discarded and counted, the peer
+// disconnected at maxChecksumErrors, a corrupted frame being no misbehaviour.
+// The other frames in error score the peer
+func (p *Peer) readCommand(f Framer) (Command, error) {
+	for {
+		command, err := f.ReadCommand(p.Rw)
+		switch {
+		case err == ErrBadChecksum:
+			atomic.AddUint64(&checksumFailures, 1)
+			p.lock.Lock()
+			p.checksumErrors++
+			failures := p.checksumErrors
+			p.lock.Unlock()
+			log.Printf("peer %s sent a %s frame failing its checksum, %d so far", p.id, command.Command, failures)
+			if failures >= maxChecksumErrors {
+				return command, errChecksumErrors
+			}
+		case err == ErrFrameTooBig:
+			p.Misbehaving(scoreTooBig, fmt.Sprintf("%s frame: %v", command.Command, err))
+		case isFrameError(err):
+			p.Misbehaving(scoreMalformed, fmt.Sprintf("%s frame: %v", command.Command, err))
+		default:
+			return command, err
+		}
+	}
+}
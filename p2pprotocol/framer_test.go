@@ -0,0 +1,133 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFramer(t *testing.T) {
+	f := Framer{Magic: 0xd9b4bef9}
+	frame, err := f.Encode(Command{"version", []byte("payload")})
+	assert.Nil(t, err)
+	assert.Equal(t, frameHeaderSize+len("payload"), len(frame))
+	command, err := f.Decode(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, Command{"version", []byte("payload")}, command)
+	empty, err := f.Encode(Command{"verack", nil})
+	assert.Nil(t, err)
+	command, err = f.Decode(empty)
+	assert.Nil(t, err)
+	assert.Equal(t, Command{"verack", nil}, command)
+
+	// the command has to fit its field
+	_, err = f.Encode(Command{"", nil})
+	assert.NotNil(t, err)
+	_, err = f.Encode(Command{"thirteenbytes", nil})
+	assert.NotNil(t, err)
+
+	// truncated frames
+	_, err = f.Decode(frame[:frameHeaderSize-1])
+	assert.Equal(t, ErrTruncatedFrame, err)
+	_, err = f.Decode(frame[:len(frame)-1])
+	assert.Equal(t, ErrTruncatedFrame, err)
+	_, err = f.Decode(append(append([]byte(nil), frame...), 0))
+	assert.Equal(t, ErrMalformedFrame, err)
+
+	// oversized frames, per command
+	_, err = f.Encode(Command{"tx", make([]byte, maxTxPayload+1)})
+	assert.NotNil(t, err)
+	_, err = f.Encode(Command{"block", make([]byte, maxTxPayload+1)})
+	assert.Nil(t, err)
+	_, err = f.Encode(Command{"block", make([]byte, maxBlockPayload+1)})
+	assert.NotNil(t, err)
+	tx, err := f.Encode(Command{"tx", []byte{1}})
+	assert.Nil(t, err)
+	tx[4+commandLength+2] = 2 // a length of 128KB
+	_, err = f.Decode(tx)
+	assert.Equal(t, ErrFrameTooBig, err)
+
+	// corrupted frames
+	corrupted := append([]byte(nil), frame...)
+	corrupted[len(corrupted)-1] ^= 1
+	_, err = f.Decode(corrupted)
+	assert.Equal(t, ErrBadChecksum, err)
+	_, err = Framer{Magic: 0x0709110b}.Decode(frame)
+	assert.Equal(t, ErrBadMagic, err)
+	assert.False(t, isFrameError(ErrBadMagic))
+}
+
+func TestReadCommand(t *testing.T) {
+	f := framer()
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	good, err := f.Encode(Command{"getaddr", nil})
+	assert.Nil(t, err)
+	corrupted := append([]byte(nil), good...)
+	corrupted[4+commandLength+4] ^= 1
+	go func() {
+		p2p.Send(rwA, StatusMsg, corrupted)
+		p2p.Send(rwA, StatusMsg, make([]byte, 2*maxBlockPayload))
+		p2p.Send(rwA, StatusMsg, "short")
+		p2p.Send(rwA, StatusMsg, []string{"not", "a", "frame"})
+		f.WriteCommand(rwA, Command{"ping", []byte{1}})
+		Framer{Magic: f.Magic + 1}.WriteCommand(rwA, Command{"ping", nil})
+	}()
+
+	// the frames after a corrupted one are read still
+	_, err = f.ReadCommand(rwB)
+	assert.Equal(t, ErrBadChecksum, err)
+	_, err = f.ReadCommand(rwB)
+	assert.Equal(t, ErrFrameTooBig, err)
+	_, err = f.ReadCommand(rwB)
+	assert.Equal(t, ErrTruncatedFrame, err)
+	_, err = f.ReadCommand(rwB)
+	assert.Equal(t, ErrMalformedFrame, err)
+	command, err := f.ReadCommand(rwB)
+	assert.Nil(t, err)
+	assert.Equal(t, Command{"ping", []byte{1}}, command)
+	_, err = f.ReadCommand(rwB)
+	assert.Equal(t, ErrBadMagic, err)
+}
+
+func TestChecksumFailures(t *testing.T) {
+	f := framer()
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+	transaction := core.NewCoinbaseTX(fmt.Sprintf("%s", core.NewWallet().GetAddress()), "")
+	frame, err := f.Encode(Command{"tx", gobEncode(tx{AddFrom: "a", Transaction: transaction.Serialize()})})
+	assert.Nil(t, err)
+	corrupted := append([]byte(nil), frame...)
+	corrupted[len(corrupted)-8] ^= 1
+	before, received := atomic.LoadUint64(&checksumFailures), atomic.LoadUint64(&inventoryStats.TxsReceived)
+	go func() {
+		p2p.Send(rwA, StatusMsg, corrupted)
+		f.WriteCommand(rwA, Command{"ping", []byte{1}})
+		for i := 1; i < maxChecksumErrors; i++ {
+			p2p.Send(rwA, StatusMsg, corrupted)
+		}
+	}()
+
+	// the corrupted transaction is discarded before anything decodes it,
+	// the peer isn't scored for it
+	command, err := p.readCommand(f)
+	assert.Nil(t, err)
+	assert.Equal(t, Command{"ping", []byte{1}}, command)
+	assert.Equal(t, 1, p.info().ChecksumErrors)
+	assert.Equal(t, 0, p.info().BanScore)
+	assert.Equal(t, before+1, atomic.LoadUint64(&checksumFailures))
+	assert.Equal(t, before+1, Stats().ChecksumFailures)
+	assert.Equal(t, received, atomic.LoadUint64(&inventoryStats.TxsReceived))
+
+	// repeated failures disconnect the peer
+	_, err = p.readCommand(f)
+	assert.Equal(t, errChecksumErrors, err)
+	assert.Equal(t, maxChecksumErrors, p.info().ChecksumErrors)
+	assert.Equal(t, before+maxChecksumErrors, atomic.LoadUint64(&checksumFailures))
+}
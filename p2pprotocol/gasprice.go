@@ -0,0 +1,156 @@
+package p2pprotocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// GasPriceConfig configures the gas price oracle
+type GasPriceConfig struct {
+	Blocks     int      // the blocks with transactions sampled, the head first
+	Percentile int      // of the prices sampled, from 0 to 100
+	Default    *big.Int // suggested before any block is sampled, and the floor
+	Max        *big.Int // the ceiling
+}
+
+// DefaultGasPriceConfig samples the last 20 blocks with transactions
+var DefaultGasPriceConfig = GasPriceConfig{
+	Blocks:     20,
+	Percentile: 60,
+	Default:    big.NewInt(1e9),
+	Max:        big.NewInt(500e9),
+}
+
+// errNoChainHead is returned by SuggestPrice when the chain has no head yet
+var errNoChainHead = errors.New("the chain has no head block yet")
+
+// gasPriceChain is what the oracle reads of a chain
+type gasPriceChain interface {
+	// Head returns the number and the hash of the head block, nil before
+	// there is one
+	Head() (uint64, []byte)
+	// TxPrices returns the gas prices of the transactions of the block of
+	// number
+	TxPrices(ctx context.Context, number uint64) ([]*big.Int, error)
+}
+
+// gasPriceOracle suggests a gas price from the prices of the transactions
+// of the recent blocks, at a percentile, kept between a floor and a
+// ceiling. The suggestion is cached until the head changes
+type gasPriceOracle struct {
+	chain  gasPriceChain
+	config GasPriceConfig
+
+	mu        sync.RWMutex
+	lastHead  []byte
+	lastPrice *big.Int
+	fetch     sync.Mutex // held while the blocks are sampled, one suggestion at a time
+}
+
+// newGasPriceOracle returns the oracle of chain, the missing settings of
+// config taken from DefaultGasPriceConfig
+func newGasPriceOracle(chain gasPriceChain, config GasPriceConfig) *gasPriceOracle {
+	if config.Blocks < 1 {
+		config.Blocks = 1
+	}
+	if config.Percentile < 0 {
+		config.Percentile = 0
+	}
+	if config.Percentile > 100 {
+		config.Percentile = 100
+	}
+	if config.Default == nil {
+		config.Default = DefaultGasPriceConfig.Default
+	}
+	if config.Max == nil {
+		config.Max = DefaultGasPriceConfig.Max
+	}
+	if config.Max.Cmp(config.Default) < 0 {
+		config.Max = config.Default
+	}
+
+	return &gasPriceOracle{chain: chain, config: config, lastPrice: config.Default}
+}
+
+// cached returns the suggestion for the head of hash, nil when it's not
+// cached
+func (o *gasPriceOracle) cached(hash []byte) *big.Int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.lastHead != nil && bytes.Equal(o.lastHead, hash) {
+		return new(big.Int).Set(o.lastPrice)
+	}
+	return nil
+}
+
+// last returns the last suggestion
+func (o *gasPriceOracle) last() *big.Int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return new(big.Int).Set(o.lastPrice)
+}
+
+// SuggestPrice returns the gas price suggested at the head of the chain.
+// Up to config.Blocks blocks with transactions are sampled among five times
+// as many, the empty ones skipped. The last suggestion is returned with the
+// error of a block that couldn't be read
+func (o *gasPriceOracle) SuggestPrice(ctx context.Context) (*big.Int, error) {
+	number, hash := o.chain.Head()
+	if hash == nil {
+		return nil, errNoChainHead
+	}
+	if price := o.cached(hash); price != nil {
+		return price, nil
+	}
+	o.fetch.Lock()
+	defer o.fetch.Unlock()
+	// the head may have been sampled while the lock was waited for
+	if price := o.cached(hash); price != nil {
+		return price, nil
+	}
+
+	var prices []*big.Int
+	sampled := 0
+	for checked := 0; sampled < o.config.Blocks && checked < 5*o.config.Blocks; checked++ {
+		if err := ctx.Err(); err != nil {
+			return o.last(), err
+		}
+		blockPrices, err := o.chain.TxPrices(ctx, number)
+		if err != nil {
+			return o.last(), err
+		}
+		if len(blockPrices) > 0 {
+			prices = append(prices, blockPrices...)
+			sampled++
+		}
+		if number == 0 {
+			break
+		}
+		number--
+	}
+
+	price := o.config.Default
+	if len(prices) > 0 {
+		sort.Slice(prices, func(i, j int) bool { return prices[i].Cmp(prices[j]) < 0 })
+		price = prices[(len(prices)-1)*o.config.Percentile/100]
+	}
+	if price.Cmp(o.config.Default) < 0 {
+		price = o.config.Default
+	}
+	if price.Cmp(o.config.Max) > 0 {
+		price = o.config.Max
+	}
+
+	o.mu.Lock()
+	o.lastHead = hash
+	o.lastPrice = new(big.Int).Set(price)
+	o.mu.Unlock()
+
+	return new(big.Int).Set(price), nil
+}
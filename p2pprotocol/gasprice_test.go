@@ -0,0 +1,123 @@
+package p2pprotocol
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// priceChain is a synthetic chain, the prices of the transactions of each
+// block
+type priceChain struct {
+	mu     sync.Mutex
+	blocks [][]int64
+	reads  int
+	fail   bool
+}
+
+func (c *priceChain) Head() (uint64, []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.blocks) == 0 {
+		return 0, nil
+	}
+	return uint64(len(c.blocks) - 1), []byte{byte(len(c.blocks))}
+}
+
+func (c *priceChain) TxPrices(ctx context.Context, number uint64) ([]*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reads++
+	if c.fail {
+		return nil, errors.New("block not found")
+	}
+	var prices []*big.Int
+	for _, price := range c.blocks[number] {
+		prices = append(prices, big.NewInt(price))
+	}
+	return prices, nil
+}
+
+func (c *priceChain) add(prices ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks = append(c.blocks, prices)
+}
+
+func TestSuggestPrice(t *testing.T) {
+	ctx := context.Background()
+	chain := &priceChain{}
+	oracle := newGasPriceOracle(chain, GasPriceConfig{Blocks: 3, Percentile: 50, Default: big.NewInt(10), Max: big.NewInt(1000)})
+
+	_, err := oracle.SuggestPrice(ctx)
+	assert.Equal(t, errNoChainHead, err)
+
+	// the floor before any transaction
+	chain.add()
+	price, err := oracle.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(10), price)
+
+	// the median of the last three blocks with transactions, the empty ones
+	// skipped
+	chain.add(900, 900, 900)
+	chain.add(20, 30)
+	chain.add()
+	chain.add(40, 50, 60)
+	chain.add(70)
+	price, err = oracle.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(40), price)
+
+	// cached until the head changes
+	reads := chain.reads
+	price, err = oracle.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(40), price)
+	assert.Equal(t, reads, chain.reads)
+
+	// kept between the floor and the ceiling
+	chain.add(5000, 6000)
+	chain.add(5000)
+	chain.add(7000)
+	price, err = oracle.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(1000), price)
+	chain.add(1, 2)
+	chain.add(1)
+	chain.add(3)
+	price, err = oracle.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(10), price)
+
+	// at most five times as many blocks as sampled are read
+	for i := 0; i < 20; i++ {
+		chain.add()
+	}
+	reads = chain.reads
+	price, err = oracle.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(10), price)
+	assert.Equal(t, reads+15, chain.reads)
+
+	// a block that can't be read returns the last suggestion with the error
+	chain.add(500)
+	chain.fail = true
+	price, err = oracle.SuggestPrice(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, big.NewInt(10), price)
+	chain.fail = false
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = oracle.SuggestPrice(cancelled)
+	assert.Equal(t, context.Canceled, err)
+	price, err = oracle.SuggestPrice(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(500), price)
+}
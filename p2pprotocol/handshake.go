@@ -0,0 +1,225 @@
+package p2pprotocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+)
+
+// The protocol versions, each speaking the messages of the older ones. A
+// connection speaks the older version of both sides, see negotiateVersion,
+// the messages newer than it aren't sent to the peer. The messages of the
+// peer are handled whatever their version, a peer sending one speaks its
+// answer, and those the node doesn't know are ignored
+const (
+	// handshakeVersion sends version and verack before anything else, the
+	// new blocks and transactions are pushed whole with block and tx, inv
+	// only answers getblocks
+	handshakeVersion = 2
+	// invVersion announces the new blocks and transactions with inv, sent
+	// when asked for with getdata, and speaks ping, mempool, addr and getaddr
+	invVersion = 3
+	// compactVersion relays the fresh blocks as cmpctblock to the peers with
+	// ServiceCompact
+	compactVersion = 4
+)
+
+// minProtocolVersion is the oldest protocol version a peer may speak
+const minProtocolVersion = handshakeVersion
+
+// messageVersions are the protocol versions the messages newer than
+// handshakeVersion need, see Peer.speaks
+var messageVersions = map[string]int{
+	"ping":        invVersion,
+	"pong":        invVersion,
+	"mempool":     invVersion,
+	"addr":        invVersion,
+	"getaddr":     invVersion,
+	"cmpctblock":  compactVersion,
+	"getblocktxn": compactVersion,
+	"blocktxn":    compactVersion,
+}
+
+// negotiateVersion returns the protocol version of a connection between
+// nodes of versions ours and theirs, the older
+func negotiateVersion(ours, theirs int) int {
+	if theirs < ours {
+		return theirs
+	}
+	return ours
+}
+
+// protocolVersion returns the protocol version of the connection to the
+// peer, negotiated in the handshake
+func (p *Peer) protocolVersion() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.version
+}
+
+// speaks returns whether the message command may be sent to the peer, at
+// the protocol version of the connection
+func (p *Peer) speaks(command string) bool {
+	return p.protocolVersion() >= messageVersions[command]
+}
+
+// handshakeTimeout is how long a peer has to complete the handshake
+const handshakeTimeout = 6 * time.Second
+
+// The services a node offers, sent as flags in its version message
+const (
+	ServiceFull    uint64 = 1 << iota // serves every block of its chain
+	ServiceMiner                      // mines blocks
+	ServicePruned                     // deleted the block bodies up to its PrunedHeight
+	ServiceCompact                    // takes fresh blocks as compact blocks, see cmpctBlock
+)
+
+// localServices returns the services of the node, pruned up to prunedHeight
+func localServices(prunedHeight int64) uint64 {
+	services := ServiceFull
+	if prunedHeight > 0 {
+		services = ServicePruned
+	}
+	if miningAddress != "" {
+		services |= ServiceMiner
+	}
+	services |= ServiceCompact
+
+	return services
+}
+
+// nonceSet holds the nonces of the version messages of the handshakes in
+// flight, a peer sending one back is the node itself
+type nonceSet struct {
+	sync.Mutex
+	nonces map[uint64]bool
+}
+
+// localNonces are the nonces of the handshakes of the node
+var localNonces = &nonceSet{nonces: make(map[uint64]bool)}
+
+func (s *nonceSet) add(nonce uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.nonces[nonce] = true
+}
+
+func (s *nonceSet) remove(nonce uint64) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.nonces, nonce)
+}
+
+func (s *nonceSet) has(nonce uint64) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.nonces[nonce]
+}
+
+// newVersion returns the version message of the node, from the chainstate
+// record of bc, with a new nonce
+func newVersion(bc *core.Blockchain) (verzion, error) {
+	info, err := bc.ChainInfo()
+	if err != nil {
+		return verzion{}, err
+	}
+
+	return verzion{
+		Version:      nodeVersion,
+		BestHeight:   big.NewInt(info.Height),
+		LastHash:     info.BestBlock,
+		AddrFrom:     nodeAddress,
+		Timestamp:    time.Now().Unix(),
+		Magic:        core.ActiveNetParams.Genesis.Magic,
+		GenesisHash:  bc.GenesisHash,
+		BlockVersion: core.MaxBlockVersion,
+		PrunedHeight: info.PruneHeight,
+		Services:     localServices(info.PruneHeight),
+		Nonce:        rand.Uint64(),
+	}, nil
+}
+
+// checkNetwork checks that a version comes from a node of the same network,
+// with the same magic and genesis block
+func checkNetwork(payload verzion, genesisHash []byte) error {
+	if payload.Magic != core.ActiveNetParams.Genesis.Magic {
+		return fmt.Errorf("network magic %08x instead of %08x", payload.Magic, core.ActiveNetParams.Genesis.Magic)
+	}
+	if !bytes.Equal(payload.GenesisHash, genesisHash) {
+		return fmt.Errorf("genesis block %x instead of %x", payload.GenesisHash, genesisHash)
+	}
+
+	return nil
+}
+
+// handshake exchanges the version messages with a peer, ours sent first on
+// both sides, then acknowledged with a verack once checked. It returns the
+// version of the peer once both are acknowledged. A peer of another network
+// or an older protocol version, the node itself, or a peer sending anything
+// else first is refused with the error as soon as its version is read, the
+// caller disconnects it. The sends don't wait for the peer to read them
+func handshake(rw p2p.MsgReadWriter, ours verzion, genesisHash []byte, nonces *nonceSet) (*verzion, error) {
+	nonces.add(ours.Nonce)
+	defer nonces.remove(ours.Nonce)
+
+	// one writer keeps the version ahead of the verack
+	sends := make(chan Command, 2)
+	errc := make(chan error, 2)
+	defer close(sends)
+	go func() {
+		for command := range sends {
+			errc <- sendDataC(rw, command)
+		}
+	}()
+	sends <- Command{"version", gobEncode(ours)}
+
+	var theirs *verzion
+	acked := false
+	for theirs == nil || !acked {
+		command, err := framer().ReadCommand(rw)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case command.Command == "version" && theirs == nil:
+			var payload verzion
+			if err := gobDecode(command.Data, &payload); err != nil {
+				return nil, err
+			}
+			if payload.Version < minProtocolVersion {
+				return nil, fmt.Errorf("protocol version %d, %d at least", payload.Version, minProtocolVersion)
+			}
+			if err := checkNetwork(payload, genesisHash); err != nil {
+				return nil, err
+			}
+			if nonces.has(payload.Nonce) {
+				return nil, errors.New("connected to itself")
+			}
+			if payload.BestHeight == nil {
+				return nil, errors.New("version without a best height")
+			}
+			theirs = &payload
+			sends <- Command{"verack", nil}
+		case command.Command == "verack" && theirs != nil:
+			acked = true
+		default:
+			return nil, fmt.Errorf("%s message during the handshake", command.Command)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+	}
+
+	return theirs, nil
+}
@@ -0,0 +1,172 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+// testVersion is the version message of a node at height of the chain
+// starting at genesisHash
+func testVersion(height int64, genesisHash []byte) verzion {
+	return verzion{
+		Version:     nodeVersion,
+		BestHeight:  big.NewInt(height),
+		Magic:       core.ActiveNetParams.Genesis.Magic,
+		GenesisHash: genesisHash,
+		Services:    ServiceFull,
+		Nonce:       rand.Uint64(),
+	}
+}
+
+type handshakeResult struct {
+	version *verzion
+	err     error
+}
+
+// runHandshake runs the handshake of two peers connected by a pipe, each
+// closing it once refusing the other as its disconnect would
+func runHandshake(a, b verzion, genesisA, genesisB []byte, noncesA, noncesB *nonceSet) (handshakeResult, handshakeResult) {
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	results := make(chan handshakeResult)
+	go func() {
+		version, err := handshake(rwB, b, genesisB, noncesB)
+		if err != nil {
+			rwB.Close()
+		}
+		results <- handshakeResult{version, err}
+	}()
+	version, err := handshake(rwA, a, genesisA, noncesA)
+	if err != nil {
+		rwA.Close()
+	}
+
+	return handshakeResult{version, err}, <-results
+}
+
+func newNonces() *nonceSet {
+	return &nonceSet{nonces: make(map[uint64]bool)}
+}
+
+func TestHandshake(t *testing.T) {
+	genesis := []byte("genesis")
+	a, b := testVersion(5, genesis), testVersion(9, genesis)
+	resultA, resultB := runHandshake(a, b, genesis, genesis, newNonces(), newNonces())
+	assert.Nil(t, resultA.err)
+	assert.Nil(t, resultB.err)
+	if resultA.version != nil && resultB.version != nil {
+		assert.Equal(t, int64(9), resultA.version.BestHeight.Int64())
+		assert.Equal(t, b.Nonce, resultA.version.Nonce)
+		assert.Equal(t, int64(5), resultB.version.BestHeight.Int64())
+	}
+}
+
+func TestHandshakeRefused(t *testing.T) {
+	genesis := []byte("genesis")
+
+	// each side refuses the version of the other, the first message read
+	resultA, resultB := runHandshake(testVersion(5, genesis), testVersion(5, []byte("other")), genesis, []byte("other"), newNonces(), newNonces())
+	assert.Nil(t, resultA.version)
+	assert.Nil(t, resultB.version)
+	refused := 0
+	for _, err := range []error{resultA.err, resultB.err} {
+		if err != nil && strings.Contains(err.Error(), "genesis block") {
+			refused++
+		}
+	}
+	assert.True(t, refused > 0, "%v, %v", resultA.err, resultB.err)
+	assert.NotNil(t, resultA.err)
+	assert.NotNil(t, resultB.err)
+
+	old := testVersion(5, genesis)
+	old.Version = 1
+	_, resultB = runHandshake(old, testVersion(5, genesis), genesis, genesis, newNonces(), newNonces())
+	assert.NotNil(t, resultB.err)
+
+	// both ends of a connection to itself hold the nonces of the node
+	nonces := newNonces()
+	resultA, resultB = runHandshake(testVersion(5, genesis), testVersion(5, genesis), genesis, genesis, nonces, nonces)
+	assert.NotNil(t, resultA.err)
+	assert.NotNil(t, resultB.err)
+	assert.Empty(t, nonces.nonces)
+}
+
+// TestVersionMatrix connects nodes of every protocol version supported, the
+// messages sent are those of the version of the connection, the older
+func TestVersionMatrix(t *testing.T) {
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	quit := make(chan struct{})
+	defer close(quit)
+	Manager = newTestManager(quit)
+	genesis := []byte("genesis")
+	coinbase := core.NewCoinbaseTX(string(core.NewWallet().GetAddress()), "")
+	block := &core.Block{
+		BlockHeader:  core.BlockHeader{Timestamp: big.NewInt(time.Now().Unix()), Height: big.NewInt(1)},
+		Transactions: []*core.Transaction{coinbase},
+		Hash:         []byte{1},
+	}
+
+	for ours := minProtocolVersion; ours <= nodeVersion; ours++ {
+		for theirs := minProtocolVersion; theirs <= nodeVersion; theirs++ {
+			pair := fmt.Sprintf("%d with %d", ours, theirs)
+			a, b := testVersion(5, genesis), testVersion(5, genesis)
+			a.Version, b.Version = ours, theirs
+			a.Services, b.Services = ServiceFull|ServiceCompact, ServiceFull|ServiceCompact
+			resultA, resultB := runHandshake(a, b, genesis, genesis, newNonces(), newNonces())
+			if !assert.Nil(t, resultA.err, pair) || !assert.Nil(t, resultB.err, pair) {
+				continue
+			}
+			version := negotiateVersion(ours, resultA.version.Version)
+			assert.Equal(t, version, negotiateVersion(theirs, resultB.version.Version), pair)
+			if ours < theirs {
+				assert.Equal(t, ours, version, pair)
+			} else {
+				assert.Equal(t, theirs, version, pair)
+			}
+
+			// the node relays to its peer as the version of the connection
+			// allows, the peer reading what it's sent
+			rw, remote := p2p.MsgPipe()
+			received := readCommands(remote)
+			p := newPeer(version, p2p.NewPeer(discover.NodeID{byte(ours), byte(theirs)}, "", nil), rw)
+			p.services = resultA.version.Services
+			requestMempool(p)
+			requestAddrs(p)
+			assert.Nil(t, p.SendTransactions(core.Transactions{coinbase}), pair)
+			assert.Nil(t, p.announceBlock(block), pair)
+			switch version {
+			case handshakeVersion:
+				// nothing it doesn't speak, blocks and transactions whole
+				assert.Equal(t, "tx", (<-received).Command, pair)
+				assert.Equal(t, "block", (<-received).Command, pair)
+				assert.False(t, p.speaks("ping"), pair)
+			case invVersion:
+				assert.Equal(t, "mempool", (<-received).Command, pair)
+				assert.Equal(t, "getaddr", (<-received).Command, pair)
+				assert.Equal(t, "inv", (<-received).Command, pair)
+				assert.Equal(t, "inv", (<-received).Command, pair)
+				assert.True(t, p.speaks("ping"), pair)
+			case compactVersion:
+				assert.Equal(t, "mempool", (<-received).Command, pair)
+				assert.Equal(t, "getaddr", (<-received).Command, pair)
+				assert.Equal(t, "inv", (<-received).Command, pair)
+				assert.Equal(t, "cmpctblock", (<-received).Command, pair)
+			}
+
+			// a message of a newer version is ignored, not held against the
+			// peer
+			HandleConnection(p, Command{Command: "sendheaders"}, nil)
+			assert.Equal(t, 0, p.info().BanScore, pair)
+			rw.Close()
+		}
+	}
+}
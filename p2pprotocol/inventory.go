@@ -0,0 +1,165 @@
+package p2pprotocol
+
+import (
+	"container/list"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../blockchain_go"
+)
+
+// getDataTimeout is how long an item asked for with getdata isn't asked for
+// again, to another peer announcing it meanwhile
+const getDataTimeout = 30 * time.Second
+
+// knownInventory holds the hashes of the transactions or blocks a peer is
+// known to have, it announced or sent them, or was sent them. It holds at
+// most limit hashes, those marked the least recently dropped first
+type knownInventory struct {
+	mu    sync.Mutex
+	limit int
+	order *list.List // of hex hashes, the most recently marked in front
+	items map[string]*list.Element
+}
+
+func newKnownInventory(limit int) *knownInventory {
+	return &knownInventory{
+		limit: limit,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Add marks hash as known
+func (k *knownInventory) Add(hash []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	if e, ok := k.items[key]; ok {
+		k.order.MoveToFront(e)
+		return
+	}
+	k.items[key] = k.order.PushFront(key)
+	for k.order.Len() > k.limit {
+		oldest := k.order.Back()
+		k.order.Remove(oldest)
+		delete(k.items, oldest.Value.(string))
+	}
+}
+
+// Has tells whether hash is known
+func (k *knownInventory) Has(hash []byte) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, ok := k.items[hex.EncodeToString(hash)]
+	return ok
+}
+
+// Len returns the number of hashes known
+func (k *knownInventory) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.order.Len()
+}
+
+// requestSet holds the items asked for with getdata and not received yet
+type requestSet struct {
+	mu    sync.Mutex
+	items map[string]time.Time // hex hash to when it was asked for
+}
+
+// requested are the items the node asked for
+var requested = &requestSet{items: make(map[string]time.Time)}
+
+// add returns whether hash is to be asked for, it isn't while another
+// request for it is in flight, and records the request then
+func (r *requestSet) add(hash []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, at := range r.items {
+		if now.Sub(at) > getDataTimeout {
+			delete(r.items, key)
+		}
+	}
+	key := hex.EncodeToString(hash)
+	if _, ok := r.items[key]; ok {
+		return false
+	}
+	r.items[key] = now
+	return true
+}
+
+// remove drops the request for hash once the item is received
+func (r *requestSet) remove(hash []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.items, hex.EncodeToString(hash))
+}
+
+// haveBlock tells whether the block of hash is stored, or waits for its
+// parent in the orphan pool
+func haveBlock(bc *core.Blockchain, hash []byte) bool {
+	if Manager.Orphans != nil && Manager.Orphans.Has(hash) {
+		return true
+	}
+	has, err := bc.HasBlock(hash)
+	return err == nil && has
+}
+
+// InventoryStats are the counters of the inventory exchange, what the
+// announcements saved is in InvSkipped, GetDataSkipped and Duplicates
+type InventoryStats struct {
+	InvSent         uint64 `json:"inv_sent"`         // hashes announced to the peers
+	InvSkipped      uint64 `json:"inv_skipped"`      // hashes not announced, known by the peer
+	InvReceived     uint64 `json:"inv_received"`     // hashes the peers announced
+	GetDataSent     uint64 `json:"getdata_sent"`     // items asked for
+	GetDataSkipped  uint64 `json:"getdata_skipped"`  // hashes announced not asked for, stored or asked for already
+	GetDataReceived uint64 `json:"getdata_received"` // items the peers asked for
+	TxsSent         uint64 `json:"txs_sent"`
+	BlocksSent      uint64 `json:"blocks_sent"`
+	BytesSent       uint64 `json:"bytes_sent"` // of the transactions and blocks sent
+	TxsReceived     uint64 `json:"txs_received"`
+	BlocksReceived  uint64 `json:"blocks_received"`
+	BytesReceived   uint64 `json:"bytes_received"` // of the transactions and blocks received
+	Duplicates      uint64 `json:"duplicates"`     // transactions and blocks received already held
+	// the duplicates of the blocks answered from seenBlocks
+	AnnouncesSuppressed uint64 `json:"announces_suppressed"` // block announcements of blocks seen already
+	BlocksSuppressed    uint64 `json:"blocks_suppressed"`    // blocks received again, validated or validating already
+	BlockRefetches      uint64 `json:"block_refetches"`      // blocks asked for from another announcer, not received in time
+	RelaysSuppressed    uint64 `json:"relays_suppressed"`    // blocks not announced back to the peer they came from
+}
+
+// inventoryStats are the counters of the node, updated atomically
+var inventoryStats InventoryStats
+
+// snapshot returns the counters read atomically
+func (s *InventoryStats) snapshot() InventoryStats {
+	return InventoryStats{
+		InvSent:         atomic.LoadUint64(&s.InvSent),
+		InvSkipped:      atomic.LoadUint64(&s.InvSkipped),
+		InvReceived:     atomic.LoadUint64(&s.InvReceived),
+		GetDataSent:     atomic.LoadUint64(&s.GetDataSent),
+		GetDataSkipped:  atomic.LoadUint64(&s.GetDataSkipped),
+		GetDataReceived: atomic.LoadUint64(&s.GetDataReceived),
+		TxsSent:         atomic.LoadUint64(&s.TxsSent),
+		BlocksSent:      atomic.LoadUint64(&s.BlocksSent),
+		BytesSent:       atomic.LoadUint64(&s.BytesSent),
+		TxsReceived:     atomic.LoadUint64(&s.TxsReceived),
+		BlocksReceived:  atomic.LoadUint64(&s.BlocksReceived),
+		BytesReceived:   atomic.LoadUint64(&s.BytesReceived),
+		Duplicates:      atomic.LoadUint64(&s.Duplicates),
+
+		AnnouncesSuppressed: atomic.LoadUint64(&s.AnnouncesSuppressed),
+		BlocksSuppressed:    atomic.LoadUint64(&s.BlocksSuppressed),
+		BlockRefetches:      atomic.LoadUint64(&s.BlockRefetches),
+		RelaysSuppressed:    atomic.LoadUint64(&s.RelaysSuppressed),
+	}
+}
@@ -0,0 +1,80 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKnownInventory(t *testing.T) {
+	known := newKnownInventory(2)
+	known.Add([]byte("a"))
+	known.Add([]byte("b"))
+	// marking a hash again keeps it the longest
+	known.Add([]byte("a"))
+	known.Add([]byte("c"))
+	assert.Equal(t, 2, known.Len())
+	assert.True(t, known.Has([]byte("a")))
+	assert.False(t, known.Has([]byte("b")))
+	assert.True(t, known.Has([]byte("c")))
+}
+
+// readCommands reads the commands sent on rw to the returned channel, until
+// rw is closed
+func readCommands(rw p2p.MsgReadWriter) <-chan Command {
+	commands := make(chan Command, 10)
+	go func() {
+		defer close(commands)
+		for {
+			command, err := framer().ReadCommand(rw)
+			if err == nil {
+				commands <- command
+			} else if !isFrameError(err) {
+				return
+			}
+		}
+	}()
+
+	return commands
+}
+
+func TestTxAnnouncements(t *testing.T) {
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	Manager = &ProtocolManager{Peers: newPeerSet(), TxMempool: core.NewMempool()}
+	defer func(r *requestSet) { requested = r }(requested)
+	requested = &requestSet{items: make(map[string]time.Time)}
+	rw, remote := p2p.MsgPipe()
+	defer remote.Close()
+	commands := readCommands(remote)
+	p := &Peer{id: "test", Rw: rw, knownTxs: newKnownInventory(maxKnownTxs), knownBlocks: newKnownInventory(maxKnownBlocks)}
+
+	address := fmt.Sprintf("%s", core.NewWallet().GetAddress())
+	pending := core.NewCoinbaseTX(address, "pending")
+	Manager.TxMempool.Add(pending)
+	missing := core.NewCoinbaseTX(address, "missing")
+	before := Stats().Inventory
+
+	// only the transaction the node lacks is asked for, once
+	announce := Command{"inv", gobEncode(inv{"", "tx", [][]byte{pending.ID, missing.ID}})}
+	handleInv(p, announce, nil)
+	handleInv(p, announce, nil)
+	var getData getdata
+	assert.Nil(t, gobDecode((<-commands).Data, &getData))
+	assert.Equal(t, "tx", getData.Type)
+	assert.Equal(t, missing.ID, getData.ID)
+	assert.Empty(t, commands)
+
+	// the peer isn't announced what it announced
+	SendTx(p, p.Rw, pending)
+	assert.Empty(t, commands)
+
+	after := Stats().Inventory
+	assert.Equal(t, uint64(4), after.InvReceived-before.InvReceived)
+	assert.Equal(t, uint64(1), after.GetDataSent-before.GetDataSent)
+	assert.Equal(t, uint64(3), after.GetDataSkipped-before.GetDataSkipped)
+	assert.Equal(t, uint64(1), after.InvSkipped-before.InvSkipped)
+}
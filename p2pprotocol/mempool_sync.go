@@ -0,0 +1,61 @@
+package p2pprotocol
+
+import (
+	"log"
+	"time"
+)
+
+// ServeMempool is whether the node answers the mempool requests of its
+// peers, a node keeping its pending transactions private doesn't
+var ServeMempool = true
+
+// MaxMempoolInv is the most pending transactions announced in answer to a
+// mempool request
+var MaxMempoolInv = 5000
+
+// mempoolRequestInterval is how often a peer may ask for the mempool, the
+// requests in between are ignored
+const mempoolRequestInterval = time.Minute
+
+// requestMempool asks the peer for its pending transactions, once per
+// connection, after the handshake and the block download from the peer.
+// The peer answers with an inv, the transactions the node lacks are asked
+// for with getdata and admitted by handleTx. The unconfirmed wallet
+// transactions are announced to the peer then, see rebroadcast. A peer
+// older than invVersion isn't asked
+func requestMempool(p *Peer) {
+	if !p.speaks("mempool") {
+		return
+	}
+	p.lock.Lock()
+	asked := p.mempoolAsked
+	p.mempoolAsked = true
+	p.lock.Unlock()
+	if asked {
+		return
+	}
+
+	sendDataC(p.Rw, Command{Command: "mempool"})
+	Manager.rebroadcast([]*Peer{p})
+}
+
+// handleMempool announces the pending transactions to the peer asking for
+// them, see syncTransactions, unless ServeMempool is off or the peer asked
+// less than mempoolRequestInterval ago
+func handleMempool(p *Peer) {
+	if !ServeMempool {
+		log.Printf("peer %s asked for the mempool, not served", p.id)
+		return
+	}
+	p.lock.Lock()
+	last := p.mempoolServed
+	if !last.IsZero() && time.Since(last) < mempoolRequestInterval {
+		p.lock.Unlock()
+		log.Printf("peer %s asked for the mempool again, ignored", p.id)
+		return
+	}
+	p.mempoolServed = time.Now()
+	p.lock.Unlock()
+
+	Manager.syncTransactions(p)
+}
@@ -0,0 +1,135 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestManager is the protocol manager of a node holding txs, answering
+// the mempool requests until quit is closed
+func newTestManager(quit chan struct{}, txs ...*core.Transaction) *ProtocolManager {
+	pm := &ProtocolManager{
+		Peers:     newPeerSet(),
+		TxMempool: core.NewMempool(),
+		TxOrphans: core.NewTxOrphanPool(core.DefaultMaxTxOrphans, core.DefaultTxOrphanAge, core.DefaultMaxTxOrphansPerPeer),
+		txsyncCh:  make(chan *txsync),
+		quitSync:  quit,
+		BestTd:    make(chan *big.Int),
+	}
+	for _, tx := range txs {
+		pm.TxMempool.Add(tx)
+	}
+	go pm.txsyncLoop()
+
+	return pm
+}
+
+// testNode is a node of the test, its manager and its peer at the other end
+type testNode struct {
+	pm       *ProtocolManager
+	peer     *Peer
+	received <-chan Command
+}
+
+// handle handles the next count commands the node received, with its
+// manager
+func (n testNode) handle(count int, bc *core.Blockchain) {
+	Manager = n.pm
+	for i := 0; i < count; i++ {
+		HandleConnection(n.peer, <-n.received, bc)
+	}
+}
+
+func TestMempoolSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+
+	// four coinbase outputs to spend, in four pending transactions
+	wallet := core.NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	other := fmt.Sprintf("%s", core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "mempooltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	UTXOSet.Reindex()
+	for i := 0; i < 3; i++ {
+		_, err := bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+		assert.Nil(t, err)
+	}
+	pending := core.NewMempool()
+	for i := 0; i < 4; i++ {
+		tx, err := core.NewUTXOTransaction(wallet, other, 1, &UTXOSet, pending.Reserved(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending.Add(tx)
+	}
+
+	// node a holds three, node b just restarted, both on the chain of bc
+	quit := make(chan struct{})
+	defer close(quit)
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	txs := pending.Transactions()
+	a := testNode{pm: newTestManager(quit, txs[:3]...), received: readCommands(rwA)}
+	a.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rwA)
+	b := testNode{pm: newTestManager(quit), received: readCommands(rwB)}
+	b.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+
+	// b asks for the mempool, a announces it, b asks for each transaction
+	// and admits them
+	Manager = b.pm
+	requestMempool(b.peer)
+	a.handle(1, bc)
+	b.handle(1, bc)
+	a.handle(3, bc)
+	b.handle(3, bc)
+	assert.Equal(t, 3, b.pm.TxMempool.Count())
+	for _, tx := range txs[:3] {
+		assert.NotNil(t, b.pm.TxMempool.Get(fmt.Sprintf("%x", tx.ID)))
+	}
+
+	// asking again right away, or a node not serving its mempool, isn't
+	// answered
+	a.pm.TxMempool.Add(txs[3])
+	askAgain := func() {
+		Manager = b.pm
+		b.peer.mempoolAsked = false
+		requestMempool(b.peer)
+		a.handle(1, bc)
+	}
+	askAgain()
+	defer func(serve bool) { ServeMempool = serve }(ServeMempool)
+	ServeMempool = false
+	a.peer.mempoolServed = time.Time{}
+	askAgain()
+
+	// what b is known to have isn't announced again
+	ServeMempool = true
+	askAgain()
+	var announced inv
+	assert.Nil(t, gobDecode((<-b.received).Data, &announced))
+	assert.Equal(t, [][]byte{txs[3].ID}, announced.Items)
+	assert.Empty(t, b.received)
+}
@@ -0,0 +1,275 @@
+package p2pprotocol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../blockchain_go"
+)
+
+// MetricsAddr is the address of the Prometheus metrics listener StartServer
+// starts, e.g. 127.0.0.1:9334, none when empty
+var MetricsAddr = ""
+
+// metricsContentType is the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// counterVec counts events by the value of a single label
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: make(map[string]uint64)}
+}
+
+func (c *counterVec) inc(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[label]++
+}
+
+func (c *counterVec) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[string]uint64, len(c.values))
+	for label, value := range c.values {
+		values[label] = value
+	}
+	return values
+}
+
+// histogram counts observations in buckets of upper bounds, the sum and the
+// count of them
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+var (
+	// blockValidation times the blocks of peers being verified and
+	// connected, orphans aside
+	blockValidation = newHistogram(.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10)
+	// reorgDepth is the number of blocks each reorganisation disconnected
+	reorgDepth = newHistogram(1, 2, 3, 5, 10, 20, 50, 100)
+	// txRejected counts the transactions of peers refused, by reason
+	txRejected = newCounterVec()
+	// peerConnections counts the peers registered, by direction
+	peerConnections = newCounterVec()
+)
+
+// the reasons a transaction of a peer is refused for, see txRejectReason
+const (
+	rejectMalformed = "malformed"
+	rejectOrphan    = "orphan"
+	rejectSpent     = "spent"
+	rejectInputs    = "inputs"
+	rejectAmount    = "amount"
+	rejectInvalid   = "invalid"
+	rejectConflict  = "conflict"
+)
+
+// txRejectReason returns the reason VerifyTx refused tx for: an input the
+// chain doesn't know, one already spent, one not owned by its key, outputs
+// worth more than the inputs, or else a signature or address that doesn't
+// check. One the UTXO set couldn't be read for counts as invalid
+func txRejectReason(tx *core.Transaction, bc *core.Blockchain) string {
+	report, err := core.UTXOSet{Blockchain: bc}.CheckUTXOAmount(tx)
+	if err != nil {
+		return rejectInvalid
+	}
+	for _, in := range report.Inputs {
+		switch in.Err {
+		case nil:
+		case core.ErrUnknownOutpoint:
+			return rejectOrphan
+		case core.ErrSpentOutpoint:
+			return rejectSpent
+		default:
+			return rejectInputs
+		}
+	}
+	if report.Err() != nil {
+		return rejectAmount
+	}
+
+	return rejectInvalid
+}
+
+// peerDirection labels p by who dialed
+func peerDirection(p *Peer) string {
+	if p.Inbound() {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// metricsHandler serves GET /metrics in the Prometheus text format. The
+// gauges are read at each scrape, the chain of nodeID opened for the
+// heights
+type metricsHandler struct {
+	nodeID string
+}
+
+// startMetrics starts the metrics listener on MetricsAddr, reporting the
+// chain of nodeID
+func startMetrics(nodeID string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", MetricsAddr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{
+		Handler:      &metricsHandler{nodeID: nodeID},
+		ReadTimeout:  restTimeout,
+		WriteTimeout: restTimeout,
+	}
+	go server.Serve(listener)
+
+	return listener, nil
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	var page metricsPage
+	h.collect(&page)
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(page.Bytes())
+}
+
+// collect writes the metrics of the node to page
+func (h *metricsHandler) collect(page *metricsPage) {
+	// the chain of a node that hasn't created it yet has no height
+	height := int64(-1)
+	if bc, err := openChain(h.nodeID); err == nil {
+		if best, _, err := bc.GetBestHeight(); err == nil {
+			height = best.Int64()
+		}
+		closeChain(bc)
+	}
+	if height >= 0 {
+		page.gauge("swc_block_height", "Height of the best block of the chain.", float64(height))
+	}
+	headers := height
+	peers := map[string]uint64{"inbound": 0, "outbound": 0}
+	mempoolTxs, mempoolBytes := 0, 0.0
+	if Manager != nil {
+		Manager.Peers.lock.RLock()
+		for _, p := range Manager.Peers.Peers {
+			peers[peerDirection(p)]++
+			if p.Td != nil && p.Td.Int64() > headers {
+				headers = p.Td.Int64()
+			}
+		}
+		Manager.Peers.lock.RUnlock()
+		for _, tx := range Manager.TxMempool.Transactions() {
+			mempoolTxs++
+			mempoolBytes += float64(len(tx.Serialize()))
+		}
+	}
+	if headers >= 0 {
+		page.gauge("swc_headers_height", "Best height the node or its peers reported.", float64(headers))
+	}
+	page.vec("swc_peers", "gauge", "Connected peers by direction.", "direction", peers)
+	page.gauge("swc_mempool_transactions", "Transactions of the mempool.", float64(mempoolTxs))
+	page.gauge("swc_mempool_bytes", "Size of the transactions of the mempool in bytes.", mempoolBytes)
+
+	page.vec("swc_peer_connections_total", "counter", "Peers registered by direction.", "direction", peerConnections.snapshot())
+	page.counter("swc_bytes_sent_total", "Bytes of messages sent to peers.", float64(atomic.LoadUint64(&bandwidthStats.BytesSent)))
+	page.counter("swc_bytes_received_total", "Bytes of messages received from peers.", float64(atomic.LoadUint64(&bandwidthStats.BytesReceived)))
+	page.counter("swc_checksum_failures_total", "Frames of peers failing their checksum, discarded.", float64(atomic.LoadUint64(&checksumFailures)))
+	page.vec("swc_tx_rejected_total", "counter", "Transactions of peers refused by reason.", "reason", txRejected.snapshot())
+	page.histogram("swc_block_validation_seconds", "Time blocks of peers take to verify and connect.", blockValidation)
+	reorgDepth.mu.Lock()
+	reorgs := reorgDepth.count
+	reorgDepth.mu.Unlock()
+	page.counter("swc_reorgs_total", "Reorganisations of the chain.", float64(reorgs))
+	page.histogram("swc_reorg_depth", "Blocks disconnected by each reorganisation.", reorgDepth)
+}
+
+// metricsPage is a scrape in the Prometheus text format
+type metricsPage struct {
+	bytes.Buffer
+}
+
+func formatSample(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *metricsPage) header(name, kind, help string) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (m *metricsPage) gauge(name, help string, v float64) {
+	m.header(name, "gauge", help)
+	fmt.Fprintf(m, "%s %s\n", name, formatSample(v))
+}
+
+func (m *metricsPage) counter(name, help string, v float64) {
+	m.header(name, "counter", help)
+	fmt.Fprintf(m, "%s %s\n", name, formatSample(v))
+}
+
+// vec writes a sample of name by value of label, in the order of the values
+func (m *metricsPage) vec(name, kind, help, label string, values map[string]uint64) {
+	m.header(name, kind, help)
+	labels := make([]string, 0, len(values))
+	for value := range values {
+		labels = append(labels, value)
+	}
+	sort.Strings(labels)
+	for _, value := range labels {
+		fmt.Fprintf(m, "%s{%s=%q} %d\n", name, label, value, values[value])
+	}
+}
+
+func (m *metricsPage) histogram(name, help string, h *histogram) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m.header(name, "histogram", help)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(m, "%s_bucket{le=%q} %d\n", name, formatSample(bound), cumulative)
+	}
+	fmt.Fprintf(m, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(m, "%s_sum %s\n", name, formatSample(h.sum))
+	fmt.Fprintf(m, "%s_count %d\n", name, h.count)
+}
+
+// observeBlock records the time a block took to process since start
+func observeBlock(start time.Time) {
+	blockValidation.observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,133 @@
+package p2pprotocol
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+// scrape returns the samples of the metrics of h by name and labels
+func scrape(t *testing.T, h http.Handler) map[string]string {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metricsContentType, w.Header().Get("Content-Type"))
+	samples := make(map[string]string)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(1, 5)
+	for _, v := range []float64{0.5, 1, 3, 10} {
+		h.observe(v)
+	}
+	var page metricsPage
+	page.histogram("depth", "Depth.", h)
+	assert.Equal(t, `# HELP depth Depth.
+# TYPE depth histogram
+depth_bucket{le="1"} 2
+depth_bucket{le="5"} 3
+depth_bucket{le="+Inf"} 4
+depth_sum 14.5
+depth_count 4
+`, page.String())
+}
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	defer func(depth *histogram, rejected, connections *counterVec) {
+		reorgDepth, txRejected, peerConnections = depth, rejected, connections
+	}(reorgDepth, txRejected, peerConnections)
+	reorgDepth, txRejected, peerConnections = newHistogram(1, 2, 3), newCounterVec(), newCounterVec()
+
+	address := string(core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "metricstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.UTXOSet{Blockchain: bc}.Reindex()
+	if _, err := bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")}); err != nil {
+		t.Fatal(err)
+	}
+	// an input the chain doesn't know
+	orphan := &core.Transaction{
+		Vin:  []core.TXInput{{Txid: make([]byte, 32), Vout: 0}},
+		Vout: []core.TXOutput{*core.NewTXOutput(1, address)},
+	}
+	assert.Equal(t, rejectOrphan, txRejectReason(orphan, bc))
+	bc.Close()
+
+	quit := make(chan struct{})
+	defer close(quit)
+	// as received from a peer, its size not cached
+	pending := core.DeserializeTransaction(core.NewCoinbaseTX(address, "pending").Serialize())
+	Manager = newTestManager(quit, &pending)
+	openChainsAgain()
+	rw, _ := p2p.MsgPipe()
+	defer rw.Close()
+	p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "", nil), rw)
+	p.Td = big.NewInt(7)
+	assert.Nil(t, Manager.Peers.Register(p))
+	txRejected.inc(rejectOrphan)
+	txRejected.inc(rejectConflict)
+	txRejected.inc(rejectConflict)
+	reorgDepth.observe(2)
+
+	handler := &metricsHandler{nodeID: "metricstest"}
+	samples := scrape(t, handler)
+	assert.Equal(t, "1", samples["swc_block_height"])
+	assert.Equal(t, "7", samples["swc_headers_height"])
+	assert.Equal(t, "1", samples[`swc_peers{direction="outbound"}`])
+	assert.Equal(t, "0", samples[`swc_peers{direction="inbound"}`])
+	assert.Equal(t, "1", samples[`swc_peer_connections_total{direction="outbound"}`])
+	assert.Equal(t, "1", samples["swc_mempool_transactions"])
+	assert.Equal(t, "2", samples[`swc_tx_rejected_total{reason="conflict"}`])
+	assert.Equal(t, "1", samples[`swc_tx_rejected_total{reason="orphan"}`])
+	assert.Equal(t, "1", samples["swc_reorgs_total"])
+	assert.Equal(t, "0", samples[`swc_reorg_depth_bucket{le="1"}`])
+	assert.Equal(t, "1", samples[`swc_reorg_depth_bucket{le="2"}`])
+	assert.Equal(t, strconv.Itoa(len(pending.Serialize())), samples["swc_mempool_bytes"])
+	assert.NotEmpty(t, samples["swc_bytes_sent_total"])
+	assert.NotEmpty(t, samples["swc_block_validation_seconds_count"])
+
+	// a node without a chain yet reports no height
+	_, ok := scrape(t, &metricsHandler{nodeID: "nochain"})["swc_block_height"]
+	assert.False(t, ok)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,77 @@
+package p2pprotocol
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"../blockchain_go"
+)
+
+// Miner mines blocks on the tip, dropping the block in flight when another
+// one connects or the node shuts down
+type Miner struct {
+	mu     sync.Mutex
+	quit   context.Context
+	stop   context.CancelFunc
+	cancel context.CancelFunc // cancels the block in flight, nil if none
+	parent []byte             // the tip the block in flight extends
+}
+
+// NewMiner returns a Miner ready to mine
+func NewMiner() *Miner {
+	quit, stop := context.WithCancel(context.Background())
+
+	return &Miner{quit: quit, stop: stop}
+}
+
+// Mine mines a block with txs on the tip of bc. It returns
+// core.ErrMiningAborted if Interrupt or Stop is called meanwhile, or if
+// another block became the tip
+func (m *Miner) Mine(bc *core.Blockchain, txs []*core.Transaction) (*core.Block, error) {
+	_, parent, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(m.quit)
+	m.mu.Lock()
+	m.cancel, m.parent = cancel, parent
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.cancel, m.parent = nil, nil
+		m.mu.Unlock()
+		cancel()
+	}()
+
+	return bc.MineBlockContext(ctx, txs)
+}
+
+// Interrupt aborts the block in flight, which a new tip made stale
+func (m *Miner) Interrupt() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+}
+
+// Connected aborts the block in flight once block connects, unless the block
+// in flight extends it, as it does the block this miner mined before
+func (m *Miner) Connected(block *core.Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil && !bytes.Equal(m.parent, block.Hash) {
+		m.cancel()
+	}
+}
+
+// Stop aborts the block in flight and any mined after
+func (m *Miner) Stop() {
+	m.stop()
+}
+
+// Stopped reports whether Stop was called
+func (m *Miner) Stopped() bool {
+	return m.quit.Err() != nil
+}
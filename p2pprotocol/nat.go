@@ -0,0 +1,78 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"../p2p/nat"
+)
+
+// NAT maps the listen port of the node on its gateway, for peers to connect
+// to it from outside, none when nil. It's set by the -nat flag, see
+// nat.Parse
+var NAT nat.Interface
+
+// natLease is the lifetime of the mapping, renewed every natRefresh
+const natLease = 20 * time.Minute
+
+var natRefresh = 15 * time.Minute
+
+// natName describes the mapping on the gateway
+const natName = "swc p2p"
+
+// refreshMapping maps port on m, it returns the address the node is
+// reachable at, the external IP of m with port
+func refreshMapping(m nat.Interface, port int) (string, error) {
+	if err := m.AddMapping("tcp", port, port, natName, natLease); err != nil {
+		return "", fmt.Errorf("mapping port %d: %v", port, err)
+	}
+	ip, err := m.ExternalIP()
+	if err != nil {
+		return "", fmt.Errorf("reading the external IP: %v", err)
+	}
+	external := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	if !validAddress(external) {
+		return "", fmt.Errorf("the external IP %v can't be connected to", ip)
+	}
+
+	return external, nil
+}
+
+// mapPort maps the TCP port of the node on m until quit is closed, the
+// mapping renewed every natRefresh, then deleted. While it holds the
+// address the node advertises is the external one, see addrManager.Local.
+// When it fails the node advertises its listen address and stays
+// outbound-only. wg is done once the mapping is deleted
+func mapPort(m nat.Interface, port int, addrs *addrManager, quit <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer m.DeleteMapping("tcp", port, port)
+
+		refresh := time.NewTimer(0)
+		defer refresh.Stop()
+		mapped, failed := "", false
+		for {
+			select {
+			case <-quit:
+				return
+			case <-refresh.C:
+			}
+			// logged when it changes only
+			external, err := refreshMapping(m, port)
+			if err != nil && !failed {
+				log.Printf("NAT: %v on %v, the node accepts no connections from outside, outbound only", err, m)
+			}
+			if err == nil && external != mapped {
+				log.Printf("NAT: port %d mapped on %v, advertising %s", port, m, external)
+			}
+			mapped, failed = external, err != nil
+			addrs.SetExternal(external)
+			refresh.Reset(natRefresh)
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNAT is a gateway mapping ports, or failing to
+type testNAT struct {
+	mu       sync.Mutex
+	ip       net.IP
+	err      error // of AddMapping
+	mappings int
+	deleted  bool
+}
+
+func (n *testNAT) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.mappings++
+	return n.err
+}
+
+func (n *testNAT) DeleteMapping(protocol string, extport, intport int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.deleted = true
+	return nil
+}
+
+func (n *testNAT) ExternalIP() (net.IP, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ip == nil {
+		return nil, errors.New("no external IP")
+	}
+	return n.ip, nil
+}
+
+func (n *testNAT) String() string { return "testNAT" }
+
+func (n *testNAT) fail(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.err = err
+}
+
+func TestRefreshMapping(t *testing.T) {
+	gateway := &testNAT{ip: net.ParseIP("203.0.113.7")}
+	external, err := refreshMapping(gateway, 2000)
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.7:2000", external)
+
+	gateway.fail(errors.New("no UPnP device"))
+	_, err = refreshMapping(gateway, 2000)
+	assert.NotNil(t, err)
+
+	_, err = refreshMapping(&testNAT{}, 2000)
+	assert.NotNil(t, err)
+	_, err = refreshMapping(&testNAT{ip: net.IPv4zero}, 2000)
+	assert.NotNil(t, err)
+}
+
+func TestMapPort(t *testing.T) {
+	defer func(refresh time.Duration) { natRefresh = refresh }(natRefresh)
+	natRefresh = 10 * time.Millisecond
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	quit := make(chan struct{})
+	defer close(quit)
+	pm := newTestManager(quit)
+	local := netAddress{ID: discover.NodeID{1}, Addr: "192.168.1.10:2000"}
+	pm.Addrs = newAddrManager("", local)
+	Manager = pm
+
+	advertised := func() string {
+		rw, remote := p2p.MsgPipe()
+		defer rw.Close()
+		p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "", nil), rw)
+		go pm.advertise(p)
+		var payload addr
+		assert.Nil(t, gobDecode(expectCommand(t, readCommands(remote), "addr").Data, &payload))
+		if assert.NotEmpty(t, payload.Addresses) {
+			assert.Equal(t, local.ID, payload.Addresses[0].ID)
+			return payload.Addresses[0].Addr
+		}
+		return ""
+	}
+	waitFor := func(addr string) {
+		for deadline := time.Now().Add(time.Second); pm.Addrs.Local().Addr != addr; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s not advertised, %s instead", addr, pm.Addrs.Local().Addr)
+			}
+		}
+	}
+
+	// the listen address until the port is mapped
+	assert.Equal(t, local.Addr, advertised())
+	gateway := &testNAT{ip: net.ParseIP("203.0.113.7")}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	mapPort(gateway, 2000, pm.Addrs, stop, &wg)
+	waitFor("203.0.113.7:2000")
+	assert.Equal(t, "203.0.113.7:2000", advertised())
+	// the address of the node itself gossiped back isn't added
+	assert.False(t, pm.Addrs.Add(netAddress{discover.NodeID{3}, "203.0.113.7:2000", time.Now().Unix()}, "10.1.0.1"))
+
+	// a lease that can't be renewed leaves the node outbound only
+	gateway.fail(errors.New("lease refused"))
+	waitFor(local.Addr)
+	assert.Equal(t, local.Addr, advertised())
+	gateway.fail(nil)
+	waitFor("203.0.113.7:2000")
+
+	// the mapping is renewed until the node stops, then deleted
+	close(stop)
+	wg.Wait()
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+	assert.True(t, gateway.mappings >= 3)
+	assert.True(t, gateway.deleted)
+}
@@ -11,8 +11,8 @@ import (
 	//"github.com/ethereum/go-ethereum/p2p"
 	"../blockchain_go"
 	"../p2p"
+	"../p2p/discover"
 	"../p2p/nat"
-	"gopkg.in/fatih/set.v0"
 	"os"
 	"encoding/hex"
 )
@@ -47,9 +47,6 @@ var (
 	errNotRegistered     = errors.New("peer is not registered")
 )
 //type Message string
-var (
-	daoChallengeTimeout = 6 * time.Second // Time allowance for a node to reply to the DAO handshake challenge
-)
 
 // propEvent is a block propagation, waiting for its turn in the broadcast queue.
 type propEvent struct {
@@ -62,36 +59,63 @@ type Peer struct {
 	id string
 
 	*p2p.Peer
-	Rw p2p.MsgReadWriter
-
-	version  int         // Protocol version negotiated
-	forkDrop *time.Timer // Timed connection dropper if forks aren't validated in time
+	Rw    p2p.MsgReadWriter
+	meter *meteredRW // Rw, counting the bytes and shaping the traffic
+
+	version  int         // Protocol version negotiated, see negotiateVersion
+	// blockVersion is the newest block format the peer decodes, from its
+	// version message
+	blockVersion int
+	services     uint64      // the Service flags of its version message
+	forkDrop *time.Timer // Timed connection dropper if the handshake isn't done in time
+
+	banScore       int       // the scores of its misbehaviours, see Misbehaving
+	checksumErrors int       // the frames it sent failing their checksum, see readCommand
+	mempoolAsked   bool      // whether the peer was asked for its mempool
+	mempoolServed  time.Time // when the peer was last sent the mempool
+	addrAsked      bool      // whether the peer was asked for its addresses
+	addrServed     bool      // whether the peer was sent the addresses it asked for
+
+	pingNonce      uint64        // the nonce of the ping waiting for its pong, 0 if none
+	pingSent       time.Time     // when it was sent
+	pingTime       time.Duration // the round trip time of the last ping answered
+	minPing        time.Duration // the shortest round trip time
+	blockRequested time.Time     // when a block was asked for, zero once received
+	compactBlock   *partialBlock // the compact block waiting for the transactions asked for
+	evicted        bool          // disconnected for an inbound peer past MaxInbound
 
 	head []byte
 	Td   *big.Int
 	lock sync.RWMutex
 
-	knownTxs    *set.Set                  // Set of transaction hashes known to be known by this peer
-	knownBlocks *set.Set                  // Set of block hashes known to be known by this peer
+	knownTxs    *knownInventory          // Set of transaction hashes known to be known by this peer
+	knownBlocks *knownInventory          // Set of block hashes known to be known by this peer
+	knownAddrs  *knownInventory          // Set of addresses known to be known by this peer
 	queuedTxs   chan []*core.Transaction // Queue of transactions to broadcast to the peer
 	queuedProps chan *propEvent           // Queue of blocks to broadcast to the peer
-	//queuedAnns  chan *types.Block         // Queue of blocks to announce to the peer
+	queuedAnns  chan *core.Block          // Queue of blocks to announce to the peer
 	term        chan struct{}             // Termination channel to stop the broadcaster
 }
 
 func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *Peer {
-	return &Peer{
+	meter := newMeteredRW(rw)
+	peer := &Peer{
 		Peer:        p,
-		Rw:          rw,
+		Rw:          meter,
+		meter:       meter,
 		version:     version,
 		id:          fmt.Sprintf("%x", p.ID().Bytes()[:8]),
-		knownTxs:    set.New(),
-		knownBlocks: set.New(),
+		knownTxs:    newKnownInventory(maxKnownTxs),
+		knownBlocks: newKnownInventory(maxKnownBlocks),
+		knownAddrs:  newKnownInventory(maxKnownAddrs),
 		queuedTxs:   make(chan []*core.Transaction, maxQueuedTxs),
 		queuedProps: make(chan *propEvent, maxQueuedProps),
-		//queuedAnns:  make(chan *types.Block, maxQueuedAnns),
+		queuedAnns:  make(chan *core.Block, maxQueuedAnns),
 		term:        make(chan struct{}),
 	}
+	meter.exempt = peer.whitelisted
+
+	return peer
 }
 
 // peerSet represents the collection of active peers currently participating in
@@ -123,9 +147,24 @@ type ProtocolManager struct {
 	quitSync    chan struct{}
 	Peers      *peerSet
 	Bc *core.Blockchain
-	TxMempool map[string]*core.Transaction
+	TxMempool *core.Mempool
+	Orphans *core.OrphanPool // blocks received before their parent
+	TxOrphans *core.TxOrphanPool // transactions received before their parents
+	Miner *Miner
 	BigestTd *big.Int
 	BestTd chan *big.Int
+	Addrs *addrManager // the addresses of the nodes gossiped, see addrLoop
+	dialer peerDialer
+	dialLock sync.Mutex // dials and added, for connectOutbound and AddNode
+	dials map[discover.NodeID]pendingDial
+	added map[discover.NodeID]*discover.Node // the nodes of AddNode, kept connected by the dialer
+	seeds []*discover.Node // dialed when there's no address to try
+	upload *rateLimiter // the budget of MaxUploadRate, nil for none
+	download *blockDownload // the blocks of the sync, downloaded from the peers at once
+	syncState syncTracker // see SyncStatus
+	walletLock sync.Mutex // walletTxs
+	walletTxs map[string]bool // the IDs of the wallet transactions not confirmed yet, see TrackWalletTx
+	slotLock sync.Mutex // the evicted flag of the peers, see admitInbound
 	//CurrTd *big.Int
 }
 
@@ -142,12 +181,20 @@ func (pm *ProtocolManager) removePeer(id string,blockchain *core.Blockchain) {
 	if err := pm.Peers.Unregister(id); err != nil {
 		log.Panic("Peer removal failed", "peer", id, "err", err)
 	}
+	if pm.TxOrphans != nil {
+		pm.TxOrphans.RemoveFrom(id)
+	}
+	// its blocks are downloaded from the others
+	if pm.download != nil {
+		pm.download.peerGone(peer)
+		pm.download.schedule(pm.Peers.List())
+	}
 	// Hard disconnect at the networking layer
 	if peer != nil {
 		peer.Peer.Disconnect(p2p.DiscUselessPeer)
 	}
 
-	blockchain.Db.Close()
+	closeChain(blockchain)
 }
 
 func MyProtocol() p2p.Protocol {
@@ -156,6 +203,7 @@ func MyProtocol() p2p.Protocol {
 		Version: 1,
 		Length:  1,
 		Run:     msgHandler,
+		PeerInfo: peerInfo,
 	}
 }
 
@@ -167,7 +215,11 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet("1NWUWL17WtxzSMVWhGm8UD7Y45ikFUHZCx")
+	wallet, err := wallets.GetWallet("1NWUWL17WtxzSMVWhGm8UD7Y45ikFUHZCx")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	nodekey := &wallet.PrivateKey
 
 	Manager = &ProtocolManager{
@@ -200,45 +252,83 @@ func main() {
 
 func msgHandler(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
 	fmt.Println("---protocol start:")
-	p := newPeer(int(1), peer, ws)
+	// the version is negotiated in the handshake
+	p := newPeer(minProtocolVersion, peer, ws)
 	//Peers[p.id] = p
 
+	// a banned address is refused before anything, and one not whitelisted
+	// in a private network
+	if err := checkBanned(p); err != nil {
+		return err
+	}
+	if err := checkWhitelistOnly(p); err != nil {
+		return err
+	}
+
 	fmt.Println("--- bf NewBlockchain:")
 	nodeID := os.Getenv("NODE_ID")
-	bc := core.NewBlockchain(nodeID)
+	bc, err := openChain(nodeID)
+	if err != nil {
+		p.Log().Error("opening the blockchain failed", "err", err)
+		return err
+	}
+	version, err := newVersion(bc)
+	genesisHash := bc.GenesisHash
+	closeChain(bc)
+	if err != nil {
+		return err
+	}
+
+	// nothing else is processed before both versions are acknowledged, the
+	// chain is left to the other handlers meanwhile
+	p.forkDrop = time.AfterFunc(handshakeTimeout, func() {
+		fmt.Println("---Timed out handshake, dropping:")
+		p.Log().Info("Timed out handshake, dropping")
+		p.Peer.Disconnect(p2p.DiscReadTimeout)
+	})
+	theirs, err := handshake(p.Rw, version, genesisHash, localNonces)
+	p.forkDrop.Stop()
+	p.forkDrop = nil
+	if err != nil {
+		log.Printf("Disconnecting peer %s: %s", p.id, err)
+		return err
+	}
+	p.lock.Lock()
+	p.version = negotiateVersion(version.Version, theirs.Version)
+	p.lock.Unlock()
+	bc, err = openChain(nodeID)
+	if err != nil {
+		p.Log().Error("opening the blockchain failed", "err", err)
+		return err
+	}
 
+	// past MaxInbound a new peer takes the slot of the least useful one
+	if err := Manager.admitInbound(p); err != nil {
+		log.Printf("Disconnecting peer %s: %s", p.id, err)
+		closeChain(bc)
+		return err
+	}
 	fmt.Println("--- bf Peers.Register:")
 	// Register the peer locally
 	if err := Manager.Peers.Register(p); err != nil {
 		p.Log().Error("peer registration failed", "err", err)
+		closeChain(bc)
 		return err
 	}
 	defer Manager.removePeer(p.id,bc)
+	// it ends when the peer is unregistered
+	if p.speaks("ping") {
+		go p.pingLoop()
+	}
 
 
-	fmt.Println("---syncTransactions:")
-	// Propagate existing transactions. new transactions appearing
-	// after this will be sent via broadcasts.
-	Manager.syncTransactions(p)
-
-	// Start a timer to disconnect if the peer doesn't reply in time
-	p.forkDrop = time.AfterFunc(daoChallengeTimeout, func() {
-		fmt.Println("---Timed out DAO fork-check, dropping:")
-		p.Log().Info("Timed out DAO fork-check, dropping")
-		Manager.removePeer(p.id,bc)
-	})
-
-	//select {
-		//case manager.newPeerCh <- p:
-			//return manager.handle(peer)
-			//SendVersion(p.Rw,bc)
-		//case <-manager.quitSync:
-			//return p2p.DiscQuitting
-	//}
-	log.Print(" --send version")
-
-	SendVersion(p.Rw, bc)
-	bc.Db.Close()
+	// the best heights of the handshake tell which side syncs from which,
+	// the pending transactions are asked for once synced, see
+	// requestMempool. New transactions appearing after this will be sent
+	// via broadcasts.
+	syncWithVersion(p, *theirs, bc)
+	closeChain(bc)
+	Manager.exchangeAddresses(p, *theirs)
 
 	// Make sure it's cleaned up if the peer dies off
 	defer func() {
@@ -248,29 +338,31 @@ func msgHandler(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
 		}
 	}()
 
-	var myMessage Command
+	f := framer()
 	for {
-		msg, err := ws.ReadMsg()
+		// a bad frame is skipped, the next starts a message of its own, but a
+		// frame of another network means the stream isn't of this protocol
+		myMessage, err := p.readCommand(f)
 		if err != nil {
 			return err
 		}
-		//s := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		//_,err = s.Bytes()
-		//fmt.Println("--------->s.Bytes() err:", err)
-
-		err = msg.Decode(&myMessage)
-		if err != nil {
-			fmt.Println("--------->msg err:", err)
-			// handle decode error
+		if !p.meter.admitIn(myMessage) {
+			log.Printf("peer %s past its rate limits, %s message dropped", p.id, myMessage.Command)
 			continue
 		}
-		bc1 := core.NewBlockchain(nodeID)
+		// setban applies to the peers connected already
+		if err := checkBanned(p); err != nil {
+			return err
+		}
+		bc1, err := openChain(nodeID)
+		if err != nil {
+			p.Log().Error("opening the blockchain failed, dropping", "err", err)
+			return err
+		}
 
 		HandleConnection(p,myMessage,bc1)
-		bc1.Db.Close()
+		closeChain(bc1)
 	}
-
-	return nil
 }
 
 // newPeerSet creates a new peer set to track the active participants.
@@ -296,6 +388,7 @@ func (ps *peerSet) Register(p *Peer) error {
 		return errAlreadyRegistered
 	}
 	ps.Peers[p.id] = p
+	peerConnections.inc(peerDirection(p))
 	fmt.Println("--------->peer Register:", p.id)
 	fmt.Println("--------->ps.Peers:", ps.Peers)
 	//load peer knowntx from db
@@ -329,7 +422,7 @@ func (ps *peerSet) PeersWithoutTx(hash []byte) []*Peer {
 	list := make([]*Peer, 0, len(ps.Peers))
 	for _, p := range ps.Peers {
 		fmt.Println("---------> p.knownTxs.Has:", hex.EncodeToString(hash))
-		if !p.knownTxs.Has(hex.EncodeToString(hash)) {
+		if !p.knownTxs.Has(hash) {
 			list = append(list, p)
 		}
 	}
@@ -379,16 +472,38 @@ func (ps *peerSet) Peer(id string) *Peer {
 	return ps.Peers[id]
 }
 
+// List returns the peers in the set.
+func (ps *peerSet) List() []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.Peers))
+	for _, p := range ps.Peers {
+		list = append(list, p)
+	}
+	return list
+}
+
+// Len returns the current number of peers in the set.
+func (ps *peerSet) Len() int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return len(ps.Peers)
+}
+
 
 // MarkTransaction marks a transaction as known for the peer, ensuring that it
 // will never be propagated to this particular peer.
 func (p *Peer) MarkTransaction(hash []byte) {
-	// If we reached the memory allowance, drop a previously known transaction hash
-	for p.knownTxs.Size() >= maxKnownTxs {
-		p.knownTxs.Pop()
-	}
-	p.knownTxs.Add(hex.EncodeToString(hash))
-	//fmt.Println("--------->MarkTransaction p.knownTxs.Has:", hex.EncodeToString(hash),"--",p.knownTxs.Has(hex.EncodeToString(hash)))
+	// the least recently marked hashes are dropped past maxKnownTxs
+	p.knownTxs.Add(hash)
+}
+
+// MarkBlock marks a block as known for the peer, ensuring that it will never
+// be announced to this particular peer.
+func (p *Peer) MarkBlock(hash []byte) {
+	p.knownBlocks.Add(hash)
 }
 
 // BestPeer retrieves the known peer with the currently highest total difficulty.
@@ -416,7 +531,7 @@ func (ps *peerSet) PeersWithoutBlock(hash []byte) []*Peer {
 
 	list := make([]*Peer, 0, len(ps.Peers))
 	for _, p := range ps.Peers {
-		if !p.knownBlocks.Has(hex.EncodeToString(hash)) {
+		if !p.knownBlocks.Has(hash) {
 			list = append(list, p)
 		}
 	}
@@ -433,6 +548,12 @@ func (p *Peer) broadcast() {
 			//fmt.Println("---broadcast p.queuedTxs ")
 			p.Log().Trace("Broadcast transactions", "count", len(txs))
 
+		case block := <-p.queuedAnns:
+			if err := p.announceBlock(block); err != nil {
+				return
+			}
+			p.Log().Trace("Announced block", "number", block.Height, "hash", block.Hash)
+
 		//case prop := <-p.queuedProps:
 		//	if err := p.SendNewBlock(prop.block, prop.td); err != nil {
 		//		return
@@ -448,11 +569,20 @@ func (p *Peer) broadcast() {
 
 
 // SendTransactions sends transactions to the peer and includes the hashes
-// in its transaction hash set for future reference.
+// in its transaction hash set for future reference. A peer older than
+// invVersion is sent them whole.
 func (p *Peer) SendTransactions(txs core.Transactions) error {
+	if p.protocolVersion() < invVersion {
+		for _, tx := range txs {
+			if err := sendTx(p, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	var items = make([][]byte,0)
 	for _, tx := range txs {
-		p.knownTxs.Add(hex.EncodeToString(tx.Hash()))
+		p.MarkTransaction(tx.ID)
 		items = append(items,tx.ID)
 	}
 	//fmt.Println("--- SendTransactions  len(txs)  ",len(txs))
@@ -462,11 +592,20 @@ func (p *Peer) SendTransactions(txs core.Transactions) error {
 }
 
 
+// SendNewBlockHashes announces the availability of a number of blocks through
+// an inv, the peer asks for those it lacks with getdata.
+func (p *Peer) SendNewBlockHashes(hashes [][]byte) error {
+	for _, hash := range hashes {
+		p.MarkBlock(hash)
+	}
+	return sendInv(p.Rw, "block", hashes)
+}
+
 // SendNewBlock propagates an entire block to a remote peer.
 func (p *Peer) SendNewBlock(block *core.Block, td *big.Int) error {
-	p.knownBlocks.Add(hex.EncodeToString(block.Hash))
+	p.MarkBlock(block.Hash)
 	//return p2p.Send(p.Rw, NewBlockMsg, []interface{}{block, td})
-	return sendBlock(p.Rw,block)
+	return sendBlock(p,block)
 }
 
 // AsyncSendNewBlock queues an entire block for propagation to a remote peer. If
@@ -474,12 +613,24 @@ func (p *Peer) SendNewBlock(block *core.Block, td *big.Int) error {
 func (p *Peer) AsyncSendNewBlock(block *core.Block, td *big.Int) {
 	select {
 	case p.queuedProps <- &propEvent{block: block, td: td}:
-		p.knownBlocks.Add(hex.EncodeToString(block.Hash))
+		p.MarkBlock(block.Hash)
 	default:
 		p.Log().Debug("Dropping block propagation", "number", block.Height, "hash", block.Hash)
 	}
 }
 
+// AsyncSendNewBlockHash queues the availability of a block for announcement to
+// a remote peer. If the peer's broadcast queue is full, the event is silently
+// dropped.
+func (p *Peer) AsyncSendNewBlockHash(block *core.Block) {
+	select {
+	case p.queuedAnns <- block:
+		p.MarkBlock(block.Hash)
+	default:
+		p.Log().Debug("Dropping block announcement", "number", block.Height, "hash", block.Hash)
+	}
+}
+
 // AsyncSendTransactions queues list of transactions propagation to a remote
 // peer. If the peer's broadcast queue is full, the event is silently dropped.
 func (p *Peer) AsyncSendTransactions(txs []*core.Transaction) {
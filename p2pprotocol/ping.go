@@ -0,0 +1,236 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+)
+
+// PingInterval is how often the peers are pinged
+var PingInterval = 30 * time.Second
+
+// PingTimeout is how long a peer has to answer a ping with its pong before
+// it's disconnected as dead
+var PingTimeout = 90 * time.Second
+
+// blockTransferTimeout replaces PingTimeout while a block asked for is on
+// its way, the pong waits behind it
+const blockTransferTimeout = 10 * time.Minute
+
+// errPingTimeout is the reason a peer not answering the pings is dropped
+var errPingTimeout = errors.New("no pong within the ping timeout")
+
+type ping struct {
+	Nonce uint64
+}
+
+// sendPing pings the peer with a new nonce, the pong is timed against it
+func (p *Peer) sendPing() error {
+	nonce := rand.Uint64() | 1
+	p.lock.Lock()
+	p.pingNonce = nonce
+	p.pingSent = time.Now()
+	p.lock.Unlock()
+
+	return sendDataC(p.Rw, Command{Command: "ping", Data: gobEncode(ping{nonce})})
+}
+
+// requestBlock asks the peer for a block, no ping is sent while it's on
+// its way
+func requestBlock(p *Peer, hash []byte) {
+	p.lock.Lock()
+	p.blockRequested = time.Now()
+	p.lock.Unlock()
+	sendGetData(p.Rw, "block", hash)
+}
+
+// checkPing returns whether the peer is to be pinged at now, or
+// errPingTimeout when the last ping went unanswered for PingTimeout, for
+// blockTransferTimeout while a block asked for is on its way. A block not
+// received within blockTransferTimeout isn't waited for any longer
+func (p *Peer) checkPing(now time.Time) (bool, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	transfer := !p.blockRequested.IsZero() && now.Sub(p.blockRequested) < blockTransferTimeout
+	if p.pingNonce != 0 {
+		timeout := PingTimeout
+		if transfer {
+			timeout = blockTransferTimeout
+		}
+		if now.Sub(p.pingSent) > timeout {
+			return false, errPingTimeout
+		}
+		return false, nil
+	}
+
+	return !transfer, nil
+}
+
+// pingLoop pings the peer every PingInterval, the first right away, and
+// disconnects it when it doesn't answer, until the peer is unregistered
+func (p *Peer) pingLoop() {
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+
+	if err := p.sendPing(); err != nil {
+		return
+	}
+	for {
+		select {
+		case now := <-ticker.C:
+			send, err := p.checkPing(now)
+			if err != nil {
+				log.Printf("Disconnecting peer %s: %v", p.id, err)
+				if p.Peer != nil {
+					p.Peer.Disconnect(p2p.DiscReadTimeout)
+				}
+				return
+			}
+			if send {
+				if err := p.sendPing(); err != nil {
+					return
+				}
+			}
+		case <-p.term:
+			return
+		}
+	}
+}
+
+// handlePing answers a ping with a pong of the same nonce
+func handlePing(p *Peer, command Command) {
+	var payload ping
+	if err := gobDecode(command.Data, &payload); err != nil {
+		malformed(p, command, err)
+		return
+	}
+
+	sendDataC(p.Rw, Command{Command: "pong", Data: gobEncode(payload)})
+}
+
+// handlePong records the round trip time of the ping the pong answers, a
+// pong of another nonce is ignored
+func handlePong(p *Peer, command Command) {
+	var payload ping
+	if err := gobDecode(command.Data, &payload); err != nil {
+		malformed(p, command, err)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if payload.Nonce == 0 || payload.Nonce != p.pingNonce {
+		log.Printf("peer %s sent a pong to no ping", p.id)
+		return
+	}
+	p.pingTime = time.Since(p.pingSent)
+	if p.minPing == 0 || p.pingTime < p.minPing {
+		p.minPing = p.pingTime
+	}
+	p.pingNonce = 0
+}
+
+// PeerInfo describes a peer of the node, the durations in seconds
+type PeerInfo struct {
+	ID             string  `json:"id"`
+	NodeID         string  `json:"node_id"`
+	Address        string  `json:"address"` // the IP and port it's connected from
+	Inbound        bool    `json:"inbound"`
+	Version        int     `json:"version"` // the protocol version
+	Services       uint64  `json:"services"`
+	BestHeight     int64   `json:"best_height"`
+	BanScore       int     `json:"ban_score"`
+	Whitelisted    bool    `json:"whitelisted"`         // exempt from the ban scores and the rate limits
+	ChecksumErrors int     `json:"checksum_errors"`     // the frames it sent failing their checksum
+	LastSend       int64   `json:"last_send"`           // the Unix time of the last message sent, 0 if none
+	LastRecv       int64   `json:"last_recv"`           // of the last message received
+	PingTime       float64 `json:"ping_time"`           // the round trip time of the last ping
+	MinPing        float64 `json:"min_ping"`            // the shortest round trip time
+	PingWait       float64 `json:"ping_wait,omitempty"` // how long the ping under way has been waiting
+	BytesSent      uint64  `json:"bytes_sent"`
+	BytesRecv      uint64  `json:"bytes_recv"`
+}
+
+// unixSeconds returns the Unix time in seconds of nanos, 0 for 0
+func unixSeconds(nanos int64) int64 {
+	if nanos == 0 {
+		return 0
+	}
+	return time.Unix(0, nanos).Unix()
+}
+
+func (p *Peer) info() PeerInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	info := PeerInfo{
+		ID:             p.id,
+		Inbound:        p.Peer != nil && p.Inbound(),
+		Version:        p.version,
+		Services:       p.services,
+		BanScore:       p.banScore,
+		ChecksumErrors: p.checksumErrors,
+		PingTime:       p.pingTime.Seconds(),
+		MinPing:        p.minPing.Seconds(),
+	}
+	info.Whitelisted = whitelist.has(p)
+	if p.Peer != nil {
+		info.NodeID = p.ID().String()
+		if addr := p.RemoteAddr(); addr != nil {
+			info.Address = addr.String()
+		}
+	}
+	if p.meter != nil {
+		info.BytesSent = atomic.LoadUint64(&p.meter.sent)
+		info.BytesRecv = atomic.LoadUint64(&p.meter.received)
+		info.LastSend = unixSeconds(atomic.LoadInt64(&p.meter.lastSent))
+		info.LastRecv = unixSeconds(atomic.LoadInt64(&p.meter.lastReceived))
+	}
+	if p.Td != nil {
+		info.BestHeight = p.Td.Int64()
+	}
+	if p.pingNonce != 0 {
+		info.PingWait = time.Since(p.pingSent).Seconds()
+	}
+
+	return info
+}
+
+// GetPeerInfo describes the peers of the node, by ID. It reads a snapshot
+// of the peer set, each peer under its lock, the connections may come and go
+// meanwhile
+func GetPeerInfo() []PeerInfo {
+	if Manager == nil {
+		return nil
+	}
+	peers := Manager.Peers.List()
+	infos := make([]PeerInfo, len(peers))
+	for i, p := range peers {
+		infos[i] = p.info()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	return infos
+}
+
+// peerInfo describes the peer of the p2p node id, for the peers of the
+// admin API
+func peerInfo(id discover.NodeID) interface{} {
+	if Manager == nil {
+		return nil
+	}
+	p := Manager.Peers.Peer(fmt.Sprintf("%x", id.Bytes()[:8]))
+	if p == nil {
+		return nil
+	}
+
+	return p.info()
+}
@@ -0,0 +1,120 @@
+package p2pprotocol
+
+import (
+	"testing"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPing(t *testing.T) {
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	Manager = &ProtocolManager{Peers: newPeerSet()}
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	a := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rwA)
+	b := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+	receivedA, receivedB := readCommands(rwA), readCommands(rwB)
+
+	// b answers the ping of a, a times it
+	send, err := a.checkPing(time.Now())
+	assert.True(t, send)
+	assert.Nil(t, err)
+	assert.Nil(t, a.sendPing())
+	HandleConnection(b, <-receivedB, nil)
+	pong := <-receivedA
+	assert.Equal(t, "pong", pong.Command)
+	HandleConnection(a, pong, nil)
+	assert.Nil(t, Manager.Peers.Register(a))
+	infos := GetPeerInfo()
+	if assert.Equal(t, 1, len(infos)) {
+		assert.Equal(t, a.id, infos[0].ID)
+		assert.True(t, infos[0].PingTime > 0)
+		assert.Equal(t, infos[0].PingTime, infos[0].MinPing)
+		assert.Equal(t, 0.0, infos[0].PingWait)
+	}
+	// a pong answering no ping changes nothing
+	HandleConnection(a, pong, nil)
+	assert.Equal(t, infos[0].PingTime, a.info().PingTime)
+
+	// a ping unanswered for PingTimeout is a dead peer
+	now := time.Now()
+	assert.Nil(t, a.sendPing())
+	<-receivedB
+	send, err = a.checkPing(now.Add(PingTimeout / 2))
+	assert.False(t, send)
+	assert.Nil(t, err)
+	_, err = a.checkPing(now.Add(PingTimeout + time.Second))
+	assert.Equal(t, errPingTimeout, err)
+
+	// unless a block asked for comes first
+	requestBlock(a, []byte{1})
+	<-receivedB
+	_, err = a.checkPing(now.Add(PingTimeout + time.Second))
+	assert.Nil(t, err)
+	_, err = a.checkPing(now.Add(blockTransferTimeout + time.Second))
+	assert.Equal(t, errPingTimeout, err)
+	a.lock.Lock()
+	a.pingNonce = 0
+	a.lock.Unlock()
+	send, err = a.checkPing(time.Now())
+	assert.False(t, send, "No ping during a block transfer")
+	assert.Nil(t, err)
+}
+
+func TestPingLoop(t *testing.T) {
+	defer func(interval, timeout time.Duration) {
+		PingInterval, PingTimeout = interval, timeout
+	}(PingInterval, PingTimeout)
+	PingInterval, PingTimeout = 10*time.Millisecond, 50*time.Millisecond
+	run := func(p *Peer) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			p.pingLoop()
+			close(done)
+		}()
+		return done
+	}
+
+	// the loop of a peer answering ends with the peer
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	a := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rwA)
+	b := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+	receivedA, receivedB := readCommands(rwA), readCommands(rwB)
+	done := run(a)
+	handle := func(p *Peer, received <-chan Command) {
+		for command := range received {
+			HandleConnection(p, command, nil)
+		}
+	}
+	go handle(a, receivedA)
+	go handle(b, receivedB)
+	select {
+	case <-done:
+		t.Fatal("The loop of a peer answering the pings ended")
+	case <-time.After(2 * PingTimeout):
+	}
+	a.close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The loop didn't end with the peer")
+	}
+
+	// that of a peer not answering ends once the ping times out
+	rwC, rwD := p2p.MsgPipe()
+	defer rwC.Close()
+	c := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{4}, "d", nil), rwC)
+	readCommands(rwD)
+	done = run(c)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The peer not answering wasn't dropped")
+	}
+	_, err := c.checkPing(time.Now())
+	assert.Equal(t, errPingTimeout, err)
+}
@@ -0,0 +1,281 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../p2p"
+)
+
+// trafficClass is the priority of a command under the rate limits
+type trafficClass int
+
+const (
+	classBlock trafficClass = iota // blocks, the messages syncing them and those keeping the connection
+	classTx                        // transactions relayed
+	classLow                       // addresses and mempools
+	numClasses
+)
+
+// classOf returns the traffic class of command
+func classOf(command string) trafficClass {
+	switch command {
+	case "tx":
+		return classTx
+	case "addr", "getaddr", "mempool":
+		return classLow
+	}
+
+	return classBlock
+}
+
+// RateLimit is a rate of messages and of bytes per second
+type RateLimit struct {
+	Messages float64
+	Bytes    float64
+}
+
+// The rate limits of each peer per traffic class, both ways. Past them the
+// low priority messages are dropped, the others wait. A peer can burst
+// rateBurst seconds of its limits
+var (
+	BlockRateLimit = RateLimit{Messages: 100, Bytes: 4 << 20}
+	TxRateLimit    = RateLimit{Messages: 100, Bytes: 1 << 20}
+	LowRateLimit   = RateLimit{Messages: 10, Bytes: 256 << 10}
+)
+
+// MaxUploadRate is the bytes per second sent to all the peers together, 0
+// for no limit. Past it the blocks wait for the budget, the transactions
+// and the low priority messages are dropped
+var MaxUploadRate float64
+
+const rateBurst = 2 // seconds of a rate limit a peer can burst
+
+// errRateLimited is returned for a message dropped over the rate limits
+var errRateLimited = errors.New("message dropped over the rate limits")
+
+// clock tells the time to the rate limiters and waits, the tests fake it
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// limiterClock is the clock of the rate limiters created
+var limiterClock clock = systemClock{}
+
+// tokenBucket holds up to rateBurst seconds of tokens of its rate, refilled
+// as the time passes. Tokens taken past those there leave it in debt
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: rate * rateBurst, tokens: rate * rateBurst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// wait returns how long until n tokens are there, n over the burst being
+// there once the bucket is full
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	b.tokens -= n
+}
+
+// rateLimiter shapes the messages of its traffic classes: the messages of
+// drop and the classes after it are dropped past the limits, the others
+// wait for the tokens they take
+type rateLimiter struct {
+	mu       sync.Mutex
+	clock    clock
+	drop     trafficClass
+	messages [numClasses]*tokenBucket // nil for no limit
+	bytes    [numClasses]*tokenBucket
+}
+
+// newPeerLimiter returns the rate limiter of one way of a peer
+func newPeerLimiter(c clock) *rateLimiter {
+	l := &rateLimiter{clock: c, drop: classLow}
+	now := c.Now()
+	for class, limit := range [numClasses]RateLimit{BlockRateLimit, TxRateLimit, LowRateLimit} {
+		l.messages[class] = newTokenBucket(limit.Messages, now)
+		l.bytes[class] = newTokenBucket(limit.Bytes, now)
+	}
+
+	return l
+}
+
+// newUploadLimiter returns the limiter of the bytes sent to all the peers,
+// of rate bytes per second shared by the classes
+func newUploadLimiter(c clock, rate float64) *rateLimiter {
+	l := &rateLimiter{clock: c, drop: classTx}
+	budget := newTokenBucket(rate, c.Now())
+	for class := range l.bytes {
+		l.bytes[class] = budget
+	}
+
+	return l
+}
+
+// admit returns whether a message of class and size bytes goes through,
+// after waiting for the limits when it has to
+func (l *rateLimiter) admit(class trafficClass, size int) bool {
+	l.mu.Lock()
+	now := l.clock.Now()
+	var wait time.Duration
+	if b := l.messages[class]; b != nil {
+		wait = b.wait(1, now)
+	}
+	if b := l.bytes[class]; b != nil {
+		if w := b.wait(float64(size), now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 && class >= l.drop {
+		l.mu.Unlock()
+		return false
+	}
+	if b := l.messages[class]; b != nil {
+		b.take(1)
+	}
+	if b := l.bytes[class]; b != nil {
+		b.take(float64(size))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		atomic.AddUint64(&bandwidthStats.Delayed, 1)
+		l.clock.Sleep(wait)
+	}
+	return true
+}
+
+// BandwidthStats are the counters of the bytes exchanged with the peers
+// and of the messages shaped by the rate limits
+type BandwidthStats struct {
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+	DroppedIn     uint64 `json:"dropped_in"`  // messages of the peers past their limits, ignored
+	DroppedOut    uint64 `json:"dropped_out"` // messages not sent past the limits
+	Delayed       uint64 `json:"delayed"`     // messages sent or read after waiting for the limits
+}
+
+// bandwidthStats are the counters of the node, updated atomically
+var bandwidthStats BandwidthStats
+
+// snapshot returns the counters read atomically
+func (s *BandwidthStats) snapshot() BandwidthStats {
+	return BandwidthStats{
+		BytesSent:     atomic.LoadUint64(&s.BytesSent),
+		BytesReceived: atomic.LoadUint64(&s.BytesReceived),
+		DroppedIn:     atomic.LoadUint64(&s.DroppedIn),
+		DroppedOut:    atomic.LoadUint64(&s.DroppedOut),
+		Delayed:       atomic.LoadUint64(&s.Delayed),
+	}
+}
+
+// meteredRW counts the bytes exchanged with a peer, and holds the rate
+// limits of each way
+type meteredRW struct {
+	p2p.MsgReadWriter
+	in, out *rateLimiter
+
+	sent, received uint64 // updated atomically
+	// lastSent and lastReceived are the Unix times in nanoseconds of the last
+	// message each way, 0 before the first, updated atomically
+	lastSent, lastReceived int64
+	exempt                 func() bool // whether the peer is past the limits, whitelisted
+}
+
+func newMeteredRW(rw p2p.MsgReadWriter) *meteredRW {
+	return &meteredRW{
+		MsgReadWriter: rw,
+		in:            newPeerLimiter(limiterClock),
+		out:           newPeerLimiter(limiterClock),
+	}
+}
+
+func (rw *meteredRW) ReadMsg() (p2p.Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err == nil {
+		atomic.AddUint64(&rw.received, uint64(msg.Size))
+		atomic.StoreInt64(&rw.lastReceived, time.Now().UnixNano())
+		atomic.AddUint64(&bandwidthStats.BytesReceived, uint64(msg.Size))
+	}
+
+	return msg, err
+}
+
+func (rw *meteredRW) WriteMsg(msg p2p.Msg) error {
+	size := msg.Size
+	err := rw.MsgReadWriter.WriteMsg(msg)
+	if err == nil {
+		atomic.AddUint64(&rw.sent, uint64(size))
+		atomic.StoreInt64(&rw.lastSent, time.Now().UnixNano())
+		atomic.AddUint64(&bandwidthStats.BytesSent, uint64(size))
+	}
+
+	return err
+}
+
+// admitOut returns whether command is sent, under the upload budget of the
+// node first, then the limits of the peer
+func (rw *meteredRW) admitOut(command Command) bool {
+	if rw.exempt != nil && rw.exempt() {
+		return true
+	}
+	class, size := classOf(command.Command), frameHeaderSize+len(command.Data)
+	if Manager != nil && Manager.upload != nil && !Manager.upload.admit(class, size) {
+		atomic.AddUint64(&bandwidthStats.DroppedOut, 1)
+		return false
+	}
+	if !rw.out.admit(class, size) {
+		atomic.AddUint64(&bandwidthStats.DroppedOut, 1)
+		return false
+	}
+
+	return true
+}
+
+// admitIn returns whether the command the peer sent is handled, after
+// waiting for the limits of the peer when it has to
+func (rw *meteredRW) admitIn(command Command) bool {
+	if rw.exempt != nil && rw.exempt() {
+		return true
+	}
+	if !rw.in.admit(classOf(command.Command), frameHeaderSize+len(command.Data)) {
+		atomic.AddUint64(&bandwidthStats.DroppedIn, 1)
+		return false
+	}
+
+	return true
+}
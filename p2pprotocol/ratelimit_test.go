@@ -0,0 +1,128 @@
+package p2pprotocol
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock moves only when slept on or advanced
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.slept += d
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) sleeping() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slept
+}
+
+func TestRateLimiter(t *testing.T) {
+	defer func(block, low RateLimit) {
+		BlockRateLimit, LowRateLimit = block, low
+	}(BlockRateLimit, LowRateLimit)
+	BlockRateLimit = RateLimit{Messages: 1, Bytes: 1000}
+	LowRateLimit = RateLimit{Messages: 2, Bytes: 1000}
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	l := newPeerLimiter(clock)
+
+	// the low priority messages past the burst are dropped, until the
+	// buckets refill
+	for i := 0; i < 2*rateBurst; i++ {
+		assert.True(t, l.admit(classLow, 10))
+	}
+	assert.False(t, l.admit(classLow, 10))
+	clock.advance(500 * time.Millisecond)
+	assert.True(t, l.admit(classLow, 10))
+	assert.False(t, l.admit(classLow, 10))
+	// one over the byte burst once the bucket is full
+	assert.False(t, l.admit(classLow, 2001))
+	clock.advance(10 * time.Second)
+	assert.True(t, l.admit(classLow, 2001))
+	assert.False(t, l.admit(classLow, 10))
+	clock.advance(time.Second)
+	assert.True(t, l.admit(classLow, 10))
+	assert.Equal(t, time.Duration(0), clock.sleeping())
+
+	// the blocks wait for the tokens they take
+	assert.True(t, l.admit(classBlock, 10))
+	assert.True(t, l.admit(classBlock, 10))
+	assert.Equal(t, time.Duration(0), clock.sleeping())
+	assert.True(t, l.admit(classBlock, 10))
+	assert.Equal(t, time.Second, clock.sleeping())
+	// one over the burst once the bucket is full
+	clock.advance(10 * time.Second)
+	assert.True(t, l.admit(classBlock, 5000))
+	assert.Equal(t, time.Second, clock.sleeping())
+	assert.True(t, l.admit(classBlock, 1000))
+	assert.Equal(t, 5*time.Second, clock.sleeping(), "Waiting for the debt of the big block")
+}
+
+func TestBandwidth(t *testing.T) {
+	defer func(m *ProtocolManager, c clock) { Manager, limiterClock = m, c }(Manager, limiterClock)
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	limiterClock = clock
+	Manager = &ProtocolManager{Peers: newPeerSet(), upload: newUploadLimiter(clock, 1000)}
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	a := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rwA)
+	b := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+	received := readCommands(b.Rw)
+	dropped := bandwidthStats.snapshot().DroppedOut
+
+	// past the upload budget the transactions are dropped, the blocks wait
+	tx := Command{"tx", make([]byte, 500)}
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, sendDataC(a.Rw, tx))
+	}
+	assert.Equal(t, errRateLimited, sendDataC(a.Rw, tx))
+	assert.Equal(t, dropped+1, bandwidthStats.snapshot().DroppedOut)
+	assert.Equal(t, time.Duration(0), clock.sleeping())
+	assert.Nil(t, sendDataC(a.Rw, Command{"block", make([]byte, 500)}))
+	assert.Equal(t, 96*time.Millisecond, clock.sleeping())
+
+	var commands []string
+	for i := 0; i < 4; i++ {
+		commands = append(commands, (<-received).Command)
+	}
+	assert.Equal(t, []string{"tx", "tx", "tx", "block"}, commands)
+	sent := a.info().BytesSent
+	assert.True(t, sent > 4*(frameHeaderSize+500))
+	assert.Equal(t, sent, b.info().BytesRecv)
+	assert.Equal(t, uint64(0), a.info().BytesRecv)
+
+	// the peer sending more addresses than its limits has the excess dropped
+	admitted := 0
+	for i := 0; i < 4*rateBurst*int(LowRateLimit.Messages); i++ {
+		if b.meter.admitIn(Command{Command: "addr"}) {
+			admitted++
+		}
+	}
+	assert.Equal(t, rateBurst*int(LowRateLimit.Messages), admitted)
+	assert.True(t, b.meter.admitIn(Command{Command: "block"}))
+}
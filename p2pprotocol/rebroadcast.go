@@ -0,0 +1,111 @@
+package p2pprotocol
+
+import (
+	"encoding/hex"
+	"log"
+	"math/rand"
+	"time"
+
+	"../blockchain_go"
+)
+
+// the transactions of the wallets still pending are announced again at a
+// random time between, so the peers can't tell them from those relayed
+const (
+	rebroadcastMin = 15 * time.Minute
+	rebroadcastMax = 30 * time.Minute
+)
+
+// rebroadcastDelay returns the time until the next rebroadcast
+func rebroadcastDelay() time.Duration {
+	return rebroadcastMin + time.Duration(rand.Int63n(int64(rebroadcastMax-rebroadcastMin)))
+}
+
+// TrackWalletTx keeps announcing tx, a transaction of the wallets of the
+// node, until it's confirmed or drops out of the mempool, see
+// rebroadcastLoop
+func (pm *ProtocolManager) TrackWalletTx(tx *core.Transaction) {
+	pm.walletLock.Lock()
+	defer pm.walletLock.Unlock()
+	if pm.walletTxs == nil {
+		pm.walletTxs = make(map[string]bool)
+	}
+	pm.walletTxs[hex.EncodeToString(tx.ID)] = true
+}
+
+// unconfirmedWalletTxs returns the wallet transactions still pending, in
+// the order of the mempool. Those gone from it, mined or conflicting with
+// a block, are no longer tracked
+func (pm *ProtocolManager) unconfirmedWalletTxs() []*core.Transaction {
+	pm.walletLock.Lock()
+	defer pm.walletLock.Unlock()
+	var txs []*core.Transaction
+	pending := make(map[string]bool, len(pm.walletTxs))
+	for _, tx := range pm.TxMempool.Transactions() {
+		id := hex.EncodeToString(tx.ID)
+		if pm.walletTxs[id] {
+			pending[id] = true
+			txs = append(txs, tx)
+		}
+	}
+	for id := range pm.walletTxs {
+		if !pending[id] {
+			log.Printf("wallet transaction %s left the mempool, no longer rebroadcast", id)
+			delete(pm.walletTxs, id)
+		}
+	}
+
+	return txs
+}
+
+// confirmWalletTxs stops tracking the wallet transactions block includes
+func (pm *ProtocolManager) confirmWalletTxs(block *core.Block) {
+	pm.walletLock.Lock()
+	defer pm.walletLock.Unlock()
+	for _, tx := range block.Transactions {
+		delete(pm.walletTxs, hex.EncodeToString(tx.ID))
+	}
+}
+
+// rebroadcast announces the unconfirmed wallet transactions to peers with an
+// inv, even to those they were announced to already: an inv lost or
+// forgotten is the likely reason they're still pending, and a peer having
+// them doesn't ask for them. Peers older than invVersion aren't sent them
+// whole again
+func (pm *ProtocolManager) rebroadcast(peers []*Peer) {
+	txs := pm.unconfirmedWalletTxs()
+	if len(txs) == 0 {
+		return
+	}
+	items := make([][]byte, len(txs))
+	for i, tx := range txs {
+		items[i] = tx.ID
+	}
+	for _, p := range peers {
+		if p.protocolVersion() < invVersion {
+			continue
+		}
+		for _, item := range items {
+			p.MarkTransaction(item)
+		}
+		if err := sendInv(p.Rw, "tx", items); err != nil {
+			log.Printf("rebroadcasting %d wallet transactions to peer %s: %v", len(items), p.id, err)
+		}
+	}
+}
+
+// rebroadcastLoop rebroadcasts the unconfirmed wallet transactions to all the
+// peers every rebroadcastDelay, until quit is closed
+func (pm *ProtocolManager) rebroadcastLoop(quit <-chan struct{}) {
+	timer := time.NewTimer(rebroadcastDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-timer.C:
+			pm.rebroadcast(pm.Peers.List())
+			timer.Reset(rebroadcastDelay())
+		}
+	}
+}
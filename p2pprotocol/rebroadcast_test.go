@@ -0,0 +1,115 @@
+package p2pprotocol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyRW loses the first drop messages written
+type flakyRW struct {
+	p2p.MsgReadWriter
+	drop int
+}
+
+func (rw *flakyRW) WriteMsg(msg p2p.Msg) error {
+	if rw.drop > 0 {
+		rw.drop--
+		return nil
+	}
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+func TestRebroadcastDelay(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		delay := rebroadcastDelay()
+		assert.True(t, delay >= rebroadcastMin && delay < rebroadcastMax, delay.String())
+	}
+}
+
+func TestRebroadcast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+
+	wallet := core.NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	other := fmt.Sprintf("%s", core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "rebroadcasttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	UTXOSet.Reindex()
+	_, err = bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+	assert.Nil(t, err)
+	tx, err := core.NewUTXOTransaction(wallet, other, 1, &UTXOSet, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node a sent tx of its wallet, the link to node b loses the
+	// announcement
+	quit := make(chan struct{})
+	defer close(quit)
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	a := testNode{pm: newTestManager(quit, tx), received: readCommands(rwA)}
+	a.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), &flakyRW{MsgReadWriter: rwA, drop: 1})
+	b := testNode{pm: newTestManager(quit), received: readCommands(rwB)}
+	b.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+	a.pm.TrackWalletTx(tx)
+	Manager = a.pm
+	SendTx(a.peer, a.peer.Rw, tx)
+	// b is taken to know it, the relay doesn't announce it again
+	SendTx(a.peer, a.peer.Rw, tx)
+	assert.Empty(t, b.received)
+
+	// the rebroadcast announces it anyway, b asks for it and admits it
+	a.pm.rebroadcast([]*Peer{a.peer})
+	b.handle(1, bc)
+	a.handle(1, bc)
+	b.handle(1, bc)
+	assert.NotNil(t, b.pm.TxMempool.Get(fmt.Sprintf("%x", tx.ID)))
+
+	// a peer connecting is announced it once synced, after the mempool
+	// request, an old one isn't sent it
+	a.peer.mempoolAsked = false
+	Manager = a.pm
+	requestMempool(a.peer)
+	expectCommand(t, b.received, "mempool")
+	var announced inv
+	assert.Nil(t, gobDecode(expectCommand(t, b.received, "inv").Data, &announced))
+	assert.Equal(t, [][]byte{tx.ID}, announced.Items)
+	rwOld, remoteOld := p2p.MsgPipe()
+	defer rwOld.Close()
+	oldReceived := readCommands(remoteOld)
+	a.pm.rebroadcast([]*Peer{newPeer(handshakeVersion, p2p.NewPeer(discover.NodeID{3}, "c", nil), rwOld)})
+	assert.Empty(t, oldReceived)
+
+	// a transaction confirmed or gone from the mempool is no longer
+	// announced
+	gone := core.NewCoinbaseTX(address, "gone")
+	a.pm.TrackWalletTx(gone)
+	assert.Equal(t, []*core.Transaction{tx}, a.pm.unconfirmedWalletTxs())
+	assert.NotContains(t, a.pm.walletTxs, fmt.Sprintf("%x", gone.ID))
+	a.pm.confirmWalletTxs(&core.Block{Transactions: []*core.Transaction{tx}})
+	assert.Empty(t, a.pm.unconfirmedWalletTxs())
+	a.pm.rebroadcast([]*Peer{a.peer})
+	assert.Empty(t, b.received)
+}
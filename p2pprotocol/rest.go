@@ -0,0 +1,169 @@
+package p2pprotocol
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"../blockchain_go"
+)
+
+// RESTAddr is the address of the REST listener StartServer starts, e.g.
+// 127.0.0.1:8334, none when empty
+var RESTAddr = ""
+
+// restTimeout bounds the time a REST request is read and answered in
+const restTimeout = 30 * time.Second
+
+// restHandler serves the read-only queries of the swc API as plain HTTP GET
+// requests answered with JSON:
+//
+//	GET /block/{hash or height}
+//	GET /tx/{txid}
+//	GET /address/{address}/balance
+//	GET /address/{address}/utxos
+//	GET /chaininfo
+//
+// An object the chain doesn't hold is a 404, a malformed identifier a 400.
+// The paths are routed as they come rather than cleaned, an identifier
+// holding a slash or a dot segment is malformed
+type restHandler struct {
+	api *SWCAPI
+}
+
+func newRESTHandler(api *SWCAPI) *restHandler {
+	return &restHandler{api: api}
+}
+
+// startREST starts the REST listener on RESTAddr, serving the chain of
+// nodeID
+func startREST(nodeID string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", RESTAddr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{
+		Handler:      newRESTHandler(NewSWCAPI(nodeID)),
+		ReadTimeout:  restTimeout,
+		WriteTimeout: restTimeout,
+	}
+	go server.Serve(listener)
+
+	return listener, nil
+}
+
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeRESTError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/block/"):
+		h.block(w, r)
+	case strings.HasPrefix(path, "/tx/"):
+		h.tx(w, r)
+	case strings.HasPrefix(path, "/address/"):
+		h.address(w, r)
+	case path == "/chaininfo":
+		h.chainInfo(w, r)
+	default:
+		h.notFound(w, r)
+	}
+}
+
+// restStatus returns the HTTP status of an error of the swc API
+func restStatus(err error) int {
+	if _, ok := err.(*invalidParamsError); ok {
+		return http.StatusBadRequest
+	}
+	switch err {
+	case core.ErrBlockNotFound, core.ErrTxNotFound:
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeRESTError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// writeREST writes result, or the status of err with its message
+func writeREST(w http.ResponseWriter, result interface{}, err error) {
+	if err != nil {
+		writeRESTError(w, restStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// restID returns the identifier of the path after prefix, refused unless it
+// is a single non-empty segment
+func restID(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	id := strings.TrimPrefix(r.URL.Path, prefix)
+	if id == "" || strings.Contains(id, "/") {
+		writeRESTError(w, http.StatusBadRequest, "malformed path "+strconv.Quote(r.URL.Path))
+		return "", false
+	}
+
+	return id, true
+}
+
+func (h *restHandler) block(w http.ResponseWriter, r *http.Request) {
+	id, ok := restID(w, r, "/block/")
+	if !ok {
+		return
+	}
+	// a hash is 64 hex digits, a height fewer decimal ones
+	if height, err := strconv.ParseInt(id, 10, 64); err == nil && len(id) < 64 {
+		block, err := h.api.GetBlockByHeight(height)
+		writeREST(w, block, err)
+		return
+	}
+	block, err := h.api.GetBlockByHash(id)
+	writeREST(w, block, err)
+}
+
+func (h *restHandler) tx(w http.ResponseWriter, r *http.Request) {
+	id, ok := restID(w, r, "/tx/")
+	if !ok {
+		return
+	}
+	tx, err := h.api.GetTransaction(id)
+	writeREST(w, tx, err)
+}
+
+func (h *restHandler) address(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/address/"), "/")
+	if len(segments) != 2 || segments[0] == "" {
+		writeRESTError(w, http.StatusBadRequest, "malformed path "+strconv.Quote(r.URL.Path))
+		return
+	}
+	switch segments[1] {
+	case "balance":
+		balance, err := h.api.GetBalance(segments[0])
+		writeREST(w, balance, err)
+	case "utxos":
+		unspent, err := h.api.GetUTXOs(segments[0])
+		writeREST(w, unspent, err)
+	default:
+		h.notFound(w, r)
+	}
+}
+
+func (h *restHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	writeRESTError(w, http.StatusNotFound, "no such resource "+strconv.Quote(r.URL.Path))
+}
+
+func (h *restHandler) chainInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := h.api.GetChainInfo()
+	writeREST(w, info, err)
+}
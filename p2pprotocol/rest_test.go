@@ -0,0 +1,117 @@
+package p2pprotocol
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"../blockchain_go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestREST(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+
+	// a chain of three blocks paying to address
+	address := string(core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "resttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.UTXOSet{Blockchain: bc}.Reindex()
+	var blocks []*core.Block
+	for i := 0; i < 2; i++ {
+		block, err := bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	bc.Close()
+	quit := make(chan struct{})
+	defer close(quit)
+	Manager = newTestManager(quit)
+	openChainsAgain()
+	handler := newRESTHandler(NewSWCAPI("resttest"))
+
+	get := func(path string, result interface{}) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), path)
+		if result != nil && w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), result), path)
+		}
+		return w.Code
+	}
+
+	var info core.ChainInfo
+	assert.Equal(t, http.StatusOK, get("/chaininfo", &info))
+	assert.Equal(t, int64(2), info.Height)
+	assert.Equal(t, hex.EncodeToString(blocks[1].Hash), info.BestBlock)
+	assert.Equal(t, "", info.Path)
+
+	// a block by height or by hash
+	var block, byHash RPCBlock
+	hash := hex.EncodeToString(blocks[0].Hash)
+	assert.Equal(t, http.StatusOK, get("/block/1", &block))
+	assert.Equal(t, hash, block.Hash)
+	assert.Equal(t, http.StatusOK, get("/block/"+hash, &byHash))
+	assert.Equal(t, block, byHash)
+
+	var tx RPCTransaction
+	assert.Equal(t, http.StatusOK, get("/tx/"+block.Transactions[0], &tx))
+	assert.True(t, tx.Coinbase)
+	assert.Equal(t, hash, tx.BlockHash)
+
+	var balance RPCBalance
+	assert.Equal(t, http.StatusOK, get("/address/"+address+"/balance", &balance))
+	assert.True(t, balance.Confirmed > 0)
+	var unspent []core.UnspentOutput
+	assert.Equal(t, http.StatusOK, get("/address/"+address+"/utxos", &unspent))
+	assert.Equal(t, 3, len(unspent))
+	assert.Equal(t, http.StatusOK, get("/address/"+string(core.NewWallet().GetAddress())+"/utxos", &unspent))
+	assert.Equal(t, 0, len(unspent))
+
+	// an unknown object is a 404, a malformed identifier a 400
+	unknown := strings.Repeat("ab", 32)
+	assert.Equal(t, http.StatusNotFound, get("/block/100", nil))
+	assert.Equal(t, http.StatusNotFound, get("/block/"+unknown, nil))
+	assert.Equal(t, http.StatusNotFound, get("/tx/"+unknown, nil))
+	assert.Equal(t, http.StatusNotFound, get("/address/"+address+"/history", nil))
+	assert.Equal(t, http.StatusNotFound, get("/wallet", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/block/-1", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/block/zz", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/block/abcd", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/block/", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/tx/"+unknown+"00", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/address/not-an-address/balance", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/address//balance", nil))
+
+	// an identifier of several segments is refused, whatever they hold
+	assert.Equal(t, http.StatusBadRequest, get("/tx/"+unknown+"%2F..%2F..%2Fetc%2Fpasswd", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/address/"+address+"%2F..%2Fbalance/utxos", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/tx/..%2F..%2Fetc%2Fpasswd", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/block/../chaininfo", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/tx/..", nil))
+
+	// read-only
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/chaininfo", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+}
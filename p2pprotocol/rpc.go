@@ -0,0 +1,56 @@
+package p2pprotocol
+
+import (
+	"net"
+	"strconv"
+
+	"../rpc"
+)
+
+// RPCPort is the port of the JSON-RPC listener StartServer starts, none
+// when 0
+var RPCPort = 0
+
+// RPCHost is the address the JSON-RPC listener is bound to, the local
+// machine only by default. The admin API is served to whoever reaches it
+var RPCHost = "127.0.0.1"
+
+// WSPort is the port of the WebSocket JSON-RPC listener StartServer starts,
+// bound to RPCHost, none when 0. The subscriptions are served over it only
+var WSPort = 0
+
+// swcAPIs returns the services of the chain of nodeID
+func swcAPIs(nodeID string) []rpc.API {
+	return []rpc.API{{
+		Namespace: "swc",
+		Version:   "1.0",
+		Service:   NewSWCAPI(nodeID),
+		Public:    true,
+	}}
+}
+
+// adminAPIs returns the services managing the connections of the node
+func adminAPIs() []rpc.API {
+	return []rpc.API{{
+		Namespace: "admin",
+		Version:   "1.0",
+		Service:   &AdminAPI{},
+	}}
+}
+
+// startRPC starts the JSON-RPC listener on RPCHost:RPCPort, serving the swc
+// API of the chain of nodeID and the admin API over HTTP
+func startRPC(nodeID string) (net.Listener, *rpc.Server, error) {
+	endpoint := net.JoinHostPort(RPCHost, strconv.Itoa(RPCPort))
+	apis := append(swcAPIs(nodeID), adminAPIs()...)
+
+	return rpc.StartHTTPEndpoint(endpoint, apis, []string{"swc", "admin"}, nil, []string{"localhost"})
+}
+
+// startWS starts the WebSocket JSON-RPC listener on RPCHost:WSPort, serving
+// the swc API and its subscriptions to the pages of localhost
+func startWS(nodeID string) (net.Listener, *rpc.Server, error) {
+	endpoint := net.JoinHostPort(RPCHost, strconv.Itoa(WSPort))
+
+	return rpc.StartWSEndpoint(endpoint, swcAPIs(nodeID), []string{"swc"}, nil, false)
+}
@@ -2,8 +2,10 @@ package p2pprotocol
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -19,33 +21,48 @@ import (
 	"math/rand"
 	"os"
 	."../boltqueue"
-	"gopkg.in/fatih/set.v0"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"../node"
+	"../rpc"
 	//"github.com/ethereum/go-ethereum/internal/debug"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
 const protocol = "tcp"
-const nodeVersion = 1
+const nodeVersion = compactVersion
 const commandLength = 12
 // This is the target size for the packs of transactions sent by txsyncLoop.
 // A pack can get larger than this if a single transactions exceeds this size.
 const txsyncPackSize = 100 * 1024
 
 var nodeAddress string
+// ListenAddr is the host:port of -listen the node binds and advertises, the
+// nodeID of StartServer when empty, as nodes named by their address did
+var ListenAddr string
 var miningAddress string
 var BootNodes = []string{"192.168.1.101:2000"}
 var BootPeers = []*discover.Node{}
 var CurrentNodeInfo *p2p.NodeInfo
-var blocksInTransit = [][]byte{}
-var blocksInTransitSet = set.New()
 
 var send = make(chan interface{}, 1)
 
 var node_id string
 var Manager *ProtocolManager
+// NodeWallets are the wallets of this node, their balance cache follows
+// the blocks added to the chain
+var NodeWallets *core.Wallets
+
+func init() {
+	// GetBalance counts the transactions waiting to be mined
+	core.MempoolTransactions = func() []*core.Transaction {
+		if Manager == nil {
+			return nil
+		}
+		return Manager.TxMempool.Transactions()
+	}
+}
 
 var (
 	testNodeKey, _ = crypto.GenerateKey()
@@ -57,6 +74,9 @@ type Node struct {
 
 type addr struct {
 	AddrList []string
+	// Addresses are the addresses of the nodes the sender knows, see
+	// addrManager, AddrList is left empty
+	Addresses []netAddress
 }
 
 type block struct {
@@ -67,6 +87,11 @@ type block struct {
 type getblocks struct {
 	AddrFrom string
 	LastHash string
+	// Locator is the block locator of the sender, see core.BlockLocator,
+	// empty from older nodes sending their tip as LastHash only. The peer
+	// answers with at most core.MaxBlockHashes hashes, none after StopHash
+	Locator  [][]byte
+	StopHash []byte
 }
 
 type getdata struct {
@@ -91,6 +116,22 @@ type verzion struct {
 	BestHeight *big.Int
 	LastHash string
 	AddrFrom   string
+	// Timestamp is the unix time of the sender, 0 from older nodes
+	Timestamp  int64
+	// Magic and GenesisHash tell the network of the sender, empty from
+	// older nodes
+	Magic       uint32
+	GenesisHash []byte
+	// BlockVersion is the newest block format the sender decodes, 0 from
+	// nodes decoding gob encoded blocks only
+	BlockVersion int
+	// PrunedHeight is the height up to which the sender deleted block
+	// bodies and can't serve them, 0 from nodes keeping them all
+	PrunedHeight int64
+	// Services are the Service flags of the sender, and Nonce is new for
+	// each handshake, see handshake
+	Services uint64
+	Nonce    uint64
 }
 
 type Command struct {
@@ -131,25 +172,19 @@ func requestBlocks() {
 	//}
 }
 
-func sendAddr(address p2p.MsgWriter) {
-	nodes := addr{BootNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddress)
-	payload := gobEncode(nodes)
-	//request := append(commandToBytes("addr"), payload...)
-	command := Command{
-		Command:"addr",
-		Data:payload,
-	}
-
-	sendDataC(address, command)
-}
-
-func sendBlock(addr p2p.MsgWriter, b *core.Block) error{
-	fmt.Printf("send Block %s \n", b)
+// sendBlock sends a block encoded in the newest format the peer decodes,
+// see verzion.BlockVersion
+func sendBlock(p *Peer, b *core.Block) error{
+	fmt.Printf("send Block %v \n", b)
 	fmt.Printf("send Block hash %x \n", b.Hash)
-	data := block{nodeAddress, b.Serialize()}
-
-	fmt.Printf("send Block len %n \n", len(data.Block))
+	p.lock.RLock()
+	blockVersion := p.blockVersion
+	p.lock.RUnlock()
+	data := block{nodeAddress, b.SerializeFor(blockVersion)}
+
+	fmt.Printf("send Block len %d \n", len(data.Block))
+	atomic.AddUint64(&inventoryStats.BlocksSent, 1)
+	atomic.AddUint64(&inventoryStats.BytesSent, uint64(len(data.Block)))
 	payload := gobEncode(data)
 	//request := append(commandToBytes("block"), payload...)
 	command := Command{
@@ -157,7 +192,7 @@ func sendBlock(addr p2p.MsgWriter, b *core.Block) error{
 		Data:payload,
 	}
 
-	return sendDataC(addr, command)
+	return sendDataC(p.Rw, command)
 }
 /*
 func sendData(addr string, data []byte) {
@@ -231,11 +266,14 @@ func sendData(addr string, data []byte) {
 }*/
 
 func sendDataC(w p2p.MsgWriter, data Command) error{
-	err := p2p.Send(w, StatusMsg, &data)
-	return err
+	if rw, ok := w.(*meteredRW); ok && !rw.admitOut(data) {
+		return errRateLimited
+	}
+	return framer().WriteCommand(w, data)
 }
 
 func sendInv(addr p2p.MsgWriter, kind string, items [][]byte) error{
+	atomic.AddUint64(&inventoryStats.InvSent, uint64(len(items)))
 	inventory := inv{nodeAddress, kind, items}
 	payload := gobEncode(inventory)
 	//request := append(commandToBytes("inv"), payload...)
@@ -248,8 +286,16 @@ func sendInv(addr p2p.MsgWriter, kind string, items [][]byte) error{
 	return sendDataC(addr, command)
 }
 
-func sendGetBlocks(addr p2p.MsgWriter, lastHash string) {
-	payload := gobEncode(getblocks{nodeAddress,lastHash})
+// sendGetBlocks asks for the hashes of the blocks after the chain of bc,
+// those of the first core.MaxBlockHashes; handleBlock asks for the next ones
+// once they are downloaded
+func sendGetBlocks(addr p2p.MsgWriter, bc *core.Blockchain) {
+	locator, err := bc.BlockLocator()
+	if err != nil {
+		log.Println("building the block locator failed:", err)
+		return
+	}
+	payload := gobEncode(getblocks{nodeAddress, hex.EncodeToString(locator[0]), locator, nil})
 	//request := append(commandToBytes("getblocks"), payload...)
 
 	command := Command{
@@ -261,6 +307,7 @@ func sendGetBlocks(addr p2p.MsgWriter, lastHash string) {
 }
 
 func sendGetData(addr p2p.MsgWriter, kind string, id []byte) {
+	atomic.AddUint64(&inventoryStats.GetDataSent, 1)
 	payload := gobEncode(getdata{nodeAddress, kind, id})
 	//request := append(commandToBytes("getdata"), payload...)
 
@@ -272,7 +319,24 @@ func sendGetData(addr p2p.MsgWriter, kind string, id []byte) {
 	sendDataC(addr, command)
 }
 
+// SendTx announces tnx to the peer with an inv, the peer asks for it with
+// getdata unless it has it already. It isn't announced to a peer known to
+// have it, a peer older than invVersion is sent it whole
 func SendTx(p *Peer,addr p2p.MsgWriter, tnx *core.Transaction) {
+	if p.knownTxs.Has(tnx.ID) {
+		atomic.AddUint64(&inventoryStats.InvSkipped, 1)
+		return
+	}
+	if p.protocolVersion() < invVersion {
+		sendTx(p, tnx)
+		return
+	}
+	p.MarkTransaction(tnx.ID)
+	sendInv(addr, "tx", [][]byte{tnx.ID})
+}
+
+// sendTx sends tnx whole, the peer asked for it with getdata
+func sendTx(p *Peer, tnx *core.Transaction) error {
 	fmt.Printf("tnx.Size()  %s\n", tnx.Size())
 
 	data := tx{nodeAddress, tnx.Serialize()}
@@ -280,18 +344,26 @@ func SendTx(p *Peer,addr p2p.MsgWriter, tnx *core.Transaction) {
 	//request := append(commandToBytes("tx"), payload...)
 
 	p.MarkTransaction(tnx.ID)
+	atomic.AddUint64(&inventoryStats.TxsSent, 1)
+	atomic.AddUint64(&inventoryStats.BytesSent, uint64(len(data.Transaction)))
 
 	command := Command{
 		Command:"tx",
 		Data:payload,
 	}
 
-	sendDataC(addr, command)
+	return sendDataC(p.Rw, command)
 }
 
-func SendVersion(addr p2p.MsgWriter, bc *core.Blockchain) {
-	bestHeight,lastHash := bc.GetBestHeight()
-	payload := gobEncode(verzion{nodeVersion, bestHeight,lastHash, nodeAddress})
+// SendVersion sends the version of the chain to addr, it fails when the
+// chain can't be read
+func SendVersion(addr p2p.MsgWriter, bc *core.Blockchain) error {
+	version, err := newVersion(bc)
+	if err != nil {
+		return err
+	}
+	bestHeight := version.BestHeight
+	payload := gobEncode(version)
 	//request := append(commandToBytes("version"), payload...)
 
 	Manager.BigestTd = bestHeight
@@ -302,7 +374,7 @@ func SendVersion(addr p2p.MsgWriter, bc *core.Blockchain) {
 	}
 	log.Print("send version --",bestHeight)
 
-	sendDataC(addr, command)
+	return sendDataC(addr, command)
 }
 
 func SendVersionStartConflict(addr p2p.MsgWriter, historyLasthash []byte, bc *core.Blockchain) {
@@ -312,7 +384,11 @@ func SendVersionStartConflict(addr p2p.MsgWriter, historyLasthash []byte, bc *co
 	}
 	bestHeight := historyLastblock.Height
 	lasthash := hex.EncodeToString(historyLasthash)
-	version := verzion{nodeVersion, bestHeight,lasthash, nodeAddress}
+	pruned, err := bc.PrunedHeight()
+	if err != nil {
+		log.Println("reading the pruned height:", err)
+	}
+	version := verzion{nodeVersion, bestHeight,lasthash, nodeAddress, time.Now().Unix(), core.ActiveNetParams.Genesis.Magic, bc.GenesisHash, core.MaxBlockVersion, pruned, localServices(pruned), rand.Uint64()}
 	payload := gobEncode(version)
 	//request := append(commandToBytes("version"), payload...)
 
@@ -325,25 +401,9 @@ func SendVersionStartConflict(addr p2p.MsgWriter, historyLasthash []byte, bc *co
 	sendDataC(addr, command)
 }
 
-func handleAddr(command Command) {
-	var buff bytes.Buffer
-	var payload addr
-
-	buff.Write(command.Data)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	BootNodes = append(BootNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(BootNodes))
-	requestBlocks()
-}
-
 /**
  1 validate every incoming block before adding it to the blockchain.
- 2 Instead of running UTXOSet.Reindex(), UTXOSet.Update(block) should be used,
+ 2 Instead of running UTXOSet.Reindex(), the block is applied to the UTXO set as it is stored,
 because if blockchain is big,it’ll take a lot of time to reindex the whole UTXO set.
  */
 func handleBlock(p *Peer, command Command, bc *core.Blockchain) {
@@ -354,67 +414,149 @@ func handleBlock(p *Peer, command Command, bc *core.Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		malformed(p, command, err)
+		return
 	}
 
 	blockData := payload.Block
 	fmt.Println("Recevied new Block len %n \n", len(blockData))
-	block := core.DeserializeBlock(blockData)
-	fmt.Println("Recevied new Block hash %x \n", block.Hash)
-
-	valid,reason := bc.IsBlockValid(block)
-	if( valid ){
-		if(!p.knownBlocks.Has(hex.EncodeToString(block.Hash))){
-			bc.AddBlock(block)
-			p.lock.RLock()
-			//Manager.CurrTd = block.Height
-			p.knownBlocks.Add(hex.EncodeToString(block.Hash))
-			//defer p.lock.RUnlock()
-			p.lock.RUnlock()
-		}
-
-		time := time.Now()
-		block.ReceivedAt = time
+	// the encoding a block is checked against, before decoding it
+	if len(blockData) > core.ActiveNetParams.MaxBlockSerializedSize {
+		fmt.Printf("Block from peer %s rejected: %s\n", p.id, core.ErrBlockTooBig)
+		p.Misbehaving(scoreTooBig, core.ErrBlockTooBig.Error())
+		return
+	}
+	block, err := core.DecodeBlock(blockData)
+	if err != nil {
+		malformed(p, command, err)
+		return
+	}
+	fmt.Printf("Recevied new Block hash %x \n", block.Hash)
+	atomic.AddUint64(&inventoryStats.BlocksReceived, 1)
+	atomic.AddUint64(&inventoryStats.BytesReceived, uint64(len(blockData)))
+	receiveBlock(p, block, bc)
+}
 
-		pending := Manager.TxMempool
-		//fmt.Println("---len(pending) ",len(pending))
-		for _, tx := range pending {
-			//fmt.Println("---syncTransactions ")
-			if(block.HasTransactions(tx.ID)){
-				delete(Manager.TxMempool, hex.EncodeToString(tx.ID))
+// receiveBlock processes a block the peer sent, in full or rebuilt from a
+// compact block
+func receiveBlock(p *Peer, block *core.Block, bc *core.Blockchain) {
+	requested.remove(block.Hash)
+	p.lock.Lock()
+	p.blockRequested = time.Time{}
+	if p.compactBlock != nil && bytes.Equal(p.compactBlock.block.Hash, block.Hash) {
+		p.compactBlock = nil
+	}
+	p.lock.Unlock()
+	// the peer isn't announced the block it sent, whether it's kept or not
+	p.MarkBlock(block.Hash)
+
+	// the blocks of a download are processed in height order, once those
+	// below them are, while the peers are assigned the next ones
+	if Manager.download != nil && Manager.download.deliver(p, block, func(from *Peer, b *core.Block) bool {
+		return acceptBlock(from, b, bc)
+	}) {
+		if source, done := Manager.download.complete(); done {
+			if source == nil {
+				source = p
 			}
+			blocksSynced(source, bc)
+		} else {
+			Manager.download.schedule(Manager.Peers.List())
 		}
-		//Manager.BroadcastBlock(block,true)
-	}else{
-		fmt.Printf("Block not Valid reason %d  %x\n",reason,block.Hash)
 		return
 	}
+	if !acceptBlock(p, block, bc) {
+		return
+	}
+	blocksSynced(p, bc)
+}
+
+// acceptBlock processes a block the peer sent, and returns whether the
+// sync goes on. A block sent twice, validating or connected already, isn't
+// validated nor stored again, the sync goes on. A rejected one ends it
+func acceptBlock(p *Peer, block *core.Block, bc *core.Blockchain) bool {
+	switch seenBlocks.receive(block.Hash, p) {
+	case blockRejected:
+		fmt.Printf("Block %x from peer %s was rejected already\n", block.Hash, p.id)
+		return false
+	case blockValidating, blockStored:
+		fmt.Printf("Block %x from peer %s is already received\n", block.Hash, p.id)
+		return true
+	}
+	status, err := bc.ClassifyBlock(block)
+	if err == nil && status == core.BlockDuplicate {
+		atomic.AddUint64(&inventoryStats.Duplicates, 1)
+		seenBlocks.processed(block.Hash, blockStored)
+		fmt.Printf("Block %x from peer %s is already stored\n", block.Hash, p.id)
+		return true
+	}
+	if !processBlock(p, block, bc) {
+		return false
+	}
 	fmt.Printf("Added block %x\n", block.Hash)
 
-	fmt.Printf("len(blocksInTransit) %s\n",len(blocksInTransit))
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[len(blocksInTransit)-1]
-		//sendGetData(payload.AddrFrom, "block", blockHash)
-		blockHashStr := hex.EncodeToString(blockHash)
-		if(blocksInTransitSet.Has(blockHashStr)){
-			sendGetData(p.Rw, "block", blockHash)
-			blocksInTransitSet.Remove(hex.EncodeToString(block.Hash))
-		}
-		if(len(blocksInTransit) > 1){
-			blocksInTransit = blocksInTransit[:len(blocksInTransit)-1]
-		}else{
-			blocksInTransit = [][]byte{}
+	return true
+}
+
+// blocksSynced follows the blocks received from the peer: an inv holds
+// core.MaxBlockHashes blocks at most, the next ones are asked for until the
+// tip of the peer, then the pending transactions
+func blocksSynced(p *Peer, bc *core.Blockchain) {
+	height, _, err := bc.GetBestHeightLastHash()
+	if err == nil && p.Td != nil && height.Cmp(p.Td) < 0 {
+		sendGetBlocks(p.Rw, bc)
+		return
+	}
+	// the pending transactions are checked against the synced chain
+	requestMempool(p)
+	for _,peer := range Manager.Peers.Peers{
+		if err := SendVersion(peer.Rw, bc); err != nil {
+			peer.Log().Error("Sending the version failed", "err", err)
 		}
-	} else {
-		//UTXOSet := UTXOSet{bc}
-		//UTXOSet.Reindex()
+	}
+}
 
-		for _,peer := range Manager.Peers.Peers{
-			SendVersion(peer.Rw, bc)
+// processBlock stores a block of the peer: the block, the tip and the UTXO
+// set in one bolt transaction, or a side branch block, which reorganises
+// the chain once it has more work. Blocks ahead of their parent wait in the
+// orphan pool while the peer is asked for the missing one. It returns
+// whether the block was stored, and records the outcome in seenBlocks
+func processBlock(p *Peer, block *core.Block, bc *core.Blockchain) bool {
+	// the mempool and the wallets follow the chain, see followChain
+	start := time.Now()
+	err := Manager.Orphans.Process(bc, block, func(block *core.Block, reorg *core.ReorgEvent) {
+		if reorg != nil {
+			fmt.Printf("Reorganised from %x to %x, %d blocks deep\n", reorg.OldTip, reorg.NewTip, reorg.Depth)
+			reorgDepth.observe(float64(reorg.Depth))
 		}
+	})
+	if err != core.ErrOrphanBlock {
+		observeBlock(start)
+	}
+	if err == core.ErrOrphanBlock {
+		// the orphan pool holds it, ask the peer for the block it waits for
+		seenBlocks.processed(block.Hash, blockUnseen)
+		if missing := Manager.Orphans.MissingAncestor(block.Hash); seenBlocks.announce(missing, p) {
+			requestBlock(p, missing)
+		}
+		return false
+	}
+	// each reason VerifyBlock refuses a block for is its own error
+	if core.IsInvalidBlock(err) ||
+		err == core.ErrCheckpointMismatch || err == core.ErrForkBeforeCheckpoint || err == core.ErrReorgTooDeep {
+		fmt.Printf("Block %x from peer %s rejected: %s\n", block.Hash, p.id, err)
+		p.Misbehaving(blockScore(err), fmt.Sprintf("block %x: %v", block.Hash, err))
+		seenBlocks.processed(block.Hash, blockRejected)
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Block not Valid %x: %s\n", block.Hash, err)
+		seenBlocks.processed(block.Hash, blockUnseen)
+		return false
 	}
-	UTXOSet := core.UTXOSet{bc}
-	UTXOSet.Update(block)
+	seenBlocks.processed(block.Hash, blockStored)
+
+	return true
 }
 
 func handleInv(p *Peer,command Command, bc *core.Blockchain) {
@@ -425,44 +567,72 @@ func handleInv(p *Peer,command Command, bc *core.Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		malformed(p, command, err)
+		return
 	}
 
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
+	atomic.AddUint64(&inventoryStats.InvReceived, uint64(len(payload.Items)))
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-		for _,item := range payload.Items {
-			blocksInTransitSet.Add(hex.EncodeToString(item))
+		// the peer has the blocks it announces, only those the node lacks
+		// are downloaded
+		var missing [][]byte
+		for _, item := range payload.Items {
+			p.MarkBlock(item)
+			// those seen already are answered from seenBlocks
+			if state := seenBlocks.State(item); state != blockUnseen && state != blockRequested {
+				atomic.AddUint64(&inventoryStats.AnnouncesSuppressed, 1)
+				continue
+			}
+			if haveBlock(bc, item) || (Manager.download != nil && Manager.download.Has(item)) {
+				atomic.AddUint64(&inventoryStats.GetDataSkipped, 1)
+				continue
+			}
+			missing = append(missing, item)
+		}
+		if len(missing) == 0 {
+			return
+		}
+		// the blocks of a sync are downloaded from all the peers at once, in
+		// height order, the lowest at the end of the inv
+		if len(missing) > 1 && Manager.download != nil {
+			wanted := make([][]byte, len(missing))
+			for i, item := range missing {
+				wanted[len(missing)-1-i] = item
+			}
+			var base int64
+			if height, _, err := bc.GetBestHeightLastHash(); err == nil {
+				base = height.Int64()
+			}
+			Manager.download.start(p, base, wanted)
+			Manager.download.schedule(Manager.Peers.List())
+			return
 		}
-		blockHash := payload.Items[len(blocksInTransit)-1]
-		blockHashStr := hex.EncodeToString(blockHash)
+		blockHash := missing[len(missing)-1]
 		//sendGetData(payload.AddrFrom, "block", blockHash)
 
-		if blocksInTransitSet.Has(blockHashStr) {
-			sendGetData(p.Rw, "block", blockHash)
-			blocksInTransitSet.Remove(blockHashStr)
+		// another peer announcing the block meanwhile isn't asked for it
+		// unless the first doesn't send it in time
+		if seenBlocks.announce(blockHash, p) {
+			requestBlock(p, blockHash)
+		} else {
+			atomic.AddUint64(&inventoryStats.GetDataSkipped, 1)
 		}
 		fmt.Printf("==========>request payload.Items[0]-blockhash %x %s\n", blockHash, payload.Type)
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
-		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
-		var txID []byte
-		for _,Item := range payload.Items{
-			txID = Item
-			if !p.knownTxs.Has(hex.EncodeToString(txID)) {
-				//sendGetData(payload.AddrFrom, "tx", txID)
-				sendGetData(p.Rw, "tx", txID)
+		for _, txID := range payload.Items {
+			// the peer isn't announced the transactions it announces
+			p.MarkTransaction(txID)
+			if Manager.TxMempool.Get(hex.EncodeToString(txID)) != nil || (Manager.TxOrphans != nil && Manager.TxOrphans.Has(txID)) || !requested.add(txID) {
+				atomic.AddUint64(&inventoryStats.GetDataSkipped, 1)
+				continue
 			}
+			//sendGetData(payload.AddrFrom, "tx", txID)
+			sendGetData(p.Rw, "tx", txID)
 		}
-
 	}
 }
 
@@ -478,7 +648,8 @@ func handleGetBlocks(p *Peer,command Command, bc *core.Blockchain) {
 
 	fmt.Printf("Recevied getblocks payload with %s\n", &payload)
 	if err != nil {
-		log.Panic(err)
+		malformed(p, command, err)
+		return
 	}
 
 	if( p.forkDrop != nil){
@@ -487,18 +658,34 @@ func handleGetBlocks(p *Peer,command Command, bc *core.Blockchain) {
 		p.forkDrop = nil
 	}
 
-	blocks := bc.GetBlockHashes(payload.LastHash)
+	locator := payload.Locator
+	if len(locator) == 0 {
+		lastHash, err := hex.DecodeString(payload.LastHash)
+		if err != nil {
+			p.Log().Debug("Dropping getblocks", "err", err)
+			return
+		}
+		locator = [][]byte{lastHash}
+	}
+	blocks, err := bc.GetBlockHashes(locator, payload.StopHash, core.MaxBlockHashes)
+	if err != nil {
+		p.Log().Debug("Dropping getblocks", "err", err)
+		return
+	}
 	var blocksToS = [][]byte{}
 
 	if(len(blocks) != 0) {
-		for _, b := range blocks{
-			hashStr := hex.EncodeToString(b)
-			if(!p.knownBlocks.Has(hashStr)){
-				p.knownBlocks.Add(hashStr)
+		// the inv lists them highest first, the peer downloads them from the end
+		for i := len(blocks) - 1; i >= 0; i-- {
+			b := blocks[i]
+			if(!p.knownBlocks.Has(b)){
+				p.MarkBlock(b)
 				blocksToS = append(blocksToS,b)
+			} else {
+				atomic.AddUint64(&inventoryStats.InvSkipped, 1)
 			}
 		}
-		log.Println("==<len(blocksToS) %d",len(blocksToS))
+		log.Printf("==<len(blocksToS) %d",len(blocksToS))
 		if(len(blocksToS)>0){
 			sendInv(p.Rw, "block", blocksToS)
 			//sendInv(payload.AddrFrom, "block", blocks)
@@ -514,26 +701,34 @@ func handleGetData(p *Peer,command Command, bc *core.Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		malformed(p, command, err)
+		return
 	}
 
+	atomic.AddUint64(&inventoryStats.GetDataReceived, 1)
+
 	if payload.Type == "block" {
 		block, err := bc.GetBlock([]byte(payload.ID))
+		if err == core.ErrBlockPruned {
+			// the version message told the peer
+			log.Printf("peer %s asked for the pruned block %x", p.id, payload.ID)
+			return
+		}
 		if err != nil {
 			return
 		}
 
 		//sendBlock(payload.AddrFrom, &block)
-		sendBlock(p.Rw, &block)
+		sendBlock(p, &block)
 	}
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
-		tx := Manager.TxMempool[txID]
+		tx := Manager.TxMempool.Get(txID)
 
 		if(tx!=nil){
 			//SendTx(payload.AddrFrom, &tx)
-			SendTx(p, p.Rw, tx)
+			sendTx(p, tx)
 			//TODO delete from queue after user new transaction been comfirmed
 			// delete(queue, txID)
 		}
@@ -549,23 +744,54 @@ func handleTx(p *Peer, command Command, bc *core.Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		malformed(p, command, err)
+		return
 	}
 
 	txData := payload.Transaction
-	tx := core.DeserializeTransaction(txData)
+	tx, err := core.DecodeTransaction(txData)
+	if err != nil {
+		txRejected.inc(rejectMalformed)
+		malformed(p, command, err)
+		return
+	}
 	tx.SetSize(uint64(len(txData)))
+	atomic.AddUint64(&inventoryStats.TxsReceived, 1)
+	atomic.AddUint64(&inventoryStats.BytesReceived, uint64(len(txData)))
+	requested.remove(tx.ID)
+	// the peer isn't announced the transaction it sent, whether it's kept
+	// or not
+	p.MarkTransaction(tx.ID)
 
 	//tx.Size()
 
-	Manager.TxMempool[hex.EncodeToString(tx.ID)] = &tx
-
-
-	p.MarkTransaction(tx.ID)
+	if Manager.TxMempool.Get(hex.EncodeToString(tx.ID)) != nil || (Manager.TxOrphans != nil && Manager.TxOrphans.Has(tx.ID)) {
+		atomic.AddUint64(&inventoryStats.Duplicates, 1)
+		return
+	}
+	if err := core.VerifyTx(tx, bc); err != nil {
+		// one spending outputs of transactions the node lacks waits for them
+		reason := txRejectReason(&tx, bc)
+		if reason == rejectOrphan && Manager.TxOrphans != nil && holdOrphanTx(p, &tx, bc) {
+			return
+		}
+		p.Log().Debug("Dropping invalid transaction", "id", hex.EncodeToString(tx.ID), "err", err)
+		txRejected.inc(reason)
+		p.Misbehaving(txScore(&tx, bc), err.Error())
+		return
+	}
+	// a double spend of a transaction of the mempool is neither kept nor
+	// relayed
+	if err := Manager.TxMempool.Add(&tx); err != nil {
+		p.Log().Debug("Dropping transaction", "id", hex.EncodeToString(tx.ID), "err", err)
+		txRejected.inc(rejectConflict)
+		return
+	}
 
 	var tnxs core.Transactions
 	tnxs = append(tnxs, &tx)
 	Manager.BroadcastTxs(tnxs)
+	acceptOrphans([][]byte{tx.ID}, bc)
 
 	if nodeAddress == BootNodes[0] {
 		/*for _, node := range BootNodes {
@@ -577,7 +803,7 @@ func handleTx(p *Peer, command Command, bc *core.Blockchain) {
 		//sendInv(p.Rw, "tx", [][]byte{tx.ID})
 	} else {
 		fmt.Println("==>len tx")
-		if len(Manager.TxMempool) >= 2 && len(miningAddress) > 0 {
+		if Manager.TxMempool.Count() >= 2 && len(miningAddress) > 0 {
 		MineTransactions:
 			var txs []*core.Transaction
 
@@ -585,7 +811,11 @@ func handleTx(p *Peer, command Command, bc *core.Blockchain) {
 			//wait block sync complete
 			select{
 			case ch := <- Manager.BestTd:
-				td,_ := bc.GetBestHeight()
+				td,_,err := bc.GetBestHeight()
+				if err != nil {
+					p.Log().Debug("Dropping tx", "err", err)
+					return
+				}
 				log.Println("---td 1:",td)
 				if(td.Cmp(ch) == 0){
 					log.Println("---td:",ch)
@@ -594,11 +824,13 @@ func handleTx(p *Peer, command Command, bc *core.Blockchain) {
 			}
 
 			fmt.Println("==>VerifyTx ")
-			for id := range Manager.TxMempool {
+			for _, tx := range Manager.TxMempool.Transactions() {
 				//verify transaction
-				if(core.VerifyTx(tx,bc)) {
-					txs = append(txs, Manager.TxMempool[id])
+				if err := core.VerifyTx(*tx, bc); err != nil {
+					p.Log().Debug("Skipping invalid transaction", "err", err)
+					continue
 				}
+				txs = append(txs, tx)
 			}
 			if len(txs) < 2 && len(miningAddress) > 0 {
 				return
@@ -610,34 +842,46 @@ func handleTx(p *Peer, command Command, bc *core.Blockchain) {
 			}
 
 			fmt.Println("==>NewCoinbaseTX ")
-			cbTx := core.NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
+			// what doesn't fit waits for the next block, the coinbase
+			// collects the fees of the rest
+			txs, err := core.UTXOSet{Blockchain: bc}.BlockTransactions(txs, miningAddress)
+			if err != nil {
+				// the transactions stay in the mempool for the next attempt
+				p.Log().Error("Selecting the transactions to mine failed", "err", err)
+				return
+			}
 
-			newBlock := bc.MineBlock(txs)
+			newBlock, err := Manager.Miner.Mine(bc, txs)
+			if err == core.ErrMiningAborted {
+				if Manager.Miner.Stopped() {
+					return
+				}
+				// mine again on the new tip, with what is left of the mempool
+				fmt.Println("Mining aborted, the tip moved")
+				goto MineTransactions
+			}
+			if core.IsInvalidBlock(err) {
+				// the self-check refused it, the peers would
+				fmt.Printf("Mined block not valid: %s\n", err)
+				return
+			}
+			if err != nil {
+				p.Log().Error("Mining failed", "err", err)
+				return
+			}
 			if(newBlock != nil){
-				UTXOSet := core.UTXOSet{bc}
-				//UTXOSet.Reindex()
-				UTXOSet.Update(newBlock)
+				// stored with its UTXO set changes, the wallets follow
+				// the chain and the peers are announced the block, see
+				// followChain
 				fmt.Println("New block is mined!")
-
-				for _, node := range BootNodes {
-					if node != nodeAddress {
-						//sendInv(node, "block", [][]byte{newBlock.Hash})
-						//sendInv(p.Rw, "block", [][]byte{newBlock.Hash})
-						//Manager.BroadcastBlock(newBlock,true)
-						for _,peer := range Manager.Peers.Peers {
-							SendVersion(peer.Rw,bc)
-						}
-					}
-				}
 			}
 			for _, tx := range txs {
 				txID := hex.EncodeToString(tx.ID)
-				delete(Manager.TxMempool, txID)
+				Manager.TxMempool.Remove(txID)
 			}
 
-			fmt.Println("==>after mine len(Manager.TxMempool) ",len(Manager.TxMempool))
-			if len(Manager.TxMempool) > 0 {
+			fmt.Println("==>after mine Manager.TxMempool.Count() ",Manager.TxMempool.Count())
+			if Manager.TxMempool.Count() > 0 {
 				goto MineTransactions
 			}
 		}
@@ -653,21 +897,59 @@ func handleVersion(p *Peer, command Command, bc *core.Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		malformed(p, command, err)
+		return
 	}
 
 	log.Println("==>handle version receive payload BestHeight：", payload.BestHeight)
-	myBestHeight,myLastHash := bc.GetBestHeightLastHash()
-	myLastHashStr := hex.EncodeToString(myLastHash)
+	// a node of another network has no block in common with this one
+	if err := checkNetwork(payload, bc.GenesisHash); err != nil {
+		log.Printf("Disconnecting peer %s: %s", p.id, err)
+		Manager.Peers.Unregister(p.id)
+		p.Peer.Disconnect(p2p.DiscUselessPeer)
+		return
+	}
+	syncWithVersion(p, payload, bc)
+}
+
+// syncWithVersion records the version of the peer and compares the chains:
+// blocks are asked for when the peer is ahead. The version of the handshake
+// starts the sync, those sent after as blocks arrive carry it on
+func syncWithVersion(p *Peer, payload verzion, bc *core.Blockchain) {
+	p.lock.Lock()
+	p.blockVersion = payload.BlockVersion
+	p.services = payload.Services
+	p.lock.Unlock()
+	// the clocks of the peers adjust the time block timestamps are checked against
+	if payload.Timestamp != 0 {
+		core.NetworkTime.AddTimeSample(p.id, time.Unix(payload.Timestamp, 0))
+	}
+	// behind or ahead of the peer, from the chainstate record
+	info, err := bc.ChainInfo()
+	if err != nil {
+		p.Log().Error("Reading the chain info failed", "err", err)
+		return
+	}
+	myBestHeight, myLastHash := big.NewInt(info.Height), info.Tip
 	foreignerBestHeight := payload.BestHeight
 
+	p.lock.Lock()
 	p.Td = foreignerBestHeight
+	p.lock.Unlock()
+	// a taller chain starts a sync, see SyncStatus
+	Manager.syncStatus(myBestHeight.Int64(), time.Now())
 
 	if myBestHeight.Cmp(foreignerBestHeight) <= 0 {
 		//sendGetBlocks(payload.AddrFrom,myLastHash)
-		if(myBestHeight.Cmp(foreignerBestHeight) < 0){
+		// a pruned peer can't serve the blocks after the tip
+		if payload.PrunedHeight > myBestHeight.Int64() {
+			log.Printf("peer %s pruned the blocks up to %d, not syncing from it", p.id, payload.PrunedHeight)
+			requestMempool(p)
+		} else if(myBestHeight.Cmp(foreignerBestHeight) < 0){
 			enqueueVersion(myLastHash)
-			sendGetBlocks(p.Rw,myLastHashStr)
+			sendGetBlocks(p.Rw, bc)
+		} else {
+			requestMempool(p)
 		}
 		go func() {
 			select {
@@ -679,26 +961,23 @@ func handleVersion(p *Peer, command Command, bc *core.Blockchain) {
 		//sendVersion(payload.AddrFrom, bc)
 		//SendVersion(p.Rw, bc)
 
-		//check possible conflict fork
-		//if there is conflict send  conflict msg to the node and ignore this node
-		peerLastHash,err1 := hex.DecodeString(payload.LastHash)
-		if err1 != nil {
-			log.Panic(err1)
+		// a peer on a fork has a tip the chain doesn't hold, it syncs from
+		// the version this node sent
+		peerLastHash, err := hex.DecodeString(payload.LastHash)
+		if err != nil {
+			p.Misbehaving(scoreMalformed, fmt.Sprintf("malformed version message: last hash: %v", err))
+			return
 		}
-		_,err2 := bc.GetBlock(peerLastHash)
-		if(err2 != nil){
-			log.Panic(err2)
-			data := statusData{
-				uint32(1),
-				CurrentNodeInfo.ID,
-				myBestHeight,
-				myLastHash,
-				bc.GenesisHash,
-			}
-			payload := gobEncode(data)
-			p2p.Send(p.Rw, StatusMsg, &Command{"conflict",payload})
+		// a pruned block is still one of the chain
+		known, err := bc.HasBlock(peerLastHash)
+		if err != nil {
+			p.Log().Error("Looking up the tip of the peer failed", "err", err)
 			return
 		}
+		if !known {
+			log.Printf("peer %s is on a fork, its tip %x isn't in the chain", p.id, peerLastHash)
+		}
+		requestMempool(p)
 		go func(){
 		select {
 		case Manager.BestTd <- myBestHeight:
@@ -707,12 +986,6 @@ func handleVersion(p *Peer, command Command, bc *core.Blockchain) {
 		}()
 	}
 
-	if( p.forkDrop != nil){
-		// Disable the fork drop timer
-		p.forkDrop.Stop()
-		p.forkDrop = nil
-	}
-
 	//sendAddr(payload.AddrFrom)
 	//if !nodeIsKnown(payload.AddrFrom) {
 	//	BootNodes = append(BootNodes, payload.AddrFrom)
@@ -731,7 +1004,9 @@ func HandleConnection(p *Peer,command Command, bc *core.Blockchain) {
 
 	switch command.Command {
 	case "addr":
-		handleAddr(command)
+		handleAddr(p, command)
+	case "getaddr":
+		handleGetAddr(p)
 	case "block":
 		handleBlock(p,command, bc)
 	case "inv":
@@ -746,8 +1021,22 @@ func HandleConnection(p *Peer,command Command, bc *core.Blockchain) {
 		handleVersion(p,command, bc)
 	case "conflict":
 		handleConflict(p,command, bc)
+	case "mempool":
+		handleMempool(p)
+	case "ping":
+		handlePing(p, command)
+	case "pong":
+		handlePong(p, command)
+	case "cmpctblock":
+		handleCmpctBlock(p, command, bc)
+	case "getblocktxn":
+		handleGetBlockTxn(p, command, bc)
+	case "blocktxn":
+		handleBlockTxn(p, command, bc)
 	default:
-		fmt.Println("Unknown command!")
+		// a message of a newer protocol version, or garbage, isn't held
+		// against the peer
+		log.Printf("peer %s sent the unknown %s message, ignored", p.id, command.Command)
 	}
 
 	//conn.Close()
@@ -783,12 +1072,43 @@ func HandleConnection(p *Peer,command Command, bc *core.Blockchain) {
 
 }*/
 
-// StartServer starts a node
-func StartServer(nodeID, minerAddress string) {
+// StopTimeout bounds how long Server.Stop waits for the node to shut down
+var StopTimeout = 15 * time.Second
+
+// errStopTimeout is returned by Server.Stop when the node didn't shut down
+// within StopTimeout
+var errStopTimeout = errors.New("the node didn't shut down in time")
+
+// Server is a node started by StartServer
+type Server struct {
+	nodeID  string
+	running *p2p.Server
+	stack   *node.Node
+	loops   sync.WaitGroup // the background loops, see Manager.quitSync
+	rpc     net.Listener   // the JSON-RPC listener, nil without RPCPort
+	ws      net.Listener   // the WebSocket JSON-RPC listener, nil without WSPort
+	wsRPC   *rpc.Server    // stopped with ws, closing the subscribers' connections
+	rest    net.Listener   // the REST listener, nil without RESTAddr
+	metrics net.Listener   // the Prometheus metrics listener, nil without MetricsAddr
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// StartServer starts the node nodeID, whose wallets and files are named
+// after nodeID, listening on ListenAddr, mining to minerAddress when it is
+// set. The node runs until Stop is called or ctx is done
+func StartServer(ctx context.Context, nodeID, minerAddress string) (*Server, error) {
 	//nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	srp := strings.NewReplacer(":", "_")
 	node_id = srp.Replace(nodeID)
-	nodeAddress = nodeID
+	nodeAddress = ListenAddr
+	if nodeAddress == "" {
+		nodeAddress = nodeID
+	}
+	if _, _, err := net.SplitHostPort(nodeAddress); err != nil {
+		return nil, fmt.Errorf("listen address %s: %v, set -listen", nodeAddress, err)
+	}
 
 	miningAddress = minerAddress
 
@@ -819,87 +1139,273 @@ func StartServer(nodeID, minerAddress string) {
 		nodeIDs := dotray.QueryNodes(10)
 		fmt.Println("query nodes:", nodeIDs)
 	*/
-	 wallets, err := core.NewWallets("192.168.1.101:2000")
-	 if err != nil {
-		 log.Panic(err)
-	 }
-	 walletaddrs := wallets.GetAddresses()
-	 wallet := wallets.GetWallet(walletaddrs[0])
-	 var peers []*discover.Node
-	 if(nodeID!="192.168.1.101:2000"){
-	 	peers = []*discover.Node{&discover.Node{IP: net.ParseIP("192.168.1.101"),TCP:2000,UDP:2000,ID: discover.PubkeyID(&wallet.PrivateKey.PublicKey)}}
-	 }else{
-	 	peers = nil
-	 }
-	 BootPeers = peers
-	 wallets1, err := core.NewWallets(nodeID)
-	 if err != nil {
-		 log.Panic(err)
-	 }
-	 //wallet1 := wallets1.GetWallet("1NWUWL17WtxzSMVWhGm8UD7Y45ikFUHZCx")
-	 walletaddrs1 := wallets1.GetAddresses()
-	 wallet1 := wallets1.GetWallet(walletaddrs1[0])
-	 config := p2p.Config{
-		 PrivateKey:      &wallet1.PrivateKey,
-		 MaxPeers:        10,
-		 NoDiscovery:     false,
-		 Dialer:          nil,
-		 EnableMsgEvents: true,
-		 BootstrapNodes:peers,
-		 Name:nodeID,
-		 //NAT:nat.Any(),
-		 ListenAddr:nodeAddress,
-		 Protocols:  []p2p.Protocol{MyProtocol()},
-	 }
-	 running := &p2p.Server{
-		 Config: config,
-	 }
-
-	err = running.Start()
+	bootHost, bootPort, err := net.SplitHostPort(BootNodes[0])
 	if err != nil {
-		 panic("server start panic:" + err.Error())
+		return nil, err
 	}
-	CurrentNodeInfo = running.NodeInfo()
- 	fmt.Println("NodeInfo:", CurrentNodeInfo)
-
-	conf := &node.Config{
-		Name: "test node",
-		P2P:  config,
+	port, err := strconv.Atoi(bootPort)
+	if err != nil {
+		return nil, fmt.Errorf("boot node %s: %v", BootNodes[0], err)
+	}
+	var peers []*discover.Node
+	if nodeAddress != BootNodes[0] {
+		// the boot node shares the data directory, named by its address
+		bootKey, err := core.PeerNodeKey(BootNodes[0])
+		if err != nil {
+			return nil, err
+		}
+		if bootKey == nil {
+			return nil, errors.New("no boot node key nor wallet found, create one first")
+		}
+		peers = []*discover.Node{&discover.Node{IP: net.ParseIP(bootHost), TCP: uint16(port), UDP: uint16(port), ID: discover.PubkeyID(&bootKey.PublicKey)}}
+	}
+	BootPeers = peers
+	// a node without a wallet yet relays, known by its node key
+	wallets1, err := core.NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	nodeKey, err := core.LoadNodeKey(nodeID)
+	if err != nil {
+		wallets1.Close()
+		return nil, fmt.Errorf("load node key: %v", err)
+	}
+	localID := discover.PubkeyID(&nodeKey.PublicKey)
+	log.SetPrefix(fmt.Sprintf("[%x] ", localID[:4]))
+	NodeWallets = wallets1
+	local := netAddress{ID: localID, Addr: nodeAddress}
+	addrs, err := loadAddrManager(core.PeersFile(node_id), local)
+	if err != nil {
+		log.Println("loading the peer addresses:", err)
+		addrs = newAddrManager(core.PeersFile(node_id), local)
 	}
-	stack, err := node.New(conf)
-	StartNode(stack)
 
-	//bc := core.NewBlockchain(nodeID)
-	//fmt.Println("af NewBlockchain:")
-	//td,_:= bc.GetBestHeight()
-	//bc.Db.Close()
+	// the handlers of the first peers find the manager set
 	Manager = &ProtocolManager{
-		Peers:       newPeerSet(),
-		//Bc:bc,
-		TxMempool:make(map[string]*core.Transaction),
-		txsyncCh: make(chan *txsync),
-		quitSync: make(chan struct{}),
-		//BigestTd:td,
-		BestTd: make(chan *big.Int),
-	}
-	//defer bc.Db.Close()
-	// start sync handlers
-	////go pm.syncer()
-	go Manager.txsyncLoop()
-
-	//if nodeAddress != BootNodes[0] {
-	//	sendVersion(BootNodes[0], bc)
-	//}
+		Peers:     newPeerSet(),
+		TxMempool: core.NewMempool(),
+		Orphans:   core.NewOrphanPool(core.DefaultMaxOrphans, core.DefaultOrphanAge),
+		TxOrphans: core.NewTxOrphanPool(core.DefaultMaxTxOrphans, core.DefaultTxOrphanAge, core.DefaultMaxTxOrphansPerPeer),
+		Miner:     NewMiner(),
+		txsyncCh:  make(chan *txsync),
+		quitSync:  make(chan struct{}),
+		BestTd:    make(chan *big.Int),
+		Addrs:     addrs,
+		download:  newBlockDownload(),
+	}
+	if MaxUploadRate > 0 {
+		Manager.upload = newUploadLimiter(limiterClock, MaxUploadRate)
+	}
+	openChainsAgain()
+	if err := loadWhitelist(os.Getenv("NODE_ID")); err != nil {
+		wallets1.Close()
+		return nil, fmt.Errorf("loading the whitelist: %v", err)
+	}
+	// a private network connects to the whitelisted nodes only
+	if WhitelistOnly {
+		peers = nil
+	}
+	trusted, static := whitelist.trusted()
+
+	config := p2p.Config{
+		PrivateKey:      nodeKey,
+		MaxPeers:        MaxInbound + 1 + MaxOutbound,
+		// one over MaxInbound for admitInbound to evict a peer, the
+		// whitelisted ones come on top as trusted nodes
+		MaxInbound:      MaxInbound + 1,
+		NoDiscovery:     WhitelistOnly,
+		Dialer:          nil,
+		EnableMsgEvents: true,
+		BootstrapNodes:  peers,
+		TrustedNodes:    trusted,
+		StaticNodes:     static,
+		Name:            nodeID,
+		//NAT:nat.Any(),
+		ListenAddr: nodeAddress,
+		Protocols:  []p2p.Protocol{MyProtocol()},
+	}
+	s := &Server{nodeID: nodeID, running: &p2p.Server{Config: config}}
+	if err := s.running.Start(); err != nil {
+		wallets1.Close()
+		return nil, fmt.Errorf("starting the p2p server: %v", err)
+	}
+	CurrentNodeInfo = s.running.NodeInfo()
+	fmt.Println("NodeInfo:", CurrentNodeInfo)
 
-	select {}
+	s.stack, err = node.New(&node.Config{Name: "test node", P2P: config})
+	if err == nil {
+		err = s.stack.Start()
+	}
+	if err != nil {
+		s.running.Stop()
+		wallets1.Close()
+		return nil, fmt.Errorf("starting the protocol stack: %v", err)
+	}
 
-	/*for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Panic(err)
+	if RPCPort != 0 {
+		if s.rpc, _, err = startRPC(os.Getenv("NODE_ID")); err != nil {
+			s.stack.Stop()
+			s.running.Stop()
+			wallets1.Close()
+			return nil, fmt.Errorf("starting the JSON-RPC listener: %v", err)
+		}
+		log.Println("JSON-RPC listening on", s.rpc.Addr())
+	}
+	if WSPort != 0 {
+		if s.ws, s.wsRPC, err = startWS(os.Getenv("NODE_ID")); err != nil {
+			if s.rpc != nil {
+				s.rpc.Close()
+			}
+			s.stack.Stop()
+			s.running.Stop()
+			wallets1.Close()
+			return nil, fmt.Errorf("starting the WebSocket JSON-RPC listener: %v", err)
+		}
+		log.Println("WebSocket JSON-RPC listening on", s.ws.Addr())
+	}
+	if RESTAddr != "" {
+		if s.rest, err = startREST(os.Getenv("NODE_ID")); err != nil {
+			if s.rpc != nil {
+				s.rpc.Close()
+			}
+			if s.ws != nil {
+				s.ws.Close()
+				s.wsRPC.Stop()
+			}
+			s.stack.Stop()
+			s.running.Stop()
+			wallets1.Close()
+			return nil, fmt.Errorf("starting the REST listener: %v", err)
+		}
+		log.Println("REST listening on", s.rest.Addr())
+	}
+	if MetricsAddr != "" {
+		if s.metrics, err = startMetrics(os.Getenv("NODE_ID")); err != nil {
+			if s.rpc != nil {
+				s.rpc.Close()
+			}
+			if s.ws != nil {
+				s.ws.Close()
+				s.wsRPC.Stop()
+			}
+			if s.rest != nil {
+				s.rest.Close()
+			}
+			s.stack.Stop()
+			s.running.Stop()
+			wallets1.Close()
+			return nil, fmt.Errorf("starting the metrics listener: %v", err)
+		}
+		log.Println("metrics listening on", s.metrics.Addr())
+	}
+
+	Manager.dialer = s.running
+	Manager.seeds = peers
+	s.loops.Add(5)
+	go func() {
+		defer s.loops.Done()
+		Manager.txsyncLoop()
+	}()
+	go func() {
+		defer s.loops.Done()
+		Manager.downloadLoop(Manager.quitSync)
+	}()
+	go func() {
+		defer s.loops.Done()
+		Manager.addrLoop(Manager.quitSync)
+	}()
+	go func() {
+		defer s.loops.Done()
+		statsLoop(StatsInterval, Manager.quitSync)
+	}()
+	go func() {
+		defer s.loops.Done()
+		Manager.rebroadcastLoop(Manager.quitSync)
+	}()
+	if NAT != nil {
+		if _, port, err := net.SplitHostPort(nodeAddress); err != nil {
+			log.Printf("NAT: no port in the address %s: %v", nodeAddress, err)
+		} else if tcp, err := strconv.Atoi(port); err == nil {
+			mapPort(NAT, tcp, Manager.Addrs, Manager.quitSync, &s.loops)
+		}
+	}
+	if bc, err := openChain(os.Getenv("NODE_ID")); err == nil {
+		followChain(bc, Manager.quitSync, &s.loops)
+		closeChain(bc)
+	} else {
+		log.Println("following the chain:", err)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-Manager.quitSync:
+		}
+	}()
+
+	return s, nil
+}
+
+// Addr returns the address the node listens on
+func (s *Server) Addr() string {
+	return s.running.ListenAddr
+}
+
+// Stop shuts the node down: it stops mining, closes the listener and the
+// peer connections, waits for the message handlers to be done with the
+// chain, closing it under those that aren't within shutdownWait, then saves
+// the wallets and releases their lock. It returns once the node is down or
+// after StopTimeout. The mempool is held in memory only and the pending
+// queues are closed after each use, there is nothing of them to flush.
+// Calling Stop again returns the result of the first call
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- s.shutdown()
+		}()
+		select {
+		case s.stopErr = <-done:
+		case <-time.After(StopTimeout):
+			s.stopErr = errStopTimeout
 		}
-		go handleConnection(conn, bc)
-	}*/
+	})
+
+	return s.stopErr
+}
+
+func (s *Server) shutdown() error {
+	log.Println("Shutting down...")
+	Manager.Miner.Stop()
+	if s.rpc != nil {
+		s.rpc.Close()
+	}
+	if s.ws != nil {
+		s.ws.Close()
+		s.wsRPC.Stop()
+	}
+	if s.rest != nil {
+		s.rest.Close()
+	}
+	if s.metrics != nil {
+		s.metrics.Close()
+	}
+	close(Manager.quitSync)
+	s.running.Stop()
+	if err := s.stack.Stop(); err != nil {
+		log.Println("stopping the protocol stack:", err)
+	}
+	// the handlers finish with the chain, no bolt write is cut short
+	closeChains()
+	log.Println("Blockchain closed")
+	s.loops.Wait()
+	CurrentNodeInfo = nil
+
+	if err := NodeWallets.SaveToFile(s.nodeID); err != nil {
+		log.Println("saving the wallet:", err)
+	}
+	return NodeWallets.Close()
 }
 
 func gobEncode(data interface{}) []byte {
@@ -944,6 +1450,7 @@ func (pm *ProtocolManager) BroadcastTxs(txs core.Transactions) {
 	for _, tx := range txs {
 		peers := pm.Peers.PeersWithoutTx(tx.ID)
 		log.Println("---len(PeersWithoutTx)   ",len(peers))
+		atomic.AddUint64(&inventoryStats.InvSkipped, uint64(pm.Peers.Len()-len(peers)))
 		for _, peer := range peers {
 			txset[peer] = append(txset[peer], tx)
 		}
@@ -955,6 +1462,22 @@ func (pm *ProtocolManager) BroadcastTxs(txs core.Transactions) {
 	}
 }
 
+// AnnounceBlock announces a block to all peers which are not known to already
+// have it, they ask for it with getdata unless they have it. The peer it came
+// from isn't announced it back.
+func (pm *ProtocolManager) AnnounceBlock(block *core.Block) {
+	peers := pm.Peers.PeersWithoutBlock(block.Hash)
+	atomic.AddUint64(&inventoryStats.InvSkipped, uint64(pm.Peers.Len()-len(peers)))
+	from := seenBlocks.source(block.Hash)
+	for _, peer := range peers {
+		if peer == from {
+			atomic.AddUint64(&inventoryStats.RelaysSuppressed, 1)
+			continue
+		}
+		peer.AsyncSendNewBlockHash(block)
+	}
+}
+
 /*
 // BroadcastBlock will either propagate a block to a subset of it's peers, or
 // will only announce it's availability (depending what's requested).
@@ -991,14 +1514,21 @@ func (pm *ProtocolManager) BroadcastBlock(block *core.Block, propagate bool) {
 }*/
 
 
-// syncTransactions starts sending all currently pending transactions to the given peer.
+// syncTransactions starts announcing the pending transactions the peer isn't
+// known to have to the given peer, MaxMempoolInv at most.
 func (pm *ProtocolManager) syncTransactions(p *Peer) {
 	var txs core.Transactions
 
-	pending := pm.TxMempool
+	pending := pm.TxMempool.Transactions()
 	//fmt.Println("---len(pending) ",len(pending))
 	for _, batch := range pending {
 		//fmt.Println("---syncTransactions ")
+		if p.knownTxs.Has(batch.ID) {
+			continue
+		}
+		if len(txs) == MaxMempoolInv {
+			break
+		}
 		txs = append(txs, batch)
 	}
 	if len(txs) == 0 {
@@ -1094,11 +1624,12 @@ func handleConflict(p *Peer, command Command, bc *core.Blockchain) {
 	dec := gob.NewDecoder(&buff)
 	err := dec.Decode(&payload)
 	if err != nil {
-		log.Panic(err)
+		malformed(p, command, err)
+		return
 	}
 
 	//save every version command received in queue
-	queueFile := fmt.Sprintf("version_%s.db", node_id)
+	queueFile := core.DataPath(fmt.Sprintf("version_%s.db", node_id))
 	versionPQueue, err := NewPQueue(queueFile)
 	if err != nil {
 		log.Panic("create Version Command queue error",err)
@@ -1115,11 +1646,51 @@ func handleConflict(p *Peer, command Command, bc *core.Blockchain) {
 		}
 		myLastHash := versionMsg.Bytes()
 		//delete old conflict block
-		blockHashs := bc.GetBlockHashesMap(myLastHash)
-		blockHashs1 := bc.DelBlockHashes(blockHashs)
+		blockHashs, err := bc.GetBlockHashesMap(myLastHash)
+		if err != nil {
+			p.Log().Debug("Dropping conflict", "err", err)
+			return
+		}
+		if NodeWallets != nil {
+			for _, hash := range blockHashs {
+				block, err := bc.GetBlock(hash)
+				if err != nil {
+					NodeWallets.InvalidateBalances()
+					break
+				}
+				NodeWallets.DisconnectBlock(&block, bc)
+			}
+		}
+		// their transactions go back to the mempool, the blocks of the new
+		// branch remove those they include as they arrive
+		for _, hash := range blockHashs {
+			block, err := bc.GetBlock(hash)
+			if err != nil {
+				continue
+			}
+			for _, tx := range block.Transactions {
+				if !tx.IsCoinbase() {
+					if err := Manager.TxMempool.Add(tx); err != nil {
+						log.Printf("dropped transaction %x: %v", tx.ID, err)
+					}
+				}
+			}
+		}
+		UTXOSet := core.UTXOSet{Blockchain: bc}
+		undoErr := UTXOSet.UndoBlocks(blockHashs)
+		blockHashs1, err := bc.DelBlockHashes(blockHashs)
 		if(len(blockHashs1) == 0){
 			log.Println("no blocks deleted !")
 		}
+		if err != nil {
+			log.Println("deleting blocks failed:", err)
+		}
+		if undoErr != nil {
+			log.Println("undo UTXO set failed, reindexing:", undoErr)
+			if err := UTXOSet.ReindexContext(context.Background(), nil); err != nil {
+				log.Println("reindexing the UTXO set failed:", err)
+			}
+		}
 		SendVersionStartConflict(p.Rw,myLastHash,bc)
 	//}
 
@@ -1127,7 +1698,7 @@ func handleConflict(p *Peer, command Command, bc *core.Blockchain) {
 
 func enqueueVersion(myLastHash []byte){
 	//save every version command received in queue
-	queueFile := fmt.Sprintf("version_%s.db", node_id)
+	queueFile := core.DataPath(fmt.Sprintf("version_%s.db", node_id))
 	versionPQueue, err := NewPQueue(queueFile)
 	if err != nil {
 		log.Panic("create Version myLastHash queue error",err)
@@ -1142,7 +1713,7 @@ func enqueueVersion(myLastHash []byte){
 }
 
 func confirmTx(newblock core.Block,wallet core.Wallet) bool {
-	queueFile := fmt.Sprintf("%x_tx.db", wallet.GetAddress())
+	queueFile := core.PendingQueueFile(wallet.GetAddress())
 	txPQueue, err := NewPQueue(queueFile)
 	if err != nil {
 		log.Panic("create queue error", err)
@@ -1211,25 +1782,3 @@ func confirmTx(newblock core.Block,wallet core.Wallet) bool {
 }
 
 
-func StartNode(stack *node.Node) {
-	if err := stack.Start(); err != nil {
-		log.Fatalf("Error starting protocol stack: %v", err)
-	}
-	go func() {
-		sigc := make(chan os.Signal, 1)
-		signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
-		defer signal.Stop(sigc)
-		<-sigc
-		log.Println("Got interrupt, shutting down...")
-		go stack.Stop()
-		/*for i := 10; i > 0; i-- {
-			<-sigc
-			if i > 1 {
-				log.Println("Already shutting down, interrupt more to panic.", "times", i-1)
-			}
-		}*/
-		//debug.Exit() // ensure trace and CPU profile data is flushed.
-		//debug.LoudPanic("boom")
-	}()
-}
-
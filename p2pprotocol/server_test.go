@@ -0,0 +1,177 @@
+package p2pprotocol
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	// a boot node on a random port, with its wallet and chain in dir
+	nodeID := "127.0.0.1:0"
+	defer func(nodes []string) { BootNodes = nodes }(BootNodes)
+	BootNodes = []string{nodeID}
+	defer os.Setenv("NODE_ID", os.Getenv("NODE_ID"))
+	os.Setenv("NODE_ID", "stoptest")
+	ws, _ := core.NewWallets(nodeID) // no wallet file yet
+	address := ws.CreateWallet()
+	assert.Nil(t, ws.SaveToFile(nodeID))
+	ws.Close()
+	bc, err := core.CreateBlockchain(address, "stoptest")
+	assert.Nil(t, err)
+	bc.Close()
+
+	goroutines := runtime.NumGoroutine()
+	server, err := StartServer(context.Background(), nodeID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addr()
+
+	// a peer that connects and never completes the handshake
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, server.Stop())
+	assert.Nil(t, server.Stop(), "Stopping again")
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err, "The connection is closed")
+	ln, err := net.Listen("tcp", addr)
+	assert.Nil(t, err, "The port is released")
+	if ln != nil {
+		ln.Close()
+	}
+	ws, err = core.NewWallets(nodeID)
+	assert.Nil(t, err, "The wallet lock is released")
+	ws.Close()
+
+	// the connections still in their handshake time out
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if runtime.NumGoroutine() <= goroutines {
+			break
+		}
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines, "%d goroutines left of %d", runtime.NumGoroutine(), goroutines)
+}
+
+func TestRestartWithoutSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	// a node that is its own seed, with its wallet and chain in dir
+	nodeID := "127.0.0.1:0"
+	defer func(nodes []string) { BootNodes = nodes }(BootNodes)
+	BootNodes = []string{nodeID}
+	defer os.Setenv("NODE_ID", os.Getenv("NODE_ID"))
+	os.Setenv("NODE_ID", "restarttest")
+	ws, _ := core.NewWallets(nodeID)
+	address := ws.CreateWallet()
+	assert.Nil(t, ws.SaveToFile(nodeID))
+	ws.Close()
+	bc, err := core.CreateBlockchain(address, "restarttest")
+	assert.Nil(t, err)
+	bc.Close()
+
+	// the node connects to a peer, then stops
+	server, err := StartServer(context.Background(), nodeID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := netAddress{ID: discover.NodeID{7}, Addr: "127.0.0.1:30399", LastSeen: time.Now().Unix()}
+	assert.True(t, Manager.Addrs.Add(peer, "127.0.0.1"))
+	Manager.Addrs.Good(peer)
+	assert.Nil(t, server.Stop())
+	_, err = os.Stat(core.PeersFile("127.0.0.1_0"))
+	assert.Nil(t, err, "The peers are saved")
+
+	// once restarted it dials the peer, the seed being itself
+	server, err = StartServer(context.Background(), nodeID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	attempted := func() bool {
+		Manager.Addrs.mu.Lock()
+		defer Manager.Addrs.mu.Unlock()
+		ka := Manager.Addrs.addrs[peer.Addr]
+		return ka != nil && ka.Tried && ka.Attempts == 1
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && !attempted(); time.Sleep(10 * time.Millisecond) {
+	}
+	assert.True(t, attempted(), "The peer is dialed")
+}
+
+func TestSyncWithVersionBehind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+	quit := make(chan struct{})
+	defer close(quit)
+	Manager = newTestManager(quit)
+
+	address := fmt.Sprintf("%s", core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "versiontest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	_, err = bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+	assert.Nil(t, err)
+
+	rw, remote := p2p.MsgPipe()
+	defer remote.Close()
+	received := readCommands(remote)
+	p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rw)
+
+	// a last hash that doesn't decode scores the peer
+	payload := verzion{Version: nodeVersion, BestHeight: big.NewInt(0), LastHash: "not hex"}
+	syncWithVersion(p, payload, bc)
+	assert.Equal(t, scoreMalformed, p.info().BanScore)
+
+	// a peer behind on a fork is still asked for its mempool
+	payload.LastHash = hex.EncodeToString(make([]byte, 32))
+	syncWithVersion(p, payload, bc)
+	expectCommand(t, received, "mempool")
+	assert.Equal(t, scoreMalformed, p.info().BanScore)
+}
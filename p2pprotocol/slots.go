@@ -0,0 +1,117 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"log"
+	"math"
+	"math/bits"
+
+	"../p2p"
+)
+
+// MaxInbound is the number of peers connecting to the node it keeps, a new
+// one past it takes the slot of the least useful, see admitInbound. The
+// whitelisted peers are kept slots of their own, counted in neither
+// MaxInbound nor MaxOutbound
+var MaxInbound = 117
+
+var errNoInboundSlot = errors.New("no inbound slot left")
+
+// ConnectionSlots tells how many of the connection slots of the node are
+// used
+type ConnectionSlots struct {
+	Inbound     int `json:"inbound"`
+	MaxInbound  int `json:"max_inbound"`
+	Outbound    int `json:"outbound"` // the nodes added by hand included
+	MaxOutbound int `json:"max_outbound"`
+	Whitelisted int `json:"whitelisted"` // the whitelisted peers, either way
+}
+
+// Slots returns the connection slots the peers use
+func (pm *ProtocolManager) Slots() ConnectionSlots {
+	slots := ConnectionSlots{MaxInbound: MaxInbound, MaxOutbound: MaxOutbound}
+	for _, p := range pm.Peers.List() {
+		switch {
+		case p.whitelisted():
+			slots.Whitelisted++
+		case p.Peer != nil && p.Inbound():
+			slots.Inbound++
+		default:
+			slots.Outbound++
+		}
+	}
+
+	return slots
+}
+
+// admitInbound makes room for the inbound peer p when the other inbound
+// peers use every slot of MaxInbound, disconnecting the least useful of
+// them, see lessUseful. A whitelisted peer is always admitted and never
+// evicted, errNoInboundSlot is returned when no peer can be evicted
+func (pm *ProtocolManager) admitInbound(p *Peer) error {
+	if p.Peer == nil || !p.Inbound() || p.whitelisted() {
+		return nil
+	}
+	pm.slotLock.Lock()
+	defer pm.slotLock.Unlock()
+	var inbound []*Peer
+	for _, other := range pm.Peers.List() {
+		if other.Peer != nil && other.Inbound() && !other.whitelisted() && !other.evicted {
+			inbound = append(inbound, other)
+		}
+	}
+	if len(inbound) < MaxInbound {
+		return nil
+	}
+	victim := leastUseful(inbound)
+	if victim == nil {
+		return errNoInboundSlot
+	}
+	// counted no more while it disconnects
+	victim.evicted = true
+	log.Printf("no inbound slot left, evicting peer %s for %s", victim.id, p.id)
+	victim.Peer.Disconnect(p2p.DiscTooManyPeers)
+
+	return nil
+}
+
+// leastUseful returns the least useful of peers, see lessUseful, nil for
+// none
+func leastUseful(peers []*Peer) *Peer {
+	var least *Peer
+	var leastInfo PeerInfo
+	for _, p := range peers {
+		info := p.info()
+		if least == nil || lessUseful(info, leastInfo) {
+			least, leastInfo = p, info
+		}
+	}
+
+	return least
+}
+
+// lessUseful returns whether the peer of a is worth less than that of b:
+// a higher ban score, then a slower round trip, a peer not answering the
+// pings yet being the slowest, then fewer services
+func lessUseful(a, b PeerInfo) bool {
+	if a.BanScore != b.BanScore {
+		return a.BanScore > b.BanScore
+	}
+	if pa, pb := pingOrInf(a), pingOrInf(b); pa != pb {
+		return pa > pb
+	}
+	if sa, sb := bits.OnesCount64(a.Services), bits.OnesCount64(b.Services); sa != sb {
+		return sa < sb
+	}
+
+	return a.ID > b.ID
+}
+
+// pingOrInf returns the shortest round trip of the peer of info, +Inf
+// before its first pong
+func pingOrInf(info PeerInfo) float64 {
+	if info.MinPing == 0 {
+		return math.Inf(1)
+	}
+	return info.MinPing
+}
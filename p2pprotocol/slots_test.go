@@ -0,0 +1,43 @@
+package p2pprotocol
+
+import (
+	"testing"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeastUseful(t *testing.T) {
+	rw, remote := p2p.MsgPipe()
+	defer remote.Close()
+	var peers []*Peer
+	for i := byte(1); i <= 4; i++ {
+		p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{i}, "", nil), rw)
+		p.minPing = 50 * time.Millisecond
+		p.services = ServiceFull | ServiceCompact
+		peers = append(peers, p)
+	}
+	assert.Nil(t, leastUseful(nil))
+
+	// the fewest services, then the slowest ping, then the highest ban
+	// score are evicted first
+	peers[0].services = ServiceFull
+	assert.Equal(t, peers[0], leastUseful(peers))
+	peers[1].minPing = time.Second
+	assert.Equal(t, peers[1], leastUseful(peers))
+	peers[2].minPing = 0
+	assert.Equal(t, peers[2], leastUseful(peers))
+	peers[3].banScore = scoreMalformed
+	assert.Equal(t, peers[3], leastUseful(peers))
+
+	// an outbound peer takes no inbound slot
+	defer func(max int) { MaxInbound = max }(MaxInbound)
+	MaxInbound = 0
+	quit := make(chan struct{})
+	defer close(quit)
+	pm := newTestManager(quit)
+	assert.Nil(t, pm.admitInbound(peers[0]))
+	assert.False(t, peers[0].evicted)
+}
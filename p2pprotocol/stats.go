@@ -0,0 +1,72 @@
+package p2pprotocol
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"../blockchain_go"
+)
+
+// StatsInterval is how often a running node logs its stats
+var StatsInterval = time.Minute
+
+// NodeStats are the counters of a running node
+type NodeStats struct {
+	Peers   int              `json:"peers"`
+	Mempool int              `json:"mempool"`
+	Orphans core.OrphanStats `json:"orphans"`
+	// TxOrphans counts the transactions received before their parents
+	TxOrphans core.TxOrphanStats `json:"tx_orphans"`
+	// Inventory counts the announcements and the items exchanged
+	Inventory InventoryStats `json:"inventory"`
+	// Bandwidth counts the bytes exchanged and the messages rate limited
+	Bandwidth BandwidthStats `json:"bandwidth"`
+	// Compact counts the compact blocks and how many were rebuilt from the
+	// mempool
+	Compact CompactStats `json:"compact"`
+	// ChecksumFailures counts the frames of the peers failing their checksum
+	ChecksumFailures uint64 `json:"checksum_failures"`
+}
+
+// Stats returns the counters of the node, zero before StartServer
+func Stats() NodeStats {
+	var stats NodeStats
+	stats.Inventory = inventoryStats.snapshot()
+	stats.Bandwidth = bandwidthStats.snapshot()
+	stats.Compact = compactStats.snapshot()
+	stats.ChecksumFailures = atomic.LoadUint64(&checksumFailures)
+	if Manager == nil {
+		return stats
+	}
+	stats.Peers = Manager.Peers.Len()
+	stats.Mempool = Manager.TxMempool.Count()
+	if Manager.Orphans != nil {
+		stats.Orphans = Manager.Orphans.Stats()
+	}
+	if Manager.TxOrphans != nil {
+		stats.TxOrphans = Manager.TxOrphans.Stats()
+	}
+
+	return stats
+}
+
+// statsLoop logs the stats every interval until quit is closed
+func statsLoop(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+		content, err := json.Marshal(Stats())
+		if err != nil {
+			log.Println("node stats:", err)
+			continue
+		}
+		log.Printf("node stats: %s", content)
+	}
+}
@@ -0,0 +1,403 @@
+package p2pprotocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"../blockchain_go"
+)
+
+// invalidParamsError is an argument the swc API refuses, the JSON-RPC
+// invalid params error and the REST bad request
+type invalidParamsError struct {
+	message string
+}
+
+func (e *invalidParamsError) Error() string  { return e.message }
+func (e *invalidParamsError) ErrorCode() int { return -32602 }
+
+// Errors of the swc API for arguments it refuses
+var (
+	errNegativeHeight error = &invalidParamsError{"the height can't be negative"}
+	errInvalidAmount  error = &invalidParamsError{"the amount must be positive"}
+	errSameAddress    error = &invalidParamsError{"the sender and the recipient are the same address"}
+	errNodeNotStarted       = errors.New("the node is not started")
+)
+
+// SWCAPI is the JSON-RPC service of the chain of the node, in the swc
+// namespace: swc_getBalance, swc_getBlockByHash and so on. The hashes are
+// hex encoded, the addresses Base58Check or Bech32 strings
+type SWCAPI struct {
+	nodeID string
+}
+
+// NewSWCAPI returns the service of the chain of nodeID
+func NewSWCAPI(nodeID string) *SWCAPI {
+	return &SWCAPI{nodeID: nodeID}
+}
+
+// RPCBalance is the balance of an address, see core.UTXOSet.GetBalance
+type RPCBalance struct {
+	Address   string `json:"address"`
+	Confirmed int64  `json:"confirmed"`
+	Pending   int64  `json:"pending"`
+}
+
+// RPCBlock is a block, its transactions by ID
+type RPCBlock struct {
+	Hash       string `json:"hash"`
+	PrevHash   string `json:"previousblockhash"`
+	Height     int64  `json:"height"`
+	Time       int64  `json:"time"`
+	Bits       uint32 `json:"bits"`
+	Nonce      int    `json:"nonce"`
+	MerkleRoot string `json:"merkleroot"`
+	// Confirmations is -1 for a block off the main chain
+	Confirmations int64    `json:"confirmations"`
+	Transactions  []string `json:"tx"`
+}
+
+// RPCInput is an input of a transaction, the output it spends
+type RPCInput struct {
+	TxID string `json:"txid"`
+	Vout int    `json:"vout"`
+}
+
+// RPCOutput is an output of a transaction
+type RPCOutput struct {
+	Value   int    `json:"value"`
+	Address string `json:"address"`
+}
+
+// RPCTransaction is a transaction, with the block holding it unless it's
+// in the mempool
+type RPCTransaction struct {
+	TxID          string      `json:"txid"`
+	Coinbase      bool        `json:"coinbase"`
+	Inputs        []RPCInput  `json:"vin"`
+	Outputs       []RPCOutput `json:"vout"`
+	BlockHash     string      `json:"blockhash,omitempty"`
+	Height        int64       `json:"height,omitempty"`
+	Confirmations int64       `json:"confirmations"`
+}
+
+// RPCMempool are the transactions of the mempool by ID
+type RPCMempool struct {
+	Size         int      `json:"size"`
+	Transactions []string `json:"tx"`
+}
+
+// checkedPubKeyHash returns the public key hash of an address, or why the
+// address isn't valid
+func checkedPubKeyHash(address string) ([]byte, error) {
+	if err := core.CheckAddress(address); err != nil {
+		return nil, &invalidParamsError{fmt.Sprintf("invalid address %q: %v", address, err)}
+	}
+
+	return core.GetPubKeyHashFromAddress(address)
+}
+
+// decodeHash decodes a hex encoded hash argument, a SHA-256 one
+func decodeHash(kind, hash string) ([]byte, error) {
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != sha256.Size {
+		return nil, &invalidParamsError{fmt.Sprintf("the %s %q is not a hex encoded hash", kind, hash)}
+	}
+
+	return decoded, nil
+}
+
+func newRPCTransaction(tx *core.Transaction) *RPCTransaction {
+	result := &RPCTransaction{TxID: hex.EncodeToString(tx.ID), Coinbase: tx.IsCoinbase(), Inputs: []RPCInput{}}
+	if !result.Coinbase {
+		for _, in := range tx.Vin {
+			result.Inputs = append(result.Inputs, RPCInput{hex.EncodeToString(in.Txid), in.Vout})
+		}
+	}
+	for _, out := range tx.Vout {
+		result.Outputs = append(result.Outputs, RPCOutput{out.Value, string(core.GetAddressFromPubkeyHash(out.PubKeyHash))})
+	}
+
+	return result
+}
+
+// newRPCBlock returns the block, its confirmations counted from the best
+// height
+func newRPCBlock(bc *core.Blockchain, block *core.Block) (*RPCBlock, error) {
+	best, _, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return nil, err
+	}
+	result := &RPCBlock{
+		Hash:          hex.EncodeToString(block.Hash),
+		PrevHash:      hex.EncodeToString(block.PrevBlockHash),
+		Height:        block.Height.Int64(),
+		Bits:          block.Bits,
+		Nonce:         block.Nonce,
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		Confirmations: -1,
+		Transactions:  []string{},
+	}
+	if block.Timestamp != nil {
+		result.Time = block.Timestamp.Int64()
+	}
+	if main, err := bc.GetBlockByHeight(int(result.Height)); err == nil && bytes.Equal(main.Hash, block.Hash) {
+		result.Confirmations = best.Int64() - result.Height + 1
+	}
+	for _, tx := range block.Transactions {
+		result.Transactions = append(result.Transactions, hex.EncodeToString(tx.ID))
+	}
+
+	return result, nil
+}
+
+// GetBalance returns the balance of an address, the outputs with a
+// confirmation confirmed
+func (api *SWCAPI) GetBalance(address string) (*RPCBalance, error) {
+	pubKeyHash, err := checkedPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+
+	confirmed, pending, err := core.UTXOSet{Blockchain: bc}.GetBalance(pubKeyHash, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RPCBalance{Address: address, Confirmed: confirmed, Pending: pending}, nil
+}
+
+// SendToAddress sends amount from an address of the wallets of the node to
+// another, paying fee per 1000 bytes, the estimate when negative. The
+// transaction goes to the mempool and the peers, rebroadcast until it's
+// confirmed, its ID is returned
+func (api *SWCAPI) SendToAddress(from, to string, amount int, fee int64) (string, error) {
+	if _, err := checkedPubKeyHash(from); err != nil {
+		return "", err
+	}
+	if _, err := checkedPubKeyHash(to); err != nil {
+		return "", err
+	}
+	if core.CanonicalAddress(from) == core.CanonicalAddress(to) {
+		return "", errSameAddress
+	}
+	if amount <= 0 {
+		return "", errInvalidAmount
+	}
+	if Manager == nil || NodeWallets == nil {
+		return "", errNodeNotStarted
+	}
+	wallet, err := NodeWallets.GetWallet(from)
+	if err != nil {
+		return "", err
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return "", err
+	}
+	defer closeChain(bc)
+
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	exclude, err := UTXOSet.PendingOutpoints(core.HashPubKey(wallet.PublicKey))
+	if err != nil {
+		return "", err
+	}
+	for outpoint := range Manager.TxMempool.Reserved() {
+		exclude[outpoint] = struct{}{}
+	}
+	var tx *core.Transaction
+	if fee < 0 {
+		tx, err = core.NewUTXOTransaction(wallet, to, amount, &UTXOSet, exclude, 1)
+	} else {
+		tx, err = core.NewUTXOTransactionFee(wallet, to, amount, &UTXOSet, exclude, 1, fee)
+	}
+	if err != nil {
+		return "", err
+	}
+	// the wallet records the transaction once the mempool took it
+	if err := Manager.TxMempool.Add(tx); err != nil {
+		return "", err
+	}
+	core.PendingIn(*wallet, tx)
+	Manager.TrackWalletTx(tx)
+	Manager.BroadcastTxs(core.Transactions{tx})
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// GetBlockByHeight returns the block of the main chain at height
+func (api *SWCAPI) GetBlockByHeight(height int64) (*RPCBlock, error) {
+	if height < 0 {
+		return nil, errNegativeHeight
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+
+	best, _, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return nil, err
+	}
+	if height > best.Int64() {
+		return nil, core.ErrBlockNotFound
+	}
+	block, err := bc.GetBlockByHeight(int(height))
+	if err != nil {
+		return nil, err
+	}
+
+	return newRPCBlock(bc, block)
+}
+
+// GetBlockByHash returns the block of hash, on the main chain or not
+func (api *SWCAPI) GetBlockByHash(hash string) (*RPCBlock, error) {
+	decoded, err := decodeHash("block hash", hash)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+
+	block, err := bc.GetBlock(decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRPCBlock(bc, &block)
+}
+
+// GetTransaction returns the transaction of txid, from the mempool or the
+// chain
+func (api *SWCAPI) GetTransaction(txid string) (*RPCTransaction, error) {
+	id, err := decodeHash("transaction ID", txid)
+	if err != nil {
+		return nil, err
+	}
+	if Manager != nil {
+		if tx := Manager.TxMempool.Get(hex.EncodeToString(id)); tx != nil {
+			return newRPCTransaction(tx), nil
+		}
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+
+	tx, block, err := bc.FindTransactionBlock(id)
+	if err != nil {
+		return nil, err
+	}
+	best, _, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return nil, err
+	}
+	result := newRPCTransaction(tx)
+	result.BlockHash = hex.EncodeToString(block.Hash)
+	result.Height = block.Height.Int64()
+	result.Confirmations = best.Int64() - result.Height + 1
+
+	return result, nil
+}
+
+// GetTransactionStatus returns whether the transaction txid is in the
+// chain, with its block, height, confirmations and position in the block,
+// pending in the mempool or unknown
+func (api *SWCAPI) GetTransactionStatus(txid string) (*core.TxStatus, error) {
+	id, err := decodeHash("transaction ID", txid)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+
+	return bc.GetTransactionStatus(id)
+}
+
+// GetMempool returns the IDs of the transactions of the mempool
+func (api *SWCAPI) GetMempool() (*RPCMempool, error) {
+	if Manager == nil {
+		return nil, errNodeNotStarted
+	}
+	result := &RPCMempool{Transactions: []string{}}
+	for _, tx := range Manager.TxMempool.Transactions() {
+		result.Transactions = append(result.Transactions, hex.EncodeToString(tx.ID))
+	}
+	result.Size = len(result.Transactions)
+
+	return result, nil
+}
+
+// GetUTXOs returns the unspent outputs of an address with a confirmation,
+// the oldest first
+func (api *SWCAPI) GetUTXOs(address string) ([]core.UnspentOutput, error) {
+	pubKeyHash, err := checkedPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+
+	unspent, err := core.UTXOSet{Blockchain: bc}.ListUnspent(pubKeyHash, 1)
+	if err != nil {
+		return nil, err
+	}
+	if unspent == nil {
+		unspent = []core.UnspentOutput{}
+	}
+
+	return unspent, nil
+}
+
+// GetChainInfo returns the tip of the chain, see core.Blockchain.ChainInfo.
+// The path of the database isn't part of it
+func (api *SWCAPI) GetChainInfo() (*core.ChainInfo, error) {
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+
+	info, err := bc.ChainInfo()
+	if err != nil {
+		return nil, err
+	}
+	info.Path = ""
+
+	return info, nil
+}
+
+// GetBlockCount returns the height of the best block
+func (api *SWCAPI) GetBlockCount() (int64, error) {
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return 0, err
+	}
+	defer closeChain(bc)
+
+	height, _, err := bc.GetBestHeightLastHash()
+	if err != nil {
+		return 0, err
+	}
+
+	return height.Int64(), nil
+}
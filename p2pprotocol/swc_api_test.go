@@ -0,0 +1,135 @@
+package p2pprotocol
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"../blockchain_go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSWCAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager, w *core.Wallets) { Manager, NodeWallets = m, w }(Manager, NodeWallets)
+
+	// a chain of three blocks paying to the wallet of the node
+	wallets, err := core.NewWallets("rpctest")
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	defer wallets.Close()
+	address := wallets.CreateWallet()
+	other := fmt.Sprintf("%s", core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "rpctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.UTXOSet{Blockchain: bc}.Reindex()
+	for i := 0; i < 2; i++ {
+		_, err := bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+		assert.Nil(t, err)
+	}
+	bc.Close()
+	quit := make(chan struct{})
+	defer close(quit)
+	Manager = newTestManager(quit)
+	NodeWallets = wallets
+	// as for a node started again after the tests stopping one
+	openChainsAgain()
+	api := NewSWCAPI("rpctest")
+
+	count, err := api.GetBlockCount()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+	status, err := api.Syncing()
+	assert.Nil(t, err)
+	assert.Equal(t, &SyncStatus{StartingHeight: 2, CurrentHeight: 2, HeadersHeight: 2, HighestKnownHeight: 2}, status)
+
+	// the blocks by height and by hash, hex encoded
+	block, err := api.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1), block.Height)
+	assert.Equal(t, int64(2), block.Confirmations)
+	assert.Equal(t, 1, len(block.Transactions))
+	byHash, err := api.GetBlockByHash(block.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, block, byHash)
+	content, err := json.Marshal(block)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), `"hash":"`+block.Hash+`"`)
+
+	// a coinbase of the chain
+	tx, err := api.GetTransaction(block.Transactions[0])
+	assert.Nil(t, err)
+	assert.True(t, tx.Coinbase)
+	assert.Equal(t, block.Hash, tx.BlockHash)
+	assert.Equal(t, int64(2), tx.Confirmations)
+	assert.Equal(t, address, tx.Outputs[0].Address)
+	txStatus, err := api.GetTransactionStatus(block.Transactions[0])
+	assert.Nil(t, err)
+	assert.Equal(t, &core.TxStatus{TxID: block.Transactions[0], Status: core.TxConfirmed, BlockHash: block.Hash, Height: 1, Confirmations: 2}, txStatus)
+
+	balance, err := api.GetBalance(address)
+	assert.Nil(t, err)
+	assert.True(t, balance.Confirmed > 0)
+
+	// a transaction sent goes to the mempool
+	txid, err := api.SendToAddress(address, other, 1, -1)
+	assert.Nil(t, err)
+	mempool, err := api.GetMempool()
+	assert.Nil(t, err)
+	assert.Equal(t, &RPCMempool{Size: 1, Transactions: []string{txid}}, mempool)
+	tx, err = api.GetTransaction(txid)
+	assert.Nil(t, err)
+	assert.False(t, tx.Coinbase)
+	assert.Equal(t, "", tx.BlockHash)
+	assert.Equal(t, int64(0), tx.Confirmations)
+	assert.Contains(t, tx.Outputs, RPCOutput{1, other})
+	txStatus, err = api.GetTransactionStatus(txid)
+	assert.Nil(t, err)
+	assert.Equal(t, &core.TxStatus{TxID: txid, Status: core.TxPending}, txStatus)
+
+	// bad arguments are refused with an error
+	_, err = api.GetBlockByHeight(-1)
+	assert.Equal(t, errNegativeHeight, err)
+	_, err = api.GetBlockByHeight(100)
+	assert.NotNil(t, err)
+	for _, hash := range []string{"", "zz", hex.EncodeToString([]byte("unknown"))} {
+		_, err = api.GetBlockByHash(hash)
+		assert.NotNil(t, err, hash)
+		_, err = api.GetTransaction(hash)
+		assert.NotNil(t, err, hash)
+		_, err = api.GetTransactionStatus(hash)
+		assert.NotNil(t, err, hash)
+	}
+	txStatus, err = api.GetTransactionStatus(hex.EncodeToString(make([]byte, 32)))
+	assert.Nil(t, err)
+	assert.Equal(t, core.TxUnknown, txStatus.Status)
+	_, err = api.GetBalance("not an address")
+	assert.NotNil(t, err)
+	_, err = api.SendToAddress(address, other, 0, -1)
+	assert.Equal(t, errInvalidAmount, err)
+	_, err = api.SendToAddress(address, address, 1, -1)
+	assert.Equal(t, errSameAddress, err)
+	_, err = api.SendToAddress(address, "not an address", 1, -1)
+	assert.NotNil(t, err)
+	_, err = api.SendToAddress(other, address, 1, -1)
+	assert.NotNil(t, err, "An address not in the wallets")
+	_, err = api.SendToAddress(address, other, 1<<40, 0)
+	assert.NotNil(t, err)
+}
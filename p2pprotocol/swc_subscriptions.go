@@ -0,0 +1,154 @@
+package p2pprotocol
+
+import (
+	"context"
+	"encoding/hex"
+	"sync/atomic"
+
+	"../blockchain_go"
+	"../rpc"
+)
+
+// subscriptionBuffer is the number of notifications a subscription holds
+// for a client reading them slower than they come, the next ones are
+// dropped
+var subscriptionBuffer = 64
+
+// RPCHead is the notification of a block connected to the tip, see
+// SWCAPI.NewHeads
+type RPCHead struct {
+	Hash       string `json:"hash"`
+	PrevHash   string `json:"previousblockhash"`
+	Height     int64  `json:"height"`
+	Time       int64  `json:"time"`
+	Bits       uint32 `json:"bits"`
+	Nonce      int    `json:"nonce"`
+	MerkleRoot string `json:"merkleroot"`
+	// Dropped counts the notifications dropped since the last one sent, the
+	// client falling behind
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// RPCPendingTx is the notification of a transaction added to the mempool,
+// see SWCAPI.NewPendingTransactions
+type RPCPendingTx struct {
+	TxID    string `json:"txid"`
+	Dropped int    `json:"dropped,omitempty"`
+}
+
+func (n *RPCHead) setDropped(dropped int)      { n.Dropped = dropped }
+func (n *RPCPendingTx) setDropped(dropped int) { n.Dropped = dropped }
+
+// notification is a notification flagging the ones dropped before it
+type notification interface {
+	setDropped(dropped int)
+}
+
+func newRPCHead(block *core.Block) *RPCHead {
+	head := &RPCHead{
+		Hash:       hex.EncodeToString(block.Hash),
+		PrevHash:   hex.EncodeToString(block.PrevBlockHash),
+		Height:     block.Height.Int64(),
+		Bits:       block.Bits,
+		Nonce:      block.Nonce,
+		MerkleRoot: hex.EncodeToString(block.MerkleRoot),
+	}
+	if block.Timestamp != nil {
+		head.Time = block.Timestamp.Int64()
+	}
+
+	return head
+}
+
+// notificationQueue holds the notifications of a subscription until they
+// are written to its connection. It never blocks the events it's fed: past
+// subscriptionBuffer the notifications are dropped and counted, the count
+// flagged on the next one written
+type notificationQueue struct {
+	pending chan notification
+	dropped int32
+}
+
+func newNotificationQueue() *notificationQueue {
+	return &notificationQueue{pending: make(chan notification, subscriptionBuffer)}
+}
+
+func (q *notificationQueue) push(n notification) {
+	select {
+	case q.pending <- n:
+	default:
+		atomic.AddInt32(&q.dropped, 1)
+	}
+}
+
+// serve writes the notifications to the client of sub until it unsubscribes
+// or its connection is closed, release is called then
+func (q *notificationQueue) serve(notifier *rpc.Notifier, sub *rpc.Subscription, release func()) {
+	defer release()
+	for {
+		select {
+		case n := <-q.pending:
+			n.setDropped(int(atomic.SwapInt32(&q.dropped, 0)))
+			if err := notifier.Notify(sub.ID, n); err != nil {
+				return
+			}
+		case <-sub.Err():
+			return
+		case <-notifier.Closed():
+			return
+		}
+	}
+}
+
+// NewHeads subscribes the client to the blocks connected to the tip of the
+// chain, swc_subscribe("newHeads"). It's served over WebSocket only
+func (api *SWCAPI) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make(chan *core.Block, chainEventBuffer)
+	bc.SubscribeBlockConnected(blocks)
+	closeChain(bc)
+
+	sub := notifier.CreateSubscription()
+	queue := newNotificationQueue()
+	go func() {
+		for block := range blocks {
+			queue.push(newRPCHead(block))
+		}
+	}()
+	// a closed channel is unsubscribed at the next event
+	go queue.serve(notifier, sub, func() { close(blocks) })
+
+	return sub, nil
+}
+
+// NewPendingTransactions subscribes the client to the IDs of the
+// transactions added to the mempool, swc_subscribe("newPendingTransactions")
+func (api *SWCAPI) NewPendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	if Manager == nil {
+		return nil, errNodeNotStarted
+	}
+	txs := make(chan *core.Transaction, chainEventBuffer)
+	Manager.TxMempool.SubscribeNewPendingTx(txs)
+
+	sub := notifier.CreateSubscription()
+	queue := newNotificationQueue()
+	go func() {
+		for tx := range txs {
+			queue.push(&RPCPendingTx{TxID: hex.EncodeToString(tx.ID)})
+		}
+	}()
+	go queue.serve(notifier, sub, func() { close(txs) })
+
+	return sub, nil
+}
@@ -0,0 +1,142 @@
+package p2pprotocol
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSWCSubscriptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager, w *core.Wallets) { Manager, NodeWallets = m, w }(Manager, NodeWallets)
+	defer func(params *core.NetParams) { core.ActiveNetParams = params }(core.ActiveNetParams)
+	core.ActiveNetParams = &core.RegTestParams
+
+	// a regtest node whose wallet owns the coinbase of its genesis block
+	wallets, err := core.NewWallets("wstest")
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	defer wallets.Close()
+	address := wallets.CreateWallet()
+	other := fmt.Sprintf("%s", core.NewWallet().GetAddress())
+	bc, err := core.CreateBlockchain(address, "wstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.UTXOSet{Blockchain: bc}.Reindex()
+	bc.Close()
+	quit := make(chan struct{})
+	defer close(quit)
+	Manager = newTestManager(quit)
+	NodeWallets = wallets
+	openChainsAgain()
+
+	listener, server, err := startWS("wstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	defer listener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := rpc.DialWebsocket(ctx, "ws://"+listener.Addr().String(), "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	heads := make(chan *RPCHead, 1)
+	headsSub, err := client.Subscribe(ctx, "swc", heads, "newHeads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := make(chan *RPCPendingTx, 1)
+	txsSub, err := client.Subscribe(ctx, "swc", txs, "newPendingTransactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a block mined is notified with its hash and height
+	bc, err = openChain("wstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+	closeChain(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case head := <-heads:
+		assert.Equal(t, hex.EncodeToString(block.Hash), head.Hash)
+		assert.Equal(t, int64(1), head.Height)
+		assert.Equal(t, hex.EncodeToString(block.PrevBlockHash), head.PrevHash)
+		assert.Equal(t, 0, head.Dropped)
+	case err := <-headsSub.Err():
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("the block wasn't notified")
+	}
+
+	// so is a transaction added to the mempool, by its ID
+	txid, err := NewSWCAPI("wstest").SendToAddress(address, other, 1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case tx := <-txs:
+		assert.Equal(t, txid, tx.TxID)
+	case err := <-txsSub.Err():
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("the transaction wasn't notified")
+	}
+
+	// subscriptions are over WebSocket only
+	httpListener, httpServer, err := startRPC("wstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpServer.Stop()
+	defer httpListener.Close()
+	httpClient, err := rpc.DialContext(ctx, "http://"+httpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpClient.Close()
+	_, err = httpClient.Subscribe(ctx, "swc", heads, "newHeads")
+	assert.Equal(t, rpc.ErrNotificationsUnsupported, err)
+}
+
+func TestNotificationQueue(t *testing.T) {
+	defer func(buffer int) { subscriptionBuffer = buffer }(subscriptionBuffer)
+	subscriptionBuffer = 2
+
+	// the notifications past the buffer are dropped, never blocking
+	q := newNotificationQueue()
+	for i := 0; i < 5; i++ {
+		q.push(&RPCPendingTx{TxID: fmt.Sprint(i)})
+	}
+	assert.Equal(t, 2, len(q.pending))
+	assert.Equal(t, int32(3), q.dropped)
+	assert.Equal(t, "0", (<-q.pending).(*RPCPendingTx).TxID)
+	assert.Equal(t, "1", (<-q.pending).(*RPCPendingTx).TxID)
+}
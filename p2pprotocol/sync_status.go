@@ -0,0 +1,144 @@
+package p2pprotocol
+
+import (
+	"sync"
+	"time"
+
+	"../blockchain_go"
+)
+
+// syncLag is how far below the best height of the peers the node may be
+// without syncing: the blocks just mined are relayed to it, and a synced
+// node doesn't flap in and out of syncing at each of them
+const syncLag = 2
+
+// SyncStatus tells whether the node is catching up with its peers. While it
+// is, the heights are those of the sync; once synced they're all the
+// height of the node, whatever the peers just mined
+type SyncStatus struct {
+	Syncing            bool  `json:"syncing"`
+	StartingHeight     int64 `json:"starting_height"` // the height the sync started at
+	CurrentHeight      int64 `json:"current_height"`
+	HeadersHeight      int64 `json:"headers_height"`       // the height of the last block of the download, the current one without
+	HighestKnownHeight int64 `json:"highest_known_height"` // the best height the peers reported
+	PeersUsed          int   `json:"peers_used"`           // the peers the blocks are downloaded from
+	// EstimatedTimeRemaining is in seconds at the rate of the sync so far,
+	// 0 until a block is synced
+	EstimatedTimeRemaining int64 `json:"estimated_time_remaining"`
+}
+
+// syncTracker follows the syncs of the node, started when a peer reports a
+// chain more than syncLag blocks higher, done once the node reaches the best
+// height of its peers
+type syncTracker struct {
+	mu       sync.Mutex
+	syncing  bool
+	starting int64
+	started  time.Time
+}
+
+// update starts or ends the sync at now, the node at current and the best
+// height of its peers highest. It returns whether it's syncing, and the
+// height and the time the sync started at
+func (s *syncTracker) update(current, highest int64, now time.Time) (bool, int64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syncing && current >= highest {
+		s.syncing = false
+	}
+	if !s.syncing && highest > current+syncLag {
+		s.syncing = true
+		s.starting = current
+		s.started = now
+	}
+
+	return s.syncing, s.starting, s.started
+}
+
+// SyncStatus returns the sync status of the node, its chain bc
+func (pm *ProtocolManager) SyncStatus(bc *core.Blockchain) (SyncStatus, error) {
+	height, _, err := bc.GetBestHeight()
+	if err != nil {
+		return SyncStatus{}, err
+	}
+
+	return pm.syncStatus(height.Int64(), time.Now()), nil
+}
+
+// syncStatus returns the sync status at now of the node at height current
+func (pm *ProtocolManager) syncStatus(current int64, now time.Time) SyncStatus {
+	highest, ahead := current, 0
+	for _, p := range pm.Peers.List() {
+		p.lock.RLock()
+		if p.Td != nil && p.Td.Int64() > current {
+			ahead++
+			if p.Td.Int64() > highest {
+				highest = p.Td.Int64()
+			}
+		}
+		p.lock.RUnlock()
+	}
+	headers, downloading := current, 0
+	if pm.download != nil {
+		pm.download.mu.Lock()
+		if len(pm.download.wanted) > 0 {
+			headers = pm.download.base + int64(len(pm.download.wanted))
+			downloading = len(pm.download.peers)
+		}
+		pm.download.mu.Unlock()
+	}
+	// the peers of a download have the blocks announced
+	if headers > highest {
+		highest = headers
+	}
+	syncing, starting, started := pm.syncState.update(current, highest, now)
+	if !syncing {
+		return SyncStatus{StartingHeight: current, CurrentHeight: current, HeadersHeight: current, HighestKnownHeight: current}
+	}
+
+	status := SyncStatus{
+		Syncing:            true,
+		StartingHeight:     starting,
+		CurrentHeight:      current,
+		HeadersHeight:      headers,
+		HighestKnownHeight: highest,
+		PeersUsed:          ahead,
+	}
+	if downloading > 0 {
+		status.PeersUsed = downloading
+	}
+	if synced, elapsed := current-starting, now.Sub(started).Seconds(); synced > 0 && elapsed > 0 {
+		status.EstimatedTimeRemaining = int64(float64(highest-current) * elapsed / float64(synced))
+	}
+
+	return status
+}
+
+// SyncStatus returns the sync status of the node
+func (s *Server) SyncStatus() (SyncStatus, error) {
+	bc, err := openChain(s.nodeID)
+	if err != nil {
+		return SyncStatus{}, err
+	}
+	defer closeChain(bc)
+
+	return Manager.SyncStatus(bc)
+}
+
+// Syncing returns the sync status of the node, see SyncStatus
+func (api *SWCAPI) Syncing() (*SyncStatus, error) {
+	if Manager == nil {
+		return nil, errNodeNotStarted
+	}
+	bc, err := openChain(api.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeChain(bc)
+	status, err := Manager.SyncStatus(bc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
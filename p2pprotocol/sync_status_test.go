@@ -0,0 +1,58 @@
+package p2pprotocol
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncStatus(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	pm := newTestManager(quit)
+	pm.download = newBlockDownload()
+	pm.download.request = func(p *Peer, hash []byte) {}
+	peer := func(id byte, height int64) *Peer {
+		rw, _ := p2p.MsgPipe()
+		p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{id}, "", nil), rw)
+		p.Td = big.NewInt(height)
+		assert.Nil(t, pm.Peers.Register(p))
+		return p
+	}
+	synced := func(height int64) SyncStatus {
+		return SyncStatus{StartingHeight: height, CurrentHeight: height, HeadersHeight: height, HighestKnownHeight: height}
+	}
+	start := time.Now()
+
+	// a peer that just mined a block or two isn't synced from
+	assert.Equal(t, synced(10), pm.syncStatus(10, start))
+	peer(1, 12)
+	assert.Equal(t, synced(10), pm.syncStatus(10, start))
+
+	// a taller chain starts a sync, whose highest height follows the peers
+	// connecting and the blocks downloading
+	p := peer(2, 100)
+	assert.Equal(t, SyncStatus{Syncing: true, StartingHeight: 10, CurrentHeight: 10, HeadersHeight: 10, HighestKnownHeight: 100, PeersUsed: 2}, pm.syncStatus(10, start))
+	peer(3, 150)
+	wanted := make([][]byte, 120)
+	for i := range wanted {
+		wanted[i] = []byte{byte(i)}
+	}
+	pm.download.start(p, 10, wanted)
+	pm.download.schedule([]*Peer{p})
+	status := pm.syncStatus(20, start.Add(10*time.Second))
+	assert.Equal(t, SyncStatus{Syncing: true, StartingHeight: 10, CurrentHeight: 20, HeadersHeight: 130, HighestKnownHeight: 150, PeersUsed: 1, EstimatedTimeRemaining: 130}, status)
+
+	// the sync ends at the best height, the blocks mined after don't start
+	// another
+	pm.download.mu.Lock()
+	pm.download.reset()
+	pm.download.mu.Unlock()
+	assert.Equal(t, synced(150), pm.syncStatus(150, start.Add(time.Minute)))
+	peer(4, 152)
+	assert.Equal(t, synced(150), pm.syncStatus(150, start.Add(2*time.Minute)))
+}
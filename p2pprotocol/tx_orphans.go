@@ -0,0 +1,115 @@
+package p2pprotocol
+
+import (
+	"encoding/hex"
+	"log"
+	"os"
+
+	"../blockchain_go"
+)
+
+// missingParents returns the IDs of the transactions whose outputs tx
+// spends the chain of bc doesn't know, once each. A parent fully spent in
+// the chain looks missing as well, such an orphan ages out of the pool
+func missingParents(tx *core.Transaction, bc *core.Blockchain) ([][]byte, error) {
+	var parents [][]byte
+	seen := make(map[string]bool)
+	report, err := core.UTXOSet{Blockchain: bc}.CheckUTXOAmount(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range report.Inputs {
+		if in.Err != core.ErrUnknownOutpoint || seen[hex.EncodeToString(in.Txid)] {
+			continue
+		}
+		seen[hex.EncodeToString(in.Txid)] = true
+		parents = append(parents, in.Txid)
+	}
+
+	return parents, nil
+}
+
+// holdOrphanTx holds tx, sent by p, in the orphan pool until its parents
+// are accepted, and asks p for those neither in the mempool, held or asked
+// for already. An orphan isn't relayed. It returns false if the pool
+// refused tx or its parents couldn't be looked up
+func holdOrphanTx(p *Peer, tx *core.Transaction, bc *core.Blockchain) bool {
+	parents, err := missingParents(tx, bc)
+	if err != nil {
+		log.Printf("dropping orphan transaction %x of peer %s: %v", tx.ID, p.id, err)
+		return false
+	}
+	if err := Manager.TxOrphans.Add(tx, parents, p.id); err != nil {
+		log.Printf("dropping orphan transaction %x of peer %s: %v", tx.ID, p.id, err)
+		return false
+	}
+	for _, parent := range parents {
+		if Manager.TxMempool.Get(hex.EncodeToString(parent)) != nil || Manager.TxOrphans.Has(parent) || !requested.add(parent) {
+			continue
+		}
+		sendGetData(p.Rw, "tx", parent)
+	}
+
+	return true
+}
+
+// acceptOrphans checks the orphans of parents again, the transactions just
+// accepted into the mempool or a block. Those valid now are accepted and
+// relayed in turn, then their own orphans checked, those still missing a
+// parent stay held. The verification reads the chain, so an orphan of a
+// transaction of the mempool waits for its parent to be mined
+func acceptOrphans(parents [][]byte, bc *core.Blockchain) {
+	if Manager.TxOrphans == nil {
+		return
+	}
+	queue := parents
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, orphan := range Manager.TxOrphans.Children(parent) {
+			if Manager.TxMempool.Get(hex.EncodeToString(orphan.ID)) != nil {
+				Manager.TxOrphans.Remove(orphan.ID)
+				continue
+			}
+			if err := core.VerifyTx(*orphan, bc); err != nil {
+				reason := txRejectReason(orphan, bc)
+				if reason == rejectOrphan {
+					continue
+				}
+				log.Printf("dropping orphan transaction %x: %v", orphan.ID, err)
+				Manager.TxOrphans.Remove(orphan.ID)
+				txRejected.inc(reason)
+				continue
+			}
+			if err := Manager.TxMempool.Add(orphan); err != nil {
+				log.Printf("dropping orphan transaction %x: %v", orphan.ID, err)
+				Manager.TxOrphans.Remove(orphan.ID)
+				txRejected.inc(rejectConflict)
+				continue
+			}
+			log.Printf("accepted orphan transaction %x, its parent %x arrived", orphan.ID, parent)
+			Manager.TxOrphans.Accept(orphan.ID)
+			Manager.BroadcastTxs(core.Transactions{orphan})
+			queue = append(queue, orphan.ID)
+		}
+	}
+}
+
+// orphansConnected checks the orphans of the transactions of block again,
+// connected to the tip
+func orphansConnected(block *core.Block) {
+	if Manager.TxOrphans == nil || Manager.TxOrphans.Stats().Held == 0 {
+		return
+	}
+	bc, err := openChain(os.Getenv("NODE_ID"))
+	if err != nil {
+		log.Println("checking the orphan transactions:", err)
+		return
+	}
+	defer closeChain(bc)
+	parents := make([][]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		parents[i] = tx.ID
+	}
+	acceptOrphans(parents, bc)
+}
@@ -0,0 +1,111 @@
+package p2pprotocol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphanTxs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer func(m *ProtocolManager) { Manager = m }(Manager)
+
+	// parent pays child's key, child spends that output
+	wallet := core.NewWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	payee := core.NewWallet()
+	bc, err := core.CreateBlockchain(address, "orphantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	UTXOSet := core.UTXOSet{Blockchain: bc}
+	UTXOSet.Reindex()
+	_, err = bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, "")})
+	assert.Nil(t, err)
+	parent, err := core.NewUTXOTransaction(wallet, fmt.Sprintf("%s", payee.GetAddress()), 1, &UTXOSet, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &core.Transaction{Vout: []core.TXOutput{*core.NewTXOutput(1, fmt.Sprintf("%s", core.NewWallet().GetAddress()))}}
+	for i, out := range parent.Vout {
+		if out.IsLockedWithKey(core.HashPubKey(payee.PublicKey)) {
+			child.Vin = append(child.Vin, core.TXInput{Txid: parent.ID, Vout: i, PubKey: payee.PublicKey})
+		}
+	}
+	child.ID = child.Hash()
+	signer, err := payee.Signer()
+	assert.Nil(t, err)
+	assert.Nil(t, child.Sign(signer, map[string]core.Transaction{hex.EncodeToString(parent.ID): *parent}))
+
+	// node b gets child from a, which holds parent, and relays to c
+	quit := make(chan struct{})
+	defer close(quit)
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	a := testNode{pm: newTestManager(quit, parent), received: readCommands(rwA)}
+	a.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rwA)
+	b := testNode{pm: newTestManager(quit), received: readCommands(rwB)}
+	b.peer = newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{1}, "a", nil), rwB)
+	rwC, remoteC := p2p.MsgPipe()
+	defer rwC.Close()
+	received := readCommands(remoteC)
+	assert.Nil(t, b.pm.Peers.Register(newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{3}, "c", nil), rwC)))
+
+	// child arrives first, it's held and its parent asked for
+	assert.Nil(t, sendTx(a.peer, child))
+	b.handle(1, bc)
+	assert.True(t, b.pm.TxOrphans.Has(child.ID))
+	assert.Equal(t, 0, b.pm.TxMempool.Count())
+	assert.Equal(t, 0, b.peer.banScore)
+	var getData getdata
+	assert.Nil(t, gobDecode(expectCommand(t, a.received, "getdata").Data, &getData))
+	assert.Equal(t, parent.ID, getData.ID)
+
+	// the parent is accepted and relayed, the orphan waits for it to be
+	// mined and isn't relayed meanwhile
+	Manager = a.pm
+	handleGetData(a.peer, Command{"getdata", gobEncode(getData)}, bc)
+	b.handle(1, bc)
+	assert.NotNil(t, b.pm.TxMempool.Get(hex.EncodeToString(parent.ID)))
+	var announced inv
+	assert.Nil(t, gobDecode(expectCommand(t, received, "inv").Data, &announced))
+	assert.Equal(t, [][]byte{parent.ID}, announced.Items)
+	assert.True(t, b.pm.TxOrphans.Has(child.ID))
+	assert.Empty(t, received)
+
+	// once mined, the orphan is accepted and relayed
+	block, err := bc.MineBlock([]*core.Transaction{core.NewCoinbaseTX(address, ""), parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.pm.TxMempool.RemoveBlock(block)
+	Manager = b.pm
+	acceptOrphans([][]byte{parent.ID}, bc)
+	assert.NotNil(t, b.pm.TxMempool.Get(hex.EncodeToString(child.ID)))
+	assert.Equal(t, core.TxOrphanStats{Accepted: 1}, b.pm.TxOrphans.Stats())
+	assert.Nil(t, gobDecode(expectCommand(t, received, "inv").Data, &announced))
+	assert.Equal(t, [][]byte{child.ID}, announced.Items)
+
+	// an orphan is held for the peer that sent it, dropped once it's gone
+	orphan := &core.Transaction{Vin: []core.TXInput{{Txid: make([]byte, 32)}}, Vout: child.Vout}
+	orphan.ID = orphan.Hash()
+	assert.True(t, holdOrphanTx(b.peer, orphan, bc))
+	assert.Equal(t, 1, b.pm.TxOrphans.RemoveFrom(b.peer.id))
+}
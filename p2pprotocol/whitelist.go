@@ -0,0 +1,267 @@
+package p2pprotocol
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"../blockchain_go"
+	"../p2p/discover"
+)
+
+// Whitelist are the peers of -whitelist, added to the whitelist of the node
+// when it starts: IP addresses, node IDs in hex or enode URLs
+var Whitelist []string
+
+// WhitelistOnly connects to the whitelisted peers only, -connect for a
+// private network: no discovery, no address dialed but theirs, the other
+// peers refused
+var WhitelistOnly bool
+
+var (
+	errNotWhitelisted = errors.New("the peer isn't whitelisted, only whitelisted peers are connected")
+	errWhitelisted    = errors.New("the peer is already whitelisted")
+	errNotInWhitelist = errors.New("the peer isn't in the whitelist")
+)
+
+// WhitelistKey returns what a whitelist entry of target is recorded under,
+// target being an IP address, a node ID in hex or an enode URL, with the
+// node of the latter, or why it is none of them
+func WhitelistKey(target string) (string, *discover.Node, error) {
+	if strings.Contains(target, "@") {
+		node, err := parseNode(target)
+		if err != nil {
+			return "", nil, err
+		}
+		return node.String(), node, nil
+	}
+	key, err := BanKey(target)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s is neither an IP address, a node ID nor an enode URL", target)
+	}
+
+	return key, nil, nil
+}
+
+// peerWhitelist holds the whitelist of the node by what its entries match,
+// the IP address or the node ID of a peer
+type peerWhitelist struct {
+	mu    sync.RWMutex
+	ips   map[string]bool
+	ids   map[discover.NodeID]bool
+	nodes map[discover.NodeID]*discover.Node // those of the enode URLs, kept connected
+}
+
+var whitelist = &peerWhitelist{}
+
+// set replaces the entries of the whitelist, those malformed are skipped
+func (w *peerWhitelist) set(entries []core.WhitelistedPeer) {
+	ips := make(map[string]bool)
+	ids := make(map[discover.NodeID]bool)
+	nodes := make(map[discover.NodeID]*discover.Node)
+	for _, entry := range entries {
+		key, node, err := WhitelistKey(entry.Address)
+		switch {
+		case err != nil:
+			log.Printf("skipping the whitelist entry %s: %v", entry.Address, err)
+		case node != nil:
+			ids[node.ID] = true
+			nodes[node.ID] = node
+		case net.ParseIP(key) != nil:
+			ips[key] = true
+		default:
+			id, _ := discover.HexID(key)
+			ids[id] = true
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ips, w.ids, w.nodes = ips, ids, nodes
+}
+
+// has returns whether the IP address or the node ID of the peer is
+// whitelisted
+func (w *peerWhitelist) has(p *Peer) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if len(w.ips) == 0 && len(w.ids) == 0 {
+		return false
+	}
+	if p.Peer != nil && w.ids[p.ID()] {
+		return true
+	}
+
+	return w.ips[peerAddress(p)]
+}
+
+// hasID returns whether the node ID is whitelisted, by itself or with an
+// enode URL
+func (w *peerWhitelist) hasID(id discover.NodeID) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.ids[id]
+}
+
+// trusted returns the nodes of the node IDs whitelisted, the p2p server
+// keeps them a slot, and those to keep connected
+func (w *peerWhitelist) trusted() (ids, dial []*discover.Node) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for id := range w.ids {
+		ids = append(ids, &discover.Node{ID: id})
+	}
+	for _, node := range w.nodes {
+		dial = append(dial, node)
+	}
+
+	return ids, dial
+}
+
+// whitelisted returns whether the peer is whitelisted, exempt from the ban
+// scores, the bans and the rate limits
+func (p *Peer) whitelisted() bool {
+	return whitelist.has(p)
+}
+
+// checkWhitelistOnly returns errNotWhitelisted for a peer not whitelisted
+// under WhitelistOnly
+func checkWhitelistOnly(p *Peer) error {
+	if WhitelistOnly && !p.whitelisted() {
+		log.Printf("peer %s isn't whitelisted, refused", p.id)
+		return errNotWhitelisted
+	}
+
+	return nil
+}
+
+// loadWhitelist adds the entries of Whitelist to the whitelist of the node
+// nodeID, then reads it
+func loadWhitelist(nodeID string) error {
+	for _, target := range Whitelist {
+		key, _, err := WhitelistKey(target)
+		if err != nil {
+			return err
+		}
+		if _, err := core.WhitelistPeer(nodeID, key); err != nil {
+			return err
+		}
+	}
+	entries, err := core.ListWhitelisted(nodeID)
+	if err != nil {
+		return err
+	}
+	whitelist.set(entries)
+
+	return nil
+}
+
+// AddWhitelist whitelists target, an IP address, a node ID or an enode URL
+// whose node is kept connected, in the whitelist of the node nodeID
+func (pm *ProtocolManager) AddWhitelist(nodeID, target string) error {
+	key, node, err := WhitelistKey(target)
+	if err != nil {
+		return err
+	}
+	added, err := core.WhitelistPeer(nodeID, key)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return errWhitelisted
+	}
+	if err := loadWhitelist(nodeID); err != nil {
+		return err
+	}
+	if id, err := discover.HexID(key); err == nil {
+		node = &discover.Node{ID: id}
+	}
+	if node == nil {
+		return nil
+	}
+
+	pm.dialLock.Lock()
+	defer pm.dialLock.Unlock()
+	if pm.dialer == nil {
+		return nil
+	}
+	pm.dialer.AddTrustedPeer(node)
+	if !node.Incomplete() {
+		// no longer given up by connectOutbound
+		delete(pm.dials, node.ID)
+		pm.dialer.AddPeer(node)
+	}
+
+	return nil
+}
+
+// RemoveWhitelist removes target from the whitelist of the node nodeID,
+// the peers connected stay but are scored and limited again. The node of an
+// enode URL is disconnected and no longer kept connected, unless added by
+// AddNode
+func (pm *ProtocolManager) RemoveWhitelist(nodeID, target string) error {
+	key, node, err := WhitelistKey(target)
+	if err != nil {
+		return err
+	}
+	removed, err := core.UnwhitelistPeer(nodeID, key)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return errNotInWhitelist
+	}
+	if err := loadWhitelist(nodeID); err != nil {
+		return err
+	}
+	if id, err := discover.HexID(key); err == nil {
+		node = &discover.Node{ID: id}
+	}
+	// another entry may whitelist the same node
+	if node == nil || whitelist.hasID(node.ID) {
+		return nil
+	}
+
+	pm.dialLock.Lock()
+	defer pm.dialLock.Unlock()
+	if pm.dialer == nil {
+		return nil
+	}
+	pm.dialer.RemoveTrustedPeer(node)
+	if _, added := pm.added[node.ID]; !added && !node.Incomplete() {
+		pm.dialer.RemovePeer(node)
+	}
+
+	return nil
+}
+
+// AddWhitelist whitelists target, an IP address, a node ID or an enode URL
+// enode://NODEID@IP:PORT kept connected. A whitelisted peer has a slot kept,
+// by its node ID, isn't banned and isn't rate limited
+func (api *AdminAPI) AddWhitelist(target string) error {
+	if Manager == nil {
+		return errNotRunning
+	}
+	return Manager.AddWhitelist(os.Getenv("NODE_ID"), target)
+}
+
+// RemoveWhitelist removes target from the whitelist
+func (api *AdminAPI) RemoveWhitelist(target string) error {
+	if Manager == nil {
+		return errNotRunning
+	}
+	return Manager.RemoveWhitelist(os.Getenv("NODE_ID"), target)
+}
+
+// ListWhitelist returns the whitelist of the node
+func (api *AdminAPI) ListWhitelist() ([]core.WhitelistedPeer, error) {
+	entries, err := core.ListWhitelisted(os.Getenv("NODE_ID"))
+	if entries == nil {
+		entries = []core.WhitelistedPeer{}
+	}
+	return entries, err
+}
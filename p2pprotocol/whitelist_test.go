@@ -0,0 +1,108 @@
+package p2pprotocol
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"../blockchain_go"
+	"../p2p"
+	"../p2p/discover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhitelist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2pprotocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer os.Setenv("NODE_ID", os.Getenv("NODE_ID"))
+	os.Setenv("NODE_ID", "wltest")
+	defer func(l *banList, w *peerWhitelist) { bans, whitelist = l, w }(bans, whitelist)
+	bans, whitelist = &banList{}, &peerWhitelist{}
+	defer func(only bool) { WhitelistOnly = only }(WhitelistOnly)
+
+	quit := make(chan struct{})
+	defer close(quit)
+	pm := newTestManager(quit)
+	dialer := &testDialer{}
+	pm.dialer = dialer
+	pm.Addrs = newAddrManager("", netAddress{ID: discover.NodeID{1}, Addr: "10.1.0.1:2000"})
+	rw, remote := p2p.MsgPipe()
+	defer remote.Close()
+	p := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{2}, "b", nil), rw)
+	other := newPeer(nodeVersion, p2p.NewPeer(discover.NodeID{4}, "d", nil), rw)
+
+	for _, malformed := range []string{"", "host", discover.NodeID{3}.String() + "@host:2000"} {
+		assert.NotNil(t, pm.AddWhitelist("wltest", malformed), malformed)
+	}
+	assert.Equal(t, errNotInWhitelist, pm.RemoveWhitelist("wltest", "10.9.0.1"))
+
+	// a peer whitelisted by its node ID is kept a slot, neither scored, banned
+	// nor rate limited
+	assert.Nil(t, pm.AddWhitelist("wltest", p.ID().String()))
+	assert.Equal(t, errWhitelisted, pm.AddWhitelist("wltest", p.ID().String()))
+	assert.True(t, p.whitelisted())
+	assert.False(t, other.whitelisted())
+	if assert.Equal(t, 1, len(dialer.trusted)) {
+		assert.Equal(t, p.ID(), dialer.trusted[0].ID)
+	}
+	assert.Empty(t, dialer.dialed)
+	p.Misbehaving(BanThreshold, "invalid block")
+	assert.Equal(t, 0, p.info().BanScore)
+	assert.True(t, p.info().Whitelisted)
+	ban := core.BannedPeer{Address: peerKey(p), Until: time.Now().Add(time.Hour), Reason: "setban"}
+	assert.Nil(t, core.BanPeer("wltest", ban))
+	assert.Nil(t, checkBanned(p))
+	for i := 0; i < 10*rateBurst*int(LowRateLimit.Messages); i++ {
+		assert.True(t, p.meter.admitIn(Command{Command: "addr"}))
+	}
+
+	// the node of an enode URL is kept connected, not dialed by
+	// connectOutbound again nor given up
+	url := "enode://" + discover.NodeID{3}.String() + "@10.3.0.1:2000"
+	assert.Nil(t, pm.AddWhitelist("wltest", url))
+	if assert.Equal(t, 1, len(dialer.dialed)) {
+		assert.Equal(t, discover.NodeID{3}, dialer.dialed[0].ID)
+	}
+	pm.connectOutbound()
+	assert.Equal(t, discover.NodeID{3}, dialer.dialed[0].ID)
+	for _, node := range dialer.dialed[1:] {
+		assert.NotEqual(t, discover.NodeID{3}, node.ID)
+	}
+	assert.Empty(t, dialer.removed)
+
+	// a private network refuses the peers not whitelisted
+	WhitelistOnly = true
+	assert.Nil(t, checkWhitelistOnly(p))
+	assert.Equal(t, errNotWhitelisted, checkWhitelistOnly(other))
+	WhitelistOnly = false
+	assert.Nil(t, checkWhitelistOnly(other))
+
+	// the whitelist outlives the node
+	whitelist = &peerWhitelist{}
+	assert.False(t, p.whitelisted())
+	assert.Nil(t, loadWhitelist("wltest"))
+	assert.True(t, p.whitelisted())
+	entries, err := core.ListWhitelisted("wltest")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	// once removed, the peer is scored and banned again
+	assert.Nil(t, pm.RemoveWhitelist("wltest", p.ID().String()))
+	assert.False(t, p.whitelisted())
+	assert.Equal(t, errBanned, checkBanned(p))
+	p.Misbehaving(scoreMalformed, "malformed inv message")
+	assert.Equal(t, scoreMalformed, p.info().BanScore)
+	assert.Nil(t, pm.RemoveWhitelist("wltest", url))
+	if assert.Equal(t, 2, len(dialer.untrusted)) && assert.Equal(t, 1, len(dialer.removed)) {
+		assert.Equal(t, discover.NodeID{3}, dialer.removed[0].ID)
+	}
+}